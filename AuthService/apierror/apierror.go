@@ -0,0 +1,62 @@
+// Package apierror writes the error body every AuthService endpoint returns:
+//
+//	{"error": {"code": "INVALID_CREDENTIALS", "message": "..."}}
+//
+// Codes are stable so clients can switch on them; messages are for humans and
+// may change.
+package apierror
+
+import (
+	"auth-service/types"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	CodeInvalidRequest     = "INVALID_REQUEST"
+	CodeValidationFailed   = "VALIDATION_FAILED"
+	CodeEmailTaken         = "EMAIL_TAKEN"
+	CodeUsernameTaken      = "USERNAME_TAKEN"
+	CodeUserNotFound       = "USER_NOT_FOUND"
+	CodeInvalidCredentials = "INVALID_CREDENTIALS"
+	CodeEmailNotVerified   = "EMAIL_NOT_VERIFIED"
+	CodePasswordChanged    = "PASSWORD_CHANGED"
+	CodeInvalidLink        = "INVALID_VERIFICATION_LINK"
+	CodeTokenMissing       = "TOKEN_MISSING"
+	CodeTokenMalformed     = "TOKEN_MALFORMED"
+	CodeTokenExpired       = "TOKEN_EXPIRED"
+	CodeTokenInvalid       = "TOKEN_INVALID"
+	CodeTokenRevoked       = "TOKEN_REVOKED"
+	CodeRefreshInvalid     = "INVALID_REFRESH_TOKEN"
+	CodeSessionNotFound    = "SESSION_NOT_FOUND"
+	CodeInvalidOAuthState  = "INVALID_OAUTH_STATE"
+	CodeOAuthFailed        = "OAUTH_FAILED"
+	CodeAccountExists      = "ACCOUNT_EXISTS"
+	CodeSignatureInvalid   = "INVALID_SIGNATURE"
+	CodeRateLimited        = "RATE_LIMITED"
+	CodeInternal           = "INTERNAL_ERROR"
+)
+
+type Detail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Fields lists the invalid fields of a VALIDATION_FAILED error
+	Fields []types.FieldError `json:"fields,omitempty"`
+	// Suggestion is a free alternative for a USERNAME_TAKEN error
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+type Response struct {
+	Error Detail `json:"error"`
+}
+
+// Abort ends the request with status and the error envelope.
+func Abort(c *gin.Context, status int, code string, message string) {
+	c.AbortWithStatusJSON(status, Response{Error: Detail{Code: code, Message: message}})
+}
+
+// AbortValidation ends the request with 422 and the invalid fields.
+func AbortValidation(c *gin.Context, fields []types.FieldError) {
+	c.AbortWithStatusJSON(http.StatusUnprocessableEntity, Response{Error: Detail{Code: CodeValidationFailed, Message: "validation failed", Fields: fields}})
+}
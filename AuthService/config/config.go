@@ -0,0 +1,287 @@
+package config
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"shared/cors"
+	sharedmodel "shared/model"
+	"shared/secrets"
+	"strconv"
+	"time"
+)
+
+type MongoConfigStruct struct {
+	MongoUri                   string
+	DatabaseName               string
+	UserCollectionName         string
+	RefreshTokenCollectionName string
+	OutboxCollectionName       string
+	LoginEventCollectionName   string
+	SessionCollectionName      string
+}
+
+var MongoConfig = MongoConfigStruct{
+	MongoUri:                   "mongodb://canvas-live-mongodb:27017",
+	DatabaseName:               "default",
+	UserCollectionName:         sharedmodel.UserCollection,
+	RefreshTokenCollectionName: sharedmodel.RefreshTokenCollection,
+	OutboxCollectionName:       sharedmodel.OutboxCollection,
+	LoginEventCollectionName:   sharedmodel.LoginEventCollection,
+	SessionCollectionName:      sharedmodel.SessionCollection,
+}
+
+// ServerConfigStruct controls the HTTP server. On SIGTERM in-flight requests
+// get up to ShutdownTimeout to finish.
+type ServerConfigStruct struct {
+	Addr            string
+	ShutdownTimeout time.Duration
+}
+
+var ServerConfig = ServerConfigStruct{
+	Addr:            getEnv("SERVER_ADDR", ":8081"),
+	ShutdownTimeout: getEnvDuration("SHUTDOWN_TIMEOUT", 15*time.Second),
+}
+
+type RedisConfigStruct struct {
+	Addr     string
+	Password string
+}
+
+var RedisConfig = RedisConfigStruct{
+	Addr: getEnv("REDIS_ADDR", "canvas-live-redis:6379"),
+}
+
+// TokenConfigStruct controls the lifetime of issued tokens. Leeway is the
+// clock skew tolerated when checking exp, nbf, and iat, since the services
+// validating a token run on different hosts. AcceptHS256 keeps HS256 tokens
+// valid after switching to RS256, until the last of them has expired.
+type TokenConfigStruct struct {
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	Leeway          time.Duration
+	AcceptHS256     bool
+}
+
+var TokenConfig = TokenConfigStruct{
+	AccessTokenTTL:  getEnvDuration("ACCESS_TOKEN_TTL", 24*time.Hour),
+	RefreshTokenTTL: getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+	Leeway:          getEnvDuration("TOKEN_LEEWAY", 30*time.Second),
+	AcceptHS256:     getEnv("JWT_ACCEPT_HS256", "true") == "true",
+}
+
+// RateLimitConfigStruct controls the login limiter. A limit of 0 disables
+// that dimension. Backend is "memory" (per instance) or "redis" (shared).
+type RateLimitConfigStruct struct {
+	Backend       string
+	LoginPerEmail int
+	LoginPerIP    int
+	LoginWindow   time.Duration
+}
+
+var RateLimitConfig = RateLimitConfigStruct{
+	Backend:       getEnv("RATE_LIMIT_BACKEND", "redis"),
+	LoginPerEmail: getEnvInt("LOGIN_RATE_LIMIT_PER_EMAIL", 5),
+	LoginPerIP:    getEnvInt("LOGIN_RATE_LIMIT_PER_IP", 20),
+	LoginWindow:   getEnvDuration("LOGIN_RATE_LIMIT_WINDOW", 15*time.Minute),
+}
+
+// AuditConfigStruct controls the login audit trail. Events older than
+// Retention are removed by a TTL index; QueueSize bounds the events waiting
+// to be written.
+type AuditConfigStruct struct {
+	Retention time.Duration
+	QueueSize int
+}
+
+var AuditConfig = AuditConfigStruct{
+	Retention: getEnvDuration("AUDIT_RETENTION", 90*24*time.Hour),
+	QueueSize: getEnvInt("AUDIT_QUEUE_SIZE", 1024),
+}
+
+// VerificationConfigStruct controls email verification. With Enforce off
+// (e.g. local development) unverified accounts can still log in.
+type VerificationConfigStruct struct {
+	Enforce  bool
+	TokenTTL time.Duration
+	URL      string // the token is appended as ?token=
+	// Resends are limited per email and per client IP
+	ResendPerEmail int
+	ResendPerIP    int
+	ResendWindow   time.Duration
+}
+
+var VerificationConfig = VerificationConfigStruct{
+	Enforce:        getEnv("REQUIRE_EMAIL_VERIFICATION", "true") == "true",
+	TokenTTL:       getEnvDuration("VERIFICATION_TOKEN_TTL", 24*time.Hour),
+	URL:            getEnv("VERIFICATION_URL", "http://localhost/auth/verify"),
+	ResendPerEmail: getEnvInt("VERIFICATION_RESEND_PER_EMAIL", 3),
+	ResendPerIP:    getEnvInt("VERIFICATION_RESEND_PER_IP", 10),
+	ResendWindow:   getEnvDuration("VERIFICATION_RESEND_WINDOW", time.Hour),
+}
+
+// CookieConfigStruct controls the HttpOnly session cookie browsers can use
+// instead of an Authorization header. It carries the access token and is set
+// on login when SetOnLogin is on or the client asks for it with ?cookie=true.
+// SameSite is "strict", "lax", or "none" (which browsers only accept with Secure).
+type CookieConfigStruct struct {
+	Name       string
+	Domain     string
+	Secure     bool
+	SameSite   http.SameSite
+	SetOnLogin bool
+}
+
+var CookieConfig = CookieConfigStruct{
+	Name:       getEnv("AUTH_COOKIE_NAME", "canvas_session"),
+	Domain:     getEnv("AUTH_COOKIE_DOMAIN", ""),
+	Secure:     getEnv("AUTH_COOKIE_SECURE", "true") == "true",
+	SameSite:   parseSameSite(getEnv("AUTH_COOKIE_SAMESITE", "lax")),
+	SetOnLogin: getEnv("AUTH_COOKIE_ON_LOGIN", "false") == "true",
+}
+
+// GoogleOAuthConfigStruct enables "Sign in with Google" when ClientID and the
+// GOOGLE_CLIENT_SECRET secret are set. RedirectURL must be registered with
+// Google and route to /auth/oauth/google/callback. After signing in the
+// browser is sent to SuccessURL with the session cookie set; without one the
+// callback responds with the tokens as JSON.
+type GoogleOAuthConfigStruct struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	SuccessURL   string
+	StateTTL     time.Duration
+}
+
+var GoogleOAuthConfig = GoogleOAuthConfigStruct{
+	ClientID:    getEnv("GOOGLE_CLIENT_ID", ""),
+	RedirectURL: getEnv("GOOGLE_REDIRECT_URL", "http://localhost/auth/oauth/google/callback"),
+	SuccessURL:  getEnv("OAUTH_SUCCESS_URL", ""),
+	StateTTL:    getEnvDuration("OAUTH_STATE_TTL", 10*time.Minute),
+}
+
+// Enabled reports whether Google sign-in is configured.
+func (c GoogleOAuthConfigStruct) Enabled() bool {
+	return c.ClientID != "" && c.ClientSecret != ""
+}
+
+// CORSConfig admits browser clients served from other origins, configured
+// with CORS_ALLOWED_ORIGINS and related variables (see cors.FromEnv).
+var CORSConfig = cors.FromEnv()
+
+// AdminConfigStruct designates the first admin. The account registered with
+// BootstrapEmail gets the admin role, and an existing one is promoted at startup.
+type AdminConfigStruct struct {
+	BootstrapEmail string
+}
+
+var AdminConfig = AdminConfigStruct{
+	BootstrapEmail: getEnv("ADMIN_BOOTSTRAP_EMAIL", ""),
+}
+
+// MailConfigStruct selects the mail transport. Without an SMTP address mail
+// is only logged.
+type MailConfigStruct struct {
+	SMTPAddr     string
+	From         string
+	SMTPUsername string
+	SMTPPassword string
+}
+
+var MailConfig = MailConfigStruct{
+	SMTPAddr:     getEnv("SMTP_ADDR", ""),
+	From:         getEnv("MAIL_FROM", "no-reply@canvas-live.local"),
+	SMTPUsername: getEnv("SMTP_USERNAME", ""),
+}
+
+// Secrets accept a NAME_FILE variant pointing at a mounted secret file.
+var Secrets = secrets.NewSet()
+
+var (
+	// MongoURI may embed the database password, so it is treated as a secret.
+	MongoURI = Secrets.Add(secrets.Spec{Name: "MONGO_URI", Fallback: MongoConfig.MongoUri})
+
+	RedisPassword = Secrets.Add(secrets.Spec{Name: "REDIS_PASSWORD"})
+
+	SMTPPassword = Secrets.Add(secrets.Spec{Name: "SMTP_PASSWORD"})
+
+	GoogleClientSecret = Secrets.Add(secrets.Spec{Name: "GOOGLE_CLIENT_SECRET"})
+
+	// JWTKeys and JWTActiveKID sign and verify access tokens (see SigningKeys).
+	// Reloaded on SIGHUP.
+	JWTKeys      = Secrets.Add(secrets.Spec{Name: "JWT_KEYS", Reloadable: true})
+	JWTActiveKID = Secrets.Add(secrets.Spec{Name: "JWT_ACTIVE_KID", Reloadable: true})
+
+	// JWTPrivateKey (PEM) switches signing to RS256; JWTPublicKeys (a PEM
+	// bundle) lists retired keys still accepted. Reloaded on SIGHUP.
+	JWTPrivateKey = Secrets.Add(secrets.Spec{Name: "JWT_PRIVATE_KEY", Reloadable: true})
+	JWTPublicKeys = Secrets.Add(secrets.Spec{Name: "JWT_PUBLIC_KEYS", Reloadable: true})
+
+	// JWTSecret is the single key of deployments that predate JWT_KEYS.
+	JWTSecret = Secrets.Add(secrets.Spec{Name: "JWT_SECRET", Reloadable: true})
+
+	// InternalHMACKey signs service-to-service requests. Reloaded on SIGHUP.
+	InternalHMACKey = Secrets.Add(secrets.Spec{Name: "INTERNAL_HMAC_KEY", Reloadable: true})
+)
+
+// Load reads every secret and applies the non-reloadable ones to the static config.
+func Load() error {
+	if err := Secrets.Load(); err != nil {
+		return err
+	}
+
+	// Refuse to start without a usable signing key rather than fall back to a known one
+	_, useRSA, err := RSAKeys()
+	if err != nil {
+		return err
+	}
+	if !useRSA {
+		if _, err := SigningKeys(); err != nil {
+			return err
+		}
+		log.Println("[Config] WARNING: JWT_PRIVATE_KEY is not configured, signing tokens with HS256")
+		if JWTKeys.Get() == "" {
+			log.Println("[Config] WARNING: JWT_KEYS is not configured, using JWT_SECRET without key rotation")
+		}
+	}
+
+	MongoConfig.MongoUri = MongoURI.Get()
+	RedisConfig.Password = RedisPassword.Get()
+	MailConfig.SMTPPassword = SMTPPassword.Get()
+	GoogleOAuthConfig.ClientSecret = GoogleClientSecret.Get()
+	return nil
+}
+
+func getEnv(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func parseSameSite(value string) http.SameSite {
+	switch value {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"shared/jwks"
+	"strings"
+	"sync"
+)
+
+// legacyKeyID names the key taken from JWT_SECRET when JWT_KEYS is not set.
+const legacyKeyID = "default"
+
+// ErrNoSigningKey is returned when neither JWT_KEYS nor JWT_SECRET is configured.
+var ErrNoSigningKey = errors.New("no JWT signing key configured: set JWT_PRIVATE_KEY, or JWT_KEYS and JWT_ACTIVE_KID")
+
+// KeySet holds the JWT keys by kid. Active signs new tokens; every key in
+// Keys verifies, so retired keys keep working until they are removed.
+type KeySet struct {
+	Active string
+	Keys   map[string][]byte
+}
+
+// ParseKeySet parses JWT_KEYS ("kid1:secret1,kid2:secret2") and checks that
+// active is one of them.
+func ParseKeySet(keys string, active string) (KeySet, error) {
+	set := KeySet{Active: active, Keys: make(map[string][]byte)}
+
+	for _, pair := range strings.Split(keys, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kid, secret, ok := strings.Cut(pair, ":")
+		if !ok || kid == "" || secret == "" {
+			return KeySet{}, fmt.Errorf("malformed key %q, expected kid:secret", kid)
+		}
+		if _, dup := set.Keys[kid]; dup {
+			return KeySet{}, fmt.Errorf("duplicate kid %q", kid)
+		}
+		set.Keys[kid] = []byte(secret)
+	}
+
+	if len(set.Keys) == 0 {
+		return KeySet{}, ErrNoSigningKey
+	}
+	if _, ok := set.Keys[active]; !ok {
+		return KeySet{}, fmt.Errorf("JWT_ACTIVE_KID %q is not in JWT_KEYS", active)
+	}
+	return set, nil
+}
+
+// SigningKeys returns the current key set. Both JWT_KEYS and JWT_ACTIVE_KID are
+// reloaded on SIGHUP, so a rotation is: add the new key, switch the active kid,
+// and remove the old key once the tokens it signed have expired.
+func SigningKeys() (KeySet, error) {
+	if keys := JWTKeys.Get(); keys != "" {
+		return ParseKeySet(keys, JWTActiveKID.Get())
+	}
+
+	// Deployments from before key rotation configure a single JWT_SECRET
+	if secret := JWTSecret.Get(); secret != "" {
+		return KeySet{Active: legacyKeyID, Keys: map[string][]byte{legacyKeyID: []byte(secret)}}, nil
+	}
+
+	return KeySet{}, ErrNoSigningKey
+}
+
+// RSAKeySet holds the RSA keys of RS256 signing. Private signs new tokens
+// under the kid Active; Public verifies and is published as the JWKS, so
+// retired keys keep working until they are removed from JWT_PUBLIC_KEYS.
+// Kids are RFC 7638 thumbprints and never configured.
+type RSAKeySet struct {
+	Active  string
+	Private *rsa.PrivateKey
+	Public  map[string]*rsa.PublicKey
+}
+
+var rsaCache struct {
+	sync.Mutex
+	private string
+	public  string
+	set     RSAKeySet
+	err     error
+}
+
+// RSAKeys returns the RS256 keys, or false when JWT_PRIVATE_KEY is not set
+// and tokens are signed with HS256. Parsed keys are cached until the secrets
+// change on a SIGHUP reload.
+func RSAKeys() (RSAKeySet, bool, error) {
+	private, public := JWTPrivateKey.Get(), JWTPublicKeys.Get()
+	if private == "" {
+		return RSAKeySet{}, false, nil
+	}
+
+	rsaCache.Lock()
+	defer rsaCache.Unlock()
+	if rsaCache.private != private || rsaCache.public != public {
+		rsaCache.set, rsaCache.err = parseRSAKeySet(private, public)
+		rsaCache.private, rsaCache.public = private, public
+	}
+	return rsaCache.set, true, rsaCache.err
+}
+
+func parseRSAKeySet(private string, public string) (RSAKeySet, error) {
+	block, _ := pem.Decode([]byte(private))
+	if block == nil {
+		return RSAKeySet{}, errors.New("JWT_PRIVATE_KEY is not PEM encoded")
+	}
+
+	var key *rsa.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return RSAKeySet{}, fmt.Errorf("JWT_PRIVATE_KEY: %w", err)
+		}
+		key = parsed
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return RSAKeySet{}, fmt.Errorf("JWT_PRIVATE_KEY: %w", err)
+		}
+		rsaKey, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return RSAKeySet{}, errors.New("JWT_PRIVATE_KEY is not an RSA key")
+		}
+		key = rsaKey
+	default:
+		return RSAKeySet{}, fmt.Errorf("JWT_PRIVATE_KEY has unsupported PEM type %q", block.Type)
+	}
+	if key.N.BitLen() < 2048 {
+		return RSAKeySet{}, errors.New("JWT_PRIVATE_KEY must be at least 2048 bits")
+	}
+
+	set := RSAKeySet{
+		Active:  jwks.Thumbprint(&key.PublicKey),
+		Private: key,
+		Public:  make(map[string]*rsa.PublicKey),
+	}
+	set.Public[set.Active] = &key.PublicKey
+
+	// JWT_PUBLIC_KEYS is a bundle of PEM public keys of retired private keys
+	rest := []byte(public)
+	for {
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		var pub *rsa.PublicKey
+		switch block.Type {
+		case "RSA PUBLIC KEY":
+			parsed, err := x509.ParsePKCS1PublicKey(block.Bytes)
+			if err != nil {
+				return RSAKeySet{}, fmt.Errorf("JWT_PUBLIC_KEYS: %w", err)
+			}
+			pub = parsed
+		case "PUBLIC KEY":
+			parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return RSAKeySet{}, fmt.Errorf("JWT_PUBLIC_KEYS: %w", err)
+			}
+			rsaKey, ok := parsed.(*rsa.PublicKey)
+			if !ok {
+				return RSAKeySet{}, errors.New("JWT_PUBLIC_KEYS contains a key that is not RSA")
+			}
+			pub = rsaKey
+		default:
+			return RSAKeySet{}, fmt.Errorf("JWT_PUBLIC_KEYS has unsupported PEM type %q", block.Type)
+		}
+		set.Public[jwks.Thumbprint(pub)] = pub
+	}
+
+	return set, nil
+}
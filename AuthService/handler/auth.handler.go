@@ -1,54 +1,140 @@
 package handler
 
 import (
+	"auth-service/apierror"
+	"auth-service/config"
 	"auth-service/model"
+	"auth-service/ratelimit"
+	"auth-service/redis"
 	"auth-service/repository"
+	"auth-service/types"
 	"auth-service/utils"
+	"bytes"
 	"context"
-	"encoding/json"
-	"fmt"
+	"errors"
+	"io"
+	"log"
 	"net/http"
+	"shared/authz"
+	"shared/httpclient"
+	"shared/tenant"
 	"strings"
 	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 )
 
 // User Registration
 type AuthHandler struct {
-	UserRepository *repository.UserRepository
+	UserRepository         *repository.UserRepository
+	RefreshTokenRepository *repository.RefreshTokenRepository
+	SessionRepository      *repository.SessionRepository
+	RedisClient            *redis.RedisClient
+	AuditRepository        *repository.AuditRepository
+
+	// Login attempts are limited per email and per client IP independently
+	LoginEmailLimiter *ratelimit.Limiter
+	LoginIPLimiter    *ratelimit.Limiter
+
+	Verification VerificationHandler
 }
 
-// ================================================= New User Registration Handler ===========================================================================
+type CreatedResponse struct {
+	ID string `json:"id"`
+}
+
+// abortWithTokenError ends the request for a token ParseToken rejected,
+// telling expired tokens apart so clients know to refresh.
+func abortWithTokenError(c *gin.Context, err error) {
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		apierror.Abort(c, http.StatusUnauthorized, apierror.CodeTokenExpired, "Token has expired")
+		return
+	}
+	apierror.Abort(c, http.StatusUnauthorized, apierror.CodeTokenInvalid, "Invalid token")
+}
+
+// recordLogin adds an event to the audit trail. user may be nil when the
+// email matched no account.
+func (h AuthHandler) recordLogin(c *gin.Context, eventType string, user *model.User, email string, reason string) {
+	event := model.LoginEvent{
+		Email:     repository.NormalizeEmail(email),
+		Type:      eventType,
+		Reason:    reason,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+	if user != nil {
+		event.UserID = user.ID.Hex()
+		event.TenantID = tenant.Normalize(user.TenantID)
+		event.Email = user.Email
+	}
+	h.AuditRepository.Record(event)
+}
 
-func (h AuthHandler) RegisterUser(w http.ResponseWriter, r *http.Request) {
-	requestMethod := r.Method
+// ================================================= New User Registration Handler ===========================================================================
 
-	fmt.Fprintf(w, "Request Method: %s\n", requestMethod)
+func (h AuthHandler) RegisterUser(c *gin.Context) {
+	// The router (router.POST) already ensures the method is POST
 
-	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST method allowed", http.StatusMethodNotAllowed)
+	var registerData types.RegisterUserData
+	if err := c.ShouldBindJSON(&registerData); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid JSON data")
 		return
 	}
 
-	var newUser model.User
-	err := json.NewDecoder(r.Body).Decode(&newUser)
-	if err != nil {
-		http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+	registerData.Normalize()
+	if fieldErrors := registerData.Validate(); len(fieldErrors) > 0 {
+		apierror.AbortValidation(c, fieldErrors)
 		return
 	}
 
+	// Only the validated fields reach the model
+	newUser := model.User{
+		Username: registerData.Username,
+		Email:    registerData.Email,
+		Password: registerData.Password,
+		Role:     authz.RoleUser,
+	}
+	if bootstrap := config.AdminConfig.BootstrapEmail; bootstrap != "" && repository.NormalizeEmail(bootstrap) == newUser.Email {
+		newUser.Role = authz.RoleAdmin
+	}
+
 	// Set up context
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 	defer cancel()
 
 	// Create user in db
 	createdUser, err := h.UserRepository.CreateUser(ctx, newUser)
+	if errors.Is(err, repository.ErrEmailAlreadyRegistered) {
+		apierror.Abort(c, http.StatusConflict, apierror.CodeEmailTaken, err.Error())
+		return
+	}
+	if errors.Is(err, repository.ErrUsernameTaken) {
+		suggestion, suggestErr := h.UserRepository.SuggestUsername(ctx, newUser.Username)
+		if suggestErr != nil {
+			log.Printf("[RegisterUser] Error suggesting a username: %v", suggestErr)
+		}
+		c.AbortWithStatusJSON(http.StatusConflict, apierror.Response{Error: apierror.Detail{
+			Code:       apierror.CodeUsernameTaken,
+			Message:    err.Error(),
+			Suggestion: suggestion,
+		}})
+		return
+	}
 	if err != nil {
-		http.Error(w, "Error creating user "+err.Error(), http.StatusInternalServerError)
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Error creating user")
+		return
+	}
+
+	// The account stays unverified until the emailed link is opened; a failed
+	// send is not fatal because the user can ask for the link again
+	if err := h.Verification.SendVerificationEmail(ctx, &createdUser); err != nil {
+		log.Printf("[RegisterUser] Error sending verification email: %v", err)
 	}
 
 	// Send success response
-	// w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "User ID: %s", createdUser.ID)
+	c.JSON(http.StatusCreated, CreatedResponse{ID: createdUser.ID.Hex()})
 }
 
 // ================================================= Login Handler ===========================================================================
@@ -58,89 +144,508 @@ type LoginData struct {
 	Password string `json:"password"`
 }
 
+// TokenResponse is returned by every endpoint that signs a user in. User saves
+// clients from decoding the access token to learn who they are.
 type TokenResponse struct {
-	AccessToken string `json:"access_token"`
+	AccessToken  string            `json:"access_token"`
+	TokenType    string            `json:"token_type"`
+	RefreshToken string            `json:"refresh_token,omitempty"`
+	ExpiresIn    int64             `json:"expires_in"`
+	ExpiresAt    string            `json:"expires_at"` // RFC 3339
+	User         types.UserProfile `json:"user"`
 }
 
-func (h AuthHandler) LoginUser(w http.ResponseWriter, r *http.Request) {
-	loginData := LoginData{}
-	err := json.NewDecoder(r.Body).Decode(&loginData)
+// issueTokens creates an access token for user in session sessionID together
+// with refreshToken, its rotated refresh token. When refreshToken is empty a
+// new session is started instead, e.g. at login. The session records the
+// client's IP and user agent.
+func (h AuthHandler) issueTokens(ctx context.Context, c *gin.Context, user *model.User, refreshToken string, sessionID string) (TokenResponse, error) {
+	var err error
+	if refreshToken == "" {
+		refreshToken, sessionID, err = h.RefreshTokenRepository.Issue(ctx, user.ID.Hex(), user.TenantID)
+		if err != nil {
+			return TokenResponse{}, err
+		}
+		err = h.SessionRepository.Create(ctx, model.Session{
+			ID:        sessionID,
+			UserID:    user.ID.Hex(),
+			TenantID:  user.TenantID,
+			IP:        c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		})
+	} else {
+		err = h.SessionRepository.Touch(ctx, sessionID, c.ClientIP(), c.Request.UserAgent())
+	}
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	return newTokenResponse(user, sessionID, refreshToken)
+}
+
+// newTokenResponse signs an access token for user in session sessionID. The
+// refresh token is left out of the response when empty, e.g. when only the
+// access token is reissued to carry changed claims.
+func newTokenResponse(user *model.User, sessionID string, refreshToken string) (TokenResponse, error) {
+	issuedAt := time.Now()
+	accessToken, err := utils.CreateToken(user.ID.Hex(), user.Email, user.Username, user.TenantID, user.Role, sessionID)
 	if err != nil {
-		http.Error(w, "Invalid json data format", http.StatusBadRequest)
+		return TokenResponse{}, err
+	}
+
+	return TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(config.TokenConfig.AccessTokenTTL.Seconds()),
+		ExpiresAt:    issuedAt.Add(config.TokenConfig.AccessTokenTTL).UTC().Format(time.RFC3339),
+		User:         types.NewUserProfile(*user),
+	}, nil
+}
+
+// writeTokens sends response, also storing the access token in the session
+// cookie when the deployment or the client asks for it, or when the request
+// was itself authenticated by the cookie (so a refresh keeps it current).
+func writeTokens(c *gin.Context, response TokenResponse) {
+	_, hasCookie := sessionCookie(c)
+	if config.CookieConfig.SetOnLogin || c.Query("cookie") == "true" || hasCookie {
+		setSessionCookie(c, response.AccessToken, int(response.ExpiresIn))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h AuthHandler) LoginUser(c *gin.Context) {
+	loginData := LoginData{}
+	if err := c.ShouldBindJSON(&loginData); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid json data format")
 		return
 	}
 
 	// 2. Set up context
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	// Throttle password guessing before touching the user
+	emailKey := repository.NormalizeEmail(loginData.Email)
+	if !ratelimit.Guard(c,
+		ratelimit.Check{Limiter: h.LoginIPLimiter, Key: c.ClientIP()},
+		ratelimit.Check{Limiter: h.LoginEmailLimiter, Key: emailKey},
+	) {
+		h.recordLogin(c, model.LoginEventFailed, nil, loginData.Email, "rate_limited")
+		return
+	}
+
+	// 3. Check the credentials
+	user, err := verifyCredentials(ctx, h.UserRepository, loginData.Email, loginData.Password, config.VerificationConfig.Enforce)
+	var credErr *credentialError
+	switch {
+	case errors.As(err, &credErr):
+		h.recordLogin(c, model.LoginEventFailed, user, loginData.Email, credErr.reason)
+		abortWithCredentialError(c, err)
+		return
+	case err != nil:
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error during database lookup")
+		return
+	}
+
+	// 4. Generate access and refresh tokens
+	response, err := h.issueTokens(ctx, c, user, "", "")
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Error signing you in - Try again.")
+		return
+	}
+
+	// A successful login clears the failures counted against this account
+	if err := h.LoginEmailLimiter.Reset(ctx, emailKey); err != nil {
+		log.Printf("[LoginUser] Could not reset login rate limit: %v", err)
+	}
+
+	h.recordLogin(c, model.LoginEventSucceeded, user, user.Email, "")
+	writeTokens(c, response)
+}
+
+// ================================================= Refresh Token Handler ===========================================================================
+
+type RefreshData struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (h AuthHandler) RefreshToken(c *gin.Context) {
+	refreshData := RefreshData{}
+	if err := c.ShouldBindJSON(&refreshData); err != nil || refreshData.RefreshToken == "" {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid json data format")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 	defer cancel()
 
-	// 3. Call the repository method
-	user, err := h.UserRepository.FindUserByEmail(ctx, loginData.Email)
+	// Consume the presented token; a reused token revokes its whole family
+	record, nextToken, err := h.RefreshTokenRepository.Rotate(ctx, refreshData.RefreshToken)
+	if errors.Is(err, repository.ErrRefreshTokenReused) {
+		log.Printf("[RefreshToken] Refresh token reuse detected, family revoked")
+		apierror.Abort(c, http.StatusUnauthorized, apierror.CodeRefreshInvalid, "Invalid refresh token")
+		return
+	}
+	if errors.Is(err, repository.ErrRefreshTokenInvalid) {
+		apierror.Abort(c, http.StatusUnauthorized, apierror.CodeRefreshInvalid, "Invalid refresh token")
+		return
+	}
 	if err != nil {
-		// Handle the internal database error
-		http.Error(w, "Internal server error during database lookup", http.StatusInternalServerError)
+		log.Printf("[RefreshToken] Error rotating refresh token: %v", err)
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error")
 		return
 	}
 
-	// 4. Handle result
+	user, err := h.UserRepository.FindUserByID(ctx, record.UserID)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error during database lookup")
+		return
+	}
 	if user == nil {
-		http.NotFound(w, r)
-		fmt.Fprintf(w, "User with email '%s' not found.", loginData.Email)
+		apierror.Abort(c, http.StatusUnauthorized, apierror.CodeRefreshInvalid, "Invalid refresh token")
+		return
+	}
+
+	response, err := h.issueTokens(ctx, c, user, nextToken, record.FamilyID)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Error refreshing your session - Try again.")
+		return
+	}
+
+	h.recordLogin(c, model.LoginEventRefreshed, user, user.Email, "")
+	writeTokens(c, response)
+}
+
+// ================================================= Change Password Handler ===========================================================================
+
+func (h AuthHandler) ChangePassword(c *gin.Context) {
+	claims, ok := h.authenticate(c)
+	if !ok {
 		return
 	}
 
-	if user.Password != loginData.Password {
-		http.Error(w, "Incorrect credentials", http.StatusUnauthorized)
+	var passwordData types.ChangePasswordData
+	if err := c.ShouldBindJSON(&passwordData); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid json data format")
+		return
 	}
+	if fieldErrors := passwordData.Validate(); len(fieldErrors) > 0 {
+		apierror.AbortValidation(c, fieldErrors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
 
-	// 5. Generate JWT
-	jwtToken, err := utils.CreateToken(user.ID.Hex(), loginData.Email, user.Username)
+	user, err := h.UserRepository.FindUserByID(ctx, claims.UserID)
 	if err != nil {
-		http.Error(w, "Error signing you in - Try again.", http.StatusInternalServerError)
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error during database lookup")
+		return
+	}
+	if user == nil {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeUserNotFound, "User not found")
+		return
+	}
+
+	if ok, _ := utils.CheckPassword(user.Password, passwordData.CurrentPassword); !ok {
+		apierror.Abort(c, http.StatusForbidden, apierror.CodeInvalidCredentials, "Incorrect current password")
+		return
 	}
 
-	response := TokenResponse{AccessToken: jwtToken}
-	json.NewEncoder(w).Encode(response)
+	err = h.UserRepository.UpdatePassword(ctx, user.ID, user.Password, passwordData.NewPassword)
+	if errors.Is(err, repository.ErrPasswordChanged) {
+		apierror.Abort(c, http.StatusConflict, apierror.CodePasswordChanged, "Password was changed by another request - Try again.")
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Error changing password")
+		return
+	}
+
+	// End every existing session; the caller continues with the tokens returned below
+	if _, err := h.SessionRepository.RevokeOthers(ctx, claims.UserID, ""); err != nil {
+		log.Printf("[ChangePassword] Error revoking sessions: %v", err)
+	}
+	if err := h.RefreshTokenRepository.RevokeAllForUser(ctx, claims.UserID); err != nil {
+		log.Printf("[ChangePassword] Error revoking refresh tokens: %v", err)
+	}
+	if err := h.RedisClient.RevokeUserTokens(ctx, claims.UserID, time.Now(), config.TokenConfig.AccessTokenTTL); err != nil {
+		log.Printf("[ChangePassword] Error revoking access tokens: %v", err)
+	}
+
+	response, err := h.issueTokens(ctx, c, user, "", "")
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Password changed, but signing you in failed - Log in again.")
+		return
+	}
+
+	writeTokens(c, response)
+}
+
+// ================================================= Delete Account Handler ===========================================================================
+
+type DeleteAccountData struct {
+	Password string `json:"password"`
+}
+
+// DeleteAccount removes the caller's account after re-confirming the password.
+// Owned documents and shares are removed asynchronously by DocumentService
+// when it consumes the resulting user.deleted event.
+func (h AuthHandler) DeleteAccount(c *gin.Context) {
+	claims, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+
+	var deleteData DeleteAccountData
+	if err := c.ShouldBindJSON(&deleteData); err != nil || deleteData.Password == "" {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Password confirmation required")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.UserRepository.FindUserByID(ctx, claims.UserID)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error during database lookup")
+		return
+	}
+	if user == nil {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeUserNotFound, "User not found")
+		return
+	}
+
+	if ok, _ := utils.CheckPassword(user.Password, deleteData.Password); !ok {
+		apierror.Abort(c, http.StatusForbidden, apierror.CodeInvalidCredentials, "Incorrect password")
+		return
+	}
+
+	if err := h.UserRepository.DeleteUser(ctx, user); err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Error deleting account")
+		return
+	}
+
+	// The account is gone; make sure none of its sessions outlive it, including
+	// tokens issued within the current second (iat has second precision)
+	if _, err := h.SessionRepository.RevokeOthers(ctx, claims.UserID, ""); err != nil {
+		log.Printf("[DeleteAccount] Error revoking sessions: %v", err)
+	}
+	if err := h.RefreshTokenRepository.RevokeAllForUser(ctx, claims.UserID); err != nil {
+		log.Printf("[DeleteAccount] Error revoking refresh tokens: %v", err)
+	}
+	if err := h.RedisClient.RevokeUserTokens(ctx, claims.UserID, time.Now().Add(time.Second), config.TokenConfig.AccessTokenTTL); err != nil {
+		log.Printf("[DeleteAccount] Error revoking access tokens: %v", err)
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ================================================= Logout Handler ===========================================================================
+
+func (h AuthHandler) Logout(c *gin.Context) {
+	token, ok := bearerToken(c)
+	if !ok {
+		return
+	}
+
+	claims, err := utils.ParseToken(token)
+	if err != nil {
+		abortWithTokenError(c, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	// Keep the token on the revocation list until it would have expired anyway
+	if err := h.RedisClient.RevokeToken(ctx, utils.TokenID(token, claims), claims.ExpiresAt.Time); err != nil {
+		log.Printf("[Logout] Error revoking token: %v", err)
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Error signing you out - Try again.")
+		return
+	}
+
+	// End the session the token belongs to
+	if claims.SessionID != "" {
+		if err := h.revokeSessions(ctx, claims.SessionID); err != nil {
+			log.Printf("[Logout] Error revoking session: %v", err)
+		}
+		if _, err := h.SessionRepository.Revoke(ctx, claims.UserID, claims.SessionID); err != nil {
+			log.Printf("[Logout] Error revoking session: %v", err)
+		}
+	}
+
+	// The refresh token is optional; when given, its family is revoked as well
+	refreshData := RefreshData{}
+	if err := c.ShouldBindJSON(&refreshData); err == nil && refreshData.RefreshToken != "" {
+		if err := h.RefreshTokenRepository.Revoke(ctx, refreshData.RefreshToken); err != nil {
+			log.Printf("[Logout] Error revoking refresh token: %v", err)
+		}
+	}
+
+	h.AuditRepository.Record(model.LoginEvent{
+		UserID:    claims.UserID,
+		TenantID:  claims.TenantID,
+		Email:     claims.Email,
+		Type:      model.LoginEventLogout,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	})
+
+	// maxAge < 0 tells the browser to drop the cookie
+	if _, ok := sessionCookie(c); ok {
+		setSessionCookie(c, "", -1)
+	}
+
+	c.Status(http.StatusNoContent)
 }
 
 // ================================================= Authenticate Request Handler ===========================================================================
 
-func (h AuthHandler) AuthenticateRequest(w http.ResponseWriter, r *http.Request) {
-	authHeader := r.Header.Get("Authorization")
+func sessionCookie(c *gin.Context) (string, bool) {
+	token, err := c.Cookie(config.CookieConfig.Name)
+	if err != nil || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+func setSessionCookie(c *gin.Context, token string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     config.CookieConfig.Name,
+		Value:    token,
+		Path:     "/",
+		Domain:   config.CookieConfig.Domain,
+		MaxAge:   maxAge,
+		Secure:   config.CookieConfig.Secure,
+		HttpOnly: true,
+		SameSite: config.CookieConfig.SameSite,
+	})
+}
+
+// bearerToken extracts the token from the Authorization header, falling back
+// to the session cookie when there is no header. It writes the error response
+// itself when neither is present or the header is malformed.
+func bearerToken(c *gin.Context) (string, bool) {
+	authHeader := c.GetHeader("Authorization")
 
 	if authHeader == "" {
-		http.Error(w, "Authorization header required", http.StatusUnauthorized)
-		return
+		if token, ok := sessionCookie(c); ok {
+			return token, true
+		}
+		apierror.Abort(c, http.StatusUnauthorized, apierror.CodeTokenMissing, "Authorization header required")
+		return "", false
 	}
 
 	if !strings.HasPrefix(authHeader, "Bearer ") {
-		http.Error(w, "Invalid authorization format: expected 'Bearer <token>'", http.StatusBadRequest)
-		return
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeTokenMalformed, "Invalid authorization format: expected 'Bearer <token>'")
+		return "", false
 	}
 
-	token := authHeader[len("Bearer "):]
+	return authHeader[len("Bearer "):], true
+}
+
+// authenticate validates the bearer token and checks it against the
+// revocation lists, writing the error response itself on failure. Revocation
+// fails closed when Redis is unavailable.
+func (h AuthHandler) authenticate(c *gin.Context) (*utils.CustomClaims, bool) {
+	token, ok := bearerToken(c)
+	if !ok {
+		return nil, false
+	}
 
 	// extract claims from token
 	claims, err := utils.ParseToken(token)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
-		return
+		abortWithTokenError(c, err)
+		return nil, false
+	}
+
+	// reject tokens revoked at logout
+	revoked, err := h.RedisClient.IsTokenRevoked(c.Request.Context(), utils.TokenID(token, claims))
+	if err != nil {
+		log.Printf("[Authenticate] Error checking token revocation: %v", err)
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error")
+		return nil, false
 	}
 
-	// add UserID to request object
-	// --- RESPONSE HEADER MODIFICATION (CRITICAL STEP) ---
+	// and tokens issued before the user's sessions were revoked, e.g. by a password change
+	if !revoked {
+		revokedAt, err := h.RedisClient.UserTokensRevokedAt(c.Request.Context(), claims.UserID)
+		if err != nil {
+			log.Printf("[Authenticate] Error checking user token revocation: %v", err)
+			apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error")
+			return nil, false
+		}
+		revoked = !revokedAt.IsZero() && (claims.IssuedAt == nil || claims.IssuedAt.Before(revokedAt))
+	}
+
+	// and tokens of sessions the user ended
+	if !revoked && claims.SessionID != "" {
+		revoked, err = h.RedisClient.IsSessionRevoked(c.Request.Context(), claims.SessionID)
+		if err != nil {
+			log.Printf("[Authenticate] Error checking session revocation: %v", err)
+			apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error")
+			return nil, false
+		}
+	}
 
-	// 1. Set the custom headers on the ResponseWriter (w)
+	if revoked {
+		apierror.Abort(c, http.StatusUnauthorized, apierror.CodeTokenRevoked, "Token has been revoked")
+		return nil, false
+	}
+
+	return claims, true
+}
+
+// authenticateCaller accepts either a signed internal request from another
+// service, which names its tenant in X-Tenant-ID, or a user's bearer token.
+// It returns the caller's tenant.
+func (h AuthHandler) authenticateCaller(c *gin.Context) (string, bool) {
+	if c.GetHeader(httpclient.SignatureHeader) != "" {
+		key := config.InternalHMACKey.Get()
+		if key == "" {
+			apierror.Abort(c, http.StatusUnauthorized, apierror.CodeSignatureInvalid, "Internal requests are not enabled")
+			return "", false
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Error reading request body")
+			return "", false
+		}
+		if err := httpclient.Verify(c.Request, []byte(key), body, time.Minute); err != nil {
+			apierror.Abort(c, http.StatusUnauthorized, apierror.CodeSignatureInvalid, "Invalid request signature")
+			return "", false
+		}
+		// Leave the body for the handler to read
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		return tenant.Normalize(c.GetHeader("X-Tenant-ID")), true
+	}
+
+	claims, ok := h.authenticate(c)
+	if !ok {
+		return "", false
+	}
+	return claims.TenantID, true
+}
+
+func (h AuthHandler) AuthenticateRequest(c *gin.Context) {
+	claims, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+
+	// 1. Set the custom headers on the response
 	// These are the headers Nginx's auth_request_set will read.
-	w.Header().Set("X-User-ID", claims.UserID)
-	w.Header().Set("X-Username", claims.Username)
-	// w.Header().Set("X-User-Email", claims.UserEmail) // If you use the email header
+	c.Header("X-User-ID", claims.UserID)
+	c.Header("X-Username", claims.Username)
+	c.Header("X-Tenant-ID", claims.TenantID)
+	c.Header(authz.RoleHeader, claims.Role)
 
 	// 2. IMPORTANT: Send a 2xx Status Code (usually 200 OK)
 	// Nginx requires a 2xx response from the auth_request to proceed with proxy_pass.
-	// We send a minimal response and a 200 status code.
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "Auth Success") // This response body is usually ignored by Nginx.
-	// fmt.Fprintf(w, "Access granted: %s", claims)
-
+	// The body is ignored by Nginx.
+	c.String(http.StatusOK, "Auth Success")
 }
@@ -0,0 +1,371 @@
+package handler
+
+import (
+	"auth-service/apierror"
+	"auth-service/config"
+	"auth-service/model"
+	"auth-service/redis"
+	"auth-service/repository"
+	"auth-service/utils"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"shared/authz"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// authRouter serves logout and authenticate with the revocation lists in
+// miniredis. Login events go nowhere.
+func authRouter(t *testing.T) (*gin.Engine, *miniredis.Miniredis) {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test-secret")
+	if err := config.Secrets.Load(); err != nil {
+		t.Fatalf("loading secrets: %v", err)
+	}
+
+	mr := miniredis.RunT(t)
+	h := AuthHandler{RedisClient: redis.NewRedisClient(mr.Addr(), ""), AuditRepository: discardAudit(t)}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/logout", h.Logout)
+	router.GET("/auth/authenticate", h.AuthenticateRequest)
+	return router, mr
+}
+
+// discardAudit records login events to a database it never finds.
+func discardAudit(t *testing.T) *repository.AuditRepository {
+	t.Helper()
+	// The client connects lazily
+	mongoClient, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://127.0.0.1:1").SetServerSelectionTimeout(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit := repository.NewAuditRepository(mongoClient, "test", "loginEvents", time.Hour, 16)
+	t.Cleanup(func() { audit.Close(context.Background()) })
+	return audit
+}
+
+func request(router *gin.Engine, method string, path string, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestLogoutRevokesOnlyItsToken(t *testing.T) {
+	router, mr := authRouter(t)
+
+	loggedOut, err := utils.CreateToken("user-1", "a@example.com", "alice", "acme", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same user, signed in on another device
+	other, err := utils.CreateToken("user-1", "a@example.com", "alice", "acme", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := request(router, http.MethodPost, "/auth/logout", loggedOut); rec.Code != http.StatusNoContent {
+		t.Fatalf("logout: status %d, want 204: %s", rec.Code, rec.Body)
+	}
+
+	rec := request(router, http.MethodGet, "/auth/authenticate", loggedOut)
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), apierror.CodeTokenRevoked) {
+		t.Errorf("logged out token: status %d %s, want 401 %s", rec.Code, rec.Body, apierror.CodeTokenRevoked)
+	}
+	rec = request(router, http.MethodGet, "/auth/authenticate", other)
+	if rec.Code != http.StatusOK || rec.Header().Get("X-User-ID") != "user-1" {
+		t.Errorf("other token of the user: status %d, X-User-ID %q, want 200 user-1", rec.Code, rec.Header().Get("X-User-ID"))
+	}
+
+	// The entry goes once the token would have expired anyway
+	keys := mr.Keys()
+	if len(keys) != 1 {
+		t.Fatalf("revocation store holds %v, want the one token", keys)
+	}
+	if ttl := mr.TTL(keys[0]); ttl <= 0 || ttl > config.TokenConfig.AccessTokenTTL {
+		t.Errorf("revocation of the token expires in %v, want within the token's lifetime %v", ttl, config.TokenConfig.AccessTokenTTL)
+	}
+}
+
+func TestTokenFromHeaderOrCookie(t *testing.T) {
+	router, _ := authRouter(t)
+
+	alice, err := utils.CreateToken("user-1", "a@example.com", "alice", "acme", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := utils.CreateToken("user-2", "b@example.com", "bob", "acme", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		header     string
+		cookie     string
+		wantStatus int
+		wantUser   string
+		wantCode   string
+	}{
+		{name: "header only", header: "Bearer " + alice, wantStatus: http.StatusOK, wantUser: "user-1"},
+		{name: "cookie only", cookie: bob, wantStatus: http.StatusOK, wantUser: "user-2"},
+		// The header is what the client chose to send; the cookie is only a fallback
+		{name: "both", header: "Bearer " + alice, cookie: bob, wantStatus: http.StatusOK, wantUser: "user-1"},
+		{name: "invalid header with a valid cookie", header: "Bearer not-a-token", cookie: bob, wantStatus: http.StatusUnauthorized, wantCode: apierror.CodeTokenInvalid},
+		{name: "malformed header", header: "Token " + alice, wantStatus: http.StatusBadRequest, wantCode: apierror.CodeTokenMalformed},
+		{name: "neither", wantStatus: http.StatusUnauthorized, wantCode: apierror.CodeTokenMissing},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/auth/authenticate", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		if tt.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: config.CookieConfig.Name, Value: tt.cookie})
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.wantStatus, rec.Body)
+			continue
+		}
+		if got := rec.Header().Get("X-User-ID"); got != tt.wantUser {
+			t.Errorf("%s: X-User-ID %q, want %q", tt.name, got, tt.wantUser)
+		}
+		if tt.wantCode != "" && !strings.Contains(rec.Body.String(), tt.wantCode) {
+			t.Errorf("%s: body %s, want %s", tt.name, rec.Body, tt.wantCode)
+		}
+	}
+}
+
+// TestAuthenticateAnswersTheGateway checks the response Nginx's auth_request
+// reads: a 2xx and the identity headers, whatever the original method.
+func TestAuthenticateAnswersTheGateway(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	if err := config.Secrets.Load(); err != nil {
+		t.Fatalf("loading secrets: %v", err)
+	}
+	h := AuthHandler{RedisClient: redis.NewRedisClient(miniredis.RunT(t).Addr(), "")}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Any("/auth/authenticate", h.AuthenticateRequest)
+
+	token, err := utils.CreateToken("user-1", "a@example.com", "alice", "acme", "admin", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"X-User-ID":      "user-1",
+		"X-Username":     "alice",
+		"X-Tenant-ID":    "acme",
+		authz.RoleHeader: "admin",
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete} {
+		rec := request(router, method, "/auth/authenticate", token)
+		if rec.Code != http.StatusOK || rec.Body.String() != "Auth Success" {
+			t.Errorf("%s: status %d %q, want 200 Auth Success", method, rec.Code, rec.Body)
+		}
+		for header, value := range want {
+			if got := rec.Header().Get(header); got != value {
+				t.Errorf("%s: %s %q, want %q", method, header, got, value)
+			}
+		}
+	}
+
+	// Refused requests name no one
+	rec := request(router, http.MethodGet, "/auth/authenticate", "not-a-token")
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("forged token: status %d, want 401", rec.Code)
+	}
+	for header := range want {
+		if got := rec.Header().Get(header); got != "" {
+			t.Errorf("forged token: %s %q, want none", header, got)
+		}
+	}
+}
+
+// changePassword posts a password change with token.
+func changePassword(router *gin.Engine, token string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/auth/password", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestChangePasswordRefusals(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	if err := config.Secrets.Load(); err != nil {
+		t.Fatalf("loading secrets: %v", err)
+	}
+	// Refused before the user is looked up
+	h := AuthHandler{RedisClient: redis.NewRedisClient(miniredis.RunT(t).Addr(), "")}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/password", h.ChangePassword)
+	token, err := utils.CreateToken("user-1", "a@example.com", "alice", "acme", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"without a token", "", `{"current_password":"correct horse","new_password":"battery staple"}`, http.StatusUnauthorized, apierror.CodeTokenMissing},
+		{"malformed JSON", token, `{"current_password":`, http.StatusBadRequest, apierror.CodeInvalidRequest},
+		{"no current password", token, `{"new_password":"battery staple"}`, http.StatusUnprocessableEntity, apierror.CodeValidationFailed},
+		{"weak new password", token, `{"current_password":"correct horse","new_password":"short"}`, http.StatusUnprocessableEntity, apierror.CodeValidationFailed},
+	}
+	for _, tt := range tests {
+		rec := changePassword(router, tt.token, tt.body)
+		if rec.Code != tt.wantStatus || !strings.Contains(rec.Body.String(), `"code":"`+tt.wantCode+`"`) {
+			t.Errorf("%s: status %d %s, want %d %s", tt.name, rec.Code, rec.Body, tt.wantStatus, tt.wantCode)
+		}
+	}
+}
+
+func TestChangePasswordWithWrongCurrentPassword(t *testing.T) {
+	router, users := registerRouter(t)
+	tokens := signIn(t, router, users, "ann", "ann@example.com")
+
+	rec := changePassword(router, tokens.AccessToken, `{"current_password":"wrong horse","new_password":"battery staple"}`)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), apierror.CodeInvalidCredentials) {
+		t.Errorf("status %d %s, want 403 %s", rec.Code, rec.Body, apierror.CodeInvalidCredentials)
+	}
+	if rec := post(router, "/auth/login", `{"email":"ann@example.com","password":"correct horse"}`); rec.Code != http.StatusOK {
+		t.Errorf("login with the unchanged password: status %d, want 200", rec.Code)
+	}
+}
+
+func TestChangePasswordEndsOtherSessions(t *testing.T) {
+	router, users := registerRouter(t)
+	phone := signIn(t, router, users, "ann", "ann@example.com")
+	laptop := post(router, "/auth/login", `{"email":"ann@example.com","password":"correct horse"}`)
+	var laptopTokens TokenResponse
+	json.Unmarshal(laptop.Body.Bytes(), &laptopTokens)
+
+	// Tokens issued within the same second as the change would survive it
+	time.Sleep(time.Second)
+	rec := changePassword(router, laptopTokens.AccessToken, `{"current_password":"correct horse","new_password":"battery staple"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("change: status %d %s, want 200", rec.Code, rec.Body)
+	}
+	var fresh TokenResponse
+	json.Unmarshal(rec.Body.Bytes(), &fresh)
+
+	for name, token := range map[string]string{"phone": phone.AccessToken, "laptop": laptopTokens.AccessToken} {
+		if rec := request(router, http.MethodGet, "/auth/authenticate", token); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s token after the change: status %d, want 401", name, rec.Code)
+		}
+	}
+	if rec := request(router, http.MethodGet, "/auth/authenticate", fresh.AccessToken); rec.Code != http.StatusOK {
+		t.Errorf("token returned by the change: status %d %s, want 200", rec.Code, rec.Body)
+	}
+	if rec := post(router, "/auth/login", `{"email":"ann@example.com","password":"correct horse"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("login with the old password: status %d, want 401", rec.Code)
+	}
+	if rec := post(router, "/auth/login", `{"email":"ann@example.com","password":"battery staple"}`); rec.Code != http.StatusOK {
+		t.Errorf("login with the new password: status %d, want 200", rec.Code)
+	}
+}
+
+// TestTokenResponseSchema checks every field of the answer to a sign-in, and
+// that nothing else is in it.
+func TestTokenResponseSchema(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	if err := config.Secrets.Load(); err != nil {
+		t.Fatalf("loading secrets: %v", err)
+	}
+	user := &model.User{
+		ID:       primitive.NewObjectID(),
+		Username: "ann",
+		Email:    "ann@example.com",
+		Password: "$2a$10$hash",
+		JoinedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Role:     "admin",
+		TenantID: "acme",
+	}
+	before := time.Now().Truncate(time.Second)
+	response, err := newTokenResponse(user, "session-1", "refresh-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+	writeTokens(c, response)
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Errorf("status %d, Content-Type %q, want 200 JSON", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"access_token":  response.AccessToken,
+		"token_type":    "Bearer",
+		"refresh_token": "refresh-1",
+		"expires_in":    config.TokenConfig.AccessTokenTTL.Seconds(),
+		"expires_at":    body["expires_at"],
+		"user": map[string]any{
+			"id":        user.ID.Hex(),
+			"username":  "ann",
+			"email":     "ann@example.com",
+			"role":      "admin",
+			"createdAt": "2026-01-02T03:04:05Z",
+		},
+	}
+	if !reflect.DeepEqual(body, want) {
+		t.Errorf("body %v, want %v", body, want)
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, response.ExpiresAt)
+	if err != nil {
+		t.Fatalf("expires_at %q is not RFC 3339: %v", response.ExpiresAt, err)
+	}
+	if wantAt := before.Add(config.TokenConfig.AccessTokenTTL); expiresAt.Before(wantAt) || expiresAt.After(wantAt.Add(2*time.Second)) {
+		t.Errorf("expires_at %s, want about %s", expiresAt, wantAt)
+	}
+	claims, err := utils.ParseToken(response.AccessToken)
+	if err != nil || claims.UserID != user.ID.Hex() || claims.SessionID != "session-1" {
+		t.Errorf("access token claims %+v, %v, want the user in session-1", claims, err)
+	}
+}
+
+func TestLoginResponseSchema(t *testing.T) {
+	router, users := registerRouter(t)
+	tokens := signIn(t, router, users, "ann", "ann@example.com")
+
+	if tokens.TokenType != "Bearer" || tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Errorf("tokens %+v, want Bearer access and refresh tokens", tokens)
+	}
+	if _, err := time.Parse(time.RFC3339, tokens.ExpiresAt); err != nil {
+		t.Errorf("expires_at %q is not RFC 3339", tokens.ExpiresAt)
+	}
+	if tokens.User.Username != "ann" || tokens.User.Email != "ann@example.com" || tokens.User.ID == "" {
+		t.Errorf("user %+v, want ann", tokens.User)
+	}
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"auth-service/apierror"
+	"auth-service/model"
+	"auth-service/utils"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Credential check failures. Callers must answer errInvalidCredentials the
+// same way whatever its reason, so responses do not reveal which emails have
+// accounts.
+var (
+	errInvalidCredentials = errors.New("invalid email or password")
+	errEmailNotVerified   = errors.New("email address is not verified")
+)
+
+// credentialError is a failed credential check. Reason is for the audit
+// trail only.
+type credentialError struct {
+	err    error
+	reason string
+}
+
+func (e *credentialError) Error() string { return e.err.Error() }
+func (e *credentialError) Unwrap() error { return e.err }
+
+// abortWithCredentialError ends the request for a failed credential check.
+// Every errInvalidCredentials gets the same response, whatever its reason.
+func abortWithCredentialError(c *gin.Context, err error) {
+	if errors.Is(err, errEmailNotVerified) {
+		apierror.Abort(c, http.StatusForbidden, apierror.CodeEmailNotVerified, "Email address is not verified")
+		return
+	}
+	apierror.Abort(c, http.StatusUnauthorized, apierror.CodeInvalidCredentials, "Invalid email or password")
+}
+
+// credentialStore is the part of the user repository credential checks use.
+type credentialStore interface {
+	FindUserByEmail(ctx context.Context, email string) (*model.User, error)
+	UpdatePasswordHash(ctx context.Context, id primitive.ObjectID, hash string) error
+}
+
+// verifyCredentials returns the user email and password belong to. A failed
+// check is a *credentialError wrapping errInvalidCredentials or
+// errEmailNotVerified, returned with the user when there is one; any other
+// error is the store's. Unknown emails take as long as wrong passwords. A
+// legacy or outdated password hash is upgraded on success.
+func verifyCredentials(ctx context.Context, store credentialStore, email string, password string, requireVerified bool) (*model.User, error) {
+	user, err := store.FindUserByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		utils.CheckDummyPassword(password)
+		return nil, &credentialError{err: errInvalidCredentials, reason: "unknown_email"}
+	}
+
+	ok, needsRehash := utils.CheckPassword(user.Password, password)
+	if !ok {
+		return user, &credentialError{err: errInvalidCredentials, reason: "wrong_password"}
+	}
+	if requireVerified && !user.Verified {
+		return user, &credentialError{err: errEmailNotVerified, reason: "email_not_verified"}
+	}
+
+	// Upgrade legacy plaintext (or outdated) passwords now that we know the plaintext
+	if needsRehash {
+		if hash, err := utils.HashPassword(password); err == nil {
+			if err := store.UpdatePasswordHash(ctx, user.ID, hash); err != nil {
+				log.Printf("[LoginUser] Could not upgrade password hash for %s: %v", user.ID.Hex(), err)
+			}
+		}
+	}
+
+	return user, nil
+}
@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"auth-service/apierror"
+	"auth-service/model"
+	"auth-service/ratelimit"
+	"auth-service/utils"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeCredentials holds users by email, and the hashes stored for them.
+type fakeCredentials struct {
+	users    map[string]*model.User
+	upgrades map[primitive.ObjectID]string
+	err      error
+}
+
+func newFakeCredentials(users ...*model.User) *fakeCredentials {
+	s := &fakeCredentials{users: make(map[string]*model.User), upgrades: make(map[primitive.ObjectID]string)}
+	for _, user := range users {
+		s.users[user.Email] = user
+	}
+	return s
+}
+
+func (s *fakeCredentials) FindUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	return s.users[email], s.err
+}
+
+func (s *fakeCredentials) UpdatePasswordHash(ctx context.Context, id primitive.ObjectID, hash string) error {
+	s.upgrades[id] = hash
+	return nil
+}
+
+func passwordUser(t *testing.T, email string, password string) *model.User {
+	t.Helper()
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &model.User{ID: primitive.NewObjectID(), Email: email, Password: hash, Verified: true, Provider: model.ProviderPassword}
+}
+
+// credentialResponse is what the login answers a failed check with.
+func credentialResponse(err error) (int, string, http.Header) {
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	abortWithCredentialError(c, err)
+	return rec.Code, rec.Body.String(), rec.Header()
+}
+
+func reasonOf(t *testing.T, err error) string {
+	t.Helper()
+	var credErr *credentialError
+	if !errors.As(err, &credErr) {
+		t.Fatalf("error %v is not a failed credential check", err)
+	}
+	return credErr.reason
+}
+
+func TestUnknownEmailAndWrongPasswordFailAlike(t *testing.T) {
+	store := newFakeCredentials(passwordUser(t, "ann@example.com", "correct horse"))
+	ctx := context.Background()
+
+	_, unknown := verifyCredentials(ctx, store, "bob@example.com", "correct horse", false)
+	_, wrong := verifyCredentials(ctx, store, "ann@example.com", "battery staple", false)
+	if !errors.Is(unknown, errInvalidCredentials) || !errors.Is(wrong, errInvalidCredentials) {
+		t.Fatalf("unknown email: %v, wrong password: %v, want both errInvalidCredentials", unknown, wrong)
+	}
+	if reasonOf(t, unknown) == reasonOf(t, wrong) {
+		t.Error("the audit trail cannot tell unknown emails from wrong passwords")
+	}
+
+	unknownCode, unknownBody, unknownHeader := credentialResponse(unknown)
+	wrongCode, wrongBody, wrongHeader := credentialResponse(wrong)
+	if unknownCode != http.StatusUnauthorized || unknownCode != wrongCode || unknownBody != wrongBody {
+		t.Errorf("unknown email answered %d %s, wrong password %d %s, want the same 401", unknownCode, unknownBody, wrongCode, wrongBody)
+	}
+	if !reflect.DeepEqual(unknownHeader, wrongHeader) {
+		t.Errorf("unknown email answered with headers %v, wrong password %v, want the same", unknownHeader, wrongHeader)
+	}
+}
+
+func TestDeletedAccountIsUnknown(t *testing.T) {
+	ann := passwordUser(t, "ann@example.com", "correct horse")
+	store := newFakeCredentials(ann)
+	delete(store.users, ann.Email)
+
+	user, err := verifyCredentials(context.Background(), store, "ann@example.com", "correct horse", false)
+	if user != nil || !errors.Is(err, errInvalidCredentials) || reasonOf(t, err) != "unknown_email" {
+		t.Fatalf("deleted account = %v, %v, want no user and an unknown email", user, err)
+	}
+}
+
+func TestAccountWithoutPasswordCannotSignIn(t *testing.T) {
+	// Accounts of an identity provider have no password
+	store := newFakeCredentials(&model.User{ID: primitive.NewObjectID(), Email: "ann@example.com", Verified: true, Provider: model.ProviderGoogle})
+
+	for _, password := range []string{"", "correct horse"} {
+		_, err := verifyCredentials(context.Background(), store, "ann@example.com", password, false)
+		if !errors.Is(err, errInvalidCredentials) {
+			t.Errorf("password %q = %v, want errInvalidCredentials", password, err)
+		}
+	}
+}
+
+func TestUnverifiedAccount(t *testing.T) {
+	ann := passwordUser(t, "ann@example.com", "correct horse")
+	ann.Verified = false
+	store := newFakeCredentials(ann)
+	ctx := context.Background()
+
+	if _, err := verifyCredentials(ctx, store, "ann@example.com", "correct horse", true); !errors.Is(err, errEmailNotVerified) {
+		t.Errorf("right password = %v, want errEmailNotVerified", err)
+	}
+	if code, _, _ := credentialResponse(&credentialError{err: errEmailNotVerified}); code != http.StatusForbidden {
+		t.Errorf("unverified account answered %d, want 403", code)
+	}
+	// Only the password holder learns the account is unverified
+	if _, err := verifyCredentials(ctx, store, "ann@example.com", "battery staple", true); !errors.Is(err, errInvalidCredentials) {
+		t.Errorf("wrong password = %v, want errInvalidCredentials", err)
+	}
+	if _, err := verifyCredentials(ctx, store, "ann@example.com", "correct horse", false); err != nil {
+		t.Errorf("right password without verification enforced = %v", err)
+	}
+}
+
+func TestLegacyPasswordIsUpgradedOnSignIn(t *testing.T) {
+	ann := &model.User{ID: primitive.NewObjectID(), Email: "ann@example.com", Password: "correct horse", Verified: true}
+	store := newFakeCredentials(ann)
+
+	if _, err := verifyCredentials(context.Background(), store, "ann@example.com", "correct horse", false); err != nil {
+		t.Fatalf("legacy row: %v", err)
+	}
+	hash, ok := store.upgrades[ann.ID]
+	if !ok || !utils.IsPasswordHash(hash) {
+		t.Fatalf("stored %q, want a bcrypt hash", hash)
+	}
+	if ok, _ := utils.CheckPassword(hash, "correct horse"); !ok {
+		t.Error("upgraded hash does not match the password")
+	}
+}
+
+func TestStoreFailureIsNotACredentialError(t *testing.T) {
+	store := newFakeCredentials()
+	store.err = errors.New("connection refused")
+
+	_, err := verifyCredentials(context.Background(), store, "ann@example.com", "correct horse", false)
+	var credErr *credentialError
+	if !errors.Is(err, store.err) || errors.As(err, &credErr) {
+		t.Fatalf("store failure = %v, want the store's error", err)
+	}
+}
+
+// TestLockedOutLoginIsRefused checks that once an email ran out of login
+// attempts even the right password is refused, before the user is looked up.
+func TestLockedOutLoginIsRefused(t *testing.T) {
+	emailLimiter := &ratelimit.Limiter{Store: ratelimit.NewMemoryStore(), Prefix: "login:email:", Limit: 1, Window: time.Minute}
+	if allowed, _, _ := emailLimiter.Allow(context.Background(), "ann@example.com"); !allowed {
+		t.Fatal("first attempt refused")
+	}
+	// Without a user repository, reaching the lookup would panic
+	h := AuthHandler{
+		LoginEmailLimiter: emailLimiter,
+		LoginIPLimiter:    &ratelimit.Limiter{},
+		AuditRepository:   discardAudit(t),
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/login", h.LoginUser)
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"email":"Ann@Example.com","password":"correct horse"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), apierror.CodeRateLimited) {
+		t.Errorf("locked out login: status %d %s, want 429 %s", rec.Code, rec.Body, apierror.CodeRateLimited)
+	}
+	if strings.Contains(rec.Body.String(), "access_token") {
+		t.Errorf("locked out login got a token: %s", rec.Body)
+	}
+}
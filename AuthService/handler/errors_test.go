@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"auth-service/apierror"
+	"auth-service/config"
+	"auth-service/ratelimit"
+	"auth-service/redis"
+	"auth-service/utils"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// TestFailuresUseErrorEnvelope checks that every failure answers with the
+// {"error": {"code", "message"}} body and nothing else.
+func TestFailuresUseErrorEnvelope(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	if err := config.Secrets.Load(); err != nil {
+		t.Fatalf("loading secrets: %v", err)
+	}
+
+	// ann@example.com has run out of login attempts
+	emailLimiter := &ratelimit.Limiter{Store: ratelimit.NewMemoryStore(), Prefix: "login:email:", Limit: 1, Window: time.Minute}
+	emailLimiter.Allow(context.Background(), "ann@example.com")
+	h := AuthHandler{
+		RedisClient:       redis.NewRedisClient(miniredis.RunT(t).Addr(), ""),
+		AuditRepository:   discardAudit(t),
+		LoginEmailLimiter: emailLimiter,
+		LoginIPLimiter:    &ratelimit.Limiter{},
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/register", h.RegisterUser)
+	router.POST("/auth/login", h.LoginUser)
+	router.POST("/auth/logout", h.Logout)
+	router.GET("/auth/authenticate", h.AuthenticateRequest)
+
+	revoked, err := utils.CreateToken("user-1", "a@example.com", "alice", "acme", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := request(router, http.MethodPost, "/auth/logout", revoked); rec.Code != http.StatusNoContent {
+		t.Fatalf("logout: status %d: %s", rec.Code, rec.Body)
+	}
+	ttl := config.TokenConfig.AccessTokenTTL
+	config.TokenConfig.AccessTokenTTL = -time.Hour
+	expired, err := utils.CreateToken("user-1", "a@example.com", "alice", "acme", "", "")
+	config.TokenConfig.AccessTokenTTL = ttl
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		method, path  string
+		authorization string
+		body          string
+		wantStatus    int
+		wantCode      string
+	}{
+		{"register with malformed JSON", http.MethodPost, "/auth/register", "", `{"email":`, http.StatusBadRequest, apierror.CodeInvalidRequest},
+		{"register with invalid fields", http.MethodPost, "/auth/register", "", `{"username":"a","email":"nope","password":"short"}`, http.StatusUnprocessableEntity, apierror.CodeValidationFailed},
+		{"login with malformed JSON", http.MethodPost, "/auth/login", "", `[]`, http.StatusBadRequest, apierror.CodeInvalidRequest},
+		{"login locked out", http.MethodPost, "/auth/login", "", `{"email":"ann@example.com","password":"correct horse"}`, http.StatusTooManyRequests, apierror.CodeRateLimited},
+		{"authenticate without a token", http.MethodGet, "/auth/authenticate", "", "", http.StatusUnauthorized, apierror.CodeTokenMissing},
+		{"authenticate with a malformed header", http.MethodGet, "/auth/authenticate", "Basic dXNlcjpwYXNz", "", http.StatusBadRequest, apierror.CodeTokenMalformed},
+		{"authenticate with a forged token", http.MethodGet, "/auth/authenticate", "Bearer not-a-token", "", http.StatusUnauthorized, apierror.CodeTokenInvalid},
+		{"authenticate with an expired token", http.MethodGet, "/auth/authenticate", "Bearer " + expired, "", http.StatusUnauthorized, apierror.CodeTokenExpired},
+		{"authenticate with a revoked token", http.MethodGet, "/auth/authenticate", "Bearer " + revoked, "", http.StatusUnauthorized, apierror.CodeTokenRevoked},
+		{"logout without a token", http.MethodPost, "/auth/logout", "", "", http.StatusUnauthorized, apierror.CodeTokenMissing},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.wantStatus, rec.Body)
+			continue
+		}
+		if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+			t.Errorf("%s: Content-Type %q, want JSON", tt.name, got)
+		}
+		var body apierror.Response
+		decoder := json.NewDecoder(bytes.NewReader(rec.Body.Bytes()))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&body); err != nil {
+			t.Errorf("%s: body %s is not the error envelope: %v", tt.name, rec.Body, err)
+			continue
+		}
+		if body.Error.Code != tt.wantCode || body.Error.Message == "" {
+			t.Errorf("%s: error %+v, want code %s and a message", tt.name, body.Error, tt.wantCode)
+		}
+		if tt.wantCode == apierror.CodeValidationFailed && len(body.Error.Fields) != 3 {
+			t.Errorf("%s: fields %+v, want username, email, and password", tt.name, body.Error.Fields)
+		}
+	}
+}
@@ -1,13 +1,62 @@
 package handler
 
 import (
-	"fmt"
+	"auth-service/redis"
+	"context"
+	"log"
 	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// readinessTimeout bounds each dependency check so a probe cannot hang.
+const readinessTimeout = 500 * time.Millisecond
+
 type HealthHandler struct {
+	MongoClient *mongo.Client
+	RedisClient *redis.RedisClient
 }
 
-func (h HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(w, "Service is OK")
+// ReadinessResponse reports each dependency as "ok" or "unavailable".
+type ReadinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Health reports that the process is up, without checking dependencies.
+func (h HealthHandler) Health(c *gin.Context) {
+	c.String(http.StatusOK, "Service is OK")
+}
+
+// Ready returns 200 when MongoDB and Redis answer a ping, and 503 naming the
+// failing dependency otherwise, so no login traffic is routed to an instance
+// that cannot serve it.
+func (h HealthHandler) Ready(c *gin.Context) {
+	checks := map[string]func(ctx context.Context) error{
+		"mongo": func(ctx context.Context) error { return h.MongoClient.Ping(ctx, nil) },
+		"redis": func(ctx context.Context) error { return h.RedisClient.Client.Ping(ctx).Err() },
+	}
+
+	response := ReadinessResponse{Status: "ok", Checks: make(map[string]string, len(checks))}
+	for name, check := range checks {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+		err := check(ctx)
+		cancel()
+
+		if err != nil {
+			log.Printf("[Ready] %s is unavailable: %v", name, err)
+			response.Status = "unavailable"
+			response.Checks[name] = "unavailable"
+			continue
+		}
+		response.Checks[name] = "ok"
+	}
+
+	status := http.StatusOK
+	if response.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, response)
 }
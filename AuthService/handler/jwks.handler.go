@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"auth-service/apierror"
+	"auth-service/config"
+	"log"
+	"net/http"
+	"shared/jwks"
+
+	"github.com/gin-gonic/gin"
+)
+
+type JWKSHandler struct{}
+
+// Keys serves the public keys access tokens are signed with, for services
+// that verify tokens locally. The set is empty while tokens are signed with HS256.
+func (h JWKSHandler) Keys(c *gin.Context) {
+	keys, useRSA, err := config.RSAKeys()
+	if err != nil {
+		log.Printf("[JWKS] Error loading RSA keys: %v", err)
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error")
+		return
+	}
+
+	set := jwks.Set{Keys: []jwks.JWK{}}
+	if useRSA {
+		for kid, pub := range keys.Public {
+			set.Keys = append(set.Keys, jwks.NewJWK(kid, pub))
+		}
+	}
+
+	// Verifiers refetch on unknown kids, so a short cache is enough
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, set)
+}
@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"auth-service/apierror"
+	"auth-service/config"
+	"auth-service/model"
+	"auth-service/oauth"
+	"auth-service/repository"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"shared/authz"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OAuthHandler signs users in with Google. The tokens it issues are the same
+// as those of a password login, so other services cannot tell them apart.
+type OAuthHandler struct {
+	Google oauth.Provider
+
+	// Auth stores the sign-in state and issues the tokens
+	Auth AuthHandler
+}
+
+// GoogleLogin sends the browser to Google's sign-in page.
+func (h OAuthHandler) GoogleLogin(c *gin.Context) {
+	state, err := oauth.NewState()
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error")
+		return
+	}
+	verifier, err := oauth.NewCodeVerifier()
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := h.Auth.RedisClient.SaveOAuthState(ctx, state, verifier, config.GoogleOAuthConfig.StateTTL); err != nil {
+		log.Printf("[GoogleLogin] %v", err)
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error")
+		return
+	}
+
+	c.Redirect(http.StatusFound, h.Google.AuthCodeURL(state, oauth.CodeChallenge(verifier)))
+}
+
+// GoogleCallback finishes a Google sign-in: it redeems the code, finds or
+// creates the account with Google's verified email, and signs the user in.
+func (h OAuthHandler) GoogleCallback(c *gin.Context) {
+	if c.Query("error") != "" {
+		apierror.Abort(c, http.StatusUnauthorized, apierror.CodeOAuthFailed, "Google sign-in was cancelled or denied")
+		return
+	}
+	state, code := c.Query("state"), c.Query("code")
+	if state == "" || code == "" {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "state and code query parameters are required")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 15*time.Second)
+	defer cancel()
+
+	// The state is single use, so a callback URL cannot be replayed
+	verifier, err := h.Auth.RedisClient.TakeOAuthState(ctx, state)
+	if err != nil {
+		log.Printf("[GoogleCallback] %v", err)
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error")
+		return
+	}
+	if verifier == "" {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidOAuthState, "Sign-in expired or was already used - Try again.")
+		return
+	}
+
+	identity, err := h.Google.Exchange(ctx, code, verifier)
+	if err != nil {
+		log.Printf("[GoogleCallback] %v", err)
+		apierror.Abort(c, http.StatusBadGateway, apierror.CodeOAuthFailed, "Could not complete Google sign-in")
+		return
+	}
+	if identity.Email == "" || !identity.EmailVerified {
+		apierror.Abort(c, http.StatusForbidden, apierror.CodeEmailNotVerified, "Your Google account's email address is not verified")
+		return
+	}
+
+	user, err := h.googleUser(ctx, identity)
+	if errors.Is(err, errAccountNotLinkable) {
+		h.Auth.recordLogin(c, model.LoginEventFailed, user, identity.Email, "oauth_not_linkable")
+		apierror.Abort(c, http.StatusConflict, apierror.CodeAccountExists, "An account with this email already exists - Log in with your password.")
+		return
+	}
+	if err != nil {
+		log.Printf("[GoogleCallback] %v", err)
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Error signing you in - Try again.")
+		return
+	}
+
+	response, err := h.Auth.issueTokens(ctx, c, user, "", "")
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Error signing you in - Try again.")
+		return
+	}
+	h.Auth.recordLogin(c, model.LoginEventSucceeded, user, user.Email, "")
+
+	// A browser navigated here, so send it on with the session cookie
+	if config.GoogleOAuthConfig.SuccessURL != "" {
+		setSessionCookie(c, response.AccessToken, int(response.ExpiresIn))
+		c.Redirect(http.StatusFound, config.GoogleOAuthConfig.SuccessURL)
+		return
+	}
+	writeTokens(c, response)
+}
+
+// errAccountNotLinkable is returned by googleUser when an account with the
+// email exists but cannot be linked to the Google identity automatically.
+var errAccountNotLinkable = errors.New("account cannot be linked")
+
+// googleUser returns the account of identity, creating it on first sign-in.
+// An existing password account is linked only when its email is verified:
+// otherwise whoever registered it never proved they own the address and
+// could keep using their password on the linked account.
+func (h OAuthHandler) googleUser(ctx context.Context, identity oauth.Identity) (*model.User, error) {
+	user, err := h.Auth.UserRepository.FindUserByEmail(ctx, identity.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	if user != nil {
+		switch {
+		case user.GoogleID == identity.Subject:
+			return user, nil
+		case user.GoogleID != "" || !user.Verified:
+			return user, errAccountNotLinkable
+		}
+		if err := h.Auth.UserRepository.LinkGoogle(ctx, user.ID, identity.Subject); err != nil {
+			return nil, err
+		}
+		log.Printf("[GoogleCallback] Linked Google account to user %s", user.ID.Hex())
+		user.GoogleID, user.Verified = identity.Subject, true
+		return user, nil
+	}
+
+	newUser := model.User{
+		Username: googleUsername(identity),
+		Email:    identity.Email,
+		Role:     authz.RoleUser,
+		Verified: true,
+		Provider: model.ProviderGoogle,
+		GoogleID: identity.Subject,
+	}
+	if bootstrap := config.AdminConfig.BootstrapEmail; bootstrap != "" && repository.NormalizeEmail(bootstrap) == repository.NormalizeEmail(newUser.Email) {
+		newUser.Role = authz.RoleAdmin
+	}
+
+	// The derived username may be taken; fall back to a numbered variant
+	for attempt := 0; attempt < 3; attempt++ {
+		created, err := h.Auth.UserRepository.CreateUser(ctx, newUser)
+		if errors.Is(err, repository.ErrUsernameTaken) {
+			suggestion, err := h.Auth.UserRepository.SuggestUsername(ctx, newUser.Username[:min(len(newUser.Username), 29)])
+			if err != nil {
+				return nil, err
+			}
+			if suggestion == "" {
+				break
+			}
+			newUser.Username = suggestion
+			continue
+		}
+		if errors.Is(err, repository.ErrEmailAlreadyRegistered) {
+			// Registered concurrently; sign in with that account instead
+			return h.googleUser(ctx, identity)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &created, nil
+	}
+	return nil, fmt.Errorf("no free username for %q", newUser.Username)
+}
+
+// googleUsername derives a username that passes ValidateUsername from the
+// Google profile name, or the email's local part when there is none.
+func googleUsername(identity oauth.Identity) string {
+	source := identity.Name
+	if source == "" {
+		source, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	var b strings.Builder
+	for _, r := range source {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '_' || r == '.' || r == '-':
+			if b.Len() > 0 {
+				b.WriteRune(r)
+			}
+		case r == ' ':
+			if b.Len() > 0 {
+				b.WriteRune('.')
+			}
+		}
+	}
+
+	username := b.String()
+	if len(username) > 32 {
+		username = username[:32]
+	}
+	if len(username) < 3 {
+		username = "user" + username
+	}
+	return username
+}
@@ -0,0 +1,311 @@
+package handler
+
+import (
+	"auth-service/apierror"
+	"auth-service/config"
+	"auth-service/mailer"
+	"auth-service/model"
+	"auth-service/oauth"
+	"auth-service/redis"
+	"auth-service/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fakeProvider signs everyone in as identity, or fails with err. It keeps
+// the codes and verifiers it was asked to redeem.
+type fakeProvider struct {
+	mu        sync.Mutex
+	identity  oauth.Identity
+	err       error
+	exchanges [][2]string
+}
+
+func (p *fakeProvider) AuthCodeURL(state string, codeChallenge string) string {
+	return "https://accounts.example.com/auth?" + url.Values{"state": {state}, "code_challenge": {codeChallenge}}.Encode()
+}
+
+func (p *fakeProvider) Exchange(ctx context.Context, code string, codeVerifier string) (oauth.Identity, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.exchanges = append(p.exchanges, [2]string{code, codeVerifier})
+	return p.identity, p.err
+}
+
+func (p *fakeProvider) exchanged() [][2]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([][2]string(nil), p.exchanges...)
+}
+
+func oauthRouter(h AuthHandler, provider oauth.Provider) *gin.Engine {
+	oauthHandler := OAuthHandler{Google: provider, Auth: h}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/auth/oauth/google/login", oauthHandler.GoogleLogin)
+	router.GET("/auth/oauth/google/callback", oauthHandler.GoogleCallback)
+	router.POST("/auth/login", h.LoginUser)
+	return router
+}
+
+// redisOnlyRouter serves the OAuth routes with sign-in state in miniredis
+// and no database, which callbacks refused early never reach.
+func redisOnlyRouter(t *testing.T, provider oauth.Provider) *gin.Engine {
+	t.Helper()
+	return oauthRouter(AuthHandler{RedisClient: redis.NewRedisClient(miniredis.RunT(t).Addr(), ""), AuditRepository: discardAudit(t)}, provider)
+}
+
+// startGoogleLogin starts a sign-in and returns where the browser was sent.
+func startGoogleLogin(t *testing.T, router *gin.Engine) url.Values {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/oauth/google/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: %d %s, want a redirect", rec.Code, rec.Body)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location.Query()
+}
+
+func callback(router *gin.Engine, query url.Values) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/oauth/google/callback?"+query.Encode(), nil))
+	return rec
+}
+
+// signInWithGoogle runs a whole sign-in, the provider redeeming code.
+func signInWithGoogle(t *testing.T, router *gin.Engine) *httptest.ResponseRecorder {
+	t.Helper()
+	state := startGoogleLogin(t, router).Get("state")
+	return callback(router, url.Values{"state": {state}, "code": {"the-code"}})
+}
+
+func TestGoogleLoginUsesPKCE(t *testing.T) {
+	// Unverified, so the callback stops before the database
+	provider := &fakeProvider{identity: oauth.Identity{Subject: "g-1", Email: "ann@example.com"}}
+	router := redisOnlyRouter(t, provider)
+
+	first, second := startGoogleLogin(t, router), startGoogleLogin(t, router)
+	if first.Get("state") == "" || first.Get("state") == second.Get("state") {
+		t.Errorf("states %q and %q, want a fresh one per sign-in", first.Get("state"), second.Get("state"))
+	}
+	if first.Get("code_challenge") == "" || first.Get("code_challenge") == second.Get("code_challenge") {
+		t.Errorf("challenges %q and %q, want a fresh one per sign-in", first.Get("code_challenge"), second.Get("code_challenge"))
+	}
+
+	callback(router, url.Values{"state": {first.Get("state")}, "code": {"the-code"}})
+	exchanges := provider.exchanged()
+	if len(exchanges) != 1 || exchanges[0][0] != "the-code" {
+		t.Fatalf("exchanged %v, want the code once", exchanges)
+	}
+	if got := oauth.CodeChallenge(exchanges[0][1]); got != first.Get("code_challenge") {
+		t.Errorf("redeemed with a verifier whose challenge is %q, want the login's %q", got, first.Get("code_challenge"))
+	}
+}
+
+func TestGoogleCallbackRefusals(t *testing.T) {
+	verified := oauth.Identity{Subject: "g-1", Email: "ann@example.com", EmailVerified: true}
+	tests := []struct {
+		name       string
+		provider   *fakeProvider
+		query      func(state string) url.Values
+		wantStatus int
+		wantCode   string
+		exchanges  int
+	}{
+		{"denied at Google", &fakeProvider{identity: verified},
+			func(state string) url.Values { return url.Values{"state": {state}, "error": {"access_denied"}} },
+			http.StatusUnauthorized, apierror.CodeOAuthFailed, 0},
+		{"no code", &fakeProvider{identity: verified},
+			func(state string) url.Values { return url.Values{"state": {state}} },
+			http.StatusBadRequest, apierror.CodeInvalidRequest, 0},
+		{"unknown state", &fakeProvider{identity: verified},
+			func(string) url.Values { return url.Values{"state": {"forged"}, "code": {"the-code"}} },
+			http.StatusBadRequest, apierror.CodeInvalidOAuthState, 0},
+		{"exchange failed", &fakeProvider{err: errors.New("invalid_grant")},
+			func(state string) url.Values { return url.Values{"state": {state}, "code": {"the-code"}} },
+			http.StatusBadGateway, apierror.CodeOAuthFailed, 1},
+		{"unverified email", &fakeProvider{identity: oauth.Identity{Subject: "g-1", Email: "ann@example.com"}},
+			func(state string) url.Values { return url.Values{"state": {state}, "code": {"the-code"}} },
+			http.StatusForbidden, apierror.CodeEmailNotVerified, 1},
+		{"no email", &fakeProvider{identity: oauth.Identity{Subject: "g-1", EmailVerified: true}},
+			func(state string) url.Values { return url.Values{"state": {state}, "code": {"the-code"}} },
+			http.StatusForbidden, apierror.CodeEmailNotVerified, 1},
+	}
+	for _, tt := range tests {
+		router := redisOnlyRouter(t, tt.provider)
+		state := startGoogleLogin(t, router).Get("state")
+
+		rec := callback(router, tt.query(state))
+		if rec.Code != tt.wantStatus || errorCode(rec) != tt.wantCode {
+			t.Errorf("%s: %d %s, want %d %s", tt.name, rec.Code, rec.Body, tt.wantStatus, tt.wantCode)
+		}
+		if n := len(tt.provider.exchanged()); n != tt.exchanges {
+			t.Errorf("%s: %d exchanges, want %d", tt.name, n, tt.exchanges)
+		}
+	}
+}
+
+func TestOAuthStateIsSingleUse(t *testing.T) {
+	provider := &fakeProvider{identity: oauth.Identity{Subject: "g-1", Email: "ann@example.com"}}
+	router := redisOnlyRouter(t, provider)
+	query := url.Values{"state": {startGoogleLogin(t, router).Get("state")}, "code": {"the-code"}}
+
+	callback(router, query)
+	rec := callback(router, query)
+	if rec.Code != http.StatusBadRequest || errorCode(rec) != apierror.CodeInvalidOAuthState {
+		t.Errorf("replayed callback: %d %s, want 400 %s", rec.Code, rec.Body, apierror.CodeInvalidOAuthState)
+	}
+	if n := len(provider.exchanged()); n != 1 {
+		t.Errorf("%d exchanges, want the replay refused before redeeming", n)
+	}
+}
+
+func TestGoogleUsername(t *testing.T) {
+	tests := []struct {
+		identity oauth.Identity
+		want     string
+	}{
+		{oauth.Identity{Name: "Ann Lee", Email: "ann@example.com"}, "Ann.Lee"},
+		{oauth.Identity{Name: "Zoë O'Brien", Email: "zoe@example.com"}, "Zo.OBrien"},
+		{oauth.Identity{Email: "ann.lee@example.com"}, "ann.lee"},
+		{oauth.Identity{Name: "._Ann", Email: "ann@example.com"}, "Ann"},
+		{oauth.Identity{Name: "李", Email: "li@example.com"}, "user"},
+		{oauth.Identity{Name: "Al", Email: "al@example.com"}, "userAl"},
+		{oauth.Identity{Name: "Bartholomew Maximilian Fitzgerald III", Email: "b@example.com"}, "Bartholomew.Maximilian.Fitzgeral"},
+	}
+	for _, tt := range tests {
+		if got := googleUsername(tt.identity); got != tt.want {
+			t.Errorf("googleUsername(%q, %q) = %q, want %q", tt.identity.Name, tt.identity.Email, got, tt.want)
+		}
+	}
+}
+
+// storedUser returns the account registered with email.
+func storedUser(t *testing.T, users *mongo.Collection, email string) model.User {
+	t.Helper()
+	var user model.User
+	if err := users.FindOne(context.Background(), bson.M{"email": email}).Decode(&user); err != nil {
+		t.Fatalf("finding %s: %v", email, err)
+	}
+	return user
+}
+
+func TestGoogleSignInCreatesTheAccount(t *testing.T) {
+	h, users := testAuthHandler(t, mailer.LogMailer{})
+	router := oauthRouter(h, &fakeProvider{identity: oauth.Identity{Subject: "g-1", Email: "Ann@Example.com", EmailVerified: true, Name: "Ann Lee"}})
+
+	rec := signInWithGoogle(t, router)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback: %d %s", rec.Code, rec.Body)
+	}
+	var tokens TokenResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &tokens); err != nil {
+		t.Fatal(err)
+	}
+
+	user := storedUser(t, users, "ann@example.com")
+	if user.Provider != model.ProviderGoogle || user.GoogleID != "g-1" || !user.Verified || user.Password != "" || user.Username != "Ann.Lee" {
+		t.Errorf("created %+v, want a verified Google account without a password", user)
+	}
+	// The same tokens as a password login
+	claims, err := utils.ParseToken(tokens.AccessToken)
+	if err != nil || claims.UserID != user.ID.Hex() || claims.Email != "ann@example.com" {
+		t.Errorf("access token for %+v (%v), want ann's", claims, err)
+	}
+
+	// Signing in again finds the account
+	if rec := signInWithGoogle(t, router); rec.Code != http.StatusOK {
+		t.Errorf("second sign-in: %d %s", rec.Code, rec.Body)
+	}
+	if n, _ := users.CountDocuments(context.Background(), bson.M{}); n != 1 {
+		t.Errorf("%d accounts, want 1", n)
+	}
+
+	// Without a password, the password login refuses the account
+	if rec := post(router, "/auth/login", `{"email":"ann@example.com","password":""}`); rec.Code == http.StatusOK {
+		t.Error("password login succeeded for a Google account")
+	}
+}
+
+func TestGoogleSignInLinksOnlyVerifiedAccounts(t *testing.T) {
+	h, users := testAuthHandler(t, mailer.LogMailer{})
+	register(mailingRouterFor(h), "ann", "ann@example.com")
+	router := oauthRouter(h, &fakeProvider{identity: oauth.Identity{Subject: "g-1", Email: "ann@example.com", EmailVerified: true}})
+
+	// Whoever registered the address never proved they own it
+	rec := signInWithGoogle(t, router)
+	if rec.Code != http.StatusConflict || errorCode(rec) != apierror.CodeAccountExists {
+		t.Errorf("unverified account: %d %s, want 409 %s", rec.Code, rec.Body, apierror.CodeAccountExists)
+	}
+	if user := storedUser(t, users, "ann@example.com"); user.GoogleID != "" {
+		t.Errorf("unverified account linked to %s", user.GoogleID)
+	}
+
+	if _, err := users.UpdateOne(context.Background(), bson.M{"email": "ann@example.com"}, bson.M{"$set": bson.M{"verified": true}}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := signInWithGoogle(t, router); rec.Code != http.StatusOK {
+		t.Fatalf("verified account: %d %s, want 200", rec.Code, rec.Body)
+	}
+	if user := storedUser(t, users, "ann@example.com"); user.GoogleID != "g-1" {
+		t.Errorf("linked to %q, want g-1", user.GoogleID)
+	}
+	// The password still works
+	if rec := post(router, "/auth/login", `{"email":"ann@example.com","password":"correct horse"}`); rec.Code != http.StatusOK {
+		t.Errorf("password login once linked: %d %s", rec.Code, rec.Body)
+	}
+
+	// Another Google identity with the same email is not let in
+	other := oauthRouter(h, &fakeProvider{identity: oauth.Identity{Subject: "g-2", Email: "ann@example.com", EmailVerified: true}})
+	if rec := signInWithGoogle(t, other); rec.Code != http.StatusConflict {
+		t.Errorf("other Google identity: %d %s, want 409", rec.Code, rec.Body)
+	}
+}
+
+func TestGoogleSignInRedirectsWithTheSessionCookie(t *testing.T) {
+	h, _ := testAuthHandler(t, mailer.LogMailer{})
+	router := oauthRouter(h, &fakeProvider{identity: oauth.Identity{Subject: "g-1", Email: "ann@example.com", EmailVerified: true}})
+	saved := config.GoogleOAuthConfig.SuccessURL
+	config.GoogleOAuthConfig.SuccessURL = "https://app.example.com/documents"
+	t.Cleanup(func() { config.GoogleOAuthConfig.SuccessURL = saved })
+
+	rec := signInWithGoogle(t, router)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://app.example.com/documents" {
+		t.Fatalf("callback: %d to %q, want a redirect to the app", rec.Code, rec.Header().Get("Location"))
+	}
+	var session *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == config.CookieConfig.Name {
+			session = cookie
+		}
+	}
+	if session == nil || !session.HttpOnly {
+		t.Fatalf("cookies %v, want the HttpOnly session cookie", rec.Result().Cookies())
+	}
+	if _, err := utils.ParseToken(session.Value); err != nil {
+		t.Errorf("session cookie holds no valid token: %v", err)
+	}
+}
+
+// mailingRouterFor serves registration from h.
+func mailingRouterFor(h AuthHandler) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/register", h.RegisterUser)
+	return router
+}
@@ -0,0 +1,385 @@
+package handler
+
+import (
+	"auth-service/apierror"
+	"auth-service/config"
+	"auth-service/mailer"
+	"auth-service/ratelimit"
+	"auth-service/redis"
+	"auth-service/repository"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"shared/migrate"
+	"shared/model"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// registerRouter serves registration, login, verification and its resends,
+// password changes, and authentication from an empty database on the server
+// at MONGO_TEST_URI, with its indexes built and dropped when the test ends,
+// and the revocation lists in miniredis. Tests needing it are skipped without
+// one.
+func registerRouter(t *testing.T) (*gin.Engine, *mongo.Collection) {
+	t.Helper()
+	return mailingRouter(t, mailer.LogMailer{})
+}
+
+// mailingRouter is registerRouter sending its mail through mail.
+func mailingRouter(t *testing.T, mail mailer.Mailer) (*gin.Engine, *mongo.Collection) {
+	t.Helper()
+	h, users := testAuthHandler(t, mail)
+	verification := h.Verification
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/register", h.RegisterUser)
+	router.POST("/auth/login", h.LoginUser)
+	router.GET("/auth/verify", verification.VerifyEmail)
+	router.POST("/auth/verify/resend", verification.ResendVerification)
+	router.POST("/auth/password", h.ChangePassword)
+	router.GET("/auth/authenticate", h.AuthenticateRequest)
+	return router, users
+}
+
+// testAuthHandler returns a handler over an empty database on the server at
+// MONGO_TEST_URI, as registerRouter serves it, and its users.
+func testAuthHandler(t *testing.T, mail mailer.Mailer) (AuthHandler, *mongo.Collection) {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+	t.Setenv("JWT_SECRET", "test-secret")
+	if err := config.Secrets.Load(); err != nil {
+		t.Fatalf("loading secrets: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := client.Database(fmt.Sprintf("auth_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+	if _, err := migrate.New(db).Apply(ctx, false); err != nil {
+		t.Fatalf("building the indexes: %v", err)
+	}
+
+	users := repository.NewUserRepository(client, db.Name(), model.UserCollection, nil)
+	verification := VerificationHandler{
+		UserRepository:     users,
+		Mailer:             mail,
+		ResendEmailLimiter: &ratelimit.Limiter{},
+		ResendIPLimiter:    &ratelimit.Limiter{},
+	}
+	h := AuthHandler{
+		UserRepository:         users,
+		RefreshTokenRepository: repository.NewRefreshTokenRepository(client, db.Name(), model.RefreshTokenCollection, time.Hour),
+		SessionRepository:      repository.NewSessionRepository(client, db.Name(), model.SessionCollection, time.Hour),
+		RedisClient:            redis.NewRedisClient(miniredis.RunT(t).Addr(), ""),
+		Verification:           verification,
+		AuditRepository:        discardAudit(t),
+		LoginEmailLimiter:      &ratelimit.Limiter{},
+		LoginIPLimiter:         &ratelimit.Limiter{},
+	}
+	return h, db.Collection(model.UserCollection)
+}
+
+func register(router *gin.Engine, username string, email string) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"username":%q,"email":%q,"password":"correct horse"}`, username, email)
+	req := httptest.NewRequest(http.MethodPost, "/auth/register", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestDuplicateEmailIsConflict(t *testing.T) {
+	router, _ := registerRouter(t)
+
+	if rec := register(router, "ann", "ann@example.com"); rec.Code != http.StatusCreated {
+		t.Fatalf("first registration: status %d, want 201: %s", rec.Code, rec.Body)
+	}
+	// Emails are compared as stored, trimmed and lowercased
+	rec := register(router, "ann2", "Ann@Example.com")
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), apierror.CodeEmailTaken) {
+		t.Fatalf("second registration: status %d %s, want 409 %s", rec.Code, rec.Body, apierror.CodeEmailTaken)
+	}
+}
+
+func TestConcurrentRegistrationsCreateOneUser(t *testing.T) {
+	router, users := registerRouter(t)
+
+	const attempts = 8
+	codes := make([]int, attempts)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = register(router, fmt.Sprintf("bob%d", i), "bob@example.com").Code
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("registration: status %d, want 201 or 409", code)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d registrations succeeded, want 1", created)
+	}
+	n, err := users.CountDocuments(context.Background(), bson.M{"email": "bob@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("%d users with the email, want 1", n)
+	}
+}
+
+func post(router *gin.Engine, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestFailuresDoNotRevealAccounts checks that an unknown email and a known
+// one get identical answers, status, body, and headers alike, where that
+// would tell whether the account exists.
+func TestFailuresDoNotRevealAccounts(t *testing.T) {
+	router, _ := registerRouter(t)
+	if rec := register(router, "ann", "ann@example.com"); rec.Code != http.StatusCreated {
+		t.Fatalf("registration: status %d, want 201: %s", rec.Code, rec.Body)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		known      string
+		unknown    string
+		wantStatus int
+	}{
+		{
+			name:       "login with a wrong password",
+			path:       "/auth/login",
+			known:      `{"email":"ann@example.com","password":"battery staple"}`,
+			unknown:    `{"email":"bob@example.com","password":"battery staple"}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "verification resend",
+			path:       "/auth/verify/resend",
+			known:      `{"email":"ann@example.com"}`,
+			unknown:    `{"email":"bob@example.com"}`,
+			wantStatus: http.StatusAccepted,
+		},
+	}
+	for _, tt := range tests {
+		known := post(router, tt.path, tt.known)
+		unknown := post(router, tt.path, tt.unknown)
+		if known.Code != tt.wantStatus || unknown.Code != known.Code {
+			t.Errorf("%s: known email %d, unknown %d, want both %d", tt.name, known.Code, unknown.Code, tt.wantStatus)
+		}
+		if known.Body.String() != unknown.Body.String() {
+			t.Errorf("%s: known email answered %s, unknown %s", tt.name, known.Body, unknown.Body)
+		}
+		if !reflect.DeepEqual(known.Header(), unknown.Header()) {
+			t.Errorf("%s: known email answered with headers %v, unknown %v", tt.name, known.Header(), unknown.Header())
+		}
+		if strings.Contains(unknown.Body.String(), "bob@example.com") {
+			t.Errorf("%s: answer repeats the submitted email: %s", tt.name, unknown.Body)
+		}
+	}
+}
+
+func TestInvalidRegistrationListsFields(t *testing.T) {
+	// Refused before the repository
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/register", AuthHandler{}.RegisterUser)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantFields []string
+	}{
+		{"not JSON", `{"username":`, http.StatusBadRequest, nil},
+		{"empty", `{}`, http.StatusUnprocessableEntity, []string{"username", "email", "password"}},
+		{"bad email", `{"username":"alice","email":"alice","password":"correct horse"}`, http.StatusUnprocessableEntity, []string{"email"}},
+		{"weak password", `{"username":"alice","email":"alice@example.com","password":"short"}`, http.StatusUnprocessableEntity, []string{"password"}},
+	}
+	for _, tt := range tests {
+		rec := post(router, "/auth/register", tt.body)
+		var body apierror.Response
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		var got []string
+		for _, field := range body.Error.Fields {
+			got = append(got, field.Field)
+		}
+		if rec.Code != tt.wantStatus || !reflect.DeepEqual(got, tt.wantFields) {
+			t.Errorf("%s: status %d, fields %q, want %d %q", tt.name, rec.Code, got, tt.wantStatus, tt.wantFields)
+		}
+		if tt.wantStatus == http.StatusUnprocessableEntity && body.Error.Code != apierror.CodeValidationFailed {
+			t.Errorf("%s: code %q, want %s", tt.name, body.Error.Code, apierror.CodeValidationFailed)
+		}
+	}
+}
+
+func TestRegistrationIgnoresOtherFields(t *testing.T) {
+	router, users := registerRouter(t)
+
+	body := `{"username":"alice","email":" Alice@Example.com ","password":"correct horse","_id":"65a1f0c2e4b0a1b2c3d4e5f6","role":"admin","joinedAt":"2020-01-01T00:00:00Z"}`
+	rec := post(router, "/auth/register", body)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d %s, want 201", rec.Code, rec.Body)
+	}
+	var created CreatedResponse
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	if created.ID == "65a1f0c2e4b0a1b2c3d4e5f6" {
+		t.Error("registration kept the _id of the request")
+	}
+
+	var stored bson.M
+	if err := users.FindOne(context.Background(), bson.M{"email": "alice@example.com"}).Decode(&stored); err != nil {
+		t.Fatalf("user not stored under the normalized email: %v", err)
+	}
+	if stored["role"] == "admin" {
+		t.Error("registration kept the role of the request")
+	}
+	if joinedAt, ok := stored["joinedAt"].(primitive.DateTime); ok && joinedAt.Time().Year() == 2020 {
+		t.Error("registration kept the joinedAt of the request")
+	}
+}
+
+// signIn registers a verified user with password "correct horse" and logs
+// them in.
+func signIn(t *testing.T, router *gin.Engine, users *mongo.Collection, username string, email string) TokenResponse {
+	t.Helper()
+	if rec := register(router, username, email); rec.Code != http.StatusCreated {
+		t.Fatalf("registering %s: status %d: %s", email, rec.Code, rec.Body)
+	}
+	if _, err := users.UpdateOne(context.Background(), bson.M{"email": email}, bson.M{"$set": bson.M{"verified": true}}); err != nil {
+		t.Fatal(err)
+	}
+	rec := post(router, "/auth/login", fmt.Sprintf(`{"email":%q,"password":"correct horse"}`, email))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("logging in as %s: status %d: %s", email, rec.Code, rec.Body)
+	}
+	var tokens TokenResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &tokens); err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+// TestStoreFailuresAreInternalErrors checks that a database failure ends
+// registration and login with a 500 and nothing else in the body.
+func TestStoreFailuresAreInternalErrors(t *testing.T) {
+	// A database that is never found
+	mongoClient, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://127.0.0.1:1").SetServerSelectionTimeout(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := AuthHandler{
+		UserRepository:    repository.NewUserRepository(mongoClient, "test", model.UserCollection, nil),
+		AuditRepository:   discardAudit(t),
+		LoginEmailLimiter: &ratelimit.Limiter{},
+		LoginIPLimiter:    &ratelimit.Limiter{},
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/register", h.RegisterUser)
+	router.POST("/auth/login", h.LoginUser)
+
+	tests := []struct {
+		path string
+		body string
+		want string
+	}{
+		{"/auth/register", `{"username":"ann","email":"ann@example.com","password":"correct horse"}`, `{"error":{"code":"INTERNAL_ERROR","message":"Error creating user"}}`},
+		{"/auth/login", `{"email":"ann@example.com","password":"correct horse"}`, `{"error":{"code":"INTERNAL_ERROR","message":"Internal server error during database lookup"}}`},
+	}
+	for _, tt := range tests {
+		rec := post(router, tt.path, tt.body)
+		if rec.Code != http.StatusInternalServerError || rec.Body.String() != tt.want {
+			t.Errorf("%s: %d %s, want 500 %s", tt.path, rec.Code, rec.Body, tt.want)
+		}
+	}
+}
+
+// TestRegisterAndLoginBodies checks the exact answers of registration and
+// login, so no path writes past its first response.
+func TestRegisterAndLoginBodies(t *testing.T) {
+	router, users := registerRouter(t)
+
+	rec := register(router, "ann", "ann@example.com")
+	var stored struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := users.FindOne(context.Background(), bson.M{"email": "ann@example.com"}).Decode(&stored); err != nil {
+		t.Fatalf("user not stored: %v", err)
+	}
+	if want := `{"id":"` + stored.ID.Hex() + `"}`; rec.Code != http.StatusCreated || rec.Body.String() != want {
+		t.Errorf("registration: %d %s, want 201 %s", rec.Code, rec.Body, want)
+	}
+	users.UpdateOne(context.Background(), bson.M{"_id": stored.ID}, bson.M{"$set": bson.M{"verified": true}})
+
+	invalid := `{"error":{"code":"INVALID_CREDENTIALS","message":"Invalid email or password"}}`
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"register with bad JSON", "/auth/register", `{"username":`, http.StatusBadRequest, `{"error":{"code":"INVALID_REQUEST","message":"Invalid JSON data"}}`},
+		{"login with bad JSON", "/auth/login", `{"email":`, http.StatusBadRequest, `{"error":{"code":"INVALID_REQUEST","message":"Invalid json data format"}}`},
+		{"login with a wrong password", "/auth/login", `{"email":"ann@example.com","password":"battery staple"}`, http.StatusUnauthorized, invalid},
+		{"login with an unknown email", "/auth/login", `{"email":"bob@example.com","password":"correct horse"}`, http.StatusUnauthorized, invalid},
+	}
+	for _, tt := range tests {
+		if rec := post(router, tt.path, tt.body); rec.Code != tt.wantStatus || rec.Body.String() != tt.wantBody {
+			t.Errorf("%s: %d %s, want %d %s", tt.name, rec.Code, rec.Body, tt.wantStatus, tt.wantBody)
+		}
+	}
+
+	rec = post(router, "/auth/login", `{"email":"ann@example.com","password":"correct horse"}`)
+	var tokens TokenResponse
+	decoder := json.NewDecoder(rec.Body)
+	if err := decoder.Decode(&tokens); rec.Code != http.StatusOK || err != nil || tokens.AccessToken == "" {
+		t.Errorf("login: %d, %v, token %q, want 200 with a token", rec.Code, err, tokens.AccessToken)
+	}
+	if decoder.More() {
+		t.Error("login wrote past its token response")
+	}
+}
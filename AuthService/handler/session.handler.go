@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"auth-service/apierror"
+	"auth-service/config"
+	"auth-service/model"
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionResponse is a session as listed to its user.
+type SessionResponse struct {
+	model.Session
+	// Current marks the session the request was made from
+	Current bool `json:"current"`
+}
+
+// revokeSessions ends the given sessions for their refresh tokens and, through
+// the revocation list, for their access tokens. The session records themselves
+// are revoked by the caller.
+func (h AuthHandler) revokeSessions(ctx context.Context, ids ...string) error {
+	for _, id := range ids {
+		if err := h.RefreshTokenRepository.RevokeFamily(ctx, id); err != nil {
+			return err
+		}
+		if err := h.RedisClient.RevokeSession(ctx, id, config.TokenConfig.AccessTokenTTL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListSessions returns the caller's active sessions, most recently used first.
+func (h AuthHandler) ListSessions(c *gin.Context) {
+	claims, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	sessions, err := h.SessionRepository.FindActive(ctx, claims.UserID)
+	if err != nil {
+		log.Printf("[ListSessions] %v", err)
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Error retrieving sessions")
+		return
+	}
+
+	response := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, SessionResponse{Session: session, Current: session.ID == claims.SessionID})
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// RevokeSession ends one of the caller's sessions.
+func (h AuthHandler) RevokeSession(c *gin.Context) {
+	claims, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	id := c.Param("id")
+	found, err := h.SessionRepository.Revoke(ctx, claims.UserID, id)
+	if err != nil {
+		log.Printf("[RevokeSession] %v", err)
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Error revoking session")
+		return
+	}
+	if !found {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeSessionNotFound, "Session not found")
+		return
+	}
+
+	if err := h.revokeSessions(ctx, id); err != nil {
+		log.Printf("[RevokeSession] %v", err)
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Error revoking session")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// RevokeOtherSessions ends every session of the caller except the current one.
+func (h AuthHandler) RevokeOtherSessions(c *gin.Context) {
+	claims, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	ids, err := h.SessionRepository.RevokeOthers(ctx, claims.UserID, claims.SessionID)
+	if err == nil {
+		err = h.revokeSessions(ctx, ids...)
+	}
+	if err != nil {
+		log.Printf("[RevokeOtherSessions] %v", err)
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Error revoking sessions")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
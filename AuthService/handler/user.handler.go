@@ -1,59 +1,21 @@
-// package handler
-
-// import (
-// 	"auth-service/repository"
-// 	"context"
-// 	"encoding/json"
-// 	"net/http"
-// 	"time"
-// )
-
-// type UserDto struct {
-// 	ID       string `json:"id"`
-// 	Username string `json:"username"`
-// 	Email    string `json:"email"`
-// }
-
-// type UserHandler struct {
-// 	UserRepository *repository.UserRepository
-// }
-
-// func (h UserHandler) RetrieveSearchedUsers(w http.ResponseWriter, r *http.Request) {
-
-// 	if r.Method != http.MethodGet {
-// 		http.Error(w, "Only GET request allowed", http.StatusBadRequest)
-// 		return
-// 	}
-
-// 	params := r.URL.Query()
-// 	q := params.Get("q")
-// 	if q == "" {
-// 		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-// 		defer cancel()
-// 		users, err := h.UserRepository.FindAll(ctx)
-// 		if err != nil {
-// 			http.Error(w, "Error retrieving users", http.StatusInternalServerError)
-// 			return
-// 		}
-
-// 		userDtos := []UserDto{}
-// 		for _, user := range users {
-// 			userDto := UserDto{ID: user.ID.Hex(), Username: user.Username, Email: user.Email}
-// 			userDtos = append(userDtos, userDto)
-// 		}
-
-// 		json.NewEncoder(w).Encode(userDtos)
-// 	}
-// }
 package handler
 
 import (
+	"auth-service/apierror"
 	"auth-service/model"
 	"auth-service/repository"
+	"auth-service/types"
 	"context"
-	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"shared/tenant"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type UserDto struct {
@@ -63,60 +25,294 @@ type UserDto struct {
 }
 
 type UserHandler struct {
-	UserRepository *repository.UserRepository
+	UserRepository  *repository.UserRepository
+	AuditRepository *repository.AuditRepository
+
+	// Auth validates bearer tokens for the endpoints that need them
+	Auth AuthHandler
+}
+
+// GetCurrentUser returns the profile of the user the bearer token belongs to.
+func (h UserHandler) GetCurrentUser(c *gin.Context) {
+	claims, ok := h.Auth.authenticate(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.UserRepository.FindUserByID(ctx, claims.UserID)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error during database lookup")
+		return
+	}
+	if user == nil {
+		// The token outlived the account
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeUserNotFound, "User not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, types.NewUserProfile(*user))
+}
+
+// UpdateCurrentUser changes the caller's username, display name, or avatar
+// URL. The username is carried in access tokens, so the response includes a
+// new access token for the same session; live sessions should switch to it.
+func (h UserHandler) UpdateCurrentUser(c *gin.Context) {
+	claims, ok := h.Auth.authenticate(c)
+	if !ok {
+		return
+	}
+
+	var data types.UpdateProfileData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid json data format")
+		return
+	}
+	data.Normalize()
+	if fieldErrors := data.Validate(); len(fieldErrors) > 0 {
+		apierror.AbortValidation(c, fieldErrors)
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeUserNotFound, "User not found")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.UserRepository.UpdateUser(ctx, userID, repository.UserUpdate{
+		Username:    data.Username,
+		DisplayName: data.DisplayName,
+		AvatarURL:   data.AvatarURL,
+	})
+	if errors.Is(err, repository.ErrUsernameTaken) {
+		suggestion, suggestErr := h.UserRepository.SuggestUsername(ctx, *data.Username)
+		if suggestErr != nil {
+			log.Printf("[UpdateCurrentUser] Error suggesting a username: %v", suggestErr)
+		}
+		c.AbortWithStatusJSON(http.StatusConflict, apierror.Response{Error: apierror.Detail{
+			Code:       apierror.CodeUsernameTaken,
+			Message:    err.Error(),
+			Suggestion: suggestion,
+		}})
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Error updating profile")
+		return
+	}
+	if user == nil {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeUserNotFound, "User not found")
+		return
+	}
+
+	response, err := newTokenResponse(user, claims.SessionID, "")
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Profile updated, but reissuing your token failed - Log in again.")
+		return
+	}
+	writeTokens(c, response)
 }
 
-func (h UserHandler) RetrieveSearchedUsers(w http.ResponseWriter, r *http.Request) {
-	// 1. Validations
-	if r.Method != http.MethodGet {
-		http.Error(w, "Only GET request allowed", http.StatusBadRequest)
+// GetLoginHistory returns the caller's most recent login events, newest first.
+// ?limit= defaults to 20 and is capped at 100.
+func (h UserHandler) GetLoginHistory(c *gin.Context) {
+	claims, ok := h.Auth.authenticate(c)
+	if !ok {
 		return
 	}
 
-	// 2. Set JSON Header
-	w.Header().Set("Content-Type", "application/json")
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "20"), 10, 64)
+	if err != nil || limit < 1 {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "limit must be a positive integer")
+		return
+	}
+	if limit > 100 {
+		limit = 100
+	}
 
-	// 3. Setup Context
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 	defer cancel()
 
-	// 4. Get Query Params
-	params := r.URL.Query()
-	q := params.Get("q")
+	events, err := h.AuditRepository.FindByUser(ctx, claims.UserID, limit)
+	if err != nil {
+		log.Printf("[GetLoginHistory] %v", err)
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Error retrieving login history")
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
+
+type UsernameAvailability struct {
+	Name       string `json:"name"`
+	Available  bool   `json:"available"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+// UsernameAvailable lets the signup form check ?name= before submitting.
+// Registration still enforces uniqueness itself.
+func (h UserHandler) UsernameAvailable(c *gin.Context) {
+	name := strings.TrimSpace(c.Query("name"))
+	if fieldErr := types.ValidateUsername(name); fieldErr != nil {
+		fieldErr.Field = "name"
+		apierror.AbortValidation(c, []types.FieldError{*fieldErr})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	available, err := h.UserRepository.UsernameAvailable(ctx, name)
+	if err != nil {
+		log.Printf("[UsernameAvailable] %v", err)
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error during database lookup")
+		return
+	}
+
+	response := UsernameAvailability{Name: name, Available: available}
+	if !available {
+		if response.Suggestion, err = h.UserRepository.SuggestUsername(ctx, name); err != nil {
+			log.Printf("[UsernameAvailable] %v", err)
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// LookupUser returns the user with exactly the given email, for resolving a
+// collaborator before sharing. It accepts a user's bearer token or a signed
+// internal request, and only finds users in the caller's tenant.
+func (h UserHandler) LookupUser(c *gin.Context) {
+	tenantID, ok := h.Auth.authenticateCaller(c)
+	if !ok {
+		return
+	}
+
+	email := c.Query("email")
+	if email == "" {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "email query parameter is required")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.UserRepository.FindUserByEmail(ctx, email)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error during database lookup")
+		return
+	}
+	if user == nil || tenant.Normalize(user.TenantID) != tenantID {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeUserNotFound, "No user with this email")
+		return
+	}
+
+	c.JSON(http.StatusOK, UserDto{
+		ID:       user.ID.Hex(),
+		Username: user.Username,
+		Email:    user.Email,
+	})
+}
+
+// ResolvedUser is a user as returned by ResolveUsers, keyed by ID.
+type ResolvedUser struct {
+	Username string `json:"username"`
+	Email    string `json:"email,omitempty"`
+}
+
+// ResolveUsers maps up to 100 user IDs to usernames in one call, for showing
+// collaborators and presence. IDs of unknown users, or of users in another
+// tenant, are left out of the response. It accepts a user's bearer token or a
+// signed internal request.
+func (h UserHandler) ResolveUsers(c *gin.Context) {
+	tenantID, ok := h.Auth.authenticateCaller(c)
+	if !ok {
+		return
+	}
+
+	data := types.ResolveUsersData{}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid json data format")
+		return
+	}
+	if fields := data.Validate(); len(fields) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apierror.Response{Error: apierror.Detail{
+			Code:    apierror.CodeValidationFailed,
+			Message: "validation failed",
+			Fields:  fields,
+		}})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	users, err := h.UserRepository.FindUsersByIDs(ctx, data.ObjectIDs())
+	if err != nil {
+		log.Printf("[ResolveUsers] %v", err)
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error during database lookup")
+		return
+	}
+
+	resolved := make(map[string]ResolvedUser, len(users))
+	for _, user := range users {
+		if tenant.Normalize(user.TenantID) != tenantID {
+			continue
+		}
+		resolved[user.ID.Hex()] = ResolvedUser{Username: user.Username, Email: user.Email}
+	}
+	c.JSON(http.StatusOK, resolved)
+}
+
+// RetrieveSearchedUsers lists the users of the caller's tenant whose username
+// or email contains ?q=, or all of them without one. It accepts a user's
+// bearer token or a signed internal request.
+func (h UserHandler) RetrieveSearchedUsers(c *gin.Context) {
+	tenantID, ok := h.Auth.authenticateCaller(c)
+	if !ok {
+		return
+	}
+
+	// 1. Setup Context
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	// 2. Get Query Params
+	q := c.Query("q")
 
 	var users []model.User
 	var err error
 
-	// 5. Logic Branching
+	// 3. Logic Branching
 	if q == "" {
 		// If query is empty, get all users
-		users, err = h.UserRepository.FindAll(ctx)
+		users, err = h.UserRepository.FindAll(ctx, tenantID)
 	} else {
 		// If query exists, search for them
-		users, err = h.UserRepository.FindByQuery(ctx, q)
+		users, err = h.UserRepository.FindByQuery(ctx, tenantID, q)
 	}
 
-	// 6. Error Handling
+	// 4. Error Handling
 	if err != nil {
-		http.Error(w, "Error retrieving users", http.StatusInternalServerError)
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Error retrieving users")
 		return
 	}
 
-	// 7. Convert to DTOs
+	// 5. Convert to DTOs
 	userDtos := []UserDto{}
-	
-	// Ensure users is not nil before looping
-	if users != nil {
-		for _, user := range users {
-			userDto := UserDto{
-				ID:       user.ID.Hex(),
-				Username: user.Username,
-				Email:    user.Email,
-			}
-			userDtos = append(userDtos, userDto)
-		}
+	for _, user := range users {
+		userDtos = append(userDtos, UserDto{
+			ID:       user.ID.Hex(),
+			Username: user.Username,
+			Email:    user.Email,
+		})
 	}
 
-	// 8. Send Response (Handles [] case automatically)
-	json.NewEncoder(w).Encode(userDtos)
-}
\ No newline at end of file
+	// 6. Send Response (Handles [] case automatically)
+	c.JSON(http.StatusOK, userDtos)
+}
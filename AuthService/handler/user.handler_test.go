@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRetrieveSearchedUsersRequiresAuthentication(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	// Unauthenticated requests must not reach the repository
+	router.GET("/auth/users", UserHandler{}.RetrieveSearchedUsers)
+
+	for _, header := range []string{"", "Basic abc", "Bearer not-a-token"} {
+		req := httptest.NewRequest(http.MethodGet, "/auth/users?q=a", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusUnauthorized && rec.Code != http.StatusBadRequest {
+			t.Errorf("Authorization %q: status %d, want 401 or 400", header, rec.Code)
+		}
+		if strings.Contains(rec.Body.String(), "email") {
+			t.Errorf("Authorization %q: response lists users: %s", header, rec.Body.String())
+		}
+	}
+}
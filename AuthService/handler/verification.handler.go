@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"auth-service/apierror"
+	"auth-service/config"
+	"auth-service/mailer"
+	"auth-service/model"
+	"auth-service/ratelimit"
+	"auth-service/repository"
+	"auth-service/utils"
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VerificationHandler confirms that users own the email they registered with.
+type VerificationHandler struct {
+	UserRepository *repository.UserRepository
+	Mailer         mailer.Mailer
+
+	// Resends are limited per email and per client IP independently
+	ResendEmailLimiter *ratelimit.Limiter
+	ResendIPLimiter    *ratelimit.Limiter
+}
+
+// SendVerificationEmail mails user a link to GET /auth/verify.
+func (h VerificationHandler) SendVerificationEmail(ctx context.Context, user *model.User) error {
+	token, err := utils.CreatePurposeToken(user.ID.Hex(), user.Email, utils.PurposeVerifyEmail, config.VerificationConfig.TokenTTL)
+	if err != nil {
+		return err
+	}
+
+	link := config.VerificationConfig.URL + "?token=" + url.QueryEscape(token)
+	return h.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Canvas Live email address",
+		Body:    "Hi " + user.Username + ",\n\nConfirm your email address by opening this link:\n\n" + link + "\n\nThe link expires in " + config.VerificationConfig.TokenTTL.String() + ".\n",
+	})
+}
+
+// ================================================= Verify Email Handler ===========================================================================
+
+func (h VerificationHandler) VerifyEmail(c *gin.Context) {
+	claims, err := utils.ParsePurposeToken(c.Query("token"), utils.PurposeVerifyEmail)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidLink, "Invalid or expired verification link")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	matched, err := h.UserRepository.MarkEmailVerified(ctx, claims.UserID, claims.Email)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Error verifying email")
+		return
+	}
+	if !matched {
+		// The account was deleted or its email changed since the link was sent
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidLink, "Invalid or expired verification link")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"verified": true})
+}
+
+// ================================================= Resend Verification Handler ===========================================================================
+
+type ResendVerificationData struct {
+	Email string `json:"email"`
+}
+
+func (h VerificationHandler) ResendVerification(c *gin.Context) {
+	var resendData ResendVerificationData
+	if err := c.ShouldBindJSON(&resendData); err != nil || resendData.Email == "" {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid json data format")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	email := repository.NormalizeEmail(resendData.Email)
+	if !ratelimit.Guard(c,
+		ratelimit.Check{Limiter: h.ResendIPLimiter, Key: c.ClientIP()},
+		ratelimit.Check{Limiter: h.ResendEmailLimiter, Key: email},
+	) {
+		return
+	}
+
+	// The response is the same whether or not the account exists or is already
+	// verified, so the endpoint cannot be used to probe for registered emails
+	user, err := h.UserRepository.FindUserByEmail(ctx, email)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error during database lookup")
+		return
+	}
+	if user != nil && !user.Verified {
+		if err := h.SendVerificationEmail(ctx, user); err != nil {
+			log.Printf("[ResendVerification] Error sending verification email: %v", err)
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists and is unverified, a verification email has been sent"})
+}
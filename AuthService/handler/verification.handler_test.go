@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"auth-service/apierror"
+	"auth-service/config"
+	"auth-service/mailer"
+	"auth-service/model"
+	"auth-service/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// captureMailer keeps the messages it is asked to send, failing with err
+// when set.
+type captureMailer struct {
+	mu   sync.Mutex
+	sent []mailer.Message
+	err  error
+}
+
+func (m *captureMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return m.err
+}
+
+func (m *captureMailer) messages() []mailer.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]mailer.Message(nil), m.sent...)
+}
+
+// verificationLink returns the link to GET /auth/verify in msg.
+func verificationLink(t *testing.T, msg mailer.Message) *url.URL {
+	t.Helper()
+	for _, field := range strings.Fields(msg.Body) {
+		if strings.HasPrefix(field, config.VerificationConfig.URL+"?") {
+			link, err := url.Parse(field)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return link
+		}
+	}
+	t.Fatalf("no verification link in %q", msg.Body)
+	return nil
+}
+
+func verify(router *gin.Engine, link *url.URL) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/verify?"+link.RawQuery, nil))
+	return rec
+}
+
+func errorCode(rec *httptest.ResponseRecorder) string {
+	var body apierror.Response
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	return body.Error.Code
+}
+
+func TestVerificationEmailCarriesAToken(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	if err := config.Secrets.Load(); err != nil {
+		t.Fatalf("loading secrets: %v", err)
+	}
+	mail := &captureMailer{}
+	user := &model.User{ID: primitive.NewObjectID(), Username: "ann", Email: "ann@example.com"}
+
+	if err := (VerificationHandler{Mailer: mail}).SendVerificationEmail(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	sent := mail.messages()
+	if len(sent) != 1 || sent[0].To != "ann@example.com" || !strings.Contains(sent[0].Body, "Hi ann") {
+		t.Fatalf("sent %+v, want one message to ann", sent)
+	}
+
+	token := verificationLink(t, sent[0]).Query().Get("token")
+	claims, err := utils.ParsePurposeToken(token, utils.PurposeVerifyEmail)
+	if err != nil {
+		t.Fatalf("mailed token: %v", err)
+	}
+	if claims.UserID != user.ID.Hex() || claims.Email != "ann@example.com" {
+		t.Errorf("token for %s %s, want ann's", claims.UserID, claims.Email)
+	}
+	if _, err := utils.ParsePurposeToken(token, ""); err == nil {
+		t.Error("the verification token is accepted for another purpose")
+	}
+
+	// Failures to send reach the caller
+	failing := &captureMailer{err: errors.New("relay down")}
+	if err := (VerificationHandler{Mailer: failing}).SendVerificationEmail(context.Background(), user); err == nil {
+		t.Error("SendVerificationEmail succeeded through a failing mailer")
+	}
+}
+
+func TestVerifyRefusesBadLinks(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	if err := config.Secrets.Load(); err != nil {
+		t.Fatalf("loading secrets: %v", err)
+	}
+	id := primitive.NewObjectID().Hex()
+	expired, _ := utils.CreatePurposeToken(id, "ann@example.com", utils.PurposeVerifyEmail, -time.Hour)
+	otherPurpose, _ := utils.CreatePurposeToken(id, "ann@example.com", "reset_password", time.Hour)
+	access, _ := utils.CreateToken(id, "ann@example.com", "ann", "", "", "")
+
+	// Each is refused before the database is reached
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/auth/verify", VerificationHandler{}.VerifyEmail)
+
+	for name, token := range map[string]string{
+		"no token":      "",
+		"garbage":       "not-a-token",
+		"expired":       expired,
+		"other purpose": otherPurpose,
+		"access token":  access,
+	} {
+		rec := verify(router, &url.URL{RawQuery: url.Values{"token": {token}}.Encode()})
+		if rec.Code != http.StatusBadRequest || errorCode(rec) != apierror.CodeInvalidLink {
+			t.Errorf("%s: %d %s, want 400 %s", name, rec.Code, rec.Body, apierror.CodeInvalidLink)
+		}
+	}
+}
+
+func TestRegistrationIsVerifiedThroughTheMailedLink(t *testing.T) {
+	mail := &captureMailer{}
+	router, _ := mailingRouter(t, mail)
+	saved := config.VerificationConfig.Enforce
+	config.VerificationConfig.Enforce = true
+	t.Cleanup(func() { config.VerificationConfig.Enforce = saved })
+	login := `{"email":"ann@example.com","password":"correct horse"}`
+
+	if rec := register(router, "ann", "Ann@Example.com"); rec.Code != http.StatusCreated {
+		t.Fatalf("register: %d %s", rec.Code, rec.Body)
+	}
+	sent := mail.messages()
+	if len(sent) != 1 || sent[0].To != "ann@example.com" {
+		t.Fatalf("sent %+v, want one message to the normalized email", sent)
+	}
+
+	if rec := post(router, "/auth/login", login); rec.Code != http.StatusForbidden || errorCode(rec) != apierror.CodeEmailNotVerified {
+		t.Errorf("login before verifying: %d %s, want 403 %s", rec.Code, rec.Body, apierror.CodeEmailNotVerified)
+	}
+
+	link := verificationLink(t, sent[0])
+	if rec := verify(router, link); rec.Code != http.StatusOK || rec.Body.String() != `{"verified":true}` {
+		t.Fatalf("verify: %d %s", rec.Code, rec.Body)
+	}
+	if rec := post(router, "/auth/login", login); rec.Code != http.StatusOK {
+		t.Errorf("login once verified: %d %s, want 200", rec.Code, rec.Body)
+	}
+	// Opening the link again does no harm
+	if rec := verify(router, link); rec.Code != http.StatusOK {
+		t.Errorf("verifying again: %d %s, want 200", rec.Code, rec.Body)
+	}
+
+	// Verified accounts get nothing more
+	if rec := post(router, "/auth/verify/resend", `{"email":"ann@example.com"}`); rec.Code != http.StatusAccepted {
+		t.Errorf("resend: %d %s, want 202", rec.Code, rec.Body)
+	}
+	if n := len(mail.messages()); n != 1 {
+		t.Errorf("%d messages sent, want none for a verified account", n-1)
+	}
+}
+
+func TestResendMailsOnlyUnverifiedAccounts(t *testing.T) {
+	mail := &captureMailer{}
+	router, _ := mailingRouter(t, mail)
+	register(router, "ann", "ann@example.com")
+
+	unknown := post(router, "/auth/verify/resend", `{"email":"nobody@example.com"}`)
+	known := post(router, "/auth/verify/resend", `{"email":"ANN@example.com"}`)
+	if unknown.Code != http.StatusAccepted || known.Code != http.StatusAccepted || unknown.Body.String() != known.Body.String() {
+		t.Errorf("resend answered %d %s for an unknown email and %d %s for ann, want the same 202", unknown.Code, unknown.Body, known.Code, known.Body)
+	}
+
+	sent := mail.messages()
+	if len(sent) != 2 || sent[1].To != "ann@example.com" {
+		t.Fatalf("sent %+v, want the registration's message and one resent to ann", sent)
+	}
+	if rec := verify(router, verificationLink(t, sent[1])); rec.Code != http.StatusOK {
+		t.Errorf("verifying with the resent link: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := post(router, "/auth/verify/resend", `{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("resend without an email: %d, want 400", rec.Code)
+	}
+}
+
+func TestLinkForAChangedEmailIsRefused(t *testing.T) {
+	mail := &captureMailer{}
+	router, users := mailingRouter(t, mail)
+	register(router, "ann", "ann@example.com")
+	if _, err := users.UpdateOne(context.Background(), bson.M{"email": "ann@example.com"}, bson.M{"$set": bson.M{"email": "ann@example.org"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := verify(router, verificationLink(t, mail.messages()[0]))
+	if rec.Code != http.StatusBadRequest || errorCode(rec) != apierror.CodeInvalidLink {
+		t.Errorf("verify: %d %s, want 400 %s", rec.Code, rec.Body, apierror.CodeInvalidLink)
+	}
+	var stored model.User
+	users.FindOne(context.Background(), bson.M{"email": "ann@example.org"}).Decode(&stored)
+	if stored.Verified {
+		t.Error("the account was verified through a link to its old email")
+	}
+}
+
+func TestRegistrationSurvivesTheMailerFailing(t *testing.T) {
+	router, users := mailingRouter(t, &captureMailer{err: errors.New("relay down")})
+
+	if rec := register(router, "ann", "ann@example.com"); rec.Code != http.StatusCreated {
+		t.Errorf("register: %d %s, want 201; the link can be resent", rec.Code, rec.Body)
+	}
+	if n, _ := users.CountDocuments(context.Background(), bson.M{"email": "ann@example.com", "verified": false}); n != 1 {
+		t.Errorf("%d unverified accounts for ann, want 1", n)
+	}
+}
+
+func TestVerificationCanBeSwitchedOff(t *testing.T) {
+	router, _ := mailingRouter(t, &captureMailer{})
+	saved := config.VerificationConfig.Enforce
+	config.VerificationConfig.Enforce = false
+	t.Cleanup(func() { config.VerificationConfig.Enforce = saved })
+
+	register(router, "ann", "ann@example.com")
+	rec := post(router, "/auth/login", `{"email":"ann@example.com","password":"correct horse"}`)
+	if rec.Code != http.StatusOK {
+		t.Errorf("unverified login with enforcement off: %d %s, want 200", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,41 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestSMTPMailerRefusesHeaderInjection(t *testing.T) {
+	// Refused before dialing the relay, which is not there
+	m := SMTPMailer{Addr: "127.0.0.1:1", From: "noreply@example.com"}
+
+	for _, msg := range []Message{
+		{To: "ann@example.com\r\nBcc: everyone@example.com", Subject: "Verify", Body: "link"},
+		{To: "ann@example.com", Subject: "Verify\nBcc: everyone@example.com", Body: "link"},
+	} {
+		err := m.Send(context.Background(), msg)
+		if err == nil || !strings.Contains(err.Error(), "invalid header value") {
+			t.Errorf("Send(%q, %q) = %v, want the header refused", msg.To, msg.Subject, err)
+		}
+	}
+}
+
+func TestLogMailerWritesTheMessage(t *testing.T) {
+	var buf bytes.Buffer
+	saved := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(saved) })
+
+	msg := Message{To: "ann@example.com", Subject: "Verify", Body: "http://localhost/auth/verify?token=abc"}
+	if err := (LogMailer{}).Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{msg.To, msg.Subject, msg.Body} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("logged %q, want %q in it", buf.String(), want)
+		}
+	}
+}
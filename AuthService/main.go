@@ -1,15 +1,29 @@
 package main
 
 import (
+	"auth-service/config"
 	"auth-service/handler"
+	"auth-service/mailer"
 	"auth-service/middleware"
+	"auth-service/oauth"
+	"auth-service/ratelimit"
+	"auth-service/redis"
 	"auth-service/repository"
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"shared/authz"
+	"shared/cors"
+	"shared/migrate"
+	"syscall"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -37,30 +51,206 @@ func connectDB(uri string) *mongo.Client {
 }
 
 func main() {
+	// Load secrets (NAME or NAME_FILE) and reload the reloadable ones on SIGHUP
+	if err := config.Load(); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	go config.Secrets.ReloadOnSIGHUP(context.Background())
+
 	// Connect to DB
-	mongoURI := "mongodb://canvas-live-mongodb:27017"
-	client := connectDB(mongoURI)
+	client := connectDB(config.MongoConfig.MongoUri)
+
+	// Create missing indexes, and refuse to start against data the migrate tool
+	// has not brought up to date
+	indexCtx, cancelIndexes := context.WithTimeout(context.Background(), time.Minute)
+	if _, err := migrate.New(client.Database(config.MongoConfig.DatabaseName)).EnsureIndexes(indexCtx); err != nil {
+		log.Fatalf("Ensuring indexes failed: %v", err)
+	}
+	cancelIndexes()
+
+	// Connect to Redis, which holds the access token revocation list
+	redisClient := redis.NewRedisClient(config.RedisConfig.Addr, config.RedisConfig.Password)
 
 	// Setup repositories
-	userRepository := repository.NewUserRepository(client, "default", "user")
+	outboxRepository := repository.NewOutboxRepository(client, config.MongoConfig.DatabaseName, config.MongoConfig.OutboxCollectionName)
+	userRepository := repository.NewUserRepository(client, config.MongoConfig.DatabaseName, config.MongoConfig.UserCollectionName, outboxRepository)
+	sessionRepository := repository.NewSessionRepository(client, config.MongoConfig.DatabaseName, config.MongoConfig.SessionCollectionName, config.TokenConfig.RefreshTokenTTL)
+	auditRepository := repository.NewAuditRepository(client, config.MongoConfig.DatabaseName, config.MongoConfig.LoginEventCollectionName, config.AuditConfig.Retention, config.AuditConfig.QueueSize)
+	refreshTokenRepository := repository.NewRefreshTokenRepository(client, config.MongoConfig.DatabaseName, config.MongoConfig.RefreshTokenCollectionName, config.TokenConfig.RefreshTokenTTL)
+
+	// Promote the bootstrap admin if it registered before being designated
+	if email := config.AdminConfig.BootstrapEmail; email != "" {
+		adminCtx, cancelAdmin := context.WithTimeout(context.Background(), 10*time.Second)
+		promoted, err := userRepository.SetRole(adminCtx, email, authz.RoleAdmin)
+		cancelAdmin()
+		if err != nil {
+			log.Fatalf("Failed to promote the bootstrap admin: %v", err)
+		}
+		if promoted {
+			log.Printf("[Main] %s has the admin role", email)
+		}
+	}
+
+	// Rate limiting, shared through Redis unless configured per instance
+	var rateLimitStore ratelimit.Store = ratelimit.NewRedisStore(redisClient.Client)
+	if config.RateLimitConfig.Backend == "memory" {
+		rateLimitStore = ratelimit.NewMemoryStore()
+	}
+
+	// Mail is only logged unless an SMTP relay is configured
+	var mail mailer.Mailer = mailer.LogMailer{}
+	if config.MailConfig.SMTPAddr != "" {
+		mail = mailer.SMTPMailer{
+			Addr:     config.MailConfig.SMTPAddr,
+			From:     config.MailConfig.From,
+			Username: config.MailConfig.SMTPUsername,
+			Password: config.MailConfig.SMTPPassword,
+		}
+	}
 
 	// Handlers
-	healthHandler := handler.HealthHandler{}
-	authHandler := handler.AuthHandler{UserRepository: userRepository}
-	userHandler := handler.UserHandler{UserRepository: userRepository}
-
-	// Server
-	mux := http.NewServeMux()
-	mux.Handle("/auth/health", healthHandler)
-	mux.Handle("/auth/register", http.HandlerFunc(authHandler.RegisterUser))
-	mux.Handle("/auth/login", http.HandlerFunc(authHandler.LoginUser))
-	mux.Handle("/auth/authenticate", http.HandlerFunc(authHandler.AuthenticateRequest))
-	mux.Handle("/auth/users", http.HandlerFunc(userHandler.RetrieveSearchedUsers))
-
-	finalMux := middleware.RequestLoggingMiddleware(mux)
-	fmt.Println("Starting server on port 8081...")
-
-	if err := http.ListenAndServe(":8081", finalMux); err != nil {
+	verificationHandler := handler.VerificationHandler{
+		UserRepository: userRepository,
+		Mailer:         mail,
+		ResendEmailLimiter: &ratelimit.Limiter{
+			Store:  rateLimitStore,
+			Prefix: "auth:ratelimit:verify-resend:email:",
+			Limit:  config.VerificationConfig.ResendPerEmail,
+			Window: config.VerificationConfig.ResendWindow,
+		},
+		ResendIPLimiter: &ratelimit.Limiter{
+			Store:  rateLimitStore,
+			Prefix: "auth:ratelimit:verify-resend:ip:",
+			Limit:  config.VerificationConfig.ResendPerIP,
+			Window: config.VerificationConfig.ResendWindow,
+		},
+	}
+	healthHandler := handler.HealthHandler{MongoClient: client, RedisClient: redisClient}
+	jwksHandler := handler.JWKSHandler{}
+	authHandler := handler.AuthHandler{
+		UserRepository:         userRepository,
+		RefreshTokenRepository: refreshTokenRepository,
+		SessionRepository:      sessionRepository,
+		RedisClient:            redisClient,
+		AuditRepository:        auditRepository,
+		LoginEmailLimiter: &ratelimit.Limiter{
+			Store:  rateLimitStore,
+			Prefix: "auth:ratelimit:login:email:",
+			Limit:  config.RateLimitConfig.LoginPerEmail,
+			Window: config.RateLimitConfig.LoginWindow,
+		},
+		LoginIPLimiter: &ratelimit.Limiter{
+			Store:  rateLimitStore,
+			Prefix: "auth:ratelimit:login:ip:",
+			Limit:  config.RateLimitConfig.LoginPerIP,
+			Window: config.RateLimitConfig.LoginWindow,
+		},
+		Verification: verificationHandler,
+	}
+	oauthHandler := handler.OAuthHandler{
+		Google: oauth.Google{
+			ClientID:     config.GoogleOAuthConfig.ClientID,
+			ClientSecret: config.GoogleOAuthConfig.ClientSecret,
+			RedirectURL:  config.GoogleOAuthConfig.RedirectURL,
+		},
+		Auth: authHandler,
+	}
+	userHandler := handler.UserHandler{UserRepository: userRepository, AuditRepository: auditRepository, Auth: authHandler}
+
+	// ===============================================
+	// GIN ROUTER SETUP
+	// ===============================================
+
+	// gin.New rather than gin.Default: requests are logged by our own middleware
+	router := gin.New()
+	router.Use(gin.Recovery(), middleware.RequestLoggingMiddleware(), cors.Middleware(config.CORSConfig))
+
+	// The gateway sets X-Real-IP; X-Forwarded-For is passed through from the
+	// client and must not decide which IP a login attempt is counted against
+	router.RemoteIPHeaders = []string{"X-Real-IP"}
+
+	// Probes for the orchestrator; /auth/health and /auth/ready are the same
+	// checks reachable through the gateway
+	router.GET("/health", healthHandler.Health)
+	router.GET("/ready", healthHandler.Ready)
+
+	authGroup := router.Group("/auth")
+	{
+		authGroup.GET("/health", healthHandler.Health)
+		authGroup.GET("/ready", healthHandler.Ready)
+		authGroup.GET("/.well-known/jwks.json", jwksHandler.Keys)
+		authGroup.POST("/register", authHandler.RegisterUser)
+		authGroup.POST("/login", authHandler.LoginUser)
+		authGroup.POST("/logout", authHandler.Logout)
+		authGroup.POST("/refresh", authHandler.RefreshToken)
+		authGroup.POST("/password", authHandler.ChangePassword)
+		authGroup.DELETE("/account", authHandler.DeleteAccount)
+		authGroup.GET("/users", userHandler.RetrieveSearchedUsers)
+		authGroup.GET("/users/lookup", userHandler.LookupUser)
+		authGroup.POST("/users/resolve", userHandler.ResolveUsers)
+		authGroup.GET("/username-available", userHandler.UsernameAvailable)
+		authGroup.GET("/me", userHandler.GetCurrentUser)
+		authGroup.PATCH("/me", userHandler.UpdateCurrentUser)
+		authGroup.GET("/me/logins", userHandler.GetLoginHistory)
+		authGroup.GET("/sessions", authHandler.ListSessions)
+		authGroup.DELETE("/sessions", authHandler.RevokeOtherSessions)
+		authGroup.DELETE("/sessions/:id", authHandler.RevokeSession)
+		authGroup.GET("/verify", verificationHandler.VerifyEmail)
+		authGroup.POST("/verify/resend", verificationHandler.ResendVerification)
+
+		// Sign in with Google, when configured
+		if config.GoogleOAuthConfig.Enabled() {
+			authGroup.GET("/oauth/google/login", oauthHandler.GoogleLogin)
+			authGroup.GET("/oauth/google/callback", oauthHandler.GoogleCallback)
+		}
+
+		// Nginx's auth_request subrequest keeps the original method, so accept any
+		authGroup.Any("/authenticate", authHandler.AuthenticateRequest)
+	}
+
+	// Serve until SIGINT or SIGTERM, then stop accepting connections and let
+	// in-flight requests finish before closing the databases they use. A
+	// second signal kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+
+	listener, err := net.Listen("tcp", config.ServerConfig.Addr)
+	if err != nil {
 		log.Fatalf("Could not start server: %s\n", err.Error())
 	}
+	shutdownCtx, cancelShutdown := serve(ctx, &http.Server{Handler: router}, listener)
+	defer cancelShutdown()
+
+	if err := auditRepository.Close(shutdownCtx); err != nil {
+		log.Printf("[Main] Dropped queued audit events: %v", err)
+	}
+	if err := redisClient.Client.Close(); err != nil {
+		log.Printf("[Main] Error closing Redis: %v", err)
+	}
+	if err := client.Disconnect(shutdownCtx); err != nil {
+		log.Printf("[Main] Error disconnecting from MongoDB: %v", err)
+	}
+	log.Println("[Main] Shutdown complete")
+}
+
+// serve serves on listener until ctx is done, then stops accepting
+// connections and lets in-flight requests finish. It returns the context
+// bounding the whole shutdown, ShutdownTimeout from then, for closing what the
+// requests used.
+func serve(ctx context.Context, server *http.Server, listener net.Listener) (context.Context, context.CancelFunc) {
+	go func() {
+		fmt.Printf("Starting server on %s with Gin...\n", listener.Addr())
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Could not start server: %s\n", err.Error())
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("[Main] Shutting down, draining requests for up to %s", config.ServerConfig.ShutdownTimeout)
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), config.ServerConfig.ShutdownTimeout)
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("[Main] Requests still running at the drain timeout: %v", err)
+	}
+	return shutdownCtx, cancelShutdown
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"auth-service/config"
+	"context"
+	"net"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSignalDrainsInFlightRequests(t *testing.T) {
+	saved := config.ServerConfig
+	config.ServerConfig.ShutdownTimeout = 5 * time.Second
+	t.Cleanup(func() { config.ServerConfig = saved })
+
+	// A login that takes until released
+	entered, release := make(chan struct{}), make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		w.Write([]byte("logged in"))
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		_, cancel := serve(ctx, server, listener)
+		cancel()
+	}()
+
+	slow := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/auth/login")
+		if err != nil {
+			t.Errorf("in-flight request failed: %v", err)
+			close(slow)
+			return
+		}
+		slow <- resp
+	}()
+	<-entered
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	// New connections are refused while the slow request still runs
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("connections still accepted after SIGTERM")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-stopped:
+		t.Fatal("server stopped before its in-flight request finished")
+	default:
+	}
+
+	close(release)
+	if resp, ok := <-slow; ok {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("in-flight request: status %d, want 200", resp.StatusCode)
+		}
+	}
+	select {
+	case <-stopped:
+	case <-time.After(config.ServerConfig.ShutdownTimeout):
+		t.Fatal("server did not stop once drained")
+	}
+}
@@ -2,34 +2,35 @@ package middleware
 
 import (
 	"log"
-	"net/http"
 	"time"
-)
 
-func RequestLoggingMiddleware(next http.Handler) http.Handler {
+	"github.com/gin-gonic/gin"
+)
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+func RequestLoggingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
 		start := time.Now() // Record the start time
 
 		// -- 1. PRE-PROCESSING (Logging) --
 		log.Printf(
 			"[%s] STARTED: %s %s from %s",
 			start.Format("2006/01/02 15:04:05"),
-			r.Method,
-			r.RequestURI,
-			r.RemoteAddr,
+			c.Request.Method,
+			c.Request.RequestURI,
+			c.Request.RemoteAddr,
 		)
 
 		// -- 2. EXECUTE THE NEXT HANDLER --
-		next.ServeHTTP(w, r) // Call the original handler function
+		c.Next()
 
-		// -- 3. POST-PROCESSING (Logging duration) --
+		// -- 3. POST-PROCESSING (Logging status and duration) --
 		log.Printf(
-			"[%s] COMPLETED: %s %s in %v",
+			"[%s] COMPLETED: %s %s %d in %v",
 			time.Now().Format("2006/01/02 15:04:05"),
-			r.Method,
-			r.RequestURI,
+			c.Request.Method,
+			c.Request.RequestURI,
+			c.Writer.Status(),
 			time.Since(start),
 		)
-	})
+	}
 }
@@ -0,0 +1,31 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Login event types recorded in the audit trail.
+const (
+	LoginEventSucceeded = "login_succeeded"
+	LoginEventFailed    = "login_failed"
+	LoginEventRefreshed = "token_refreshed"
+	LoginEventLogout    = "logout"
+)
+
+// LoginEvent is one entry of the authentication audit trail. UserID is empty
+// for failed logins against an unknown email.
+type LoginEvent struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    string             `bson:"userId,omitempty" json:"-"`
+	TenantID  string             `bson:"tenantId,omitempty" json:"-"`
+	Email     string             `bson:"email,omitempty" json:"email,omitempty"`
+	Type      string             `bson:"type" json:"type"`
+	Reason    string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	IP        string             `bson:"ip" json:"ip"`
+	UserAgent string             `bson:"userAgent" json:"userAgent"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	// ExpiresAt drives the TTL index, so retention changes apply to new events
+	ExpiresAt time.Time `bson:"expiresAt" json:"-"`
+}
@@ -0,0 +1,22 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RefreshToken is a stored refresh token. Every token issued by rotating
+// another one shares its FamilyID, so presenting an already rotated token
+// revokes the whole family.
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    string             `bson:"userId"`
+	TenantID  string             `bson:"tenantId,omitempty"`
+	FamilyID  string             `bson:"familyId"`
+	TokenHash string             `bson:"tokenHash"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+	RotatedAt *time.Time         `bson:"rotatedAt,omitempty"`
+	RevokedAt *time.Time         `bson:"revokedAt,omitempty"`
+}
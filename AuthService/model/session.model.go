@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// Session is a signed-in device. Its ID is the family ID of the refresh tokens
+// issued to it, so revoking a session revokes that family, and access tokens
+// carry it as their sid claim.
+type Session struct {
+	ID         string     `bson:"_id" json:"id"`
+	UserID     string     `bson:"userId" json:"-"`
+	TenantID   string     `bson:"tenantId,omitempty" json:"-"`
+	UserAgent  string     `bson:"userAgent" json:"userAgent"`
+	IP         string     `bson:"ip" json:"ip"`
+	CreatedAt  time.Time  `bson:"createdAt" json:"createdAt"`
+	LastUsedAt time.Time  `bson:"lastUsedAt" json:"lastUsedAt"`
+	ExpiresAt  time.Time  `bson:"expiresAt" json:"-"`
+	RevokedAt  *time.Time `bson:"revokedAt,omitempty" json:"-"`
+}
@@ -11,7 +11,28 @@ type User struct {
 	// primitive.ObjectID is the standard type for MongoDB's _id field.
 	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	Username string             `bson:"name" json:"username"`
-	Email    string             `bson:"email" json:"email"`
-	Password string             `bson:"password" json:"password"`
-	JoinedAt time.Time          `bson:"joinedAt" json:"joinedAt"`
+	// UsernameKey is the lowercased username the unique index is built on
+	UsernameKey string    `bson:"nameKey" json:"-"`
+	Email       string    `bson:"email" json:"email"`
+	Password    string    `bson:"password" json:"-"`
+	JoinedAt    time.Time `bson:"joinedAt" json:"joinedAt"`
+	Verified    bool      `bson:"verified" json:"verified"`
+	// DisplayName and AvatarURL are optional profile fields set by the user.
+	DisplayName string `bson:"displayName,omitempty" json:"displayName,omitempty"`
+	AvatarURL   string `bson:"avatarUrl,omitempty" json:"avatarUrl,omitempty"`
+	// Role is "user" or "admin"; users created before roles existed have none.
+	Role string `bson:"role,omitempty" json:"role"`
+	// TenantID is assigned by operators, never by the registration payload.
+	TenantID string `bson:"tenantId,omitempty" json:"-"`
+	// Provider is how the account was created; accounts created through an
+	// identity provider have no password. Older accounts have none.
+	Provider string `bson:"provider,omitempty" json:"provider,omitempty"`
+	// GoogleID is the Google subject the account is linked to, if any.
+	GoogleID string `bson:"googleId,omitempty" json:"-"`
 }
+
+// Values of User.Provider.
+const (
+	ProviderPassword = "password"
+	ProviderGoogle   = "google"
+)
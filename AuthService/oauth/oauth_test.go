@@ -0,0 +1,155 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// rerouted sends every request to server, keeping its path, as Google's
+// endpoints are fixed.
+type rerouted struct {
+	server *httptest.Server
+}
+
+func (r rerouted) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, _ := url.Parse(r.server.URL)
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = target.Scheme, target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// fakeGoogle answers the token endpoint with tokenStatus and the userinfo
+// endpoint with userinfo, keeping the token form and userinfo credentials.
+func fakeGoogle(t *testing.T, tokenStatus int, userinfo map[string]any) (Google, *url.Values, *string) {
+	t.Helper()
+	form, authorization := &url.Values{}, new(string)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		*form = r.PostForm
+		if tokenStatus != http.StatusOK {
+			http.Error(w, `{"error":"invalid_grant"}`, tokenStatus)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access-1", "token_type": "Bearer"})
+	})
+	mux.HandleFunc("GET /v1/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		*authorization = r.Header.Get("Authorization")
+		json.NewEncoder(w).Encode(userinfo)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	google := Google{
+		ClientID:     "client-1",
+		ClientSecret: "secret-1",
+		RedirectURL:  "https://auth.example.com/auth/oauth/google/callback",
+		HTTPClient:   &http.Client{Transport: rerouted{server}},
+	}
+	return google, form, authorization
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// RFC 7636, appendix B
+	if got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("CodeChallenge = %q", got)
+	}
+}
+
+func TestNewStateAndVerifier(t *testing.T) {
+	seen := map[string]bool{}
+	for range 100 {
+		state, err := NewState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifier, err := NewCodeVerifier()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[state] || seen[verifier] {
+			t.Fatalf("%q or %q repeated", state, verifier)
+		}
+		seen[state], seen[verifier] = true, true
+		// RFC 7636 wants 43 to 128 unreserved characters
+		if len(verifier) < 43 || len(verifier) > 128 || strings.ContainsAny(verifier, "+/=") {
+			t.Errorf("verifier %q is not a valid PKCE verifier", verifier)
+		}
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	google := Google{ClientID: "client-1", RedirectURL: "https://auth.example.com/auth/oauth/google/callback"}
+	link, err := url.Parse(google.AuthCodeURL("state-1", "challenge-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Scheme != "https" || link.Host != "accounts.google.com" {
+		t.Errorf("sent to %s, want Google", link)
+	}
+	query := link.Query()
+	for param, want := range map[string]string{
+		"client_id":             "client-1",
+		"redirect_uri":          "https://auth.example.com/auth/oauth/google/callback",
+		"response_type":         "code",
+		"scope":                 "openid email profile",
+		"state":                 "state-1",
+		"code_challenge":        "challenge-1",
+		"code_challenge_method": "S256",
+	} {
+		if got := query.Get(param); got != want {
+			t.Errorf("%s = %q, want %q", param, got, want)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	google, form, authorization := fakeGoogle(t, http.StatusOK, map[string]any{
+		"sub": "g-1", "email": "ann@example.com", "email_verified": true, "name": "Ann Lee",
+	})
+
+	identity, err := google.Exchange(context.Background(), "code-1", "verifier-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Identity{Subject: "g-1", Email: "ann@example.com", EmailVerified: true, Name: "Ann Lee"}); identity != want {
+		t.Errorf("identity %+v, want %+v", identity, want)
+	}
+	for field, want := range map[string]string{
+		"client_id":     "client-1",
+		"client_secret": "secret-1",
+		"redirect_uri":  "https://auth.example.com/auth/oauth/google/callback",
+		"grant_type":    "authorization_code",
+		"code":          "code-1",
+		"code_verifier": "verifier-1",
+	} {
+		if got := form.Get(field); got != want {
+			t.Errorf("token request %s = %q, want %q", field, got, want)
+		}
+	}
+	if *authorization != "Bearer access-1" {
+		t.Errorf("userinfo Authorization %q, want the issued token", *authorization)
+	}
+}
+
+func TestExchangeFailures(t *testing.T) {
+	tests := []struct {
+		name        string
+		tokenStatus int
+		userinfo    map[string]any
+	}{
+		{"code refused", http.StatusBadRequest, map[string]any{"sub": "g-1"}},
+		{"no subject", http.StatusOK, map[string]any{"email": "ann@example.com", "email_verified": true}},
+	}
+	for _, tt := range tests {
+		google, _, _ := fakeGoogle(t, tt.tokenStatus, tt.userinfo)
+		if identity, err := google.Exchange(context.Background(), "code-1", "verifier-1"); err == nil {
+			t.Errorf("%s: signed in as %+v", tt.name, identity)
+		}
+	}
+}
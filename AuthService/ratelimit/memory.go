@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps attempts in process memory. Counts are per instance.
+type MemoryStore struct {
+	mu       sync.Mutex
+	attempts map[string][]time.Time
+	sweeps   int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{attempts: make(map[string][]time.Time)}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(window, now)
+
+	recent := prune(s.attempts[key], now.Add(-window))
+	if len(recent) >= limit {
+		s.attempts[key] = recent
+		return false, recent[0].Add(window).Sub(now), nil
+	}
+
+	s.attempts[key] = append(recent, now)
+	return true, 0, nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+// sweep occasionally drops keys with no attempts left in the window so the
+// map does not grow with every IP and email ever seen.
+func (s *MemoryStore) sweep(window time.Duration, now time.Time) {
+	s.sweeps++
+	if s.sweeps < 1000 {
+		return
+	}
+	s.sweeps = 0
+
+	for key, attempts := range s.attempts {
+		if len(prune(attempts, now.Add(-window))) == 0 {
+			delete(s.attempts, key)
+		}
+	}
+}
+
+// prune drops attempts at or before cutoff. attempts is sorted oldest first.
+func prune(attempts []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(attempts) && !attempts[i].After(cutoff) {
+		i++
+	}
+	return attempts[i:]
+}
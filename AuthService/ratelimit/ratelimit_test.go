@@ -0,0 +1,179 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// clock is a fake clock tests move by hand.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+// testStores returns each Store, the Redis one over a miniredis.
+func testStores(t *testing.T) map[string]Store {
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { client.Close() })
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"redis":  NewRedisStore(client),
+	}
+}
+
+// loginLimiters limits logins to 5 per IP and 3 per email a minute.
+func loginLimiters(store Store, clock *clock) (ip *Limiter, email *Limiter) {
+	ip = &Limiter{Store: store, Prefix: "login:ip:", Limit: 5, Window: time.Minute, Now: clock.Now}
+	email = &Limiter{Store: store, Prefix: "login:email:", Limit: 3, Window: time.Minute, Now: clock.Now}
+	return ip, email
+}
+
+// attempt counts a login from ip at email, as LoginUser does.
+func attempt(ipLimiter *Limiter, emailLimiter *Limiter, ip string, email string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/login", nil)
+	if Guard(c, Check{Limiter: ipLimiter, Key: ip}, Check{Limiter: emailLimiter, Key: email}) {
+		c.Status(http.StatusOK)
+	}
+	return rec
+}
+
+func TestBurstsTripEachDimension(t *testing.T) {
+	tests := []struct {
+		name        string
+		ip          func(i int) string
+		email       func(i int) string
+		wantAllowed int
+	}{
+		{
+			name:        "one IP against many emails",
+			ip:          func(i int) string { return "10.0.0.1" },
+			email:       func(i int) string { return fmt.Sprintf("user-%d@example.com", i) },
+			wantAllowed: 5,
+		},
+		{
+			name:        "many IPs against one email",
+			ip:          func(i int) string { return fmt.Sprintf("10.0.0.%d", i) },
+			email:       func(i int) string { return "ann@example.com" },
+			wantAllowed: 3,
+		},
+		{
+			name:        "one IP against one email",
+			ip:          func(i int) string { return "10.0.0.1" },
+			email:       func(i int) string { return "ann@example.com" },
+			wantAllowed: 3,
+		},
+		{
+			name:        "many IPs against many emails",
+			ip:          func(i int) string { return fmt.Sprintf("10.0.0.%d", i) },
+			email:       func(i int) string { return fmt.Sprintf("user-%d@example.com", i) },
+			wantAllowed: 10,
+		},
+	}
+	for storeName, store := range testStores(t) {
+		for _, tt := range tests {
+			name := storeName + ": " + tt.name
+			clock := &clock{now: time.Unix(1_700_000_000, 0)}
+			ipLimiter, emailLimiter := loginLimiters(store, clock)
+			// Every case starts from empty counts
+			ipLimiter.Prefix += tt.name + ":"
+			emailLimiter.Prefix += tt.name + ":"
+
+			for i := 0; i < 10; i++ {
+				rec := attempt(ipLimiter, emailLimiter, tt.ip(i), tt.email(i))
+				if i < tt.wantAllowed {
+					if rec.Code != http.StatusOK {
+						t.Errorf("%s: attempt %d = %d, want 200", name, i, rec.Code)
+					}
+					continue
+				}
+				if rec.Code != http.StatusTooManyRequests {
+					t.Errorf("%s: attempt %d = %d, want 429", name, i, rec.Code)
+				}
+				if got := rec.Header().Get("Retry-After"); got != "60" {
+					t.Errorf("%s: attempt %d Retry-After = %q, want 60", name, i, got)
+				}
+			}
+
+			// The window slides past the burst
+			clock.now = clock.now.Add(time.Minute)
+			if rec := attempt(ipLimiter, emailLimiter, tt.ip(0), tt.email(0)); rec.Code != http.StatusOK {
+				t.Errorf("%s: attempt a window later = %d, want 200", name, rec.Code)
+			}
+		}
+	}
+}
+
+func TestTrippedDimensionLeavesOtherAlone(t *testing.T) {
+	for name, store := range testStores(t) {
+		clock := &clock{now: time.Unix(1_700_000_000, 0)}
+		ipLimiter, emailLimiter := loginLimiters(store, clock)
+
+		// One IP runs out trying many accounts
+		for i := 0; i < 5; i++ {
+			attempt(ipLimiter, emailLimiter, "10.0.0.1", fmt.Sprintf("user-%d@example.com", i))
+		}
+		if rec := attempt(ipLimiter, emailLimiter, "10.0.0.1", "user-0@example.com"); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("%s: attempt from the tripped IP = %d, want 429", name, rec.Code)
+		}
+		// The owner of one of those accounts still logs in from elsewhere
+		if rec := attempt(ipLimiter, emailLimiter, "10.0.0.2", "user-0@example.com"); rec.Code != http.StatusOK {
+			t.Errorf("%s: attempt from another IP = %d, want 200", name, rec.Code)
+		}
+	}
+}
+
+func TestRetryAfterCountsFromOldestAttempt(t *testing.T) {
+	for name, store := range testStores(t) {
+		clock := &clock{now: time.Unix(1_700_000_000, 0)}
+		_, emailLimiter := loginLimiters(store, clock)
+
+		for i := 0; i < 3; i++ {
+			if allowed, _, err := emailLimiter.Allow(t.Context(), "ann@example.com"); !allowed || err != nil {
+				t.Fatalf("%s: attempt %d = %v, %v", name, i, allowed, err)
+			}
+			clock.now = clock.now.Add(10 * time.Second)
+		}
+		// The first attempt leaves the window 60s after it was made
+		allowed, retryAfter, err := emailLimiter.Allow(t.Context(), "ann@example.com")
+		if allowed || err != nil || retryAfter != 30*time.Second {
+			t.Errorf("%s: attempt over the limit = %v, %v, %v, want refused for 30s", name, allowed, retryAfter, err)
+		}
+		clock.now = clock.now.Add(30 * time.Second)
+		if allowed, _, _ := emailLimiter.Allow(t.Context(), "ann@example.com"); !allowed {
+			t.Errorf("%s: attempt once the oldest left the window refused", name)
+		}
+	}
+}
+
+func TestResetForgetsAttempts(t *testing.T) {
+	for name, store := range testStores(t) {
+		clock := &clock{now: time.Unix(1_700_000_000, 0)}
+		ipLimiter, emailLimiter := loginLimiters(store, clock)
+
+		for i := 0; i < 2; i++ {
+			attempt(ipLimiter, emailLimiter, fmt.Sprintf("10.0.0.%d", i), "ann@example.com")
+		}
+		// A successful login resets the email's count
+		if err := emailLimiter.Reset(t.Context(), "ann@example.com"); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			if rec := attempt(ipLimiter, emailLimiter, fmt.Sprintf("10.0.1.%d", i), "ann@example.com"); rec.Code != http.StatusOK {
+				t.Errorf("%s: attempt %d after reset = %d, want 200", name, i, rec.Code)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// takeScript keeps one sorted set per key, scored by attempt time in
+// milliseconds. It trims the window, then either records the attempt or
+// returns the age of the oldest one.
+var takeScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+if redis.call("ZCARD", KEYS[1]) >= limit then
+	local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+	return tonumber(oldest[2]) + window - now
+end
+redis.call("ZADD", KEYS[1], now, ARGV[4])
+redis.call("PEXPIRE", KEYS[1], window)
+return 0`)
+
+// RedisStore shares attempts between every instance using the same Redis.
+type RedisStore struct {
+	Client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{Client: client}
+}
+
+func (s *RedisStore) Take(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (bool, time.Duration, error) {
+	// Members must be unique even for attempts at the same instant, which
+	// would otherwise count once
+	member := strconv.FormatInt(now.UnixNano(), 10) + "-" + strconv.FormatUint(rand.Uint64(), 36)
+
+	wait, err := takeScript.Run(ctx, s.Client, []string{key},
+		now.UnixMilli(), window.Milliseconds(), limit, member).Int64()
+	if err != nil {
+		return false, 0, fmt.Errorf("redis rate limit failed: %w", err)
+	}
+	if wait > 0 {
+		return false, time.Duration(wait) * time.Millisecond, nil
+	}
+	return true, 0, nil
+}
+
+func (s *RedisStore) Reset(ctx context.Context, key string) error {
+	if err := s.Client.Del(ctx, key).Err(); err != nil {
+		return fmt.Errorf("redis DEL failed: %w", err)
+	}
+	return nil
+}
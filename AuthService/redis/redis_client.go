@@ -0,0 +1,127 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	revokedTokenPrefix   = "auth:revoked:"
+	revokedUserPrefix    = "auth:revoked-user:"
+	revokedSessionPrefix = "auth:revoked-session:"
+	oauthStatePrefix     = "auth:oauth-state:"
+)
+
+// RedisClient struct holds the client connection
+type RedisClient struct {
+	Client *redis.Client
+}
+
+// NewRedisClient creates and tests the connection to Redis
+func NewRedisClient(addr string, password string) *RedisClient {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       0,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		log.Fatalf("Failed to connect to Redis at %s: %v", addr, err)
+	}
+
+	fmt.Printf("Successfully connected to Redis at %s\n", addr)
+	return &RedisClient{
+		Client: rdb,
+	}
+}
+
+// RevokeToken marks the access token identified by tokenID as revoked until
+// expiresAt, after which the token is rejected on its own and the entry expires.
+func (r *RedisClient) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	if err := r.Client.Set(ctx, revokedTokenPrefix+tokenID, 1, ttl).Err(); err != nil {
+		return fmt.Errorf("redis SET failed: %w", err)
+	}
+	return nil
+}
+
+// IsTokenRevoked reports whether the access token identified by tokenID was revoked.
+func (r *RedisClient) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	n, err := r.Client.Exists(ctx, revokedTokenPrefix+tokenID).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis EXISTS failed: %w", err)
+	}
+	return n == 1, nil
+}
+
+// RevokeUserTokens revokes every access token of userID issued before at. The
+// entry is kept for ttl, the lifetime of an access token.
+func (r *RedisClient) RevokeUserTokens(ctx context.Context, userID string, at time.Time, ttl time.Duration) error {
+	if err := r.Client.Set(ctx, revokedUserPrefix+userID, at.Unix(), ttl).Err(); err != nil {
+		return fmt.Errorf("redis SET failed: %w", err)
+	}
+	return nil
+}
+
+// UserTokensRevokedAt returns the time before which access tokens of userID
+// are revoked, or the zero time when none are.
+func (r *RedisClient) UserTokensRevokedAt(ctx context.Context, userID string) (time.Time, error) {
+	unix, err := r.Client.Get(ctx, revokedUserPrefix+userID).Int64()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("redis GET failed: %w", err)
+	}
+	return time.Unix(unix, 0), nil
+}
+
+// RevokeSession revokes every access token carrying sessionID as its sid. The
+// entry is kept for ttl, the lifetime of an access token.
+func (r *RedisClient) RevokeSession(ctx context.Context, sessionID string, ttl time.Duration) error {
+	if err := r.Client.Set(ctx, revokedSessionPrefix+sessionID, 1, ttl).Err(); err != nil {
+		return fmt.Errorf("redis SET failed: %w", err)
+	}
+	return nil
+}
+
+// IsSessionRevoked reports whether the session sessionID was revoked.
+func (r *RedisClient) IsSessionRevoked(ctx context.Context, sessionID string) (bool, error) {
+	n, err := r.Client.Exists(ctx, revokedSessionPrefix+sessionID).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis EXISTS failed: %w", err)
+	}
+	return n == 1, nil
+}
+
+// SaveOAuthState remembers the PKCE code verifier of a sign-in started with
+// state, for ttl.
+func (r *RedisClient) SaveOAuthState(ctx context.Context, state string, codeVerifier string, ttl time.Duration) error {
+	if err := r.Client.Set(ctx, oauthStatePrefix+state, codeVerifier, ttl).Err(); err != nil {
+		return fmt.Errorf("redis SET failed: %w", err)
+	}
+	return nil
+}
+
+// TakeOAuthState returns and forgets the code verifier saved for state, or ""
+// when state is unknown, expired, or already used.
+func (r *RedisClient) TakeOAuthState(ctx context.Context, state string) (string, error) {
+	verifier, err := r.Client.GetDel(ctx, oauthStatePrefix+state).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("redis GETDEL failed: %w", err)
+	}
+	return verifier, nil
+}
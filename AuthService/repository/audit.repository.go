@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"auth-service/model"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditRepository records login events. Record never blocks the request: events
+// are queued and inserted by a background goroutine, and dropped (with a log
+// line) when the queue is full or the insert fails.
+type AuditRepository struct {
+	collection *mongo.Collection
+	retention  time.Duration
+	queue      chan model.LoginEvent
+	done       chan struct{}
+}
+
+// NewAuditRepository starts the writer goroutine, which runs until Close.
+func NewAuditRepository(client *mongo.Client, database string, collection string, retention time.Duration, queueSize int) *AuditRepository {
+	r := &AuditRepository{
+		collection: client.Database(database).Collection(collection),
+		retention:  retention,
+		queue:      make(chan model.LoginEvent, queueSize),
+		done:       make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Record queues event for insertion, stamping its creation and expiry times.
+func (r *AuditRepository) Record(event model.LoginEvent) {
+	event.CreatedAt = time.Now().UTC()
+	event.ExpiresAt = event.CreatedAt.Add(r.retention)
+
+	select {
+	case r.queue <- event:
+	default:
+		log.Printf("[Audit] Queue full, dropping %s event for %q", event.Type, event.Email)
+	}
+}
+
+// Close stops accepting events and waits until the queued ones are written or
+// ctx is done. Call it once no request can record events anymore.
+func (r *AuditRepository) Close(ctx context.Context) error {
+	close(r.queue)
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *AuditRepository) run() {
+	defer close(r.done)
+	for event := range r.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if _, err := r.collection.InsertOne(ctx, event); err != nil {
+			log.Printf("[Audit] Error recording %s event: %v", event.Type, err)
+		}
+		cancel()
+	}
+}
+
+// FindByUser returns the most recent events of a user, newest first.
+func (r *AuditRepository) FindByUser(ctx context.Context, userID string, limit int64) ([]model.LoginEvent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(limit)
+	cursor, err := r.collection.Find(ctx, bson.M{"userId": userID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error finding login events: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	events := []model.LoginEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, fmt.Errorf("error decoding login events: %w", err)
+	}
+	return events, nil
+}
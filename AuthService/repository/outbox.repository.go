@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"shared/events"
+	sharedmodel "shared/model"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// OutboxRepository appends lifecycle events to the shared outbox collection.
+// DocumentService's relay publishes them to Kafka.
+type OutboxRepository struct {
+	collection *mongo.Collection
+}
+
+func NewOutboxRepository(client *mongo.Client, database string, collection string) *OutboxRepository {
+	return &OutboxRepository{
+		collection: client.Database(database).Collection(collection),
+	}
+}
+
+// Append inserts a pending event. Pass the session context of the surrounding
+// transaction so the event commits or rolls back together with the state change.
+func (r *OutboxRepository) Append(ctx context.Context, e events.Event) error {
+	envelope, err := events.Encode(e)
+	if err != nil {
+		return fmt.Errorf("error encoding outbox event: %w", err)
+	}
+
+	topic, err := events.TopicOf(envelope.Type)
+	if err != nil {
+		return err
+	}
+
+	event := sharedmodel.OutboxEvent{
+		AggregateID: envelope.AggregateID,
+		Topic:       topic,
+		EventType:   envelope.Type,
+		Payload:     string(envelope.Payload),
+		Status:      sharedmodel.OutboxStatusPending,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	if _, err := r.collection.InsertOne(ctx, event); err != nil {
+		log.Printf("Error appending %s event: %v", envelope.Type, err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"auth-service/model"
+	"auth-service/utils"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrRefreshTokenInvalid covers unknown, expired, and revoked tokens.
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid or expired")
+	// ErrRefreshTokenReused means an already rotated token was presented; its
+	// family has been revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+)
+
+// RefreshTokenRepository stores hashed refresh tokens.
+type RefreshTokenRepository struct {
+	collection *mongo.Collection
+	ttl        time.Duration
+}
+
+func NewRefreshTokenRepository(client *mongo.Client, database string, collection string, ttl time.Duration) *RefreshTokenRepository {
+	return &RefreshTokenRepository{
+		collection: client.Database(database).Collection(collection),
+		ttl:        ttl,
+	}
+}
+
+// TTL is the lifetime of issued refresh tokens.
+func (r *RefreshTokenRepository) TTL() time.Duration {
+	return r.ttl
+}
+
+// Issue creates a refresh token in a new family, e.g. at login, and returns
+// it with the family ID.
+func (r *RefreshTokenRepository) Issue(ctx context.Context, userID string, tenantID string) (string, string, error) {
+	familyID := primitive.NewObjectID().Hex()
+	token, err := r.issue(ctx, userID, tenantID, familyID)
+	if err != nil {
+		return "", "", err
+	}
+	return token, familyID, nil
+}
+
+func (r *RefreshTokenRepository) issue(ctx context.Context, userID string, tenantID string, familyID string) (string, error) {
+	token, hash, err := utils.NewRefreshToken()
+	if err != nil {
+		return "", fmt.Errorf("error generating refresh token: %w", err)
+	}
+
+	now := time.Now().UTC()
+	record := model.RefreshToken{
+		UserID:    userID,
+		TenantID:  tenantID,
+		FamilyID:  familyID,
+		TokenHash: hash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(r.ttl),
+	}
+	if _, err := r.collection.InsertOne(ctx, record); err != nil {
+		log.Printf("Error storing refresh token: %v", err)
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Rotate consumes token and issues its successor in the same family. It
+// returns the consumed record so the caller knows whom to issue an access
+// token for.
+func (r *RefreshTokenRepository) Rotate(ctx context.Context, token string) (*model.RefreshToken, string, error) {
+	hash := utils.HashRefreshToken(token)
+	now := time.Now().UTC()
+
+	var current model.RefreshToken
+	err := r.collection.FindOne(ctx, bson.M{"tokenHash": hash}).Decode(&current)
+	if err == mongo.ErrNoDocuments {
+		return nil, "", ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("error finding refresh token: %w", err)
+	}
+
+	if current.RotatedAt != nil {
+		r.revokeFamily(ctx, current.FamilyID)
+		return nil, "", ErrRefreshTokenReused
+	}
+	if current.RevokedAt != nil || !now.Before(current.ExpiresAt) {
+		return nil, "", ErrRefreshTokenInvalid
+	}
+
+	// Claim the token atomically; losing the race means it was used twice
+	filter := bson.M{"_id": current.ID, "rotatedAt": nil, "revokedAt": nil}
+	update := bson.M{"$set": bson.M{"rotatedAt": now}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&current); err != nil {
+		if err == mongo.ErrNoDocuments {
+			r.revokeFamily(ctx, current.FamilyID)
+			return nil, "", ErrRefreshTokenReused
+		}
+		return nil, "", fmt.Errorf("error rotating refresh token: %w", err)
+	}
+
+	next, err := r.issue(ctx, current.UserID, current.TenantID, current.FamilyID)
+	if err != nil {
+		return nil, "", err
+	}
+	return &current, next, nil
+}
+
+// Revoke invalidates the family of token, e.g. at logout. Unknown tokens are ignored.
+func (r *RefreshTokenRepository) Revoke(ctx context.Context, token string) error {
+	var current model.RefreshToken
+	err := r.collection.FindOne(ctx, bson.M{"tokenHash": utils.HashRefreshToken(token)}).Decode(&current)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return r.revokeFamily(ctx, current.FamilyID)
+}
+
+// RevokeAllForUser invalidates every refresh token of a user.
+func (r *RefreshTokenRepository) RevokeAllForUser(ctx context.Context, userID string) error {
+	filter := bson.M{"userId": userID, "revokedAt": nil}
+	_, err := r.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revokedAt": time.Now().UTC()}})
+	return err
+}
+
+// RevokeFamily invalidates every refresh token of a family, i.e. of a session.
+func (r *RefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	return r.revokeFamily(ctx, familyID)
+}
+
+func (r *RefreshTokenRepository) revokeFamily(ctx context.Context, familyID string) error {
+	filter := bson.M{"familyId": familyID, "revokedAt": nil}
+	_, err := r.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revokedAt": time.Now().UTC()}})
+	if err != nil {
+		log.Printf("Error revoking refresh token family %s: %v", familyID, err)
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"shared/model"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testRefreshTokens returns a repository issuing tokens valid for ttl, over an
+// empty database on the server at MONGO_TEST_URI, dropped when the test ends.
+// Tests needing it are skipped without one.
+func testRefreshTokens(t *testing.T, ttl time.Duration) *RefreshTokenRepository {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := client.Database(fmt.Sprintf("refresh_token_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+	return NewRefreshTokenRepository(client, db.Name(), model.RefreshTokenCollection, ttl)
+}
+
+func TestRotateIssuesSuccessor(t *testing.T) {
+	r := testRefreshTokens(t, time.Hour)
+	ctx := context.Background()
+
+	token, familyID, err := r.Issue(ctx, "u-1", "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		record, next, err := r.Rotate(ctx, token)
+		if err != nil {
+			t.Fatalf("rotation %d: %v", i, err)
+		}
+		if record.UserID != "u-1" || record.TenantID != "acme" || record.FamilyID != familyID {
+			t.Fatalf("rotation %d consumed %+v, want u-1 of acme in family %s", i, record, familyID)
+		}
+		if record.RotatedAt == nil {
+			t.Fatalf("rotation %d did not mark the token rotated", i)
+		}
+		if next == "" || next == token {
+			t.Fatalf("rotation %d issued %q after %q", i, next, token)
+		}
+		token = next
+	}
+}
+
+func TestRotateRejectsInvalidTokens(t *testing.T) {
+	ctx := context.Background()
+
+	expired := testRefreshTokens(t, -time.Second)
+	token, _, err := expired.Issue(ctx, "u-1", "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := expired.Rotate(ctx, token); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Errorf("Rotate of an expired token = %v, want ErrRefreshTokenInvalid", err)
+	}
+
+	r := testRefreshTokens(t, time.Hour)
+	if _, _, err := r.Rotate(ctx, "not-a-token"); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Errorf("Rotate of an unknown token = %v, want ErrRefreshTokenInvalid", err)
+	}
+
+	revoked, _, err := r.Issue(ctx, "u-1", "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Revoke(ctx, revoked); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.Rotate(ctx, revoked); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Errorf("Rotate of a revoked token = %v, want ErrRefreshTokenInvalid", err)
+	}
+}
+
+func TestReuseRevokesFamily(t *testing.T) {
+	r := testRefreshTokens(t, time.Hour)
+	ctx := context.Background()
+
+	stolen, _, err := r.Issue(ctx, "u-1", "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := r.Issue(ctx, "u-1", "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, next, err := r.Rotate(ctx, stolen)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Presenting the rotated token again gives away that it leaked
+	if _, _, err := r.Rotate(ctx, stolen); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("Rotate of a rotated token = %v, want ErrRefreshTokenReused", err)
+	}
+	// so its successor, which either party may hold, no longer works
+	if _, _, err := r.Rotate(ctx, next); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Errorf("Rotate of the successor after reuse = %v, want ErrRefreshTokenInvalid", err)
+	}
+	// while the user's other sessions are left alone
+	if _, _, err := r.Rotate(ctx, other); err != nil {
+		t.Errorf("Rotate in another family after reuse = %v", err)
+	}
+}
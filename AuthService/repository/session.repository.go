@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"auth-service/model"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SessionRepository stores the sessions behind refresh token families. A
+// session expires once it has gone unused for the refresh token lifetime.
+type SessionRepository struct {
+	collection *mongo.Collection
+	ttl        time.Duration
+}
+
+func NewSessionRepository(client *mongo.Client, database string, collection string, ttl time.Duration) *SessionRepository {
+	return &SessionRepository{
+		collection: client.Database(database).Collection(collection),
+		ttl:        ttl,
+	}
+}
+
+// Create stores a new session, e.g. at login.
+func (r *SessionRepository) Create(ctx context.Context, session model.Session) error {
+	now := time.Now().UTC()
+	session.CreatedAt = now
+	session.LastUsedAt = now
+	session.ExpiresAt = now.Add(r.ttl)
+
+	if _, err := r.collection.InsertOne(ctx, session); err != nil {
+		log.Printf("Error storing session: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Touch records that the session was used, e.g. by a refresh, from ip and userAgent.
+func (r *SessionRepository) Touch(ctx context.Context, id string, ip string, userAgent string) error {
+	now := time.Now().UTC()
+	update := bson.M{"$set": bson.M{
+		"lastUsedAt": now,
+		"expiresAt":  now.Add(r.ttl),
+		"ip":         ip,
+		"userAgent":  userAgent,
+	}}
+	if _, err := r.collection.UpdateOne(ctx, bson.M{"_id": id, "revokedAt": nil}, update); err != nil {
+		return fmt.Errorf("error updating session: %w", err)
+	}
+	return nil
+}
+
+// FindActive returns the unrevoked sessions of a user, most recently used first.
+func (r *SessionRepository) FindActive(ctx context.Context, userID string) ([]model.Session, error) {
+	filter := bson.M{"userId": userID, "revokedAt": nil, "expiresAt": bson.M{"$gt": time.Now().UTC()}}
+	opts := options.Find().SetSort(bson.D{{Key: "lastUsedAt", Value: -1}})
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error finding sessions: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	sessions := []model.Session{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, fmt.Errorf("error decoding sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// Revoke revokes the session id of userID. It reports whether an active
+// session matched.
+func (r *SessionRepository) Revoke(ctx context.Context, userID string, id string) (bool, error) {
+	filter := bson.M{"_id": id, "userId": userID, "revokedAt": nil}
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"revokedAt": time.Now().UTC()}})
+	if err != nil {
+		return false, fmt.Errorf("error revoking session: %w", err)
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// RevokeOthers revokes every active session of userID except keepID, returning
+// the IDs it revoked. An empty keepID revokes them all.
+func (r *SessionRepository) RevokeOthers(ctx context.Context, userID string, keepID string) ([]string, error) {
+	sessions, err := r.FindActive(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, session := range sessions {
+		if session.ID != keepID {
+			ids = append(ids, session.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+
+	filter := bson.M{"_id": bson.M{"$in": ids}, "revokedAt": nil}
+	if _, err := r.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revokedAt": time.Now().UTC()}}); err != nil {
+		return nil, fmt.Errorf("error revoking sessions: %w", err)
+	}
+	return ids, nil
+}
@@ -1,38 +1,118 @@
 package repository
 
 import (
+	authdb "auth-service/database"
 	"auth-service/model"
+	"auth-service/utils"
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"regexp"
+	"shared/authz"
+	"shared/events"
+	"shared/tenant"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrEmailAlreadyRegistered is returned by CreateUser when the email is taken.
+// The unique email_unique index enforces it, so concurrent registrations
+// cannot both succeed.
+var ErrEmailAlreadyRegistered = errors.New("email already registered")
+
+// ErrUsernameTaken is returned by CreateUser when another account has the same
+// username, compared case-insensitively by the nameKey_unique index.
+var ErrUsernameTaken = errors.New("username already taken")
+
+// ErrPasswordChanged is returned by UpdatePassword when the stored password
+// no longer matches the one the change was verified against.
+var ErrPasswordChanged = errors.New("password was changed concurrently")
+
 // UserRepository handles all database interactions for the User model.
 type UserRepository struct {
-	collection *mongo.Collection
+	client       *mongo.Client
+	collection   *mongo.Collection
+	outbox       *OutboxRepository
+	transactions bool
 }
 
 // NewUserRepository creates a new repository instance.
-func NewUserRepository(client *mongo.Client, database string, collection string) *UserRepository {
+func NewUserRepository(client *mongo.Client, database string, collection string, outbox *OutboxRepository) *UserRepository {
 	// The client, database name, and collection name are passed during initialization.
 	coll := client.Database(database).Collection(collection)
 	return &UserRepository{
-		collection: coll,
+		client:       client,
+		collection:   coll,
+		outbox:       outbox,
+		transactions: authdb.SupportsTransactions(client),
+	}
+}
+
+// withTransaction runs fn inside a multi-document transaction when the deployment
+// supports it. On a standalone server fn runs directly (best-effort).
+func (r *UserRepository) withTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !r.transactions {
+		return fn(ctx)
+	}
+
+	session, err := r.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("error starting session: %w", err)
 	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// NormalizeEmail matches the normalization applied to stored emails by the
+// normalize_user_emails migration, which the unique email index relies on.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// UsernameKey is the form usernames are compared in for uniqueness.
+func UsernameKey(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
 }
 
 // Save inserts a new User document into the collection.
 func (r *UserRepository) CreateUser(ctx context.Context, user model.User) (model.User, error) {
-	// Set the joined date before saving
+	// Set the joined date and tenant before saving
 	user.JoinedAt = time.Now()
+	user.TenantID = tenant.Normalize(user.TenantID)
+	user.Email = NormalizeEmail(user.Email)
+	user.UsernameKey = UsernameKey(user.Username)
+	user.Role = authz.NormalizeRole(user.Role)
+
+	// Never persist a plaintext password. Accounts created through an
+	// identity provider have none.
+	if user.Password != "" && !utils.IsPasswordHash(user.Password) {
+		hash, err := utils.HashPassword(user.Password)
+		if err != nil {
+			log.Printf("Error hashing password: %v", err)
+			return model.User{}, err
+		}
+		user.Password = hash
+	}
 
 	// Insert the document
 	result, err := r.collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		// The error names the unique index that was violated
+		if strings.Contains(err.Error(), "nameKey_unique") {
+			return model.User{}, ErrUsernameTaken
+		}
+		return model.User{}, ErrEmailAlreadyRegistered
+	}
 	if err != nil {
 		log.Printf("Error inserting user: %v", err)
 		return model.User{}, err
@@ -46,12 +126,80 @@ func (r *UserRepository) CreateUser(ctx context.Context, user model.User) (model
 	return user, nil
 }
 
-// FindAll retrieves all User documents.
-func (r *UserRepository) FindAll(ctx context.Context) ([]model.User, error) {
+// UsernameAvailable reports whether no account uses username.
+func (r *UserRepository) UsernameAvailable(ctx context.Context, username string) (bool, error) {
+	count, err := r.collection.CountDocuments(ctx, bson.M{"nameKey": UsernameKey(username)}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, fmt.Errorf("error checking username: %w", err)
+	}
+	return count == 0, nil
+}
+
+// SuggestUsername returns the first free username of the form "<username><n>",
+// or "" when none of the candidates tried is free.
+func (r *UserRepository) SuggestUsername(ctx context.Context, username string) (string, error) {
+	const candidates = 20
+
+	keys := make([]string, 0, candidates)
+	for n := 2; n < 2+candidates; n++ {
+		keys = append(keys, UsernameKey(fmt.Sprintf("%s%d", username, n)))
+	}
+
+	cursor, err := r.collection.Find(ctx, bson.M{"nameKey": bson.M{"$in": keys}}, options.Find().SetProjection(bson.M{"nameKey": 1}))
+	if err != nil {
+		return "", fmt.Errorf("error suggesting username: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var taken []model.User
+	if err := cursor.All(ctx, &taken); err != nil {
+		return "", fmt.Errorf("error suggesting username: %w", err)
+	}
+	used := make(map[string]bool, len(taken))
+	for _, user := range taken {
+		used[user.UsernameKey] = true
+	}
+
+	for n, key := range keys {
+		if !used[key] {
+			return fmt.Sprintf("%s%d", strings.TrimSpace(username), n+2), nil
+		}
+	}
+	return "", nil
+}
+
+// inTenant scopes filter to the users of tenantID. Users created before
+// tenants existed have no tenantId and belong to the default tenant.
+func inTenant(filter bson.M, tenantID string) bson.M {
+	tenantID = tenant.Normalize(tenantID)
+	if tenant.IsDefault(tenantID) {
+		filter["tenantId"] = bson.M{"$in": bson.A{tenantID, nil}}
+	} else {
+		filter["tenantId"] = tenantID
+	}
+	return filter
+}
+
+// searchFilter matches the users of the tenant whose username or email
+// contains query. The query is matched literally, not as a pattern.
+func searchFilter(tenantID string, query string) bson.M {
+	// Note: In your model.User, Username has `bson:"name"`.
+	// So we must search the "name" field in MongoDB, not "username".
+	pattern := regexp.QuoteMeta(query)
+	return inTenant(bson.M{
+		"$or": []bson.M{
+			{"name": bson.M{"$regex": pattern, "$options": "i"}},
+			{"email": bson.M{"$regex": pattern, "$options": "i"}},
+		},
+	}, tenantID)
+}
+
+// FindAll retrieves all User documents of the tenant.
+func (r *UserRepository) FindAll(ctx context.Context, tenantID string) ([]model.User, error) {
 	var users []model.User
 
 	// Create a context for the find operation (use a short timeout if required, but context from handler is usually sufficient)
-	cursor, err := r.collection.Find(ctx, bson.M{})
+	cursor, err := r.collection.Find(ctx, inTenant(bson.M{}, tenantID))
 	if err != nil {
 		log.Printf("Error finding users: %v", err)
 		return nil, err
@@ -69,7 +217,7 @@ func (r *UserRepository) FindAll(ctx context.Context) ([]model.User, error) {
 
 func (r *UserRepository) FindUserByEmail(ctx context.Context, email string) (*model.User, error) {
 	// 1. Define the filter
-	filter := bson.M{"email": email}
+	filter := bson.M{"email": NormalizeEmail(email)}
 
 	var user model.User
 
@@ -90,17 +238,207 @@ func (r *UserRepository) FindUserByEmail(ctx context.Context, email string) (*mo
 
 	return &user, nil
 }
-func (r *UserRepository) FindByQuery(ctx context.Context, query string) ([]model.User, error) {
-	// Note: In your model.User, Username has `bson:"name"`.
-	// So we must search the "name" field in MongoDB, not "username".
-	filter := bson.M{
-		"$or": []bson.M{
-			{"name": bson.M{"$regex": query, "$options": "i"}},
-			{"email": bson.M{"$regex": query, "$options": "i"}},
-		},
+
+// FindUserByID returns the user with the given hex id, or nil when it does not exist.
+func (r *UserRepository) FindUserByID(ctx context.Context, id string) (*model.User, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, nil
+	}
+
+	var user model.User
+	err = r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error finding user by id: %w", err)
+	}
+
+	return &user, nil
+}
+
+// FindUsersByIDs returns the users among ids in a single query. IDs without a
+// user are left out.
+func (r *UserRepository) FindUsersByIDs(ctx context.Context, ids []primitive.ObjectID) ([]model.User, error) {
+	users := []model.User{}
+	if len(ids) == 0 {
+		return users, nil
+	}
+
+	opts := options.Find().SetProjection(bson.M{"name": 1, "email": 1, "tenantId": 1})
+	cursor, err := r.collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error finding users by id: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("error decoding users: %w", err)
+	}
+	return users, nil
+}
+
+// UserUpdate lists the profile fields to change; nil fields are left as they are.
+type UserUpdate struct {
+	Username    *string
+	DisplayName *string
+	AvatarURL   *string
+}
+
+// UpdateUser applies update to the user and returns the updated user, or nil
+// when it does not exist. A username another account holds is rejected with
+// ErrUsernameTaken.
+func (r *UserRepository) UpdateUser(ctx context.Context, id primitive.ObjectID, update UserUpdate) (*model.User, error) {
+	set := bson.M{}
+	unset := bson.M{}
+	if update.Username != nil {
+		set["name"] = *update.Username
+		set["nameKey"] = UsernameKey(*update.Username)
+	}
+	for field, value := range map[string]*string{"displayName": update.DisplayName, "avatarUrl": update.AvatarURL} {
+		switch {
+		case value == nil:
+		case *value == "":
+			unset[field] = ""
+		default:
+			set[field] = *value
+		}
+	}
+
+	changes := bson.M{}
+	if len(set) > 0 {
+		changes["$set"] = set
+	}
+	if len(unset) > 0 {
+		changes["$unset"] = unset
+	}
+	if len(changes) == 0 {
+		return r.FindUserByID(ctx, id.Hex())
+	}
+
+	var user model.User
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := r.collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, changes, opts).Decode(&user)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrUsernameTaken
+	}
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Error updating user: %v", err)
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdatePasswordHash replaces the stored password of a user with hash.
+func (r *UserRepository) UpdatePasswordHash(ctx context.Context, id primitive.ObjectID, hash string) error {
+	if !utils.IsPasswordHash(hash) {
+		return fmt.Errorf("refusing to store an unhashed password")
+	}
+
+	_, err := r.collection.UpdateByID(ctx, id, bson.M{"$set": bson.M{"password": hash}})
+	if err != nil {
+		log.Printf("Error updating password hash: %v", err)
+		return err
+	}
+	return nil
+}
+
+// UpdatePassword hashes newPassword and stores it, provided the stored hash is
+// still currentHash. This keeps two concurrent changes from both succeeding.
+func (r *UserRepository) UpdatePassword(ctx context.Context, id primitive.ObjectID, currentHash string, newPassword string) error {
+	hash, err := utils.HashPassword(newPassword)
+	if err != nil {
+		log.Printf("Error hashing password: %v", err)
+		return err
+	}
+
+	filter := bson.M{"_id": id, "password": currentHash}
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"password": hash}})
+	if err != nil {
+		log.Printf("Error updating password: %v", err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrPasswordChanged
+	}
+	return nil
+}
+
+// LinkGoogle links the user to the Google account subject. Google has
+// verified the email, so the user is marked verified too.
+func (r *UserRepository) LinkGoogle(ctx context.Context, id primitive.ObjectID, subject string) error {
+	_, err := r.collection.UpdateByID(ctx, id, bson.M{"$set": bson.M{"googleId": subject, "verified": true}})
+	if err != nil {
+		log.Printf("Error linking Google account: %v", err)
+		return err
+	}
+	return nil
+}
+
+// SetRole assigns role to the user with the given email. It reports whether a
+// user matched.
+func (r *UserRepository) SetRole(ctx context.Context, email string, role string) (bool, error) {
+	filter := bson.M{"email": NormalizeEmail(email)}
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"role": role}})
+	if err != nil {
+		log.Printf("Error setting user role: %v", err)
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// MarkEmailVerified flags the user as verified, provided their email is still
+// the one the verification token was issued for. It reports whether a user matched.
+func (r *UserRepository) MarkEmailVerified(ctx context.Context, id string, email string) (bool, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, nil
+	}
+
+	filter := bson.M{"_id": objectID, "email": NormalizeEmail(email)}
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"verified": true}})
+	if err != nil {
+		log.Printf("Error marking email verified: %v", err)
+		return false, err
 	}
+	return result.MatchedCount == 1, nil
+}
+
+// DeleteUser removes the user together with a user.deleted outbox event, so
+// the documents and shares it leaves behind are always cleaned up by the
+// services consuming that event.
+func (r *UserRepository) DeleteUser(ctx context.Context, user *model.User) error {
+	err := r.withTransaction(ctx, func(ctx context.Context) error {
+		result, err := r.collection.DeleteOne(ctx, bson.M{"_id": user.ID})
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			// Already deleted by a concurrent request, which emitted the event
+			return nil
+		}
+
+		return r.outbox.Append(ctx, events.UserDeletedEvent{
+			UserID:     user.ID.Hex(),
+			TenantID:   tenant.Normalize(user.TenantID),
+			OccurredAt: time.Now().UTC(),
+		})
+	})
+	if err != nil {
+		log.Printf("Error deleting user: %v", err)
+		return err
+	}
+	return nil
+}
 
-	cursor, err := r.collection.Find(ctx, filter)
+// FindByQuery returns the users of the tenant whose username or email
+// contains query, ignoring case.
+func (r *UserRepository) FindByQuery(ctx context.Context, tenantID string, query string) ([]model.User, error) {
+	cursor, err := r.collection.Find(ctx, searchFilter(tenantID, query))
 	if err != nil {
 		log.Printf("Error searching users: %v", err)
 		return nil, err
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"regexp"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSearchFilterMatchesQueryLiterally(t *testing.T) {
+	filter := searchFilter("acme", `a.b*(c`)
+
+	clauses, ok := filter["$or"].([]bson.M)
+	if !ok || len(clauses) != 2 {
+		t.Fatalf("filter $or = %v, want a name and an email clause", filter["$or"])
+	}
+	for _, clause := range clauses {
+		for field, cond := range clause {
+			pattern := cond.(bson.M)["$regex"].(string)
+			re := regexp.MustCompile(pattern)
+			if !re.MatchString(`xa.b*(cx`) {
+				t.Errorf("%s pattern %q does not match the query itself", field, pattern)
+			}
+			if re.MatchString(`axbbbc`) {
+				t.Errorf("%s pattern %q treats the query as a pattern", field, pattern)
+			}
+		}
+	}
+}
+
+func TestSearchFilterIsScopedToTenant(t *testing.T) {
+	if got := searchFilter("acme", "bob")["tenantId"]; got != "acme" {
+		t.Errorf("tenantId filter = %v, want acme", got)
+	}
+
+	// Users created before tenants existed have no tenantId and belong to
+	// the default tenant, and only to it
+	for _, tenantID := range []string{"", "default"} {
+		got, ok := searchFilter(tenantID, "bob")["tenantId"].(bson.M)
+		if !ok {
+			t.Fatalf("tenantId filter for %q = %v, want $in", tenantID, searchFilter(tenantID, "bob")["tenantId"])
+		}
+		in := got["$in"].(bson.A)
+		if len(in) != 2 || in[0] != "default" || in[1] != nil {
+			t.Errorf("tenantId filter for %q = %v, want default or missing", tenantID, got)
+		}
+	}
+}
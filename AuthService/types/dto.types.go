@@ -0,0 +1,245 @@
+package types
+
+import (
+	"auth-service/model"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"shared/authz"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	MinPasswordLength    = 8
+	MaxPasswordLength    = 72 // bcrypt ignores anything past 72 bytes
+	MinUsernameLength    = 3
+	MaxUsernameLength    = 32
+	MaxResolveIDs        = 100
+	MaxDisplayNameLength = 64
+	MaxAvatarURLLength   = 2048
+)
+
+// usernamePattern keeps usernames safe to show and to put in headers:
+// letters, digits, '_', '.', and '-', starting with a letter or digit.
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// RegisterUserData is the accepted registration payload. Anything else in the
+// request body (e.g. _id or joinedAt) is ignored.
+type RegisterUserData struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Normalize trims the username and trims and lowercases the email.
+func (d *RegisterUserData) Normalize() {
+	d.Username = strings.TrimSpace(d.Username)
+	d.Email = strings.ToLower(strings.TrimSpace(d.Email))
+}
+
+// Validate returns one error per invalid field, or nil when the payload is valid.
+// Call Normalize first.
+func (d RegisterUserData) Validate() []FieldError {
+	var errs []FieldError
+
+	if err := ValidateUsername(d.Username); err != nil {
+		errs = append(errs, *err)
+	}
+
+	switch {
+	case d.Email == "":
+		errs = append(errs, FieldError{Field: "email", Message: "email is required"})
+	case !isValidEmail(d.Email):
+		errs = append(errs, FieldError{Field: "email", Message: "email is not a valid address"})
+	}
+
+	if err := validatePassword("password", d.Password); err != nil {
+		errs = append(errs, *err)
+	}
+
+	return errs
+}
+
+// ChangePasswordData is the payload of an authenticated password change.
+type ChangePasswordData struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// Validate applies the registration strength rules to the new password.
+func (d ChangePasswordData) Validate() []FieldError {
+	var errs []FieldError
+
+	if d.CurrentPassword == "" {
+		errs = append(errs, FieldError{Field: "current_password", Message: "current_password is required"})
+	}
+	if err := validatePassword("new_password", d.NewPassword); err != nil {
+		errs = append(errs, *err)
+	}
+
+	return errs
+}
+
+// UpdateProfileData is a partial profile update; omitted fields are left
+// unchanged. An empty display name or avatar URL clears it.
+type UpdateProfileData struct {
+	Username    *string `json:"username"`
+	DisplayName *string `json:"displayName"`
+	AvatarURL   *string `json:"avatarUrl"`
+}
+
+// Normalize trims every given field.
+func (d *UpdateProfileData) Normalize() {
+	for _, field := range []*string{d.Username, d.DisplayName, d.AvatarURL} {
+		if field != nil {
+			*field = strings.TrimSpace(*field)
+		}
+	}
+}
+
+// Validate returns one error per invalid field, or nil when the payload is
+// valid. Call Normalize first.
+func (d UpdateProfileData) Validate() []FieldError {
+	if d.Username == nil && d.DisplayName == nil && d.AvatarURL == nil {
+		return []FieldError{{Field: "body", Message: "at least one of username, displayName, and avatarUrl is required"}}
+	}
+
+	var errs []FieldError
+	if d.Username != nil {
+		if err := ValidateUsername(*d.Username); err != nil {
+			errs = append(errs, *err)
+		}
+	}
+	if d.DisplayName != nil && utf8.RuneCountInString(*d.DisplayName) > MaxDisplayNameLength {
+		errs = append(errs, FieldError{Field: "displayName", Message: "displayName must be at most 64 characters"})
+	}
+	if d.AvatarURL != nil && *d.AvatarURL != "" {
+		switch {
+		case len(*d.AvatarURL) > MaxAvatarURLLength:
+			errs = append(errs, FieldError{Field: "avatarUrl", Message: "avatarUrl must be at most 2048 bytes"})
+		case !isValidHTTPURL(*d.AvatarURL):
+			errs = append(errs, FieldError{Field: "avatarUrl", Message: "avatarUrl must be an http or https URL"})
+		}
+	}
+	return errs
+}
+
+// ResolveUsersData is the payload of a batch user ID lookup.
+type ResolveUsersData struct {
+	IDs []string `json:"ids"`
+}
+
+// Validate returns one error per malformed ID, or one for the list when it is
+// empty or longer than MaxResolveIDs.
+func (d ResolveUsersData) Validate() []FieldError {
+	switch {
+	case len(d.IDs) == 0:
+		return []FieldError{{Field: "ids", Message: "ids is required"}}
+	case len(d.IDs) > MaxResolveIDs:
+		return []FieldError{{Field: "ids", Message: "ids must list at most 100 ids"}}
+	}
+
+	var errs []FieldError
+	for i, id := range d.IDs {
+		if !primitive.IsValidObjectID(id) {
+			errs = append(errs, FieldError{Field: fmt.Sprintf("ids[%d]", i), Message: fmt.Sprintf("%q is not a valid id", id)})
+		}
+	}
+	return errs
+}
+
+// ObjectIDs returns the IDs without duplicates. Call Validate first.
+func (d ResolveUsersData) ObjectIDs() []primitive.ObjectID {
+	seen := make(map[primitive.ObjectID]bool, len(d.IDs))
+	ids := make([]primitive.ObjectID, 0, len(d.IDs))
+	for _, id := range d.IDs {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil || seen[objectID] {
+			continue
+		}
+		seen[objectID] = true
+		ids = append(ids, objectID)
+	}
+	return ids
+}
+
+// ValidateUsername checks a trimmed username against the length and
+// character rules.
+func ValidateUsername(username string) *FieldError {
+	switch {
+	case username == "":
+		return &FieldError{Field: "username", Message: "username is required"}
+	case utf8.RuneCountInString(username) < MinUsernameLength:
+		return &FieldError{Field: "username", Message: "username must be at least 3 characters"}
+	case utf8.RuneCountInString(username) > MaxUsernameLength:
+		return &FieldError{Field: "username", Message: "username must be at most 32 characters"}
+	case !usernamePattern.MatchString(username):
+		return &FieldError{Field: "username", Message: "username may only contain letters, digits, '_', '.', and '-'"}
+	}
+	return nil
+}
+
+func validatePassword(field string, password string) *FieldError {
+	switch {
+	case password == "":
+		return &FieldError{Field: field, Message: field + " is required"}
+	case utf8.RuneCountInString(password) < MinPasswordLength:
+		return &FieldError{Field: field, Message: field + " must be at least 8 characters"}
+	case len(password) > MaxPasswordLength:
+		return &FieldError{Field: field, Message: field + " must be at most 72 bytes"}
+	}
+	return nil
+}
+
+// isValidEmail accepts a bare address with a dotted domain, e.g. a@b.co,
+// and rejects display-name forms like "Bob <bob@example.com>".
+func isValidEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return false
+	}
+	at := strings.LastIndex(email, "@")
+	domain := email[at+1:]
+	return strings.Contains(domain, ".") && !strings.HasPrefix(domain, ".") && !strings.HasSuffix(domain, ".")
+}
+
+func isValidHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// UserProfile is the public view of a user. Build it with NewUserProfile
+// rather than serializing model.User, so the password hash never leaves.
+type UserProfile struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	DisplayName string `json:"displayName,omitempty"`
+	AvatarURL   string `json:"avatarUrl,omitempty"`
+}
+
+func NewUserProfile(user model.User) UserProfile {
+	return UserProfile{
+		ID:        user.ID.Hex(),
+		Username:  user.Username,
+		Email:     user.Email,
+		Role:      authz.NormalizeRole(user.Role),
+		CreatedAt: user.JoinedAt,
+
+		DisplayName: user.DisplayName,
+		AvatarURL:   user.AvatarURL,
+	}
+}
@@ -0,0 +1,90 @@
+package types
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fields returns the fields of errs, in order.
+func fields(errs []FieldError) []string {
+	var names []string
+	for _, err := range errs {
+		names = append(names, err.Field)
+	}
+	return names
+}
+
+func TestRegisterUserDataValidate(t *testing.T) {
+	valid := RegisterUserData{Username: "alice", Email: "alice@example.com", Password: "correct horse"}
+
+	tests := []struct {
+		name string
+		edit func(d *RegisterUserData)
+		want []string
+	}{
+		{"valid", func(d *RegisterUserData) {}, nil},
+		{"everything missing", func(d *RegisterUserData) { *d = RegisterUserData{} }, []string{"username", "email", "password"}},
+		{"username too short", func(d *RegisterUserData) { d.Username = "al" }, []string{"username"}},
+		{"username too long", func(d *RegisterUserData) { d.Username = strings.Repeat("a", MaxUsernameLength+1) }, []string{"username"}},
+		{"username at the limit", func(d *RegisterUserData) { d.Username = strings.Repeat("a", MaxUsernameLength) }, nil},
+		{"username with a space", func(d *RegisterUserData) { d.Username = "alice smith" }, []string{"username"}},
+		{"username starting with a dot", func(d *RegisterUserData) { d.Username = ".alice" }, []string{"username"}},
+		{"email without @", func(d *RegisterUserData) { d.Email = "alice.example.com" }, []string{"email"}},
+		{"email without a dotted domain", func(d *RegisterUserData) { d.Email = "alice@localhost" }, []string{"email"}},
+		{"email with a trailing dot", func(d *RegisterUserData) { d.Email = "alice@example." }, []string{"email"}},
+		{"email with a display name", func(d *RegisterUserData) { d.Email = "Alice <alice@example.com>" }, []string{"email"}},
+		{"password too short", func(d *RegisterUserData) { d.Password = "1234567" }, []string{"password"}},
+		{"password at the minimum", func(d *RegisterUserData) { d.Password = "12345678" }, nil},
+		// bcrypt ignores what is past 72 bytes, however many runes
+		{"password too long", func(d *RegisterUserData) { d.Password = strings.Repeat("é", 37) }, []string{"password"}},
+		{"two invalid fields", func(d *RegisterUserData) { d.Email, d.Password = "", "short" }, []string{"email", "password"}},
+	}
+	for _, tt := range tests {
+		data := valid
+		tt.edit(&data)
+		if got := fields(data.Validate()); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: invalid fields %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRegisterUserDataNormalize(t *testing.T) {
+	data := RegisterUserData{Username: "  alice ", Email: " Alice@Example.COM ", Password: " secret  words "}
+	data.Normalize()
+
+	want := RegisterUserData{Username: "alice", Email: "alice@example.com", Password: " secret  words "}
+	if data != want {
+		t.Errorf("Normalize = %+v, want %+v; passwords are kept as typed", data, want)
+	}
+}
+
+func TestRegisterUserDataIgnoresOtherFields(t *testing.T) {
+	var data RegisterUserData
+	body := `{"username":"alice","email":"alice@example.com","password":"correct horse","_id":"65a1f0c2e4b0a1b2c3d4e5f6","role":"admin","joinedAt":"2020-01-01T00:00:00Z"}`
+	if err := json.Unmarshal([]byte(body), &data); err != nil {
+		t.Fatal(err)
+	}
+	if want := (RegisterUserData{Username: "alice", Email: "alice@example.com", Password: "correct horse"}); data != want {
+		t.Errorf("decoded %+v, want %+v", data, want)
+	}
+}
+
+func TestChangePasswordDataValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		data ChangePasswordData
+		want []string
+	}{
+		{"valid", ChangePasswordData{CurrentPassword: "old password", NewPassword: "new password"}, nil},
+		{"no current password", ChangePasswordData{NewPassword: "new password"}, []string{"current_password"}},
+		{"weak new password", ChangePasswordData{CurrentPassword: "old password", NewPassword: "short"}, []string{"new_password"}},
+		{"nothing", ChangePasswordData{}, []string{"current_password", "new_password"}},
+	}
+	for _, tt := range tests {
+		if got := fields(tt.data.Validate()); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: invalid fields %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,39 +1,103 @@
 package utils
 
 import (
+	"auth-service/config"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"shared/authz"
+	"shared/tenant"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type CustomClaims struct {
-	UserID   string `json:"user_id`
+	UserID   string `json:"user_id"`
 	Username string `json:"username"`
 	Email    string `json:"email"`
+	TenantID string `json:"tenant_id,omitempty"`
+	Role     string `json:"role,omitempty"`
+	// SessionID names the session (refresh token family) the token was issued to
+	SessionID string `json:"sid,omitempty"`
+	// Purpose is set on single-use tokens such as email verification links;
+	// access tokens have none, and ParseToken refuses tokens that do.
+	Purpose string `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
 }
 
-var jwtSecret = []byte("my_super_secret_key")
+// PurposeVerifyEmail marks email verification tokens.
+const PurposeVerifyEmail = "verify_email"
 
-func CreateToken(userID string, email string, username string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+// ErrUnknownKeyID is returned for tokens signed with a key that is no longer accepted.
+var ErrUnknownKeyID = errors.New("token signed with an unknown key")
+
+func CreateToken(userID string, email string, username string, tenantID string, role string, sessionID string) (string, error) {
+	now := time.Now()
+	expirationTime := now.Add(config.TokenConfig.AccessTokenTTL)
 
 	// create custom claims object
 	claims := &CustomClaims{
-		UserID:   userID,
-		Email:    email,
-		Username: username,
+		UserID:    userID,
+		Email:     email,
+		Username:  username,
+		TenantID:  tenant.Normalize(tenantID),
+		Role:      authz.NormalizeRole(role),
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Subject:   userID,
+			ID:        newTokenID(),
+		},
+	}
+
+	return signClaims(claims)
+}
+
+// CreatePurposeToken creates a token for userID and email that is only
+// accepted by ParsePurposeToken with the same purpose.
+func CreatePurposeToken(userID string, email string, purpose string, ttl time.Duration) (string, error) {
+	claims := &CustomClaims{
+		UserID:  userID,
+		Email:   email,
+		Purpose: purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
 			Subject:   userID,
+			ID:        newTokenID(),
 		},
 	}
 
+	return signClaims(claims)
+}
+
+func signClaims(claims *CustomClaims) (string, error) {
+	// Read the keys per token so a SIGHUP reload applies to the next one
+	rsaKeys, useRSA, err := config.RSAKeys()
+	if err != nil {
+		return "", err
+	}
+	if useRSA {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = rsaKeys.Active
+		return token.SignedString(rsaKeys.Private)
+	}
+
+	keys, err := config.SigningKeys()
+	if err != nil {
+		return "", err
+	}
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = keys.Active
 
-	tokenString, err := token.SignedString(jwtSecret)
+	tokenString, err := token.SignedString(keys.Keys[keys.Active])
 
 	if err != nil {
 		return "", err
@@ -42,15 +106,102 @@ func CreateToken(userID string, email string, username string) (string, error) {
 	return tokenString, nil
 }
 
+func newTokenID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// TokenID identifies an access token for revocation. Tokens minted before
+// tokens carried a jti are identified by the hash of the token itself.
+func TokenID(tokenString string, claims *CustomClaims) string {
+	if claims.ID != "" {
+		return claims.ID
+	}
+	return HashRefreshToken(tokenString)
+}
+
+// rsaVerificationKey selects the public key named by the token's kid header.
+func rsaVerificationKey(token *jwt.Token) (*rsa.PublicKey, error) {
+	keys, useRSA, err := config.RSAKeys()
+	if err != nil {
+		return nil, err
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, ok := keys.Public[kid]
+	if !useRSA || !ok {
+		return nil, ErrUnknownKeyID
+	}
+	return key, nil
+}
+
+// verificationKey selects the key named by the token's kid header. Tokens
+// minted before kids were stamped are verified with the active key. Once
+// signing has moved to RS256, HS256 tokens are only accepted with AcceptHS256.
+func verificationKey(token *jwt.Token) ([]byte, error) {
+	if _, useRSA, _ := config.RSAKeys(); useRSA && !config.TokenConfig.AcceptHS256 {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	keys, err := config.SigningKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return keys.Keys[keys.Active], nil
+	}
+
+	key, ok := keys.Keys[kid]
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+	return key, nil
+}
+
+// ParseToken validates an access token.
 func ParseToken(tokenString string) (*CustomClaims, error) {
+	claims, err := parseClaims(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return claims, nil
+}
+
+// ParsePurposeToken validates a token created by CreatePurposeToken for purpose.
+func ParsePurposeToken(tokenString string, purpose string) (*CustomClaims, error) {
+	claims, err := parseClaims(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purpose {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return claims, nil
+}
+
+func parseClaims(tokenString string) (*CustomClaims, error) {
 	claims := &CustomClaims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		// KeyFunc provides the secret key to the library for verification
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		// KeyFunc provides the key to the library for verification
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA:
+			return rsaVerificationKey(token)
+		case *jwt.SigningMethodHMAC:
+			return verificationKey(token)
 		}
-		return jwtSecret, nil
-	})
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodHS256.Alg()}),
+		// exp is mandatory; exp, nbf, and a future iat are checked with leeway
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(config.TokenConfig.Leeway),
+	)
 
 	// Check for parsing errors
 	if err != nil {
@@ -62,6 +213,11 @@ func ParseToken(tokenString string) (*CustomClaims, error) {
 		return nil, fmt.Errorf("invalid token")
 	}
 
+	// Tokens minted before tenants or roles existed belong to the default
+	// tenant and carry the user role
+	claims.TenantID = tenant.Normalize(claims.TenantID)
+	claims.Role = authz.NormalizeRole(claims.Role)
+
 	// If valid, return the claims
 	return claims, nil
 }
@@ -0,0 +1,257 @@
+package utils
+
+import (
+	"auth-service/config"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// useSecret signs and verifies the test's tokens with a JWT_SECRET.
+func useSecret(t *testing.T, secret string) {
+	t.Helper()
+	t.Setenv("JWT_SECRET", secret)
+	if err := config.Secrets.Load(); err != nil {
+		t.Fatalf("loading secrets: %v", err)
+	}
+}
+
+func TestTokenCarriesTenant(t *testing.T) {
+	useSecret(t, "test-secret")
+
+	tests := []struct {
+		tenantID string
+		want     string
+	}{
+		{"acme", "acme"},
+		{"", "default"},
+		{"  ", "default"},
+	}
+	for _, tt := range tests {
+		token, err := CreateToken("user-1", "a@example.com", "alice", tt.tenantID, "", "")
+		if err != nil {
+			t.Fatalf("CreateToken: %v", err)
+		}
+		claims, err := ParseToken(token)
+		if err != nil {
+			t.Fatalf("ParseToken: %v", err)
+		}
+		if claims.TenantID != tt.want {
+			t.Errorf("tenant %q: token carries %q, want %q", tt.tenantID, claims.TenantID, tt.want)
+		}
+	}
+}
+
+func TestReloadSwapsVerificationKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "jwt_secret")
+	os.WriteFile(keyFile, []byte("old-secret"), 0o600)
+	t.Setenv("JWT_SECRET_FILE", keyFile)
+	if err := config.Secrets.Load(); err != nil {
+		t.Fatalf("loading secrets: %v", err)
+	}
+
+	oldToken, err := CreateToken("user-1", "a@example.com", "alice", "", "", "")
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+
+	os.WriteFile(keyFile, []byte("new-secret"), 0o600)
+	if err := config.Secrets.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	if _, err := ParseToken(oldToken); err == nil {
+		t.Error("token signed with the replaced secret still verifies")
+	}
+	newToken, err := CreateToken("user-1", "a@example.com", "alice", "", "", "")
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+	if _, err := ParseToken(newToken); err != nil {
+		t.Errorf("token signed after the reload: %v", err)
+	}
+}
+
+// useKeys signs and verifies the test's tokens with JWT_KEYS, signing with active.
+func useKeys(t *testing.T, keys string, active string) {
+	t.Helper()
+	t.Setenv("JWT_KEYS", keys)
+	t.Setenv("JWT_ACTIVE_KID", active)
+	if err := config.Secrets.Load(); err != nil {
+		t.Fatalf("loading secrets: %v", err)
+	}
+}
+
+func TestRetiredKeyStillVerifies(t *testing.T) {
+	useKeys(t, "2024:old-secret", "2024")
+	oldToken, err := CreateToken("user-1", "a@example.com", "alice", "", "", "")
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+
+	// Rotation: the new key signs, the old one only verifies
+	useKeys(t, "2024:old-secret,2025:new-secret", "2025")
+	if claims, err := ParseToken(oldToken); err != nil || claims.UserID != "user-1" {
+		t.Errorf("token signed with the retired key = %v, %v", claims, err)
+	}
+	newToken, err := CreateToken("user-1", "a@example.com", "alice", "", "", "")
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, &CustomClaims{})
+	if err != nil || parsed.Header["kid"] != "2025" {
+		t.Errorf("new token kid = %v, %v, want 2025", parsed.Header["kid"], err)
+	}
+
+	// Once removed, the old key no longer verifies what it signed
+	useKeys(t, "2025:new-secret", "2025")
+	if _, err := ParseToken(oldToken); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("token signed with a removed key = %v, want ErrUnknownKeyID", err)
+	}
+	if _, err := ParseToken(newToken); err != nil {
+		t.Errorf("token signed with the active key: %v", err)
+	}
+}
+
+func TestUnknownKeyIDIsRejected(t *testing.T) {
+	useKeys(t, "2025:new-secret", "2025")
+
+	now := time.Now()
+	claims := &CustomClaims{UserID: "user-1", RegisteredClaims: jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		IssuedAt:  jwt.NewNumericDate(now),
+	}}
+	for _, kid := range []string{"2023", ""} {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		token.Header["kid"] = kid
+		// Even signed with a configured secret, a kid naming no key is refused
+		signed, err := token.SignedString([]byte("new-secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParseToken(signed); !errors.Is(err, ErrUnknownKeyID) {
+			t.Errorf("token with kid %q = %v, want ErrUnknownKeyID", kid, err)
+		}
+	}
+}
+
+// rsaKeyPEM returns a new RS256 private key, and its public key, PEM encoded.
+func rsaKeyPEM(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}))
+}
+
+// useRSAKey signs the test's tokens with private, verifying with retired too.
+func useRSAKey(t *testing.T, private string, retired string) {
+	t.Helper()
+	t.Setenv("JWT_PRIVATE_KEY", private)
+	t.Setenv("JWT_PUBLIC_KEYS", retired)
+	if err := config.Secrets.Load(); err != nil {
+		t.Fatalf("loading secrets: %v", err)
+	}
+}
+
+func TestRetiredRSAKeyStillVerifies(t *testing.T) {
+	oldPrivate, oldPublic := rsaKeyPEM(t)
+	newPrivate, _ := rsaKeyPEM(t)
+
+	useRSAKey(t, oldPrivate, "")
+	oldToken, err := CreateToken("user-1", "a@example.com", "alice", "", "", "")
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+
+	useRSAKey(t, newPrivate, oldPublic)
+	if _, err := ParseToken(oldToken); err != nil {
+		t.Errorf("token signed with the retired key: %v", err)
+	}
+
+	useRSAKey(t, newPrivate, "")
+	if _, err := ParseToken(oldToken); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("token signed with a removed key = %v, want ErrUnknownKeyID", err)
+	}
+}
+
+// signedToken signs claims for user-1 with the configured key, as CreateToken would.
+func signedToken(t *testing.T, registered jwt.RegisteredClaims) string {
+	t.Helper()
+	token, err := signClaims(&CustomClaims{UserID: "user-1", RegisteredClaims: registered})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestTimeClaimsAreCheckedWithLeeway(t *testing.T) {
+	useSecret(t, "test-secret")
+	leeway := config.TokenConfig.Leeway
+	config.TokenConfig.Leeway = 30 * time.Second
+	t.Cleanup(func() { config.TokenConfig.Leeway = leeway })
+
+	now := time.Now()
+	at := func(d time.Duration) *jwt.NumericDate { return jwt.NewNumericDate(now.Add(d)) }
+	tests := []struct {
+		name   string
+		claims jwt.RegisteredClaims
+		want   error
+	}{
+		{"valid", jwt.RegisteredClaims{ExpiresAt: at(time.Hour), IssuedAt: at(0), NotBefore: at(0)}, nil},
+		{"expired", jwt.RegisteredClaims{ExpiresAt: at(-time.Minute), IssuedAt: at(-time.Hour)}, jwt.ErrTokenExpired},
+		{"expired within the leeway", jwt.RegisteredClaims{ExpiresAt: at(-28 * time.Second), IssuedAt: at(-time.Hour)}, nil},
+		{"expired just past the leeway", jwt.RegisteredClaims{ExpiresAt: at(-32 * time.Second), IssuedAt: at(-time.Hour)}, jwt.ErrTokenExpired},
+		{"not yet valid", jwt.RegisteredClaims{ExpiresAt: at(time.Hour), NotBefore: at(time.Minute)}, jwt.ErrTokenNotValidYet},
+		{"not yet valid within the leeway", jwt.RegisteredClaims{ExpiresAt: at(time.Hour), NotBefore: at(28 * time.Second)}, nil},
+		{"not yet valid just past the leeway", jwt.RegisteredClaims{ExpiresAt: at(time.Hour), NotBefore: at(32 * time.Second)}, jwt.ErrTokenNotValidYet},
+		{"issued in the future", jwt.RegisteredClaims{ExpiresAt: at(time.Hour), IssuedAt: at(time.Minute)}, jwt.ErrTokenUsedBeforeIssued},
+		{"issued within the leeway", jwt.RegisteredClaims{ExpiresAt: at(time.Hour), IssuedAt: at(28 * time.Second)}, nil},
+		{"without expiry", jwt.RegisteredClaims{IssuedAt: at(0)}, jwt.ErrTokenRequiredClaimMissing},
+	}
+	for _, tt := range tests {
+		_, err := ParseToken(signedToken(t, tt.claims))
+		if tt.want == nil && err != nil {
+			t.Errorf("%s: ParseToken = %v, want valid", tt.name, err)
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: ParseToken = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestTokenExpiresAfterTTL(t *testing.T) {
+	useSecret(t, "test-secret")
+	ttl := config.TokenConfig.AccessTokenTTL
+	config.TokenConfig.AccessTokenTTL = 15 * time.Minute
+	t.Cleanup(func() { config.TokenConfig.AccessTokenTTL = ttl })
+
+	token, err := CreateToken("user-1", "a@example.com", "alice", "", "", "")
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+	claims, err := ParseToken(token)
+	if err != nil {
+		t.Fatalf("ParseToken: %v", err)
+	}
+	if claims.IssuedAt == nil || claims.NotBefore == nil || claims.ExpiresAt == nil {
+		t.Fatalf("token claims iat %v, nbf %v, exp %v, want all", claims.IssuedAt, claims.NotBefore, claims.ExpiresAt)
+	}
+	if got := claims.ExpiresAt.Sub(claims.IssuedAt.Time); got != 15*time.Minute {
+		t.Errorf("token lives %v, want 15m", got)
+	}
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"crypto/subtle"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordCost is the bcrypt work factor for new hashes.
+const passwordCost = bcrypt.DefaultCost
+
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// IsPasswordHash reports whether stored is a bcrypt hash rather than a legacy
+// plaintext password.
+func IsPasswordHash(stored string) bool {
+	if !strings.HasPrefix(stored, "$2") {
+		return false
+	}
+	_, err := bcrypt.Cost([]byte(stored))
+	return err == nil
+}
+
+// CheckPassword compares a login attempt with the stored password. needsRehash
+// is true when the match was against a legacy plaintext value or a hash with
+// an outdated cost, so the caller can store a fresh hash.
+func CheckPassword(stored string, password string) (ok bool, needsRehash bool) {
+	if stored == "" {
+		// Accounts created through an identity provider have no password
+		CheckDummyPassword(password)
+		return false, false
+	}
+
+	if !IsPasswordHash(stored) {
+		// Legacy plaintext row; constant-time so timing does not leak the prefix
+		ok = subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+		return ok, ok
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)); err != nil {
+		return false, false
+	}
+	cost, _ := bcrypt.Cost([]byte(stored))
+	return true, cost != passwordCost
+}
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// CheckDummyPassword spends as long as CheckPassword against a current hash
+// and always fails. Logins for unknown emails call it so their response time
+// does not reveal that the account does not exist.
+func CheckDummyPassword(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), passwordCost)
+	})
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
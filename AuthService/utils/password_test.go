@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	if !IsPasswordHash(hash) {
+		t.Fatalf("HashPassword returned %q, not a bcrypt hash", hash)
+	}
+
+	if ok, needsRehash := CheckPassword(hash, "correct horse"); !ok || needsRehash {
+		t.Errorf("right password = %v, %v, want true, false", ok, needsRehash)
+	}
+	if ok, needsRehash := CheckPassword(hash, "battery staple"); ok || needsRehash {
+		t.Errorf("wrong password = %v, %v, want false, false", ok, needsRehash)
+	}
+	// Accounts of an identity provider have no password to match
+	if ok, _ := CheckPassword("", ""); ok {
+		t.Error("empty password matched an account without one")
+	}
+}
+
+func TestLegacyPasswordIsUpgraded(t *testing.T) {
+	legacy := "correct horse"
+	if IsPasswordHash(legacy) {
+		t.Fatal("plaintext taken for a hash")
+	}
+	if ok, _ := CheckPassword(legacy, "battery staple"); ok {
+		t.Fatal("wrong password matched a legacy row")
+	}
+
+	ok, needsRehash := CheckPassword(legacy, "correct horse")
+	if !ok || !needsRehash {
+		t.Fatalf("right password on a legacy row = %v, %v, want true, true", ok, needsRehash)
+	}
+	// What the login stores in its place
+	upgraded, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	if ok, needsRehash := CheckPassword(upgraded, "correct horse"); !ok || needsRehash {
+		t.Errorf("upgraded row = %v, %v, want true, false", ok, needsRehash)
+	}
+}
+
+func TestOutdatedCostNeedsRehash(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, needsRehash := CheckPassword(string(hash), "correct horse"); !ok || !needsRehash {
+		t.Errorf("hash of cost %d = %v, %v, want true, true", bcrypt.MinCost, ok, needsRehash)
+	}
+}
+
+// TestUnknownUserTakesAsLong checks that a login for an email without an
+// account, which only checks the dummy password, takes about as long as a
+// wrong password for one.
+func TestUnknownUserTakesAsLong(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The dummy hash is made once, on the first call
+	CheckDummyPassword("warm up")
+
+	const rounds = 5
+	// The fastest of a few rounds, the least disturbed by the scheduler
+	fastest := func(check func()) time.Duration {
+		var best time.Duration
+		for i := 0; i < rounds; i++ {
+			start := time.Now()
+			check()
+			if d := time.Since(start); i == 0 || d < best {
+				best = d
+			}
+		}
+		return best
+	}
+	known := fastest(func() { CheckPassword(hash, "battery staple") })
+	unknown := fastest(func() { CheckDummyPassword("battery staple") })
+
+	if unknown < known/2 || unknown > known*2 {
+		t.Errorf("unknown user took %v, a wrong password %v", unknown, known)
+	}
+}
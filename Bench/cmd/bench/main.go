@@ -0,0 +1,213 @@
+// Command bench runs the realtime pipeline benchmarks.
+//
+//	bench load  -url ws://localhost/updates/ws/docId/%s -docs a,b -clients 200 -jwt-secret ...
+//	bench micro -rooms 10,100,1000
+//	bench soak  -duration 10m
+//
+// Results are written to stdout as JSON lines; set GIT_COMMIT to tag them.
+package main
+
+import (
+	"bench/loadgen"
+	"bench/micro"
+	"bench/report"
+	"bench/soak"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	// The services under test log every message to stdout. Keep the real
+	// stdout for results and silence everything else.
+	out := report.NewWriter(os.Stdout)
+	if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+		os.Stdout = devNull
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	switch os.Args[1] {
+	case "load":
+		err = runLoad(ctx, out, os.Args[2:])
+	case "micro":
+		err = runMicro(out, os.Args[2:])
+	case "soak":
+		err = runSoak(ctx, out, os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatalf("[bench][%s] %v", os.Args[1], err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: bench load|micro|soak [flags]")
+	os.Exit(2)
+}
+
+func runLoad(ctx context.Context, out *report.Writer, args []string) error {
+	fs := flag.NewFlagSet("load", flag.ExitOnError)
+	url := fs.String("url", "ws://localhost/updates/ws/docId/%s", "websocket URL template (docId)")
+	docs := fs.String("docs", "", "comma-separated document IDs")
+	clients := fs.Int("clients", 100, "number of simultaneous clients")
+	contentRate := fs.Float64("content-rate", 2, "content ops per client per second")
+	cursorRate := fs.Float64("cursor-rate", 10, "cursor moves per client per second")
+	presenceRate := fs.Float64("presence-rate", 0.5, "select/deselect ops per client per second")
+	duration := fs.Duration("duration", time.Minute, "steady-state duration")
+	rampUp := fs.Duration("ramp-up", 10*time.Second, "time over which clients connect")
+	tokenFile := fs.String("tokens", "", "file with one token per line")
+	jwtSecret := fs.String("jwt-secret", os.Getenv("JWT_SECRET"), "HS256 secret used to mint tokens when -tokens is not set")
+	tenantID := fs.String("tenant", "", "tenant ID placed in minted tokens")
+	fs.Parse(args)
+
+	var tokens loadgen.TokenSource
+	switch {
+	case *tokenFile != "":
+		var err error
+		if tokens, err = loadgen.TokensFromFile(*tokenFile); err != nil {
+			return err
+		}
+	case *jwtSecret != "":
+		tokens = loadgen.MintedTokens(*jwtSecret, *tenantID, *rampUp+*duration+time.Hour)
+	default:
+		return fmt.Errorf("either -tokens or -jwt-secret is required")
+	}
+
+	res, err := loadgen.Run(ctx, loadgen.Config{
+		URLTemplate:  *url,
+		DocumentIDs:  splitList(*docs),
+		Clients:      *clients,
+		Tokens:       tokens,
+		ContentRate:  *contentRate,
+		CursorRate:   *cursorRate,
+		PresenceRate: *presenceRate,
+		Duration:     *duration,
+		RampUp:       *rampUp,
+	})
+	if err != nil {
+		return err
+	}
+
+	labels := map[string]string{
+		"clients":   strconv.Itoa(res.Clients),
+		"documents": strconv.Itoa(res.Documents),
+	}
+	emit := func(metric string, value float64, unit string) {
+		out.Emit(report.Result{Suite: "load", Name: "websocket", Metric: metric, Value: value, Unit: unit, Labels: labels})
+	}
+	emit("connected", float64(res.Connected), "clients")
+	emit("dial_errors", float64(res.DialErrors), "count")
+	emit("disconnects", float64(res.Disconnects), "count")
+	emit("sent", res.SentPerSecond, "msg/s")
+	emit("received", res.RecvPerSecond, "msg/s")
+	emit("acked", float64(res.Acked), "count")
+	emit("rejected", float64(res.Rejected), "count")
+	emit("cursor_fanout_p50", ms(res.CursorFanout.P50), "ms")
+	emit("cursor_fanout_p95", ms(res.CursorFanout.P95), "ms")
+	emit("cursor_fanout_p99", ms(res.CursorFanout.P99), "ms")
+	emit("cursor_fanout_max", ms(res.CursorFanout.Max), "ms")
+	return nil
+}
+
+func runMicro(out *report.Writer, args []string) error {
+	fs := flag.NewFlagSet("micro", flag.ExitOnError)
+	rooms := fs.String("rooms", "1,10,100", "room sizes for the broadcast benchmark")
+	filter := fs.String("run", "", "only run benchmarks whose name contains this string")
+	fs.Parse(args)
+
+	var sizes []int
+	for _, s := range splitList(*rooms) {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid room size %q", s)
+		}
+		sizes = append(sizes, n)
+	}
+
+	for _, bench := range micro.All(sizes) {
+		if *filter != "" && !strings.Contains(bench.Name, *filter) {
+			continue
+		}
+		r := testing.Benchmark(bench.Fn)
+		if r.N == 0 {
+			return fmt.Errorf("benchmark %s failed", bench.Name)
+		}
+		emit := func(metric string, value float64, unit string) {
+			out.Emit(report.Result{Suite: "micro", Name: bench.Name, Metric: metric, Value: value, Unit: unit})
+		}
+		emit("time", float64(r.NsPerOp()), "ns/op")
+		emit("allocs", float64(r.AllocsPerOp()), "allocs/op")
+		emit("bytes", float64(r.AllocedBytesPerOp()), "B/op")
+		if r.Bytes > 0 {
+			emit("throughput", float64(r.Bytes)*float64(r.N)/r.T.Seconds()/1e6, "MB/s")
+		}
+	}
+	return nil
+}
+
+func runSoak(ctx context.Context, out *report.Writer, args []string) error {
+	cfg := soak.DefaultConfig()
+	fs := flag.NewFlagSet("soak", flag.ExitOnError)
+	fs.DurationVar(&cfg.Duration, "duration", cfg.Duration, "total run time")
+	fs.DurationVar(&cfg.SampleInterval, "sample", cfg.SampleInterval, "resource sampling interval")
+	fs.IntVar(&cfg.Rooms, "rooms", cfg.Rooms, "number of rooms")
+	fs.IntVar(&cfg.ClientsPerRoom, "clients-per-room", cfg.ClientsPerRoom, "clients in each room")
+	fs.IntVar(&cfg.MessagesPerSecond, "rate", cfg.MessagesPerSecond, "broadcasts per second across all rooms")
+	fs.IntVar(&cfg.ChurnPerSecond, "churn", cfg.ChurnPerSecond, "client reconnects per second")
+	fs.Float64Var(&cfg.MaxHeapGrowth, "max-heap-growth", cfg.MaxHeapGrowth, "tolerated final/initial heap ratio")
+	fs.IntVar(&cfg.MaxGoroutineGrowth, "max-goroutine-growth", cfg.MaxGoroutineGrowth, "tolerated goroutine increase")
+	fs.Parse(args)
+
+	res, err := soak.Run(ctx, cfg, func(s soak.Sample) {
+		labels := map[string]string{"elapsed": s.Elapsed.Round(time.Second).String()}
+		emit := func(metric string, value float64, unit string) {
+			out.Emit(report.Result{Suite: "soak", Name: "pool", Metric: metric, Value: value, Unit: unit, Labels: labels})
+		}
+		emit("heap_alloc", float64(s.HeapAlloc), "bytes")
+		emit("goroutines", float64(s.Goroutines), "count")
+		emit("delivered", float64(s.Delivered), "count")
+	})
+	if err != nil {
+		return err
+	}
+
+	stable := 0.0
+	if res.Stable {
+		stable = 1
+	}
+	out.Emit(report.Result{Suite: "soak", Name: "pool", Metric: "stable", Value: stable, Unit: "bool"})
+	if !res.Stable {
+		return fmt.Errorf("soak unstable: %s", res.Reason)
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
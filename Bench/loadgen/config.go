@@ -0,0 +1,70 @@
+// Package loadgen simulates collaborating editors against an UpdatesService
+// websocket endpoint. It is used both for load tests and as the traffic driver
+// of the integration suite.
+package loadgen
+
+import (
+	"fmt"
+	"time"
+)
+
+// Config describes a load run.
+type Config struct {
+	// URLTemplate is formatted with the documentID, e.g.
+	// "ws://localhost:8083/updates/ws/docId/%s". The token is sent in the
+	// Authorization header.
+	URLTemplate string
+
+	// DocumentIDs are the documents clients join, round robin.
+	DocumentIDs []string
+
+	// Clients is the number of simultaneous websocket connections.
+	Clients int
+
+	// Tokens supplies a bearer token for the i-th client.
+	Tokens TokenSource
+
+	// Per-client rates in messages per second. A zero rate disables that op.
+	ContentRate  float64 // create/update/delete of canvas objects
+	CursorRate   float64 // cursormove
+	PresenceRate float64 // select/deselect
+
+	// Duration of the steady state; RampUp spreads connection setup.
+	Duration time.Duration
+	RampUp   time.Duration
+
+	// DialTimeout bounds a single websocket handshake.
+	DialTimeout time.Duration
+}
+
+// TokenSource returns the token for the client with the given index.
+type TokenSource func(client int) (string, error)
+
+// StaticTokens cycles through a fixed token list.
+func StaticTokens(tokens []string) TokenSource {
+	return func(client int) (string, error) {
+		if len(tokens) == 0 {
+			return "", fmt.Errorf("no tokens configured")
+		}
+		return tokens[client%len(tokens)], nil
+	}
+}
+
+func (c Config) validate() error {
+	if c.URLTemplate == "" {
+		return fmt.Errorf("URLTemplate is required")
+	}
+	if len(c.DocumentIDs) == 0 {
+		return fmt.Errorf("at least one document ID is required")
+	}
+	if c.Clients <= 0 {
+		return fmt.Errorf("clients must be positive")
+	}
+	if c.Tokens == nil {
+		return fmt.Errorf("a token source is required")
+	}
+	if c.Duration <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package loadgen
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Result is the outcome of a load run.
+type Result struct {
+	Clients       int           `json:"clients"`
+	Documents     int           `json:"documents"`
+	Connected     int64         `json:"connected"`
+	DialErrors    int64         `json:"dialErrors"`
+	Sent          int64         `json:"sent"`
+	Received      int64         `json:"received"`
+	Acked         int64         `json:"acked"`
+	Rejected      int64         `json:"rejected"`
+	Disconnects   int64         `json:"disconnects"`
+	Elapsed       time.Duration `json:"elapsed"`
+	CursorFanout  Percentiles   `json:"cursorFanout"`
+	SentPerSecond float64       `json:"sentPerSecond"`
+	RecvPerSecond float64       `json:"recvPerSecond"`
+}
+
+type counters struct {
+	connected, dialErrors, sent, received, acked, rejected, disconnects atomic.Int64
+}
+
+// envelope is the server-to-client frame; both broadcasts and acks arrive on
+// the same connection so we decode loosely.
+type envelope struct {
+	Body    string `json:"body"`
+	Success *bool  `json:"success"`
+}
+
+// Run connects cfg.Clients editors, drives traffic for cfg.Duration and
+// returns the aggregated counters. It returns early if ctx is cancelled.
+func Run(ctx context.Context, cfg Config) (Result, error) {
+	if err := cfg.validate(); err != nil {
+		return Result{}, err
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = 10 * time.Second
+	}
+
+	var (
+		c       counters
+		latency = &latencyRecorder{seed: uint64(time.Now().UnixNano()) | 1}
+		wg      sync.WaitGroup
+	)
+
+	runCtx, cancel := context.WithTimeout(ctx, cfg.RampUp+cfg.Duration)
+	defer cancel()
+
+	start := time.Now()
+	for i := 0; i < cfg.Clients; i++ {
+		delay := time.Duration(0)
+		if cfg.RampUp > 0 {
+			delay = cfg.RampUp * time.Duration(i) / time.Duration(cfg.Clients)
+		}
+
+		wg.Add(1)
+		go func(i int, delay time.Duration) {
+			defer wg.Done()
+
+			select {
+			case <-time.After(delay):
+			case <-runCtx.Done():
+				return
+			}
+			runClient(runCtx, cfg, i, &c, latency)
+		}(i, delay)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	res := Result{
+		Clients:      cfg.Clients,
+		Documents:    len(cfg.DocumentIDs),
+		Connected:    c.connected.Load(),
+		DialErrors:   c.dialErrors.Load(),
+		Sent:         c.sent.Load(),
+		Received:     c.received.Load(),
+		Acked:        c.acked.Load(),
+		Rejected:     c.rejected.Load(),
+		Disconnects:  c.disconnects.Load(),
+		Elapsed:      elapsed,
+		CursorFanout: latency.percentiles(),
+	}
+	if secs := elapsed.Seconds(); secs > 0 {
+		res.SentPerSecond = float64(res.Sent) / secs
+		res.RecvPerSecond = float64(res.Received) / secs
+	}
+	return res, nil
+}
+
+func runClient(ctx context.Context, cfg Config, i int, c *counters, latency *latencyRecorder) {
+	token, err := cfg.Tokens(i)
+	if err != nil {
+		c.dialErrors.Add(1)
+		return
+	}
+	docID := cfg.DocumentIDs[i%len(cfg.DocumentIDs)]
+	url := fmt.Sprintf(cfg.URLTemplate, docID)
+	header := http.Header{"Authorization": {"Bearer " + token}}
+
+	dialCtx, cancel := context.WithTimeout(ctx, cfg.DialTimeout)
+	conn, _, err := websocket.DefaultDialer.DialContext(dialCtx, url, header)
+	cancel()
+	if err != nil {
+		c.dialErrors.Add(1)
+		return
+	}
+	c.connected.Add(1)
+
+	// Closing the connection on ctx.Done unblocks the reader
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(time.Second))
+		conn.Close()
+	}()
+
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		readLoop(conn, c, latency)
+	}()
+
+	writeLoop(ctx, conn, newOpGenerator(i, "bench-slide"), cfg, c, readerDone)
+	close(done)
+	<-readerDone
+}
+
+func readLoop(conn *websocket.Conn, c *counters, latency *latencyRecorder) {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				c.disconnects.Add(1)
+			}
+			return
+		}
+
+		var env envelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			continue
+		}
+		if env.Success != nil {
+			if *env.Success {
+				c.acked.Add(1)
+			} else {
+				c.rejected.Add(1)
+			}
+			continue
+		}
+
+		c.received.Add(1)
+		var body map[string]interface{}
+		if json.Unmarshal([]byte(env.Body), &body) != nil {
+			continue
+		}
+		if sentAt, ok := body[sentAtKey].(float64); ok {
+			latency.add(time.Since(time.Unix(0, int64(sentAt))))
+		}
+	}
+}
+
+func writeLoop(ctx context.Context, conn *websocket.Conn, gen *opGenerator, cfg Config, c *counters, readerDone <-chan struct{}) {
+	content := ticker(cfg.ContentRate)
+	cursor := ticker(cfg.CursorRate)
+	presence := ticker(cfg.PresenceRate)
+	defer stop(content, cursor, presence)
+
+	for {
+		var msg []byte
+		select {
+		case <-ctx.Done():
+			return
+		case <-readerDone:
+			return
+		case <-tick(content):
+			msg = gen.content()
+		case <-tick(cursor):
+			msg = gen.cursor()
+		case <-tick(presence):
+			msg = gen.presence()
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			return
+		}
+		c.sent.Add(1)
+	}
+}
+
+func ticker(rate float64) *time.Ticker {
+	if rate <= 0 {
+		return nil
+	}
+	return time.NewTicker(time.Duration(float64(time.Second) / rate))
+}
+
+// tick returns a nil channel for disabled ops so select never picks them.
+func tick(t *time.Ticker) <-chan time.Time {
+	if t == nil {
+		return nil
+	}
+	return t.C
+}
+
+func stop(tickers ...*time.Ticker) {
+	for _, t := range tickers {
+		if t != nil {
+			t.Stop()
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package loadgen
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+	"shared/messages"
+)
+
+func TestConfigValidate(t *testing.T) {
+	valid := Config{
+		URLTemplate: "ws://localhost/%s",
+		DocumentIDs: []string{"doc-1"},
+		Clients:     1,
+		Tokens:      StaticTokens([]string{"token"}),
+		Duration:    time.Second,
+	}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("valid config rejected: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Config)
+	}{
+		{"no url", func(c *Config) { c.URLTemplate = "" }},
+		{"no documents", func(c *Config) { c.DocumentIDs = nil }},
+		{"no clients", func(c *Config) { c.Clients = 0 }},
+		{"no tokens", func(c *Config) { c.Tokens = nil }},
+		{"no duration", func(c *Config) { c.Duration = 0 }},
+	}
+	for _, tt := range tests {
+		cfg := valid
+		tt.modify(&cfg)
+		if err := cfg.validate(); err == nil {
+			t.Errorf("%s: config accepted", tt.name)
+		}
+	}
+}
+
+func TestStaticTokensCycle(t *testing.T) {
+	tokens := StaticTokens([]string{"a", "b"})
+	for i, want := range []string{"a", "b", "a"} {
+		if got, err := tokens(i); err != nil || got != want {
+			t.Fatalf("token for client %d = %q, %v, want %q", i, got, err, want)
+		}
+	}
+	if _, err := StaticTokens(nil)(0); err == nil {
+		t.Fatal("empty token list gave a token")
+	}
+}
+
+func TestTokensFromFileSkipsBlanksAndComments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte("# bench users\n\n  first  \nsecond\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := TokensFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := tokens(0); got != "first" {
+		t.Fatalf("first token = %q", got)
+	}
+	if got, _ := tokens(1); got != "second" {
+		t.Fatalf("second token = %q", got)
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(empty, []byte("# nothing\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TokensFromFile(empty); err == nil {
+		t.Fatal("file without tokens accepted")
+	}
+}
+
+func TestMintedTokensCarryAuthServiceClaims(t *testing.T) {
+	raw, err := MintedTokens("secret", "acme", time.Minute)(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(raw, claims, func(*jwt.Token) (interface{}, error) {
+		return []byte("secret"), nil
+	}); err != nil {
+		t.Fatalf("minted token does not verify: %v", err)
+	}
+	if claims["user_id"] != "bench-user-3" || claims["username"] != "bench3" || claims["tenant_id"] != "acme" {
+		t.Fatalf("claims = %v", claims)
+	}
+
+	raw, _ = MintedTokens("secret", "", time.Minute)(0)
+	claims = jwt.MapClaims{}
+	jwt.ParseWithClaims(raw, claims, func(*jwt.Token) (interface{}, error) { return []byte("secret"), nil })
+	if _, ok := claims["tenant_id"]; ok {
+		t.Fatal("tenant claim set without a tenant")
+	}
+}
+
+func TestGeneratedFramesParse(t *testing.T) {
+	gen := newOpGenerator(1, "slide-1")
+	for i := 0; i < 200; i++ {
+		for _, frame := range [][]byte{gen.content(), gen.cursor(), gen.presence()} {
+			if _, err := messages.Parse(frame, 1<<20); err != nil {
+				t.Fatalf("generated frame %s rejected: %v", frame, err)
+			}
+		}
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	l := &latencyRecorder{seed: 1}
+	if got := l.percentiles(); got != (Percentiles{}) {
+		t.Fatalf("percentiles of no samples = %+v", got)
+	}
+	for i := 1; i <= 100; i++ {
+		l.add(time.Duration(i) * time.Millisecond)
+	}
+	got := l.percentiles()
+	if got.Count != 100 || got.P50 != 50*time.Millisecond || got.P99 != 99*time.Millisecond || got.Max != 100*time.Millisecond {
+		t.Fatalf("percentiles = %+v", got)
+	}
+}
+
+// echoServer acks every frame and broadcasts cursor frames back, as the
+// UpdatesService does to the sender's room.
+func echoServer(t *testing.T) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"success":true}`))
+			if strings.Contains(string(data), "cursormove") {
+				frame, _ := json.Marshal(map[string]string{"body": string(data)})
+				conn.WriteMessage(websocket.TextMessage, frame)
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRunCountsTraffic(t *testing.T) {
+	srv := echoServer(t)
+	res, err := Run(context.Background(), Config{
+		URLTemplate:  "ws" + strings.TrimPrefix(srv.URL, "http") + "/%s",
+		DocumentIDs:  []string{"doc-1", "doc-2"},
+		Clients:      3,
+		Tokens:       StaticTokens([]string{"token"}),
+		ContentRate:  50,
+		CursorRate:   50,
+		PresenceRate: 20,
+		Duration:     300 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Connected != 3 || res.DialErrors != 0 {
+		t.Fatalf("connected %d, dial errors %d", res.Connected, res.DialErrors)
+	}
+	if res.Sent == 0 || res.Acked == 0 || res.Received == 0 {
+		t.Fatalf("sent %d, acked %d, received %d", res.Sent, res.Acked, res.Received)
+	}
+	if res.CursorFanout.Count == 0 {
+		t.Fatal("no cursor fan-out latency recorded")
+	}
+}
+
+func TestRunCountsRejectedDials(t *testing.T) {
+	srv := echoServer(t)
+	res, err := Run(context.Background(), Config{
+		URLTemplate: "ws" + strings.TrimPrefix(srv.URL, "http") + "/%s",
+		DocumentIDs: []string{"doc-1"},
+		Clients:     2,
+		Tokens:      StaticTokens([]string{"wrong"}),
+		Duration:    50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Connected != 0 || res.DialErrors != 2 {
+		t.Fatalf("connected %d, dial errors %d, want every dial refused", res.Connected, res.DialErrors)
+	}
+}
@@ -0,0 +1,110 @@
+package loadgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// sentAtKey carries the send timestamp on cursor messages so receivers can
+// compute fan-out latency. Cursor messages are broadcast only, never persisted.
+const sentAtKey = "benchSentAt"
+
+// opGenerator builds realistic client messages for one simulated editor.
+type opGenerator struct {
+	client  int
+	rng     *rand.Rand
+	slideID string
+	objects []string
+	seq     int
+}
+
+func newOpGenerator(client int, slideID string) *opGenerator {
+	return &opGenerator{
+		client:  client,
+		rng:     rand.New(rand.NewSource(int64(client) + time.Now().UnixNano())),
+		slideID: slideID,
+	}
+}
+
+func (g *opGenerator) nextObjectID() string {
+	g.seq++
+	return fmt.Sprintf("bench-%d-%d", g.client, g.seq)
+}
+
+// content returns a create, update, or delete, weighted towards updates the
+// way a real editing session is.
+func (g *opGenerator) content() []byte {
+	roll := g.rng.Intn(10)
+	switch {
+	case len(g.objects) == 0 || roll < 2:
+		id := g.nextObjectID()
+		g.objects = append(g.objects, id)
+		return mustJSON(map[string]interface{}{
+			"action":     "create",
+			"slideId":    g.slideID,
+			"objectId":   id,
+			"objectType": "rectangle",
+			"attributes": map[string]interface{}{
+				"x": g.rng.Float64() * 1000, "y": g.rng.Float64() * 600,
+				"width": 120.0, "height": 80.0,
+				"strokeWidth": 1, "strokeColor": "#000000", "fillColor": "#FFFFFF",
+			},
+		})
+	case roll < 9:
+		id := g.objects[g.rng.Intn(len(g.objects))]
+		return mustJSON(map[string]interface{}{
+			"action":     "update",
+			"slideId":    g.slideID,
+			"objectId":   id,
+			"objectType": "rectangle",
+			"updatedAttributes": map[string]interface{}{
+				"x": g.rng.Float64() * 1000, "y": g.rng.Float64() * 600,
+			},
+		})
+	default:
+		i := g.rng.Intn(len(g.objects))
+		id := g.objects[i]
+		g.objects = append(g.objects[:i], g.objects[i+1:]...)
+		return mustJSON(map[string]interface{}{
+			"action":     "delete",
+			"slideId":    g.slideID,
+			"objectId":   id,
+			"objectType": "rectangle",
+		})
+	}
+}
+
+func (g *opGenerator) cursor() []byte {
+	return mustJSON(map[string]interface{}{
+		"action":            "cursormove",
+		"slideId":           g.slideID,
+		"newCursorLocation": [2]float64{g.rng.Float64() * 1000, g.rng.Float64() * 600},
+		sentAtKey:           time.Now().UnixNano(),
+	})
+}
+
+func (g *opGenerator) presence() []byte {
+	action := "select"
+	if g.rng.Intn(2) == 0 {
+		action = "deselect"
+	}
+	objectID := g.nextObjectID()
+	if len(g.objects) > 0 {
+		objectID = g.objects[g.rng.Intn(len(g.objects))]
+	}
+	return mustJSON(map[string]interface{}{
+		"action":   action,
+		"slideId":  g.slideID,
+		"objectId": objectID,
+	})
+}
+
+func mustJSON(v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,67 @@
+package loadgen
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxSamples bounds latency memory on long runs; later samples replace random
+// earlier ones (reservoir sampling) so the percentiles stay representative.
+const maxSamples = 200000
+
+type latencyRecorder struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	seen    int64
+	seed    uint64
+}
+
+func (l *latencyRecorder) add(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seen++
+	if len(l.samples) < maxSamples {
+		l.samples = append(l.samples, d)
+		return
+	}
+	// xorshift keeps this lock-held path allocation and syscall free
+	l.seed ^= l.seed << 13
+	l.seed ^= l.seed >> 7
+	l.seed ^= l.seed << 17
+	if i := l.seed % uint64(l.seen); i < maxSamples {
+		l.samples[i] = d
+	}
+}
+
+// Percentiles summarises observed latencies.
+type Percentiles struct {
+	Count int64         `json:"count"`
+	P50   time.Duration `json:"p50"`
+	P95   time.Duration `json:"p95"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+func (l *latencyRecorder) percentiles() Percentiles {
+	l.mu.Lock()
+	sorted := append([]time.Duration(nil), l.samples...)
+	seen := l.seen
+	l.mu.Unlock()
+
+	if len(sorted) == 0 {
+		return Percentiles{}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	at := func(q float64) time.Duration {
+		return sorted[int(q*float64(len(sorted)-1))]
+	}
+	return Percentiles{
+		Count: seen,
+		P50:   at(0.50),
+		P95:   at(0.95),
+		P99:   at(0.99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
@@ -0,0 +1,56 @@
+package loadgen
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TokensFromFile reads one token per line, ignoring blanks and # comments.
+func TokensFromFile(path string) (TokenSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var tokens []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens in %s", path)
+	}
+	return StaticTokens(tokens), nil
+}
+
+// MintedTokens signs an HS256 token per client with the AuthService claim
+// layout, so a run against a local stack needs no registered users.
+func MintedTokens(secret string, tenantID string, ttl time.Duration) TokenSource {
+	return func(client int) (string, error) {
+		now := time.Now()
+		claims := jwt.MapClaims{
+			"user_id":  fmt.Sprintf("bench-user-%d", client),
+			"username": fmt.Sprintf("bench%d", client),
+			"email":    fmt.Sprintf("bench%d@example.com", client),
+			"iat":      now.Unix(),
+			"exp":      now.Add(ttl).Unix(),
+		}
+		if tenantID != "" {
+			claims["tenant_id"] = tenantID
+		}
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	}
+}
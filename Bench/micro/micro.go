@@ -0,0 +1,156 @@
+// Package micro holds in-process benchmarks of the hot paths of the realtime
+// pipeline. They run through testing.Benchmark so the suite ships as a
+// regular binary and needs no external services.
+package micro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	consumerhandler "DocumentUpdatesConsumer/handler"
+	consumermodel "DocumentUpdatesConsumer/model"
+	consumertypes "DocumentUpdatesConsumer/types"
+	"UpdatesService/types"
+	"UpdatesService/websocket"
+)
+
+// Benchmark is one named benchmark.
+type Benchmark struct {
+	Name string
+	Fn   func(b *testing.B)
+}
+
+// All returns the benchmark set. roomSizes controls the broadcast fan-out
+// variants.
+func All(roomSizes []int) []Benchmark {
+	benches := []Benchmark{
+		{Name: "envelope/marshal", Fn: benchEnvelopeMarshal},
+		{Name: "envelope/unmarshal", Fn: benchEnvelopeUnmarshal},
+		{Name: "consumer/dispatch-update", Fn: benchDispatch(updateBody)},
+		{Name: "consumer/dispatch-create", Fn: benchDispatch(createBody)},
+	}
+	for _, n := range roomSizes {
+		benches = append(benches, Benchmark{
+			Name: fmt.Sprintf("pool/broadcast-room-%d", n),
+			Fn:   benchBroadcast(n),
+		})
+	}
+	return benches
+}
+
+const (
+	updateBody = `{"action":"update","slideId":"s1","objectId":"o1","objectType":"rectangle","updatedAttributes":{"x":10,"y":20}}`
+	createBody = `{"action":"create","slideId":"s1","objectId":"o1","objectType":"rectangle","attributes":{"x":1,"y":2,"width":3,"height":4,"strokeWidth":1,"strokeColor":"#000","fillColor":"#fff"}}`
+)
+
+var sampleMessage = types.Message{
+	DocumentID: "6560f1b7c2a4e1d2f3a4b5c6",
+	UserID:     "user-1",
+	Username:   "bench",
+	TenantID:   "default",
+	Type:       1,
+	Body:       updateBody,
+}
+
+func benchEnvelopeMarshal(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := websocket.SerializeMessage(sampleMessage); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchEnvelopeUnmarshal(b *testing.B) {
+	data, _ := json.Marshal(sampleMessage)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var msg types.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchBroadcast measures the pool's fan-out of one message to a room of
+// size n. Each client drains its Send channel in its own goroutine, as the
+// real writer does, so the numbers include channel hand-off.
+func benchBroadcast(n int) func(b *testing.B) {
+	return func(b *testing.B) {
+		pool := websocket.NewPool(nil)
+		go pool.Start()
+
+		delivered := make(chan struct{}, 1024)
+		for i := 0; i < n; i++ {
+			client := &websocket.Client{
+				UserID:     fmt.Sprintf("user-%d", i),
+				DocumentID: sampleMessage.DocumentID,
+				TenantID:   sampleMessage.TenantID,
+				Pool:       pool,
+				Send:       make(chan []byte, 256),
+			}
+			go func() {
+				for range client.Send {
+					select {
+					case delivered <- struct{}{}:
+					default:
+					}
+				}
+			}()
+			pool.Register <- client
+		}
+
+		// Without a sender every client receives the message
+		msg := websocket.RoomMessage{Message: sampleMessage}
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			pool.RoomBroadcast <- msg
+		}
+	}
+}
+
+// noopStore satisfies the consumer's DocumentStore without touching Mongo,
+// isolating decode and dispatch cost.
+type noopStore struct{}
+
+func (noopStore) AddNewSlide(context.Context, string, string) error { return nil }
+func (noopStore) RemoveSlide(context.Context, string, string) error { return nil }
+func (noopStore) UpdateElement(context.Context, string, string, string, map[string]interface{}) error {
+	return nil
+}
+func (noopStore) CreateElement(context.Context, string, string, consumermodel.Object) error {
+	return nil
+}
+func (noopStore) DeleteElement(context.Context, string, string, string) error { return nil }
+func (noopStore) RecordOperation(context.Context, consumermodel.Operation) error {
+	return nil
+}
+func (noopStore) SnapshotIfDue(context.Context, string, string) error { return nil }
+func (noopStore) EditState(context.Context, string) (bool, *time.Time, error) {
+	return false, nil, nil
+}
+
+func benchDispatch(body string) func(b *testing.B) {
+	return func(b *testing.B) {
+		msg := consumertypes.Message{
+			DocumentID: sampleMessage.DocumentID,
+			UserID:     sampleMessage.UserID,
+			Username:   sampleMessage.Username,
+			TenantID:   sampleMessage.TenantID,
+			Type:       1,
+			Body:       body,
+		}
+		ctx := context.Background()
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			consumerhandler.DocumentUpdatesHandler(ctx, noopStore{}, msg)
+		}
+	}
+}
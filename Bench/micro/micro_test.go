@@ -0,0 +1,30 @@
+package micro
+
+import (
+	"strings"
+	"testing"
+)
+
+// BenchmarkAll runs the suite under go test -bench as well as the bench binary.
+func BenchmarkAll(b *testing.B) {
+	for _, bench := range All([]int{1, 10, 100}) {
+		b.Run(bench.Name, bench.Fn)
+	}
+}
+
+func TestAllNamesAreUniqueAndCoverRoomSizes(t *testing.T) {
+	seen := map[string]bool{}
+	rooms := 0
+	for _, bench := range All([]int{1, 50}) {
+		if seen[bench.Name] {
+			t.Fatalf("duplicate benchmark %q", bench.Name)
+		}
+		seen[bench.Name] = true
+		if strings.HasPrefix(bench.Name, "pool/broadcast-room-") {
+			rooms++
+		}
+	}
+	if rooms != 2 || !seen["pool/broadcast-room-50"] {
+		t.Fatalf("benchmarks %v, want one broadcast variant per room size", seen)
+	}
+}
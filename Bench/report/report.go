@@ -0,0 +1,49 @@
+// Package report prints benchmark results as JSON lines so runs on different
+// commits can be diffed or loaded into a spreadsheet without parsing prose.
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Result is one measured value.
+type Result struct {
+	Suite     string            `json:"suite"`
+	Name      string            `json:"name"`
+	Metric    string            `json:"metric"`
+	Value     float64           `json:"value"`
+	Unit      string            `json:"unit"`
+	Commit    string            `json:"commit,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// Writer emits results to an io.Writer, one JSON object per line.
+type Writer struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	commit string
+}
+
+// NewWriter creates a writer. The commit is taken from GIT_COMMIT when set.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{enc: json.NewEncoder(w), commit: os.Getenv("GIT_COMMIT")}
+}
+
+// Emit writes a single result line.
+func (w *Writer) Emit(r Result) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if r.Commit == "" {
+		r.Commit = w.commit
+	}
+	if r.Timestamp.IsZero() {
+		r.Timestamp = time.Now().UTC()
+	}
+	return w.enc.Encode(r)
+}
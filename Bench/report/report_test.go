@@ -0,0 +1,42 @@
+package report
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEmitWritesOneJSONObjectPerLine(t *testing.T) {
+	t.Setenv("GIT_COMMIT", "abc123")
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	stamp := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := w.Emit(Result{Suite: "micro", Name: "envelope/marshal", Metric: "ns/op", Value: 42, Unit: "ns"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Emit(Result{Suite: "load", Name: "run", Metric: "sent", Value: 7, Commit: "pinned", Timestamp: stamp}); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []Result
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var r Result
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("line %q is not a result: %v", scanner.Text(), err)
+		}
+		lines = append(lines, r)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	if lines[0].Commit != "abc123" || lines[0].Timestamp.IsZero() {
+		t.Fatalf("first result = %+v, want the environment's commit and a timestamp", lines[0])
+	}
+	if lines[1].Commit != "pinned" || !lines[1].Timestamp.Equal(stamp) {
+		t.Fatalf("second result = %+v, want its own commit and timestamp kept", lines[1])
+	}
+}
@@ -0,0 +1,10 @@
+// Package resilience runs the whole pipeline, from the websocket to Mongo,
+// against Kafka, Mongo and Redis in containers, and takes them away in the
+// middle of an editing session: the broker is restarted, a consumer crashes
+// and the database goes down. Every scenario then checks that no edit the
+// server acknowledged was lost or applied twice.
+//
+// The tests need Docker and are built only with the resilience tag:
+//
+//	go test -tags resilience -timeout 30m ./resilience
+package resilience
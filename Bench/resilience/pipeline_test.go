@@ -0,0 +1,690 @@
+//go:build resilience
+
+package resilience
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sharedmodel "shared/model"
+
+	"github.com/gorilla/websocket"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/network"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// binaries are the services under test, built once for every test, by name.
+var binaries = map[string]string{}
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "resilience")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	builds := []struct{ name, module, pkg string }{
+		{"auth", "../../AuthService", "."},
+		{"document", "../../DocumentService", "."},
+		{"updates", "../../UpdatesService", "."},
+		{"consumer", "../../DocumentUpdatesConsumer", "."},
+		{"migrate", "../../Shared", "./cmd/migrate"},
+	}
+	for _, b := range builds {
+		binaries[b.name] = filepath.Join(dir, b.name)
+		cmd := exec.Command("go", "build", "-o", binaries[b.name], b.pkg)
+		cmd.Dir = b.module
+		if out, err := cmd.CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "building %s: %v\n%s", b.name, err, out)
+			os.Exit(1)
+		}
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// onHostPort publishes the container's port on the same port of the host, so
+// the container is found at the address it had once restarted and the
+// address it advertises is the one clients use.
+func onHostPort(port int) testcontainers.CustomizeRequestOption {
+	spec := fmt.Sprintf("%d/tcp", port)
+	return func(req *testcontainers.GenericContainerRequest) error {
+		req.ExposedPorts = append(req.ExposedPorts, spec)
+		req.HostConfigModifier = func(hc *container.HostConfig) {
+			hc.PortBindings = network.PortMap{
+				network.MustParsePort(spec): {{HostPort: strconv.Itoa(port)}},
+			}
+		}
+		return nil
+	}
+}
+
+// startKafka runs a single KRaft broker, returning it and its address.
+func startKafka(t *testing.T) (*testcontainers.DockerContainer, string) {
+	t.Helper()
+	port := freePort(t)
+	ctr, err := testcontainers.Run(context.Background(), "apache/kafka:3.7.0",
+		onHostPort(port),
+		testcontainers.WithEnv(map[string]string{
+			"KAFKA_NODE_ID":                                  "1",
+			"KAFKA_PROCESS_ROLES":                            "broker,controller",
+			"KAFKA_LISTENERS":                                fmt.Sprintf("PLAINTEXT://0.0.0.0:%d,CONTROLLER://0.0.0.0:9093", port),
+			"KAFKA_ADVERTISED_LISTENERS":                     fmt.Sprintf("PLAINTEXT://localhost:%d", port),
+			"KAFKA_LISTENER_SECURITY_PROTOCOL_MAP":           "CONTROLLER:PLAINTEXT,PLAINTEXT:PLAINTEXT",
+			"KAFKA_CONTROLLER_LISTENER_NAMES":                "CONTROLLER",
+			"KAFKA_CONTROLLER_QUORUM_VOTERS":                 "1@localhost:9093",
+			"KAFKA_OFFSETS_TOPIC_REPLICATION_FACTOR":         "1",
+			"KAFKA_TRANSACTION_STATE_LOG_REPLICATION_FACTOR": "1",
+			"KAFKA_TRANSACTION_STATE_LOG_MIN_ISR":            "1",
+			"KAFKA_GROUP_INITIAL_REBALANCE_DELAY_MS":         "0",
+			"KAFKA_AUTO_CREATE_TOPICS_ENABLE":                "true",
+		}),
+		testcontainers.WithWaitStrategy(wait.ForListeningPort(fmt.Sprintf("%d/tcp", port)).WithStartupTimeout(2*time.Minute)),
+	)
+	testcontainers.CleanupContainer(t, ctr)
+	if err != nil {
+		t.Fatalf("starting kafka: %v", err)
+	}
+	return ctr, fmt.Sprintf("localhost:%d", port)
+}
+
+// startMongo runs a single node replica set, the services using
+// transactions, returning it and its URI.
+func startMongo(t *testing.T) (*testcontainers.DockerContainer, string) {
+	t.Helper()
+	port := freePort(t)
+	ctr, err := testcontainers.Run(context.Background(), "mongo:7",
+		onHostPort(port),
+		testcontainers.WithCmd("--replSet", "rs0", "--bind_ip_all", "--port", strconv.Itoa(port)),
+		testcontainers.WithWaitStrategy(wait.ForLog("Waiting for connections").WithStartupTimeout(2*time.Minute)),
+	)
+	testcontainers.CleanupContainer(t, ctr)
+	if err != nil {
+		t.Fatalf("starting mongo: %v", err)
+	}
+
+	initiate := fmt.Sprintf("rs.initiate({_id: 'rs0', members: [{_id: 0, host: 'localhost:%d'}]})", port)
+	code, out, err := ctr.Exec(context.Background(), []string{"mongosh", "--quiet", "--port", strconv.Itoa(port), "--eval", initiate})
+	if err != nil || code != 0 {
+		var msg bytes.Buffer
+		if out != nil {
+			msg.ReadFrom(out)
+		}
+		t.Fatalf("initiating the replica set: exit %d, %v: %s", code, err, msg.String())
+	}
+
+	uri := fmt.Sprintf("mongodb://localhost:%d/?directConnection=true", port)
+	waitForPrimary(t, uri)
+	return ctr, uri
+}
+
+// waitForPrimary waits for the replica set at uri to take writes.
+func waitForPrimary(t *testing.T, uri string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Minute)
+	for !isPrimary(uri) {
+		if time.Now().After(deadline) {
+			t.Fatalf("mongo at %s has no primary", uri)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func isPrimary(uri string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return false
+	}
+	defer client.Disconnect(ctx)
+
+	var hello struct {
+		IsWritablePrimary bool `bson:"isWritablePrimary"`
+	}
+	err = client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	return err == nil && hello.IsWritablePrimary
+}
+
+func startRedis(t *testing.T) string {
+	t.Helper()
+	ctr, err := testcontainers.Run(context.Background(), "redis:7-alpine",
+		testcontainers.WithExposedPorts("6379/tcp"),
+		testcontainers.WithWaitStrategy(wait.ForListeningPort("6379/tcp")),
+	)
+	testcontainers.CleanupContainer(t, ctr)
+	if err != nil {
+		t.Fatalf("starting redis: %v", err)
+	}
+	addr, err := ctr.PortEndpoint(context.Background(), "6379/tcp", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr
+}
+
+// stopContainer stops ctr the way an outage would, keeping its data for
+// startContainer.
+func stopContainer(t *testing.T, ctr *testcontainers.DockerContainer) {
+	t.Helper()
+	timeout := 10 * time.Second
+	if err := ctr.Stop(context.Background(), &timeout); err != nil {
+		t.Fatalf("stopping container: %v", err)
+	}
+}
+
+func startContainer(t *testing.T, ctr *testcontainers.DockerContainer) {
+	t.Helper()
+	if err := ctr.Start(context.Background()); err != nil {
+		t.Fatalf("restarting container: %v", err)
+	}
+}
+
+// service is a service binary running for a test.
+type service struct {
+	name string
+	cmd  *exec.Cmd
+	done chan struct{}
+	// metricsAddr is where a consumer publishes its counters
+	metricsAddr string
+}
+
+// stop interrupts the service and waits for it to shut down.
+func (s *service) stop() {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	s.cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-s.done:
+	case <-time.After(30 * time.Second):
+		s.kill()
+	}
+}
+
+// kill ends the service at once, as a crash would.
+func (s *service) kill() {
+	s.cmd.Process.Kill()
+	<-s.done
+}
+
+// pipeline is every service of the editing path, against containers of its
+// own.
+type pipeline struct {
+	kafka, mongo *testcontainers.DockerContainer
+	mongoURI     string
+	env          []string
+
+	authURL, documentURL, updatesURL string
+	consumers                        []*service
+
+	documents *mongo.Collection
+}
+
+// newPipeline starts the containers, migrates the database and runs every
+// service, with consumers instances of the updates consumer in one group.
+func newPipeline(t *testing.T, consumers int) *pipeline {
+	p := &pipeline{}
+	var kafkaAddr string
+	p.kafka, kafkaAddr = startKafka(t)
+	p.mongo, p.mongoURI = startMongo(t)
+	redisAddr := startRedis(t)
+
+	authPort, documentPort, updatesPort := freePort(t), freePort(t), freePort(t)
+	p.authURL = fmt.Sprintf("http://localhost:%d", authPort)
+	p.documentURL = fmt.Sprintf("http://localhost:%d", documentPort)
+	p.updatesURL = fmt.Sprintf("http://localhost:%d", updatesPort)
+	p.env = []string{
+		"MONGO_URI=" + p.mongoURI,
+		"REDIS_ADDR=" + redisAddr,
+		"KAFKA_BROKER=" + kafkaAddr,
+		"INTERNAL_HMAC_KEY=resilience-internal-key",
+		"JWT_KEYS=resilience:resilience-signing-key",
+		"JWT_ACTIVE_KID=resilience",
+		"REQUIRE_EMAIL_VERIFICATION=false",
+		"AUTH_SERVICE_URL=" + p.authURL,
+		"DOCUMENT_SERVICE_URL=" + p.documentURL,
+		// Long enough for edits to wait out every outage below in the
+		// producer's queue
+		"KAFKA_DELIVERY_TIMEOUT=2m",
+		"WS_EDIT_RATE=1000",
+		"WS_EDIT_BURST=1000",
+	}
+
+	migrate := exec.Command(binaries["migrate"])
+	migrate.Env = append(os.Environ(), p.env...)
+	if out, err := migrate.CombinedOutput(); err != nil {
+		t.Fatalf("migrating: %v\n%s", err, out)
+	}
+
+	p.start(t, "auth", fmt.Sprintf("SERVER_ADDR=:%d", authPort))
+	waitForHTTP(t, p.authURL+"/health")
+	p.start(t, "document", fmt.Sprintf("SERVER_ADDR=:%d", documentPort))
+	waitForHTTP(t, p.documentURL+"/health")
+	for i := 0; i < consumers; i++ {
+		metricsAddr := fmt.Sprintf("localhost:%d", freePort(t))
+		s := p.start(t, "consumer", "METRICS_ADDR="+metricsAddr)
+		s.metricsAddr = metricsAddr
+		waitForHTTP(t, "http://"+metricsAddr+"/metrics")
+		p.consumers = append(p.consumers, s)
+	}
+	p.start(t, "updates", fmt.Sprintf("SERVER_ADDR=:%d", updatesPort))
+	waitForHTTP(t, p.updatesURL+"/health")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(p.mongoURI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	p.documents = client.Database("default").Collection(sharedmodel.DocumentCollection)
+	return p
+}
+
+// start runs the binary of name with the pipeline's environment and env,
+// showing the end of its output if the test fails.
+func (p *pipeline) start(t *testing.T, name string, env ...string) *service {
+	t.Helper()
+	logPath := filepath.Join(t.TempDir(), name+".log")
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(binaries[name])
+	cmd.Env = append(append(os.Environ(), p.env...), env...)
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("starting %s: %v", name, err)
+	}
+
+	s := &service{name: name, cmd: cmd, done: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		logFile.Close()
+		close(s.done)
+	}()
+	t.Cleanup(func() {
+		s.stop()
+		if t.Failed() {
+			t.Logf("end of the %s log:\n%s", name, tail(logPath, 40))
+		}
+	})
+	return s
+}
+
+func tail(path string, lines int) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return err.Error()
+	}
+	defer f.Close()
+	var last []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		last = append(last, scanner.Text())
+		if len(last) > lines {
+			last = last[1:]
+		}
+	}
+	return strings.Join(last, "\n")
+}
+
+func waitForHTTP(t *testing.T, url string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Minute)
+	for {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s not up: %v", url, err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// counter reads a counter a consumer publishes.
+func (s *service) counter(t *testing.T, name string) int64 {
+	t.Helper()
+	resp, err := http.Get("http://" + s.metricsAddr + "/metrics")
+	if err != nil {
+		t.Fatalf("reading the %s metrics: %v", s.name, err)
+	}
+	defer resp.Body.Close()
+	var vars map[string]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&vars); err != nil {
+		t.Fatal(err)
+	}
+	value, err := strconv.ParseInt(string(vars[name]), 10, 64)
+	if err != nil {
+		t.Fatalf("%s metric %s: %v", s.name, name, err)
+	}
+	return value
+}
+
+func postJSON(t *testing.T, url string, token string, body interface{}, want int, out interface{}) {
+	t.Helper()
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != want {
+		var msg bytes.Buffer
+		msg.ReadFrom(resp.Body)
+		t.Fatalf("POST %s: %d %s, want %d", url, resp.StatusCode, msg.String(), want)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// signUp registers a user and returns their access token.
+func (p *pipeline) signUp(t *testing.T, username string) string {
+	t.Helper()
+	email := username + "@resilience.test"
+	password := "resilience-password"
+	postJSON(t, p.authURL+"/auth/register", "", map[string]string{"username": username, "email": email, "password": password}, http.StatusCreated, nil)
+
+	var login struct {
+		AccessToken string `json:"access_token"`
+	}
+	postJSON(t, p.authURL+"/auth/login", "", map[string]string{"email": email, "password": password}, http.StatusOK, &login)
+	return login.AccessToken
+}
+
+func (p *pipeline) createDocument(t *testing.T, token string) string {
+	t.Helper()
+	var created struct {
+		ID string `json:"id"`
+	}
+	postJSON(t, p.documentURL+"/document/create", token, struct{}{}, http.StatusCreated, &created)
+	return created.ID
+}
+
+// objects returns the IDs of the objects saved on a slide, in their order.
+func (p *pipeline) objects(t *testing.T, documentID string, slideID string) []string {
+	t.Helper()
+	id, err := primitive.ObjectIDFromHex(documentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var doc sharedmodel.Document
+	if err := p.documents.FindOne(ctx, bson.M{"_id": id}).Decode(&doc); err != nil {
+		return nil
+	}
+	var ids []string
+	for _, slide := range doc.Slides {
+		if slide.ID != slideID {
+			continue
+		}
+		for _, obj := range slide.Objects {
+			ids = append(ids, obj.ID)
+		}
+	}
+	return ids
+}
+
+// assertSaved waits for the edits e's server acknowledged to be saved, then
+// checks each was saved once and in the order it was made. Edits reported
+// in a persist-failed frame may be missing.
+func (p *pipeline) assertSaved(t *testing.T, documentID string, slideID string, e *editor) {
+	t.Helper()
+	acked, failed := e.outcome()
+	order := map[string]int{}
+	for i, id := range acked {
+		order[id] = i
+	}
+
+	var got []string
+	deadline := time.Now().Add(3 * time.Minute)
+	for {
+		got = p.objects(t, documentID, slideID)
+		saved := map[string]bool{}
+		for _, id := range got {
+			saved[id] = true
+		}
+		missing := 0
+		for _, id := range acked {
+			if !saved[id] && !failed[id] {
+				missing++
+			}
+		}
+		if missing == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("document %s: %d of %d acknowledged edits never saved", documentID, missing, len(acked))
+		}
+		time.Sleep(time.Second)
+	}
+
+	last := -1
+	for _, id := range got {
+		i, ok := order[id]
+		switch {
+		case !ok:
+			t.Fatalf("document %s: object %s saved, but never acknowledged", documentID, id)
+		case i == last:
+			t.Fatalf("document %s: object %s saved twice", documentID, id)
+		case i < last:
+			t.Fatalf("document %s: object %s saved after %s, which was made later", documentID, id, acked[last])
+		}
+		last = i
+	}
+	t.Logf("document %s: %d edits acknowledged, %d reported not persisted, %d saved", documentID, len(acked), len(failed), len(got))
+}
+
+// editor is a client editing a document over its own websocket.
+type editor struct {
+	conn   *websocket.Conn
+	acks   chan bool
+	closed chan struct{}
+
+	mu      sync.Mutex
+	readErr error
+	acked   []string
+	failed  map[string]bool
+}
+
+func (p *pipeline) join(t *testing.T, token string, documentID string) *editor {
+	t.Helper()
+	url := strings.Replace(p.updatesURL, "http://", "ws://", 1) + "/updates/ws/docId/" + documentID
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer " + token}})
+	if err != nil {
+		t.Fatalf("joining document %s: %v", documentID, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	e := &editor{conn: conn, acks: make(chan bool, 1024), closed: make(chan struct{}), failed: map[string]bool{}}
+	go e.read()
+	return e
+}
+
+// read collects the acknowledgements of the server and the edits it reports
+// not persisted; every other frame is of no interest here.
+func (e *editor) read() {
+	for {
+		_, frame, err := e.conn.ReadMessage()
+		if err != nil {
+			e.mu.Lock()
+			e.readErr = err
+			e.mu.Unlock()
+			close(e.closed)
+			return
+		}
+
+		var f struct {
+			Type    string `json:"type"`
+			Success *bool  `json:"success"`
+			Body    string `json:"body"`
+		}
+		if json.Unmarshal(frame, &f) != nil {
+			continue
+		}
+		switch {
+		case f.Success != nil:
+			e.acks <- *f.Success
+		case f.Type == "persist-failed":
+			var edit struct {
+				ObjectID string `json:"objectId"`
+			}
+			json.Unmarshal([]byte(f.Body), &edit)
+			e.mu.Lock()
+			e.failed[edit.ObjectID] = true
+			e.mu.Unlock()
+		}
+	}
+}
+
+// send sends an edit and waits for the server to acknowledge it, which it
+// does once the edit is queued for Kafka.
+func (e *editor) send(edit map[string]interface{}) (bool, error) {
+	if err := e.conn.WriteJSON(edit); err != nil {
+		return false, fmt.Errorf("sending %v: %w", edit["action"], err)
+	}
+	select {
+	case ok := <-e.acks:
+		return ok, nil
+	case <-e.closed:
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return false, fmt.Errorf("websocket closed: %v", e.readErr)
+	case <-time.After(30 * time.Second):
+		return false, fmt.Errorf("%v not acknowledged", edit["action"])
+	}
+}
+
+func (e *editor) addSlide(t *testing.T, slideID string) {
+	t.Helper()
+	ok, err := e.send(map[string]interface{}{"action": "add_slide", "slideId": slideID})
+	if err != nil || !ok {
+		t.Fatalf("adding slide %s: acknowledged %v, %v", slideID, ok, err)
+	}
+}
+
+// create adds an object to a slide, remembering it when acknowledged.
+func (e *editor) create(slideID string, objectID string) error {
+	ok, err := e.send(map[string]interface{}{
+		"action":     "create",
+		"slideId":    slideID,
+		"objectId":   objectID,
+		"objectType": "rectangle",
+		"attributes": map[string]interface{}{
+			"x": 10.0, "y": 10.0, "width": 120.0, "height": 80.0,
+			"strokeWidth": 1, "strokeColor": "#000000", "fillColor": "#FFFFFF",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if ok {
+		e.mu.Lock()
+		e.acked = append(e.acked, objectID)
+		e.mu.Unlock()
+	}
+	return nil
+}
+
+// outcome returns the objects acknowledged, in the order they were created,
+// and those reported not persisted.
+func (e *editor) outcome() ([]string, map[string]bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	failed := make(map[string]bool, len(e.failed))
+	for id := range e.failed {
+		failed[id] = true
+	}
+	return append([]string(nil), e.acked...), failed
+}
+
+// session is an editor creating objects on a slide until stopped.
+type session struct {
+	stopping chan struct{}
+	done     chan error
+}
+
+// keepEditing creates an object on slideID every interval until the session
+// is stopped.
+func (e *editor) keepEditing(slideID string, interval time.Duration) *session {
+	s := &session{stopping: make(chan struct{}), done: make(chan error, 1)}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for n := 0; ; n++ {
+			select {
+			case <-s.stopping:
+				s.done <- nil
+				return
+			case <-ticker.C:
+			}
+			if err := e.create(slideID, fmt.Sprintf("obj-%05d", n)); err != nil {
+				s.done <- err
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// stop ends the session, returning why it ended early if it did.
+func (s *session) stop() error {
+	close(s.stopping)
+	return <-s.done
+}
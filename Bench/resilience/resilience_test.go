@@ -0,0 +1,107 @@
+//go:build resilience
+
+package resilience
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+const editInterval = 50 * time.Millisecond
+
+// TestBrokerRestart stops Kafka in the middle of a session. The server keeps
+// acknowledging edits, holding them in the producer's queue, the websocket
+// stays open, and once the broker is back the consumer resumes and saves
+// every edit acknowledged.
+func TestBrokerRestart(t *testing.T) {
+	p := newPipeline(t, 1)
+	token := p.signUp(t, "broker-restart")
+	documentID := p.createDocument(t, token)
+	e := p.join(t, token, documentID)
+	e.addSlide(t, "s-1")
+
+	editing := e.keepEditing("s-1", editInterval)
+	time.Sleep(3 * time.Second)
+	brokerDownBefore := p.consumers[0].counter(t, "kafka_all_brokers_down_total")
+
+	stopContainer(t, p.kafka)
+	time.Sleep(15 * time.Second)
+	startContainer(t, p.kafka)
+	time.Sleep(5 * time.Second)
+
+	if err := editing.stop(); err != nil {
+		t.Fatalf("editing through the outage: %v", err)
+	}
+	p.assertSaved(t, documentID, "s-1", e)
+
+	if p.consumers[0].counter(t, "kafka_all_brokers_down_total") == brokerDownBefore {
+		t.Error("the consumer never reported the broker down")
+	}
+}
+
+// TestConsumerRebalance crashes one of two consumers of the group while
+// documents are edited. Its partitions move to the other consumer, which
+// takes them from their last committed offsets: the updates the crashed one
+// had applied since are consumed again, and must not be applied twice.
+func TestConsumerRebalance(t *testing.T) {
+	p := newPipeline(t, 2)
+	token := p.signUp(t, "consumer-rebalance")
+
+	// Enough documents for both consumers to get some of the partitions
+	const documents = 6
+	ids := make([]string, documents)
+	editors := make([]*editor, documents)
+	sessions := make([]*session, documents)
+	for i := range ids {
+		ids[i] = p.createDocument(t, token)
+		editors[i] = p.join(t, token, ids[i])
+		editors[i].addSlide(t, "s-1")
+		sessions[i] = editors[i].keepEditing("s-1", editInterval)
+	}
+
+	time.Sleep(5 * time.Second)
+	p.consumers[0].kill()
+	// The group notices the crash once the session times out
+	time.Sleep(40 * time.Second)
+
+	for i, s := range sessions {
+		if err := s.stop(); err != nil {
+			t.Fatalf("editing document %d: %v", i, err)
+		}
+	}
+	for i, id := range ids {
+		t.Run(fmt.Sprintf("document-%d", i), func(t *testing.T) {
+			p.assertSaved(t, id, "s-1", editors[i])
+		})
+	}
+}
+
+// TestMongoOutage stops Mongo in the middle of a session. Edits still reach
+// Kafka; the consumer holds on to the update it cannot apply, retrying it,
+// and saves it and every later one once the database is back.
+func TestMongoOutage(t *testing.T) {
+	p := newPipeline(t, 1)
+	token := p.signUp(t, "mongo-outage")
+	documentID := p.createDocument(t, token)
+	e := p.join(t, token, documentID)
+	e.addSlide(t, "s-1")
+
+	editing := e.keepEditing("s-1", editInterval)
+	time.Sleep(3 * time.Second)
+	retriesBefore := p.consumers[0].counter(t, "store_retries_total")
+
+	stopContainer(t, p.mongo)
+	time.Sleep(15 * time.Second)
+	if p.consumers[0].counter(t, "store_retries_total") == retriesBefore {
+		t.Error("the consumer did not retry while the database was down")
+	}
+	startContainer(t, p.mongo)
+	waitForPrimary(t, p.mongoURI)
+	time.Sleep(5 * time.Second)
+
+	if err := editing.stop(); err != nil {
+		t.Fatalf("editing through the outage: %v", err)
+	}
+	p.assertSaved(t, documentID, "s-1", e)
+}
@@ -0,0 +1,250 @@
+// Package soak runs the websocket pool in-process for a long period under
+// steady traffic and connection churn, sampling memory and goroutines to
+// catch leaks that short benchmarks miss.
+package soak
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"UpdatesService/types"
+	"UpdatesService/websocket"
+)
+
+// Config describes a soak run.
+type Config struct {
+	Duration       time.Duration
+	SampleInterval time.Duration
+	Rooms          int
+	ClientsPerRoom int
+	// MessagesPerSecond is the total broadcast rate across all rooms.
+	MessagesPerSecond int
+	// ChurnPerSecond is how many clients leave and are replaced each second.
+	ChurnPerSecond int
+
+	// MaxHeapGrowth is the tolerated ratio between the final and the warm-up
+	// heap; MaxGoroutineGrowth is the tolerated absolute increase.
+	MaxHeapGrowth      float64
+	MaxGoroutineGrowth int
+}
+
+// DefaultConfig is a ten minute run sized for a laptop.
+func DefaultConfig() Config {
+	return Config{
+		Duration:           10 * time.Minute,
+		SampleInterval:     10 * time.Second,
+		Rooms:              50,
+		ClientsPerRoom:     20,
+		MessagesPerSecond:  2000,
+		ChurnPerSecond:     20,
+		MaxHeapGrowth:      1.5,
+		MaxGoroutineGrowth: 50,
+	}
+}
+
+// Sample is one point of the resource time series.
+type Sample struct {
+	Elapsed    time.Duration `json:"elapsed"`
+	HeapAlloc  uint64        `json:"heapAlloc"`
+	HeapInuse  uint64        `json:"heapInuse"`
+	Goroutines int           `json:"goroutines"`
+	NumGC      uint32        `json:"numGC"`
+	Broadcasts int64         `json:"broadcasts"`
+	Delivered  int64         `json:"delivered"`
+}
+
+// Result is the outcome of a soak run.
+type Result struct {
+	Samples []Sample `json:"samples"`
+	Stable  bool     `json:"stable"`
+	Reason  string   `json:"reason,omitempty"`
+}
+
+type member struct {
+	client *websocket.Client
+	done   chan struct{}
+}
+
+type harness struct {
+	pool      *websocket.Pool
+	delivered atomic.Int64
+	seq       int
+}
+
+func (h *harness) join(room int) *member {
+	h.seq++
+	m := &member{
+		client: &websocket.Client{
+			UserID:     fmt.Sprintf("soak-user-%d", h.seq),
+			DocumentID: roomID(room),
+			Pool:       h.pool,
+			Send:       make(chan []byte, 256),
+		},
+		done: make(chan struct{}),
+	}
+	go func() {
+		for {
+			select {
+			case <-m.client.Send:
+				h.delivered.Add(1)
+			case <-m.done:
+				return
+			}
+		}
+	}()
+	h.pool.Register <- m.client
+	return m
+}
+
+func (h *harness) leave(m *member) {
+	h.pool.Unregister <- m.client
+	// The pool still writes departure notices after receiving from Unregister.
+	// A broadcast to an empty room is a barrier: once the pool accepts it, the
+	// previous case has finished and the drainer can stop.
+	h.pool.RoomBroadcast <- websocket.RoomMessage{Message: types.Message{DocumentID: "soak-barrier"}}
+	close(m.done)
+}
+
+func roomID(room int) string {
+	return fmt.Sprintf("soak-room-%d", room)
+}
+
+// Run executes the soak and reports whether memory and goroutines stayed flat.
+// emit is called for every sample as it is taken.
+func Run(ctx context.Context, cfg Config, emit func(Sample)) (Result, error) {
+	if cfg.Rooms <= 0 || cfg.ClientsPerRoom <= 0 {
+		return Result{}, fmt.Errorf("rooms and clients per room must be positive")
+	}
+	if cfg.SampleInterval <= 0 || cfg.Duration < 2*cfg.SampleInterval {
+		return Result{}, fmt.Errorf("duration must cover at least two sample intervals")
+	}
+
+	h := &harness{pool: websocket.NewPool(nil)}
+	go h.pool.Start()
+
+	rooms := make([][]*member, cfg.Rooms)
+	for r := range rooms {
+		for i := 0; i < cfg.ClientsPerRoom; i++ {
+			rooms[r] = append(rooms[r], h.join(r))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	var broadcasts atomic.Int64
+	var mu sync.Mutex // guards rooms between the traffic and churn loops
+	var wg sync.WaitGroup
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if cfg.MessagesPerSecond <= 0 {
+			return
+		}
+		t := time.NewTicker(time.Second / time.Duration(cfg.MessagesPerSecond))
+		defer t.Stop()
+		for i := 0; ; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			h.pool.RoomBroadcast <- websocket.RoomMessage{Message: types.Message{
+				DocumentID: roomID(i % cfg.Rooms),
+				UserID:     "soak-sender",
+				Type:       1,
+				Body:       `{"action":"cursormove","slideId":"s1","newCursorLocation":[1,2]}`,
+			}}
+			broadcasts.Add(1)
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if cfg.ChurnPerSecond <= 0 {
+			return
+		}
+		t := time.NewTicker(time.Second / time.Duration(cfg.ChurnPerSecond))
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			mu.Lock()
+			r := rng.Intn(cfg.Rooms)
+			i := rng.Intn(len(rooms[r]))
+			h.leave(rooms[r][i])
+			rooms[r][i] = h.join(r)
+			mu.Unlock()
+		}
+	}()
+
+	start := time.Now()
+	sample := func() Sample {
+		runtime.GC()
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		s := Sample{
+			Elapsed:    time.Since(start),
+			HeapAlloc:  ms.HeapAlloc,
+			HeapInuse:  ms.HeapInuse,
+			Goroutines: runtime.NumGoroutine(),
+			NumGC:      ms.NumGC,
+			Broadcasts: broadcasts.Load(),
+			Delivered:  h.delivered.Load(),
+		}
+		if emit != nil {
+			emit(s)
+		}
+		return s
+	}
+
+	var res Result
+	t := time.NewTicker(cfg.SampleInterval)
+	defer t.Stop()
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-t.C:
+			res.Samples = append(res.Samples, sample())
+		}
+	}
+	wg.Wait()
+
+	res.Stable, res.Reason = evaluate(cfg, res.Samples)
+	return res, nil
+}
+
+// evaluate compares the last sample with the first one taken after warm-up.
+func evaluate(cfg Config, samples []Sample) (bool, string) {
+	if len(samples) < 2 {
+		return false, "not enough samples"
+	}
+	base, last := samples[0], samples[len(samples)-1]
+
+	if base.HeapAlloc > 0 {
+		growth := float64(last.HeapAlloc) / float64(base.HeapAlloc)
+		if growth > cfg.MaxHeapGrowth {
+			return false, fmt.Sprintf("heap grew %.2fx (limit %.2fx)", growth, cfg.MaxHeapGrowth)
+		}
+	}
+	if delta := last.Goroutines - base.Goroutines; delta > cfg.MaxGoroutineGrowth {
+		return false, fmt.Sprintf("goroutines grew by %d (limit %d)", delta, cfg.MaxGoroutineGrowth)
+	}
+	if last.Delivered == base.Delivered {
+		return false, "no messages delivered after warm-up"
+	}
+	return true, ""
+}
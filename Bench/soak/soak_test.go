@@ -0,0 +1,66 @@
+package soak
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRunSamplesAndDelivers(t *testing.T) {
+	cfg := Config{
+		Duration:           500 * time.Millisecond,
+		SampleInterval:     100 * time.Millisecond,
+		Rooms:              3,
+		ClientsPerRoom:     4,
+		MessagesPerSecond:  200,
+		ChurnPerSecond:     20,
+		MaxHeapGrowth:      100,
+		MaxGoroutineGrowth: 1000,
+	}
+	var emitted int
+	res, err := Run(context.Background(), cfg, func(Sample) { emitted++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Samples) < 2 || emitted != len(res.Samples) {
+		t.Fatalf("%d samples, %d emitted", len(res.Samples), emitted)
+	}
+	last := res.Samples[len(res.Samples)-1]
+	if last.Broadcasts == 0 || last.Delivered == 0 {
+		t.Fatalf("last sample %+v, want traffic", last)
+	}
+	if !res.Stable {
+		t.Fatalf("short run unstable: %s", res.Reason)
+	}
+}
+
+func TestRunRejectsShortDuration(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Duration = cfg.SampleInterval
+	if _, err := Run(context.Background(), cfg, nil); err == nil {
+		t.Fatal("duration of one sample interval accepted")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	cfg := Config{MaxHeapGrowth: 1.5, MaxGoroutineGrowth: 10}
+	base := Sample{HeapAlloc: 1000, Goroutines: 20, Delivered: 100}
+	tests := []struct {
+		name   string
+		last   Sample
+		stable bool
+	}{
+		{"flat", Sample{HeapAlloc: 1200, Goroutines: 25, Delivered: 200}, true},
+		{"heap growth", Sample{HeapAlloc: 2000, Goroutines: 20, Delivered: 200}, false},
+		{"goroutine leak", Sample{HeapAlloc: 1000, Goroutines: 40, Delivered: 200}, false},
+		{"stalled", Sample{HeapAlloc: 1000, Goroutines: 20, Delivered: 100}, false},
+	}
+	for _, tt := range tests {
+		if stable, reason := evaluate(cfg, []Sample{base, tt.last}); stable != tt.stable {
+			t.Errorf("%s: stable = %v (%s), want %v", tt.name, stable, reason, tt.stable)
+		}
+	}
+	if stable, _ := evaluate(cfg, []Sample{base}); stable {
+		t.Error("a single sample judged stable")
+	}
+}
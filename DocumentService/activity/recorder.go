@@ -0,0 +1,74 @@
+// Package activity records when users open documents without slowing down the
+// requests that open them.
+package activity
+
+import (
+	"context"
+	"document-service/metrics"
+	"document-service/repository"
+	"log"
+	"shared/tenant"
+	"time"
+)
+
+// writeTimeout bounds each write, so a slow database backs the queue up
+// instead of stalling the recorder indefinitely.
+const writeTimeout = 5 * time.Second
+
+type open struct {
+	tenantId   string
+	userId     string
+	documentId string
+	openedAt   time.Time
+}
+
+// Recorder queues document opens and writes them in the background. The
+// queue is bounded: opens arriving while it is full are dropped, since
+// losing one "last opened" time is better than delaying a read.
+type Recorder struct {
+	activity *repository.ActivityRepository
+	queue    chan open
+}
+
+func NewRecorder(activity *repository.ActivityRepository, bufferSize int) *Recorder {
+	return &Recorder{activity: activity, queue: make(chan open, bufferSize)}
+}
+
+// Record queues an open of documentId by userId in the tenant of ctx. It
+// never blocks.
+func (r *Recorder) Record(ctx context.Context, userId string, documentId string) {
+	entry := open{
+		tenantId:   tenant.FromContext(ctx),
+		userId:     userId,
+		documentId: documentId,
+		openedAt:   time.Now().UTC(),
+	}
+	select {
+	case r.queue <- entry:
+	default:
+		metrics.ActivityDropped.Add(1)
+	}
+}
+
+// Run writes queued opens until ctx is cancelled.
+func (r *Recorder) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case entry := <-r.queue:
+			r.write(ctx, entry)
+		}
+	}
+}
+
+func (r *Recorder) write(ctx context.Context, entry open) {
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+
+	if err := r.activity.RecordOpen(ctx, entry.tenantId, entry.userId, entry.documentId, entry.openedAt); err != nil {
+		log.Printf("[ActivityRecorder] Dropping open of %s by %s: %v", entry.documentId, entry.userId, err)
+		return
+	}
+	metrics.ActivityRecorded.Add(1)
+}
@@ -0,0 +1,124 @@
+// Package authclient calls the AuthService on behalf of DocumentService.
+package authclient
+
+import (
+	"context"
+	"document-service/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"shared/httpclient"
+	"shared/tenant"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrUnavailable is returned when the AuthService cannot be reached or fails.
+var ErrUnavailable = errors.New("auth service unavailable")
+
+// User is a resolved user.
+type User struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+// Client resolves users through the AuthService with signed internal requests.
+type Client struct {
+	http *httpclient.Client
+}
+
+func New() *Client {
+	return &Client{http: httpclient.New(httpclient.Config{
+		Service:    "auth-service",
+		BaseURL:    config.AuthServiceConfig.URL,
+		Timeout:    config.AuthServiceConfig.Timeout,
+		Retry:      httpclient.DefaultRetryPolicy,
+		Breaker:    httpclient.NewConsecutiveBreaker(config.AuthServiceConfig.BreakerThreshold, config.AuthServiceConfig.BreakerCooldown),
+		SigningKey: config.InternalHMACKey.Get,
+	})}
+}
+
+// FindUserByEmail returns the user with email in the tenant of ctx, or nil
+// when there is none.
+func (c *Client) FindUserByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.AuthServiceConfig.LookupTimeout)
+	defer cancel()
+
+	header := http.Header{}
+	header.Set("X-Tenant-ID", tenant.FromContext(ctx))
+
+	resp, err := c.http.Get(ctx, "/auth/users/lookup?email="+url.QueryEscape(email), header)
+	if httpclient.StatusCode(err) == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	var user User
+	if err := json.Unmarshal(resp.Body, &user); err != nil {
+		return nil, fmt.Errorf("%w: decoding lookup response: %v", ErrUnavailable, err)
+	}
+	return &user, nil
+}
+
+// FindUserByID returns the user with id in the tenant of ctx, or nil when
+// there is none.
+func (c *Client) FindUserByID(ctx context.Context, id string) (*User, error) {
+	users, err := c.ResolveUsers(ctx, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	if user, found := users[id]; found {
+		return &user, nil
+	}
+	return nil, nil
+}
+
+// maxResolveIDs is how many IDs the AuthService resolves per request.
+const maxResolveIDs = 100
+
+// ResolveUsers returns the users with the given IDs in the tenant of ctx,
+// keyed by ID. Users that do not exist are missing from the result.
+func (c *Client) ResolveUsers(ctx context.Context, ids []string) (map[string]User, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.AuthServiceConfig.LookupTimeout)
+	defer cancel()
+
+	header := http.Header{}
+	header.Set("X-Tenant-ID", tenant.FromContext(ctx))
+	header.Set("Content-Type", "application/json")
+
+	// The AuthService rejects malformed IDs, which cannot belong to a user anyway
+	valid := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if primitive.IsValidObjectID(id) {
+			valid = append(valid, id)
+		}
+	}
+
+	users := make(map[string]User, len(valid))
+	for start := 0; start < len(valid); start += maxResolveIDs {
+		body, err := json.Marshal(map[string][]string{"ids": valid[start:min(start+maxResolveIDs, len(valid))]})
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.http.Post(ctx, "/auth/users/resolve", body, header)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+
+		var resolved map[string]User
+		if err := json.Unmarshal(resp.Body, &resolved); err != nil {
+			return nil, fmt.Errorf("%w: decoding resolve response: %v", ErrUnavailable, err)
+		}
+		for id, user := range resolved {
+			user.ID = id
+			users[id] = user
+		}
+	}
+	return users, nil
+}
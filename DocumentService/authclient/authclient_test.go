@@ -0,0 +1,154 @@
+package authclient
+
+import (
+	"context"
+	"document-service/config"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"shared/tenant"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testClient returns a client of an AuthService served by handler.
+func testClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	saved := config.AuthServiceConfig
+	config.AuthServiceConfig.URL = server.URL
+	config.AuthServiceConfig.LookupTimeout = time.Second
+	config.AuthServiceConfig.BreakerThreshold = 100
+	t.Cleanup(func() { config.AuthServiceConfig = saved })
+	return New()
+}
+
+func TestFindUserByID(t *testing.T) {
+	known := primitive.NewObjectID().Hex()
+	var tenants []string
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		tenants = append(tenants, r.Header.Get("X-Tenant-ID"))
+		var body struct {
+			IDs []string `json:"ids"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		resolved := map[string]User{}
+		for _, id := range body.IDs {
+			if id == known {
+				resolved[id] = User{Username: "alice", Email: "alice@example.com"}
+			}
+		}
+		json.NewEncoder(w).Encode(resolved)
+	})
+	ctx := tenant.WithID(context.Background(), "acme")
+
+	user, err := client.FindUserByID(ctx, known)
+	if err != nil || user == nil || *user != (User{ID: known, Username: "alice", Email: "alice@example.com"}) {
+		t.Errorf("known user: %+v, %v", user, err)
+	}
+	if user, err := client.FindUserByID(ctx, primitive.NewObjectID().Hex()); user != nil || err != nil {
+		t.Errorf("unknown user: %+v, %v, want nil without an error", user, err)
+	}
+	if len(tenants) != 2 || tenants[0] != "acme" || tenants[1] != "acme" {
+		t.Errorf("tenants asked for %q, want acme twice", tenants)
+	}
+
+	// Malformed IDs belong to nobody, without asking
+	if user, err := client.FindUserByID(ctx, "u-1"); user != nil || err != nil || len(tenants) != 2 {
+		t.Errorf("malformed ID: %+v, %v after %d requests, want nil without a request", user, err, len(tenants))
+	}
+}
+
+func TestFindUserByEmail(t *testing.T) {
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/auth/users/lookup" || r.URL.Query().Get("email") != "alice+docs@example.com" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(User{ID: "u-1", Username: "alice", Email: "alice+docs@example.com"})
+	})
+
+	user, err := client.FindUserByEmail(context.Background(), "alice+docs@example.com")
+	if err != nil || user == nil || user.ID != "u-1" {
+		t.Errorf("registered email: %+v, %v", user, err)
+	}
+	if user, err := client.FindUserByEmail(context.Background(), "bob@example.com"); user != nil || err != nil {
+		t.Errorf("unregistered email: %+v, %v, want nil without an error", user, err)
+	}
+}
+
+func TestLookupFailuresAreUnavailable(t *testing.T) {
+	release := make(chan struct{})
+	tests := map[string]http.HandlerFunc{
+		"server error": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		},
+		"bad gateway": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		},
+		"not JSON": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("<html>"))
+		},
+		"too slow": func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+		},
+	}
+	for name, handler := range tests {
+		client := testClient(t, handler)
+		config.AuthServiceConfig.LookupTimeout = 500 * time.Millisecond
+
+		start := time.Now()
+		if _, err := client.FindUserByID(context.Background(), primitive.NewObjectID().Hex()); !errors.Is(err, ErrUnavailable) {
+			t.Errorf("%s: FindUserByID error %v, want ErrUnavailable", name, err)
+		}
+		if _, err := client.FindUserByEmail(context.Background(), "alice@example.com"); !errors.Is(err, ErrUnavailable) {
+			t.Errorf("%s: FindUserByEmail error %v, want ErrUnavailable", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%s: lookups took %s, want each bounded by the lookup timeout", name, elapsed)
+		}
+	}
+	// Before the servers close, which waits for their handlers
+	t.Cleanup(func() { close(release) })
+}
+
+func TestBreakerFailsFastOnceTheAuthServiceIsDown(t *testing.T) {
+	var requests atomic.Int32
+	testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	// A client tripping after two failed lookups
+	config.AuthServiceConfig.BreakerThreshold = 2
+	config.AuthServiceConfig.BreakerCooldown = time.Minute
+	client := New()
+
+	for range 2 {
+		if _, err := client.FindUserByEmail(context.Background(), "alice@example.com"); !errors.Is(err, ErrUnavailable) {
+			t.Fatalf("error %v, want ErrUnavailable", err)
+		}
+	}
+	tripped := requests.Load()
+
+	start := time.Now()
+	for range 5 {
+		if _, err := client.FindUserByEmail(context.Background(), "alice@example.com"); !errors.Is(err, ErrUnavailable) {
+			t.Errorf("with the breaker open: error %v, want ErrUnavailable", err)
+		}
+	}
+	if requests.Load() != tripped {
+		t.Errorf("%d more requests reached the AuthService with the breaker open", requests.Load()-tripped)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("lookups with the breaker open took %s, want them to fail at once", elapsed)
+	}
+}
@@ -0,0 +1,97 @@
+// Package cache keeps documents in Redis so opening a document does not read
+// it from MongoDB every time. The cache is strictly optional: when Redis
+// fails, reads miss and writes are skipped with a warning, and callers fall
+// back to MongoDB.
+package cache
+
+import (
+	"context"
+	"document-service/metrics"
+	"document-service/model"
+	"errors"
+	"log"
+	sharedmodel "shared/model"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// timeout bounds every Redis call, so a struggling Redis slows reads down by
+// no more than this before they go to MongoDB.
+const timeout = 200 * time.Millisecond
+
+// DocumentCache caches documents by ID for TTL. Documents are stored as BSON,
+// so a cached document decodes exactly like one read from MongoDB.
+type DocumentCache struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func NewDocumentCache(client *redis.Client, ttl time.Duration) *DocumentCache {
+	return &DocumentCache{client: client, ttl: ttl}
+}
+
+// Get returns the cached document with id, or false on a miss.
+func (c *DocumentCache) Get(ctx context.Context, id string) (*model.Document, bool) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	data, err := c.client.Get(ctx, sharedmodel.DocumentCacheKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		metrics.DocumentCacheMisses.Add(1)
+		return nil, false
+	}
+	if err != nil {
+		metrics.DocumentCacheErrors.Add(1)
+		log.Printf("[DocumentCache][Get] Warning: reading document %s from the cache failed: %v", id, err)
+		return nil, false
+	}
+
+	var document model.Document
+	if err := bson.Unmarshal(data, &document); err != nil {
+		metrics.DocumentCacheErrors.Add(1)
+		log.Printf("[DocumentCache][Get] Warning: decoding cached document %s failed: %v", id, err)
+		return nil, false
+	}
+	metrics.DocumentCacheHits.Add(1)
+	return &document, true
+}
+
+// Set caches document.
+func (c *DocumentCache) Set(ctx context.Context, document model.Document) {
+	data, err := bson.Marshal(document)
+	if err != nil {
+		log.Printf("[DocumentCache][Set] Warning: encoding document %s failed: %v", document.ID.Hex(), err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := c.client.Set(ctx, sharedmodel.DocumentCacheKey(document.ID.Hex()), data, c.ttl).Err(); err != nil {
+		metrics.DocumentCacheErrors.Add(1)
+		log.Printf("[DocumentCache][Set] Warning: caching document %s failed: %v", document.ID.Hex(), err)
+	}
+}
+
+// Invalidate drops the documents with ids from the cache. When that fails a
+// stale document may be served until its TTL runs out.
+func (c *DocumentCache) Invalidate(ctx context.Context, ids ...string) {
+	if len(ids) == 0 {
+		return
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = sharedmodel.DocumentCacheKey(id)
+	}
+
+	// Invalidate after a write even when the request was cancelled meanwhile
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+
+	if err := c.client.Del(ctx, keys...).Err(); err != nil {
+		metrics.DocumentCacheErrors.Add(1)
+		log.Printf("[DocumentCache][Invalidate] Warning: invalidating documents %v failed: %v", ids, err)
+	}
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"context"
+	"document-service/metrics"
+	"document-service/model"
+	sharedmodel "shared/model"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testCache returns a cache keeping documents for a minute in a miniredis.
+func testCache(t *testing.T) (*DocumentCache, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewDocumentCache(client, time.Minute), server
+}
+
+func testDocument() model.Document {
+	return model.Document{ID: primitive.NewObjectID(), Title: "Plan", OwnerID: "u-1", TenantID: "acme", Version: 3}
+}
+
+func TestGetHitsWhatSetCached(t *testing.T) {
+	cache, server := testCache(t)
+	ctx := context.Background()
+	document := testDocument()
+	id := document.ID.Hex()
+
+	misses := metrics.DocumentCacheMisses.Value()
+	if _, found := cache.Get(ctx, id); found {
+		t.Fatal("document found before it was cached")
+	}
+	if got := metrics.DocumentCacheMisses.Value() - misses; got != 1 {
+		t.Errorf("misses counted = %d, want 1", got)
+	}
+
+	cache.Set(ctx, document)
+	if ttl := server.TTL(sharedmodel.DocumentCacheKey(id)); ttl != time.Minute {
+		t.Errorf("cached for %v, want 1m", ttl)
+	}
+	hits := metrics.DocumentCacheHits.Value()
+	cached, found := cache.Get(ctx, id)
+	if !found || cached.ID != document.ID || cached.Title != "Plan" || cached.TenantID != "acme" || cached.Version != 3 {
+		t.Fatalf("cached document = %+v, %v, want %+v", cached, found, document)
+	}
+	if got := metrics.DocumentCacheHits.Value() - hits; got != 1 {
+		t.Errorf("hits counted = %d, want 1", got)
+	}
+
+	// Entries expire with their TTL
+	server.FastForward(time.Minute)
+	if _, found := cache.Get(ctx, id); found {
+		t.Error("document found after its TTL")
+	}
+}
+
+func TestInvalidateDropsDocuments(t *testing.T) {
+	cache, _ := testCache(t)
+	ctx := context.Background()
+	changed, other, kept := testDocument(), testDocument(), testDocument()
+	for _, document := range []model.Document{changed, other, kept} {
+		cache.Set(ctx, document)
+	}
+
+	cache.Invalidate(ctx, changed.ID.Hex(), other.ID.Hex())
+	for _, document := range []model.Document{changed, other} {
+		if _, found := cache.Get(ctx, document.ID.Hex()); found {
+			t.Errorf("document %s found after it was invalidated", document.ID.Hex())
+		}
+	}
+	if _, found := cache.Get(ctx, kept.ID.Hex()); !found {
+		t.Error("invalidating other documents dropped this one")
+	}
+
+	// Writes finishing after their request was cancelled still invalidate
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	cache.Invalidate(cancelled, kept.ID.Hex())
+	if _, found := cache.Get(ctx, kept.ID.Hex()); found {
+		t.Error("document found after an invalidation with a cancelled context")
+	}
+}
+
+func TestRedisDownMisses(t *testing.T) {
+	cache, server := testCache(t)
+	ctx := context.Background()
+	document := testDocument()
+	cache.Set(ctx, document)
+	server.Close()
+
+	errors := metrics.DocumentCacheErrors.Value()
+	start := time.Now()
+	if _, found := cache.Get(ctx, document.ID.Hex()); found {
+		t.Error("document found with Redis down")
+	}
+	cache.Set(ctx, document)
+	cache.Invalidate(ctx, document.ID.Hex())
+	if elapsed := time.Since(start); elapsed > 3*timeout {
+		t.Errorf("calls to a Redis that is down took %v, want them bounded by %v each", elapsed, timeout)
+	}
+	if got := metrics.DocumentCacheErrors.Value() - errors; got != 3 {
+		t.Errorf("errors counted = %d, want 3", got)
+	}
+}
+
+func TestUndecodableEntryMisses(t *testing.T) {
+	cache, server := testCache(t)
+	id := primitive.NewObjectID().Hex()
+	server.Set(sharedmodel.DocumentCacheKey(id), "not bson")
+
+	if _, found := cache.Get(context.Background(), id); found {
+		t.Error("undecodable entry found")
+	}
+}
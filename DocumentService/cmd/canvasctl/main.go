@@ -0,0 +1,407 @@
+// Command canvasctl inspects and repairs live data.
+//
+// Every command is read-only unless --yes is passed; without it, commands that
+// would change data print what they would do instead.
+//
+//	canvasctl documents list --owner <userId>
+//	canvasctl documents inspect <documentId>
+//	canvasctl history <documentId> [--limit 20]
+//	canvasctl integrity [--fix --yes]
+//	canvasctl lag [--group document-updates-consumer-group --topic document-updates]
+//	canvasctl redrive parked [--limit 100] [--yes]
+//	canvasctl redrive topic --from <dlq> --to <topic> [--max 1000] [--yes]
+package main
+
+import (
+	"context"
+	"document-service/config"
+	"document-service/database"
+	"document-service/kafkaUtils"
+	"document-service/repository"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"shared/tenant"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// options are the flags shared by every command.
+type options struct {
+	output string
+	tenant string
+	yes    bool
+	out    printer
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.output, "output", "table", "output format: table or json")
+	fs.StringVar(&o.tenant, "tenant", tenant.DefaultID, "tenant to scope document queries to")
+	fs.BoolVar(&o.yes, "yes", false, "apply mutations instead of only reporting them")
+}
+
+func (o *options) parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var err error
+	o.out, err = newPrinter(o.output)
+	return err
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	os.Stdout = os.Stderr
+
+	if err := config.Load(); err != nil {
+		fail(fmt.Errorf("failed to load configuration: %w", err))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var err error
+	switch os.Args[1] {
+	case "documents":
+		err = runDocuments(ctx, os.Args[2:])
+	case "history":
+		err = runHistory(ctx, os.Args[2:])
+	case "integrity":
+		err = runIntegrity(ctx, os.Args[2:])
+	case "lag":
+		err = runLag(os.Args[2:])
+	case "redrive":
+		err = runRedrive(ctx, os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: canvasctl documents|history|integrity|lag|redrive [flags]")
+	os.Exit(2)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "canvasctl:", err)
+	os.Exit(1)
+}
+
+// connect opens Mongo; ConnectDB exits the process if the server is unreachable.
+func connect() *mongo.Client {
+	return database.ConnectDB(config.MongoConfig.MongoUri)
+}
+
+func documentRepository(client *mongo.Client) *repository.DocumentRepository {
+	// The outbox is only written by mutations canvasctl does not perform.
+	return repository.NewDocumentRepository(
+		client,
+		config.MongoConfig.DatabaseName,
+		config.MongoConfig.DocumentCollectionName,
+		config.MongoConfig.SharedDocRecordCollectionName,
+		config.MongoConfig.FavoriteCollectionName,
+		nil,
+	)
+}
+
+func outboxRepository(client *mongo.Client) *repository.OutboxRepository {
+	return repository.NewOutboxRepository(
+		client,
+		config.MongoConfig.DatabaseName,
+		config.MongoConfig.OutboxCollectionName,
+		config.MongoConfig.OutboxParkedCollectionName,
+		config.KafkaConfig.DocumentEventsTopic,
+	)
+}
+
+func runDocuments(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: canvasctl documents list|inspect")
+	}
+
+	var opts options
+	fs := flag.NewFlagSet("documents "+args[0], flag.ExitOnError)
+	opts.register(fs)
+
+	switch args[0] {
+	case "list":
+		owner := fs.String("owner", "", "owner user ID (required)")
+		if err := opts.parse(fs, args[1:]); err != nil {
+			return err
+		}
+		if *owner == "" {
+			return fmt.Errorf("--owner is required")
+		}
+
+		client := connect()
+		defer client.Disconnect(context.Background())
+		ctx = tenant.WithID(ctx, opts.tenant)
+
+		documents, _, err := documentRepository(client).FindOwnedDocuments(ctx, *owner, repository.ListOptions{})
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, 0, len(documents))
+		for _, d := range documents {
+			rows = append(rows, []string{d.ID.Hex(), d.Title, d.OwnerID, strconv.Itoa(len(d.Slides))})
+		}
+		opts.out.print([]string{"ID", "TITLE", "OWNER", "SLIDES"}, rows, documents)
+		return nil
+
+	case "inspect":
+		if err := opts.parse(fs, args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: canvasctl documents inspect <documentId>")
+		}
+		documentID := fs.Arg(0)
+
+		client := connect()
+		defer client.Disconnect(context.Background())
+		ctx = tenant.WithID(ctx, opts.tenant)
+		repo := documentRepository(client)
+
+		document, err := repo.FindDocumentByID(ctx, documentID)
+		if err != nil {
+			return err
+		}
+		if document == nil {
+			return fmt.Errorf("document %s not found in tenant %s", documentID, opts.tenant)
+		}
+		records, err := repo.FindCollaboratorsByDocumentID(ctx, documentID)
+		if err != nil {
+			return err
+		}
+
+		objects := 0
+		for _, slide := range document.Slides {
+			objects += len(slide.Objects)
+		}
+		rows := [][]string{{document.ID.Hex(), document.Title, document.OwnerID, strconv.Itoa(len(document.Slides)), strconv.Itoa(objects), ""}}
+		for _, r := range records {
+			rows = append(rows, []string{"", "", "", "", "", r.UserID + " (" + r.AccessType + ")"})
+		}
+		opts.out.print([]string{"ID", "TITLE", "OWNER", "SLIDES", "OBJECTS", "SHARED WITH"}, rows, map[string]interface{}{
+			"document": document,
+			"shares":   records,
+		})
+		return nil
+	}
+	return fmt.Errorf("unknown documents command %q", args[0])
+}
+
+func runHistory(ctx context.Context, args []string) error {
+	var opts options
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	opts.register(fs)
+	limit := fs.Int64("limit", 20, "number of most recent operations to show")
+	if err := opts.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: canvasctl history <documentId>")
+	}
+	documentID := fs.Arg(0)
+
+	client := connect()
+	defer client.Disconnect(context.Background())
+	ctx = tenant.WithID(ctx, opts.tenant)
+
+	history := repository.NewHistoryRepository(
+		client,
+		config.MongoConfig.DatabaseName,
+		config.MongoConfig.OperationCollectionName,
+		config.MongoConfig.SnapshotCollectionName,
+	)
+	operations, err := history.FindOperations(ctx, documentID, *limit)
+	if err != nil {
+		return err
+	}
+	snapshot, err := history.FindLatestSnapshot(ctx, documentID)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(operations))
+	for _, op := range operations {
+		rows = append(rows, []string{op.AppliedAt.Format(time.RFC3339), op.Action, op.Username, op.UserID})
+	}
+	opts.out.print([]string{"APPLIED AT", "ACTION", "USER", "USER ID"}, rows, map[string]interface{}{
+		"operations":     operations,
+		"latestSnapshot": snapshot,
+	})
+	if opts.output == "table" {
+		if snapshot == nil {
+			note("no snapshot")
+		} else {
+			note("latest snapshot: version %d taken %s", snapshot.Version, snapshot.CreatedAt.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+func runIntegrity(ctx context.Context, args []string) error {
+	var opts options
+	fs := flag.NewFlagSet("integrity", flag.ExitOnError)
+	opts.register(fs)
+	fix := fs.Bool("fix", false, "delete orphaned shared records (requires --yes)")
+	if err := opts.parse(fs, args); err != nil {
+		return err
+	}
+
+	client := connect()
+	defer client.Disconnect(context.Background())
+	repo := documentRepository(client)
+
+	orphans, err := repo.FindOrphanedShares(ctx)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(orphans))
+	for _, o := range orphans {
+		rows = append(rows, []string{o.Record.ID.Hex(), o.Record.DocumentID, o.Record.UserID, tenant.Normalize(o.Record.TenantID), o.Reason})
+	}
+	opts.out.print([]string{"SHARE ID", "DOCUMENT", "USER", "TENANT", "PROBLEM"}, rows, orphans)
+
+	if !*fix || len(orphans) == 0 {
+		note("%d orphaned shared records", len(orphans))
+		return nil
+	}
+	if !opts.yes {
+		note("would delete %d orphaned shared records; re-run with --yes to apply", len(orphans))
+		return nil
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(orphans))
+	for _, o := range orphans {
+		ids = append(ids, o.Record.ID)
+	}
+	deleted, err := repo.DeleteCollaborationRecords(ctx, ids)
+	if err != nil {
+		return err
+	}
+	note("deleted %d orphaned shared records", deleted)
+	return nil
+}
+
+func runLag(args []string) error {
+	var opts options
+	fs := flag.NewFlagSet("lag", flag.ExitOnError)
+	opts.register(fs)
+	group := fs.String("group", "document-updates-consumer-group", "consumer group")
+	topic := fs.String("topic", "document-updates", "topic")
+	timeout := fs.Duration("timeout", 10*time.Second, "broker request timeout")
+	if err := opts.parse(fs, args); err != nil {
+		return err
+	}
+
+	lags, err := kafkaUtils.ConsumerGroupLag(config.KafkaConfig.Broker, *group, *topic, *timeout)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	rows := make([][]string, 0, len(lags))
+	for _, l := range lags {
+		total += l.Lag
+		rows = append(rows, []string{l.Topic, strconv.Itoa(int(l.Partition)), strconv.FormatInt(l.Committed, 10), strconv.FormatInt(l.High, 10), strconv.FormatInt(l.Lag, 10)})
+	}
+	opts.out.print([]string{"TOPIC", "PARTITION", "COMMITTED", "HIGH", "LAG"}, rows, lags)
+	if opts.output == "table" {
+		note("total lag: %d", total)
+	}
+	return nil
+}
+
+func runRedrive(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: canvasctl redrive parked|topic")
+	}
+
+	var opts options
+	fs := flag.NewFlagSet("redrive "+args[0], flag.ExitOnError)
+	opts.register(fs)
+
+	switch args[0] {
+	case "parked":
+		limit := fs.Int64("limit", 100, "maximum number of parked outbox events to redrive")
+		if err := opts.parse(fs, args[1:]); err != nil {
+			return err
+		}
+
+		client := connect()
+		defer client.Disconnect(context.Background())
+		outbox := outboxRepository(client)
+
+		parked, err := outbox.FindParked(ctx, *limit)
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, 0, len(parked))
+		for _, e := range parked {
+			rows = append(rows, []string{e.ID.Hex(), e.EventType, e.AggregateID, strconv.Itoa(e.Attempts), e.LastError})
+		}
+		opts.out.print([]string{"EVENT ID", "TYPE", "AGGREGATE", "ATTEMPTS", "LAST ERROR"}, rows, parked)
+
+		if !opts.yes {
+			note("would redrive %d parked events; re-run with --yes to apply", len(parked))
+			return nil
+		}
+		for _, e := range parked {
+			if err := outbox.Redrive(ctx, e); err != nil {
+				return err
+			}
+		}
+		note("redrove %d parked events into the outbox", len(parked))
+		return nil
+
+	case "topic":
+		from := fs.String("from", "", "source topic, e.g. a dead-letter topic (required)")
+		to := fs.String("to", "", "destination topic (required)")
+		max := fs.Int("max", 1000, "maximum number of messages to copy")
+		group := fs.String("group", "canvasctl-redrive", "consumer group used to track progress on the source topic")
+		idle := fs.Duration("idle", 5*time.Second, "stop after no message arrived for this long")
+		if err := opts.parse(fs, args[1:]); err != nil {
+			return err
+		}
+		if *from == "" || *to == "" {
+			return fmt.Errorf("--from and --to are required")
+		}
+
+		if !opts.yes {
+			lags, err := kafkaUtils.ConsumerGroupLag(config.KafkaConfig.Broker, *group, *from, 10*time.Second)
+			if err != nil {
+				return err
+			}
+			var pending int64
+			for _, l := range lags {
+				pending += l.Lag
+			}
+			note("would copy up to %d of %d pending messages from %s to %s; re-run with --yes to apply", *max, pending, *from, *to)
+			return nil
+		}
+
+		producer, err := kafkaUtils.ConnectProducer(config.KafkaConfig.Broker)
+		if err != nil {
+			return err
+		}
+		defer producer.Close()
+
+		copied, err := kafkaUtils.RedriveTopic(ctx, producer, config.KafkaConfig.Broker, *group, *from, *to, *max, *idle)
+		note("copied %d messages from %s to %s", copied, *from, *to)
+		return err
+	}
+	return fmt.Errorf("unknown redrive command %q", args[0])
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"document-service/config"
+	"document-service/model"
+	"encoding/json"
+	"fmt"
+	"os"
+	"shared/migrate"
+	sharedmodel "shared/model"
+	"shared/tenant"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongooptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// seededDB points the configuration at a fresh database on the MongoDB at
+// MONGO_TEST_URI, with its indexes built, dropped when the test ends. Tests
+// seed it through the returned handle.
+func seededDB(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The command's own options shadow the driver's
+	client, err := mongo.Connect(ctx, mongooptions.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := client.Database(fmt.Sprintf("canvasctl_test_%d", time.Now().UnixNano()))
+	saved := config.MongoConfig
+	config.MongoConfig.MongoUri = uri
+	config.MongoConfig.DatabaseName = db.Name()
+	t.Cleanup(func() {
+		config.MongoConfig = saved
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+	if _, err := migrate.New(db).Apply(ctx, false); err != nil {
+		t.Fatalf("building the indexes: %v", err)
+	}
+	return db
+}
+
+func insert(t *testing.T, db *mongo.Database, collection string, documents ...interface{}) {
+	t.Helper()
+	if _, err := db.Collection(collection).InsertMany(context.Background(), documents); err != nil {
+		t.Fatalf("seeding %s: %v", collection, err)
+	}
+}
+
+func count(t *testing.T, db *mongo.Database, collection string) int64 {
+	t.Helper()
+	n, err := db.Collection(collection).CountDocuments(context.Background(), bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// run runs a command, returning what it printed as results and as notes.
+// Like main, it keeps what repositories print out of the results.
+func run(t *testing.T, command func(ctx context.Context, args []string) error, args ...string) (string, string, error) {
+	t.Helper()
+	results, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedStdout, savedOsStdout, savedStderr := stdout, os.Stdout, os.Stderr
+	stdout, os.Stdout, os.Stderr = results, notes, notes
+	defer func() { stdout, os.Stdout, os.Stderr = savedStdout, savedOsStdout, savedStderr }()
+
+	runErr := command(context.Background(), args)
+	out, _ := os.ReadFile(results.Name())
+	errOut, _ := os.ReadFile(notes.Name())
+	return string(out), string(errOut), runErr
+}
+
+func document(owner string, tenantID string, title string, slides ...sharedmodel.Slide) model.Document {
+	return model.Document{ID: primitive.NewObjectID(), Title: title, OwnerID: owner, TenantID: tenantID, Slides: slides, CreatedAt: time.Now()}
+}
+
+func TestUsageErrors(t *testing.T) {
+	// All refused before connecting anywhere
+	tests := []struct {
+		name    string
+		command func(ctx context.Context, args []string) error
+		args    []string
+		want    string
+	}{
+		{"documents without a command", runDocuments, nil, "usage"},
+		{"unknown documents command", runDocuments, []string{"purge"}, `unknown documents command "purge"`},
+		{"list without an owner", runDocuments, []string{"list"}, "--owner is required"},
+		{"inspect without an ID", runDocuments, []string{"inspect"}, "usage"},
+		{"unknown output", runDocuments, []string{"list", "--owner", "alice", "--output", "yaml"}, `unknown output format "yaml"`},
+		{"history without an ID", runHistory, nil, "usage"},
+		{"redrive without a command", runRedrive, nil, "usage"},
+		{"unknown redrive command", runRedrive, []string{"all"}, `unknown redrive command "all"`},
+		{"redrive topic without topics", runRedrive, []string{"topic", "--from", "dlq"}, "--from and --to are required"},
+	}
+	for _, tt := range tests {
+		_, _, err := run(t, tt.command, tt.args...)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	rows := [][]string{{"1", "Roadmap"}, {"22", "Q3"}}
+	value := []map[string]string{{"id": "1"}}
+
+	table, _, _ := run(t, func(context.Context, []string) error {
+		printer{format: "table"}.print([]string{"ID", "TITLE"}, rows, value)
+		return nil
+	})
+	if want := "ID  TITLE\n1   Roadmap\n22  Q3\n"; table != want {
+		t.Errorf("table output %q, want %q", table, want)
+	}
+
+	out, _, _ := run(t, func(context.Context, []string) error {
+		printer{format: "json"}.print([]string{"ID", "TITLE"}, rows, value)
+		return nil
+	})
+	var decoded []map[string]string
+	if err := json.Unmarshal([]byte(out), &decoded); err != nil || len(decoded) != 1 || decoded[0]["id"] != "1" {
+		t.Errorf("JSON output %q, want the value, not the rows", out)
+	}
+}
+
+func TestDocumentsList(t *testing.T) {
+	db := seededDB(t)
+	insert(t, db, config.MongoConfig.DocumentCollectionName,
+		document("alice", tenant.DefaultID, "Roadmap"),
+		document("alice", "", "Retro"),
+		document("bob", tenant.DefaultID, "Budget"),
+		document("alice", "acme", "Acme plan"),
+	)
+
+	out, _, err := run(t, runDocuments, "list", "--owner", "alice", "--output", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var documents []model.Document
+	if err := json.Unmarshal([]byte(out), &documents); err != nil {
+		t.Fatalf("output %q: %v", out, err)
+	}
+	titles := map[string]bool{}
+	for _, d := range documents {
+		titles[d.Title] = true
+	}
+	if len(documents) != 2 || !titles["Roadmap"] || !titles["Retro"] {
+		t.Errorf("listed %v, want alice's two documents of the default tenant", titles)
+	}
+
+	out, _, err = run(t, runDocuments, "list", "--owner", "alice", "--tenant", "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[0], "ID") || !strings.Contains(lines[1], "Acme plan") {
+		t.Errorf("table for acme:\n%s\nwant a header and alice's acme document", out)
+	}
+}
+
+func TestDocumentsInspect(t *testing.T) {
+	db := seededDB(t)
+	doc := document("alice", tenant.DefaultID, "Roadmap",
+		sharedmodel.Slide{ID: "s-1", Objects: []sharedmodel.Object{{}, {}}},
+		sharedmodel.Slide{ID: "s-2", Objects: []sharedmodel.Object{{}}},
+	)
+	insert(t, db, config.MongoConfig.DocumentCollectionName, doc)
+	insert(t, db, config.MongoConfig.SharedDocRecordCollectionName,
+		model.CollaborationRecord{ID: primitive.NewObjectID(), UserID: "bob", DocumentID: doc.ID.Hex(), AccessType: "read", TenantID: tenant.DefaultID, SharedAt: time.Now()},
+	)
+
+	out, _, err := run(t, runDocuments, "inspect", doc.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "Roadmap") || !strings.Contains(lines[2], "bob (read)") {
+		t.Errorf("table:\n%s\nwant the document, then its share with bob", out)
+	}
+	if fields := strings.Fields(lines[1]); len(fields) != 5 || fields[3] != "2" || fields[4] != "3" {
+		t.Errorf("document row %q, want 2 slides and 3 objects", lines[1])
+	}
+
+	out, _, err = run(t, runDocuments, "inspect", "--output", "json", doc.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inspected struct {
+		Document model.Document              `json:"document"`
+		Shares   []model.CollaborationRecord `json:"shares"`
+	}
+	if err := json.Unmarshal([]byte(out), &inspected); err != nil {
+		t.Fatalf("output %q: %v", out, err)
+	}
+	if inspected.Document.ID != doc.ID || len(inspected.Shares) != 1 || inspected.Shares[0].UserID != "bob" {
+		t.Errorf("inspected %+v", inspected)
+	}
+
+	if _, _, err := run(t, runDocuments, "inspect", "--tenant", "acme", doc.ID.Hex()); err == nil || !strings.Contains(err.Error(), "not found in tenant acme") {
+		t.Errorf("inspecting in another tenant: %v, want not found", err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	db := seededDB(t)
+	doc := document("alice", tenant.DefaultID, "Roadmap")
+	start := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	var operations []interface{}
+	for i, action := range []string{"add_slide", "add_object", "update_object"} {
+		operations = append(operations, sharedmodel.Operation{DocumentID: doc.ID.Hex(), TenantID: tenant.DefaultID, UserID: "u-1", Username: "alice", Action: action, AppliedAt: start.Add(time.Duration(i) * time.Minute)})
+	}
+	insert(t, db, config.MongoConfig.OperationCollectionName, operations...)
+	insert(t, db, config.MongoConfig.SnapshotCollectionName,
+		sharedmodel.Snapshot{DocumentID: doc.ID.Hex(), TenantID: tenant.DefaultID, Version: 1, CreatedAt: start},
+		sharedmodel.Snapshot{DocumentID: doc.ID.Hex(), TenantID: tenant.DefaultID, Version: 2, CreatedAt: start.Add(time.Minute)},
+	)
+
+	out, _, err := run(t, runHistory, "--limit", "2", "--output", "json", doc.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var history struct {
+		Operations     []sharedmodel.Operation `json:"operations"`
+		LatestSnapshot *sharedmodel.Snapshot   `json:"latestSnapshot"`
+	}
+	if err := json.Unmarshal([]byte(out), &history); err != nil {
+		t.Fatalf("output %q: %v", out, err)
+	}
+	if len(history.Operations) != 2 || history.Operations[0].Action != "update_object" || history.Operations[1].Action != "add_object" {
+		t.Errorf("operations %+v, want the latest two, newest first", history.Operations)
+	}
+	if history.LatestSnapshot == nil || history.LatestSnapshot.Version != 2 {
+		t.Errorf("latest snapshot %+v, want version 2", history.LatestSnapshot)
+	}
+
+	_, notes, err := run(t, runHistory, primitive.NewObjectID().Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(notes, "no snapshot") {
+		t.Errorf("notes %q for a document without history, want no snapshot", notes)
+	}
+}
+
+func TestIntegrityIsReadOnlyWithoutYes(t *testing.T) {
+	db := seededDB(t)
+	doc := document("alice", tenant.DefaultID, "Roadmap")
+	acme := document("alice", "acme", "Acme plan")
+	insert(t, db, config.MongoConfig.DocumentCollectionName, doc, acme)
+
+	share := func(documentID string, tenantID string) model.CollaborationRecord {
+		return model.CollaborationRecord{ID: primitive.NewObjectID(), UserID: "bob", DocumentID: documentID, AccessType: "read", TenantID: tenantID, SharedAt: time.Now()}
+	}
+	healthy := share(doc.ID.Hex(), tenant.DefaultID)
+	insert(t, db, config.MongoConfig.SharedDocRecordCollectionName,
+		healthy,
+		share(primitive.NewObjectID().Hex(), tenant.DefaultID),
+		share("not-an-id", tenant.DefaultID),
+		share(acme.ID.Hex(), tenant.DefaultID),
+	)
+	shares := config.MongoConfig.SharedDocRecordCollectionName
+
+	out, notes, err := run(t, runIntegrity, "--output", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var orphans []struct {
+		Record model.CollaborationRecord `json:"record"`
+		Reason string                    `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(out), &orphans); err != nil {
+		t.Fatalf("output %q: %v", out, err)
+	}
+	reasons := map[string]bool{}
+	for _, o := range orphans {
+		reasons[o.Reason] = true
+		if o.Record.ID == healthy.ID {
+			t.Errorf("the share of an existing document reported as %q", o.Reason)
+		}
+	}
+	if len(orphans) != 3 || len(reasons) != 3 {
+		t.Errorf("reported %v, want the missing, invalid, and other tenant's document", reasons)
+	}
+	if !strings.Contains(notes, "3 orphaned shared records") {
+		t.Errorf("notes %q", notes)
+	}
+
+	// --fix alone only says what it would do
+	_, notes, err = run(t, runIntegrity, "--fix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(notes, "would delete 3") || count(t, db, shares) != 4 {
+		t.Errorf("--fix without --yes: notes %q, %d shares left, want all 4", notes, count(t, db, shares))
+	}
+
+	_, notes, err = run(t, runIntegrity, "--fix", "--yes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(notes, "deleted 3") || count(t, db, shares) != 1 {
+		t.Errorf("--fix --yes: notes %q, %d shares left, want the healthy one", notes, count(t, db, shares))
+	}
+	if n, _ := db.Collection(shares).CountDocuments(context.Background(), bson.M{"_id": healthy.ID}); n != 1 {
+		t.Error("the healthy share was deleted")
+	}
+}
+
+func TestRedriveParkedIsReadOnlyWithoutYes(t *testing.T) {
+	db := seededDB(t)
+	parkedAt := time.Now().UTC()
+	parked := func(eventType string) sharedmodel.OutboxEvent {
+		return sharedmodel.OutboxEvent{ID: primitive.NewObjectID(), AggregateID: "doc-1", Topic: "document-events", EventType: eventType, Payload: "{}", Status: sharedmodel.OutboxStatusPending, Attempts: 5, LastError: "broker down", CreatedAt: parkedAt, ParkedAt: &parkedAt}
+	}
+	insert(t, db, config.MongoConfig.OutboxParkedCollectionName, parked("document.shared"), parked("document.deleted"))
+	outbox, parkingLot := config.MongoConfig.OutboxCollectionName, config.MongoConfig.OutboxParkedCollectionName
+
+	out, notes, err := run(t, runRedrive, "parked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "document.shared") || !strings.Contains(out, "broker down") || !strings.Contains(notes, "would redrive 2") {
+		t.Errorf("dry run printed %q, noted %q", out, notes)
+	}
+	if count(t, db, outbox) != 0 || count(t, db, parkingLot) != 2 {
+		t.Fatalf("dry run moved events: %d in the outbox, %d parked", count(t, db, outbox), count(t, db, parkingLot))
+	}
+
+	if _, notes, err = run(t, runRedrive, "parked", "--limit", "1", "--yes"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(notes, "redrove 1") || count(t, db, outbox) != 1 || count(t, db, parkingLot) != 1 {
+		t.Errorf("--limit 1 --yes: noted %q, %d in the outbox, %d parked", notes, count(t, db, outbox), count(t, db, parkingLot))
+	}
+
+	var redriven sharedmodel.OutboxEvent
+	if err := db.Collection(outbox).FindOne(context.Background(), bson.M{}).Decode(&redriven); err != nil {
+		t.Fatal(err)
+	}
+	if redriven.Status != sharedmodel.OutboxStatusPending || redriven.Attempts != 0 || redriven.LastError != "" || redriven.ParkedAt != nil {
+		t.Errorf("redriven event %+v, want pending with a fresh attempt budget", redriven)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// stdout is the real standard output. main points os.Stdout at stderr so the
+// log lines printed by repositories never mix with results.
+var stdout = os.Stdout
+
+// printer renders results either as an aligned table or as indented JSON.
+type printer struct {
+	format string
+}
+
+func newPrinter(format string) (printer, error) {
+	switch format {
+	case "table", "json":
+		return printer{format: format}, nil
+	}
+	return printer{}, fmt.Errorf("unknown output format %q (want table or json)", format)
+}
+
+// print writes rows under headers in table mode, or v in JSON mode.
+func (p printer) print(headers []string, rows [][]string, v interface{}) {
+	if p.format == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(v)
+		return
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
+
+// note writes human-oriented messages to stderr so JSON output stays parseable.
+func note(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
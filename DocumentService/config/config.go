@@ -1,5 +1,16 @@
 package config
 
+import (
+	"os"
+	"shared/content"
+	"shared/cors"
+	"shared/events"
+	sharedmodel "shared/model"
+	"shared/secrets"
+	"strconv"
+	"time"
+)
+
 type Config struct {
 }
 
@@ -9,12 +20,285 @@ type MongoConfigStruct struct {
 	UserCollectionName            string
 	DocumentCollectionName        string
 	SharedDocRecordCollectionName string
+	OutboxCollectionName          string
+	OutboxParkedCollectionName    string
+	OperationCollectionName       string
+	SnapshotCollectionName        string
+	FolderCollectionName          string
+	FavoriteCollectionName        string
+	ActivityCollectionName        string
+	AccessRequestCollectionName   string
 }
 
 var MongoConfig = MongoConfigStruct{
 	MongoUri:                      "mongodb://canvas-live-mongodb:27017",
 	DatabaseName:                  "default",
-	UserCollectionName:            "user",
-	DocumentCollectionName:        "document",
-	SharedDocRecordCollectionName: "shared",
+	UserCollectionName:            sharedmodel.UserCollection,
+	DocumentCollectionName:        sharedmodel.DocumentCollection,
+	SharedDocRecordCollectionName: sharedmodel.SharedDocRecordCollection,
+	OutboxCollectionName:          sharedmodel.OutboxCollection,
+	OutboxParkedCollectionName:    sharedmodel.OutboxParkedCollection,
+	OperationCollectionName:       sharedmodel.OperationCollection,
+	SnapshotCollectionName:        sharedmodel.SnapshotCollection,
+	FolderCollectionName:          sharedmodel.FolderCollection,
+	FavoriteCollectionName:        sharedmodel.FavoriteCollection,
+	ActivityCollectionName:        sharedmodel.ActivityCollection,
+	AccessRequestCollectionName:   sharedmodel.AccessRequestCollection,
+}
+
+type KafkaConfigStruct struct {
+	Broker              string
+	DocumentEventsTopic string
+	UserEventsTopic     string
+	LifecycleGroupID    string
+}
+
+var KafkaConfig = KafkaConfigStruct{
+	Broker:              getEnv("KAFKA_BROKER", "canvas-live-kafka:9092"),
+	DocumentEventsTopic: getEnv("KAFKA_DOCUMENT_EVENTS_TOPIC", events.TopicDocumentEvents),
+	UserEventsTopic:     getEnv("KAFKA_USER_EVENTS_TOPIC", events.TopicUserEvents),
+	LifecycleGroupID:    getEnv("KAFKA_LIFECYCLE_GROUP_ID", "document-service-lifecycle"),
+}
+
+// Ways requests are authenticated, selected with AUTH_MODE.
+const (
+	// AuthModeRemote asks AuthService about every request's token.
+	AuthModeRemote = "remote"
+	// AuthModeLocal verifies RS256 tokens against AuthService's published keys.
+	AuthModeLocal = "local"
+	// AuthModeGateway trusts the identity headers set by the gateway's
+	// auth_request. Only safe when the service is not reachable directly.
+	AuthModeGateway = "gateway"
+)
+
+// AuthServiceConfigStruct locates the AuthService, used to authenticate
+// requests and to resolve collaborators by email. A user lookup, retries
+// included, takes at most LookupTimeout; after BreakerThreshold failures in a
+// row lookups fail at once for BreakerCooldown rather than wait on an
+// AuthService that is down.
+type AuthServiceConfigStruct struct {
+	URL              string
+	Timeout          time.Duration
+	Mode             string
+	TokenLeeway      time.Duration
+	LookupTimeout    time.Duration
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+var AuthServiceConfig = AuthServiceConfigStruct{
+	URL:              getEnv("AUTH_SERVICE_URL", "http://auth-service:8081"),
+	Timeout:          getEnvDuration("AUTH_SERVICE_TIMEOUT", 3*time.Second),
+	Mode:             getEnv("AUTH_MODE", AuthModeRemote),
+	TokenLeeway:      getEnvDuration("TOKEN_LEEWAY", 30*time.Second),
+	LookupTimeout:    getEnvDuration("AUTH_LOOKUP_TIMEOUT", 2*time.Second),
+	BreakerThreshold: getEnvInt("AUTH_BREAKER_THRESHOLD", 5),
+	BreakerCooldown:  getEnvDuration("AUTH_BREAKER_COOLDOWN", 30*time.Second),
+}
+
+// AccessConfigStruct controls how documents the caller may not see are
+// answered. With HideForbidden they get the same 404 as missing documents, so
+// document IDs cannot be probed; otherwise they get a 403.
+//
+// With KeepAccessOnTransfer the previous owner of a transferred document
+// stays on it as a write collaborator.
+type AccessConfigStruct struct {
+	HideForbidden        bool
+	KeepAccessOnTransfer bool
+}
+
+var AccessConfig = AccessConfigStruct{
+	HideForbidden:        getEnv("HIDE_FORBIDDEN_DOCUMENTS", "true") == "true",
+	KeepAccessOnTransfer: getEnv("TRANSFER_KEEPS_PREVIOUS_OWNER", "true") == "true",
+}
+
+// ContentConfigStruct limits document content written over REST. Larger
+// request bodies are rejected with 413. UpdatesService and the updates
+// consumer read the same variable, see shared/content.
+type ContentConfigStruct struct {
+	MaxBytes int64
+}
+
+var ContentConfig = ContentConfigStruct{
+	MaxBytes: int64(getEnvInt(content.MaxBytesEnv, content.DefaultMaxBytes)),
+}
+
+// BulkConfigStruct caps how many documents one bulk request may name, and
+// how many collaborators one bulk share may.
+type BulkConfigStruct struct {
+	MaxDocuments int
+	MaxShares    int
+}
+
+var BulkConfig = BulkConfigStruct{
+	MaxDocuments: getEnvInt("MAX_BULK_DOCUMENTS", 100),
+	MaxShares:    getEnvInt("MAX_BULK_SHARES", 100),
+}
+
+// ServerConfigStruct controls the HTTP server. On SIGTERM /ready starts
+// failing at once; after DrainDelay, time for the load balancer to notice, the
+// server stops accepting connections and in-flight requests get up to
+// ShutdownTimeout to finish.
+type ServerConfigStruct struct {
+	Addr            string
+	DrainDelay      time.Duration
+	ShutdownTimeout time.Duration
+}
+
+var ServerConfig = ServerConfigStruct{
+	Addr:            getEnv("SERVER_ADDR", ":8082"),
+	DrainDelay:      getEnvDuration("SHUTDOWN_DRAIN_DELAY", 0),
+	ShutdownTimeout: getEnvDuration("SHUTDOWN_TIMEOUT", 15*time.Second),
+}
+
+// HistoryConfigStruct bounds the version history: a document keeps its
+// MaxVersions newest snapshots. DocumentUpdatesConsumer, which takes most of
+// them, reads the same VERSION_HISTORY_MAX.
+type HistoryConfigStruct struct {
+	MaxVersions int
+}
+
+var HistoryConfig = HistoryConfigStruct{
+	MaxVersions: getEnvInt("VERSION_HISTORY_MAX", 100),
+}
+
+// ActivityConfigStruct sizes the queue of document opens waiting to be
+// recorded. Opens arriving while it is full are dropped rather than slowing
+// the request down.
+type ActivityConfigStruct struct {
+	BufferSize int
+}
+
+var ActivityConfig = ActivityConfigStruct{
+	BufferSize: getEnvInt("ACTIVITY_BUFFER_SIZE", 1024),
+}
+
+// CacheConfigStruct controls the Redis cache documents are read through.
+// Documents stay cached for at most DocumentTTL; 0 disables the cache. Live
+// updates reach MongoDB through the updates consumer, which must point at the
+// same Redis to invalidate what it changes.
+type CacheConfigStruct struct {
+	DocumentTTL time.Duration
+}
+
+var CacheConfig = CacheConfigStruct{
+	DocumentTTL: getEnvDuration("DOCUMENT_CACHE_TTL", time.Minute),
+}
+
+// RateLimitConfigStruct limits requests per user, or per IP before they
+// authenticate, with token buckets refilled at PerMinute and holding Burst.
+// Reads, writes, and creating or sharing documents have separate buckets; a
+// PerMinute of 0 disables that bucket. Backend is "memory" (per instance) or
+// "redis" (shared by every replica).
+type RateLimitConfigStruct struct {
+	Backend         string
+	ReadPerMinute   int
+	ReadBurst       int
+	WritePerMinute  int
+	WriteBurst      int
+	CreatePerMinute int
+	CreateBurst     int
+}
+
+var RateLimitConfig = RateLimitConfigStruct{
+	Backend:         getEnv("RATE_LIMIT_BACKEND", "memory"),
+	ReadPerMinute:   getEnvInt("RATE_LIMIT_READ_PER_MINUTE", 600),
+	ReadBurst:       getEnvInt("RATE_LIMIT_READ_BURST", 100),
+	WritePerMinute:  getEnvInt("RATE_LIMIT_WRITE_PER_MINUTE", 240),
+	WriteBurst:      getEnvInt("RATE_LIMIT_WRITE_BURST", 60),
+	CreatePerMinute: getEnvInt("RATE_LIMIT_CREATE_PER_MINUTE", 30),
+	CreateBurst:     getEnvInt("RATE_LIMIT_CREATE_BURST", 10),
+}
+
+// TrashConfigStruct controls how long trashed documents are kept before the
+// background purge deletes them for good, and how often it runs.
+type TrashConfigStruct struct {
+	Retention     time.Duration
+	PurgeInterval time.Duration
+}
+
+var TrashConfig = TrashConfigStruct{
+	Retention:     getEnvDuration("TRASH_RETENTION", 30*24*time.Hour),
+	PurgeInterval: getEnvDuration("TRASH_PURGE_INTERVAL", time.Hour),
+}
+
+// CORSConfig admits browser clients served from other origins, configured
+// with CORS_ALLOWED_ORIGINS and related variables (see cors.FromEnv).
+var CORSConfig = cors.FromEnv()
+
+type RedisConfigStruct struct {
+	Addr     string
+	Password string
+}
+
+var RedisConfig = RedisConfigStruct{
+	Addr: getEnv("REDIS_ADDR", "canvas-live-redis:6379"),
+}
+
+// Secrets accept a NAME_FILE variant pointing at a mounted secret file.
+var Secrets = secrets.NewSet()
+
+var (
+	// MongoURI may embed the database password, so it is treated as a secret.
+	MongoURI      = Secrets.Add(secrets.Spec{Name: "MONGO_URI", Fallback: MongoConfig.MongoUri})
+	RedisPassword = Secrets.Add(secrets.Spec{Name: "REDIS_PASSWORD"})
+
+	// InternalHMACKey signs service-to-service requests. Reloaded on SIGHUP.
+	InternalHMACKey = Secrets.Add(secrets.Spec{Name: "INTERNAL_HMAC_KEY", Reloadable: true})
+
+	// APIKeys is a comma-separated list of keys accepted from automation clients. Reloaded on SIGHUP.
+	APIKeys = Secrets.Add(secrets.Spec{Name: "API_KEYS", Reloadable: true})
+)
+
+// Load reads every secret and applies the non-reloadable ones to the static config.
+func Load() error {
+	if err := Secrets.Load(); err != nil {
+		return err
+	}
+
+	MongoConfig.MongoUri = MongoURI.Get()
+	RedisConfig.Password = RedisPassword.Get()
+	return nil
+}
+
+// OutboxConfigStruct controls the background relay that moves outbox rows to Kafka.
+type OutboxConfigStruct struct {
+	PollInterval time.Duration
+	BatchSize    int64
+	MaxAttempts  int
+	LockKey      string
+	// LockTTL is how long the relay lock outlives its holder; it is renewed
+	// during a batch, and bounds how long one publish may wait for its ack.
+	LockTTL time.Duration
+}
+
+var OutboxConfig = OutboxConfigStruct{
+	PollInterval: getEnvDuration("OUTBOX_POLL_INTERVAL", 500*time.Millisecond),
+	BatchSize:    int64(getEnvInt("OUTBOX_BATCH_SIZE", 100)),
+	MaxAttempts:  getEnvInt("OUTBOX_MAX_ATTEMPTS", 10),
+	LockKey:      getEnv("OUTBOX_LOCK_KEY", "document-service:outbox-relay"),
+	LockTTL:      getEnvDuration("OUTBOX_LOCK_TTL", 10*time.Second),
+}
+
+func getEnv(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
 }
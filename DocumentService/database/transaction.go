@@ -0,0 +1,34 @@
+package database
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SupportsTransactions reports whether the connected deployment is a replica set
+// or sharded cluster. Standalone servers reject multi-document transactions.
+func SupportsTransactions(client *mongo.Client) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var hello bson.M
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		log.Printf("[Database] Could not determine topology, assuming no transaction support: %v", err)
+		return false
+	}
+
+	if _, ok := hello["setName"]; ok {
+		return true
+	}
+	if msg, ok := hello["msg"].(string); ok && msg == "isdbgrid" {
+		return true
+	}
+
+	log.Println("[Database] Standalone MongoDB detected: multi-document writes will not be transactional")
+	return false
+}
@@ -0,0 +1,173 @@
+// Package export converts documents to downloadable formats. Each format is
+// registered in formats; handlers look it up by name and never need to know
+// about individual formats.
+package export
+
+import (
+	"bufio"
+	"document-service/model"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// SchemaVersion is the version of the JSON export format.
+const SchemaVersion = 1
+
+// Format writes documents in one export format.
+type Format struct {
+	ContentType string
+	Extension   string
+	// Write serializes document to w slide by slide, so large documents are
+	// streamed instead of built in memory first.
+	Write func(w io.Writer, document model.Document) error
+}
+
+var formats = map[string]Format{
+	"json": {ContentType: "application/json; charset=utf-8", Extension: "json", Write: writeJSON},
+	"md":   {ContentType: "text/markdown; charset=utf-8", Extension: "md", Write: writeMarkdown},
+	"txt":  {ContentType: "text/plain; charset=utf-8", Extension: "txt", Write: writeText},
+}
+
+// Lookup returns the format registered under name.
+func Lookup(name string) (Format, bool) {
+	format, ok := formats[strings.ToLower(name)]
+	return format, ok
+}
+
+// Names returns the names of every format in sorted order.
+func Names() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ContentDisposition returns the Content-Disposition of a download of the
+// document titled title in format.
+func (f Format) ContentDisposition(title string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": Filename(title) + "." + f.Extension})
+}
+
+// Filename derives a file name, without extension, from a document title.
+func Filename(title string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == '-', r == '_', r == '.':
+			return r
+		case unicode.IsSpace(r):
+			return ' '
+		}
+		return -1
+	}, title)
+	name = strings.Join(strings.Fields(name), " ")
+	name = strings.Trim(name, ".")
+	if name == "" {
+		return "document"
+	}
+	return name
+}
+
+// writeJSON writes the export schema that Parse reads back.
+func writeJSON(w io.Writer, document model.Document) error {
+	buf := bufio.NewWriter(w)
+
+	title, err := json.Marshal(document.Title)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(buf, `{"schemaVersion":%d,"title":%s,"slides":[`, SchemaVersion, title)
+
+	for i, slide := range document.Slides {
+		if slide.Objects == nil {
+			slide.Objects = []model.Object{}
+		}
+		encoded, err := json.Marshal(slide)
+		if err != nil {
+			return fmt.Errorf("encoding slide %d: %w", i, err)
+		}
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(encoded)
+	}
+
+	buf.WriteString("]}\n")
+	return buf.Flush()
+}
+
+// writeMarkdown writes a heading per slide, the slide's text as paragraphs,
+// and a list of its shapes.
+func writeMarkdown(w io.Writer, document model.Document) error {
+	buf := bufio.NewWriter(w)
+	fmt.Fprintf(buf, "# %s\n", document.Title)
+
+	for i, slide := range document.Slides {
+		fmt.Fprintf(buf, "\n## Slide %d\n", i+1)
+
+		for _, text := range texts(slide) {
+			fmt.Fprintf(buf, "\n%s\n", text)
+		}
+
+		shapes := false
+		for _, object := range slide.Objects {
+			if object.Type == "text" {
+				continue
+			}
+			if !shapes {
+				buf.WriteString("\n")
+				shapes = true
+			}
+			fmt.Fprintf(buf, "- %s\n", describe(object))
+		}
+	}
+
+	return buf.Flush()
+}
+
+// writeText writes only the text of each slide.
+func writeText(w io.Writer, document model.Document) error {
+	buf := bufio.NewWriter(w)
+	fmt.Fprintf(buf, "%s\n", document.Title)
+
+	for i, slide := range document.Slides {
+		fmt.Fprintf(buf, "\nSlide %d\n", i+1)
+		for _, text := range texts(slide) {
+			fmt.Fprintf(buf, "%s\n", text)
+		}
+	}
+
+	return buf.Flush()
+}
+
+// texts returns the values of the slide's text objects.
+func texts(slide model.Slide) []string {
+	var values []string
+	for _, object := range slide.Objects {
+		if object.Type != "text" {
+			continue
+		}
+		if value, _ := object.Attributes["value"].(string); strings.TrimSpace(value) != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// describe names a shape with its position and size.
+func describe(object model.Object) string {
+	attr := object.Attributes
+	switch object.Type {
+	case "rectangle":
+		return fmt.Sprintf("Rectangle at (%v, %v), %v × %v", attr["x"], attr["y"], attr["width"], attr["height"])
+	case "circle":
+		return fmt.Sprintf("Circle at (%v, %v), radius %v", attr["cx"], attr["cy"], attr["radius"])
+	}
+	return fmt.Sprintf("%s object %s", object.Type, object.ID)
+}
@@ -0,0 +1,200 @@
+package export
+
+import (
+	"bytes"
+	"document-service/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// plan is a document with text, shapes, and an empty slide. Numbers are
+// float64, as they come back from JSON.
+func plan() model.Document {
+	return model.Document{
+		Title: "Q3 plan",
+		Slides: []model.Slide{
+			{ID: "s-1", Background: "#ffffff", Objects: []model.Object{
+				{ID: "o-1", Type: "text", Attributes: map[string]interface{}{"value": "Goals"}},
+				{ID: "o-2", Type: "rectangle", Attributes: map[string]interface{}{"x": 1.0, "y": 2.0, "width": 3.0, "height": 4.0}},
+				{ID: "o-3", Type: "text", Attributes: map[string]interface{}{"value": "  "}},
+				{ID: "o-4", Type: "circle", Attributes: map[string]interface{}{"cx": 5.0, "cy": 6.0, "radius": 7.5}},
+				{ID: "o-5", Type: "arrow", Attributes: map[string]interface{}{}},
+				{ID: "o-6", Type: "text", Attributes: map[string]interface{}{"value": "Ship it"}},
+			}},
+			{ID: "s-2", Background: "#000000"},
+		},
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for name, want := range map[string]string{"json": "json", "MD": "md", "txt": "txt"} {
+		format, ok := Lookup(name)
+		if !ok || format.Extension != want {
+			t.Errorf("Lookup(%q) = %+v, %v, want the %s format", name, format, ok, want)
+		}
+	}
+	for _, name := range []string{"", "pdf", "markdown"} {
+		if _, ok := Lookup(name); ok {
+			t.Errorf("Lookup(%q) found a format", name)
+		}
+	}
+	if got := Names(); !slices.Equal(got, []string{"json", "md", "txt"}) {
+		t.Errorf("Names() = %q, want json, md, txt", got)
+	}
+}
+
+func TestFilename(t *testing.T) {
+	tests := map[string]string{
+		"Q3 plan":              "Q3 plan",
+		"  Q3\tplan  ":         "Q3 plan",
+		"../../etc/passwd":     "etcpasswd",
+		"report.final":         "report.final",
+		"...":                  "document",
+		"":                     "document",
+		`"quoted" / slashed`:   "quoted slashed",
+		"Résumé 2026":          "Résumé 2026",
+		"line\nbreak\r\nfield": "line break field",
+	}
+	for title, want := range tests {
+		if got := Filename(title); got != want {
+			t.Errorf("Filename(%q) = %q, want %q", title, got, want)
+		}
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	md, _ := Lookup("md")
+	if got, want := md.ContentDisposition("Q3 plan"), `attachment; filename="Q3 plan.md"`; got != want {
+		t.Errorf("ContentDisposition = %q, want %q", got, want)
+	}
+	// Non-ASCII names are encoded rather than sent raw
+	jsonFormat, _ := Lookup("json")
+	if got, want := jsonFormat.ContentDisposition("Résumé"), `attachment; filename*=utf-8''R%C3%A9sum%C3%A9.json`; got != want {
+		t.Errorf("ContentDisposition = %q, want %q", got, want)
+	}
+}
+
+func write(t *testing.T, name string, document model.Document) string {
+	t.Helper()
+	format, _ := Lookup(name)
+	var buf bytes.Buffer
+	if err := format.Write(&buf, document); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	want := "# Q3 plan\n" +
+		"\n## Slide 1\n" +
+		"\nGoals\n" +
+		"\nShip it\n" +
+		"\n- Rectangle at (1, 2), 3 × 4\n" +
+		"- Circle at (5, 6), radius 7.5\n" +
+		"- arrow object o-5\n" +
+		"\n## Slide 2\n"
+	if got := write(t, "md", plan()); got != want {
+		t.Errorf("markdown:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	want := "Q3 plan\n" +
+		"\nSlide 1\n" +
+		"Goals\n" +
+		"Ship it\n" +
+		"\nSlide 2\n"
+	if got := write(t, "txt", plan()); got != want {
+		t.Errorf("text:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(write(t, "json", plan())), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["schemaVersion"] != float64(SchemaVersion) || got["title"] != "Q3 plan" {
+		t.Errorf("header of %v", got)
+	}
+	slides, _ := got["slides"].([]interface{})
+	if len(slides) != 2 {
+		t.Fatalf("slides = %v, want 2", got["slides"])
+	}
+	// An empty slide still has a list of objects
+	if objects, ok := slides[1].(map[string]interface{})["objects"].([]interface{}); !ok || len(objects) != 0 {
+		t.Errorf("empty slide = %v, want objects []", slides[1])
+	}
+	// Only the schema is exported, nothing about who owns the document
+	if len(got) != 3 {
+		t.Errorf("keys of %v, want schemaVersion, title, and slides", got)
+	}
+
+	var empty map[string]interface{}
+	json.Unmarshal([]byte(write(t, "json", model.Document{Title: "Empty"})), &empty)
+	if slides, ok := empty["slides"].([]interface{}); !ok || len(slides) != 0 {
+		t.Errorf("document without slides: %v, want slides []", empty)
+	}
+}
+
+// writeRecorder records the size of each write it is given.
+type writeRecorder struct {
+	sizes []int
+}
+
+func (w *writeRecorder) Write(p []byte) (int, error) {
+	w.sizes = append(w.sizes, len(p))
+	return len(p), nil
+}
+
+func TestLargeDocumentsAreStreamed(t *testing.T) {
+	var document model.Document
+	document.Title = "Large"
+	for i := range 2000 {
+		document.Slides = append(document.Slides, model.Slide{ID: fmt.Sprintf("s-%d", i), Objects: []model.Object{
+			{ID: "o-1", Type: "text", Attributes: map[string]interface{}{"value": strings.Repeat("words ", 20)}},
+		}})
+	}
+
+	for _, name := range Names() {
+		format, _ := Lookup(name)
+		var w writeRecorder
+		if err := format.Write(&w, document); err != nil {
+			t.Fatal(err)
+		}
+		total := 0
+		for _, size := range w.sizes {
+			total += size
+			if size > 64<<10 {
+				t.Errorf("%s: a write of %d bytes, want the export written in pieces", name, size)
+			}
+		}
+		if len(w.sizes) < 10 {
+			t.Errorf("%s: %d bytes in %d writes, want it streamed", name, total, len(w.sizes))
+		}
+	}
+}
+
+// failingWriter is a client that went away.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestWriteErrorsAreReturned(t *testing.T) {
+	document := plan()
+	for len(document.Slides) < 500 {
+		document.Slides = append(document.Slides, plan().Slides...)
+	}
+	for _, name := range Names() {
+		format, _ := Lookup(name)
+		if err := format.Write(failingWriter{}, document); err == nil {
+			t.Errorf("%s: no error writing to a closed connection", name)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package export
+
+import (
+	"document-service/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Document is the JSON export schema, as written by the json format and read
+// by Parse.
+type Document struct {
+	SchemaVersion int           `json:"schemaVersion"`
+	Title         string        `json:"title"`
+	Slides        []model.Slide `json:"slides"`
+}
+
+// ParseError tells what is wrong with an import. Pointer is the JSON Pointer
+// (RFC 6901) of the offending value; it is empty when the input is not JSON
+// at all.
+type ParseError struct {
+	Pointer string
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	if e.Pointer == "" {
+		return e.Message
+	}
+	return e.Pointer + ": " + e.Message
+}
+
+// Parse reads a document in the JSON export schema and checks its structure.
+// Any problem is returned as a *ParseError.
+func Parse(r io.Reader) (Document, error) {
+	var document Document
+	decoder := json.NewDecoder(r)
+	if err := decoder.Decode(&document); err != nil {
+		return Document{}, decodeError(err)
+	}
+	if decoder.More() {
+		return Document{}, &ParseError{Message: "unexpected data after the document"}
+	}
+
+	if document.SchemaVersion != SchemaVersion {
+		return Document{}, &ParseError{Pointer: "/schemaVersion", Message: fmt.Sprintf("must be %d", SchemaVersion)}
+	}
+	if len(document.Slides) == 0 {
+		return Document{}, &ParseError{Pointer: "/slides", Message: "must contain at least one slide"}
+	}
+
+	// Live updates address slides and objects by ID, so they must be unique
+	slideIDs := make(map[string]bool, len(document.Slides))
+	for i, slide := range document.Slides {
+		if slide.ID == "" || slideIDs[slide.ID] {
+			return Document{}, &ParseError{Pointer: fmt.Sprintf("/slides/%d/id", i), Message: "must be a unique, non-empty id"}
+		}
+		slideIDs[slide.ID] = true
+
+		objectIDs := make(map[string]bool, len(slide.Objects))
+		for j, object := range slide.Objects {
+			pointer := fmt.Sprintf("/slides/%d/objects/%d", i, j)
+			if object.ID == "" || objectIDs[object.ID] {
+				return Document{}, &ParseError{Pointer: pointer + "/id", Message: "must be a unique, non-empty id"}
+			}
+			objectIDs[object.ID] = true
+			if object.Type == "" {
+				return Document{}, &ParseError{Pointer: pointer + "/type", Message: "is required"}
+			}
+			if object.Attributes == nil {
+				return Document{}, &ParseError{Pointer: pointer + "/attributes", Message: "must be an object"}
+			}
+		}
+	}
+
+	return document, nil
+}
+
+// decodeError turns a decoding error into a ParseError, with a pointer when
+// the decoder tells which field failed.
+func decodeError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		if typeErr.Field == "" {
+			return &ParseError{Message: "must be a JSON object with schemaVersion, title, and slides"}
+		}
+		pointer := "/" + strings.ReplaceAll(typeErr.Field, ".", "/")
+		return &ParseError{Pointer: pointer, Message: fmt.Sprintf("must be %s, not %s", typeErr.Type, typeErr.Value)}
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return &ParseError{Message: fmt.Sprintf("invalid JSON at byte %d: %v", syntaxErr.Offset, err)}
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return &ParseError{Message: "the document is empty or cut short"}
+	}
+	return err
+}
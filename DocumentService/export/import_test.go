@@ -0,0 +1,106 @@
+package export
+
+import (
+	"bytes"
+	"document-service/model"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	slide := `{"id":"s-1","objects":[{"id":"o-1","type":"text","attributes":{}}]}`
+	tests := []struct {
+		name    string
+		input   string
+		pointer string
+	}{
+		{"empty", ``, ""},
+		{"cut short", `{"schemaVersion":1,"title":"Plan","slides":[`, ""},
+		{"not JSON", `<html>`, ""},
+		{"not an object", `[1, 2]`, ""},
+		{"trailing data", `{"schemaVersion":1,"title":"Plan","slides":[` + slide + `]} {}`, ""},
+		{"no schema version", `{"title":"Plan","slides":[` + slide + `]}`, "/schemaVersion"},
+		{"future schema version", `{"schemaVersion":2,"title":"Plan","slides":[` + slide + `]}`, "/schemaVersion"},
+		{"title of the wrong type", `{"schemaVersion":1,"title":7,"slides":[` + slide + `]}`, "/title"},
+		{"no slides", `{"schemaVersion":1,"title":"Plan","slides":[]}`, "/slides"},
+		{"slides of the wrong type", `{"schemaVersion":1,"title":"Plan","slides":{}}`, "/slides"},
+		{"slide without id", `{"schemaVersion":1,"title":"Plan","slides":[{"objects":[]}]}`, "/slides/0/id"},
+		{"duplicate slide id", `{"schemaVersion":1,"title":"Plan","slides":[` + slide + `,` + slide + `]}`, "/slides/1/id"},
+		{"objects of the wrong type", `{"schemaVersion":1,"title":"Plan","slides":[{"id":"s-1","objects":"none"}]}`, "/slides/0/objects"},
+		{"duplicate object id", `{"schemaVersion":1,"title":"Plan","slides":[{"id":"s-1","objects":[{"id":"o-1","type":"text","attributes":{}},{"id":"o-1","type":"text","attributes":{}}]}]}`, "/slides/0/objects/1/id"},
+		{"object without type", `{"schemaVersion":1,"title":"Plan","slides":[{"id":"s-1","objects":[{"id":"o-1","attributes":{}}]}]}`, "/slides/0/objects/0/type"},
+		{"object without attributes", `{"schemaVersion":1,"title":"Plan","slides":[{"id":"s-1","objects":[{"id":"o-1","type":"text"}]}]}`, "/slides/0/objects/0/attributes"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(strings.NewReader(tt.input))
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("%s: error %v, want a *ParseError", tt.name, err)
+			continue
+		}
+		if parseErr.Pointer != tt.pointer || parseErr.Message == "" {
+			t.Errorf("%s: pointer %q, message %q, want pointer %q", tt.name, parseErr.Pointer, parseErr.Message, tt.pointer)
+		}
+	}
+}
+
+// brokenReader fails mid-way, like an upload cut short by the client.
+type brokenReader struct{}
+
+func (brokenReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestReadErrorsAreNotParseErrors(t *testing.T) {
+	_, err := Parse(brokenReader{})
+	var parseErr *ParseError
+	if err == nil || errors.As(err, &parseErr) {
+		t.Errorf("error %v, want the read error rather than a *ParseError", err)
+	}
+}
+
+func TestParseErrorString(t *testing.T) {
+	if got := (&ParseError{Pointer: "/slides", Message: "must contain at least one slide"}).Error(); got != "/slides: must contain at least one slide" {
+		t.Errorf("Error() = %q", got)
+	}
+	if got := (&ParseError{Message: "the document is empty or cut short"}).Error(); got != "the document is empty or cut short" {
+		t.Errorf("Error() = %q", got)
+	}
+}
+
+func TestExportThenImport(t *testing.T) {
+	document := plan()
+	// Imports need at least one slide and give each an object list
+	document.Slides[1].Objects = nil
+
+	format, _ := Lookup("json")
+	var buf bytes.Buffer
+	if err := format.Write(&buf, document); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := Parse(&buf)
+	if err != nil {
+		t.Fatalf("importing an export: %v", err)
+	}
+
+	if imported.SchemaVersion != SchemaVersion || imported.Title != document.Title {
+		t.Errorf("imported %d %q, want %d %q", imported.SchemaVersion, imported.Title, SchemaVersion, document.Title)
+	}
+	if len(imported.Slides) != len(document.Slides) || len(imported.Slides[1].Objects) != 0 {
+		t.Fatalf("imported slides %+v", imported.Slides)
+	}
+	imported.Slides[1].Objects = nil
+	if !reflect.DeepEqual(imported.Slides, document.Slides) {
+		t.Errorf("imported slides %+v, want %+v", imported.Slides, document.Slides)
+	}
+
+	// And exporting the import again gives the same bytes
+	var again, first bytes.Buffer
+	format.Write(&first, document)
+	format.Write(&again, model.Document{Title: imported.Title, Slides: imported.Slides})
+	if again.String() != first.String() {
+		t.Errorf("re-export %s, want %s", again.String(), first.String())
+	}
+}
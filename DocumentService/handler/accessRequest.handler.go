@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"document-service/model"
+	"document-service/repository"
+	"document-service/types"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"shared/apierror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ================================= Access Request Handlers ==============================
+
+// RequestAccess asks the owner of a document to share it with the user. It
+// answers 202 whether or not a request was recorded: not for documents that
+// do not exist, nor when the user already has the access asked for, but
+// telling those apart would reveal which document IDs exist. Asking again
+// updates the pending request.
+//
+// Route: POST /document/:id/request-access
+func (h DocumentHandler) RequestAccess(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+
+	var data types.AccessRequestData
+	err := c.ShouldBindJSON(&data)
+	if errors.Is(err, types.ErrInvalidAccessType) {
+		apierror.Abort(c, http.StatusUnprocessableEntity, apierror.CodeValidationFailed, err.Error())
+		return
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid data format or missing fields")
+		return
+	}
+	data.Normalize()
+	if err := data.Validate(); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, err.Error())
+		return
+	}
+
+	level, err := h.DocumentRepository.GetAccessLevel(c, userId, documentId)
+	if err != nil && !errors.Is(err, repository.ErrDocumentNotFound) {
+		apierror.Internal(c, "Error requesting access", err)
+		return
+	}
+	if err == nil && !repository.HasAccess(level, string(data.AccessType)) {
+		if err := h.AccessRequests.RequestAccess(c, documentId, userId, data.AccessType, data.Message); err != nil {
+			apierror.Internal(c, "Error requesting access", err)
+			return
+		}
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// GetAccessRequests lists the pending access requests of one of the user's
+// documents, oldest first.
+//
+// Route: GET /document/:id/access-requests
+func (h DocumentHandler) GetAccessRequests(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := h.authorizeDocument(c, userId, documentId, repository.AccessOwner); !ok {
+		return
+	}
+
+	requests, err := h.AccessRequests.FindPendingRequests(c, documentId)
+	if err != nil {
+		apierror.Internal(c, "Error retrieving access requests", err)
+		return
+	}
+
+	ids := make([]string, 0, len(requests))
+	for _, request := range requests {
+		ids = append(ids, request.UserID)
+	}
+	users, err := h.Users.ResolveUsers(c, ids)
+	if err != nil {
+		fmt.Printf("[DocumentHandler][GetAccessRequests] Error resolving requesters: %v\n", err)
+		apierror.Abort(c, http.StatusServiceUnavailable, apierror.CodeUserLookupUnavailable, "Could not look up the requesters, try again later")
+		return
+	}
+
+	result := make([]types.AccessRequestDto, 0, len(requests))
+	for _, request := range requests {
+		dto := types.AccessRequestDto{
+			ID:         request.ID.Hex(),
+			UserID:     request.UserID,
+			AccessType: request.AccessType,
+			Message:    request.Message,
+			CreatedAt:  request.CreatedAt,
+		}
+		if user, found := users[request.UserID]; found {
+			dto.Username = user.Username
+		} else {
+			dto.Username, dto.Unknown = types.UnknownUsername, true
+		}
+		result = append(result, dto)
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ApproveAccessRequest shares one of the user's documents with whoever
+// requested access, granting the requested access type unless the body
+// names another.
+//
+// Route: POST /document/:id/access-requests/:requestId/approve
+func (h DocumentHandler) ApproveAccessRequest(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+
+	var data types.ApproveAccessRequestData
+	err := c.ShouldBindJSON(&data)
+	if errors.Is(err, types.ErrInvalidAccessType) {
+		apierror.Abort(c, http.StatusUnprocessableEntity, apierror.CodeValidationFailed, err.Error())
+		return
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid data format or missing fields")
+		return
+	}
+
+	if _, ok := h.authorizeDocument(c, userId, documentId, repository.AccessOwner); !ok {
+		return
+	}
+
+	requestId, ok := ParseObjectIDParam(c, "requestId")
+	if !ok {
+		return
+	}
+	request, err := h.AccessRequests.FindPendingRequest(c, documentId, requestId)
+	if errors.Is(err, repository.ErrAccessRequestNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeAccessRequestNotFound, "Access request not found")
+		return
+	}
+	if err != nil {
+		apierror.Internal(c, "Error retrieving access request", err)
+		return
+	}
+
+	accessType := data.AccessType
+	if accessType == "" {
+		if accessType, err = types.ParseAccessType(request.AccessType); err != nil {
+			accessType = types.AccessRead
+		}
+	}
+
+	// Share first, so an approved request always has its share; approving
+	// again after a failure in between only updates the share
+	if _, err := h.DocumentRepository.CreateCollaborationRecord(c, request.UserID, documentId, accessType); err != nil {
+		apierror.Internal(c, "Error creating a collaboration record", err)
+		return
+	}
+	h.decideAccessRequest(c, userId, documentId, requestId, model.AccessRequestApproved)
+}
+
+// DenyAccessRequest turns down a pending access request of one of the
+// user's documents.
+//
+// Route: POST /document/:id/access-requests/:requestId/deny
+func (h DocumentHandler) DenyAccessRequest(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := h.authorizeDocument(c, userId, documentId, repository.AccessOwner); !ok {
+		return
+	}
+
+	requestId, ok := ParseObjectIDParam(c, "requestId")
+	if !ok {
+		return
+	}
+
+	h.decideAccessRequest(c, userId, documentId, requestId, model.AccessRequestDenied)
+}
+
+// decideAccessRequest records the owner's decision and writes the response.
+func (h DocumentHandler) decideAccessRequest(c *gin.Context, userId string, documentId string, requestId string, status string) {
+	err := h.AccessRequests.DecideRequest(c, documentId, requestId, status, userId)
+	if errors.Is(err, repository.ErrAccessRequestNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeAccessRequestNotFound, "Access request not found")
+		return
+	}
+	if err != nil {
+		apierror.Internal(c, "Error deciding access request", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
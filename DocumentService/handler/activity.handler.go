@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"document-service/repository"
+	"document-service/types"
+	"fmt"
+	"net/http"
+	"shared/apierror"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ================================= Activity Handlers ==============================
+
+// maxRecentBatches bounds how many pages of activity GetRecentDocuments reads
+// while skipping documents the user can no longer open.
+const maxRecentBatches = 5
+
+// GetRecentDocuments lists the documents the user opened most recently,
+// newest first, up to ?limit= of them. Documents they can no longer open, or
+// that are in the trash, are left out; those shared with them carry their
+// owner's username.
+//
+// Route: GET /document/recent
+func (h DocumentHandler) GetRecentDocuments(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	limit := int64(types.DefaultRecentLimit)
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 1 || parsed > types.MaxRecentLimit {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, fmt.Sprintf("limit must be between 1 and %d", types.MaxRecentLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	// Read activity in batches, since some of it may point at documents
+	// that were unshared or deleted since
+	documents := []types.DocumentDto{}
+	batchSize := max(2*limit, 20)
+	for batch := int64(0); batch < maxRecentBatches && int64(len(documents)) < limit; batch++ {
+		activity, err := h.Activity.FindRecent(c, userId, batchSize, batch*batchSize)
+		if err != nil {
+			apierror.Internal(c, "Error retrieving recent activity", err)
+			return
+		}
+
+		documentIds := make([]string, len(activity))
+		for i, row := range activity {
+			documentIds[i] = row.DocumentID
+		}
+		accessible, err := h.DocumentRepository.FindAccessibleDocuments(c, userId, documentIds)
+		if err != nil {
+			apierror.Internal(c, "Error retrieving documents", err)
+			return
+		}
+		byID := make(map[string]types.DocumentDto, len(accessible))
+		for _, document := range accessible {
+			byID[document.ID.Hex()] = document
+		}
+
+		for _, row := range activity {
+			document, found := byID[row.DocumentID]
+			if !found {
+				continue
+			}
+			if document.AccessLevel != repository.AccessOwner {
+				document.FolderID = ""
+			}
+			documents = append(documents, document)
+			if int64(len(documents)) == limit {
+				break
+			}
+		}
+
+		if int64(len(activity)) < batchSize {
+			break
+		}
+	}
+
+	if !h.annotateDocuments(c, userId, documents) {
+		return
+	}
+	h.annotateOwners(c, documents)
+	c.JSON(http.StatusOK, types.RecentDocumentsDto{Documents: documents})
+}
+
+// RecordDocumentOpened notes that the user opened a document somewhere other
+// than GetDocumentByID, such as by joining its live session. UpdatesService
+// calls it with the user's credentials.
+//
+// Route: POST /document/:id/opened
+func (h DocumentHandler) RecordDocumentOpened(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := h.authorizeDocument(c, userId, documentId, repository.AccessRead); !ok {
+		return
+	}
+
+	h.Opens.Record(c, userId, documentId)
+	c.Status(http.StatusNoContent)
+}
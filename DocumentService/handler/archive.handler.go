@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"document-service/repository"
+	"document-service/types"
+	"errors"
+	"net/http"
+	"shared/apierror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ================================= Archive Handlers ==============================
+
+// ArchiveDocument archives one of the user's documents. Archived documents
+// drop out of the default listing and stay readable, but their content can
+// no longer be changed.
+//
+// Route: POST /document/:id/archive
+func (h DocumentHandler) ArchiveDocument(c *gin.Context) {
+	h.setArchived(c, true)
+}
+
+// UnarchiveDocument makes an archived document of the user editable again
+// and puts it back in the default listing.
+//
+// Route: POST /document/:id/unarchive
+func (h DocumentHandler) UnarchiveDocument(c *gin.Context) {
+	h.setArchived(c, false)
+}
+
+// setArchived archives or unarchives the :id document; only its owner may.
+func (h DocumentHandler) setArchived(c *gin.Context, archived bool) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := h.authorizeDocument(c, userId, documentId, repository.AccessOwner); !ok {
+		return
+	}
+
+	document, err := h.DocumentRepository.SetArchived(c, documentId, userId, archived)
+	if errors.Is(err, repository.ErrDocumentNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeDocumentNotFound, "Document not found")
+		return
+	}
+	if err != nil {
+		apierror.Internal(c, "Error archiving document", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, types.NewDocumentMetadataDto(*document))
+}
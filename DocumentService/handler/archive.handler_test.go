@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"document-service/types"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"shared/apierror"
+	"shared/authmw"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// archiveRouter serves the archive routes next to the listing, reading and
+// writing routes they affect.
+func archiveRouter(h DocumentHandler) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.ContextWithFallback = true
+	group := router.Group("/document", authmw.Middleware(authmw.GatewayHeaders{}))
+	group.GET("/all", h.GetAllDocuments)
+	group.GET("/id/:id", h.GetDocumentByID)
+	group.PUT("/:id/content", h.UpdateDocumentContent)
+	group.POST("/:id/archive", h.ArchiveDocument)
+	group.POST("/:id/unarchive", h.UnarchiveDocument)
+	return router
+}
+
+// listed returns the IDs of the owned and the shared documents userId lists
+// at path.
+func listed(t *testing.T, router *gin.Engine, userId string, path string) ([]string, []string) {
+	t.Helper()
+	rec := as(router, userId, http.MethodGet, path, "")
+	var all types.AllDocumentsDto
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &all) != nil {
+		t.Fatalf("listing %s: status %d %s", path, rec.Code, rec.Body)
+	}
+	var owned, shared []string
+	for _, document := range all.OwnedDocuments {
+		owned = append(owned, document.ID.Hex())
+	}
+	for _, document := range all.SharedDocuments {
+		shared = append(shared, document.ID.Hex())
+	}
+	return owned, shared
+}
+
+func TestArchiveRequestsAreValidated(t *testing.T) {
+	// Refused before the repository
+	router := archiveRouter(DocumentHandler{})
+
+	for _, path := range []string{"/document/d-1/archive", "/document/d-1/unarchive"} {
+		if rec := as(router, "u-1", http.MethodPost, path, ""); rec.Code != http.StatusBadRequest || errorCode(rec) != apierror.CodeInvalidID {
+			t.Errorf("%s: status %d %s, want 400 %s", path, rec.Code, rec.Body, apierror.CodeInvalidID)
+		}
+		if rec := as(router, "", http.MethodPost, path, ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s anonymously: status %d, want 401", path, rec.Code)
+		}
+	}
+	if rec := as(router, "u-1", http.MethodGet, "/document/all?archived=yes", ""); rec.Code != http.StatusBadRequest || errorCode(rec) != apierror.CodeValidationFailed {
+		t.Errorf("?archived=yes: status %d %s, want 400 %s", rec.Code, rec.Body, apierror.CodeValidationFailed)
+	}
+}
+
+func TestArchivedDocumentsAreHiddenAndReadOnly(t *testing.T) {
+	users := fakeDirectory{}
+	owner, collaborator := users.newUserID("owner"), users.newUserID("collaborator")
+	h := testHandler(t, users)
+	router := archiveRouter(h)
+
+	ctx := tenantContext()
+	archived, err := h.DocumentRepository.CreateNewDocument(ctx, "Done", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err := h.DocumentRepository.CreateNewDocument(ctx, "Ongoing", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, document := range []string{archived.ID.Hex(), active.ID.Hex()} {
+		if _, err := h.DocumentRepository.CreateCollaborationRecord(ctx, collaborator, document, "write"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	id := archived.ID.Hex()
+
+	// Only the owner archives
+	if rec := as(router, collaborator, http.MethodPost, "/document/"+id+"/archive", ""); rec.Code != http.StatusForbidden && rec.Code != http.StatusNotFound {
+		t.Errorf("collaborator archiving: status %d, want it refused", rec.Code)
+	}
+	for range 2 {
+		rec := as(router, owner, http.MethodPost, "/document/"+id+"/archive", "")
+		var metadata map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &metadata)
+		if rec.Code != http.StatusOK || metadata["archived"] != true {
+			t.Errorf("owner archiving: status %d %s, want 200 archived", rec.Code, rec.Body)
+		}
+	}
+
+	// Gone from the default lists of both, and the only one listed with ?archived=true
+	for _, user := range []string{owner, collaborator} {
+		owned, shared := listed(t, router, user, "/document/all")
+		if got := append(owned, shared...); len(got) != 1 || got[0] != active.ID.Hex() {
+			t.Errorf("default listing of %s = %v, want only the active document", user, got)
+		}
+		owned, shared = listed(t, router, user, "/document/all?archived=true")
+		if got := append(owned, shared...); len(got) != 1 || got[0] != id {
+			t.Errorf("archived listing of %s = %v, want only the archived document", user, got)
+		}
+	}
+
+	// Still readable, but not writable, by anyone
+	for _, user := range []string{owner, collaborator} {
+		if rec := as(router, user, http.MethodGet, "/document/id/"+id, ""); rec.Code != http.StatusOK {
+			t.Errorf("%s reading the archived document: status %d, want 200", user, rec.Code)
+		}
+		body := fmt.Sprintf(`{"baseVersion":%d,"slides":[{"id":"s-1","objects":[]}]}`, archived.Version)
+		if rec := as(router, user, http.MethodPut, "/document/"+id+"/content", body); rec.Code != http.StatusConflict || errorCode(rec) != apierror.CodeDocumentArchived {
+			t.Errorf("%s writing the archived document: status %d %s, want 409 %s", user, rec.Code, rec.Body, apierror.CodeDocumentArchived)
+		}
+	}
+
+	// Unarchived, it is back and writable
+	if rec := as(router, owner, http.MethodPost, "/document/"+id+"/unarchive", ""); rec.Code != http.StatusOK {
+		t.Fatalf("unarchiving: status %d %s", rec.Code, rec.Body)
+	}
+	if owned, _ := listed(t, router, owner, "/document/all"); len(owned) != 2 {
+		t.Errorf("default listing after unarchiving = %v, want both", owned)
+	}
+	body := fmt.Sprintf(`{"baseVersion":%d,"slides":[{"id":"s-1","objects":[]}]}`, archived.Version)
+	if rec := as(router, collaborator, http.MethodPut, "/document/"+id+"/content", body); rec.Code != http.StatusOK {
+		t.Errorf("writing once unarchived: status %d %s, want 200", rec.Code, rec.Body)
+	}
+
+	if rec := as(router, owner, http.MethodPost, "/document/"+primitive.NewObjectID().Hex()+"/archive", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("archiving a missing document: status %d, want 404", rec.Code)
+	}
+}
@@ -1,34 +1,150 @@
 package handler
 
 import (
+	"context"
+	"document-service/activity"
+	"document-service/authclient"
+	"document-service/config"
+	"document-service/export"
+	"document-service/middleware"
 	"document-service/repository"
 	"document-service/types"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"shared/apierror"
+	"shared/content"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 )
 
 // ===========================================
 
+// UserDirectory looks users up in the AuthService. *authclient.Client
+// implements it; lookups return nil or leave out users that do not exist, and
+// fail only when the AuthService cannot answer.
+type UserDirectory interface {
+	FindUserByID(ctx context.Context, id string) (*authclient.User, error)
+	FindUserByEmail(ctx context.Context, email string) (*authclient.User, error)
+	ResolveUsers(ctx context.Context, ids []string) (map[string]authclient.User, error)
+}
+
 type DocumentHandler struct {
 	DocumentRepository *repository.DocumentRepository
+	Folders            *repository.FolderRepository
+	History            *repository.HistoryRepository
+	Activity           *repository.ActivityRepository
+	Opens              *activity.Recorder
+	AccessRequests     *repository.AccessRequestRepository
+	Users              UserDirectory
 }
 
-// Helper to get authenticated UserID (assuming it's set in a middleware header)
+// Helper to get authenticated UserID (set by middleware.AuthContext)
 func getAuthUserID(c *gin.Context) (string, bool) {
-	// Retrieving directly from the raw request header
-	userId := c.Request.Header.Get("X-User-ID")
+	userId := c.GetString(middleware.UserIDKey)
 	if userId == "" {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+		apierror.Abort(c, http.StatusUnauthorized, apierror.CodeAuthRequired, "Authorization required")
 		return "", false
 	}
 	return userId, true
 }
 
+// ParseObjectIDParam returns the name path parameter, answering 400 itself
+// when it is not an ID. Malformed IDs are the caller's mistake, so they are
+// told apart from well-formed IDs that match nothing, which answer 404.
+func ParseObjectIDParam(c *gin.Context, name string) (string, bool) {
+	id := c.Param(name)
+	if !validID(c, name, id) {
+		return "", false
+	}
+	return id, true
+}
+
+// documentIDParam returns the :id path parameter, answering 400 itself when
+// it is not a document ID.
+func documentIDParam(c *gin.Context) (string, bool) {
+	return ParseObjectIDParam(c, "id")
+}
+
+// validID reports whether id, the value of field, is an ID, answering 400
+// itself when it is not.
+func validID(c *gin.Context, field string, id string) bool {
+	if err := types.ValidateObjectID(field, id); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidID, err.Error())
+		return false
+	}
+	return true
+}
+
 // ====================== Get all documents handler =======================================
 
+// parseListQuery reads ?limit=, ?sort=createdAt|updatedAt|title, and
+// ?order=asc|desc, plus the offsets of the owned and shared lists, which page
+// independently: ?ownedOffset= and ?sharedOffset=, both defaulting to ?offset=.
+// ?tag= keeps only documents carrying the tag. It writes a 400 itself when a
+// value is invalid.
+func parseListQuery(c *gin.Context) (repository.ListOptions, int64, int64, bool) {
+	opts := repository.ListOptions{Limit: types.DefaultPageSize, Sort: repository.SortCreatedAt}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 1 {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, "limit must be a positive integer")
+			return opts, 0, 0, false
+		}
+		opts.Limit = min(limit, types.MaxPageSize)
+	}
+
+	switch sort := c.DefaultQuery("sort", repository.SortCreatedAt); sort {
+	case repository.SortCreatedAt, repository.SortUpdatedAt, repository.SortTitle:
+		opts.Sort = sort
+	default:
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, "sort must be one of createdAt, updatedAt, title")
+		return opts, 0, 0, false
+	}
+
+	switch order := c.DefaultQuery("order", "asc"); order {
+	case "asc", "desc":
+		opts.Descending = order == "desc"
+	default:
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, "order must be asc or desc")
+		return opts, 0, 0, false
+	}
+
+	if value := c.Query("tag"); value != "" {
+		tag, err := types.NormalizeTag(value)
+		if err != nil {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, err.Error())
+			return opts, 0, 0, false
+		}
+		opts.Tag = tag
+	}
+
+	offsets := [2]int64{}
+	for i, name := range []string{"ownedOffset", "sharedOffset"} {
+		value := c.DefaultQuery(name, c.DefaultQuery("offset", "0"))
+		offset, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || offset < 0 {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, name+" must be a non-negative integer")
+			return opts, 0, 0, false
+		}
+		offsets[i] = offset
+	}
+
+	return opts, offsets[0], offsets[1], true
+}
+
 // GetAllDocuments returns a Gin HandlerFunc to retrieve all documents owned by or shared with the user.
+// Both lists are paged and ordered as described by parseListQuery. With
+// ?folderId= the owned list holds only the documents in that folder, or in
+// none for ?folderId=root; the shared list is not filed in folders. Archived
+// documents are left out of both lists unless ?archived=true, which lists only
+// them. ?favorites=true keeps only the documents the user starred. Shared
+// documents carry their owner's username.
 func (h DocumentHandler) GetAllDocuments(c *gin.Context) {
 	// The router (router.GET) already ensures r.Method is GET
 
@@ -38,26 +154,195 @@ func (h DocumentHandler) GetAllDocuments(c *gin.Context) {
 		return // Response already sent by helper
 	}
 
-	// Get all owned documents
-	ownedDocuments, err := h.DocumentRepository.FindOwnedDocuments(c, userId)
+	opts, ownedOffset, sharedOffset, ok := parseListQuery(c)
+	if !ok {
+		return
+	}
+
+	if folderId := c.Query("folderId"); folderId != "" && folderId != repository.RootFolder {
+		if !validID(c, "folderId", folderId) {
+			return
+		}
+		exists, err := h.Folders.FolderExists(c, folderId, userId)
+		if err != nil {
+			apierror.Internal(c, "Error retrieving folder", err)
+			return
+		}
+		if !exists {
+			apierror.Abort(c, http.StatusNotFound, apierror.CodeFolderNotFound, "Folder not found")
+			return
+		}
+	}
+	opts.FolderID = c.Query("folderId")
+
+	if opts.Archived, ok = boolQuery(c, "archived"); !ok {
+		return
+	}
+	if opts.FavoritesOnly, ok = boolQuery(c, "favorites"); !ok {
+		return
+	}
+
+	h.listDocuments(c, userId, opts, ownedOffset, sharedOffset)
+}
+
+// boolQuery reads the query parameter name as true or false, false when it is
+// absent. It writes a 400 itself when the value is neither.
+func boolQuery(c *gin.Context, name string) (bool, bool) {
+	switch c.DefaultQuery(name, "false") {
+	case "true":
+		return true, true
+	case "false":
+		return false, true
+	}
+	apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, name+" must be true or false")
+	return false, false
+}
+
+// SearchDocuments finds the documents owned by or shared with the user whose
+// title contains ?q=, ignoring case. Results are paged and ordered like
+// GetAllDocuments and come in the same shape.
+//
+// Route: GET /document/search
+func (h DocumentHandler) SearchDocuments(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	query := strings.TrimSpace(c.Query("q"))
+	if length := utf8.RuneCountInString(query); length < types.MinSearchLength || length > types.MaxSearchLength {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, fmt.Sprintf("q must be %d to %d characters long", types.MinSearchLength, types.MaxSearchLength))
+		return
+	}
+
+	opts, ownedOffset, sharedOffset, ok := parseListQuery(c)
+	if !ok {
+		return
+	}
+	opts.TitleContains = query
+
+	h.listDocuments(c, userId, opts, ownedOffset, sharedOffset)
+}
+
+// listDocuments responds with a page each of the owned and the shared
+// documents matching opts, and the user's folders.
+func (h DocumentHandler) listDocuments(c *gin.Context, userId string, opts repository.ListOptions, ownedOffset int64, sharedOffset int64) {
+	// Get a page of owned documents
+	ownedOpts := opts
+	ownedOpts.Offset = ownedOffset
+	ownedDocuments, ownedTotal, err := h.DocumentRepository.FindOwnedDocuments(c, userId, ownedOpts)
+	if err != nil {
+		apierror.Internal(c, "Error retrieving owned documents", err)
+		return
+	}
+
+	// Get a page of shared documents
+	sharedOpts := opts
+	sharedOpts.Offset = sharedOffset
+	sharedDocuments, sharedTotal, err := h.DocumentRepository.FindSharedDocuments(c, userId, sharedOpts)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving owned documents"})
+		apierror.Internal(c, "Error retrieving shared documents", err)
 		return
 	}
+	// Where the owner filed a document is their own business
+	for i := range sharedDocuments {
+		sharedDocuments[i].FolderID = ""
+	}
 
-	// Get all shared documents
-	sharedDocuments, err := h.DocumentRepository.FindSharedDocuments(c, userId)
+	folders, err := h.Folders.FindFolders(c, userId)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving shared documents"})
+		apierror.Internal(c, "Error retrieving folders", err)
+		return
+	}
+
+	owned := make([]types.DocumentDto, len(ownedDocuments))
+	for i, document := range ownedDocuments {
+		owned[i] = types.DocumentDto{Document: document, AccessLevel: repository.AccessOwner}
+	}
+	if !h.annotateDocuments(c, userId, owned, sharedDocuments) {
 		return
 	}
+	h.annotateOwners(c, sharedDocuments)
 
-	result := types.AllDocumentsDto{OwnedDocuments: ownedDocuments, SharedDocuments: sharedDocuments}
+	result := types.AllDocumentsDto{
+		OwnedDocuments:  owned,
+		SharedDocuments: sharedDocuments,
+		OwnedPage:       types.NewPageInfo(ownedTotal, opts.Limit, ownedOffset, len(ownedDocuments)),
+		SharedPage:      types.NewPageInfo(sharedTotal, opts.Limit, sharedOffset, len(sharedDocuments)),
+		Folders:         folders,
+	}
 
 	// Json response
 	c.JSON(http.StatusOK, result)
 }
 
+// annotateDocuments marks which of the listed documents the user starred and
+// when they last opened each, with one lookup each across every list. It
+// writes a 500 itself and returns false on failure.
+func (h DocumentHandler) annotateDocuments(c *gin.Context, userId string, lists ...[]types.DocumentDto) bool {
+	documentIds := []string{}
+	for _, documents := range lists {
+		for _, document := range documents {
+			documentIds = append(documentIds, document.ID.Hex())
+		}
+	}
+
+	favorites, err := h.DocumentRepository.FindFavorites(c, userId, documentIds)
+	if err != nil {
+		apierror.Internal(c, "Error retrieving favorites", err)
+		return false
+	}
+	lastOpened, err := h.Activity.FindLastOpened(c, userId, documentIds)
+	if err != nil {
+		apierror.Internal(c, "Error retrieving recent activity", err)
+		return false
+	}
+
+	for _, documents := range lists {
+		for i := range documents {
+			id := documents[i].ID.Hex()
+			documents[i].IsFavorite = favorites[id]
+			if openedAt, found := lastOpened[id]; found {
+				documents[i].LastOpenedAt = &openedAt
+			}
+		}
+	}
+	return true
+}
+
+// annotateOwners names the owner of each listed document the caller does not
+// own, looking every owner up once. Owners that cannot be looked up, because
+// their account is gone or the AuthService is unavailable, are named
+// types.UnknownUsername rather than failing the listing.
+func (h DocumentHandler) annotateOwners(c *gin.Context, documents []types.DocumentDto) {
+	ids := []string{}
+	seen := map[string]bool{}
+	for _, document := range documents {
+		if document.AccessLevel != repository.AccessOwner && !seen[document.OwnerID] {
+			seen[document.OwnerID] = true
+			ids = append(ids, document.OwnerID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	owners, err := h.Users.ResolveUsers(c, ids)
+	if err != nil {
+		fmt.Printf("[DocumentHandler][annotateOwners] Error resolving document owners: %v\n", err)
+	}
+
+	for i := range documents {
+		if documents[i].AccessLevel == repository.AccessOwner {
+			continue
+		}
+		documents[i].OwnerUsername = types.UnknownUsername
+		if owner, found := owners[documents[i].OwnerID]; found {
+			documents[i].OwnerUsername = owner.Username
+		}
+	}
+}
+
 // ================================ Create New Empty Document Handler ===========================
 
 // CreateNewDocument returns a Gin HandlerFunc to create a new document.
@@ -73,7 +358,7 @@ func (h DocumentHandler) CreateNewDocument(c *gin.Context) {
 	// Create document
 	createdDoc, err := h.DocumentRepository.CreateNewDocument(c, "Untitled", userId)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Error creating document"})
+		apierror.Internal(c, "Error creating document", err)
 		return
 	}
 
@@ -85,52 +370,402 @@ func (h DocumentHandler) CreateNewDocument(c *gin.Context) {
 // ================================= Share Document Handler ==============================
 
 // ShareDocument returns a Gin HandlerFunc to create a new sharing record.
+// Deprecated in favor of ShareDocumentByID; it takes the document ID from the
+// body.
+//
+// Route: POST /document/share
 func (h DocumentHandler) ShareDocument(c *gin.Context) {
-	// The router (router.POST) already ensures r.Method is POST
-
 	// Retrieve user data
 	userId, ok := getAuthUserID(c)
 	if !ok {
 		return
 	}
 
-	// Decode and bind data from request body
+	data, ok := bindShareData(c)
+	if !ok {
+		return
+	}
+	if !validID(c, "documentId", data.DocumentID) {
+		return
+	}
+
+	if !h.shareDocument(c, userId, data.DocumentID, data) {
+		return
+	}
+	c.String(http.StatusOK, "Success")
+}
+
+// ShareDocumentByID shares the document with a collaborator, named by user ID
+// or email, or changes the access type of an existing share. Only the owner
+// may share it.
+//
+// Route: POST /document/:id/share
+func (h DocumentHandler) ShareDocumentByID(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+
+	data, ok := bindShareData(c)
+	if !ok {
+		return
+	}
+
+	if !h.shareDocument(c, userId, documentId, data) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// bindShareData decodes a share request, writing the error response itself
+// when it is unacceptable.
+func bindShareData(c *gin.Context) (types.ShareDocumentPostData, bool) {
 	var data types.ShareDocumentPostData
 	// Gin's ShouldBindJSON handles decoding and error check
+	err := c.ShouldBindJSON(&data)
+	if errors.Is(err, types.ErrInvalidAccessType) || (err == nil && data.AccessType == "") {
+		apierror.Abort(c, http.StatusUnprocessableEntity, apierror.CodeValidationFailed, types.ErrInvalidAccessType.Error())
+		return data, false
+	}
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid data format or missing fields")
+		return data, false
+	}
+	return data, true
+}
+
+// shareDocument shares the document as the share request asks, provided
+// userId owns it. It writes the error response itself and reports whether the
+// document was shared; the caller writes the success response.
+func (h DocumentHandler) shareDocument(c *gin.Context, userId string, documentId string, data types.ShareDocumentPostData) bool {
+	if !h.checkShareOwner(c, userId, documentId) {
+		return false
+	}
+
+	collaboratorUserId, ok := h.resolveCollaborator(c, data)
+	if !ok {
+		return false
+	}
+	if collaboratorUserId == userId {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, "You cannot share a document with yourself")
+		return false
+	}
+
+	// Create sharing record
+	if _, err := h.DocumentRepository.CreateCollaborationRecord(c, collaboratorUserId, documentId, data.AccessType); err != nil {
+		apierror.Internal(c, "Error creating a collaboration record", err)
+		return false
+	}
+	return true
+}
+
+// resolveCollaborator returns the user ID of the collaborator a share request
+// names, by user ID, by email, or by both when they are the same user. It
+// writes the error response itself and returns false when there is no such
+// user, or when the AuthService cannot be asked in time. An unknown email is
+// answered with EMAIL_NOT_REGISTERED, so clients can offer an invitation
+// instead.
+func (h DocumentHandler) resolveCollaborator(c *gin.Context, data types.ShareDocumentPostData) (string, bool) {
+	if data.CollaboratorUserID == "" && data.CollaboratorEmail == "" {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, "collaboratorUserId or collaboratorEmail is required")
+		return "", false
+	}
+	if data.CollaboratorUserID != "" && !validID(c, "collaboratorUserId", data.CollaboratorUserID) {
+		return "", false
+	}
+
+	if data.CollaboratorEmail == "" {
+		// Make sure the user ID belongs to a user
+		collaborator, err := h.Users.FindUserByID(c, data.CollaboratorUserID)
+		if err != nil {
+			fmt.Printf("[DocumentHandler][ShareDocument] Error verifying collaborator: %v\n", err)
+			apierror.Abort(c, http.StatusServiceUnavailable, apierror.CodeUserLookupUnavailable, "Could not look up the collaborator, try again later")
+			return "", false
+		}
+		if collaborator == nil {
+			apierror.Abort(c, http.StatusNotFound, apierror.CodeUserNotFound, "collaborator not found")
+			return "", false
+		}
+		return collaborator.ID, true
+	}
+
+	collaborator, err := h.Users.FindUserByEmail(c, data.CollaboratorEmail)
+	if err != nil {
+		fmt.Printf("[DocumentHandler][ShareDocument] Error resolving collaborator: %v\n", err)
+		apierror.Abort(c, http.StatusServiceUnavailable, apierror.CodeUserLookupUnavailable, "Could not look up the collaborator, try again later")
+		return "", false
+	}
+	if data.CollaboratorUserID != "" && (collaborator == nil || collaborator.ID != data.CollaboratorUserID) {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, "collaboratorUserId and collaboratorEmail name different users")
+		return "", false
+	}
+	if collaborator == nil {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeEmailNotRegistered, "No user is registered with this email")
+		return "", false
+	}
+	return collaborator.ID, true
+}
+
+// checkShareOwner makes sure userId owns the document they are sharing. It
+// writes the error response itself and returns false otherwise.
+func (h DocumentHandler) checkShareOwner(c *gin.Context, userId string, documentId string) bool {
+	// Check if the user actually owns the document
+	isUserOwner, err := h.DocumentRepository.IsDocumentOwnedByUser(c, userId, documentId)
+	if errors.Is(err, repository.ErrDocumentNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeDocumentNotFound, "Document not found")
+		return false
+	}
+	if err != nil {
+		apierror.Internal(c, "Error verifying ownership of the document", err)
+		return false
+	}
+
+	if !isUserOwner {
+		apierror.Abort(c, http.StatusForbidden, apierror.CodeForbidden, "Only the owner can share documents with other users")
+		return false
+	}
+	return true
+}
+
+// ShareDocumentBulk shares the document with several collaborators at once,
+// each named by user ID or email, and answers with the outcome of every entry
+// in request order. Entries fail on their own: only a malformed request or a
+// caller who does not own the document fails the whole request. A
+// collaborator named more than once is shared with by their first entry.
+//
+// Route: POST /document/:id/share/bulk
+func (h DocumentHandler) ShareDocumentBulk(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+
+	var data types.BulkShareData
 	if err := c.ShouldBindJSON(&data); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid data format or missing fields"})
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid data format or missing fields")
+		return
+	}
+	if len(data.Shares) == 0 || len(data.Shares) > config.BulkConfig.MaxShares {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, fmt.Sprintf("shares must name 1 to %d collaborators", config.BulkConfig.MaxShares))
 		return
 	}
 
-	// Check if the user actually owns the document
-	isUserOwner, err := h.DocumentRepository.IsDocumentOwnedByUser(c, userId, data.DocumentID)
+	if !h.checkShareOwner(c, userId, documentId) {
+		return
+	}
+
+	results := make([]types.BulkShareResult, len(data.Shares))
+	accessTypes := make([]types.AccessType, len(data.Shares))
+	for i, entry := range data.Shares {
+		results[i] = types.BulkShareResult{CollaboratorUserID: entry.CollaboratorUserID, CollaboratorEmail: entry.CollaboratorEmail}
+		accessType, err := types.ParseAccessType(entry.AccessType)
+		if err != nil {
+			results[i].Result = types.ShareInvalidAccessType
+			continue
+		}
+		accessTypes[i] = accessType
+		if entry.CollaboratorUserID == "" && entry.CollaboratorEmail == "" {
+			results[i].Result = types.ShareInvalidUser
+		}
+	}
+
+	h.resolveBulkCollaborators(c, data.Shares, results)
+
+	// One share per collaborator, however many entries name them
+	grants := []repository.ShareGrant{}
+	granted := map[string]bool{}
+	for i := range results {
+		if results[i].Result != "" {
+			continue
+		}
+		collaboratorUserId := results[i].CollaboratorUserID
+		switch {
+		case collaboratorUserId == userId:
+			results[i].Result = types.ShareSelf
+		case granted[collaboratorUserId]:
+			results[i].Result = types.ShareDuplicate
+		default:
+			granted[collaboratorUserId] = true
+			grants = append(grants, repository.ShareGrant{UserID: collaboratorUserId, AccessType: accessTypes[i]})
+		}
+	}
+
+	if len(grants) > 0 {
+		outcomes, err := h.DocumentRepository.CreateCollaborationRecords(c, documentId, grants)
+		if err != nil {
+			apierror.Internal(c, "Error creating collaboration records", err)
+			return
+		}
+		for i := range results {
+			if results[i].Result == "" {
+				results[i].Result = outcomes[results[i].CollaboratorUserID]
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, types.BulkShareResponse{Results: results})
+}
+
+// resolveBulkCollaborators fills in the user ID of every bulk share entry
+// still without a result, or marks it invalid_user when nobody matches.
+// Entries that cannot be checked because the AuthService is unavailable are
+// marked lookup_failed.
+func (h DocumentHandler) resolveBulkCollaborators(c *gin.Context, entries []types.BulkShareEntry, results []types.BulkShareResult) {
+	ids := []string{}
+	for i, entry := range entries {
+		if results[i].Result == "" && entry.CollaboratorUserID != "" {
+			ids = append(ids, entry.CollaboratorUserID)
+		}
+	}
+
+	var users map[string]authclient.User
+	var lookupErr error
+	if len(ids) > 0 {
+		users, lookupErr = h.Users.ResolveUsers(c, ids)
+		if lookupErr != nil {
+			fmt.Printf("[DocumentHandler][ShareDocumentBulk] Error verifying collaborators: %v\n", lookupErr)
+		}
+	}
+
+	for i, entry := range entries {
+		if results[i].Result != "" {
+			continue
+		}
+
+		if entry.CollaboratorUserID != "" {
+			switch _, found := users[entry.CollaboratorUserID]; {
+			case lookupErr != nil:
+				results[i].Result = types.ShareLookupFailed
+			case !found:
+				results[i].Result = types.ShareInvalidUser
+			}
+			continue
+		}
+
+		collaborator, err := h.Users.FindUserByEmail(c, entry.CollaboratorEmail)
+		switch {
+		case err != nil:
+			fmt.Printf("[DocumentHandler][ShareDocumentBulk] Error resolving collaborator: %v\n", err)
+			results[i].Result = types.ShareLookupFailed
+		case collaborator == nil:
+			results[i].Result = types.ShareInvalidUser
+		default:
+			results[i].CollaboratorUserID = collaborator.ID
+		}
+	}
+}
+
+// ================================= Unshare Document Handler ==============================
+
+// UnshareDocument revokes a collaborator's access to a document. Only the
+// owner may revoke access. Revoking access the user does not have succeeds,
+// so retries are safe.
+//
+// Route: DELETE /document/:id/share/:userId
+// Route: DELETE /document/:id/collaborators/:userId
+func (h DocumentHandler) UnshareDocument(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+	collaboratorId, ok := ParseObjectIDParam(c, "userId")
+	if !ok {
+		return
+	}
+	if _, ok := h.authorizeDocument(c, userId, documentId, repository.AccessOwner); !ok {
+		return
+	}
+
+	revoked, err := h.DocumentRepository.DeleteCollaborationRecord(c, documentId, collaboratorId)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Error verifying ownership of the document"})
+		apierror.Internal(c, "Error revoking access to the document", err)
 		return
 	}
+	if revoked {
+		fmt.Printf("[DocumentHandler][UnshareDocument] Revoked access of user %s to document %s\n", collaboratorId, documentId)
+	}
 
-	if !isUserOwner {
-		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Only the owner can share documents with other users"})
+	c.Status(http.StatusNoContent)
+}
+
+// ================================= List Collaborators Handler ==============================
+
+// GetCollaborators lists who a document is shared with, with their usernames.
+// The owner and collaborators may list them.
+//
+// Route: GET /document/:id/collaborators
+func (h DocumentHandler) GetCollaborators(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
 		return
 	}
 
-	// Create sharing record
-	// NOTE: Using the context provided by Gin (c.Request.Context() is implicit in Gin handler functions)
-	_, err = h.DocumentRepository.CreateCollaborationRecord(c, data.CollaboratorUserID, data.DocumentID, data.AccessType)
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := h.authorizeDocument(c, userId, documentId, repository.AccessRead); !ok {
+		return
+	}
+
+	records, err := h.DocumentRepository.FindCollaboratorsByDocumentID(c, documentId)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Error creating a collaboration record"})
+		apierror.Internal(c, "Error retrieving collaborators", err)
 		return
 	}
 
-	c.String(http.StatusOK, "Success")
+	ids := make([]string, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.UserID)
+	}
+	users, err := h.Users.ResolveUsers(c, ids)
+	if err != nil {
+		fmt.Printf("[DocumentHandler][GetCollaborators] Error resolving collaborators: %v\n", err)
+		apierror.Abort(c, http.StatusServiceUnavailable, apierror.CodeUserLookupUnavailable, "Could not look up the collaborators, try again later")
+		return
+	}
+
+	collaborators := make([]types.CollaboratorDto, 0, len(records))
+	for _, record := range records {
+		collaborator := types.CollaboratorDto{
+			UserID:     record.UserID,
+			AccessType: record.AccessType,
+			SharedAt:   record.SharedAt,
+		}
+		if user, found := users[record.UserID]; found {
+			collaborator.Username = user.Username
+		} else {
+			collaborator.Username, collaborator.Unknown = types.UnknownUsername, true
+		}
+		collaborators = append(collaborators, collaborator)
+	}
+
+	c.JSON(http.StatusOK, collaborators)
 }
 
 // ================================= Delete Document Handler ==============================
 
-// DeleteDocument returns a Gin HandlerFunc to delete a document.
+// DeleteDocument returns a Gin HandlerFunc to move a document to the trash.
+// Deprecated in favor of DeleteDocumentByID; it takes the document ID from the
+// body.
+//
+// Route: POST /document/delete
 func (h DocumentHandler) DeleteDocument(c *gin.Context) {
-	// The router (router.POST) already ensures r.Method is POST
-
 	// Retrieve user data
 	userId, ok := getAuthUserID(c)
 	if !ok {
@@ -140,64 +775,662 @@ func (h DocumentHandler) DeleteDocument(c *gin.Context) {
 	// Decode and bind data from request body
 	var data types.DeleteDocumentPostData
 	if err := c.ShouldBindJSON(&data); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid data format or missing fields"})
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid data format or missing fields")
 		return
 	}
 
-	// Check if the user actually owns the document
-	isUserOwner, err := h.DocumentRepository.IsDocumentOwnedByUser(c, userId, data.DocumentID)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Error verifying ownership of the document"})
+	if !validID(c, "documentId", data.DocumentID) {
 		return
 	}
 
-	if !isUserOwner {
-		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Only the owner can delete their documents"})
+	if !h.deleteDocument(c, userId, data.DocumentID) {
 		return
 	}
+	c.String(http.StatusOK, "Success")
+}
 
-	// Delete document
-	err = h.DocumentRepository.DeleteDocument(c, data.DocumentID)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Error deleting document: %s", err.Error())})
+// DeleteDocumentByID moves a document to the trash. It can be restored until
+// it is purged, by the owner or once it has been in the trash for the
+// retention period. Only the owner may delete it.
+//
+// Route: DELETE /document/:id
+func (h DocumentHandler) DeleteDocumentByID(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
 		return
 	}
 
-	c.String(http.StatusOK, "Success")
-}
-
-// Route: GET /document/:id
-func (h DocumentHandler) GetDocumentByID(c *gin.Context) {
-	// 1. Get Path Parameter
-	docID := c.Param("id")
-	if docID == "" {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Document ID is required in the path"})
+	documentId, ok := documentIDParam(c)
+	if !ok {
 		return
 	}
 
-	// 2. Auth Check (optional, but good practice before database access)
-	// You should ideally check if the authenticated user has access to this document.
-	// userID, ok := getAuthUserID(c)
-	// if !ok {
-	// 	return
-	// }
+	if !h.deleteDocument(c, userId, documentId) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
 
-	// 3. Call Repository to find the document
-	document, err := h.DocumentRepository.FindDocumentByID(c.Request.Context(), docID)
+// deleteDocument moves the document to the trash, provided userId owns it. It
+// writes the error response itself and reports whether the document was
+// trashed; the caller writes the success response.
+func (h DocumentHandler) deleteDocument(c *gin.Context, userId string, documentId string) bool {
+	// Check if the user actually owns the document
+	isUserOwner, err := h.DocumentRepository.IsDocumentOwnedByUser(c, userId, documentId)
+	if errors.Is(err, repository.ErrDocumentNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeDocumentNotFound, "Document not found")
+		return false
+	}
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving document"})
-		return
+		apierror.Internal(c, "Error verifying ownership of the document", err)
+		return false
 	}
 
-	// 4. Handle Not Found (Repository returns nil, nil for ErrNoDocuments)
-	if document == nil {
-		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Document not found"})
-		return
+	if !isUserOwner {
+		apierror.Abort(c, http.StatusForbidden, apierror.CodeForbidden, "Only the owner can delete their documents")
+		return false
 	}
 
-	// 5. Authorization Check (if not owner, check sharing)
-	// Add logic here to check if userID is the owner or in shared list
+	// Move document to the trash
+	err = h.DocumentRepository.TrashDocument(c, documentId, userId)
+	if errors.Is(err, repository.ErrDocumentNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeDocumentNotFound, "Document not found")
+		return false
+	}
+	if err != nil {
+		apierror.Internal(c, "Error deleting document", err)
+		return false
+	}
+	return true
+}
+
+// ================================= Transfer Ownership Handler ==============================
+
+// TransferOwnership hands a document over to another user. Only the owner may
+// transfer it; depending on config.AccessConfig.KeepAccessOnTransfer they
+// stay on it as a write collaborator.
+//
+// Route: POST /document/:id/transfer
+func (h DocumentHandler) TransferOwnership(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	var data types.TransferOwnershipData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid data format or missing fields")
+		return
+	}
+	if !validID(c, "newOwnerUserId", data.NewOwnerUserID) {
+		return
+	}
+	if data.NewOwnerUserID == userId {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, "You already own this document")
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := h.authorizeDocument(c, userId, documentId, repository.AccessOwner); !ok {
+		return
+	}
+
+	newOwner, err := h.Users.FindUserByID(c, data.NewOwnerUserID)
+	if err != nil {
+		fmt.Printf("[DocumentHandler][TransferOwnership] Error verifying new owner: %v\n", err)
+		apierror.Abort(c, http.StatusServiceUnavailable, apierror.CodeUserLookupUnavailable, "Could not look up the new owner, try again later")
+		return
+	}
+	if newOwner == nil {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeUserNotFound, "new owner not found")
+		return
+	}
+
+	document, err := h.DocumentRepository.TransferOwnership(c, documentId, userId, newOwner.ID, config.AccessConfig.KeepAccessOnTransfer)
+	if errors.Is(err, repository.ErrDocumentNotFound) {
+		// Transferred or deleted since the access check
+		apierror.Abort(c, http.StatusConflict, apierror.CodeOwnershipChanged, "The document changed hands or was deleted, reload and try again")
+		return
+	}
+	if err != nil {
+		apierror.Internal(c, "Error transferring document", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, types.NewDocumentMetadataDto(*document))
+}
+
+// ================================= Bulk Delete Handler ==============================
+
+// BulkDeleteDocuments moves several of the user's documents to the trash, like
+// DeleteDocument. Each ID succeeds or fails on its own: the response maps
+// every ID to its outcome, with 207 Multi-Status unless all were deleted.
+//
+// Route: POST /document/bulk-delete
+func (h DocumentHandler) BulkDeleteDocuments(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	var data types.BulkDeleteData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid data format or missing fields")
+		return
+	}
+	if len(data.DocumentIDs) == 0 || len(data.DocumentIDs) > config.BulkConfig.MaxDocuments {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, fmt.Sprintf("documentIds must list 1 to %d documents", config.BulkConfig.MaxDocuments))
+		return
+	}
+
+	results, err := h.DocumentRepository.TrashDocuments(c, data.DocumentIDs, userId)
+	if err != nil {
+		apierror.Internal(c, "Error deleting documents", err)
+		return
+	}
+
+	status := http.StatusOK
+	for _, result := range results {
+		if result != repository.BulkDeleted {
+			status = http.StatusMultiStatus
+			break
+		}
+	}
+	c.JSON(status, types.BulkDeleteResponse{Results: results})
+}
+
+// ================================= Trash Handlers ==============================
+
+// GetTrash lists the documents the user moved to the trash, paged and ordered
+// like GetAllDocuments with ?limit=, ?offset=, ?sort=, and ?order=.
+//
+// Route: GET /document/trash
+func (h DocumentHandler) GetTrash(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	opts, offset, _, ok := parseListQuery(c)
+	if !ok {
+		return
+	}
+	opts.Offset = offset
+
+	documents, total, err := h.DocumentRepository.FindTrashedDocuments(c, userId, opts)
+	if err != nil {
+		apierror.Internal(c, "Error retrieving trashed documents", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, types.TrashDto{
+		Documents: documents,
+		Page:      types.NewPageInfo(total, opts.Limit, offset, len(documents)),
+	})
+}
+
+// RestoreDocument takes one of the user's documents out of the trash.
+//
+// Route: POST /document/:id/restore
+func (h DocumentHandler) RestoreDocument(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+
+	document, err := h.DocumentRepository.RestoreDocument(c, documentId, userId)
+	if errors.Is(err, repository.ErrDocumentNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeDocumentNotFound, "Document not found in the trash")
+		return
+	}
+	if err != nil {
+		apierror.Internal(c, "Error restoring document", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, types.NewDocumentMetadataDto(*document))
+}
+
+// PurgeDocument permanently deletes one of the user's documents, whether it is
+// in the trash or not. Only the owner may purge it.
+//
+// Route: DELETE /document/:id/purge
+func (h DocumentHandler) PurgeDocument(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+
+	err := h.DocumentRepository.PurgeDocument(c, documentId, userId)
+	if errors.Is(err, repository.ErrDocumentNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeDocumentNotFound, "Document not found")
+		return
+	}
+	if err != nil {
+		apierror.Internal(c, "Error deleting document", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ================================= Rename Document Handler ==============================
+
+// RenameDocument changes a document's title. The owner and collaborators with
+// write access may rename it.
+func (h DocumentHandler) RenameDocument(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	var data types.RenameDocumentData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid data format or missing fields")
+		return
+	}
+	data.Normalize()
+	if err := data.Validate(); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, err.Error())
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+	level, ok := h.authorizeDocument(c, userId, documentId, repository.AccessWrite)
+	if !ok {
+		return
+	}
+
+	document, err := h.DocumentRepository.UpdateTitle(c, documentId, data.Title, userId)
+	if errors.Is(err, repository.ErrDocumentNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeDocumentNotFound, "Document not found")
+		return
+	}
+	if err != nil {
+		apierror.Internal(c, "Error renaming document", err)
+		return
+	}
+	if level != repository.AccessOwner {
+		document.FolderID = ""
+	}
+
+	c.JSON(http.StatusOK, types.NewDocumentMetadataDto(*document))
+}
+
+// ================================= Update Content Handler ==============================
+
+// UpdateDocumentContent replaces a document's slides, for clients that are not
+// connected to the live editing service. The owner and collaborators with
+// write access may update it.
+//
+// The write must name the version it is based on, in an If-Match header with
+// the ETag of GetDocumentByID or in baseVersion; if the content changed since,
+// it answers 409 with the current version. The write goes straight to the
+// database, and a document.content_replaced event tells open editors to reload. Live updates still in flight that refer
+// to slides or objects the new content no longer has match nothing and are
+// dropped.
+func (h DocumentHandler) UpdateDocumentContent(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := h.authorizeDocument(c, userId, documentId, repository.AccessWrite); !ok {
+		return
+	}
+
+	// Refuse oversized content before reading it
+	if c.Request.ContentLength > config.ContentConfig.MaxBytes {
+		apierror.Abort(c, http.StatusRequestEntityTooLarge, apierror.CodeContentTooLarge, content.TooLargeMessage(config.ContentConfig.MaxBytes))
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, config.ContentConfig.MaxBytes)
+
+	var data types.UpdateContentData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apierror.Abort(c, http.StatusRequestEntityTooLarge, apierror.CodeContentTooLarge, content.TooLargeMessage(config.ContentConfig.MaxBytes))
+			return
+		}
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid data format or missing fields")
+		return
+	}
+	if err := data.Validate(); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, err.Error())
+		return
+	}
+
+	// The write must name the version it is based on
+	var baseVersion int64
+	switch ifMatch := c.GetHeader("If-Match"); {
+	case ifMatch != "":
+		version, err := types.ParseETag(ifMatch)
+		if err != nil {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, err.Error())
+			return
+		}
+		baseVersion = version
+	case data.BaseVersion != nil:
+		baseVersion = *data.BaseVersion
+	default:
+		apierror.Abort(c, http.StatusPreconditionRequired, apierror.CodeVersionRequired, "An If-Match header or baseVersion is required")
+		return
+	}
+
+	document, err := h.DocumentRepository.UpdateContent(c, documentId, data.Slides, userId, baseVersion)
+	if errors.Is(err, repository.ErrDocumentNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeDocumentNotFound, "Document not found")
+		return
+	}
+	if errors.Is(err, repository.ErrDocumentArchived) {
+		apierror.Abort(c, http.StatusConflict, apierror.CodeDocumentArchived, "Document is archived - Unarchive it to edit.")
+		return
+	}
+	if errors.Is(err, repository.ErrDocumentLocked) {
+		apierror.Abort(c, http.StatusLocked, apierror.CodeDocumentLocked, "Document is locked by its owner")
+		return
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		c.Header("ETag", types.ETag(document.Version))
+		c.AbortWithStatusJSON(http.StatusConflict, types.VersionConflictResponse{
+			Error:   apierror.New(c, apierror.CodeVersionConflict, "The document changed since the version this content is based on"),
+			Version: document.Version,
+		})
+		return
+	}
+	if err != nil {
+		apierror.Internal(c, "Error updating document content", err)
+		return
+	}
+
+	c.Header("ETag", types.ETag(document.Version))
+	c.JSON(http.StatusOK, types.ContentUpdatedResponse{ID: documentId, UpdatedAt: document.UpdatedAt, Version: document.Version})
+}
+
+// ================================= Export Handler ==============================
+
+// ExportDocument downloads a document in the ?format= given, one of the
+// formats of the export package; JSON by default. The owner and
+// collaborators may export it.
+//
+// Route: GET /document/:id/export
+func (h DocumentHandler) ExportDocument(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	format, ok := export.Lookup(c.DefaultQuery("format", "json"))
+	if !ok {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, "format must be one of "+strings.Join(export.Names(), ", "))
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := h.authorizeDocument(c, userId, documentId, repository.AccessRead); !ok {
+		return
+	}
+
+	document, err := h.DocumentRepository.FindDocumentByID(c, documentId)
+	if err != nil {
+		apierror.Internal(c, "Error retrieving document", err)
+		return
+	}
+	if document == nil {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeDocumentNotFound, "Document not found")
+		return
+	}
+
+	c.Header("Content-Type", format.ContentType)
+	c.Header("Content-Disposition", format.ContentDisposition(document.Title))
+	c.Status(http.StatusOK)
+
+	// The status is sent already; a failure can only cut the download short
+	if err := format.Write(c.Writer, *document); err != nil {
+		fmt.Printf("[DocumentHandler][ExportDocument] Error writing export of %s: %v\n", documentId, err)
+	}
+}
+
+// ================================= Import Handler ==============================
+
+// ImportDocument creates a document owned by the user from a document in the
+// JSON export schema, sent as the request body or as the "file" field of a
+// multipart form. Content that is not a valid export is answered with 422 and
+// the JSON Pointer of what failed.
+//
+// Route: POST /document/import
+func (h DocumentHandler) ImportDocument(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	tooLarge := func() {
+		apierror.Abort(c, http.StatusRequestEntityTooLarge, apierror.CodeContentTooLarge, content.TooLargeMessage(config.ContentConfig.MaxBytes))
+	}
+	if c.Request.ContentLength > config.ContentConfig.MaxBytes {
+		tooLarge()
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, config.ContentConfig.MaxBytes)
+
+	body := io.Reader(c.Request.Body)
+	if c.ContentType() == "multipart/form-data" {
+		header, err := c.FormFile("file")
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			tooLarge()
+			return
+		}
+		if err != nil {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "A file field with the document is required")
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Could not read the uploaded file")
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	imported, err := export.Parse(body)
+	var parseErr *export.ParseError
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		tooLarge()
+		return
+	case errors.As(err, &parseErr):
+		detail := apierror.New(c, apierror.CodeValidationFailed, parseErr.Message)
+		detail.Pointer = parseErr.Pointer
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, apierror.Response{Error: detail})
+		return
+	case err != nil:
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Could not read the document")
+		return
+	}
+
+	title := types.RenameDocumentData{Title: imported.Title}
+	title.Normalize()
+	if err := title.Validate(); err != nil {
+		detail := apierror.New(c, apierror.CodeValidationFailed, err.Error())
+		detail.Pointer = "/title"
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, apierror.Response{Error: detail})
+		return
+	}
+
+	document, err := h.DocumentRepository.CreateDocumentWithContent(c, title.Title, userId, imported.Slides)
+	if err != nil {
+		apierror.Internal(c, "Error creating document", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, types.CreatedResponse{ID: document.ID.Hex()})
+}
+
+// authorizeDocument checks that userId has at least the required access
+// level on the document and returns the level they have. It writes the error
+// response itself otherwise: 400 for a malformed ID, 404 for a missing
+// document, and 403 or, when forbidden documents are hidden, 404 for one the
+// user may not access.
+func (h DocumentHandler) authorizeDocument(c *gin.Context, userId string, documentId string, required string) (string, bool) {
+	level, err := h.DocumentRepository.GetAccessLevel(c, userId, documentId)
+	if errors.Is(err, repository.ErrInvalidID) {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidID, "Invalid document ID")
+		return "", false
+	}
+	if errors.Is(err, repository.ErrDocumentNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeDocumentNotFound, "Document not found")
+		return "", false
+	}
+	if err != nil {
+		apierror.Internal(c, "Error verifying access to the document", err)
+		return "", false
+	}
+
+	if !repository.HasAccess(level, required) {
+		if level == repository.AccessNone && config.AccessConfig.HideForbidden {
+			apierror.Abort(c, http.StatusNotFound, apierror.CodeDocumentNotFound, "Document not found")
+			return "", false
+		}
+		apierror.Abort(c, http.StatusForbidden, apierror.CodeForbidden, "You do not have access to this document")
+		return "", false
+	}
+	return level, true
+}
+
+// Route: GET /document/id/:id
+func (h DocumentHandler) GetDocumentByID(c *gin.Context) {
+	// 1. Get Path Parameter
+	docID, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+
+	// 2. Only the owner and collaborators may read the document
+	userID, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+	level, ok := h.authorizeDocument(c, userID, docID, repository.AccessRead)
+	if !ok {
+		return
+	}
+
+	// 3. Call Repository to find the document
+	document, err := h.DocumentRepository.FindDocumentByID(c.Request.Context(), docID)
+	if err != nil {
+		apierror.Internal(c, "Error retrieving document", err)
+		return
+	}
+
+	// 4. Handle Not Found (deleted since the access check)
+	if document == nil {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeDocumentNotFound, "Document not found")
+		return
+	}
+	if level != repository.AccessOwner {
+		document.FolderID = ""
+	}
+	favorites, err := h.DocumentRepository.FindFavorites(c, userID, []string{docID})
+	if err != nil {
+		apierror.Internal(c, "Error retrieving favorites", err)
+		return
+	}
+
+	// 5. Remember the open for the recent documents, without waiting on it
+	h.Opens.Record(c, userID, docID)
+
+	// 6. Return Document with the caller's access level, tagged with its
+	// version for conditional writes
+	c.Header("ETag", types.ETag(document.Version))
+	c.JSON(http.StatusOK, types.DocumentDto{Document: *document, AccessLevel: level, IsFavorite: favorites[docID]})
+}
+
+// GetSnapshot returns the content of a document, for UpdatesService to send
+// a client joining its live session. Unlike GetDocumentByID it does not count
+// as the user opening the document: UpdatesService records that itself.
+//
+// Route: GET /document/:id/snapshot
+func (h DocumentHandler) GetSnapshot(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := h.authorizeDocument(c, userId, documentId, repository.AccessRead); !ok {
+		return
+	}
+
+	document, err := h.DocumentRepository.FindDocumentByID(c.Request.Context(), documentId)
+	if err != nil {
+		apierror.Internal(c, "Error retrieving document", err)
+		return
+	}
+	// Deleted since the access check
+	if document == nil {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeDocumentNotFound, "Document not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, types.SnapshotDto{
+		DocumentID: documentId,
+		Version:    document.Version,
+		Slides:     document.Slides,
+		Seq:        document.Seq,
+		Applied:    document.Applied,
+	})
+}
+
+// GetAccessLevel tells the user their access to a document, without loading
+// it: "owner", "write", or "read". UpdatesService asks before admitting them
+// to the document's live session.
+//
+// Route: GET /document/:id/access
+func (h DocumentHandler) GetAccessLevel(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+	level, ok := h.authorizeDocument(c, userId, documentId, repository.AccessRead)
+	if !ok {
+		return
+	}
 
-	// 6. Return Document
-	c.JSON(http.StatusOK, document)
+	c.JSON(http.StatusOK, types.AccessLevelDto{DocumentID: documentId, AccessLevel: level})
 }
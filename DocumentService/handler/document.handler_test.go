@@ -0,0 +1,914 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"document-service/activity"
+	"document-service/authclient"
+	"document-service/config"
+	"document-service/repository"
+	"document-service/types"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"shared/apierror"
+	"shared/authmw"
+	"shared/migrate"
+	"shared/model"
+	"shared/tenant"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const testTenant = "acme"
+
+// fakeDirectory knows the users it was given.
+type fakeDirectory map[string]authclient.User
+
+func (d fakeDirectory) FindUserByID(ctx context.Context, id string) (*authclient.User, error) {
+	if user, ok := d[id]; ok {
+		return &user, nil
+	}
+	return nil, nil
+}
+
+func (d fakeDirectory) FindUserByEmail(ctx context.Context, email string) (*authclient.User, error) {
+	for _, user := range d {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, nil
+}
+
+func (d fakeDirectory) ResolveUsers(ctx context.Context, ids []string) (map[string]authclient.User, error) {
+	users := make(map[string]authclient.User)
+	for _, id := range ids {
+		if user, ok := d[id]; ok {
+			users[id] = user
+		}
+	}
+	return users, nil
+}
+
+// newUserID returns the ID of a user of the directory.
+func (d fakeDirectory) newUserID(name string) string {
+	id := primitive.NewObjectID().Hex()
+	d[id] = authclient.User{ID: id, Username: name, Email: name + "@example.com"}
+	return id
+}
+
+// testHandler returns a handler over an empty database on the server at
+// MONGO_TEST_URI, with its indexes built, dropped when the test ends. Tests
+// needing it are skipped without one.
+func testHandler(t *testing.T, users fakeDirectory) DocumentHandler {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := client.Database(fmt.Sprintf("document_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+	if _, err := migrate.New(db).Apply(ctx, false); err != nil {
+		t.Fatalf("building the indexes: %v", err)
+	}
+
+	outbox := repository.NewOutboxRepository(client, db.Name(), model.OutboxCollection, model.OutboxParkedCollection, "document-events")
+	documents := repository.NewDocumentRepository(client, db.Name(), model.DocumentCollection, model.SharedDocRecordCollection, model.FavoriteCollection, outbox)
+	return DocumentHandler{
+		DocumentRepository: documents,
+		Folders:            repository.NewFolderRepository(client, db.Name(), model.FolderCollection, model.DocumentCollection),
+		Activity:           repository.NewActivityRepository(client, db.Name(), model.ActivityCollection),
+		Opens:              activity.NewRecorder(repository.NewActivityRepository(client, db.Name(), model.ActivityCollection), 16),
+		Users:              users,
+	}
+}
+
+// tenantContext is the context requests of the test tenant run in.
+func tenantContext() context.Context {
+	return tenant.WithID(context.Background(), testTenant)
+}
+
+// documentRouter serves the handler's routes to the users named in the
+// X-User-ID header, as behind the gateway.
+func documentRouter(h DocumentHandler) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.ContextWithFallback = true
+	group := router.Group("/document", authmw.Middleware(authmw.GatewayHeaders{}))
+	group.GET("/id/:id", h.GetDocumentByID)
+	group.GET("/:id/snapshot", h.GetSnapshot)
+	group.POST("/:id/share", h.ShareDocumentByID)
+	group.POST("/:id/transfer", h.TransferOwnership)
+	group.PUT("/:id/content", h.UpdateDocumentContent)
+	group.GET("/:id/export", h.ExportDocument)
+	group.POST("/import", h.ImportDocument)
+	return router
+}
+
+// as sends a request to router as userId of the test tenant.
+func as(router *gin.Engine, userId string, method string, path string, body string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if userId != "" {
+		req.Header.Set("X-User-ID", userId)
+		req.Header.Set("X-Tenant-ID", testTenant)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// errorCode returns the code of an error response.
+func errorCode(rec *httptest.ResponseRecorder) string {
+	var response apierror.Response
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	return response.Error.Code
+}
+
+func TestGetDocumentByIDRefusesBadRequests(t *testing.T) {
+	// Neither reaches the repository
+	router := documentRouter(DocumentHandler{})
+
+	if rec := as(router, "", http.MethodGet, "/document/id/"+primitive.NewObjectID().Hex(), ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without a user: status %d, want 401", rec.Code)
+	}
+	if rec := as(router, "u-1", http.MethodGet, "/document/id/not-an-id", ""); rec.Code != http.StatusBadRequest || errorCode(rec) != apierror.CodeInvalidID {
+		t.Errorf("malformed ID: status %d %s, want 400 %s", rec.Code, rec.Body, apierror.CodeInvalidID)
+	}
+}
+
+func TestGetDocumentByIDAccess(t *testing.T) {
+	users := fakeDirectory{}
+	owner, reader, writer, stranger := users.newUserID("owner"), users.newUserID("reader"), users.newUserID("writer"), users.newUserID("stranger")
+	h := testHandler(t, users)
+	router := documentRouter(h)
+
+	ctx := tenantContext()
+	document, err := h.DocumentRepository.CreateNewDocument(ctx, "Plan", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	documentId := document.ID.Hex()
+	if _, err := h.DocumentRepository.CreateCollaborationRecord(ctx, reader, documentId, "read"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.DocumentRepository.CreateCollaborationRecord(ctx, writer, documentId, "write"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		userId    string
+		wantLevel string
+	}{
+		{"owner", owner, repository.AccessOwner},
+		{"read collaborator", reader, repository.AccessRead},
+		{"write collaborator", writer, repository.AccessWrite},
+	}
+	for _, tt := range tests {
+		rec := as(router, tt.userId, http.MethodGet, "/document/id/"+documentId, "")
+		var dto struct {
+			ID          string `json:"id"`
+			AccessLevel string `json:"accessLevel"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &dto)
+		if rec.Code != http.StatusOK || dto.AccessLevel != tt.wantLevel {
+			t.Errorf("%s: status %d, access %q, want 200 %q", tt.name, rec.Code, dto.AccessLevel, tt.wantLevel)
+		}
+	}
+
+	// Strangers cannot tell the document from a missing one, unless
+	// configured otherwise
+	missing := as(router, owner, http.MethodGet, "/document/id/"+primitive.NewObjectID().Hex(), "")
+	if missing.Code != http.StatusNotFound || errorCode(missing) != apierror.CodeDocumentNotFound {
+		t.Errorf("missing document: status %d %s, want 404 %s", missing.Code, missing.Body, apierror.CodeDocumentNotFound)
+	}
+	hide := config.AccessConfig.HideForbidden
+	defer func() { config.AccessConfig.HideForbidden = hide }()
+
+	config.AccessConfig.HideForbidden = true
+	if rec := as(router, stranger, http.MethodGet, "/document/id/"+documentId, ""); rec.Code != missing.Code || rec.Body.String() != missing.Body.String() {
+		t.Errorf("stranger: status %d %s, want the missing document's %d %s", rec.Code, rec.Body, missing.Code, missing.Body)
+	}
+	config.AccessConfig.HideForbidden = false
+	if rec := as(router, stranger, http.MethodGet, "/document/id/"+documentId, ""); rec.Code != http.StatusForbidden || errorCode(rec) != apierror.CodeForbidden {
+		t.Errorf("stranger, forbidden documents shown: status %d %s, want 403 %s", rec.Code, rec.Body, apierror.CodeForbidden)
+	}
+}
+
+// TestSnapshotIsNotAnOpen checks that the snapshot UpdatesService fetches
+// for a joining client does not record an open, which it records itself.
+func TestSnapshotIsNotAnOpen(t *testing.T) {
+	users := fakeDirectory{}
+	owner, stranger := users.newUserID("owner"), users.newUserID("stranger")
+	h := testHandler(t, users)
+	// Recording an open would panic
+	h.Opens = nil
+	router := documentRouter(h)
+
+	document, err := h.DocumentRepository.CreateNewDocument(tenantContext(), "Plan", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := as(router, owner, http.MethodGet, "/document/"+document.ID.Hex()+"/snapshot", "")
+	var snapshot types.SnapshotDto
+	json.Unmarshal(rec.Body.Bytes(), &snapshot)
+	if rec.Code != http.StatusOK || snapshot.DocumentID != document.ID.Hex() || len(snapshot.Slides) != len(document.Slides) {
+		t.Errorf("snapshot: status %d %s, want 200 and the document's content", rec.Code, rec.Body)
+	}
+
+	if rec := as(router, stranger, http.MethodGet, "/document/"+document.ID.Hex()+"/snapshot", ""); rec.Code != http.StatusForbidden && rec.Code != http.StatusNotFound {
+		t.Errorf("stranger's snapshot: status %d, want it refused", rec.Code)
+	}
+}
+
+func TestShareRefusesInvalidAccessType(t *testing.T) {
+	// Refused before the repository
+	router := documentRouter(DocumentHandler{})
+	path := "/document/" + primitive.NewObjectID().Hex() + "/share"
+
+	collaborator := `"collaboratorUserId":"` + primitive.NewObjectID().Hex() + `"`
+
+	for _, accessType := range []string{`,"accessType":"admin"`, `,"accessType":"rw"`, `,"accessType":"banana"`, `,"accessType":""`, `,"accessType":2`, `,"accessType":null`, ``} {
+		rec := as(router, "u-1", http.MethodPost, path, "{"+collaborator+accessType+"}")
+		var response apierror.Response
+		json.Unmarshal(rec.Body.Bytes(), &response)
+		if rec.Code != http.StatusUnprocessableEntity || response.Error.Code != apierror.CodeValidationFailed {
+			t.Errorf("%q: status %d %s, want 422 %s", accessType, rec.Code, rec.Body, apierror.CodeValidationFailed)
+		}
+		if response.Error.Message != `accessType must be "read" or "write"` {
+			t.Errorf("%q: message %q, want it to name the valid access types", accessType, response.Error.Message)
+		}
+	}
+
+	// Malformed JSON is a bad request, not a bad access type
+	if rec := as(router, "u-1", http.MethodPost, path, "{"+collaborator); rec.Code != http.StatusBadRequest || errorCode(rec) != apierror.CodeInvalidRequest {
+		t.Errorf("malformed body: status %d %s, want 400 %s", rec.Code, rec.Body, apierror.CodeInvalidRequest)
+	}
+}
+
+// unavailableDirectory is a UserDirectory whose AuthService cannot answer.
+type unavailableDirectory struct{}
+
+func (unavailableDirectory) FindUserByID(ctx context.Context, id string) (*authclient.User, error) {
+	return nil, authclient.ErrUnavailable
+}
+
+func (unavailableDirectory) FindUserByEmail(ctx context.Context, email string) (*authclient.User, error) {
+	return nil, authclient.ErrUnavailable
+}
+
+func (unavailableDirectory) ResolveUsers(ctx context.Context, ids []string) (map[string]authclient.User, error) {
+	return nil, authclient.ErrUnavailable
+}
+
+func TestResolveCollaborator(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	users := fakeDirectory{}
+	known := users.newUserID("known")
+	other := users.newUserID("other")
+	unknown := primitive.NewObjectID().Hex()
+
+	tests := []struct {
+		name   string
+		users  UserDirectory
+		data   types.ShareDocumentPostData
+		want   string
+		status int
+		code   string
+	}{
+		{"by user ID", users, types.ShareDocumentPostData{CollaboratorUserID: known}, known, 0, ""},
+		{"by email", users, types.ShareDocumentPostData{CollaboratorEmail: "known@example.com"}, known, 0, ""},
+		{"by both", users, types.ShareDocumentPostData{CollaboratorUserID: known, CollaboratorEmail: "known@example.com"}, known, 0, ""},
+		{"unknown user ID", users, types.ShareDocumentPostData{CollaboratorUserID: unknown}, "", http.StatusNotFound, apierror.CodeUserNotFound},
+		{"unknown email", users, types.ShareDocumentPostData{CollaboratorEmail: "nobody@example.com"}, "", http.StatusNotFound, apierror.CodeEmailNotRegistered},
+		{"different users", users, types.ShareDocumentPostData{CollaboratorUserID: known, CollaboratorEmail: "other@example.com"}, "", http.StatusBadRequest, apierror.CodeValidationFailed},
+		{"unknown user ID of a known email", users, types.ShareDocumentPostData{CollaboratorUserID: unknown, CollaboratorEmail: "other@example.com"}, "", http.StatusBadRequest, apierror.CodeValidationFailed},
+		{"nobody", users, types.ShareDocumentPostData{}, "", http.StatusBadRequest, apierror.CodeValidationFailed},
+		{"malformed user ID", users, types.ShareDocumentPostData{CollaboratorUserID: "u-1"}, "", http.StatusBadRequest, apierror.CodeInvalidID},
+		// Not skipped when the AuthService is down
+		{"user ID, AuthService down", unavailableDirectory{}, types.ShareDocumentPostData{CollaboratorUserID: other}, "", http.StatusServiceUnavailable, apierror.CodeUserLookupUnavailable},
+		{"email, AuthService down", unavailableDirectory{}, types.ShareDocumentPostData{CollaboratorEmail: "other@example.com"}, "", http.StatusServiceUnavailable, apierror.CodeUserLookupUnavailable},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodPost, "/document/share", nil)
+
+		got, ok := DocumentHandler{Users: tt.users}.resolveCollaborator(c, tt.data)
+		if tt.status == 0 {
+			if !ok || got != tt.want {
+				t.Errorf("%s: resolved %q, ok %v, status %d %s, want %q", tt.name, got, ok, rec.Code, rec.Body, tt.want)
+			}
+			continue
+		}
+		if ok || rec.Code != tt.status || errorCode(rec) != tt.code {
+			t.Errorf("%s: resolved %q, ok %v, status %d %s, want %d %s", tt.name, got, ok, rec.Code, rec.Body, tt.status, tt.code)
+		}
+	}
+}
+
+func TestShareWithUnknownCollaboratorCreatesNoRecord(t *testing.T) {
+	users := fakeDirectory{}
+	owner := users.newUserID("owner")
+	h := testHandler(t, users)
+	router := documentRouter(h)
+
+	document, err := h.DocumentRepository.CreateNewDocument(tenantContext(), "Plan", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/document/" + document.ID.Hex() + "/share"
+
+	rec := as(router, owner, http.MethodPost, path, `{"collaboratorUserId":"`+primitive.NewObjectID().Hex()+`","accessType":"read"}`)
+	var response apierror.Response
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	if rec.Code != http.StatusNotFound || response.Error.Code != apierror.CodeUserNotFound || response.Error.Message != "collaborator not found" {
+		t.Errorf("sharing with a made-up user: status %d %s, want 404 collaborator not found", rec.Code, rec.Body)
+	}
+
+	h.Users = unavailableDirectory{}
+	router = documentRouter(h)
+	if rec := as(router, owner, http.MethodPost, path, `{"collaboratorUserId":"`+primitive.NewObjectID().Hex()+`","accessType":"read"}`); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("sharing while the AuthService is down: status %d %s, want 503", rec.Code, rec.Body)
+	}
+
+	records, err := h.DocumentRepository.FindCollaboratorsByDocumentID(tenantContext(), document.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("records for unverified collaborators: %v", records)
+	}
+}
+
+func TestShareByEmail(t *testing.T) {
+	users := fakeDirectory{}
+	owner, collaborator, other := users.newUserID("owner"), users.newUserID("collaborator"), users.newUserID("other")
+	h := testHandler(t, users)
+	router := documentRouter(h)
+
+	document, err := h.DocumentRepository.CreateNewDocument(tenantContext(), "Plan", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/document/" + document.ID.Hex() + "/share"
+
+	if rec := as(router, owner, http.MethodPost, path, `{"collaboratorEmail":"collaborator@example.com","accessType":"write"}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("sharing by email: status %d %s, want 204", rec.Code, rec.Body)
+	}
+	// The frontend offers an invitation on EMAIL_NOT_REGISTERED
+	if rec := as(router, owner, http.MethodPost, path, `{"collaboratorEmail":"nobody@example.com","accessType":"read"}`); rec.Code != http.StatusNotFound || errorCode(rec) != apierror.CodeEmailNotRegistered {
+		t.Errorf("sharing with an unregistered email: status %d %s, want 404 %s", rec.Code, rec.Body, apierror.CodeEmailNotRegistered)
+	}
+	if rec := as(router, owner, http.MethodPost, path, `{"collaboratorUserId":"`+other+`","collaboratorEmail":"collaborator@example.com","accessType":"read"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("sharing with conflicting user ID and email: status %d %s, want 400", rec.Code, rec.Body)
+	}
+
+	h.Users = unavailableDirectory{}
+	if rec := as(documentRouter(h), owner, http.MethodPost, path, `{"collaboratorEmail":"other@example.com","accessType":"read"}`); rec.Code != http.StatusServiceUnavailable || errorCode(rec) != apierror.CodeUserLookupUnavailable {
+		t.Errorf("sharing by email while the AuthService is down: status %d %s, want 503 %s", rec.Code, rec.Body, apierror.CodeUserLookupUnavailable)
+	}
+
+	records, err := h.DocumentRepository.FindCollaboratorsByDocumentID(tenantContext(), document.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].UserID != collaborator || records[0].AccessType != "write" {
+		t.Errorf("records = %+v, want only the collaborator found by email", records)
+	}
+}
+
+func TestShareWithYourselfIsRefused(t *testing.T) {
+	users := fakeDirectory{}
+	owner := users.newUserID("owner")
+	h := testHandler(t, users)
+	router := documentRouter(h)
+
+	document, err := h.DocumentRepository.CreateNewDocument(tenantContext(), "Plan", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/document/" + document.ID.Hex() + "/share"
+
+	for _, body := range []string{
+		`{"collaboratorUserId":"` + owner + `","accessType":"write"}`,
+		`{"collaboratorEmail":"owner@example.com","accessType":"write"}`,
+	} {
+		if rec := as(router, owner, http.MethodPost, path, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("sharing %s with the owner: status %d %s, want 400", body, rec.Code, rec.Body)
+		}
+	}
+	records, err := h.DocumentRepository.FindCollaboratorsByDocumentID(tenantContext(), document.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("document shared with its owner: %v", records)
+	}
+}
+
+func TestConcurrentSharesCreateOneRecord(t *testing.T) {
+	users := fakeDirectory{}
+	owner, collaborator := users.newUserID("owner"), users.newUserID("collaborator")
+	h := testHandler(t, users)
+	router := documentRouter(h)
+
+	document, err := h.DocumentRepository.CreateNewDocument(tenantContext(), "Plan", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	documentId := document.ID.Hex()
+	path := "/document/" + documentId + "/share"
+
+	const requests = 16
+	codes := make([]int, requests)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = as(router, owner, http.MethodPost, path, `{"collaboratorUserId":"`+collaborator+`","accessType":"read"}`).Code
+		}()
+	}
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusNoContent {
+			t.Errorf("share %d: status %d, want 204", i, code)
+		}
+	}
+
+	// Sharing again changes the access of the one record
+	if rec := as(router, owner, http.MethodPost, path, `{"collaboratorUserId":"`+collaborator+`","accessType":"write"}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("changing the access: status %d %s, want 204", rec.Code, rec.Body)
+	}
+	records, err := h.DocumentRepository.FindCollaboratorsByDocumentID(tenantContext(), documentId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].UserID != collaborator || records[0].AccessType != "write" {
+		t.Fatalf("records after the shares = %+v, want one of write access", records)
+	}
+}
+
+func TestTransferRefusesBadRequests(t *testing.T) {
+	// Refused before the repository
+	router := documentRouter(DocumentHandler{})
+	owner := primitive.NewObjectID().Hex()
+	path := "/document/" + primitive.NewObjectID().Hex() + "/transfer"
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"no new owner", `{}`, http.StatusBadRequest},
+		{"malformed new owner", `{"newOwnerUserId":"bob"}`, http.StatusBadRequest},
+		{"to the owner", `{"newOwnerUserId":"` + owner + `"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if rec := as(router, owner, http.MethodPost, path, tt.body); rec.Code != tt.want {
+			t.Errorf("%s: status %d %s, want %d", tt.name, rec.Code, rec.Body, tt.want)
+		}
+	}
+}
+
+func TestTransferOwnership(t *testing.T) {
+	for _, keepAccess := range []bool{true, false} {
+		t.Run(fmt.Sprintf("keep access %v", keepAccess), func(t *testing.T) {
+			keep := config.AccessConfig.KeepAccessOnTransfer
+			config.AccessConfig.KeepAccessOnTransfer = keepAccess
+			defer func() { config.AccessConfig.KeepAccessOnTransfer = keep }()
+
+			users := fakeDirectory{}
+			owner, newOwner := users.newUserID("owner"), users.newUserID("new-owner")
+			h := testHandler(t, users)
+			router := documentRouter(h)
+
+			ctx := tenantContext()
+			document, err := h.DocumentRepository.CreateNewDocument(ctx, "Plan", owner)
+			if err != nil {
+				t.Fatal(err)
+			}
+			documentId := document.ID.Hex()
+			// The new owner was a collaborator
+			if _, err := h.DocumentRepository.CreateCollaborationRecord(ctx, newOwner, documentId, "read"); err != nil {
+				t.Fatal(err)
+			}
+
+			path := "/document/" + documentId + "/transfer"
+			if rec := as(router, newOwner, http.MethodPost, path, `{"newOwnerUserId":"`+newOwner+`"}`); rec.Code != http.StatusBadRequest {
+				t.Errorf("transfer to yourself by a collaborator: status %d, want 400", rec.Code)
+			}
+			if rec := as(router, owner, http.MethodPost, path, `{"newOwnerUserId":"`+primitive.NewObjectID().Hex()+`"}`); rec.Code != http.StatusNotFound {
+				t.Errorf("transfer to an unknown user: status %d %s, want 404", rec.Code, rec.Body)
+			}
+			if rec := as(router, owner, http.MethodPost, path, `{"newOwnerUserId":"`+newOwner+`"}`); rec.Code != http.StatusOK {
+				t.Fatalf("transfer: status %d %s, want 200", rec.Code, rec.Body)
+			}
+
+			if level, _ := h.DocumentRepository.GetAccessLevel(ctx, newOwner, documentId); level != repository.AccessOwner {
+				t.Errorf("new owner's access = %q, want owner", level)
+			}
+			wantPrevious := repository.AccessNone
+			if keepAccess {
+				wantPrevious = repository.AccessWrite
+			}
+			if level, _ := h.DocumentRepository.GetAccessLevel(ctx, owner, documentId); level != wantPrevious {
+				t.Errorf("previous owner's access = %q, want %q", level, wantPrevious)
+			}
+			records, err := h.DocumentRepository.FindCollaboratorsByDocumentID(ctx, documentId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, record := range records {
+				if record.UserID == newOwner {
+					t.Error("the new owner is still a collaborator")
+				}
+			}
+
+			// Only the owner may transfer
+			third := users.newUserID("third")
+			if rec := as(router, owner, http.MethodPost, path, `{"newOwnerUserId":"`+third+`"}`); rec.Code == http.StatusOK {
+				t.Error("the previous owner transferred the document on")
+			}
+		})
+	}
+}
+
+func TestUpdateDocumentContent(t *testing.T) {
+	users := fakeDirectory{}
+	owner, reader, writer, stranger := users.newUserID("owner"), users.newUserID("reader"), users.newUserID("writer"), users.newUserID("stranger")
+	h := testHandler(t, users)
+	router := documentRouter(h)
+
+	ctx := tenantContext()
+	document, err := h.DocumentRepository.CreateNewDocument(ctx, "Plan", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	documentId := document.ID.Hex()
+	if _, err := h.DocumentRepository.CreateCollaborationRecord(ctx, reader, documentId, "read"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.DocumentRepository.CreateCollaborationRecord(ctx, writer, documentId, "write"); err != nil {
+		t.Fatal(err)
+	}
+
+	hide, maxBytes := config.AccessConfig.HideForbidden, config.ContentConfig.MaxBytes
+	defer func() { config.AccessConfig.HideForbidden, config.ContentConfig.MaxBytes = hide, maxBytes }()
+	config.AccessConfig.HideForbidden = true
+	config.ContentConfig.MaxBytes = 1024
+
+	path := "/document/" + documentId + "/content"
+	body := fmt.Sprintf(`{"baseVersion":%d,"slides":[{"id":"s-1","objects":[]}]}`, document.Version)
+	oversized := fmt.Sprintf(`{"baseVersion":%d,"slides":[{"id":"%s","objects":[]}]}`, document.Version, strings.Repeat("s", 2048))
+
+	tests := []struct {
+		name       string
+		userId     string
+		path       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"read collaborator", reader, path, body, http.StatusForbidden, apierror.CodeForbidden},
+		// Strangers cannot tell the document from a missing one
+		{"stranger", stranger, path, body, http.StatusNotFound, apierror.CodeDocumentNotFound},
+		{"missing document", owner, "/document/" + primitive.NewObjectID().Hex() + "/content", body, http.StatusNotFound, apierror.CodeDocumentNotFound},
+		{"oversized content", writer, path, oversized, http.StatusRequestEntityTooLarge, apierror.CodeContentTooLarge},
+	}
+	for _, tt := range tests {
+		rec := as(router, tt.userId, http.MethodPut, tt.path, tt.body)
+		if rec.Code != tt.wantStatus || errorCode(rec) != tt.wantCode {
+			t.Errorf("%s: status %d %s, want %d %s", tt.name, rec.Code, rec.Body, tt.wantStatus, tt.wantCode)
+		}
+	}
+
+	// Oversized content of unknown length is cut off while it is read
+	req := httptest.NewRequest(http.MethodPut, path, io.MultiReader(strings.NewReader(oversized)))
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", writer)
+	req.Header.Set("X-Tenant-ID", testTenant)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge || errorCode(rec) != apierror.CodeContentTooLarge {
+		t.Errorf("oversized chunked content: status %d %s, want 413 %s", rec.Code, rec.Body, apierror.CodeContentTooLarge)
+	}
+
+	// None of the refused writes changed the document
+	rec = as(router, writer, http.MethodPut, path, body)
+	var updated types.ContentUpdatedResponse
+	json.Unmarshal(rec.Body.Bytes(), &updated)
+	if rec.Code != http.StatusOK || updated.Version != document.Version+1 {
+		t.Errorf("write collaborator: status %d %s, want 200 and version %d", rec.Code, rec.Body, document.Version+1)
+	}
+}
+
+func TestParseObjectIDParam(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name string
+		id   string
+		ok   bool
+	}{
+		{"empty", "", false},
+		{"too short", "65a1f0c2e4b0", false},
+		{"too long", "65a1f0c2e4b0a1b2c3d4e5f6a7", false},
+		{"not hex", "65a1f0c2e4b0a1b2c3d4e5zz", false},
+		{"valid", "65a1f0c2e4b0a1b2c3d4e5f6", true},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Params = gin.Params{{Key: "id", Value: tt.id}}
+
+		id, ok := ParseObjectIDParam(c, "id")
+		if ok != tt.ok {
+			t.Errorf("%s: ok = %v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if ok {
+			if id != tt.id || c.IsAborted() {
+				t.Errorf("%s: = %q, aborted %v, want %q and the request left alone", tt.name, id, c.IsAborted(), tt.id)
+			}
+			continue
+		}
+		if rec.Code != http.StatusBadRequest || errorCode(rec) != apierror.CodeInvalidID {
+			t.Errorf("%s: status %d %s, want 400 %s", tt.name, rec.Code, rec.Body, apierror.CodeInvalidID)
+		}
+	}
+}
+
+// idRoutes are the routes of documentRouter taking a document ID, with a
+// body each would otherwise accept.
+var idRoutes = []struct {
+	method string
+	path   string
+	body   string
+}{
+	{http.MethodGet, "/document/id/%s", ""},
+	{http.MethodGet, "/document/%s/snapshot", ""},
+	{http.MethodPost, "/document/%s/share", `{"email":"bob@example.com","accessType":"read"}`},
+	{http.MethodPost, "/document/%s/transfer", `{"newOwnerUserId":"65a1f0c2e4b0a1b2c3d4e5f6"}`},
+	{http.MethodPut, "/document/%s/content", `{"baseVersion":1,"slides":[]}`},
+}
+
+func TestMalformedDocumentIDsAreRefused(t *testing.T) {
+	// Refused before the repository
+	router := documentRouter(DocumentHandler{})
+	userId := primitive.NewObjectID().Hex()
+
+	for _, route := range idRoutes {
+		for _, id := range []string{"65a1f0c2e4b0", "65a1f0c2e4b0a1b2c3d4e5zz", "abc"} {
+			path := fmt.Sprintf(route.path, id)
+			if rec := as(router, userId, route.method, path, route.body); rec.Code != http.StatusBadRequest || errorCode(rec) != apierror.CodeInvalidID {
+				t.Errorf("%s %s: status %d %s, want 400 %s", route.method, path, rec.Code, rec.Body, apierror.CodeInvalidID)
+			}
+		}
+	}
+}
+
+func TestMissingDocumentsAreNotFound(t *testing.T) {
+	users := fakeDirectory{}
+	userId := users.newUserID("alice")
+	users.newUserID("bob")
+	router := documentRouter(testHandler(t, users))
+
+	// Well-formed, so past validation, but naming no document
+	id := primitive.NewObjectID().Hex()
+	for _, route := range idRoutes {
+		path := fmt.Sprintf(route.path, id)
+		if rec := as(router, userId, route.method, path, route.body); rec.Code != http.StatusNotFound || errorCode(rec) != apierror.CodeDocumentNotFound {
+			t.Errorf("%s %s: status %d %s, want 404 %s", route.method, path, rec.Code, rec.Body, apierror.CodeDocumentNotFound)
+		}
+	}
+}
+
+func TestParseListQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defaults := repository.ListOptions{Limit: types.DefaultPageSize, Sort: repository.SortCreatedAt}
+
+	tests := []struct {
+		query        string
+		want         repository.ListOptions
+		owned, share int64
+	}{
+		{"", defaults, 0, 0},
+		{"limit=10&sort=title&order=desc", repository.ListOptions{Limit: 10, Sort: repository.SortTitle, Descending: true}, 0, 0},
+		{"limit=100000", repository.ListOptions{Limit: types.MaxPageSize, Sort: repository.SortCreatedAt}, 0, 0},
+		{"offset=20", defaults, 20, 20},
+		{"offset=20&sharedOffset=5", defaults, 20, 5},
+		{"ownedOffset=7&sharedOffset=3", defaults, 7, 3},
+		{"tag=Design", repository.ListOptions{Limit: types.DefaultPageSize, Sort: repository.SortCreatedAt, Tag: "design"}, 0, 0},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodGet, "/document/all?"+tt.query, nil)
+
+		opts, owned, shared, ok := parseListQuery(c)
+		if !ok {
+			t.Errorf("%q: refused with %d %s", tt.query, rec.Code, rec.Body)
+			continue
+		}
+		if opts != tt.want || owned != tt.owned || shared != tt.share {
+			t.Errorf("%q: %+v, offsets %d and %d, want %+v, %d and %d", tt.query, opts, owned, shared, tt.want, tt.owned, tt.share)
+		}
+	}
+
+	for _, query := range []string{"limit=0", "limit=ten", "sort=size", "order=up", "offset=-1", "ownedOffset=x", "sharedOffset=-3"} {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodGet, "/document/all?"+query, nil)
+
+		if _, _, _, ok := parseListQuery(c); ok || rec.Code != http.StatusBadRequest || errorCode(rec) != apierror.CodeValidationFailed {
+			t.Errorf("%q: ok %v, status %d %s, want 400 %s", query, ok, rec.Code, rec.Body, apierror.CodeValidationFailed)
+		}
+	}
+}
+
+func TestExportRefusesUnknownFormats(t *testing.T) {
+	// Refused before the repository
+	router := documentRouter(DocumentHandler{})
+	path := "/document/" + primitive.NewObjectID().Hex() + "/export"
+
+	for _, format := range []string{"pdf", "docx", ""} {
+		rec := as(router, "u-1", http.MethodGet, path+"?format="+format, "")
+		var response apierror.Response
+		json.Unmarshal(rec.Body.Bytes(), &response)
+		if rec.Code != http.StatusBadRequest || response.Error.Code != apierror.CodeValidationFailed || response.Error.Message != "format must be one of json, md, txt" {
+			t.Errorf("format %q: status %d %s, want 400 listing json, md, txt", format, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestExportDocument(t *testing.T) {
+	users := fakeDirectory{}
+	owner, reader, stranger := users.newUserID("owner"), users.newUserID("reader"), users.newUserID("stranger")
+	h := testHandler(t, users)
+	router := documentRouter(h)
+
+	ctx := tenantContext()
+	slides := []model.Slide{{ID: "s-1", Objects: []model.Object{{ID: "o-1", Type: "text", Attributes: map[string]interface{}{"value": "Goals"}}}}}
+	document, err := h.DocumentRepository.CreateDocumentWithContent(ctx, "Q3 plan", owner, slides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.DocumentRepository.CreateCollaborationRecord(ctx, reader, document.ID.Hex(), "read"); err != nil {
+		t.Fatal(err)
+	}
+	path := "/document/" + document.ID.Hex() + "/export"
+
+	tests := []struct {
+		userId      string
+		query       string
+		contentType string
+		disposition string
+		body        string
+	}{
+		{owner, "", "application/json; charset=utf-8", `attachment; filename="Q3 plan.json"`, `{"schemaVersion":1,"title":"Q3 plan","slides":[{"id":"s-1","background":"","objects":[{"id":"o-1","type":"text","attributes":{"value":"Goals"}}]}]}` + "\n"},
+		{reader, "?format=md", "text/markdown; charset=utf-8", `attachment; filename="Q3 plan.md"`, "# Q3 plan\n\n## Slide 1\n\nGoals\n"},
+		{reader, "?format=TXT", "text/plain; charset=utf-8", `attachment; filename="Q3 plan.txt"`, "Q3 plan\n\nSlide 1\nGoals\n"},
+	}
+	for _, tt := range tests {
+		rec := as(router, tt.userId, http.MethodGet, path+tt.query, "")
+		if rec.Code != http.StatusOK {
+			t.Errorf("%q: status %d %s, want 200", tt.query, rec.Code, rec.Body)
+			continue
+		}
+		if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%q: Content-Type %q, want %q", tt.query, got, tt.contentType)
+		}
+		if got := rec.Header().Get("Content-Disposition"); got != tt.disposition {
+			t.Errorf("%q: Content-Disposition %q, want %q", tt.query, got, tt.disposition)
+		}
+		if rec.Body.String() != tt.body {
+			t.Errorf("%q: body %q, want %q", tt.query, rec.Body, tt.body)
+		}
+	}
+
+	if rec := as(router, stranger, http.MethodGet, path, ""); rec.Code != http.StatusForbidden && rec.Code != http.StatusNotFound {
+		t.Errorf("stranger's export: status %d, want it refused", rec.Code)
+	}
+}
+
+// upload sends content to POST /document/import as the file field of a
+// multipart form, as userId.
+func upload(router *gin.Engine, userId string, field string, content string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile(field, "plan.json")
+	part.Write([]byte(content))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/document/import", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("X-User-ID", userId)
+	req.Header.Set("X-Tenant-ID", testTenant)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestImportRefusesMalformedContent(t *testing.T) {
+	// Refused before the repository
+	router := documentRouter(DocumentHandler{})
+	maxBytes := config.ContentConfig.MaxBytes
+	defer func() { config.ContentConfig.MaxBytes = maxBytes }()
+	config.ContentConfig.MaxBytes = 1024
+
+	slides := `"slides":[{"id":"s-1","objects":[]}]`
+	tests := []struct {
+		name    string
+		body    string
+		status  int
+		code    string
+		pointer string
+	}{
+		{"not JSON", `{"schemaVersion":1,`, http.StatusUnprocessableEntity, apierror.CodeValidationFailed, ""},
+		{"other schema version", `{"schemaVersion":9,"title":"Plan",` + slides + `}`, http.StatusUnprocessableEntity, apierror.CodeValidationFailed, "/schemaVersion"},
+		{"no slides", `{"schemaVersion":1,"title":"Plan","slides":[]}`, http.StatusUnprocessableEntity, apierror.CodeValidationFailed, "/slides"},
+		{"object without type", `{"schemaVersion":1,"title":"Plan","slides":[{"id":"s-1","objects":[{"id":"o-1","attributes":{}}]}]}`, http.StatusUnprocessableEntity, apierror.CodeValidationFailed, "/slides/0/objects/0/type"},
+		{"blank title", `{"schemaVersion":1,"title":"  ",` + slides + `}`, http.StatusUnprocessableEntity, apierror.CodeValidationFailed, "/title"},
+		{"too large", `{"schemaVersion":1,"title":"` + strings.Repeat("a", 2048) + `",` + slides + `}`, http.StatusRequestEntityTooLarge, apierror.CodeContentTooLarge, ""},
+	}
+	for _, tt := range tests {
+		for _, rec := range []*httptest.ResponseRecorder{
+			as(router, "u-1", http.MethodPost, "/document/import", tt.body),
+			upload(router, "u-1", "file", tt.body),
+		} {
+			var response apierror.Response
+			json.Unmarshal(rec.Body.Bytes(), &response)
+			if rec.Code != tt.status || response.Error.Code != tt.code || response.Error.Pointer != tt.pointer || response.Error.Message == "" {
+				t.Errorf("%s: status %d %s, want %d %s at %q", tt.name, rec.Code, rec.Body, tt.status, tt.code, tt.pointer)
+			}
+		}
+	}
+
+	if rec := upload(router, "u-1", "document", `{}`); rec.Code != http.StatusBadRequest || errorCode(rec) != apierror.CodeInvalidRequest {
+		t.Errorf("form without a file field: status %d %s, want 400 %s", rec.Code, rec.Body, apierror.CodeInvalidRequest)
+	}
+	if rec := as(router, "", http.MethodPost, "/document/import", `{}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous import: status %d, want 401", rec.Code)
+	}
+}
+
+func TestImportThenExport(t *testing.T) {
+	users := fakeDirectory{}
+	owner, stranger := users.newUserID("owner"), users.newUserID("stranger")
+	h := testHandler(t, users)
+	router := documentRouter(h)
+
+	exported := `{"schemaVersion":1,"title":"Q3 plan","slides":[{"id":"s-1","background":"#fff","objects":[{"id":"o-1","type":"rectangle","attributes":{"x":1,"y":2.5}}]}]}` + "\n"
+
+	for name, rec := range map[string]*httptest.ResponseRecorder{
+		"body":   as(router, owner, http.MethodPost, "/document/import", exported),
+		"upload": upload(router, owner, "file", exported),
+	} {
+		var created types.CreatedResponse
+		if rec.Code != http.StatusCreated || json.Unmarshal(rec.Body.Bytes(), &created) != nil || !primitive.IsValidObjectID(created.ID) {
+			t.Errorf("%s: status %d %s, want 201 with the new ID", name, rec.Code, rec.Body)
+			continue
+		}
+
+		document, err := h.DocumentRepository.FindDocumentByID(tenantContext(), created.ID)
+		if err != nil || document == nil || document.OwnerID != owner || document.Title != "Q3 plan" {
+			t.Errorf("%s: imported %+v, %v, want Q3 plan owned by the importer", name, document, err)
+		}
+		if rec := as(router, owner, http.MethodGet, "/document/"+created.ID+"/export", ""); rec.Body.String() != exported {
+			t.Errorf("%s: exported the import as %s, want %s", name, rec.Body, exported)
+		}
+		if rec := as(router, stranger, http.MethodGet, "/document/"+created.ID+"/export", ""); rec.Code == http.StatusOK {
+			t.Errorf("%s: the import is visible to others", name)
+		}
+	}
+}
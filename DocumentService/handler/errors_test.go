@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// apierrorCodes reads the codes apierror declares, by constant name.
+func apierrorCodes(t *testing.T) map[string]string {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "../../Shared/apierror/apierror.go", nil, 0)
+	if err != nil {
+		t.Fatalf("parsing apierror: %v", err)
+	}
+	codes := make(map[string]string)
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			value := spec.(*ast.ValueSpec)
+			for i, name := range value.Names {
+				if lit, ok := value.Values[i].(*ast.BasicLit); ok && strings.HasPrefix(name.Name, "Code") {
+					codes[name.Name], _ = strconv.Unquote(lit.Value)
+				}
+			}
+		}
+	}
+	if len(codes) == 0 {
+		t.Fatal("apierror declares no codes")
+	}
+	return codes
+}
+
+// TestErrorPathsUseKnownCodes checks every error the handlers write: each goes
+// through apierror, with a code named by one of its constants rather than
+// spelled out or computed, so clients only ever see the codes listed there.
+func TestErrorPathsUseKnownCodes(t *testing.T) {
+	codes := apierrorCodes(t)
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fset := token.NewFileSet()
+	paths := 0
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatalf("parsing %s: %v", name, err)
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			fun, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			var code ast.Expr
+			switch {
+			case isPackage(fun.X, "apierror") && fun.Sel.Name == "Abort":
+				code = call.Args[2]
+			case isPackage(fun.X, "apierror") && fun.Sel.Name == "New":
+				code = call.Args[1]
+			case isPackage(fun.X, "apierror") && fun.Sel.Name == "Internal":
+				paths++
+				return true
+			case fun.Sel.Name == "JSON" || fun.Sel.Name == "AbortWithStatusJSON":
+				if body, ok := call.Args[1].(*ast.CompositeLit); ok && isGinH(body.Type) {
+					for _, elt := range body.Elts {
+						if key, ok := elt.(*ast.KeyValueExpr).Key.(*ast.BasicLit); ok && key.Value == `"error"` {
+							t.Errorf("%s: error written as gin.H, not the apierror envelope", fset.Position(call.Pos()))
+						}
+					}
+				}
+				return true
+			default:
+				return true
+			}
+
+			paths++
+			sel, ok := code.(*ast.SelectorExpr)
+			if !ok || !isPackage(sel.X, "apierror") {
+				t.Errorf("%s: code is not an apierror constant", fset.Position(code.Pos()))
+				return true
+			}
+			if _, ok := codes[sel.Sel.Name]; !ok {
+				t.Errorf("%s: apierror.%s is not a declared code", fset.Position(code.Pos()), sel.Sel.Name)
+			}
+			return true
+		})
+	}
+	// Guards the scan itself: the handlers have well over a hundred
+	if paths < 100 {
+		t.Errorf("found %d error paths, the scan is missing them", paths)
+	}
+}
+
+func TestCodesAreDistinct(t *testing.T) {
+	seen := make(map[string]string)
+	for name, code := range apierrorCodes(t) {
+		if other, ok := seen[code]; ok {
+			t.Errorf("%s and %s are both %q", name, other, code)
+		}
+		seen[code] = name
+		if code != strings.ToUpper(code) || strings.ContainsAny(code, " -") {
+			t.Errorf("%s = %q, want UPPER_SNAKE_CASE", name, code)
+		}
+	}
+}
+
+func isPackage(x ast.Expr, name string) bool {
+	ident, ok := x.(*ast.Ident)
+	return ok && ident.Name == name
+}
+
+func isGinH(x ast.Expr) bool {
+	sel, ok := x.(*ast.SelectorExpr)
+	return ok && isPackage(sel.X, "gin") && sel.Sel.Name == "H"
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"document-service/repository"
+	"net/http"
+	"shared/apierror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ================================= Favorite Handlers ==============================
+
+// FavoriteDocument stars a document for the user. Any document they may read
+// can be starred, their own or one shared with them; starring it again is
+// not an error.
+//
+// Route: POST /document/:id/favorite
+func (h DocumentHandler) FavoriteDocument(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := h.authorizeDocument(c, userId, documentId, repository.AccessRead); !ok {
+		return
+	}
+
+	if err := h.DocumentRepository.AddFavorite(c, userId, documentId); err != nil {
+		apierror.Internal(c, "Error adding favorite", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// UnfavoriteDocument unstars a document for the user. Unstarring a document
+// that is not starred is not an error.
+//
+// Route: DELETE /document/:id/favorite
+func (h DocumentHandler) UnfavoriteDocument(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.DocumentRepository.RemoveFavorite(c, userId, documentId); err != nil {
+		apierror.Internal(c, "Error removing favorite", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"document-service/repository"
+	"document-service/types"
+	"errors"
+	"net/http"
+	"shared/apierror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ================================= Folder Handlers ==============================
+
+// GetFolders lists the user's folders. Each names its parent, so clients build
+// the tree themselves.
+//
+// Route: GET /document/folders
+func (h DocumentHandler) GetFolders(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	folders, err := h.Folders.FindFolders(c, userId)
+	if err != nil {
+		apierror.Internal(c, "Error retrieving folders", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, folders)
+}
+
+// CreateFolder creates a folder at the root or inside one of the user's
+// folders.
+//
+// Route: POST /document/folders
+func (h DocumentHandler) CreateFolder(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	var data types.FolderData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid data format or missing fields")
+		return
+	}
+	data.Normalize()
+	if err := data.Validate(); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, err.Error())
+		return
+	}
+	if data.ParentID != "" && !validID(c, "parentId", data.ParentID) {
+		return
+	}
+
+	folder, err := h.Folders.CreateFolder(c, userId, data.Name, data.ParentID)
+	if errors.Is(err, repository.ErrFolderNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeFolderNotFound, "Parent folder not found")
+		return
+	}
+	if err != nil {
+		apierror.Internal(c, "Error creating folder", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, folder)
+}
+
+// RenameFolder renames one of the user's folders.
+//
+// Route: PATCH /document/folders/:folderId
+func (h DocumentHandler) RenameFolder(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	var data types.FolderData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid data format or missing fields")
+		return
+	}
+	data.Normalize()
+	if err := data.Validate(); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, err.Error())
+		return
+	}
+
+	folderId, ok := ParseObjectIDParam(c, "folderId")
+	if !ok {
+		return
+	}
+
+	folder, err := h.Folders.RenameFolder(c, folderId, userId, data.Name)
+	if errors.Is(err, repository.ErrFolderNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeFolderNotFound, "Folder not found")
+		return
+	}
+	if err != nil {
+		apierror.Internal(c, "Error renaming folder", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, folder)
+}
+
+// DeleteFolder deletes one of the user's folders. Only empty folders can be
+// deleted; a folder still holding documents or folders answers 409.
+//
+// Route: DELETE /document/folders/:folderId
+func (h DocumentHandler) DeleteFolder(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	folderId, ok := ParseObjectIDParam(c, "folderId")
+	if !ok {
+		return
+	}
+
+	err := h.Folders.DeleteFolder(c, folderId, userId)
+	if errors.Is(err, repository.ErrFolderNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeFolderNotFound, "Folder not found")
+		return
+	}
+	if errors.Is(err, repository.ErrFolderNotEmpty) {
+		apierror.Abort(c, http.StatusConflict, apierror.CodeFolderNotEmpty, "Folder is not empty - Move or delete its contents first.")
+		return
+	}
+	if err != nil {
+		apierror.Internal(c, "Error deleting folder", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// MoveDocument puts one of the user's documents into one of their folders,
+// or at the root when folderId is empty. Only the owner files a document;
+// collaborators do not see the owner's folders.
+//
+// Route: PATCH /document/:id/folder
+func (h DocumentHandler) MoveDocument(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	var data types.MoveDocumentData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid data format or missing fields")
+		return
+	}
+	if data.FolderID != "" && !validID(c, "folderId", data.FolderID) {
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := h.authorizeDocument(c, userId, documentId, repository.AccessOwner); !ok {
+		return
+	}
+
+	document, err := h.Folders.MoveDocument(c, documentId, userId, data.FolderID)
+	if errors.Is(err, repository.ErrDocumentNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeDocumentNotFound, "Document not found")
+		return
+	}
+	if errors.Is(err, repository.ErrFolderNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeFolderNotFound, "Folder not found")
+		return
+	}
+	if err != nil {
+		apierror.Internal(c, "Error moving document", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, types.NewDocumentMetadataDto(*document))
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"shared/apierror"
+	"shared/authmw"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// folderRouter serves the folder routes like documentRouter.
+func folderRouter(h DocumentHandler) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.ContextWithFallback = true
+	group := router.Group("/document", authmw.Middleware(authmw.GatewayHeaders{}))
+	group.GET("/folders", h.GetFolders)
+	group.POST("/folders", h.CreateFolder)
+	group.PATCH("/folders/:folderId", h.RenameFolder)
+	group.DELETE("/folders/:folderId", h.DeleteFolder)
+	group.PATCH("/:id/folder", h.MoveDocument)
+	return router
+}
+
+func TestFolderRequestsAreValidated(t *testing.T) {
+	// Refused before the repository
+	router := folderRouter(DocumentHandler{})
+	folder := "/document/folders/" + primitive.NewObjectID().Hex()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		code   string
+	}{
+		{"blank name", http.MethodPost, "/document/folders", `{"name":"  "}`, http.StatusBadRequest, apierror.CodeValidationFailed},
+		{"long name", http.MethodPost, "/document/folders", `{"name":"` + strings.Repeat("f", 101) + `"}`, http.StatusBadRequest, apierror.CodeValidationFailed},
+		{"control characters", http.MethodPost, "/document/folders", `{"name":"a\nb"}`, http.StatusBadRequest, apierror.CodeValidationFailed},
+		{"malformed parent", http.MethodPost, "/document/folders", `{"name":"Q3","parentId":"f-1"}`, http.StatusBadRequest, apierror.CodeInvalidID},
+		{"malformed body", http.MethodPost, "/document/folders", `{"name":`, http.StatusBadRequest, apierror.CodeInvalidRequest},
+		{"rename to nothing", http.MethodPatch, folder, `{"name":""}`, http.StatusBadRequest, apierror.CodeValidationFailed},
+		{"rename malformed folder", http.MethodPatch, "/document/folders/f-1", `{"name":"Q3"}`, http.StatusBadRequest, apierror.CodeInvalidID},
+		{"delete malformed folder", http.MethodDelete, "/document/folders/f-1", "", http.StatusBadRequest, apierror.CodeInvalidID},
+		{"move into malformed folder", http.MethodPatch, "/document/" + primitive.NewObjectID().Hex() + "/folder", `{"folderId":"f-1"}`, http.StatusBadRequest, apierror.CodeInvalidID},
+		{"move malformed document", http.MethodPatch, "/document/d-1/folder", `{"folderId":""}`, http.StatusBadRequest, apierror.CodeInvalidID},
+	}
+	for _, tt := range tests {
+		if rec := as(router, "u-1", tt.method, tt.path, tt.body); rec.Code != tt.status || errorCode(rec) != tt.code {
+			t.Errorf("%s: status %d %s, want %d %s", tt.name, rec.Code, rec.Body, tt.status, tt.code)
+		}
+	}
+}
+
+func TestDeleteFolderAnswersConflictWhileNotEmpty(t *testing.T) {
+	users := fakeDirectory{}
+	owner, other := users.newUserID("owner"), users.newUserID("other")
+	h := testHandler(t, users)
+	router := folderRouter(h)
+
+	rec := as(router, owner, http.MethodPost, "/document/folders", `{"name":" Work "}`)
+	var folder struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	if rec.Code != http.StatusCreated || json.Unmarshal(rec.Body.Bytes(), &folder) != nil || folder.Name != "Work" {
+		t.Fatalf("creating a folder: status %d %s, want 201 named Work", rec.Code, rec.Body)
+	}
+	document, err := h.DocumentRepository.CreateNewDocument(tenantContext(), "Plan", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	move := "/document/" + document.ID.Hex() + "/folder"
+	if rec := as(router, owner, http.MethodPatch, move, `{"folderId":"`+folder.ID+`"}`); rec.Code != http.StatusOK {
+		t.Fatalf("filing the document: status %d %s", rec.Code, rec.Body)
+	}
+
+	path := "/document/folders/" + folder.ID
+	if rec := as(router, other, http.MethodDelete, path, ""); rec.Code != http.StatusNotFound || errorCode(rec) != apierror.CodeFolderNotFound {
+		t.Errorf("another user deleting it: status %d %s, want 404 %s", rec.Code, rec.Body, apierror.CodeFolderNotFound)
+	}
+	if rec := as(router, owner, http.MethodDelete, path, ""); rec.Code != http.StatusConflict || errorCode(rec) != apierror.CodeFolderNotEmpty {
+		t.Errorf("deleting it with a document inside: status %d %s, want 409 %s", rec.Code, rec.Body, apierror.CodeFolderNotEmpty)
+	}
+
+	if rec := as(router, owner, http.MethodPatch, move, `{"folderId":""}`); rec.Code != http.StatusOK {
+		t.Fatalf("moving the document to the root: status %d %s", rec.Code, rec.Body)
+	}
+	if rec := as(router, owner, http.MethodDelete, path, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("deleting it once empty: status %d %s, want 204", rec.Code, rec.Body)
+	}
+	if rec := as(router, owner, http.MethodDelete, path, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("deleting it again: status %d, want 404", rec.Code)
+	}
+}
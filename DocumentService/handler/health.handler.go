@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// readinessTimeout bounds the MongoDB ping so a probe cannot hang.
+	readinessTimeout = 500 * time.Millisecond
+	// readinessCacheTTL is how long a ping result is reused, so a storm of
+	// probes cannot hammer the database.
+	readinessCacheTTL = 2 * time.Second
+)
+
+// HealthHandler answers the orchestrator's probes.
+type HealthHandler struct {
+	MongoClient *mongo.Client
+	// Version identifies the build, set at link time
+	Version string
+
+	draining atomic.Bool
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	mongoErr  error
+}
+
+// HealthResponse identifies the running build.
+type HealthResponse struct {
+	Status    string `json:"status"`
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+// ReadinessResponse reports MongoDB as "ok" or why it is unavailable, or that
+// the instance is shutting down.
+type ReadinessResponse struct {
+	Status string `json:"status,omitempty"`
+	Mongo  string `json:"mongo,omitempty"`
+}
+
+// Health reports that the process is up, without checking dependencies, and
+// which build it runs.
+func (h *HealthHandler) Health(c *gin.Context) {
+	response := HealthResponse{Status: "ok", Version: h.Version, GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				response.Revision = setting.Value
+			}
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Ready returns 200 when MongoDB answers a ping and 503 with the reason
+// otherwise. It also returns 503 once the instance is shutting down, so the
+// load balancer stops routing to it during the drain.
+func (h *HealthHandler) Ready(c *gin.Context) {
+	if h.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, ReadinessResponse{Status: "shutting down"})
+		return
+	}
+
+	if err := h.pingMongo(c.Request.Context()); err != nil {
+		c.JSON(http.StatusServiceUnavailable, ReadinessResponse{Mongo: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ReadinessResponse{Mongo: "ok"})
+}
+
+// pingMongo pings MongoDB, reusing the last result for readinessCacheTTL.
+// Concurrent probes wait for a single ping.
+func (h *HealthHandler) pingMongo(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if time.Since(h.checkedAt) < readinessCacheTTL {
+		return h.mongoErr
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	h.mongoErr = h.MongoClient.Ping(ctx, nil)
+	h.checkedAt = time.Now()
+	if h.mongoErr != nil {
+		log.Printf("[Ready] mongo is unavailable: %v", h.mongoErr)
+	}
+	return h.mongoErr
+}
+
+// Drain makes Ready fail from now on.
+func (h *HealthHandler) Drain() {
+	h.draining.Store(true)
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"document-service/repository"
+	"document-service/types"
+	"errors"
+	"net/http"
+	"shared/apierror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ================================= Lock Handlers ==============================
+
+// LockDocument freezes one of the user's documents. Locked documents stay
+// readable by everyone they are shared with, but their content cannot be
+// changed, live or through the API, until the owner unlocks them.
+//
+// Route: POST /document/:id/lock
+func (h DocumentHandler) LockDocument(c *gin.Context) {
+	h.setLocked(c, true)
+}
+
+// UnlockDocument makes a locked document of the user editable again.
+//
+// Route: POST /document/:id/unlock
+func (h DocumentHandler) UnlockDocument(c *gin.Context) {
+	h.setLocked(c, false)
+}
+
+// setLocked locks or unlocks the :id document; only its owner may.
+func (h DocumentHandler) setLocked(c *gin.Context, locked bool) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := h.authorizeDocument(c, userId, documentId, repository.AccessOwner); !ok {
+		return
+	}
+
+	document, err := h.DocumentRepository.SetLocked(c, documentId, userId, locked)
+	if errors.Is(err, repository.ErrDocumentNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeDocumentNotFound, "Document not found")
+		return
+	}
+	if err != nil {
+		apierror.Internal(c, "Error locking document", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, types.NewDocumentMetadataDto(*document))
+}
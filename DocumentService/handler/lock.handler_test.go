@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"shared/apierror"
+	"shared/authmw"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// lockRouter serves the lock routes next to the reading and writing routes
+// they affect.
+func lockRouter(h DocumentHandler) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.ContextWithFallback = true
+	group := router.Group("/document", authmw.Middleware(authmw.GatewayHeaders{}))
+	group.GET("/id/:id", h.GetDocumentByID)
+	group.PUT("/:id/content", h.UpdateDocumentContent)
+	group.POST("/:id/lock", h.LockDocument)
+	group.POST("/:id/unlock", h.UnlockDocument)
+	return router
+}
+
+// lockState is what the lock routes answer about the lock.
+type lockState struct {
+	Locked   bool   `json:"locked"`
+	LockedBy string `json:"lockedBy"`
+	LockedAt string `json:"lockedAt"`
+}
+
+func TestLockRequestsAreValidated(t *testing.T) {
+	// Refused before the repository
+	router := lockRouter(DocumentHandler{})
+
+	for _, path := range []string{"/document/d-1/lock", "/document/d-1/unlock"} {
+		if rec := as(router, "u-1", http.MethodPost, path, ""); rec.Code != http.StatusBadRequest || errorCode(rec) != apierror.CodeInvalidID {
+			t.Errorf("%s: status %d %s, want 400 %s", path, rec.Code, rec.Body, apierror.CodeInvalidID)
+		}
+		if rec := as(router, "", http.MethodPost, path, ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s anonymously: status %d, want 401", path, rec.Code)
+		}
+	}
+}
+
+func TestLockingIsIdempotentAndFreezesContent(t *testing.T) {
+	users := fakeDirectory{}
+	owner, collaborator := users.newUserID("owner"), users.newUserID("collaborator")
+	h := testHandler(t, users)
+	router := lockRouter(h)
+
+	ctx := tenantContext()
+	document, err := h.DocumentRepository.CreateNewDocument(ctx, "Plan", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := document.ID.Hex()
+	if _, err := h.DocumentRepository.CreateCollaborationRecord(ctx, collaborator, id, "write"); err != nil {
+		t.Fatal(err)
+	}
+	content := fmt.Sprintf(`{"baseVersion":%d,"slides":[{"id":"s-1","objects":[]}]}`, document.Version)
+
+	if rec := as(router, collaborator, http.MethodPost, "/document/"+id+"/lock", ""); rec.Code != http.StatusForbidden && rec.Code != http.StatusNotFound {
+		t.Errorf("collaborator locking: status %d, want it refused", rec.Code)
+	}
+
+	// Locking twice answers the same lock
+	var first lockState
+	for i := range 2 {
+		rec := as(router, owner, http.MethodPost, "/document/"+id+"/lock", "")
+		var state lockState
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &state) != nil || !state.Locked || state.LockedBy != owner || state.LockedAt == "" {
+			t.Fatalf("lock %d: status %d %s, want 200 locked by the owner", i+1, rec.Code, rec.Body)
+		}
+		if i == 0 {
+			first = state
+		} else if state != first {
+			t.Errorf("locking again answered %+v, want %+v", state, first)
+		}
+	}
+
+	// Reads go on, writes are refused, for the owner too
+	for _, user := range []string{owner, collaborator} {
+		if rec := as(router, user, http.MethodGet, "/document/id/"+id, ""); rec.Code != http.StatusOK {
+			t.Errorf("%s reading the locked document: status %d, want 200", user, rec.Code)
+		}
+		if rec := as(router, user, http.MethodPut, "/document/"+id+"/content", content); rec.Code != http.StatusLocked || errorCode(rec) != apierror.CodeDocumentLocked {
+			t.Errorf("%s writing the locked document: status %d %s, want 423 %s", user, rec.Code, rec.Body, apierror.CodeDocumentLocked)
+		}
+	}
+
+	for i := range 2 {
+		rec := as(router, owner, http.MethodPost, "/document/"+id+"/unlock", "")
+		var state lockState
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &state) != nil || state != (lockState{}) {
+			t.Errorf("unlock %d: status %d %s, want 200 without a lock", i+1, rec.Code, rec.Body)
+		}
+	}
+	if rec := as(router, collaborator, http.MethodPut, "/document/"+id+"/content", content); rec.Code != http.StatusOK {
+		t.Errorf("writing once unlocked: status %d %s, want 200", rec.Code, rec.Body)
+	}
+}
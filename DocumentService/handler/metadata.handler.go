@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"document-service/repository"
+	"document-service/types"
+	"errors"
+	"net/http"
+	"shared/apierror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ================================= Metadata Handlers ==============================
+
+// UpdateDocument changes any of a document's title, description, tags,
+// folder, and archived flag in one request; the fields left out stay as they
+// are. Collaborators with write access may change the title, description,
+// and tags, only the owner the folder and the archived flag. Fields that
+// cannot be changed are refused with a 422 naming them.
+//
+// With an If-Match header holding the ETag of GetDocumentByID, the patch
+// applies only while the document is still at that version and answers 409
+// with the current version otherwise.
+//
+// Route: PATCH /document/:id
+func (h DocumentHandler) UpdateDocument(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid data format or missing fields")
+		return
+	}
+	patch, err := types.ParseDocumentPatch(body)
+	var unknown *types.UnknownFieldsError
+	switch {
+	case errors.As(err, &unknown):
+		apierror.Abort(c, http.StatusUnprocessableEntity, apierror.CodeValidationFailed, err.Error())
+		return
+	case errors.Is(err, types.ErrEmptyPatch):
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "The patch must change at least one field")
+		return
+	case err != nil:
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid data format or missing fields")
+		return
+	}
+	if err := patch.Normalize(); err != nil {
+		apierror.Abort(c, http.StatusUnprocessableEntity, apierror.CodeValidationFailed, err.Error())
+		return
+	}
+
+	var baseVersion *int64
+	if ifMatch := c.GetHeader("If-Match"); ifMatch != "" {
+		version, err := types.ParseETag(ifMatch)
+		if err != nil {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, err.Error())
+			return
+		}
+		baseVersion = &version
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+	required := repository.AccessWrite
+	if patch.OwnerOnly() {
+		required = repository.AccessOwner
+	}
+	level, ok := h.authorizeDocument(c, userId, documentId, required)
+	if !ok {
+		return
+	}
+
+	if patch.FolderID != nil && *patch.FolderID != "" {
+		exists, err := h.Folders.FolderExists(c, *patch.FolderID, userId)
+		if err != nil {
+			apierror.Internal(c, "Error updating document", err)
+			return
+		}
+		if !exists {
+			apierror.Abort(c, http.StatusNotFound, apierror.CodeFolderNotFound, "Folder not found")
+			return
+		}
+	}
+
+	document, err := h.DocumentRepository.UpdateDocumentFields(c, documentId, userId, patch, baseVersion)
+	if errors.Is(err, repository.ErrDocumentNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeDocumentNotFound, "Document not found")
+		return
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		c.Header("ETag", types.ETag(document.Version))
+		c.AbortWithStatusJSON(http.StatusConflict, types.VersionConflictResponse{
+			Error:   apierror.New(c, apierror.CodeVersionConflict, "The document changed since the version this patch is based on"),
+			Version: document.Version,
+		})
+		return
+	}
+	if err != nil {
+		apierror.Internal(c, "Error updating document", err)
+		return
+	}
+	if level != repository.AccessOwner {
+		document.FolderID = ""
+	}
+
+	c.Header("ETag", types.ETag(document.Version))
+	c.JSON(http.StatusOK, types.NewDocumentMetadataDto(*document))
+}
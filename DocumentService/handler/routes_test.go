@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"document-service/middleware"
+	"document-service/repository"
+	"net/http"
+	"net/http/httptest"
+	"shared/apierror"
+	"shared/authmw"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// parityRouter serves the RESTful delete and share routes next to the legacy
+// ones that take the document ID in the body, as main registers them.
+func parityRouter(h DocumentHandler) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.ContextWithFallback = true
+	group := router.Group("/document", authmw.Middleware(authmw.GatewayHeaders{}))
+	group.DELETE("/:id", h.DeleteDocumentByID)
+	group.POST("/delete", middleware.Deprecated("DELETE /document/:id"), h.DeleteDocument)
+	group.POST("/:id/share", h.ShareDocumentByID)
+	group.POST("/share", middleware.Deprecated("POST /document/:id/share"), h.ShareDocument)
+	group.DELETE("/:id/share/:userId", h.UnshareDocument)
+	return router
+}
+
+// routeShape is one way of asking for an action on a document.
+type routeShape struct {
+	method string
+	path   func(documentId string) string
+	body   func(documentId string) string
+	legacy bool
+}
+
+var (
+	deleteShapes = []routeShape{
+		{http.MethodDelete, func(id string) string { return "/document/" + id }, func(string) string { return "" }, false},
+		{http.MethodPost, func(string) string { return "/document/delete" }, func(id string) string { return `{"documentId":"` + id + `"}` }, true},
+	}
+	shareShapes = func(collaborator string) []routeShape {
+		return []routeShape{
+			{http.MethodPost, func(id string) string { return "/document/" + id + "/share" }, func(string) string {
+				return `{"collaboratorUserId":"` + collaborator + `","accessType":"write"}`
+			}, false},
+			{http.MethodPost, func(string) string { return "/document/share" }, func(id string) string {
+				return `{"documentId":"` + id + `","collaboratorUserId":"` + collaborator + `","accessType":"write"}`
+			}, true},
+		}
+	}
+)
+
+func (s routeShape) send(router *gin.Engine, userId string, documentId string) *httptest.ResponseRecorder {
+	return as(router, userId, s.method, s.path(documentId), s.body(documentId))
+}
+
+func TestGarbageIDsAreRefusedOnEveryRoute(t *testing.T) {
+	// Refused before the repository, rather than failing in it
+	router := parityRouter(DocumentHandler{})
+	shapes := append(append([]routeShape{}, deleteShapes...), shareShapes(primitive.NewObjectID().Hex())...)
+
+	for _, shape := range shapes {
+		for _, id := range []string{"garbage", "65a1f0c2e4b0a1b2c3d4e5f", "65a1f0c2e4b0a1b2c3d4e5fz"} {
+			rec := shape.send(router, "u-1", id)
+			if rec.Code != http.StatusBadRequest || errorCode(rec) != apierror.CodeInvalidID {
+				t.Errorf("%s %s with %q: status %d %s, want 400 %s", shape.method, shape.path(id), id, rec.Code, rec.Body, apierror.CodeInvalidID)
+			}
+			if deprecated := rec.Header().Get("Deprecation") == "true"; deprecated != shape.legacy {
+				t.Errorf("%s %s: Deprecation header %v, want %v", shape.method, shape.path(id), deprecated, shape.legacy)
+			}
+		}
+	}
+
+	rec := as(router, "u-1", http.MethodDelete, "/document/"+primitive.NewObjectID().Hex()+"/share/garbage", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unsharing with a garbage user ID: status %d %s, want 400", rec.Code, rec.Body)
+	}
+}
+
+func TestDeleteRoutesAgree(t *testing.T) {
+	users := fakeDirectory{}
+	owner, stranger := users.newUserID("owner"), users.newUserID("stranger")
+	h := testHandler(t, users)
+	router := parityRouter(h)
+
+	for i, shape := range deleteShapes {
+		document, err := h.DocumentRepository.CreateNewDocument(tenantContext(), "Plan", owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := document.ID.Hex()
+
+		if rec := shape.send(router, stranger, id); rec.Code != http.StatusForbidden {
+			t.Errorf("shape %d: stranger deleting: status %d %s, want 403", i, rec.Code, rec.Body)
+		}
+		if rec := shape.send(router, owner, primitive.NewObjectID().Hex()); rec.Code != http.StatusNotFound || errorCode(rec) != apierror.CodeDocumentNotFound {
+			t.Errorf("shape %d: deleting a missing document: status %d %s, want 404", i, rec.Code, rec.Body)
+		}
+
+		want := http.StatusNoContent
+		if shape.legacy {
+			want = http.StatusOK
+		}
+		if rec := shape.send(router, owner, id); rec.Code != want {
+			t.Errorf("shape %d: owner deleting: status %d %s, want %d", i, rec.Code, rec.Body, want)
+		}
+		trashed, _, err := h.DocumentRepository.FindTrashedDocuments(tenantContext(), owner, repository.ListOptions{})
+		if err != nil || len(trashed) != i+1 {
+			t.Errorf("shape %d: trash holds %d documents, %v, want %d", i, len(trashed), err, i+1)
+		}
+	}
+}
+
+func TestShareRoutesAgree(t *testing.T) {
+	users := fakeDirectory{}
+	owner, collaborator, stranger := users.newUserID("owner"), users.newUserID("collaborator"), users.newUserID("stranger")
+	h := testHandler(t, users)
+	router := parityRouter(h)
+
+	for i, shape := range shareShapes(collaborator) {
+		document, err := h.DocumentRepository.CreateNewDocument(tenantContext(), "Plan", owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := document.ID.Hex()
+
+		if rec := shape.send(router, stranger, id); rec.Code != http.StatusForbidden {
+			t.Errorf("shape %d: stranger sharing: status %d %s, want 403", i, rec.Code, rec.Body)
+		}
+		want := http.StatusNoContent
+		if shape.legacy {
+			want = http.StatusOK
+		}
+		if rec := shape.send(router, owner, id); rec.Code != want {
+			t.Fatalf("shape %d: owner sharing: status %d %s, want %d", i, rec.Code, rec.Body, want)
+		}
+		records, err := h.DocumentRepository.FindCollaboratorsByDocumentID(tenantContext(), id)
+		if err != nil || len(records) != 1 || records[0].UserID != collaborator || records[0].AccessType != "write" {
+			t.Errorf("shape %d: records %+v, %v, want the collaborator with write access", i, records, err)
+		}
+
+		// Either way, the share is revoked the RESTful way
+		if rec := as(router, owner, http.MethodDelete, "/document/"+id+"/share/"+collaborator, ""); rec.Code != http.StatusNoContent {
+			t.Errorf("shape %d: unsharing: status %d %s, want 204", i, rec.Code, rec.Body)
+		}
+		if records, _ := h.DocumentRepository.FindCollaboratorsByDocumentID(tenantContext(), id); len(records) != 0 {
+			t.Errorf("shape %d: records after unsharing %+v", i, records)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"document-service/repository"
+	"document-service/types"
+	"errors"
+	"net/http"
+	"shared/apierror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ================================= Tag Handlers ==============================
+
+// GetTags lists the tags on the documents the user owns or that are shared
+// with them, with how many documents carry each, for autocomplete.
+//
+// Route: GET /document/tags
+func (h DocumentHandler) GetTags(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	tags, err := h.DocumentRepository.FindTags(c, userId)
+	if err != nil {
+		apierror.Internal(c, "Error retrieving tags", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tags)
+}
+
+// AddTags tags a document. Tags are trimmed and lowercased; invalid tags, or
+// more than a document may carry, answer 422. The owner and collaborators with
+// write access may tag it.
+//
+// Route: POST /document/:id/tags
+func (h DocumentHandler) AddTags(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	var data types.AddTagsData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid data format or missing fields")
+		return
+	}
+	if err := data.Normalize(); err != nil {
+		apierror.Abort(c, http.StatusUnprocessableEntity, apierror.CodeValidationFailed, err.Error())
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := h.authorizeDocument(c, userId, documentId, repository.AccessWrite); !ok {
+		return
+	}
+
+	tags, err := h.DocumentRepository.AddTags(c, documentId, data.Tags)
+	if errors.Is(err, repository.ErrTooManyTags) {
+		apierror.Abort(c, http.StatusUnprocessableEntity, apierror.CodeValidationFailed, err.Error())
+		return
+	}
+	if errors.Is(err, repository.ErrDocumentNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeDocumentNotFound, "Document not found")
+		return
+	}
+	if err != nil {
+		apierror.Internal(c, "Error tagging document", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, types.TagsResponse{Tags: tags})
+}
+
+// RemoveTag removes a tag from a document. The owner and collaborators with
+// write access may remove it.
+//
+// Route: DELETE /document/:id/tags/:tag
+func (h DocumentHandler) RemoveTag(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	tag, err := types.NormalizeTag(c.Param("tag"))
+	if err != nil {
+		apierror.Abort(c, http.StatusUnprocessableEntity, apierror.CodeValidationFailed, err.Error())
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := h.authorizeDocument(c, userId, documentId, repository.AccessWrite); !ok {
+		return
+	}
+
+	tags, err := h.DocumentRepository.RemoveTag(c, documentId, tag)
+	if errors.Is(err, repository.ErrDocumentNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeDocumentNotFound, "Document not found")
+		return
+	}
+	if err != nil {
+		apierror.Internal(c, "Error removing tag", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, types.TagsResponse{Tags: tags})
+}
@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"document-service/config"
+	"document-service/repository"
+	"document-service/types"
+	"errors"
+	"fmt"
+	"net/http"
+	"shared/apierror"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ================================= Version History Handlers ==============================
+
+// GetVersions lists a document's version history, newest first, paged with
+// ?limit= and ?offset=. Anyone who may read the document may list it.
+//
+// Route: GET /document/:id/versions
+func (h DocumentHandler) GetVersions(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+
+	opts, offset, _, ok := parseListQuery(c)
+	if !ok {
+		return
+	}
+
+	if _, ok := h.authorizeDocument(c, userId, documentId, repository.AccessRead); !ok {
+		return
+	}
+
+	snapshots, total, err := h.History.FindVersions(c, documentId, opts.Limit, offset)
+	if err != nil {
+		apierror.Internal(c, "Error retrieving versions", err)
+		return
+	}
+
+	versions := make([]types.VersionDto, len(snapshots))
+	for i, snapshot := range snapshots {
+		versions[i] = types.VersionDto{
+			Version:   snapshot.Version,
+			UserID:    snapshot.UserID,
+			Title:     snapshot.Title,
+			CreatedAt: snapshot.CreatedAt,
+		}
+	}
+
+	c.JSON(http.StatusOK, types.VersionsDto{
+		Versions: versions,
+		Page:     types.NewPageInfo(total, opts.Limit, offset, len(versions)),
+	})
+}
+
+// RestoreVersion writes the content of an earlier version back as a new
+// version, so the history never rewinds and the restore can itself be undone.
+// The owner and collaborators with write access may restore. With an If-Match
+// header the restore only applies to that version, like a content update.
+//
+// Route: POST /document/:id/versions/:version/restore
+func (h DocumentHandler) RestoreVersion(c *gin.Context) {
+	userId, ok := getAuthUserID(c)
+	if !ok {
+		return
+	}
+
+	documentId, ok := documentIDParam(c)
+	if !ok {
+		return
+	}
+
+	version, err := strconv.ParseInt(c.Param("version"), 10, 64)
+	if err != nil || version < 1 {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, "version must be a positive integer")
+		return
+	}
+
+	if _, ok := h.authorizeDocument(c, userId, documentId, repository.AccessWrite); !ok {
+		return
+	}
+
+	snapshot, err := h.History.FindSnapshot(c, documentId, version)
+	if err != nil {
+		apierror.Internal(c, "Error retrieving version", err)
+		return
+	}
+	if snapshot == nil {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeVersionNotFound, "Version not found")
+		return
+	}
+
+	// Restore on top of the version the caller saw, or else the current one
+	var baseVersion int64
+	if ifMatch := c.GetHeader("If-Match"); ifMatch != "" {
+		if baseVersion, err = types.ParseETag(ifMatch); err != nil {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, err.Error())
+			return
+		}
+	} else {
+		current, err := h.DocumentRepository.FindDocumentByID(c, documentId)
+		if err != nil {
+			apierror.Internal(c, "Error retrieving document", err)
+			return
+		}
+		if current == nil {
+			apierror.Abort(c, http.StatusNotFound, apierror.CodeDocumentNotFound, "Document not found")
+			return
+		}
+		baseVersion = current.Version
+	}
+
+	document, err := h.DocumentRepository.UpdateContent(c, documentId, snapshot.Slides, userId, baseVersion)
+	if errors.Is(err, repository.ErrDocumentNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeDocumentNotFound, "Document not found")
+		return
+	}
+	if errors.Is(err, repository.ErrDocumentArchived) {
+		apierror.Abort(c, http.StatusConflict, apierror.CodeDocumentArchived, "Document is archived - Unarchive it to restore a version.")
+		return
+	}
+	if errors.Is(err, repository.ErrDocumentLocked) {
+		apierror.Abort(c, http.StatusLocked, apierror.CodeDocumentLocked, "Document is locked by its owner - Unlock it to restore a version.")
+		return
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		c.Header("ETag", types.ETag(document.Version))
+		c.AbortWithStatusJSON(http.StatusConflict, types.VersionConflictResponse{
+			Error:   apierror.New(c, apierror.CodeVersionConflict, "The document changed while restoring the version"),
+			Version: document.Version,
+		})
+		return
+	}
+	if err != nil {
+		apierror.Internal(c, "Error restoring version", err)
+		return
+	}
+
+	// The restored content is a version of its own
+	restored := *document
+	restored.Slides = snapshot.Slides
+	if err := h.History.RecordSnapshot(c, restored, userId, int64(config.HistoryConfig.MaxVersions)); err != nil {
+		fmt.Printf("[DocumentHandler][RestoreVersion] Error recording version %d of %s: %v\n", document.Version, documentId, err)
+	}
+
+	c.Header("ETag", types.ETag(document.Version))
+	c.JSON(http.StatusOK, types.ContentUpdatedResponse{ID: documentId, UpdatedAt: document.UpdatedAt, Version: document.Version})
+}
@@ -0,0 +1,122 @@
+package kafkaUtils
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// PartitionLag is the committed offset of a consumer group on one partition
+// compared with the partition's high watermark.
+type PartitionLag struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Committed int64  `json:"committed"` // -1 when the group has not committed yet
+	High      int64  `json:"high"`
+	Lag       int64  `json:"lag"`
+}
+
+// ConsumerGroupLag reports per-partition lag of group on topic without joining
+// the group, so it is safe to run against a live consumer.
+func ConsumerGroupLag(brokers string, group string, topic string, timeout time.Duration) ([]PartitionLag, error) {
+	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":  brokers,
+		"group.id":           group,
+		"enable.auto.commit": false,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	metadata, err := consumer.GetMetadata(&topic, false, int(timeout.Milliseconds()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+	topicMetadata, ok := metadata.Topics[topic]
+	if !ok || topicMetadata.Error.Code() != kafka.ErrNoError {
+		return nil, fmt.Errorf("topic %s not found", topic)
+	}
+
+	partitions := make([]kafka.TopicPartition, 0, len(topicMetadata.Partitions))
+	for _, p := range topicMetadata.Partitions {
+		partitions = append(partitions, kafka.TopicPartition{Topic: &topic, Partition: p.ID})
+	}
+
+	committed, err := consumer.Committed(partitions, int(timeout.Milliseconds()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read committed offsets: %w", err)
+	}
+
+	lags := make([]PartitionLag, 0, len(committed))
+	for _, tp := range committed {
+		low, high, err := consumer.QueryWatermarkOffsets(topic, tp.Partition, int(timeout.Milliseconds()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read watermarks of partition %d: %w", tp.Partition, err)
+		}
+
+		lag := PartitionLag{Topic: topic, Partition: tp.Partition, Committed: int64(tp.Offset), High: high}
+		if tp.Offset < 0 {
+			// Nothing committed: everything still retained is unconsumed
+			lag.Committed = -1
+			lag.Lag = high - low
+		} else {
+			lag.Lag = high - int64(tp.Offset)
+		}
+		lags = append(lags, lag)
+	}
+	return lags, nil
+}
+
+// RedriveTopic copies up to max messages from one topic to another, keeping
+// keys and headers. It reads as group and commits after each delivered copy,
+// so an interrupted run resumes where it stopped. It returns once max messages
+// were copied or no message arrived for idle.
+func RedriveTopic(ctx context.Context, p *kafka.Producer, brokers string, group string, from string, to string, max int, idle time.Duration) (int, error) {
+	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":  brokers,
+		"group.id":           group,
+		"auto.offset.reset":  "earliest",
+		"enable.auto.commit": false,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	if err := consumer.Subscribe(from, nil); err != nil {
+		return 0, fmt.Errorf("failed to subscribe to %s: %w", from, err)
+	}
+
+	copied := 0
+	for copied < max {
+		if err := ctx.Err(); err != nil {
+			return copied, err
+		}
+
+		msg, err := consumer.ReadMessage(idle)
+		if err != nil {
+			if kafkaErr, ok := err.(kafka.Error); ok && kafkaErr.Code() == kafka.ErrTimedOut {
+				return copied, nil
+			}
+			return copied, fmt.Errorf("failed to read from %s: %w", from, err)
+		}
+
+		headers := make(map[string]string, len(msg.Headers)+1)
+		for _, h := range msg.Headers {
+			headers[h.Key] = string(h.Value)
+		}
+		headers["redrivenFrom"] = from
+
+		if err := ProduceMessage(ctx, p, to, msg.Key, msg.Value, headers); err != nil {
+			return copied, err
+		}
+		if _, err := consumer.CommitMessage(msg); err != nil {
+			return copied, fmt.Errorf("failed to commit offset: %w", err)
+		}
+		copied++
+	}
+	return copied, nil
+}
@@ -0,0 +1,89 @@
+package kafkaUtils
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// ConnectProducer creates a producer and verifies the broker is reachable, retrying until it is.
+func ConnectProducer(brokers string) (*kafka.Producer, error) {
+	var producer *kafka.Producer
+	var err error
+
+	maxRetries := 30
+	retryInterval := 5 * time.Second
+
+	for i := 0; i < maxRetries; i++ {
+		fmt.Printf("Attempting to connect Producer to Kafka (Attempt %d/%d)...\n", i+1, maxRetries)
+
+		producer, err = kafka.NewProducer(&kafka.ConfigMap{
+			"bootstrap.servers":  brokers,
+			"enable.idempotence": true,
+		})
+
+		if err == nil {
+			// NewProducer is lazy; this forces a network call.
+			_, err = producer.GetMetadata(nil, false, 5000)
+			if err == nil {
+				fmt.Println("Successfully connected Producer to Kafka!")
+				return producer, nil
+			}
+			producer.Close()
+		}
+
+		fmt.Printf("Failed to connect Producer: %v. Retrying in %v...\n", err, retryInterval)
+		time.Sleep(retryInterval)
+	}
+
+	return nil, fmt.Errorf("failed to connect producer after %d attempts: %w", maxRetries, err)
+}
+
+// ProduceMessage produces a keyed message and blocks until its delivery report arrives or ctx ends.
+func ProduceMessage(ctx context.Context, p *kafka.Producer, topic string, key []byte, value []byte, headers map[string]string) error {
+	kafkaHeaders := make([]kafka.Header, 0, len(headers))
+	for k, v := range headers {
+		kafkaHeaders = append(kafkaHeaders, kafka.Header{Key: k, Value: []byte(v)})
+	}
+
+	kafkaMessage := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            key,
+		Value:          value,
+		Headers:        kafkaHeaders,
+	}
+
+	deliveryChan := make(chan kafka.Event, 1)
+	if err := p.Produce(kafkaMessage, deliveryChan); err != nil {
+		return fmt.Errorf("failed to produce message: %w", err)
+	}
+
+	var e kafka.Event
+	select {
+	case e = <-deliveryChan:
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for delivery report: %w", ctx.Err())
+	}
+
+	m, ok := e.(*kafka.Message)
+	if !ok {
+		return fmt.Errorf("unexpected delivery event: %v", e)
+	}
+
+	if m.TopicPartition.Error != nil {
+		return fmt.Errorf("delivery failed: %w", m.TopicPartition.Error)
+	}
+
+	return nil
+}
+
+// Publisher adapts a producer to the outbox relay.
+type Publisher struct {
+	Producer *kafka.Producer
+}
+
+func (p Publisher) Publish(ctx context.Context, topic string, key []byte, value []byte, headers map[string]string) error {
+	return ProduceMessage(ctx, p.Producer, topic, key, value, headers)
+}
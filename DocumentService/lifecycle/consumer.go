@@ -0,0 +1,117 @@
+// Package lifecycle consumes user lifecycle events and cleans up the
+// documents they affect, and purges documents left in the trash.
+package lifecycle
+
+import (
+	"context"
+	"document-service/repository"
+	"errors"
+	"fmt"
+	"log"
+	"shared/events"
+	"shared/tenant"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+const (
+	minRetryDelay = time.Second
+	maxRetryDelay = 30 * time.Second
+)
+
+// Consumer reads the user events topic. Offsets are committed only after an
+// event was handled, so a crash or failed cleanup is retried rather than lost.
+type Consumer struct {
+	consumer   *kafka.Consumer
+	dispatcher *events.Dispatcher
+}
+
+func NewConsumer(consumer *kafka.Consumer, documents *repository.DocumentRepository, folders *repository.FolderRepository, activity *repository.ActivityRepository) *Consumer {
+	dispatcher := events.NewDispatcher().
+		Handle(events.UserDeleted, func(ctx context.Context, env events.Envelope, e events.Event) error {
+			deleted := e.(events.UserDeletedEvent)
+			ctx = tenant.WithID(ctx, tenant.Normalize(deleted.TenantID))
+
+			documentCount, shareCount, err := documents.DeleteUserData(ctx, deleted.UserID)
+			if err != nil {
+				return err
+			}
+			folderCount, err := folders.DeleteUserFolders(ctx, deleted.UserID)
+			if err != nil {
+				return err
+			}
+			activityCount, err := activity.DeleteUserActivity(ctx, deleted.UserID)
+			if err != nil {
+				return err
+			}
+			log.Printf("[Lifecycle] Cleaned up user %s: %d documents deleted, %d shares revoked, %d folders deleted, %d activity rows deleted", deleted.UserID, documentCount, shareCount, folderCount, activityCount)
+			return nil
+		}).
+		Ignore(events.UserCreated)
+
+	return &Consumer{consumer: consumer, dispatcher: dispatcher}
+}
+
+// Run subscribes to topic and handles events until ctx is cancelled.
+func (c *Consumer) Run(ctx context.Context, topic string) error {
+	if missing := c.dispatcher.Unhandled(topic); len(missing) > 0 {
+		return fmt.Errorf("no handler registered for %v", missing)
+	}
+
+	if err := c.consumer.SubscribeTopics([]string{topic}, nil); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", topic, err)
+	}
+	log.Printf("[Lifecycle] Subscribed to %s", topic)
+
+	for ctx.Err() == nil {
+		ev := c.consumer.Poll(100)
+
+		switch e := ev.(type) {
+		case *kafka.Message:
+			c.handle(ctx, e)
+		case kafka.Error:
+			log.Printf("[Lifecycle] Kafka error: %v (Code: %d)", e, e.Code())
+			if e.IsFatal() {
+				return e
+			}
+		}
+	}
+
+	return nil
+}
+
+// handle dispatches one message, retrying with backoff until it succeeds or
+// ctx ends, then commits its offset. Undecodable events are logged and skipped.
+func (c *Consumer) handle(ctx context.Context, msg *kafka.Message) {
+	delay := minRetryDelay
+	for {
+		err := c.dispatch(ctx, msg.Value)
+		if err == nil {
+			break
+		}
+		if errors.Is(err, events.ErrMalformedEvent) || errors.Is(err, events.ErrUnknownEvent) || errors.Is(err, events.ErrInvalidEvent) {
+			log.Printf("[Lifecycle] Skipping undecodable event at %v: %v", msg.TopicPartition, err)
+			break
+		}
+
+		log.Printf("[Lifecycle] Handling event at %v failed, retrying in %v: %v", msg.TopicPartition, delay, err)
+		select {
+		case <-ctx.Done():
+			// Not committed, so the event is handled again after a restart
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+
+	if _, err := c.consumer.CommitMessage(msg); err != nil {
+		log.Printf("[Lifecycle] Error committing offset %v: %v", msg.TopicPartition, err)
+	}
+}
+
+func (c *Consumer) dispatch(ctx context.Context, value []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	return c.dispatcher.Dispatch(ctx, value)
+}
@@ -0,0 +1,31 @@
+package lifecycle
+
+import (
+	"context"
+	"document-service/repository"
+	"log"
+	"time"
+)
+
+// RunTrashPurge permanently deletes documents that have been in the trash
+// longer than retention, checking every interval until ctx is cancelled.
+// Purging is idempotent, so every instance of the service may run it.
+func RunTrashPurge(ctx context.Context, documents *repository.DocumentRepository, retention time.Duration, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := documents.PurgeTrash(ctx, time.Now().UTC().Add(-retention))
+		if err != nil {
+			log.Printf("[TrashPurge] Purge failed after %d documents: %v", purged, err)
+		} else if purged > 0 {
+			log.Printf("[TrashPurge] Purged %d documents from the trash", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
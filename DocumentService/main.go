@@ -2,74 +2,380 @@ package main
 
 import (
 	"context"
+	"document-service/activity"
+	"document-service/authclient"
+	"document-service/cache"
 	"document-service/config"
 	"document-service/database"
 	"document-service/handler"
+	"document-service/kafkaUtils"
+	"document-service/lifecycle"
+	"document-service/metrics"
+	"document-service/middleware"
+	"document-service/outbox"
+	"document-service/ratelimit"
+	"document-service/redis"
 	"document-service/repository"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"shared/cors"
+	"shared/migrate"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// version identifies the build in /health; the Dockerfile sets it with
+// -ldflags "-X main.version=...".
+var version = "dev"
+
 func main() {
+	// Load secrets (NAME or NAME_FILE) and reload the reloadable ones on SIGHUP
+	if err := config.Load(); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	go config.Secrets.ReloadOnSIGHUP(context.Background())
+
 	// Connect to DB
 	client := database.ConnectDB(config.MongoConfig.MongoUri)
-	defer client.Disconnect(context.Background()) // Ensure DB connection closes
+
+	// Create missing indexes, and refuse to start against data the migrate tool
+	// has not brought up to date
+	indexCtx, cancelIndexes := context.WithTimeout(context.Background(), time.Minute)
+	if _, err := migrate.New(client.Database(config.MongoConfig.DatabaseName)).EnsureIndexes(indexCtx); err != nil {
+		log.Fatalf("Ensuring indexes failed: %v", err)
+	}
+	cancelIndexes()
+
+	// Connect to Redis and Kafka (used by the outbox relay)
+	redisClient := redis.NewRedisClient(config.RedisConfig.Addr, config.RedisConfig.Password)
+
+	producer, err := kafkaUtils.ConnectProducer(config.KafkaConfig.Broker)
+	if err != nil {
+		log.Fatalf("Failed to create producer: %s\n", err)
+	}
+	defer producer.Close()
 
 	// Set up Repositories
+	OutboxRepository := repository.NewOutboxRepository(
+		client,
+		config.MongoConfig.DatabaseName,
+		config.MongoConfig.OutboxCollectionName,
+		config.MongoConfig.OutboxParkedCollectionName,
+		config.KafkaConfig.DocumentEventsTopic,
+	)
 	DocumentRepository := repository.NewDocumentRepository(
 		client,
 		config.MongoConfig.DatabaseName,
 		config.MongoConfig.DocumentCollectionName,
 		config.MongoConfig.SharedDocRecordCollectionName,
+		config.MongoConfig.FavoriteCollectionName,
+		OutboxRepository,
 	)
+	HistoryRepository := repository.NewHistoryRepository(
+		client,
+		config.MongoConfig.DatabaseName,
+		config.MongoConfig.OperationCollectionName,
+		config.MongoConfig.SnapshotCollectionName,
+	)
+	ActivityRepository := repository.NewActivityRepository(
+		client,
+		config.MongoConfig.DatabaseName,
+		config.MongoConfig.ActivityCollectionName,
+	)
+	AccessRequestRepository := repository.NewAccessRequestRepository(
+		client,
+		config.MongoConfig.DatabaseName,
+		config.MongoConfig.AccessRequestCollectionName,
+	)
+	FolderRepository := repository.NewFolderRepository(
+		client,
+		config.MongoConfig.DatabaseName,
+		config.MongoConfig.FolderCollectionName,
+		config.MongoConfig.DocumentCollectionName,
+	)
+
+	// Read documents through Redis unless the cache is disabled
+	if config.CacheConfig.DocumentTTL > 0 {
+		documentCache := cache.NewDocumentCache(redisClient.Client, config.CacheConfig.DocumentTTL)
+		DocumentRepository.UseCache(documentCache)
+		FolderRepository.UseCache(documentCache)
+	}
+
+	// Start the outbox relay
+	relay := outbox.NewRelay(OutboxRepository, kafkaUtils.Publisher{Producer: producer}, redisClient, outbox.Config{
+		PollInterval: config.OutboxConfig.PollInterval,
+		BatchSize:    config.OutboxConfig.BatchSize,
+		MaxAttempts:  config.OutboxConfig.MaxAttempts,
+		LockKey:      config.OutboxConfig.LockKey,
+		LockTTL:      config.OutboxConfig.LockTTL,
+	})
+	go relay.Run(context.Background())
+
+	// Clean up after deleted users
+	lifecycleConsumer, err := kafkaUtils.ConnectConsumer(config.KafkaConfig.Broker, config.KafkaConfig.LifecycleGroupID)
+	if err != nil {
+		log.Fatalf("Failed to create lifecycle consumer: %s\n", err)
+	}
+	defer lifecycleConsumer.Close()
+	go func() {
+		if err := lifecycle.NewConsumer(lifecycleConsumer, DocumentRepository, FolderRepository, ActivityRepository).Run(context.Background(), config.KafkaConfig.UserEventsTopic); err != nil {
+			log.Fatalf("Lifecycle consumer stopped: %v", err)
+		}
+	}()
+
+	// Record document opens in the background
+	opens := activity.NewRecorder(ActivityRepository, config.ActivityConfig.BufferSize)
+	go opens.Run(context.Background())
+
+	// Purge documents that have been in the trash past the retention period
+	go lifecycle.RunTrashPurge(context.Background(), DocumentRepository, config.TrashConfig.Retention, config.TrashConfig.PurgeInterval)
 
 	// Set up Handlers
-	documentHandler := handler.DocumentHandler{DocumentRepository: DocumentRepository}
+	healthHandler := &handler.HealthHandler{MongoClient: client, Version: version}
+	documentHandler := handler.DocumentHandler{DocumentRepository: DocumentRepository, Folders: FolderRepository, History: HistoryRepository, Activity: ActivityRepository, Opens: opens, AccessRequests: AccessRequestRepository, Users: authclient.New()}
 
 	// ===============================================
 	// GIN ROUTER SETUP
 	// ===============================================
 
-	// 1. Initialize Gin Router (Default includes Logger and Recovery middleware)
-	router := gin.Default()
+	// 1. Initialize Gin Router; gin.New rather than gin.Default, requests are
+	// logged and recovered by our own middleware
+	router := gin.New()
+
+	// Let repositories read request-scoped values (tenant) through the gin.Context
+	router.ContextWithFallback = true
+
+	// 2. Give every request an ID and log it, recovering panics, then answer
+	// cross-origin requests, preflights included, before authentication
+	router.Use(middleware.RequestLogging(), middleware.Recovery(), cors.Middleware(config.CORSConfig))
 
-	// 2. Apply Custom Middleware (If needed)
-	// NOTE: If RequestLoggingMiddleware is adapted to return gin.HandlerFunc, use router.Use()
-	// For simplicity, if we assume middleware.RequestLoggingMiddleware is adapted, we would use:
-	// router.Use(middleware.RequestLoggingMiddleware)
+	// Rate limiting per user, per instance unless shared through Redis
+	var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if config.RateLimitConfig.Backend == "redis" {
+		rateLimitStore = ratelimit.NewRedisStore(redisClient.Client)
+	}
+	readLimiter := &ratelimit.Limiter{
+		Store:  rateLimitStore,
+		Prefix: "document:ratelimit:read:",
+		Rate:   ratelimit.PerMinute(config.RateLimitConfig.ReadPerMinute),
+		Burst:  config.RateLimitConfig.ReadBurst,
+	}
+	writeLimiter := &ratelimit.Limiter{
+		Store:  rateLimitStore,
+		Prefix: "document:ratelimit:write:",
+		Rate:   ratelimit.PerMinute(config.RateLimitConfig.WritePerMinute),
+		Burst:  config.RateLimitConfig.WriteBurst,
+	}
+	// Creating and sharing documents is limited more strictly, on top of writes
+	limitCreate := ratelimit.Middleware(&ratelimit.Limiter{
+		Store:  rateLimitStore,
+		Prefix: "document:ratelimit:create:",
+		Rate:   ratelimit.PerMinute(config.RateLimitConfig.CreatePerMinute),
+		Burst:  config.RateLimitConfig.CreateBurst,
+	})
 
 	// 3. Register Routes using a Group
-	documentGroup := router.Group("/document")
+	documentGroup := router.Group("/document", middleware.AuthContext(), ratelimit.ByMethod(readLimiter, writeLimiter))
 	{
 		// POST /document/create
-		documentGroup.POST("/create", documentHandler.CreateNewDocument)
+		documentGroup.POST("/create", limitCreate, documentHandler.CreateNewDocument)
 
 		// GET /document/all
 		documentGroup.GET("/all", documentHandler.GetAllDocuments)
 
-		// POST /document/share
-		documentGroup.POST("/share", documentHandler.ShareDocument)
+		// GET /document/search
+		documentGroup.GET("/search", documentHandler.SearchDocuments)
+
+		// GET /document/folders
+		documentGroup.GET("/folders", documentHandler.GetFolders)
+
+		// POST /document/folders
+		documentGroup.POST("/folders", documentHandler.CreateFolder)
+
+		// PATCH /document/folders/:folderId
+		documentGroup.PATCH("/folders/:folderId", documentHandler.RenameFolder)
+
+		// DELETE /document/folders/:folderId
+		documentGroup.DELETE("/folders/:folderId", documentHandler.DeleteFolder)
+
+		// PATCH /document/:id/folder
+		documentGroup.PATCH("/:id/folder", documentHandler.MoveDocument)
+
+		// POST /document/:id/archive
+		documentGroup.POST("/:id/archive", documentHandler.ArchiveDocument)
+
+		// POST /document/:id/unarchive
+		documentGroup.POST("/:id/unarchive", documentHandler.UnarchiveDocument)
 
-		// POST /document/delete
-		documentGroup.POST("/delete", documentHandler.DeleteDocument)
+		// POST /document/:id/lock
+		documentGroup.POST("/:id/lock", documentHandler.LockDocument)
+
+		// POST /document/:id/unlock
+		documentGroup.POST("/:id/unlock", documentHandler.UnlockDocument)
+
+		// POST /document/:id/favorite
+		documentGroup.POST("/:id/favorite", documentHandler.FavoriteDocument)
+
+		// DELETE /document/:id/favorite
+		documentGroup.DELETE("/:id/favorite", documentHandler.UnfavoriteDocument)
+
+		// GET /document/recent
+		documentGroup.GET("/recent", documentHandler.GetRecentDocuments)
+
+		// POST /document/:id/opened
+		documentGroup.POST("/:id/opened", documentHandler.RecordDocumentOpened)
+
+		// GET /document/tags
+		documentGroup.GET("/tags", documentHandler.GetTags)
+
+		// POST /document/:id/tags
+		documentGroup.POST("/:id/tags", documentHandler.AddTags)
+
+		// DELETE /document/:id/tags/:tag
+		documentGroup.DELETE("/:id/tags/:tag", documentHandler.RemoveTag)
+
+		// POST /document/:id/share
+		documentGroup.POST("/:id/share", limitCreate, documentHandler.ShareDocumentByID)
+
+		// POST /document/:id/share/bulk
+		documentGroup.POST("/:id/share/bulk", limitCreate, documentHandler.ShareDocumentBulk)
+
+		// DELETE /document/:id/share/:userId
+		documentGroup.DELETE("/:id/share/:userId", documentHandler.UnshareDocument)
+
+		// POST /document/share (deprecated)
+		documentGroup.POST("/share", middleware.Deprecated("POST /document/:id/share"), limitCreate, documentHandler.ShareDocument)
+
+		// POST /document/:id/request-access
+		documentGroup.POST("/:id/request-access", limitCreate, documentHandler.RequestAccess)
+
+		// GET /document/:id/access-requests
+		documentGroup.GET("/:id/access-requests", documentHandler.GetAccessRequests)
+
+		// POST /document/:id/access-requests/:requestId/approve
+		documentGroup.POST("/:id/access-requests/:requestId/approve", documentHandler.ApproveAccessRequest)
+
+		// POST /document/:id/access-requests/:requestId/deny
+		documentGroup.POST("/:id/access-requests/:requestId/deny", documentHandler.DenyAccessRequest)
+
+		// GET /document/:id/collaborators
+		documentGroup.GET("/:id/collaborators", documentHandler.GetCollaborators)
+
+		// DELETE /document/:id/collaborators/:userId
+		documentGroup.DELETE("/:id/collaborators/:userId", documentHandler.UnshareDocument)
+
+		// DELETE /document/:id
+		documentGroup.DELETE("/:id", documentHandler.DeleteDocumentByID)
+
+		// POST /document/delete (deprecated)
+		documentGroup.POST("/delete", middleware.Deprecated("DELETE /document/:id"), documentHandler.DeleteDocument)
+
+		// POST /document/:id/transfer
+		documentGroup.POST("/:id/transfer", documentHandler.TransferOwnership)
+
+		// POST /document/bulk-delete
+		documentGroup.POST("/bulk-delete", documentHandler.BulkDeleteDocuments)
+
+		// POST /document/import
+		documentGroup.POST("/import", limitCreate, documentHandler.ImportDocument)
+
+		// GET /document/:id/versions
+		documentGroup.GET("/:id/versions", documentHandler.GetVersions)
+
+		// POST /document/:id/versions/:version/restore
+		documentGroup.POST("/:id/versions/:version/restore", documentHandler.RestoreVersion)
+
+		// GET /document/:id/export
+		documentGroup.GET("/:id/export", documentHandler.ExportDocument)
+
+		// GET /document/trash
+		documentGroup.GET("/trash", documentHandler.GetTrash)
+
+		// POST /document/:id/restore
+		documentGroup.POST("/:id/restore", documentHandler.RestoreDocument)
+
+		// DELETE /document/:id/purge
+		documentGroup.DELETE("/:id/purge", documentHandler.PurgeDocument)
 
 		// GET /document/id/:id
 		documentGroup.GET("/id/:id", documentHandler.GetDocumentByID)
+
+		// GET /document/:id/access
+		documentGroup.GET("/:id/access", documentHandler.GetAccessLevel)
+
+		// GET /document/:id/snapshot
+		documentGroup.GET("/:id/snapshot", documentHandler.GetSnapshot)
+
+		// PATCH /document/:id
+		documentGroup.PATCH("/:id", documentHandler.UpdateDocument)
+
+		// PATCH /document/:id/title
+		documentGroup.PATCH("/:id/title", documentHandler.RenameDocument)
+
+		// PUT /document/:id/content
+		documentGroup.PUT("/:id/content", documentHandler.UpdateDocumentContent)
 	}
 
-	// Optional: Simple health check route
-	router.GET("/health", func(c *gin.Context) {
-		c.String(http.StatusOK, "OK")
-	})
+	// Probes for the orchestrator: /health is a cheap liveness check, /ready
+	// also pings MongoDB
+	router.GET("/health", healthHandler.Health)
+	router.GET("/ready", healthHandler.Ready)
+
+	// Relay and process metrics
+	router.GET("/metrics", metrics.Handler())
 
-	// 4. Start the Server
-	fmt.Println("Starting server on port 8082 with Gin...")
+	// 4. Serve until SIGINT or SIGTERM, then stop accepting connections and
+	// let in-flight requests finish before closing the database they use. A
+	// second signal kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
 
-	// Gin's router handles listening and serving
-	if err := router.Run(":8082"); err != nil {
+	listener, err := net.Listen("tcp", config.ServerConfig.Addr)
+	if err != nil {
 		log.Fatalf("Could not start server: %s\n", err.Error())
 	}
+	shutdownCtx, cancelShutdown := serve(ctx, &http.Server{Handler: router}, listener, healthHandler)
+	defer cancelShutdown()
+
+	if err := client.Disconnect(shutdownCtx); err != nil {
+		log.Printf("[Main] Error disconnecting from MongoDB: %v", err)
+	}
+	log.Println("[Main] Shutdown complete")
+}
+
+// serve serves on listener until ctx is done, then fails readiness, waits
+// DrainDelay for the load balancer to notice, stops accepting connections and
+// lets in-flight requests finish. It returns the context bounding the rest of
+// the shutdown, ShutdownTimeout from the end of the delay, for closing what
+// the requests used.
+func serve(ctx context.Context, server *http.Server, listener net.Listener, health *handler.HealthHandler) (context.Context, context.CancelFunc) {
+	go func() {
+		fmt.Printf("Starting server on %s with Gin...\n", listener.Addr())
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Could not start server: %s\n", err.Error())
+		}
+	}()
+
+	<-ctx.Done()
+
+	// Fail readiness first so the load balancer stops sending new requests
+	health.Drain()
+	if config.ServerConfig.DrainDelay > 0 {
+		log.Printf("[Main] Shutting down, waiting %s for the load balancer to notice", config.ServerConfig.DrainDelay)
+		time.Sleep(config.ServerConfig.DrainDelay)
+	}
+	log.Printf("[Main] Shutting down, draining requests for up to %s", config.ServerConfig.ShutdownTimeout)
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), config.ServerConfig.ShutdownTimeout)
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("[Main] Requests still running at the drain timeout: %v", err)
+	}
+	return shutdownCtx, cancelShutdown
 }
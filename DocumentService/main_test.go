@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"document-service/config"
+	"document-service/handler"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// readiness returns the status and body of GET /ready on addr, or 0 when the
+// connection is refused.
+func readiness(t *testing.T, addr string) (int, handler.ReadinessResponse) {
+	t.Helper()
+	var body handler.ReadinessResponse
+	resp, err := http.Get("http://" + addr + "/ready")
+	if err != nil {
+		return 0, body
+	}
+	defer resp.Body.Close()
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
+
+func TestSignalDrainsInFlightRequests(t *testing.T) {
+	saved := config.ServerConfig
+	config.ServerConfig.DrainDelay = 300 * time.Millisecond
+	config.ServerConfig.ShutdownTimeout = 5 * time.Second
+	t.Cleanup(func() { config.ServerConfig = saved })
+
+	// Readiness pings a MongoDB that is not there until shutting down
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://127.0.0.1:1").SetServerSelectionTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	health := &handler.HealthHandler{MongoClient: client}
+
+	// A request that takes until released
+	entered, release := make(chan struct{}), make(chan struct{})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ready", health.Ready)
+	router.GET("/document/slow", func(c *gin.Context) {
+		close(entered)
+		<-release
+		c.Status(http.StatusOK)
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		_, cancel := serve(ctx, &http.Server{Handler: router}, listener, health)
+		cancel()
+	}()
+
+	slow := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/document/slow")
+		if err != nil {
+			t.Errorf("in-flight request failed: %v", err)
+			close(slow)
+			return
+		}
+		slow <- resp
+	}()
+	<-entered
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	// Readiness fails at once, while connections are still accepted
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		status, body := readiness(t, addr)
+		if status == 0 {
+			t.Fatal("connections refused before readiness failed")
+		}
+		if status == http.StatusServiceUnavailable && body.Status == "shutting down" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("readiness still %d %+v after SIGTERM", status, body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// After the drain delay new connections are refused, while the slow
+	// request still runs
+	deadline = time.Now().Add(2 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("connections still accepted after the drain delay")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-stopped:
+		t.Fatal("server stopped before its in-flight request finished")
+	default:
+	}
+
+	close(release)
+	if resp, ok := <-slow; ok {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("in-flight request: status %d, want 200", resp.StatusCode)
+		}
+	}
+	select {
+	case <-stopped:
+	case <-time.After(config.ServerConfig.ShutdownTimeout):
+		t.Fatal("server did not stop once drained")
+	}
+}
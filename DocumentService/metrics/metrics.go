@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"expvar"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Outbox relay metrics, published through expvar.
+var (
+	OutboxRelayLagSeconds = expvar.NewFloat("outbox_relay_lag_seconds")
+	OutboxPending         = expvar.NewInt("outbox_pending_events")
+	OutboxPublished       = expvar.NewInt("outbox_published_total")
+	OutboxPublishFailures = expvar.NewInt("outbox_publish_failures_total")
+	OutboxParked          = expvar.NewInt("outbox_parked_total")
+	OutboxRelayLeader     = expvar.NewInt("outbox_relay_leader")
+)
+
+// Activity recorder metrics.
+var (
+	ActivityRecorded = expvar.NewInt("activity_recorded_total")
+	ActivityDropped  = expvar.NewInt("activity_dropped_total")
+)
+
+// Document cache metrics. Errors count Redis failures, each of which fell
+// back to MongoDB.
+var (
+	DocumentCacheHits   = expvar.NewInt("document_cache_hits_total")
+	DocumentCacheMisses = expvar.NewInt("document_cache_misses_total")
+	DocumentCacheErrors = expvar.NewInt("document_cache_errors_total")
+)
+
+// Handler exposes every registered expvar as JSON.
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(expvar.Handler())
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"document-service/config"
+	"log"
+	"shared/authmw"
+	"shared/httpclient"
+	"shared/jwks"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Keys under which the authenticated identity is stored on the gin.Context.
+const (
+	UserIDKey   = authmw.UserIDKey
+	UsernameKey = authmw.UsernameKey
+	TenantIDKey = authmw.TenantIDKey
+)
+
+// AuthContext authenticates every request as configured by AUTH_MODE and
+// stores the identity on the gin.Context. The tenant is also attached to the
+// request's context.Context so repositories can scope their queries with
+// tenant.FromContext.
+func AuthContext() gin.HandlerFunc {
+	return authmw.Middleware(newAuthenticator())
+}
+
+func newAuthenticator() authmw.Authenticator {
+	if config.AuthServiceConfig.Mode == config.AuthModeGateway {
+		log.Println("[AuthContext] Trusting the gateway's identity headers; the service must not be reachable directly")
+		return authmw.GatewayHeaders{}
+	}
+
+	client := httpclient.New(httpclient.Config{
+		Service:    "auth-service",
+		BaseURL:    config.AuthServiceConfig.URL,
+		Timeout:    config.AuthServiceConfig.Timeout,
+		Retry:      httpclient.DefaultRetryPolicy,
+		SigningKey: config.InternalHMACKey.Get,
+	})
+	remote := authmw.Remote{Client: client}
+	if config.AuthServiceConfig.Mode == config.AuthModeLocal {
+		return authmw.Local{
+			Verifier: jwks.NewVerifier(client, config.AuthServiceConfig.TokenLeeway),
+			Fallback: remote,
+		}
+	}
+	return remote
+}
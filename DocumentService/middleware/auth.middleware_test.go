@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"document-service/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestDirectCallerCannotNameUser is a regression test for the X-User-ID
+// header being trusted: unless AUTH_MODE is gateway, a request reaching the
+// service directly is authenticated by its token alone.
+func TestDirectCallerCannotNameUser(t *testing.T) {
+	authService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer authService.Close()
+
+	saved := config.AuthServiceConfig
+	t.Cleanup(func() { config.AuthServiceConfig = saved })
+	config.AuthServiceConfig.URL = authService.URL
+
+	for _, mode := range []string{config.AuthModeRemote, config.AuthModeLocal} {
+		config.AuthServiceConfig.Mode = mode
+
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.GET("/document/:id", AuthContext(), func(c *gin.Context) {
+			c.String(http.StatusOK, c.GetString(UserIDKey))
+		})
+		req := httptest.NewRequest(http.MethodGet, "/document/doc-1", nil)
+		req.Header.Set("X-User-ID", "victim")
+		req.Header.Set("X-Username", "victim")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s mode: spoofed X-User-ID answered %d %s, want 401", mode, rec.Code, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deprecated marks a legacy route: every call is logged, naming the route to
+// use instead, and answered with a Deprecation header, so remaining callers
+// can be found before the route is removed.
+func Deprecated(replacement string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		log.Printf("[Deprecated] %s %s called by user %s, use %s instead", c.Request.Method, c.FullPath(), c.GetString(UserIDKey), replacement)
+		c.Header("Deprecation", "true")
+		c.Next()
+	}
+}
@@ -1,35 +1,19 @@
 package middleware
 
 import (
-	"log"
-	"net/http"
-	"time"
-)
-
-func RequestLoggingMiddleware(next http.Handler) http.Handler {
-
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now() // Record the start time
+	"shared/requestlog"
 
-		// -- 1. PRE-PROCESSING (Logging) --
-		log.Printf(
-			"[%s] STARTED: %s %s from %s",
-			start.Format("2006/01/02 15:04:05"),
-			r.Method,
-			r.RequestURI,
-			r.RemoteAddr,
-		)
+	"github.com/gin-gonic/gin"
+)
 
-		// -- 2. EXECUTE THE NEXT HANDLER --
-		next.ServeHTTP(w, r) // Call the original handler function
+// RequestLogging assigns every request an ID, honoring an incoming
+// X-Request-ID, sets it on the response, and logs the request on completion.
+func RequestLogging() gin.HandlerFunc {
+	return requestlog.Middleware()
+}
 
-		// -- 3. POST-PROCESSING (Logging duration) --
-		log.Printf(
-			"[%s] COMPLETED: %s %s in %v",
-			time.Now().Format("2006/01/02 15:04:05"),
-			r.Method,
-			r.RequestURI,
-			time.Since(start),
-		)
-	})
+// Recovery answers 500 to a request whose handler panicked, logging the panic
+// with the request ID. Mount it after RequestLogging.
+func Recovery() gin.HandlerFunc {
+	return requestlog.Recovery()
 }
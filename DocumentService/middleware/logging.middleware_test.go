@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestRequestLoggingAsMounted serves a panicking handler behind the
+// middleware in the order main mounts it.
+func TestRequestLoggingAsMounted(t *testing.T) {
+	var logged bytes.Buffer
+	saved := log.Writer()
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(saved) })
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestLogging(), Recovery())
+	router.GET("/document/all", func(c *gin.Context) { c.String(http.StatusOK, "[]") })
+	router.GET("/document/panic", func(c *gin.Context) { panic("boom") })
+
+	for path, status := range map[string]int{"/document/all": http.StatusOK, "/document/panic": http.StatusInternalServerError} {
+		logged.Reset()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Request-ID", "req-1")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != status || rec.Header().Get("X-Request-ID") != "req-1" {
+			t.Errorf("%s: status %d, X-Request-ID %q, want %d req-1", path, rec.Code, rec.Header().Get("X-Request-ID"), status)
+		}
+		if want := "[Request] req-1 GET " + path + " "; !strings.Contains(logged.String(), want) {
+			t.Errorf("%s: log %q, want a line starting %q", path, logged, want)
+		}
+	}
+	if !strings.Contains(logged.String(), "[Recovery] req-1 panic serving GET /document/panic: boom") {
+		t.Errorf("panic logged as %q, want it with the request ID", logged)
+	}
+}
@@ -0,0 +1,29 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// States of an access request.
+const (
+	AccessRequestPending  = "pending"
+	AccessRequestApproved = "approved"
+	AccessRequestDenied   = "denied"
+)
+
+// AccessRequest asks a document's owner to share it with UserID. A user has
+// at most one pending request per document; decided requests are kept.
+type AccessRequest struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	DocumentID string             `bson:"documentId" json:"documentId"`
+	TenantID   string             `bson:"tenantId,omitempty" json:"tenantId,omitempty"`
+	UserID     string             `bson:"userId" json:"userId"`
+	AccessType string             `bson:"accessType" json:"accessType"`
+	Message    string             `bson:"message,omitempty" json:"message,omitempty"`
+	Status     string             `bson:"status" json:"status"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	DecidedAt  *time.Time         `bson:"decidedAt,omitempty" json:"decidedAt,omitempty"`
+	DecidedBy  string             `bson:"decidedBy,omitempty" json:"decidedBy,omitempty"`
+}
@@ -0,0 +1,17 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Activity records when a user last opened a document, over REST or by
+// joining its live session.
+type Activity struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID       string             `bson:"userId" json:"userId"`
+	DocumentID   string             `bson:"documentId" json:"documentId"`
+	TenantID     string             `bson:"tenantId,omitempty" json:"tenantId,omitempty"`
+	LastOpenedAt time.Time          `bson:"lastOpenedAt" json:"lastOpenedAt"`
+}
@@ -1,23 +1,15 @@
 package model
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
-
-type Object struct {
-	ID         string                 `bson:"_id" json:"id"`
-	Type       string                 `bson:"type" json:"type"`
-	Attributes map[string]interface{} `bson:"attributes" json:"attributes"`
-}
-
-type Slide struct {
-	// ID         string   `bson:"_id,omitemtpy" json:"id,omitempty"`
-	ID         string   `bson:"_id" json:"id"`
-	Background string   `bson:"background" json:"background"`
-	Objects    []Object `bson:"objects" json:"objects"`
-}
-
-type Document struct {
-	ID      primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	Title   string             `bson:"title" json:"title"`
-	OwnerID string             `bson:"ownerId" json:"ownerId"`
-	Slides  []Slide            `bson:"slides" json:"slides"`
-}
+import sharedmodel "shared/model"
+
+// The document shape is owned by the shared module because DocumentUpdatesConsumer
+// writes to the same collection.
+type (
+	Object   = sharedmodel.Object
+	Slide    = sharedmodel.Slide
+	Document = sharedmodel.Document
+
+	// Written by DocumentUpdatesConsumer, read here for history.
+	Operation = sharedmodel.Operation
+	Snapshot  = sharedmodel.Snapshot
+)
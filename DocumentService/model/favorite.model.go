@@ -0,0 +1,17 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Favorite marks a document a user starred. Users favorite documents they own
+// or that are shared with them; a favorite goes away with their access.
+type Favorite struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID     string             `bson:"userId" json:"userId"`
+	DocumentID string             `bson:"documentId" json:"documentId"`
+	TenantID   string             `bson:"tenantId,omitempty" json:"tenantId,omitempty"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+}
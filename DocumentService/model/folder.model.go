@@ -0,0 +1,18 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Folder groups documents of one owner. Folders nest through ParentID; a
+// folder without one is at the root.
+type Folder struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name      string             `bson:"name" json:"name"`
+	OwnerID   string             `bson:"ownerId" json:"ownerId"`
+	TenantID  string             `bson:"tenantId,omitempty" json:"tenantId,omitempty"`
+	ParentID  string             `bson:"parentId,omitempty" json:"parentId,omitempty"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
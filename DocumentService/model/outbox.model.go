@@ -0,0 +1,10 @@
+package model
+
+import sharedmodel "shared/model"
+
+const (
+	OutboxStatusPending = sharedmodel.OutboxStatusPending
+	OutboxStatusSent    = sharedmodel.OutboxStatusSent
+)
+
+type OutboxEvent = sharedmodel.OutboxEvent
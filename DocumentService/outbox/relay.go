@@ -0,0 +1,267 @@
+package outbox
+
+import (
+	"context"
+	"document-service/metrics"
+	"document-service/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"shared/events"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Publisher delivers a relayed event to the broker and returns once it is acknowledged.
+type Publisher interface {
+	Publish(ctx context.Context, topic string, key []byte, value []byte, headers map[string]string) error
+}
+
+// Locker is a distributed lock so only one DocumentService replica relays at a time.
+type Locker interface {
+	AcquireLock(ctx context.Context, key string, token string, ttl time.Duration) (bool, error)
+	RenewLock(ctx context.Context, key string, token string, ttl time.Duration) (bool, error)
+	ReleaseLock(ctx context.Context, key string, token string) error
+}
+
+// Store is the outbox collection the relay reads pending events from;
+// *repository.OutboxRepository implements it.
+type Store interface {
+	FindPending(ctx context.Context, limit int64) ([]model.OutboxEvent, error)
+	MarkSent(ctx context.Context, id primitive.ObjectID) error
+	RecordFailure(ctx context.Context, id primitive.ObjectID, cause error) (int, error)
+	Park(ctx context.Context, event model.OutboxEvent) error
+	PendingStats(ctx context.Context) (int64, *time.Time, error)
+}
+
+// Config controls the relay. The lock is renewed during a batch whenever a
+// third of LockTTL passed since it last was, and a publish may take up to
+// half of it, so the lock cannot run out while a batch is being relayed.
+type Config struct {
+	PollInterval time.Duration
+	BatchSize    int64
+	MaxAttempts  int
+	LockKey      string
+	LockTTL      time.Duration
+}
+
+// maxPublishWait bounds how long a publish waits for the broker's ack.
+const maxPublishWait = 10 * time.Second
+
+// errLockLost stops a batch whose relay no longer holds the lock, so that
+// the events it has not published are left to the new leader.
+var errLockLost = errors.New("relay lock lost")
+
+// Relay polls the outbox collection and produces pending events to Kafka in order.
+type Relay struct {
+	repository Store
+	publisher  Publisher
+	locker     Locker
+	config     Config
+	token      string
+	leader     bool
+	// renewed is when the lock was last acquired or renewed
+	renewed time.Time
+}
+
+func NewRelay(r Store, publisher Publisher, locker Locker, config Config) *Relay {
+	return &Relay{
+		repository: r,
+		publisher:  publisher,
+		locker:     locker,
+		config:     config,
+		token:      primitive.NewObjectID().Hex(),
+	}
+}
+
+// Run relays events until ctx is cancelled.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.PollInterval)
+	defer ticker.Stop()
+
+	log.Printf("[OutboxRelay] Started (instance %s)", r.token)
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.resign()
+			log.Println("[OutboxRelay] Stopped")
+			return
+		case <-ticker.C:
+			r.tick(ctx)
+		}
+	}
+}
+
+func (r *Relay) tick(ctx context.Context) {
+	if !r.holdLock(ctx) {
+		return
+	}
+
+	if err := r.relayBatch(ctx); err != nil {
+		log.Printf("[OutboxRelay] Error relaying batch: %v", err)
+	}
+
+	r.updateLag(ctx)
+}
+
+// holdLock acquires or renews the relay lock, reporting whether this instance is the leader.
+func (r *Relay) holdLock(ctx context.Context) bool {
+	var ok bool
+	var err error
+
+	if r.leader {
+		ok, err = r.locker.RenewLock(ctx, r.config.LockKey, r.token, r.config.LockTTL)
+	} else {
+		ok, err = r.locker.AcquireLock(ctx, r.config.LockKey, r.token, r.config.LockTTL)
+	}
+	if err != nil {
+		log.Printf("[OutboxRelay] Lock error: %v", err)
+		ok = false
+	}
+	if ok {
+		r.renewed = time.Now()
+	}
+
+	if ok != r.leader {
+		if ok {
+			log.Println("[OutboxRelay] Acquired relay lock")
+			metrics.OutboxRelayLeader.Set(1)
+		} else {
+			log.Println("[OutboxRelay] Lost relay lock")
+			metrics.OutboxRelayLeader.Set(0)
+		}
+	}
+	r.leader = ok
+
+	return ok
+}
+
+func (r *Relay) resign() {
+	if !r.leader {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := r.locker.ReleaseLock(ctx, r.config.LockKey, r.token); err != nil {
+		log.Printf("[OutboxRelay] Error releasing lock: %v", err)
+	}
+	r.leader = false
+	metrics.OutboxRelayLeader.Set(0)
+}
+
+// keepLock renews the lock in the middle of a batch once a third of its TTL
+// passed, reporting whether this instance still holds it.
+func (r *Relay) keepLock(ctx context.Context) bool {
+	if time.Since(r.renewed) < r.config.LockTTL/3 {
+		return true
+	}
+	return r.holdLock(ctx)
+}
+
+// relayBatch publishes one batch of pending events. When an event for a document
+// fails, later events for the same document are held back so per-document order
+// is preserved; they are retried on the next tick. The batch stops as soon as
+// the lock is lost, as another replica may be relaying the same events.
+func (r *Relay) relayBatch(ctx context.Context) error {
+	events, err := r.repository.FindPending(ctx, r.config.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	blocked := make(map[string]bool)
+
+	for _, event := range events {
+		if blocked[event.AggregateID] {
+			continue
+		}
+		if !r.keepLock(ctx) {
+			return errLockLost
+		}
+
+		if err := r.publish(ctx, event); err != nil {
+			metrics.OutboxPublishFailures.Add(1)
+			blocked[event.AggregateID] = true
+			r.handleFailure(ctx, event, err)
+			continue
+		}
+
+		if err := r.repository.MarkSent(ctx, event.ID); err != nil {
+			// The event was delivered but not marked; it will be delivered again.
+			// Consumers must treat events as at-least-once.
+			log.Printf("[OutboxRelay] Error marking event %s as sent: %v", event.ID.Hex(), err)
+			blocked[event.AggregateID] = true
+			continue
+		}
+		metrics.OutboxPublished.Add(1)
+	}
+
+	return nil
+}
+
+func (r *Relay) publish(ctx context.Context, event model.OutboxEvent) error {
+	value, err := json.Marshal(events.Envelope{
+		ID:          event.ID.Hex(),
+		Type:        event.EventType,
+		AggregateID: event.AggregateID,
+		OccurredAt:  event.CreatedAt,
+		Payload:     json.RawMessage(event.Payload),
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding envelope: %w", err)
+	}
+
+	wait := maxPublishWait
+	if half := r.config.LockTTL / 2; half < wait {
+		wait = half
+	}
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	headers := map[string]string{"eventType": event.EventType, "eventId": event.ID.Hex()}
+	return r.publisher.Publish(ctx, event.Topic, []byte(event.AggregateID), value, headers)
+}
+
+func (r *Relay) handleFailure(ctx context.Context, event model.OutboxEvent, cause error) {
+	attempts, err := r.repository.RecordFailure(ctx, event.ID, cause)
+	if err != nil {
+		log.Printf("[OutboxRelay] Error recording failure for event %s: %v", event.ID.Hex(), err)
+		return
+	}
+
+	log.Printf("[OutboxRelay] Publishing event %s (%s) failed, attempt %d/%d: %v",
+		event.ID.Hex(), event.EventType, attempts, r.config.MaxAttempts, cause)
+
+	if attempts < r.config.MaxAttempts {
+		return
+	}
+
+	event.Attempts = attempts
+	event.LastError = cause.Error()
+	if err := r.repository.Park(ctx, event); err != nil {
+		log.Printf("[OutboxRelay] Error parking event %s: %v", event.ID.Hex(), err)
+		return
+	}
+
+	metrics.OutboxParked.Add(1)
+	log.Printf("[OutboxRelay] Parked event %s after %d attempts", event.ID.Hex(), attempts)
+}
+
+func (r *Relay) updateLag(ctx context.Context) {
+	count, oldest, err := r.repository.PendingStats(ctx)
+	if err != nil {
+		log.Printf("[OutboxRelay] Error reading outbox stats: %v", err)
+		return
+	}
+
+	metrics.OutboxPending.Set(count)
+	if oldest == nil {
+		metrics.OutboxRelayLagSeconds.Set(0)
+		return
+	}
+	metrics.OutboxRelayLagSeconds.Set(time.Since(*oldest).Seconds())
+}
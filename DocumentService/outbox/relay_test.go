@@ -0,0 +1,404 @@
+package outbox
+
+import (
+	"context"
+	"document-service/metrics"
+	"document-service/model"
+	"document-service/repository"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ Store = (*repository.OutboxRepository)(nil)
+
+// memStore is an outbox held in memory, in insertion order.
+type memStore struct {
+	mu     sync.Mutex
+	events []model.OutboxEvent
+	parked []model.OutboxEvent
+}
+
+func (s *memStore) add(aggregateID string, eventType string, createdAt time.Time) primitive.ObjectID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := primitive.NewObjectID()
+	s.events = append(s.events, model.OutboxEvent{
+		ID:          id,
+		AggregateID: aggregateID,
+		Topic:       "document-events",
+		EventType:   eventType,
+		Payload:     "{}",
+		Status:      model.OutboxStatusPending,
+		CreatedAt:   createdAt,
+	})
+	return id
+}
+
+func (s *memStore) FindPending(ctx context.Context, limit int64) ([]model.OutboxEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pending []model.OutboxEvent
+	for _, e := range s.events {
+		if e.Status == model.OutboxStatusPending && int64(len(pending)) < limit {
+			pending = append(pending, e)
+		}
+	}
+	return pending, nil
+}
+
+func (s *memStore) MarkSent(ctx context.Context, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.events {
+		if s.events[i].ID == id {
+			s.events[i].Status = model.OutboxStatusSent
+		}
+	}
+	return nil
+}
+
+func (s *memStore) RecordFailure(ctx context.Context, id primitive.ObjectID, cause error) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.events {
+		if s.events[i].ID == id {
+			s.events[i].Attempts++
+			s.events[i].LastError = cause.Error()
+			return s.events[i].Attempts, nil
+		}
+	}
+	return 0, errors.New("no such event")
+}
+
+func (s *memStore) Park(ctx context.Context, event model.OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.events {
+		if s.events[i].ID == event.ID {
+			s.events = append(s.events[:i], s.events[i+1:]...)
+			break
+		}
+	}
+	s.parked = append(s.parked, event)
+	return nil
+}
+
+func (s *memStore) PendingStats(ctx context.Context) (int64, *time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var count int64
+	var oldest *time.Time
+	for _, e := range s.events {
+		if e.Status != model.OutboxStatusPending {
+			continue
+		}
+		count++
+		if oldest == nil || e.CreatedAt.Before(*oldest) {
+			createdAt := e.CreatedAt
+			oldest = &createdAt
+		}
+	}
+	return count, oldest, nil
+}
+
+func (s *memStore) status(id primitive.ObjectID) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.events {
+		if e.ID == id {
+			return e.Status
+		}
+	}
+	return ""
+}
+
+// recordingPublisher records the keys it published, failing those in fail.
+// Each publish takes delay, as when the broker is slow to ack.
+type recordingPublisher struct {
+	mu        sync.Mutex
+	published []string
+	fail      map[string]bool
+	delay     time.Duration
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, topic string, key []byte, value []byte, headers map[string]string) error {
+	time.Sleep(p.delay)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fail[string(key)] {
+		return errors.New("broker unavailable")
+	}
+	p.published = append(p.published, string(key)+":"+headers["eventType"])
+	return nil
+}
+
+// memLocker is a lock shared by the relays of a test, held for its TTL;
+// expire stands in for the TTL running out.
+type memLocker struct {
+	mu      sync.Mutex
+	holder  string
+	expires time.Time
+}
+
+func (l *memLocker) AcquireLock(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder != "" && l.holder != token && time.Now().Before(l.expires) {
+		return false, nil
+	}
+	l.holder, l.expires = token, time.Now().Add(ttl)
+	return true, nil
+}
+
+func (l *memLocker) RenewLock(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder != token || !time.Now().Before(l.expires) {
+		return false, nil
+	}
+	l.expires = time.Now().Add(ttl)
+	return true, nil
+}
+
+func (l *memLocker) ReleaseLock(ctx context.Context, key string, token string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder == token {
+		l.holder = ""
+	}
+	return nil
+}
+
+func (l *memLocker) expire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.holder = ""
+}
+
+var testConfig = Config{PollInterval: time.Hour, BatchSize: 100, MaxAttempts: 3, LockKey: "outbox", LockTTL: time.Minute}
+
+func TestRelayPublishesOnlyWhileHoldingTheLock(t *testing.T) {
+	store := &memStore{}
+	publisher := &recordingPublisher{}
+	locker := &memLocker{}
+	a := NewRelay(store, publisher, locker, testConfig)
+	b := NewRelay(store, publisher, locker, testConfig)
+	ctx := context.Background()
+
+	first := store.add("doc-1", "document.created", time.Now())
+	a.tick(ctx)
+	b.tick(ctx)
+	if !a.leader || b.leader {
+		t.Fatalf("leaders: a=%v b=%v, want only a", a.leader, b.leader)
+	}
+	if got := store.status(first); got != model.OutboxStatusSent {
+		t.Fatalf("event status = %q, want sent", got)
+	}
+
+	// a steps down, as on shutdown; b takes over
+	a.resign()
+	second := store.add("doc-1", "document.renamed", time.Now())
+	b.tick(ctx)
+	a.tick(ctx)
+	if a.leader || !b.leader {
+		t.Fatalf("leaders after resign: a=%v b=%v, want only b", a.leader, b.leader)
+	}
+	if got := store.status(second); got != model.OutboxStatusSent {
+		t.Fatalf("event status after handoff = %q, want sent", got)
+	}
+
+	// The lock expires under b, as when it stalls, and a takes it; b must
+	// notice on renewing and stop relaying
+	locker.expire()
+	a.tick(ctx)
+	third := store.add("doc-1", "document.deleted", time.Now())
+	b.tick(ctx)
+	if b.leader {
+		t.Fatal("b still leader after its lock expired")
+	}
+	if got := store.status(third); got != model.OutboxStatusPending {
+		t.Fatalf("event relayed by a relay without the lock, status %q", got)
+	}
+
+	if len(publisher.published) != 2 {
+		t.Fatalf("published %v, want each event once", publisher.published)
+	}
+}
+
+func TestRelayHoldsBackLaterEventsOfAFailedAggregate(t *testing.T) {
+	store := &memStore{}
+	publisher := &recordingPublisher{fail: map[string]bool{"doc-1": true}}
+	relay := NewRelay(store, publisher, &memLocker{}, testConfig)
+	ctx := context.Background()
+
+	failed := store.add("doc-1", "document.created", time.Now())
+	heldBack := store.add("doc-1", "document.renamed", time.Now())
+	other := store.add("doc-2", "document.created", time.Now())
+	relay.tick(ctx)
+
+	if got := store.status(failed); got != model.OutboxStatusPending {
+		t.Fatalf("failed event status = %q, want pending", got)
+	}
+	if got := store.status(heldBack); got != model.OutboxStatusPending {
+		t.Fatalf("later event of the failed document status = %q, want pending", got)
+	}
+	if got := store.status(other); got != model.OutboxStatusSent {
+		t.Fatalf("event of another document status = %q, want sent", got)
+	}
+
+	// Once the broker takes doc-1 again, its events go out in order
+	publisher.mu.Lock()
+	publisher.fail = nil
+	publisher.mu.Unlock()
+	relay.tick(ctx)
+
+	want := []string{"doc-2:document.created", "doc-1:document.created", "doc-1:document.renamed"}
+	if len(publisher.published) != len(want) {
+		t.Fatalf("published %v, want %v", publisher.published, want)
+	}
+	for i := range want {
+		if publisher.published[i] != want[i] {
+			t.Fatalf("published %v, want %v", publisher.published, want)
+		}
+	}
+}
+
+func TestRelayParksEventAfterMaxAttempts(t *testing.T) {
+	store := &memStore{}
+	publisher := &recordingPublisher{fail: map[string]bool{"doc-1": true}}
+	relay := NewRelay(store, publisher, &memLocker{}, testConfig)
+	ctx := context.Background()
+
+	poison := store.add("doc-1", "document.created", time.Now())
+	parkedBefore := metrics.OutboxParked.Value()
+
+	for i := 1; i < testConfig.MaxAttempts; i++ {
+		relay.tick(ctx)
+		if len(store.parked) != 0 {
+			t.Fatalf("event parked after %d attempts, want %d", i, testConfig.MaxAttempts)
+		}
+	}
+	relay.tick(ctx)
+
+	if len(store.parked) != 1 || store.parked[0].ID != poison {
+		t.Fatalf("parked %v, want the poison event", store.parked)
+	}
+	if store.parked[0].Attempts != testConfig.MaxAttempts || store.parked[0].LastError == "" {
+		t.Fatalf("parked event attempts %d, last error %q", store.parked[0].Attempts, store.parked[0].LastError)
+	}
+	if got := store.status(poison); got != "" {
+		t.Fatalf("parked event still in the outbox with status %q", got)
+	}
+	if got := metrics.OutboxParked.Value() - parkedBefore; got != 1 {
+		t.Fatalf("outbox_parked_total grew by %d, want 1", got)
+	}
+}
+
+func TestRelayReportsLag(t *testing.T) {
+	store := &memStore{}
+	publisher := &recordingPublisher{fail: map[string]bool{"doc-1": true}}
+	relay := NewRelay(store, publisher, &memLocker{}, testConfig)
+	ctx := context.Background()
+
+	store.add("doc-1", "document.created", time.Now().Add(-30*time.Second))
+	store.add("doc-1", "document.renamed", time.Now())
+	relay.tick(ctx)
+
+	if got := metrics.OutboxPending.Value(); got != 2 {
+		t.Fatalf("outbox_pending_events = %d, want 2", got)
+	}
+	if got := metrics.OutboxRelayLagSeconds.Value(); got < 30 || got > 60 {
+		t.Fatalf("outbox_relay_lag_seconds = %v, want the age of the oldest pending event", got)
+	}
+
+	publisher.mu.Lock()
+	publisher.fail = nil
+	publisher.mu.Unlock()
+	relay.tick(ctx)
+
+	if got := metrics.OutboxPending.Value(); got != 0 {
+		t.Fatalf("outbox_pending_events = %d once drained, want 0", got)
+	}
+	if got := metrics.OutboxRelayLagSeconds.Value(); got != 0 {
+		t.Fatalf("outbox_relay_lag_seconds = %v once drained, want 0", got)
+	}
+}
+
+// TestSlowBatchKeepsTheLock checks that a batch outlasting the lock's TTL,
+// published to a slow broker, renews the lock as it goes, so that another
+// replica cannot take it and relay the same events meanwhile.
+func TestSlowBatchKeepsTheLock(t *testing.T) {
+	store := &memStore{}
+	publisher := &recordingPublisher{delay: 20 * time.Millisecond}
+	locker := &memLocker{}
+	config := testConfig
+	config.LockTTL = 100 * time.Millisecond
+	a := NewRelay(store, publisher, locker, config)
+	b := NewRelay(store, publisher, locker, config)
+	ctx := context.Background()
+
+	const n = 20
+	for i := 0; i < n; i++ {
+		store.add("doc-1", fmt.Sprintf("document.renamed.%d", i), time.Now())
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.tick(ctx)
+	}()
+	// b keeps trying for the lock while a relays
+	for relaying := true; relaying; {
+		select {
+		case <-done:
+			relaying = false
+		case <-time.After(5 * time.Millisecond):
+			b.tick(ctx)
+		}
+	}
+
+	if b.leader {
+		t.Error("b took the lock in the middle of a's batch")
+	}
+	if len(publisher.published) != n {
+		t.Fatalf("published %d events, want each of %d once", len(publisher.published), n)
+	}
+	for i, got := range publisher.published {
+		if want := fmt.Sprintf("doc-1:document.renamed.%d", i); got != want {
+			t.Fatalf("event %d published = %s, want %s", i, got, want)
+		}
+	}
+}
+
+// TestBatchStopsOnceTheLockIsLost checks that a relay that cannot renew the
+// lock in the middle of a batch leaves the rest of it to the new leader.
+func TestBatchStopsOnceTheLockIsLost(t *testing.T) {
+	store := &memStore{}
+	publisher := &recordingPublisher{delay: 20 * time.Millisecond}
+	locker := &memLocker{}
+	config := testConfig
+	config.LockTTL = 90 * time.Millisecond
+	a := NewRelay(store, publisher, locker, config)
+	ctx := context.Background()
+
+	const n = 10
+	for i := 0; i < n; i++ {
+		store.add("doc-1", "document.renamed", time.Now())
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		locker.expire()
+	}()
+	a.tick(ctx)
+
+	if a.leader {
+		t.Error("a still leader after its lock expired")
+	}
+	if got := len(publisher.published); got == 0 || got >= n {
+		t.Errorf("published %d of %d events, want the batch stopped at the lost lock", got, n)
+	}
+}
@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryStore keeps buckets in process memory. Limits are per instance.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	sweeps  int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, rate float64, burst int, now time.Time) (bool, int, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(rate, burst, now)
+
+	b, found := s.buckets[key]
+	if !found {
+		b = &bucket{tokens: float64(burst), last: now}
+		s.buckets[key] = b
+	}
+	b.tokens = refill(b.tokens, b.last, rate, burst, now)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+		return false, 0, wait, nil
+	}
+	b.tokens--
+	return true, int(b.tokens), 0, nil
+}
+
+// refill returns the tokens of a bucket last updated at last as of now.
+func refill(tokens float64, last time.Time, rate float64, burst int, now time.Time) float64 {
+	if elapsed := now.Sub(last).Seconds(); elapsed > 0 {
+		tokens += elapsed * rate
+	}
+	return min(tokens, float64(burst))
+}
+
+// sweep occasionally drops buckets that have refilled completely, which are
+// no different from missing ones, so the map does not grow with every user
+// and IP ever seen.
+func (s *MemoryStore) sweep(rate float64, burst int, now time.Time) {
+	s.sweeps++
+	if s.sweeps < 1000 {
+		return
+	}
+	s.sweeps = 0
+
+	for key, b := range s.buckets {
+		if refill(b.tokens, b.last, rate, burst, now) >= float64(burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Package ratelimit limits requests with token buckets. Each key gets a bucket
+// of Burst tokens refilled at Rate per second, and every request takes one.
+// Buckets live in a Store, which is in memory for a single instance or Redis
+// when every replica must share the limits.
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"shared/apierror"
+	"shared/authmw"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Store keeps the token buckets.
+type Store interface {
+	// Take takes a token from the bucket of key, refilled at rate tokens per
+	// second up to burst as of now. It returns the tokens left and, when the
+	// bucket was empty, how long until the next token.
+	Take(ctx context.Context, key string, rate float64, burst int, now time.Time) (allowed bool, remaining int, retryAfter time.Duration, err error)
+}
+
+// Limiter allows requests per key at Rate per second on average, with bursts
+// of up to Burst. A zero Rate disables it.
+type Limiter struct {
+	Store  Store
+	Prefix string
+	Rate   float64
+	Burst  int
+	// Now is the clock buckets are refilled by; time.Now when nil.
+	Now func() time.Time
+}
+
+// PerMinute converts a limit in requests per minute to a Rate.
+func PerMinute(requests int) float64 {
+	return float64(requests) / 60
+}
+
+// Allow takes a token for key.
+func (l *Limiter) Allow(ctx context.Context, key string) (bool, int, time.Duration, error) {
+	if l.Rate <= 0 {
+		return true, l.Burst, 0, nil
+	}
+	now := time.Now
+	if l.Now != nil {
+		now = l.Now
+	}
+	return l.Store.Take(ctx, l.Prefix+key, l.Rate, max(l.Burst, 1), now())
+}
+
+// Middleware limits requests by the authenticated user, or the client IP
+// before authentication. It sets X-RateLimit-Limit and X-RateLimit-Remaining,
+// and answers 429 with Retry-After when the bucket is empty. If the store
+// fails the request is let through rather than locking users out.
+func Middleware(l *Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if guard(c, l) {
+			c.Next()
+		}
+	}
+}
+
+// ByMethod limits reads (GET and HEAD) with read and everything else with
+// write, like Middleware.
+func ByMethod(read *Limiter, write *Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		l := write
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			l = read
+		}
+		if guard(c, l) {
+			c.Next()
+		}
+	}
+}
+
+func guard(c *gin.Context, l *Limiter) bool {
+	if l.Rate <= 0 {
+		return true
+	}
+
+	allowed, remaining, retryAfter, err := l.Allow(c.Request.Context(), key(c))
+	if err != nil {
+		log.Printf("[RateLimit] Limiter unavailable for %s: %v", l.Prefix, err)
+		return true
+	}
+
+	c.Header("X-RateLimit-Limit", strconv.Itoa(max(l.Burst, 1)))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	if !allowed {
+		seconds := max(int(math.Ceil(retryAfter.Seconds())), 1)
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		apierror.Abort(c, http.StatusTooManyRequests, apierror.CodeRateLimited, "Too many requests - Try again later.")
+		return false
+	}
+	return true
+}
+
+// key is the user the request is counted against, or its IP when it is not
+// authenticated yet.
+func key(c *gin.Context) string {
+	if userId := c.GetString(authmw.UserIDKey); userId != "" {
+		return "user:" + userId
+	}
+	return "ip:" + c.ClientIP()
+}
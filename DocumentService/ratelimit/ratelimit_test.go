@@ -0,0 +1,178 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"shared/apierror"
+	"shared/authmw"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// clock is a fake clock tests move by hand.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+// testStores returns each Store, the Redis one over a miniredis.
+func testStores(t *testing.T) map[string]Store {
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { client.Close() })
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"redis":  NewRedisStore(client),
+	}
+}
+
+// limitedRouter serves GET and POST /document behind handler, as userId when
+// set.
+func limitedRouter(userId string, handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if userId != "" {
+			c.Set(authmw.UserIDKey, userId)
+		}
+	}, handler)
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/document", ok)
+	router.POST("/document", ok)
+	return router
+}
+
+func request(router *gin.Engine, method string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, "/document", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestBucketRefills(t *testing.T) {
+	for name, store := range testStores(t) {
+		clock := &clock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+		// 3 at once, then one every 2 seconds
+		l := &Limiter{Store: store, Prefix: "create:", Rate: 0.5, Burst: 3, Now: clock.Now}
+		router := limitedRouter("u-1", Middleware(l))
+
+		for i, want := range []string{"2", "1", "0"} {
+			rec := request(router, http.MethodPost)
+			if rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Remaining") != want {
+				t.Errorf("%s: request %d: status %d, remaining %q, want 200 %s", name, i+1, rec.Code, rec.Header().Get("X-RateLimit-Remaining"), want)
+			}
+		}
+
+		rec := request(router, http.MethodPost)
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("%s: burst exceeded: status %d, want 429", name, rec.Code)
+		}
+		var body apierror.Response
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error.Code != apierror.CodeRateLimited {
+			t.Errorf("%s: 429 body %s, want %s", name, rec.Body, apierror.CodeRateLimited)
+		}
+		if got := rec.Header().Get("Retry-After"); got != "2" {
+			t.Errorf("%s: Retry-After %q, want 2", name, got)
+		}
+		if got := rec.Header().Get("X-RateLimit-Remaining"); got != "0" {
+			t.Errorf("%s: refused with %q remaining, want 0", name, got)
+		}
+
+		// Half a token is not enough
+		clock.now = clock.now.Add(time.Second)
+		if rec := request(router, http.MethodPost); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+			t.Errorf("%s: a second later: status %d, Retry-After %q, want 429 1", name, rec.Code, rec.Header().Get("Retry-After"))
+		}
+		clock.now = clock.now.Add(time.Second)
+		if rec := request(router, http.MethodPost); rec.Code != http.StatusOK {
+			t.Errorf("%s: once refilled: status %d, want 200", name, rec.Code)
+		}
+
+		// A long pause refills up to the burst, not beyond
+		clock.now = clock.now.Add(time.Hour)
+		if rec := request(router, http.MethodPost); rec.Header().Get("X-RateLimit-Remaining") != "2" {
+			t.Errorf("%s: after an hour: remaining %q, want 2", name, rec.Header().Get("X-RateLimit-Remaining"))
+		}
+	}
+}
+
+func TestBucketsAreKeyedByUserThenIP(t *testing.T) {
+	for name, store := range testStores(t) {
+		clock := &clock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+		l := &Limiter{Store: store, Prefix: "create:", Rate: 0.5, Burst: 1, Now: clock.Now}
+		handler := Middleware(l)
+
+		if rec := request(limitedRouter("u-1", handler), http.MethodPost); rec.Code != http.StatusOK {
+			t.Errorf("%s: u-1: status %d, want 200", name, rec.Code)
+		}
+		if rec := request(limitedRouter("u-1", handler), http.MethodPost); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("%s: u-1 again: status %d, want 429", name, rec.Code)
+		}
+		// Same IP, other bucket
+		if rec := request(limitedRouter("u-2", handler), http.MethodPost); rec.Code != http.StatusOK {
+			t.Errorf("%s: u-2: status %d, want 200", name, rec.Code)
+		}
+		if rec := request(limitedRouter("", handler), http.MethodPost); rec.Code != http.StatusOK {
+			t.Errorf("%s: anonymous: status %d, want 200", name, rec.Code)
+		}
+		if rec := request(limitedRouter("", handler), http.MethodPost); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("%s: anonymous again: status %d, want 429", name, rec.Code)
+		}
+	}
+}
+
+func TestByMethodSeparatesReadsFromWrites(t *testing.T) {
+	clock := &clock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	read := &Limiter{Store: store, Prefix: "read:", Rate: 1, Burst: 5, Now: clock.Now}
+	write := &Limiter{Store: store, Prefix: "write:", Rate: 1, Burst: 1, Now: clock.Now}
+	router := limitedRouter("u-1", ByMethod(read, write))
+
+	request(router, http.MethodPost)
+	if rec := request(router, http.MethodPost); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("second write: status %d, want 429", rec.Code)
+	}
+	// Writes exhausted do not hold reads back
+	for i := range 5 {
+		rec := request(router, http.MethodGet)
+		if rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Remaining") != strconv.Itoa(4-i) {
+			t.Errorf("read %d: status %d, remaining %q, want 200 %d", i+1, rec.Code, rec.Header().Get("X-RateLimit-Remaining"), 4-i)
+		}
+	}
+}
+
+// failingStore is a Store whose backend is down.
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, rate float64, burst int, now time.Time) (bool, int, time.Duration, error) {
+	return false, 0, 0, errors.New("connection refused")
+}
+
+func TestUnavailableStoreLetsRequestsThrough(t *testing.T) {
+	l := &Limiter{Store: failingStore{}, Prefix: "create:", Rate: 1, Burst: 1}
+	if rec := request(limitedRouter("u-1", Middleware(l)), http.MethodPost); rec.Code != http.StatusOK {
+		t.Errorf("status %d, want 200", rec.Code)
+	}
+}
+
+func TestZeroRateDisablesLimiter(t *testing.T) {
+	// No store to reach
+	l := &Limiter{Rate: 0, Burst: 1}
+	router := limitedRouter("u-1", Middleware(l))
+	for range 10 {
+		if rec := request(router, http.MethodPost); rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200", rec.Code)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// takeScript keeps one hash per key holding the tokens left and when they
+// were counted, in milliseconds. It refills the bucket, then either takes a
+// token or returns how long until the next one. The hash expires once the
+// bucket would be full again, as a missing bucket is a full one.
+var takeScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) * rate / 1000)
+end
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", math.max(now, ts))
+redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) * 1000 / rate) + 1000)
+return {allowed, math.floor(tokens), wait}`)
+
+// RedisStore shares buckets between every instance using the same Redis.
+type RedisStore struct {
+	Client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{Client: client}
+}
+
+func (s *RedisStore) Take(ctx context.Context, key string, rate float64, burst int, now time.Time) (bool, int, time.Duration, error) {
+	result, err := takeScript.Run(ctx, s.Client, []string{key},
+		now.UnixMilli(), rate, burst).Int64Slice()
+	if err != nil {
+		return false, 0, 0, fmt.Errorf("redis rate limit failed: %w", err)
+	}
+	if len(result) != 3 {
+		return false, 0, 0, fmt.Errorf("redis rate limit failed: unexpected reply %v", result)
+	}
+	wait := time.Duration(math.Max(float64(result[2]), 0)) * time.Millisecond
+	return result[0] == 1, int(result[1]), wait, nil
+}
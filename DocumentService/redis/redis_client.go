@@ -0,0 +1,75 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisClient struct holds the client connection
+type RedisClient struct {
+	Client *redis.Client
+}
+
+// NewRedisClient creates and tests the connection to Redis
+func NewRedisClient(addr string, password string) *RedisClient {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       0,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		log.Fatalf("Failed to connect to Redis at %s: %v", addr, err)
+	}
+
+	fmt.Printf("Successfully connected to Redis at %s\n", addr)
+	return &RedisClient{
+		Client: rdb,
+	}
+}
+
+// Only the holder of the token may extend or delete the lock.
+var renewLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// AcquireLock tries to take the lock identified by key for the given owner token.
+func (r *RedisClient) AcquireLock(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	ok, err := r.Client.SetNX(ctx, key, token, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis SETNX failed: %w", err)
+	}
+	return ok, nil
+}
+
+// RenewLock extends the lock if it is still held by token. It returns false when the lock was lost.
+func (r *RedisClient) RenewLock(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	res, err := renewLockScript.Run(ctx, r.Client, []string{key}, token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("redis lock renew failed: %w", err)
+	}
+	return res == 1, nil
+}
+
+// ReleaseLock deletes the lock if it is still held by token.
+func (r *RedisClient) ReleaseLock(ctx context.Context, key string, token string) error {
+	if err := releaseLockScript.Run(ctx, r.Client, []string{key}, token).Err(); err != nil && err != redis.Nil {
+		return fmt.Errorf("redis lock release failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"document-service/model"
+	"document-service/types"
+	"errors"
+	"fmt"
+	"shared/tenant"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrAccessRequestNotFound is returned when a document has no pending access
+// request with the given ID.
+var ErrAccessRequestNotFound = errors.New("access request not found")
+
+// AccessRequestRepository stores requests for access to documents until
+// their owners approve or deny them.
+type AccessRequestRepository struct {
+	collection *mongo.Collection
+}
+
+func NewAccessRequestRepository(client *mongo.Client, databaseName string, collection string) *AccessRequestRepository {
+	return &AccessRequestRepository{collection: client.Database(databaseName).Collection(collection)}
+}
+
+// RequestAccess records that userId asks for accessType on the document. A
+// pending request of theirs is updated rather than duplicated.
+func (r *AccessRequestRepository) RequestAccess(ctx context.Context, documentId string, userId string, accessType types.AccessType, message string) error {
+	filter := bson.M{"documentId": documentId, "userId": userId, "status": model.AccessRequestPending}
+	update := bson.M{
+		"$set": bson.M{"accessType": string(accessType), "message": message},
+		"$setOnInsert": bson.M{
+			"_id":       primitive.NewObjectID(),
+			"tenantId":  tenant.FromContext(ctx),
+			"createdAt": time.Now().UTC(),
+		},
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		_, err = r.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+		// Two concurrent first requests both try to insert; the loser retries as an update
+		if !mongo.IsDuplicateKeyError(err) {
+			break
+		}
+	}
+	if err != nil {
+		fmt.Printf("[AccessRequestRepository][RequestAccess] Error recording access request: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// FindPendingRequests returns the pending access requests of a document,
+// oldest first.
+func (r *AccessRequestRepository) FindPendingRequests(ctx context.Context, documentId string) ([]model.AccessRequest, error) {
+	filter := tenantScoped(ctx, bson.M{"documentId": documentId, "status": model.AccessRequestPending})
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}})
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		fmt.Printf("[AccessRequestRepository][FindPendingRequests] Error retrieving access requests: %v\n", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	requests := []model.AccessRequest{}
+	if err := cursor.All(ctx, &requests); err != nil {
+		fmt.Printf("[AccessRequestRepository][FindPendingRequests] Error decoding access requests: %v\n", err)
+		return nil, err
+	}
+	return requests, nil
+}
+
+// FindPendingRequest returns a pending access request of the document, or
+// ErrAccessRequestNotFound.
+func (r *AccessRequestRepository) FindPendingRequest(ctx context.Context, documentId string, requestId string) (*model.AccessRequest, error) {
+	objectId, err := primitive.ObjectIDFromHex(requestId)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var request model.AccessRequest
+	filter := tenantScoped(ctx, bson.M{"_id": objectId, "documentId": documentId, "status": model.AccessRequestPending})
+	err = r.collection.FindOne(ctx, filter).Decode(&request)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrAccessRequestNotFound
+	}
+	if err != nil {
+		fmt.Printf("[AccessRequestRepository][FindPendingRequest] Error retrieving access request: %v\n", err)
+		return nil, err
+	}
+	return &request, nil
+}
+
+// DecideRequest approves or denies a pending access request of the document
+// on behalf of decidedBy, or returns ErrAccessRequestNotFound. Approving
+// does not share the document; the caller does that first.
+func (r *AccessRequestRepository) DecideRequest(ctx context.Context, documentId string, requestId string, status string, decidedBy string) error {
+	objectId, err := primitive.ObjectIDFromHex(requestId)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	filter := tenantScoped(ctx, bson.M{"_id": objectId, "documentId": documentId, "status": model.AccessRequestPending})
+	update := bson.M{"$set": bson.M{"status": status, "decidedAt": time.Now().UTC(), "decidedBy": decidedBy}}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		fmt.Printf("[AccessRequestRepository][DecideRequest] Error deciding access request: %v\n", err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrAccessRequestNotFound
+	}
+	return nil
+}
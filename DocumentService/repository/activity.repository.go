@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"document-service/model"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ActivityRepository stores when each user last opened each document. Rows
+// are not removed with access to the document; readers only show documents
+// the user can still open.
+type ActivityRepository struct {
+	collection *mongo.Collection
+}
+
+func NewActivityRepository(client *mongo.Client, databaseName string, collection string) *ActivityRepository {
+	return &ActivityRepository{collection: client.Database(databaseName).Collection(collection)}
+}
+
+// RecordOpen sets when userId last opened the document in tenantId. An older
+// open arriving late does not move the time back.
+func (r *ActivityRepository) RecordOpen(ctx context.Context, tenantId string, userId string, documentId string, openedAt time.Time) error {
+	filter := bson.M{"userId": userId, "documentId": documentId}
+	update := bson.M{
+		"$max":         bson.M{"lastOpenedAt": openedAt},
+		"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "tenantId": tenantId},
+	}
+
+	if _, err := r.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true)); err != nil {
+		fmt.Printf("[ActivityRepository][RecordOpen] Error recording open: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// FindRecent returns the documents userId opened, most recent first, skipping
+// the first offset.
+func (r *ActivityRepository) FindRecent(ctx context.Context, userId string, limit int64, offset int64) ([]model.Activity, error) {
+	filter := tenantScoped(ctx, bson.M{"userId": userId})
+	opts := options.Find().
+		SetSort(bson.D{{Key: "lastOpenedAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(offset).
+		SetLimit(limit)
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		fmt.Printf("[ActivityRepository][FindRecent] Error retrieving activity: %v\n", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	activity := []model.Activity{}
+	if err := cursor.All(ctx, &activity); err != nil {
+		fmt.Printf("[ActivityRepository][FindRecent] Error decoding activity: %v\n", err)
+		return nil, err
+	}
+	return activity, nil
+}
+
+// FindLastOpened returns when userId last opened each of documentIds they
+// opened at all, in one query.
+func (r *ActivityRepository) FindLastOpened(ctx context.Context, userId string, documentIds []string) (map[string]time.Time, error) {
+	if len(documentIds) == 0 {
+		return map[string]time.Time{}, nil
+	}
+
+	filter := tenantScoped(ctx, bson.M{"userId": userId, "documentId": bson.M{"$in": documentIds}})
+	cursor, err := r.collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"documentId": 1, "lastOpenedAt": 1}))
+	if err != nil {
+		fmt.Printf("[ActivityRepository][FindLastOpened] Error retrieving activity: %v\n", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var activity []model.Activity
+	if err := cursor.All(ctx, &activity); err != nil {
+		fmt.Printf("[ActivityRepository][FindLastOpened] Error decoding activity: %v\n", err)
+		return nil, err
+	}
+
+	lastOpened := make(map[string]time.Time, len(activity))
+	for _, row := range activity {
+		lastOpened[row.DocumentID] = row.LastOpenedAt
+	}
+	return lastOpened, nil
+}
+
+// DeleteUserActivity deletes the activity of a deleted user and returns how
+// many rows there were.
+func (r *ActivityRepository) DeleteUserActivity(ctx context.Context, userId string) (int, error) {
+	result, err := r.collection.DeleteMany(ctx, tenantScoped(ctx, bson.M{"userId": userId}))
+	if err != nil {
+		fmt.Printf("[ActivityRepository][DeleteUserActivity] Error deleting activity: %v\n", err)
+		return 0, err
+	}
+	return int(result.DeletedCount), nil
+}
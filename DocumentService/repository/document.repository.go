@@ -2,31 +2,182 @@ package repository
 
 import (
 	"context"
+	"document-service/database"
 	"document-service/model"
+	"document-service/types"
+	"errors"
 	"fmt"
 	"log"
+	"regexp"
+	"shared/events"
+	"shared/tenant"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrDocumentNotFound is returned when a document does not exist in the caller's tenant.
+var ErrDocumentNotFound = errors.New("document not found")
+
+// ErrInvalidID is returned when an ID passed in is not an ObjectID, before
+// anything is looked up. Handlers validate IDs first, so it means a bug.
+var ErrInvalidID = errors.New("invalid ID")
+
+// ErrTooManyTags is returned when adding tags would leave a document with more
+// than types.MaxTagsPerDocument.
+var ErrTooManyTags = fmt.Errorf("a document can have at most %d tags", types.MaxTagsPerDocument)
+
+// ErrDocumentArchived is returned when changing the content of an archived
+// document.
+var ErrDocumentArchived = errors.New("document is archived")
+
+// ErrDocumentLocked is returned when changing the content of a document its
+// owner locked.
+var ErrDocumentLocked = errors.New("document is locked")
+
+// ErrVersionConflict is returned when a document's content changed since the
+// version a write was based on.
+var ErrVersionConflict = errors.New("document version conflict")
+
+// Access levels a user can have on a document, from most to least privileged.
+// AccessNone means the user may not see the document at all. Collaborators
+// have the level of their share's access type.
+const (
+	AccessOwner = "owner"
+	AccessWrite = string(types.AccessWrite)
+	AccessRead  = string(types.AccessRead)
+	AccessNone  = ""
+)
+
+// accessRank orders access levels so the strongest of several can be picked.
+var accessRank = map[string]int{AccessNone: 0, AccessRead: 1, AccessWrite: 2, AccessOwner: 3}
+
+// HasAccess reports whether level grants at least required.
+func HasAccess(level string, required string) bool {
+	return accessRank[level] >= accessRank[required]
+}
+
+// collaboratorAccess maps a share's access type to an access level. Shares
+// made before access types were checked may hold anything until the
+// normalize_access_types migration ran; whatever is not write access only
+// grants read access.
+func collaboratorAccess(accessType string) string {
+	if parsed, err := types.ParseAccessType(accessType); err == nil && parsed == types.AccessWrite {
+		return AccessWrite
+	}
+	return AccessRead
+}
+
 type DocumentRepository struct {
+	client                    *mongo.Client
 	collection                *mongo.Collection
 	sharedDocRecordCollection *mongo.Collection
+	favoriteCollection        *mongo.Collection
+	outbox                    *OutboxRepository
+	transactions              bool
+	cache                     DocumentCache
 }
 
-func NewDocumentRepository(client *mongo.Client, database string, collection string, sharedDocCollectionName string) *DocumentRepository {
-	coll := client.Database(database).Collection(collection)
-	shared := client.Database(database).Collection(sharedDocCollectionName)
+func NewDocumentRepository(client *mongo.Client, databaseName string, collection string, sharedDocCollectionName string, favoriteCollectionName string, outbox *OutboxRepository) *DocumentRepository {
+	coll := client.Database(databaseName).Collection(collection)
+	shared := client.Database(databaseName).Collection(sharedDocCollectionName)
 	return &DocumentRepository{
+		client:                    client,
 		collection:                coll,
 		sharedDocRecordCollection: shared,
+		favoriteCollection:        client.Database(databaseName).Collection(favoriteCollectionName),
+		outbox:                    outbox,
+		transactions:              database.SupportsTransactions(client),
+	}
+}
+
+// DocumentCache keeps documents read by FindDocumentByID. *cache.DocumentCache
+// implements it. It never fails: on errors Get misses and the rest do nothing.
+type DocumentCache interface {
+	Get(ctx context.Context, id string) (*model.Document, bool)
+	Set(ctx context.Context, document model.Document)
+	Invalidate(ctx context.Context, ids ...string)
+}
+
+// UseCache makes FindDocumentByID read through cache. Every method changing a
+// document invalidates it once the change is committed.
+func (r *DocumentRepository) UseCache(cache DocumentCache) {
+	r.cache = cache
+}
+
+// invalidate drops changed documents from the cache, if there is one.
+func (r *DocumentRepository) invalidate(ctx context.Context, ids ...string) {
+	if r.cache != nil {
+		r.cache.Invalidate(ctx, ids...)
 	}
 }
 
+// withTransaction runs fn inside a multi-document transaction when the deployment
+// supports it. On a standalone server fn runs directly (best-effort).
+func (r *DocumentRepository) withTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !r.transactions {
+		return fn(ctx)
+	}
+
+	session, err := r.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("error starting session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// tenantScoped adds the tenant taken from ctx to filter. Records written before
+// tenants existed carry no tenantId and are treated as part of the default tenant.
+func tenantScoped(ctx context.Context, filter bson.M) bson.M {
+	tenantID := tenant.FromContext(ctx)
+	if tenant.IsDefault(tenantID) {
+		filter["tenantId"] = bson.M{"$in": bson.A{tenantID, nil}}
+	} else {
+		filter["tenantId"] = tenantID
+	}
+	return filter
+}
+
+// notTrashed leaves documents in the trash out of filter. Trashed documents
+// can only be listed, restored, or purged.
+func notTrashed(filter bson.M) bson.M {
+	filter["deletedAt"] = nil
+	return filter
+}
+
+// FindDocumentByID returns the document, or nil when it does not exist in the
+// caller's tenant or is in the trash. With a cache it reads through it.
 func (r *DocumentRepository) FindDocumentByID(ctx context.Context, docID string) (*model.Document, error) {
+	if r.cache == nil {
+		return r.findDocument(ctx, docID)
+	}
+
+	if document, found := r.cache.Get(ctx, docID); found {
+		// The cache is shared by every tenant
+		if tenant.Normalize(document.TenantID) != tenant.FromContext(ctx) {
+			return nil, nil
+		}
+		return document, nil
+	}
+
+	document, err := r.findDocument(ctx, docID)
+	if err != nil || document == nil {
+		return document, err
+	}
+	r.cache.Set(ctx, *document)
+	return document, nil
+}
+
+// findDocument reads the document from MongoDB, bypassing the cache.
+func (r *DocumentRepository) findDocument(ctx context.Context, docID string) (*model.Document, error) {
 	// We derive a context with a timeout from the request context
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
@@ -34,11 +185,11 @@ func (r *DocumentRepository) FindDocumentByID(ctx context.Context, docID string)
 	// 1. Convert the string ID to a primitive.ObjectID
 	objectID, err := primitive.ObjectIDFromHex(docID)
 	if err != nil {
-		return nil, fmt.Errorf("invalid document ID format: %w", err)
+		return nil, ErrInvalidID
 	}
 
 	// 2. Define the filter
-	filter := bson.M{"_id": objectID}
+	filter := notTrashed(tenantScoped(ctx, bson.M{"_id": objectID}))
 
 	// 3. Execute FindOne
 	var document model.Document
@@ -64,149 +215,588 @@ func (r *DocumentRepository) FindDocumentByID(ctx context.Context, docID string)
 }
 
 func (r *DocumentRepository) CreateNewDocument(ctx context.Context, title string, ownerId string) (model.Document, error) {
+	return r.CreateDocumentWithContent(ctx, title, ownerId, []model.Slide{
+		{
+			ID:         primitive.NewObjectID().Hex(),
+			Background: "#FFFFFF",
+			// Objects:    make([]model.Object, 0, 1),
+			Objects: make([]model.Object, 0),
+		},
+	})
+}
+
+// CreateDocumentWithContent creates a document owned by ownerId with the
+// given slides, e.g. an imported one.
+func (r *DocumentRepository) CreateDocumentWithContent(ctx context.Context, title string, ownerId string, slides []model.Slide) (model.Document, error) {
+
+	// Live updates push objects into these arrays, so they must not be null
+	for i := range slides {
+		if slides[i].Objects == nil {
+			slides[i].Objects = []model.Object{}
+		}
+	}
 
 	// Create a Document
+	tenantID := tenant.FromContext(ctx)
+	now := time.Now().UTC()
 	emptyDocument := model.Document{
-		Title:   title,
-		OwnerID: ownerId,
-		// Slides:  make([]model.Slide, 0),
-		Slides: []model.Slide{
-			{
-				ID:         primitive.NewObjectID().Hex(),
-				Background: "#FFFFFF",
-				// Objects:    make([]model.Object, 0, 1),
-				Objects:    make([]model.Object, 0),
-			},
-		},
+		Title:     title,
+		OwnerID:   ownerId,
+		TenantID:  tenantID,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Slides:    slides,
 	}
 
-	// Insert Document
-	result, err := r.collection.InsertOne(ctx, emptyDocument)
+	emptyDocument.ID = primitive.NewObjectID()
+
+	// Insert Document together with its outbox event
+	err := r.withTransaction(ctx, func(ctx context.Context) error {
+		if _, err := r.collection.InsertOne(ctx, emptyDocument); err != nil {
+			return err
+		}
+
+		return r.outbox.Append(ctx, events.DocumentCreatedEvent{
+			DocumentID: emptyDocument.ID.Hex(),
+			TenantID:   tenantID,
+			OwnerID:    ownerId,
+			OccurredAt: time.Now().UTC(),
+		})
+	})
 	if err != nil {
 		return model.Document{}, err
 	}
 
-	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
-		emptyDocument.ID = oid
+	return emptyDocument, nil
+}
+
+// TrashDocument moves the owner's document to the trash, emitting a
+// document.trashed event in the same transaction. It returns
+// ErrDocumentNotFound unless ownerId owns the document and it is not in the
+// trash already.
+func (r *DocumentRepository) TrashDocument(ctx context.Context, id string, ownerId string) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
 	}
 
-	return emptyDocument, nil
+	filter := notTrashed(tenantScoped(ctx, bson.M{"_id": objectId, "ownerId": ownerId}))
+	update := bson.M{"$set": bson.M{"deletedAt": time.Now().UTC()}}
+
+	err = r.withTransaction(ctx, func(ctx context.Context) error {
+		result, err := r.collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return ErrDocumentNotFound
+		}
+
+		return r.outbox.Append(ctx, events.DocumentTrashedEvent{
+			DocumentID: id,
+			TenantID:   tenant.FromContext(ctx),
+			TrashedBy:  ownerId,
+			OccurredAt: time.Now().UTC(),
+		})
+	})
+	if err != nil && !errors.Is(err, ErrDocumentNotFound) {
+		fmt.Printf("[DocumentRepository][TrashDocument] Error trashing document: %v\n", err)
+	}
+	if err == nil {
+		r.invalidate(ctx, id)
+	}
+	return err
 }
 
-func (r *DocumentRepository) DeleteDocument(ctx context.Context, id string) error {
+// Outcomes of a document in TrashDocuments.
+const (
+	BulkDeleted   = "deleted"
+	BulkForbidden = "forbidden"
+	BulkNotFound  = "not_found"
+)
+
+// TrashDocuments moves the documents ownerId owns among ids to the trash, like
+// TrashDocument, with one query to check ownership and one update. It returns
+// the outcome of every ID: BulkDeleted, BulkForbidden when someone else owns
+// the document, or BulkNotFound.
+func (r *DocumentRepository) TrashDocuments(ctx context.Context, ids []string, ownerId string) (map[string]string, error) {
+	objectIds := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if objectId, err := primitive.ObjectIDFromHex(id); err == nil {
+			objectIds = append(objectIds, objectId)
+		}
+	}
+
+	outcomes := map[primitive.ObjectID]string{}
+	tenantID := tenant.FromContext(ctx)
+	err := r.withTransaction(ctx, func(ctx context.Context) error {
+		clear(outcomes)
+		if len(objectIds) == 0 {
+			return nil
+		}
+
+		opts := options.Find().SetProjection(bson.M{"_id": 1, "ownerId": 1})
+		cursor, err := r.collection.Find(ctx, notTrashed(tenantScoped(ctx, bson.M{"_id": bson.M{"$in": objectIds}})), opts)
+		if err != nil {
+			return err
+		}
+		documents := []model.Document{}
+		if err := cursor.All(ctx, &documents); err != nil {
+			return err
+		}
+
+		owned := []primitive.ObjectID{}
+		for _, document := range documents {
+			if document.OwnerID != ownerId {
+				outcomes[document.ID] = BulkForbidden
+				continue
+			}
+			owned = append(owned, document.ID)
+		}
+		if len(owned) == 0 {
+			return nil
+		}
+
+		filter := notTrashed(tenantScoped(ctx, bson.M{"_id": bson.M{"$in": owned}, "ownerId": ownerId}))
+		if _, err := r.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"deletedAt": time.Now().UTC()}}); err != nil {
+			return err
+		}
+
+		for _, id := range owned {
+			err := r.outbox.Append(ctx, events.DocumentTrashedEvent{
+				DocumentID: id.Hex(),
+				TenantID:   tenantID,
+				TrashedBy:  ownerId,
+				OccurredAt: time.Now().UTC(),
+			})
+			if err != nil {
+				return err
+			}
+			outcomes[id] = BulkDeleted
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("[DocumentRepository][TrashDocuments] Error trashing documents: %v\n", err)
+		return nil, err
+	}
+
+	results := make(map[string]string, len(ids))
+	trashed := []string{}
+	for _, id := range ids {
+		results[id] = BulkNotFound
+		if objectId, err := primitive.ObjectIDFromHex(id); err == nil && outcomes[objectId] != "" {
+			results[id] = outcomes[objectId]
+		}
+		if results[id] == BulkDeleted {
+			trashed = append(trashed, id)
+		}
+	}
+	r.invalidate(ctx, trashed...)
+	return results, nil
+}
+
+// RestoreDocument takes the owner's document out of the trash, emitting a
+// document.restored event in the same transaction. It returns the document
+// without its slides, or ErrDocumentNotFound unless ownerId owns the document
+// and it is in the trash.
+func (r *DocumentRepository) RestoreDocument(ctx context.Context, id string, ownerId string) (*model.Document, error) {
 	objectId, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		fmt.Printf("[DocumentRepository] Invalid document id: %v\n", err)
-		return err
+		return nil, ErrInvalidID
+	}
+
+	filter := tenantScoped(ctx, bson.M{"_id": objectId, "ownerId": ownerId, "deletedAt": bson.M{"$ne": nil}})
+	update := bson.M{"$unset": bson.M{"deletedAt": ""}}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"slides": 0})
+
+	var document model.Document
+	err = r.withTransaction(ctx, func(ctx context.Context) error {
+		if err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&document); err != nil {
+			return err
+		}
+
+		return r.outbox.Append(ctx, events.DocumentRestoredEvent{
+			DocumentID: id,
+			TenantID:   tenant.FromContext(ctx),
+			RestoredBy: ownerId,
+			OccurredAt: time.Now().UTC(),
+		})
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrDocumentNotFound
+	}
+	if err != nil {
+		fmt.Printf("[DocumentRepository][RestoreDocument] Error restoring document: %v\n", err)
+		return nil, err
+	}
+
+	r.invalidate(ctx, id)
+	return &document, nil
+}
+
+// PurgeDocument permanently deletes the owner's document, in the trash or not.
+// It returns ErrDocumentNotFound unless ownerId owns the document.
+func (r *DocumentRepository) PurgeDocument(ctx context.Context, id string, ownerId string) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	return r.purge(ctx, tenantScoped(ctx, bson.M{"_id": objectId, "ownerId": ownerId}), id)
+}
+
+// PurgeTrash permanently deletes the documents of every tenant that were moved
+// to the trash before cutoff, and returns how many it deleted.
+func (r *DocumentRepository) PurgeTrash(ctx context.Context, cutoff time.Time) (int, error) {
+	opts := options.Find().SetProjection(bson.M{"_id": 1, "tenantId": 1})
+	cursor, err := r.collection.Find(ctx, bson.M{"deletedAt": bson.M{"$lt": cutoff}}, opts)
+	if err != nil {
+		fmt.Printf("[DocumentRepository][PurgeTrash] Error retrieving trashed documents: %v\n", err)
+		return 0, err
+	}
+
+	documents := []model.Document{}
+	if err := cursor.All(ctx, &documents); err != nil {
+		fmt.Printf("[DocumentRepository][PurgeTrash] Error decoding trashed documents: %v\n", err)
+		return 0, err
+	}
+
+	purged := 0
+	for _, document := range documents {
+		// Purge each document in its own tenant, which the events carry
+		ctx := tenant.WithID(ctx, tenant.Normalize(document.TenantID))
+		filter := bson.M{"_id": document.ID, "deletedAt": bson.M{"$lt": cutoff}}
+
+		err := r.purge(ctx, filter, document.ID.Hex())
+		if errors.Is(err, ErrDocumentNotFound) {
+			// Restored or purged in the meantime
+			continue
+		}
+		if err != nil {
+			return purged, err
+		}
+		purged++
 	}
-	fmt.Printf("[DocumentRepository][FindOwnedDocuments] Error decoding documents: %v\n", err)
 
-	filter := bson.M{"_id": objectId}
+	return purged, nil
+}
 
-	// Execute Deletion
-	result, err := r.collection.DeleteOne(ctx, filter)
+// purge deletes the document matching filter together with its shares and a
+// document.deleted event, or returns ErrDocumentNotFound.
+func (r *DocumentRepository) purge(ctx context.Context, filter bson.M, id string) error {
+	err := r.withTransaction(ctx, func(ctx context.Context) error {
+		result, err := r.collection.DeleteOne(ctx, filter)
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			return ErrDocumentNotFound
+		}
+
+		if err := r.deleteShares(ctx, id); err != nil {
+			return err
+		}
+		if err := r.deleteFavorites(ctx, bson.M{"documentId": id}); err != nil {
+			return err
+		}
+
+		return r.outbox.Append(ctx, events.DocumentDeletedEvent{
+			DocumentID: id,
+			TenantID:   tenant.FromContext(ctx),
+			OccurredAt: time.Now().UTC(),
+		})
+	})
+	if errors.Is(err, ErrDocumentNotFound) {
+		return err
+	}
 	if err != nil {
 		fmt.Printf("[DocumentRepository] Error deleting document: %v\n", err)
 		return err
 	}
 
-	if result.DeletedCount == 1 {
-		fmt.Printf("[DocumentRepository] Successfully deleted 1 document with ID: %s\n", id)
+	r.invalidate(ctx, id)
+	fmt.Printf("[DocumentRepository] Successfully deleted 1 document with ID: %s\n", id)
+	return nil
+}
+
+// deleteShares removes every share of a document being deleted. Without
+// transactions the other steps of the deletion cannot be rolled back, so
+// failures are retried a few times before giving up; FindSharedDocuments
+// skips any shares left behind.
+func (r *DocumentRepository) deleteShares(ctx context.Context, documentId string) error {
+	for attempt := 1; ; attempt++ {
+		_, err := r.sharedDocRecordCollection.DeleteMany(ctx, bson.M{"documentId": documentId})
+		if err == nil || r.transactions || attempt == 3 {
+			return err
+		}
+		fmt.Printf("[DocumentRepository][deleteShares] Error deleting shares of %s (attempt %d): %v\n", documentId, attempt, err)
+		time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+	}
+}
+
+// Orders documents can be listed in.
+const (
+	SortCreatedAt = "createdAt"
+	SortUpdatedAt = "updatedAt"
+	SortTitle     = "title"
+)
+
+// ListOptions pages and orders a document listing. A zero Limit lists every
+// document. A non-empty TitleContains keeps only documents whose title
+// contains it, ignoring case. A non-empty FolderID keeps only the owned
+// documents in that folder, or in none for RootFolder; folders do not apply
+// to shared documents. A non-empty Tag keeps only documents carrying it.
+// Archived lists archived documents instead of the others. FavoritesOnly
+// keeps only the documents the listing user favorited.
+type ListOptions struct {
+	Limit         int64
+	Offset        int64
+	Sort          string
+	Descending    bool
+	TitleContains string
+	FolderID      string
+	Tag           string
+	Archived      bool
+	FavoritesOnly bool
+}
+
+// archivedScoped keeps only archived documents in filter, or only the others.
+func archivedScoped(filter bson.M, archived bool) bson.M {
+	if archived {
+		filter["archived"] = true
 	} else {
-		fmt.Printf("[DocumentRepository] No document found with ID: %s\n", id)
+		filter["archived"] = bson.M{"$ne": true}
 	}
+	return filter
+}
 
-	return nil
+// findOptions applies the options to a Find. Ties are broken by _id so pages
+// never overlap or skip documents; _id also stands in for the creation time.
+func (o ListOptions) findOptions() *options.FindOptions {
+	direction := 1
+	if o.Descending {
+		direction = -1
+	}
+
+	sort := bson.D{}
+	switch o.Sort {
+	case SortUpdatedAt:
+		sort = append(sort, bson.E{Key: "updatedAt", Value: direction})
+	case SortTitle:
+		sort = append(sort, bson.E{Key: "title", Value: direction})
+	}
+	sort = append(sort, bson.E{Key: "_id", Value: direction})
+
+	opts := options.Find().SetSort(sort).SetSkip(o.Offset)
+	if o.Limit > 0 {
+		opts.SetLimit(o.Limit)
+	}
+	return opts
 }
 
-func (r *DocumentRepository) FindOwnedDocuments(ctx context.Context, userId string) ([]model.Document, error) {
+// FindOwnedDocuments returns a page of the documents userId owns together with
+// how many they own in total.
+func (r *DocumentRepository) FindOwnedDocuments(ctx context.Context, userId string, opts ListOptions) ([]model.Document, int64, error) {
+	filter := archivedScoped(notTrashed(tenantScoped(ctx, bson.M{"ownerId": userId})), opts.Archived)
+	switch opts.FolderID {
+	case "":
+	case RootFolder:
+		filter["folderId"] = nil
+	default:
+		filter["folderId"] = opts.FolderID
+	}
+	if opts.FavoritesOnly {
+		favorites, err := r.FindFavorites(ctx, userId, nil)
+		if err != nil {
+			return []model.Document{}, 0, err
+		}
+		filter["_id"] = bson.M{"$in": favoriteIDs(favorites)}
+	}
+	return r.findPage(ctx, filter, opts, "FindOwnedDocuments")
+}
 
-	filter := bson.M{"ownerId": userId}
-	// Execute the query
-	cursor, err := r.collection.Find(ctx, filter)
+// FindTrashedDocuments returns a page of the documents userId moved to the
+// trash together with how many are in their trash in total.
+func (r *DocumentRepository) FindTrashedDocuments(ctx context.Context, userId string, opts ListOptions) ([]model.Document, int64, error) {
+	filter := tenantScoped(ctx, bson.M{"ownerId": userId, "deletedAt": bson.M{"$ne": nil}})
+	return r.findPage(ctx, filter, opts, "FindTrashedDocuments")
+}
+
+// FindSharedDocuments returns a page of the documents shared with userId
+// together with how many are shared with them in total. Each document carries
+// the access level its share grants.
+func (r *DocumentRepository) FindSharedDocuments(ctx context.Context, userId string, opts ListOptions) ([]types.DocumentDto, int64, error) {
+	access, err := r.sharedDocumentAccess(ctx, userId, "FindSharedDocuments")
 	if err != nil {
-		fmt.Printf("[DocumentRepository][FindOwnedDocuments] Error retrieving documents: %v\n", err)
-		return []model.Document{}, err
+		return []types.DocumentDto{}, 0, err
 	}
-	defer cursor.Close(ctx)
 
-	// Decode all Documents in documents slice
-	documents := []model.Document{}
-	if err = cursor.All(ctx, &documents); err != nil {
-		fmt.Printf("[DocumentRepository][FindOwnedDocuments] Error decoding documents: %v\n", err)
-		return []model.Document{}, err
+	// Get documents; shares of documents that no longer exist match nothing
+	// if ids is empty return empty slice
+	if opts.FavoritesOnly {
+		favorites, err := r.FindFavorites(ctx, userId, nil)
+		if err != nil {
+			return []types.DocumentDto{}, 0, err
+		}
+		for id := range access {
+			if !favorites[id.Hex()] {
+				delete(access, id)
+			}
+		}
+	}
+	if len(access) == 0 {
+		return []types.DocumentDto{}, 0, nil
+	}
+
+	filter := archivedScoped(notTrashed(tenantScoped(ctx, bson.M{
+		"_id": bson.M{"$in": sharedIDs(access)},
+	})), opts.Archived)
+	documents, total, err := r.findPage(ctx, filter, opts, "FindSharedDocuments")
+	if err != nil {
+		return []types.DocumentDto{}, 0, err
 	}
 
-	return documents, nil
+	shared := make([]types.DocumentDto, len(documents))
+	for i, document := range documents {
+		shared[i] = types.DocumentDto{Document: document, AccessLevel: access[document.ID]}
+	}
+	return shared, total, nil
 }
 
-func (r *DocumentRepository) FindSharedDocuments(ctx context.Context, userId string) ([]model.Document, error) {
+// FindAccessibleDocuments returns those of documentIds that userId owns or
+// that are shared with them, outside the trash, each with the caller's access
+// level. They come in no particular order.
+func (r *DocumentRepository) FindAccessibleDocuments(ctx context.Context, userId string, documentIds []string) ([]types.DocumentDto, error) {
+	ids := make([]primitive.ObjectID, 0, len(documentIds))
+	for _, id := range documentIds {
+		if objectId, err := primitive.ObjectIDFromHex(id); err == nil {
+			ids = append(ids, objectId)
+		}
+	}
+	if len(ids) == 0 {
+		return []types.DocumentDto{}, nil
+	}
+
+	access, err := r.sharedDocumentAccess(ctx, userId, "FindAccessibleDocuments")
+	if err != nil {
+		return []types.DocumentDto{}, err
+	}
+
+	filter := notTrashed(tenantScoped(ctx, bson.M{
+		"_id": bson.M{"$in": ids},
+		"$or": bson.A{
+			bson.M{"ownerId": userId},
+			bson.M{"_id": bson.M{"$in": sharedIDs(access)}},
+		},
+	}))
+	documents, _, err := r.findPage(ctx, filter, ListOptions{}, "FindAccessibleDocuments")
+	if err != nil {
+		return []types.DocumentDto{}, err
+	}
 
-	filter := bson.M{"userId": userId}
+	accessible := make([]types.DocumentDto, len(documents))
+	for i, document := range documents {
+		level := access[document.ID]
+		if document.OwnerID == userId {
+			level = AccessOwner
+		}
+		accessible[i] = types.DocumentDto{Document: document, AccessLevel: level}
+	}
+	return accessible, nil
+}
+
+// sharedDocumentAccess returns the documents shared with userId and the
+// access level each share grants them.
+func (r *DocumentRepository) sharedDocumentAccess(ctx context.Context, userId string, caller string) (map[primitive.ObjectID]string, error) {
+	filter := tenantScoped(ctx, bson.M{"userId": userId})
 
-	// Get IDs of documents shared with the current user
-	cursor, err := r.sharedDocRecordCollection.Find(ctx, filter)
+	cursor, err := r.sharedDocRecordCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"documentId": 1, "accessType": 1}))
 	if err != nil {
-		fmt.Printf("[DocumentRepository][FindSharedDocuments] Error retrieving shared document records: %v\n", err)
-		return []model.Document{}, err
+		fmt.Printf("[DocumentRepository][%s] Error retrieving shared document records: %v\n", caller, err)
+		return nil, err
 	}
 	defer cursor.Close(ctx)
 
 	var sharedDocRecords []model.CollaborationRecord
 	if err = cursor.All(ctx, &sharedDocRecords); err != nil {
-		fmt.Printf("[DocumentRepository][FindSharedDocuments] Error decoding shared document records: %v\n", err)
-		return []model.Document{}, err
+		fmt.Printf("[DocumentRepository][%s] Error decoding shared document records: %v\n", caller, err)
+		return nil, err
 	}
 
-	var ids []primitive.ObjectID
+	access := make(map[primitive.ObjectID]string, len(sharedDocRecords))
 	for _, record := range sharedDocRecords {
 		objectId, err := primitive.ObjectIDFromHex(record.DocumentID)
 		if err != nil {
 			continue
 		}
-		ids = append(ids, objectId)
+		if level := collaboratorAccess(record.AccessType); accessRank[level] > accessRank[access[objectId]] {
+			access[objectId] = level
+		}
 	}
+	return access, nil
+}
 
-	// Get documents
-	// if ids is empty return empty slice
-	if len(ids) == 0 {
-		return []model.Document{}, nil
+// sharedIDs returns the document IDs of sharedDocumentAccess's result.
+func sharedIDs(access map[primitive.ObjectID]string) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(access))
+	for id := range access {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// findPage runs a paged document query and counts every match of filter.
+func (r *DocumentRepository) findPage(ctx context.Context, filter bson.M, opts ListOptions, caller string) ([]model.Document, int64, error) {
+	if opts.TitleContains != "" {
+		filter["title"] = primitive.Regex{Pattern: regexp.QuoteMeta(opts.TitleContains), Options: "i"}
+	}
+	if opts.Tag != "" {
+		filter["tags"] = opts.Tag
 	}
 
-	filter = bson.M{
-		"_id": bson.M{"$in": ids},
+	total, err := r.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		fmt.Printf("[DocumentRepository][%s] Error counting documents: %v\n", caller, err)
+		return []model.Document{}, 0, err
 	}
 
-	cursor, err = r.collection.Find(ctx, filter)
+	// Execute the query
+	cursor, err := r.collection.Find(ctx, filter, opts.findOptions())
 	if err != nil {
-		fmt.Printf("[DocumentRepository][FindSharedDocuments] Error retrieving documents: %v\n", err)
-		return []model.Document{}, err
+		fmt.Printf("[DocumentRepository][%s] Error retrieving documents: %v\n", caller, err)
+		return []model.Document{}, 0, err
 	}
 	defer cursor.Close(ctx)
-	
-
-	documents:= []model.Document{}
 
+	// Decode all Documents in documents slice
+	documents := []model.Document{}
 	if err = cursor.All(ctx, &documents); err != nil {
-		fmt.Printf("[DocumentRepository][FindSharedDocuments] Error decoding documents: %v\n", err)
-		return []model.Document{}, nil
+		fmt.Printf("[DocumentRepository][%s] Error decoding documents: %v\n", caller, err)
+		return []model.Document{}, 0, err
 	}
 
-	return documents, nil
+	return documents, total, nil
 }
+
 func (r *DocumentRepository) IsDocumentOwnedByUser(ctx context.Context, userId string, documentId string) (bool, error) {
 
 	documentObjectId, err := primitive.ObjectIDFromHex(documentId)
 	if err != nil {
 		fmt.Printf("[DocumentRepository][IsDocumentOwnedByUser] Invalid document id: %v\n", err)
-		return false, err
+		return false, ErrInvalidID
 	}
 
 	// retrieve documents
-	filter := bson.M{"_id": documentObjectId}
+	filter := notTrashed(tenantScoped(ctx, bson.M{"_id": documentObjectId}))
 
 	var document model.Document
 	err = r.collection.FindOne(ctx, filter).Decode(&document)
+	if err == mongo.ErrNoDocuments {
+		return false, ErrDocumentNotFound
+	}
 	if err != nil {
 		fmt.Printf("[DocumentRepository][IsDocumentOwnedByUser] Error retrieving or decoding document: %v\n", err)
 		return false, err
@@ -219,25 +809,860 @@ func (r *DocumentRepository) IsDocumentOwnedByUser(ctx context.Context, userId s
 	return false, nil
 }
 
-func (r *DocumentRepository) CreateCollaborationRecord(ctx context.Context, collaboratorUserId string, documentId, accessType string) (model.CollaborationRecord, error) {
+// UpdateTitle renames the document and bumps its updatedAt, emitting a
+// document.renamed event in the same transaction. It returns the updated
+// document without its slides, or ErrDocumentNotFound.
+func (r *DocumentRepository) UpdateTitle(ctx context.Context, documentId string, title string, userId string) (*model.Document, error) {
+	documentObjectId, err := primitive.ObjectIDFromHex(documentId)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	filter := notTrashed(tenantScoped(ctx, bson.M{"_id": documentObjectId}))
+	update := bson.M{"$set": bson.M{"title": title, "updatedAt": time.Now().UTC()}}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"slides": 0})
+
+	var document model.Document
+	err = r.withTransaction(ctx, func(ctx context.Context) error {
+		if err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&document); err != nil {
+			return err
+		}
 
-	// Create shared document record object
-	sharedDocRecord := model.CollaborationRecord{
-		UserID:     collaboratorUserId,
+		return r.outbox.Append(ctx, events.DocumentRenamedEvent{
+			DocumentID: documentId,
+			TenantID:   tenant.FromContext(ctx),
+			Title:      title,
+			RenamedBy:  userId,
+			OccurredAt: time.Now().UTC(),
+		})
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrDocumentNotFound
+	}
+	if err != nil {
+		fmt.Printf("[DocumentRepository][UpdateTitle] Error renaming document: %v\n", err)
+		return nil, err
+	}
+
+	r.invalidate(ctx, documentId)
+	return &document, nil
+}
+
+// UpdateDocumentFields applies a normalized metadata patch in a single update
+// that bumps updatedAt, emitting a document.renamed event in the same
+// transaction when it changes the title. A patch of owner-only fields applies
+// only when userId owns the document. With baseVersion set, it applies only
+// while the document is still at that version. It returns the updated
+// document without its slides, ErrDocumentNotFound, or ErrVersionConflict
+// together with the current document.
+func (r *DocumentRepository) UpdateDocumentFields(ctx context.Context, documentId string, userId string, patch types.DocumentPatch, baseVersion *int64) (*model.Document, error) {
+	documentObjectId, err := primitive.ObjectIDFromHex(documentId)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	now := time.Now().UTC()
+	set := bson.M{"updatedAt": now}
+	unset := bson.M{}
+	if patch.Title != nil {
+		set["title"] = *patch.Title
+	}
+	if patch.Description != nil {
+		if *patch.Description == "" {
+			unset["description"] = ""
+		} else {
+			set["description"] = *patch.Description
+		}
+	}
+	if patch.Tags != nil {
+		if len(*patch.Tags) == 0 {
+			unset["tags"] = ""
+		} else {
+			set["tags"] = *patch.Tags
+		}
+	}
+	if patch.FolderID != nil {
+		if *patch.FolderID == "" {
+			unset["folderId"] = ""
+		} else {
+			set["folderId"] = *patch.FolderID
+		}
+	}
+	if patch.Archived != nil {
+		if *patch.Archived {
+			set["archived"] = true
+		} else {
+			unset["archived"] = ""
+		}
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	filter := bson.M{"_id": documentObjectId}
+	if patch.OwnerOnly() {
+		filter["ownerId"] = userId
+	}
+	if baseVersion != nil {
+		// Documents without a version are at version 0
+		filter["version"] = any(*baseVersion)
+		if *baseVersion == 0 {
+			filter["version"] = bson.M{"$in": bson.A{0, nil}}
+		}
+	}
+	filter = notTrashed(tenantScoped(ctx, filter))
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"slides": 0})
+
+	var document model.Document
+	err = r.withTransaction(ctx, func(ctx context.Context) error {
+		if err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&document); err != nil {
+			return err
+		}
+		if patch.Title == nil {
+			return nil
+		}
+
+		return r.outbox.Append(ctx, events.DocumentRenamedEvent{
+			DocumentID: documentId,
+			TenantID:   tenant.FromContext(ctx),
+			Title:      *patch.Title,
+			RenamedBy:  userId,
+			OccurredAt: now,
+		})
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if baseVersion == nil {
+			return nil, ErrDocumentNotFound
+		}
+
+		// Either the document is gone, not the caller's, or its version
+		// moved on; the cache may not know yet
+		current, err := r.findDocument(ctx, documentId)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil || (patch.OwnerOnly() && current.OwnerID != userId) {
+			return nil, ErrDocumentNotFound
+		}
+		current.Slides = nil
+		return current, ErrVersionConflict
+	}
+	if err != nil {
+		fmt.Printf("[DocumentRepository][UpdateDocumentFields] Error updating document: %v\n", err)
+		return nil, err
+	}
+
+	r.invalidate(ctx, documentId)
+	return &document, nil
+}
+
+// AddTags adds normalized tags to the document, keeping each tag once, and
+// returns the document's tags. It returns ErrTooManyTags when the document
+// would end up with more than types.MaxTagsPerDocument, or ErrDocumentNotFound.
+func (r *DocumentRepository) AddTags(ctx context.Context, documentId string, tags []string) ([]string, error) {
+	documentObjectId, err := primitive.ObjectIDFromHex(documentId)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	// The limit is part of the filter so concurrent additions cannot exceed it
+	filter := notTrashed(tenantScoped(ctx, bson.M{"_id": documentObjectId}))
+	limited := bson.M{}
+	for key, value := range filter {
+		limited[key] = value
+	}
+	limited["$expr"] = bson.M{"$lte": bson.A{
+		bson.M{"$size": bson.M{"$setUnion": bson.A{bson.M{"$ifNull": bson.A{"$tags", bson.A{}}}, tags}}},
+		types.MaxTagsPerDocument,
+	}}
+	update := bson.M{"$addToSet": bson.M{"tags": bson.M{"$each": tags}}}
+
+	document, err := r.updateTags(ctx, limited, update, "AddTags")
+	if !errors.Is(err, ErrDocumentNotFound) {
+		return document, err
+	}
+
+	// Tell a full document apart from a missing one
+	count, err := r.collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		fmt.Printf("[DocumentRepository][AddTags] Error looking up document: %v\n", err)
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrTooManyTags
+	}
+	return nil, ErrDocumentNotFound
+}
+
+// RemoveTag removes a tag from the document and returns the document's tags,
+// or ErrDocumentNotFound. Removing a tag the document lacks changes nothing.
+func (r *DocumentRepository) RemoveTag(ctx context.Context, documentId string, tag string) ([]string, error) {
+	documentObjectId, err := primitive.ObjectIDFromHex(documentId)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	filter := notTrashed(tenantScoped(ctx, bson.M{"_id": documentObjectId}))
+	update := bson.M{"$pull": bson.M{"tags": tag}}
+	return r.updateTags(ctx, filter, update, "RemoveTag")
+}
+
+// updateTags applies a change of tags and returns the resulting tags.
+func (r *DocumentRepository) updateTags(ctx context.Context, filter bson.M, update bson.M, caller string) ([]string, error) {
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"tags": 1})
+
+	var document model.Document
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&document)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrDocumentNotFound
+	}
+	if err != nil {
+		fmt.Printf("[DocumentRepository][%s] Error updating tags: %v\n", caller, err)
+		return nil, err
+	}
+	r.invalidate(ctx, document.ID.Hex())
+
+	if document.Tags == nil {
+		return []string{}, nil
+	}
+	return document.Tags, nil
+}
+
+// FindTags returns the distinct tags on the documents userId owns or that are
+// shared with them, with how many documents carry each, most used first.
+func (r *DocumentRepository) FindTags(ctx context.Context, userId string) ([]types.TagCountDto, error) {
+	access, err := r.sharedDocumentAccess(ctx, userId, "FindTags")
+	if err != nil {
+		return []types.TagCountDto{}, err
+	}
+
+	match := notTrashed(tenantScoped(ctx, bson.M{
+		"$or":  bson.A{bson.M{"ownerId": userId}, bson.M{"_id": bson.M{"$in": sharedIDs(access)}}},
+		"tags": bson.M{"$exists": true},
+	}))
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		fmt.Printf("[DocumentRepository][FindTags] Error aggregating tags: %v\n", err)
+		return []types.TagCountDto{}, err
+	}
+	defer cursor.Close(ctx)
+
+	tags := []types.TagCountDto{}
+	if err := cursor.All(ctx, &tags); err != nil {
+		fmt.Printf("[DocumentRepository][FindTags] Error decoding tags: %v\n", err)
+		return []types.TagCountDto{}, err
+	}
+	return tags, nil
+}
+
+// TransferOwnership makes newOwnerId the owner of the document, provided
+// ownerId still owns it, so of two concurrent transfers only the first
+// succeeds. A share the new owner had is removed; with keepAccess the previous
+// owner becomes a write collaborator. The document leaves the previous owner's
+// folder and lands at the new owner's root. Everything, including the
+// document.ownership_transferred event, happens in one transaction. It returns
+// the updated document without its slides, or ErrDocumentNotFound.
+func (r *DocumentRepository) TransferOwnership(ctx context.Context, documentId string, ownerId string, newOwnerId string, keepAccess bool) (*model.Document, error) {
+	documentObjectId, err := primitive.ObjectIDFromHex(documentId)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	tenantID := tenant.FromContext(ctx)
+	filter := notTrashed(tenantScoped(ctx, bson.M{"_id": documentObjectId, "ownerId": ownerId}))
+	update := bson.M{
+		"$set":   bson.M{"ownerId": newOwnerId, "updatedAt": time.Now().UTC()},
+		"$unset": bson.M{"folderId": ""},
+	}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"slides": 0})
+
+	var document model.Document
+	err = r.withTransaction(ctx, func(ctx context.Context) error {
+		if err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&document); err != nil {
+			return err
+		}
+
+		// Nobody is both owner and collaborator
+		if _, err := r.sharedDocRecordCollection.DeleteMany(ctx, bson.M{"documentId": documentId, "userId": newOwnerId}); err != nil {
+			return err
+		}
+
+		previousOwnerAccess := ""
+		if !keepAccess {
+			// The previous owner can no longer open it
+			if err := r.deleteFavorites(ctx, bson.M{"documentId": documentId, "userId": ownerId}); err != nil {
+				return err
+			}
+		}
+		if keepAccess {
+			previousOwnerAccess = AccessWrite
+			shareFilter := bson.M{"tenantId": tenantID, "documentId": documentId, "userId": ownerId}
+			shareUpdate := bson.M{
+				"$set":         bson.M{"accessType": previousOwnerAccess},
+				"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "sharedAt": time.Now()},
+			}
+			if _, err := r.sharedDocRecordCollection.UpdateOne(ctx, shareFilter, shareUpdate, options.Update().SetUpsert(true)); err != nil {
+				return err
+			}
+		}
+
+		return r.outbox.Append(ctx, events.OwnershipTransferredEvent{
+			DocumentID:          documentId,
+			TenantID:            tenantID,
+			PreviousOwnerID:     ownerId,
+			NewOwnerID:          newOwnerId,
+			PreviousOwnerAccess: previousOwnerAccess,
+			OccurredAt:          time.Now().UTC(),
+		})
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrDocumentNotFound
+	}
+	if err != nil {
+		fmt.Printf("[DocumentRepository][TransferOwnership] Error transferring document: %v\n", err)
+		return nil, err
+	}
+
+	r.invalidate(ctx, documentId)
+	return &document, nil
+}
+
+// SetArchived archives or unarchives a document of ownerId and returns it
+// without its slides, or ErrDocumentNotFound.
+func (r *DocumentRepository) SetArchived(ctx context.Context, documentId string, ownerId string, archived bool) (*model.Document, error) {
+	documentObjectId, err := primitive.ObjectIDFromHex(documentId)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	filter := notTrashed(tenantScoped(ctx, bson.M{"_id": documentObjectId, "ownerId": ownerId}))
+	update := bson.M{"$unset": bson.M{"archived": ""}}
+	if archived {
+		update = bson.M{"$set": bson.M{"archived": true}}
+	}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"slides": 0})
+
+	var document model.Document
+	err = r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&document)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrDocumentNotFound
+	}
+	if err != nil {
+		fmt.Printf("[DocumentRepository][SetArchived] Error archiving document: %v\n", err)
+		return nil, err
+	}
+	r.invalidate(ctx, documentId)
+	return &document, nil
+}
+
+// SetLocked locks or unlocks a document of ownerId and emits a
+// document.locked or document.unlocked event in the same transaction. It
+// returns the document without its slides, or ErrDocumentNotFound unless
+// ownerId owns it. Locking a locked document, or unlocking an unlocked one,
+// changes nothing and emits no event.
+func (r *DocumentRepository) SetLocked(ctx context.Context, documentId string, ownerId string, locked bool) (*model.Document, error) {
+	documentObjectId, err := primitive.ObjectIDFromHex(documentId)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	// The lock is not an edit, so updatedAt stays
+	now := time.Now().UTC()
+	filter := notTrashed(tenantScoped(ctx, bson.M{"_id": documentObjectId, "ownerId": ownerId, "locked": bson.M{"$ne": locked}}))
+	update := bson.M{"$unset": bson.M{"locked": "", "lockedBy": "", "lockedAt": ""}}
+	var event events.Event = events.DocumentUnlockedEvent{
 		DocumentID: documentId,
-		AccessType: accessType,
+		TenantID:   tenant.FromContext(ctx),
+		UnlockedBy: ownerId,
+		OccurredAt: now,
 	}
+	if locked {
+		update = bson.M{"$set": bson.M{"locked": true, "lockedBy": ownerId, "lockedAt": now}}
+		event = events.DocumentLockedEvent{
+			DocumentID: documentId,
+			TenantID:   tenant.FromContext(ctx),
+			LockedBy:   ownerId,
+			LockedAt:   now,
+			OccurredAt: now,
+		}
+	}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"slides": 0})
 
-	// Execute the query
-	result, err := r.sharedDocRecordCollection.InsertOne(ctx, sharedDocRecord)
+	var document model.Document
+	err = r.withTransaction(ctx, func(ctx context.Context) error {
+		if err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&document); err != nil {
+			return err
+		}
+		return r.outbox.Append(ctx, event)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Either the document is gone or already in the requested state
+		current, err := r.findDocument(ctx, documentId)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil || current.OwnerID != ownerId {
+			return nil, ErrDocumentNotFound
+		}
+		current.Slides = nil
+		return current, nil
+	}
+	if err != nil {
+		fmt.Printf("[DocumentRepository][SetLocked] Error locking document: %v\n", err)
+		return nil, err
+	}
+
+	r.invalidate(ctx, documentId)
+	return &document, nil
+}
+
+// UpdateContent replaces the document's slides, provided its version is still
+// baseVersion, bumping the version and updatedAt and emitting a
+// document.content_replaced event in the same transaction. It returns the
+// updated document without its slides, ErrDocumentNotFound, or
+// ErrVersionConflict, ErrDocumentArchived or ErrDocumentLocked together with
+// the current document.
+func (r *DocumentRepository) UpdateContent(ctx context.Context, documentId string, slides []model.Slide, userId string, baseVersion int64) (*model.Document, error) {
+	documentObjectId, err := primitive.ObjectIDFromHex(documentId)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	// Live updates push objects into these arrays, so they must not be null
+	for i := range slides {
+		if slides[i].Objects == nil {
+			slides[i].Objects = []model.Object{}
+		}
+	}
+
+	// Documents without a version are at version 0
+	version := any(baseVersion)
+	if baseVersion == 0 {
+		version = bson.M{"$in": bson.A{0, nil}}
+	}
+	filter := notTrashed(tenantScoped(ctx, bson.M{"_id": documentObjectId, "version": version, "archived": bson.M{"$ne": true}, "locked": bson.M{"$ne": true}}))
+	update := bson.M{
+		"$set": bson.M{"slides": slides, "updatedAt": time.Now().UTC()},
+		"$inc": bson.M{"version": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"slides": 0})
+
+	var document model.Document
+	err = r.withTransaction(ctx, func(ctx context.Context) error {
+		if err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&document); err != nil {
+			return err
+		}
+
+		return r.outbox.Append(ctx, events.ContentReplacedEvent{
+			DocumentID: documentId,
+			TenantID:   tenant.FromContext(ctx),
+			ReplacedBy: userId,
+			UpdatedAt:  document.UpdatedAt,
+			Version:    document.Version,
+			OccurredAt: time.Now().UTC(),
+		})
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Either the document is gone, archived, locked, or its version
+		// moved on; the cache may not know yet
+		current, err := r.findDocument(ctx, documentId)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil {
+			return nil, ErrDocumentNotFound
+		}
+		current.Slides = nil
+		if current.Archived {
+			return current, ErrDocumentArchived
+		}
+		if current.Locked {
+			return current, ErrDocumentLocked
+		}
+		return current, ErrVersionConflict
+	}
+	if err != nil {
+		fmt.Printf("[DocumentRepository][UpdateContent] Error updating content: %v\n", err)
+		return nil, err
+	}
+
+	r.invalidate(ctx, documentId)
+	return &document, nil
+}
+
+// GetAccessLevel returns the access userId has to the document: AccessOwner
+// for its owner, the access type of a share for collaborators, and AccessNone
+// for anyone else. It returns ErrDocumentNotFound when the document does not
+// exist in the caller's tenant.
+func (r *DocumentRepository) GetAccessLevel(ctx context.Context, userId string, documentId string) (string, error) {
+	documentObjectId, err := primitive.ObjectIDFromHex(documentId)
+	if err != nil {
+		return AccessNone, ErrInvalidID
+	}
+
+	var document model.Document
+	opts := options.FindOne().SetProjection(bson.M{"ownerId": 1})
+	err = r.collection.FindOne(ctx, notTrashed(tenantScoped(ctx, bson.M{"_id": documentObjectId})), opts).Decode(&document)
+	if err == mongo.ErrNoDocuments {
+		return AccessNone, ErrDocumentNotFound
+	}
+	if err != nil {
+		fmt.Printf("[DocumentRepository][GetAccessLevel] Error retrieving document: %v\n", err)
+		return AccessNone, err
+	}
+	if document.OwnerID == userId {
+		return AccessOwner, nil
+	}
+
+	cursor, err := r.sharedDocRecordCollection.Find(ctx, tenantScoped(ctx, bson.M{"documentId": documentId, "userId": userId}))
+	if err != nil {
+		fmt.Printf("[DocumentRepository][GetAccessLevel] Error retrieving shared records: %v\n", err)
+		return AccessNone, err
+	}
+	defer cursor.Close(ctx)
+
+	records := []model.CollaborationRecord{}
+	if err := cursor.All(ctx, &records); err != nil {
+		fmt.Printf("[DocumentRepository][GetAccessLevel] Error decoding shared records: %v\n", err)
+		return AccessNone, err
+	}
+
+	// A user shared with more than once gets the strongest of their shares
+	level := AccessNone
+	for _, record := range records {
+		if access := collaboratorAccess(record.AccessType); accessRank[access] > accessRank[level] {
+			level = access
+		}
+	}
+	return level, nil
+}
+
+// CreateCollaborationRecord shares the document with the collaborator, or
+// changes the access type of an existing share. A document.shared event is
+// emitted in the same transaction unless the share already had that access type.
+func (r *DocumentRepository) CreateCollaborationRecord(ctx context.Context, collaboratorUserId string, documentId string, accessType types.AccessType) (model.CollaborationRecord, error) {
+	// A user has at most one share per document, backed by a unique index.
+	// Shares all carry a tenantId since the backfill_tenant_ids migration, so
+	// an exact tenant match finds them and is copied into new ones.
+	filter := bson.M{"tenantId": tenant.FromContext(ctx), "documentId": documentId, "userId": collaboratorUserId}
+
+	var record model.CollaborationRecord
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		record, err = r.upsertCollaborationRecord(ctx, filter, accessType)
+		// Two concurrent first shares both try to insert; the loser retries as an update
+		if !mongo.IsDuplicateKeyError(err) {
+			break
+		}
+	}
 	if err != nil {
 		fmt.Printf("[DocumentRepository] Error creating sharing record: %v\n", err)
 		return model.CollaborationRecord{}, err
 	}
 
-	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
-		sharedDocRecord.ID = oid
+	return record, nil
+}
+
+// ShareGrant is one collaborator of a bulk share.
+type ShareGrant struct {
+	UserID     string
+	AccessType types.AccessType
+}
+
+// CreateCollaborationRecords shares the document with every collaborator of
+// grants in one bulk write, emitting a document.shared event for each share
+// created or changed, all in one transaction. Grants must name each user at
+// most once. It returns the outcome per user: types.ShareCreated,
+// types.ShareUpdated, or types.ShareUnchanged.
+func (r *DocumentRepository) CreateCollaborationRecords(ctx context.Context, documentId string, grants []ShareGrant) (map[string]string, error) {
+	var results map[string]string
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		results, err = r.upsertCollaborationRecords(ctx, documentId, grants)
+		// A concurrent first share of one of the users won the insert; retry as an update
+		if !mongo.IsDuplicateKeyError(err) {
+			break
+		}
+	}
+	if err != nil {
+		fmt.Printf("[DocumentRepository][CreateCollaborationRecords] Error creating sharing records: %v\n", err)
+		return nil, err
+	}
+	return results, nil
+}
+
+func (r *DocumentRepository) upsertCollaborationRecords(ctx context.Context, documentId string, grants []ShareGrant) (map[string]string, error) {
+	tenantID := tenant.FromContext(ctx)
+	userIds := make([]string, len(grants))
+	for i, grant := range grants {
+		userIds[i] = grant.UserID
+	}
+
+	var results map[string]string
+	err := r.withTransaction(ctx, func(ctx context.Context) error {
+		results = make(map[string]string, len(grants))
+
+		// Find the existing shares to tell created, updated, and unchanged apart
+		filter := bson.M{"tenantId": tenantID, "documentId": documentId, "userId": bson.M{"$in": userIds}}
+		cursor, err := r.sharedDocRecordCollection.Find(ctx, filter)
+		if err != nil {
+			return err
+		}
+		var existing []model.CollaborationRecord
+		if err := cursor.All(ctx, &existing); err != nil {
+			return err
+		}
+		previous := make(map[string]string, len(existing))
+		for _, record := range existing {
+			previous[record.UserID] = record.AccessType
+		}
+
+		now := time.Now()
+		writes := make([]mongo.WriteModel, len(grants))
+		for i, grant := range grants {
+			writes[i] = mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"tenantId": tenantID, "documentId": documentId, "userId": grant.UserID}).
+				SetUpdate(bson.M{
+					"$set":         bson.M{"accessType": string(grant.AccessType)},
+					"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "sharedAt": now},
+				}).
+				SetUpsert(true)
+		}
+		if _, err := r.sharedDocRecordCollection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+
+		for _, grant := range grants {
+			accessType, found := previous[grant.UserID]
+			switch {
+			case !found:
+				results[grant.UserID] = types.ShareCreated
+			case accessType != string(grant.AccessType):
+				results[grant.UserID] = types.ShareUpdated
+			default:
+				results[grant.UserID] = types.ShareUnchanged
+				continue
+			}
+
+			err := r.outbox.Append(ctx, events.DocumentSharedEvent{
+				DocumentID: documentId,
+				TenantID:   tenantID,
+				UserID:     grant.UserID,
+				AccessType: string(grant.AccessType),
+				OccurredAt: time.Now().UTC(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return results, err
+}
+
+func (r *DocumentRepository) upsertCollaborationRecord(ctx context.Context, filter bson.M, accessType types.AccessType) (model.CollaborationRecord, error) {
+	now := time.Now()
+	record := model.CollaborationRecord{
+		ID:         primitive.NewObjectID(),
+		UserID:     filter["userId"].(string),
+		DocumentID: filter["documentId"].(string),
+		AccessType: string(accessType),
+		TenantID:   filter["tenantId"].(string),
+		SharedAt:   now,
+	}
+
+	update := bson.M{
+		"$set":         bson.M{"accessType": record.AccessType},
+		"$setOnInsert": bson.M{"_id": record.ID, "sharedAt": now},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+
+	// Execute the query together with its outbox event
+	err := r.withTransaction(ctx, func(ctx context.Context) error {
+		var previous model.CollaborationRecord
+		err := r.sharedDocRecordCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&previous)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+		if err == nil {
+			record.ID, record.SharedAt = previous.ID, previous.SharedAt
+			if previous.AccessType == record.AccessType {
+				return nil
+			}
+		}
+
+		return r.outbox.Append(ctx, events.DocumentSharedEvent{
+			DocumentID: record.DocumentID,
+			TenantID:   record.TenantID,
+			UserID:     record.UserID,
+			AccessType: record.AccessType,
+			OccurredAt: time.Now().UTC(),
+		})
+	})
+	return record, err
+}
+
+// DeleteCollaborationRecord revokes the access a share gave userId to the
+// document and emits a share.revoked event in the same transaction, so open
+// editors of that user can be closed. It reports whether a share existed;
+// revoking one that does not is not an error.
+func (r *DocumentRepository) DeleteCollaborationRecord(ctx context.Context, documentId string, userId string) (bool, error) {
+	filter := tenantScoped(ctx, bson.M{"documentId": documentId, "userId": userId})
+
+	var deleted int64
+	err := r.withTransaction(ctx, func(ctx context.Context) error {
+		result, err := r.sharedDocRecordCollection.DeleteMany(ctx, filter)
+		if err != nil || result.DeletedCount == 0 {
+			return err
+		}
+		deleted = result.DeletedCount
+
+		if err := r.deleteFavorites(ctx, bson.M{"documentId": documentId, "userId": userId}); err != nil {
+			return err
+		}
+
+		return r.outbox.Append(ctx, events.ShareRevokedEvent{
+			DocumentID: documentId,
+			TenantID:   tenant.FromContext(ctx),
+			UserID:     userId,
+			OccurredAt: time.Now().UTC(),
+		})
+	})
+	if err != nil {
+		fmt.Printf("[DocumentRepository][DeleteCollaborationRecord] Error deleting sharing record: %v\n", err)
+		return false, err
+	}
+
+	return deleted > 0, nil
+}
+
+// FindCollaboratorsByDocumentID returns every shared record of a document,
+// oldest share first.
+func (r *DocumentRepository) FindCollaboratorsByDocumentID(ctx context.Context, documentId string) ([]model.CollaborationRecord, error) {
+	filter := tenantScoped(ctx, bson.M{"documentId": documentId})
+	opts := options.Find().SetSort(bson.D{{Key: "sharedAt", Value: 1}, {Key: "_id", Value: 1}})
+
+	cursor, err := r.sharedDocRecordCollection.Find(ctx, filter, opts)
+	if err != nil {
+		fmt.Printf("[DocumentRepository][FindCollaboratorsByDocumentID] Error retrieving shared records: %v\n", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	records := []model.CollaborationRecord{}
+	if err := cursor.All(ctx, &records); err != nil {
+		fmt.Printf("[DocumentRepository][FindCollaboratorsByDocumentID] Error decoding shared records: %v\n", err)
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// DeleteUserData removes what a deleted user leaves behind in the tenant of
+// ctx: the shares granting them access, their favorites, and every document
+// they own, together with those documents' shares and favorites. Each step emits its outbox events in the same
+// transaction, and re-running it after a partial failure finishes the job.
+func (r *DocumentRepository) DeleteUserData(ctx context.Context, userId string) (int, int, error) {
+	tenantID := tenant.FromContext(ctx)
+
+	// 1. Shares granting the user access to other people's documents
+	records := []model.CollaborationRecord{}
+	cursor, err := r.sharedDocRecordCollection.Find(ctx, tenantScoped(ctx, bson.M{"userId": userId}))
+	if err != nil {
+		fmt.Printf("[DocumentRepository][DeleteUserData] Error retrieving shared records: %v\n", err)
+		return 0, 0, err
+	}
+	if err := cursor.All(ctx, &records); err != nil {
+		fmt.Printf("[DocumentRepository][DeleteUserData] Error decoding shared records: %v\n", err)
+		return 0, 0, err
+	}
+
+	for _, record := range records {
+		err := r.withTransaction(ctx, func(ctx context.Context) error {
+			result, err := r.sharedDocRecordCollection.DeleteOne(ctx, bson.M{"_id": record.ID})
+			if err != nil || result.DeletedCount == 0 {
+				return err
+			}
+
+			if err := r.deleteFavorites(ctx, bson.M{"documentId": record.DocumentID, "userId": userId}); err != nil {
+				return err
+			}
+
+			return r.outbox.Append(ctx, events.ShareRevokedEvent{
+				DocumentID: record.DocumentID,
+				TenantID:   tenantID,
+				UserID:     userId,
+				OccurredAt: time.Now().UTC(),
+			})
+		})
+		if err != nil {
+			fmt.Printf("[DocumentRepository][DeleteUserData] Error revoking share %s: %v\n", record.ID.Hex(), err)
+			return 0, 0, err
+		}
+	}
+
+	// 2. Documents the user owns, and everyone else's access to them
+	// Trashed documents included
+	owned, _, err := r.findPage(ctx, tenantScoped(ctx, bson.M{"ownerId": userId}), ListOptions{}, "DeleteUserData")
+	if err != nil {
+		return 0, len(records), err
+	}
+
+	for _, document := range owned {
+		documentId := document.ID.Hex()
+		err := r.withTransaction(ctx, func(ctx context.Context) error {
+			if err := r.deleteShares(ctx, documentId); err != nil {
+				return err
+			}
+			if err := r.deleteFavorites(ctx, bson.M{"documentId": documentId}); err != nil {
+				return err
+			}
+
+			result, err := r.collection.DeleteOne(ctx, bson.M{"_id": document.ID})
+			if err != nil || result.DeletedCount == 0 {
+				return err
+			}
+
+			return r.outbox.Append(ctx, events.DocumentDeletedEvent{
+				DocumentID: documentId,
+				TenantID:   tenantID,
+				OccurredAt: time.Now().UTC(),
+			})
+		})
+		if err != nil {
+			fmt.Printf("[DocumentRepository][DeleteUserData] Error deleting document %s: %v\n", documentId, err)
+			return 0, len(records), err
+		}
+		r.invalidate(ctx, documentId)
+	}
+
+	// 3. Favorites left on documents they could open
+	if err := r.deleteFavorites(ctx, tenantScoped(ctx, bson.M{"userId": userId})); err != nil {
+		fmt.Printf("[DocumentRepository][DeleteUserData] Error deleting favorites: %v\n", err)
+		return len(owned), len(records), err
 	}
 
-	return sharedDocRecord, nil
+	return len(owned), len(records), nil
 }
@@ -0,0 +1,380 @@
+package repository
+
+import (
+	"context"
+	"document-service/cache"
+	"document-service/metrics"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"shared/events"
+	"shared/migrate"
+	"shared/model"
+	"shared/tenant"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testRepository returns a repository over an empty database on the server
+// at MONGO_TEST_URI, with its indexes built, dropped when the test ends.
+// Tests needing it are skipped without one.
+func testRepository(t *testing.T) *DocumentRepository {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := client.Database(fmt.Sprintf("document_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+	if _, err := migrate.New(db).Apply(ctx, false); err != nil {
+		t.Fatalf("building the indexes: %v", err)
+	}
+
+	outbox := NewOutboxRepository(client, db.Name(), model.OutboxCollection, model.OutboxParkedCollection, "document-events")
+	return NewDocumentRepository(client, db.Name(), model.DocumentCollection, model.SharedDocRecordCollection, model.FavoriteCollection, outbox)
+}
+
+func TestConcurrentTransfersOneSucceeds(t *testing.T) {
+	r := testRepository(t)
+	ctx := tenant.WithID(context.Background(), "acme")
+
+	owner := primitive.NewObjectID().Hex()
+	document, err := r.CreateNewDocument(ctx, "Plan", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const attempts = 8
+	newOwners := make([]string, attempts)
+	errs := make([]error, attempts)
+	var wg sync.WaitGroup
+	for i := range newOwners {
+		newOwners[i] = primitive.NewObjectID().Hex()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = r.TransferOwnership(ctx, document.ID.Hex(), owner, newOwners[i], true)
+		}()
+	}
+	wg.Wait()
+
+	winner := ""
+	for i, err := range errs {
+		switch {
+		case err == nil && winner == "":
+			winner = newOwners[i]
+		case err == nil:
+			t.Errorf("transfers to %s and %s both succeeded", winner, newOwners[i])
+		case !errors.Is(err, ErrDocumentNotFound):
+			t.Errorf("transfer to %s: %v, want ErrDocumentNotFound", newOwners[i], err)
+		}
+	}
+	if winner == "" {
+		t.Fatal("no transfer succeeded")
+	}
+
+	if level, _ := r.GetAccessLevel(ctx, winner, document.ID.Hex()); level != AccessOwner {
+		t.Errorf("winner's access = %q, want owner", level)
+	}
+	records, err := r.FindCollaboratorsByDocumentID(ctx, document.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the previous owner stays on, as a write collaborator
+	if len(records) != 1 || records[0].UserID != owner || records[0].AccessType != AccessWrite {
+		t.Errorf("collaborators after the transfers = %+v, want the previous owner with write access", records)
+	}
+}
+
+func TestFindDocumentByIDReadsThroughCache(t *testing.T) {
+	r := testRepository(t)
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	r.UseCache(cache.NewDocumentCache(client, time.Minute))
+	ctx := tenant.WithID(context.Background(), "acme")
+
+	owner := primitive.NewObjectID().Hex()
+	document, err := r.CreateNewDocument(ctx, "Plan", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := document.ID.Hex()
+	key := model.DocumentCacheKey(id)
+
+	// A miss reads MongoDB and fills the cache
+	if found, err := r.FindDocumentByID(ctx, id); err != nil || found == nil || found.Title != "Plan" {
+		t.Fatalf("first read = %v, %v, want the document", found, err)
+	}
+	if !server.Exists(key) {
+		t.Fatal("document not cached after a miss")
+	}
+
+	// A hit is served from the cache
+	hits := metrics.DocumentCacheHits.Value()
+	if found, err := r.FindDocumentByID(ctx, id); err != nil || found == nil || found.ID != document.ID {
+		t.Fatalf("second read = %v, %v, want the document", found, err)
+	}
+	if metrics.DocumentCacheHits.Value() != hits+1 {
+		t.Error("second read missed the cache")
+	}
+	// even shared by every tenant, a cached document stays in its own
+	if found, err := r.FindDocumentByID(tenant.WithID(context.Background(), "globex"), id); err != nil || found != nil {
+		t.Errorf("read from another tenant = %v, %v, want nothing", found, err)
+	}
+
+	// Writes invalidate, so the next read sees them
+	if _, err := r.UpdateTitle(ctx, id, "Roadmap", owner); err != nil {
+		t.Fatal(err)
+	}
+	if server.Exists(key) {
+		t.Error("document still cached after its title changed")
+	}
+	if found, err := r.FindDocumentByID(ctx, id); err != nil || found == nil || found.Title != "Roadmap" {
+		t.Errorf("read after the write = %v, %v, want the new title", found, err)
+	}
+
+	// With Redis down reads go to MongoDB, without an error
+	server.Close()
+	if found, err := r.FindDocumentByID(ctx, id); err != nil || found == nil || found.Title != "Roadmap" {
+		t.Errorf("read with Redis down = %v, %v, want the document", found, err)
+	}
+	if _, err := r.UpdateTitle(ctx, id, "Plan B", owner); err != nil {
+		t.Errorf("write with Redis down = %v", err)
+	}
+}
+
+func TestMalformedIDsAreErrInvalidID(t *testing.T) {
+	// Refused before the database, which the repository does not have
+	r := &DocumentRepository{}
+	ctx := tenant.WithID(context.Background(), "acme")
+
+	for _, id := range []string{"", "65a1f0c2e4b0", "65a1f0c2e4b0a1b2c3d4e5zz"} {
+		if _, err := r.FindDocumentByID(ctx, id); !errors.Is(err, ErrInvalidID) {
+			t.Errorf("FindDocumentByID(%q) = %v, want ErrInvalidID", id, err)
+		}
+		if _, err := r.GetAccessLevel(ctx, "u-1", id); !errors.Is(err, ErrInvalidID) {
+			t.Errorf("GetAccessLevel(%q) = %v, want ErrInvalidID", id, err)
+		}
+	}
+}
+
+func TestMissingDocumentIsNotInvalid(t *testing.T) {
+	r := testRepository(t)
+	ctx := tenant.WithID(context.Background(), "acme")
+	id := primitive.NewObjectID().Hex()
+
+	if document, err := r.FindDocumentByID(ctx, id); document != nil || err != nil {
+		t.Errorf("FindDocumentByID of a missing document = %v, %v, want nil, nil", document, err)
+	}
+	if _, err := r.GetAccessLevel(ctx, "u-1", id); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("GetAccessLevel of a missing document = %v, want ErrDocumentNotFound", err)
+	}
+}
+
+func TestFindOptionsBreakTiesByID(t *testing.T) {
+	tests := []struct {
+		opts ListOptions
+		want bson.D
+	}{
+		{ListOptions{Sort: SortCreatedAt}, bson.D{{Key: "_id", Value: 1}}},
+		{ListOptions{Sort: SortCreatedAt, Descending: true}, bson.D{{Key: "_id", Value: -1}}},
+		{ListOptions{Sort: SortTitle}, bson.D{{Key: "title", Value: 1}, {Key: "_id", Value: 1}}},
+		{ListOptions{Sort: SortUpdatedAt, Descending: true}, bson.D{{Key: "updatedAt", Value: -1}, {Key: "_id", Value: -1}}},
+	}
+	for _, tt := range tests {
+		opts := tt.opts.findOptions()
+		if !reflect.DeepEqual(opts.Sort, tt.want) {
+			t.Errorf("%+v: sort %v, want %v", tt.opts, opts.Sort, tt.want)
+		}
+	}
+
+	opts := ListOptions{Limit: 20, Offset: 40}.findOptions()
+	if *opts.Limit != 20 || *opts.Skip != 40 {
+		t.Errorf("limit %d, skip %d, want 20 and 40", *opts.Limit, *opts.Skip)
+	}
+	if opts := (ListOptions{}).findOptions(); opts.Limit != nil {
+		t.Errorf("zero Limit set a limit of %d", *opts.Limit)
+	}
+}
+
+// pageThrough lists every page of limit owned documents of userId, returning
+// their IDs in order.
+func pageThrough(t *testing.T, r *DocumentRepository, ctx context.Context, userId string, opts ListOptions) []string {
+	t.Helper()
+	var ids []string
+	for opts.Offset = 0; ; opts.Offset += opts.Limit {
+		page, total, err := r.FindOwnedDocuments(ctx, userId, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, document := range page {
+			ids = append(ids, document.ID.Hex())
+		}
+		if opts.Offset+opts.Limit >= total {
+			return ids
+		}
+	}
+}
+
+func TestPagesOfTiedDocumentsAreStable(t *testing.T) {
+	r := testRepository(t)
+	ctx := tenant.WithID(context.Background(), "acme")
+
+	// Same title, so the sort ties on every document
+	var created []string
+	for range 7 {
+		document, err := r.CreateNewDocument(ctx, "Plan", "owner")
+		if err != nil {
+			t.Fatal(err)
+		}
+		created = append(created, document.ID.Hex())
+	}
+
+	ascending := pageThrough(t, r, ctx, "owner", ListOptions{Limit: 2, Sort: SortTitle})
+	if !slices.Equal(ascending, created) {
+		t.Errorf("pages by title = %v, want every document once, oldest first: %v", ascending, created)
+	}
+	descending := pageThrough(t, r, ctx, "owner", ListOptions{Limit: 3, Sort: SortTitle, Descending: true})
+	slices.Reverse(created)
+	if !slices.Equal(descending, created) {
+		t.Errorf("pages by title, descending = %v, want %v", descending, created)
+	}
+}
+
+func TestOwnedAndSharedPageIndependently(t *testing.T) {
+	r := testRepository(t)
+	ctx := tenant.WithID(context.Background(), "acme")
+	user := primitive.NewObjectID().Hex()
+
+	for i := range 3 {
+		if _, err := r.CreateNewDocument(ctx, fmt.Sprintf("Own %d", i), user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range 5 {
+		document, err := r.CreateNewDocument(ctx, fmt.Sprintf("Shared %d", i), "someone-else")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.CreateCollaborationRecord(ctx, user, document.ID.Hex(), "read"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	owned, ownedTotal, err := r.FindOwnedDocuments(ctx, user, ListOptions{Limit: 2, Offset: 2, Sort: SortTitle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, sharedTotal, err := r.FindSharedDocuments(ctx, user, ListOptions{Limit: 2, Offset: 0, Sort: SortTitle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ownedTotal != 3 || len(owned) != 1 || owned[0].Title != "Own 2" {
+		t.Errorf("owned page at 2: %d of %d, want Own 2 of 3", len(owned), ownedTotal)
+	}
+	if sharedTotal != 5 || len(shared) != 2 || shared[0].Title != "Shared 0" || shared[1].Title != "Shared 1" {
+		t.Errorf("shared page at 0: %d of %d, want Shared 0 and 1 of 5", len(shared), sharedTotal)
+	}
+}
+
+func TestLegacyAccessTypesGrantRead(t *testing.T) {
+	tests := map[string]string{
+		"write":  AccessWrite,
+		"Write ": AccessWrite,
+		"read":   AccessRead,
+		"editor": AccessRead,
+		"owner":  AccessRead,
+		"":       AccessRead,
+	}
+	for accessType, want := range tests {
+		if got := collaboratorAccess(accessType); got != want {
+			t.Errorf("collaboratorAccess(%q) = %q, want %q", accessType, got, want)
+		}
+	}
+}
+
+// eventsOf returns the types of the pending outbox events of r about documentId.
+func eventsOf(t *testing.T, r *DocumentRepository, ctx context.Context, documentId string) []string {
+	t.Helper()
+	pending, err := r.outbox.FindPending(ctx, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var eventTypes []string
+	for _, event := range pending {
+		if event.AggregateID == documentId {
+			eventTypes = append(eventTypes, event.EventType)
+		}
+	}
+	return eventTypes
+}
+
+func TestSetLockedIsIdempotent(t *testing.T) {
+	r := testRepository(t)
+	ctx := tenant.WithID(context.Background(), "acme")
+	owner := primitive.NewObjectID().Hex()
+
+	document, err := r.CreateNewDocument(ctx, "Plan", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := document.ID.Hex()
+	before := eventsOf(t, r, ctx, id)
+
+	locked, err := r.SetLocked(ctx, id, owner, true)
+	if err != nil || !locked.Locked || locked.LockedBy != owner || locked.LockedAt == nil {
+		t.Fatalf("locking: %+v, %v", locked, err)
+	}
+	again, err := r.SetLocked(ctx, id, owner, true)
+	if err != nil || !again.Locked || !again.LockedAt.Equal(*locked.LockedAt) {
+		t.Errorf("locking again: %+v, %v, want the first lock kept", again, err)
+	}
+
+	if _, err := r.UpdateContent(ctx, id, []model.Slide{{ID: "s-1"}}, owner, document.Version); !errors.Is(err, ErrDocumentLocked) {
+		t.Errorf("changing locked content: %v, want ErrDocumentLocked", err)
+	}
+	if _, err := r.SetLocked(ctx, id, primitive.NewObjectID().Hex(), false); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("someone else unlocking: %v, want ErrDocumentNotFound", err)
+	}
+
+	for range 2 {
+		unlocked, err := r.SetLocked(ctx, id, owner, false)
+		if err != nil || unlocked.Locked || unlocked.LockedBy != "" || unlocked.LockedAt != nil {
+			t.Errorf("unlocking: %+v, %v", unlocked, err)
+		}
+	}
+	if _, err := r.UpdateContent(ctx, id, []model.Slide{{ID: "s-1"}}, owner, document.Version); err != nil {
+		t.Errorf("changing unlocked content: %v", err)
+	}
+
+	// One event per change of state, none for the repeats
+	got := eventsOf(t, r, ctx, id)[len(before):]
+	want := []string{events.DocumentLocked, events.DocumentUnlocked}
+	if len(got) < 2 || !slices.Equal(got[:2], want) {
+		t.Errorf("events %q, want %q first", got, want)
+	}
+	if slices.Contains(got[2:], events.DocumentLocked) || slices.Contains(got[2:], events.DocumentUnlocked) {
+		t.Errorf("events %q, want repeats to emit nothing", got)
+	}
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"document-service/model"
+	"fmt"
+	"shared/tenant"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AddFavorite stars a document for userId. Starring it again is not an error.
+// Callers check the user may open the document.
+func (r *DocumentRepository) AddFavorite(ctx context.Context, userId string, documentId string) error {
+	filter := bson.M{"userId": userId, "documentId": documentId}
+	update := bson.M{"$setOnInsert": bson.M{
+		"_id":       primitive.NewObjectID(),
+		"tenantId":  tenant.FromContext(ctx),
+		"createdAt": time.Now().UTC(),
+	}}
+
+	if _, err := r.favoriteCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true)); err != nil {
+		fmt.Printf("[DocumentRepository][AddFavorite] Error adding favorite: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// RemoveFavorite unstars a document for userId. Unstarring a document that is
+// not starred is not an error.
+func (r *DocumentRepository) RemoveFavorite(ctx context.Context, userId string, documentId string) error {
+	if err := r.deleteFavorites(ctx, tenantScoped(ctx, bson.M{"userId": userId, "documentId": documentId})); err != nil {
+		fmt.Printf("[DocumentRepository][RemoveFavorite] Error removing favorite: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// FindFavorites returns which of documentIds userId starred, in one query.
+// With documentIds nil it returns every document they starred.
+func (r *DocumentRepository) FindFavorites(ctx context.Context, userId string, documentIds []string) (map[string]bool, error) {
+	filter := tenantScoped(ctx, bson.M{"userId": userId})
+	if documentIds != nil {
+		if len(documentIds) == 0 {
+			return map[string]bool{}, nil
+		}
+		filter["documentId"] = bson.M{"$in": documentIds}
+	}
+
+	cursor, err := r.favoriteCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"documentId": 1}))
+	if err != nil {
+		fmt.Printf("[DocumentRepository][FindFavorites] Error retrieving favorites: %v\n", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []model.Favorite
+	if err := cursor.All(ctx, &records); err != nil {
+		fmt.Printf("[DocumentRepository][FindFavorites] Error decoding favorites: %v\n", err)
+		return nil, err
+	}
+
+	favorites := make(map[string]bool, len(records))
+	for _, record := range records {
+		favorites[record.DocumentID] = true
+	}
+	return favorites, nil
+}
+
+// favoriteIDs returns the document IDs of FindFavorites's result.
+func favoriteIDs(favorites map[string]bool) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(favorites))
+	for id := range favorites {
+		if objectId, err := primitive.ObjectIDFromHex(id); err == nil {
+			ids = append(ids, objectId)
+		}
+	}
+	return ids
+}
+
+// deleteFavorites removes the favorites matching filter, for documents a user
+// can no longer open.
+func (r *DocumentRepository) deleteFavorites(ctx context.Context, filter bson.M) error {
+	_, err := r.favoriteCollection.DeleteMany(ctx, filter)
+	return err
+}
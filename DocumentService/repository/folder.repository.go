@@ -0,0 +1,242 @@
+package repository
+
+import (
+	"context"
+	"document-service/model"
+	"errors"
+	"fmt"
+	"shared/tenant"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrFolderNotFound is returned when a folder does not exist or belongs to
+// another user.
+var ErrFolderNotFound = errors.New("folder not found")
+
+// ErrFolderNotEmpty is returned when deleting a folder that still holds
+// documents or folders. Only empty folders can be deleted.
+var ErrFolderNotEmpty = errors.New("folder is not empty")
+
+// RootFolder selects the documents in no folder when listing by folder.
+const RootFolder = "root"
+
+// FolderRepository stores the folders users organize their documents in.
+// Folders are per owner: only the owner's documents can be put in them, and
+// collaborators never see where the owner filed a shared document.
+type FolderRepository struct {
+	collection         *mongo.Collection
+	documentCollection *mongo.Collection
+	cache              DocumentCache
+}
+
+func NewFolderRepository(client *mongo.Client, databaseName string, collection string, documentCollection string) *FolderRepository {
+	return &FolderRepository{
+		collection:         client.Database(databaseName).Collection(collection),
+		documentCollection: client.Database(databaseName).Collection(documentCollection),
+	}
+}
+
+// UseCache makes MoveDocument invalidate the documents it moves in cache, the
+// one DocumentRepository reads through.
+func (r *FolderRepository) UseCache(cache DocumentCache) {
+	r.cache = cache
+}
+
+// FindFolders returns every folder of ownerId, ordered by name.
+func (r *FolderRepository) FindFolders(ctx context.Context, ownerId string) ([]model.Folder, error) {
+	filter := tenantScoped(ctx, bson.M{"ownerId": ownerId})
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}})
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		fmt.Printf("[FolderRepository][FindFolders] Error retrieving folders: %v\n", err)
+		return []model.Folder{}, err
+	}
+	defer cursor.Close(ctx)
+
+	folders := []model.Folder{}
+	if err := cursor.All(ctx, &folders); err != nil {
+		fmt.Printf("[FolderRepository][FindFolders] Error decoding folders: %v\n", err)
+		return []model.Folder{}, err
+	}
+	return folders, nil
+}
+
+// FolderExists reports whether ownerId has the folder.
+func (r *FolderRepository) FolderExists(ctx context.Context, id string, ownerId string) (bool, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, nil
+	}
+
+	count, err := r.collection.CountDocuments(ctx, tenantScoped(ctx, bson.M{"_id": objectId, "ownerId": ownerId}), options.Count().SetLimit(1))
+	if err != nil {
+		fmt.Printf("[FolderRepository][FolderExists] Error looking up folder: %v\n", err)
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// CreateFolder creates a folder of ownerId inside parentId, or at the root
+// when parentId is empty. It returns ErrFolderNotFound when ownerId has no
+// folder parentId.
+func (r *FolderRepository) CreateFolder(ctx context.Context, ownerId string, name string, parentId string) (model.Folder, error) {
+	if parentId != "" {
+		exists, err := r.FolderExists(ctx, parentId, ownerId)
+		if err != nil {
+			return model.Folder{}, err
+		}
+		if !exists {
+			return model.Folder{}, ErrFolderNotFound
+		}
+	}
+
+	folder := model.Folder{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		OwnerID:   ownerId,
+		TenantID:  tenant.FromContext(ctx),
+		ParentID:  parentId,
+		CreatedAt: time.Now().UTC(),
+	}
+	if _, err := r.collection.InsertOne(ctx, folder); err != nil {
+		fmt.Printf("[FolderRepository][CreateFolder] Error creating folder: %v\n", err)
+		return model.Folder{}, err
+	}
+	return folder, nil
+}
+
+// RenameFolder renames a folder of ownerId and returns it, or
+// ErrFolderNotFound.
+func (r *FolderRepository) RenameFolder(ctx context.Context, id string, ownerId string, name string) (*model.Folder, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	filter := tenantScoped(ctx, bson.M{"_id": objectId, "ownerId": ownerId})
+	update := bson.M{"$set": bson.M{"name": name}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var folder model.Folder
+	err = r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&folder)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrFolderNotFound
+	}
+	if err != nil {
+		fmt.Printf("[FolderRepository][RenameFolder] Error renaming folder: %v\n", err)
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// DeleteFolder deletes an empty folder of ownerId. It returns
+// ErrFolderNotEmpty while the folder holds folders or documents outside the
+// trash, and ErrFolderNotFound. Trashed documents in the folder, and anything
+// moved into it while it was being deleted, end up at the root.
+func (r *FolderRepository) DeleteFolder(ctx context.Context, id string, ownerId string) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	exists, err := r.FolderExists(ctx, id, ownerId)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrFolderNotFound
+	}
+
+	subfolders, err := r.collection.CountDocuments(ctx, tenantScoped(ctx, bson.M{"ownerId": ownerId, "parentId": id}), options.Count().SetLimit(1))
+	if err != nil {
+		fmt.Printf("[FolderRepository][DeleteFolder] Error counting subfolders: %v\n", err)
+		return err
+	}
+	documents, err := r.documentCollection.CountDocuments(ctx, notTrashed(tenantScoped(ctx, bson.M{"ownerId": ownerId, "folderId": id})), options.Count().SetLimit(1))
+	if err != nil {
+		fmt.Printf("[FolderRepository][DeleteFolder] Error counting documents: %v\n", err)
+		return err
+	}
+	if subfolders > 0 || documents > 0 {
+		return ErrFolderNotEmpty
+	}
+
+	result, err := r.collection.DeleteOne(ctx, tenantScoped(ctx, bson.M{"_id": objectId, "ownerId": ownerId}))
+	if err != nil {
+		fmt.Printf("[FolderRepository][DeleteFolder] Error deleting folder: %v\n", err)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrFolderNotFound
+	}
+
+	// Nothing may point at the deleted folder
+	if _, err := r.collection.UpdateMany(ctx, tenantScoped(ctx, bson.M{"ownerId": ownerId, "parentId": id}), bson.M{"$unset": bson.M{"parentId": ""}}); err != nil {
+		fmt.Printf("[FolderRepository][DeleteFolder] Error moving subfolders of %s to the root: %v\n", id, err)
+		return err
+	}
+	if _, err := r.documentCollection.UpdateMany(ctx, tenantScoped(ctx, bson.M{"ownerId": ownerId, "folderId": id}), bson.M{"$unset": bson.M{"folderId": ""}}); err != nil {
+		fmt.Printf("[FolderRepository][DeleteFolder] Error moving documents of %s to the root: %v\n", id, err)
+		return err
+	}
+	return nil
+}
+
+// MoveDocument puts a document of ownerId into a folder of theirs, or at the
+// root when folderId is empty. It returns the document without its slides,
+// ErrDocumentNotFound unless ownerId owns the document, or ErrFolderNotFound.
+func (r *FolderRepository) MoveDocument(ctx context.Context, documentId string, ownerId string, folderId string) (*model.Document, error) {
+	documentObjectId, err := primitive.ObjectIDFromHex(documentId)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	update := bson.M{"$unset": bson.M{"folderId": ""}}
+	if folderId != "" {
+		exists, err := r.FolderExists(ctx, folderId, ownerId)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, ErrFolderNotFound
+		}
+		update = bson.M{"$set": bson.M{"folderId": folderId}}
+	}
+
+	// Filing a document away is not an edit, so updatedAt stays
+	filter := notTrashed(tenantScoped(ctx, bson.M{"_id": documentObjectId, "ownerId": ownerId}))
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"slides": 0})
+
+	var document model.Document
+	err = r.documentCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&document)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrDocumentNotFound
+	}
+	if err != nil {
+		fmt.Printf("[FolderRepository][MoveDocument] Error moving document: %v\n", err)
+		return nil, err
+	}
+	if r.cache != nil {
+		r.cache.Invalidate(ctx, documentId)
+	}
+	return &document, nil
+}
+
+// DeleteUserFolders deletes every folder of a deleted user and returns how
+// many there were.
+func (r *FolderRepository) DeleteUserFolders(ctx context.Context, ownerId string) (int, error) {
+	result, err := r.collection.DeleteMany(ctx, tenantScoped(ctx, bson.M{"ownerId": ownerId}))
+	if err != nil {
+		fmt.Printf("[FolderRepository][DeleteUserFolders] Error deleting folders: %v\n", err)
+		return 0, err
+	}
+	return int(result.DeletedCount), nil
+}
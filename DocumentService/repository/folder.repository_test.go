@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"shared/model"
+	"shared/tenant"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testFolders returns a folder repository over the database of r.
+func testFolders(r *DocumentRepository) *FolderRepository {
+	return NewFolderRepository(r.client, r.collection.Database().Name(), model.FolderCollection, model.DocumentCollection)
+}
+
+func TestFolderMalformedIDsAreErrInvalidID(t *testing.T) {
+	// Refused before the database
+	r := &FolderRepository{}
+	ctx := context.Background()
+
+	if _, err := r.RenameFolder(ctx, "f-1", "u-1", "Plans"); !errors.Is(err, ErrInvalidID) {
+		t.Errorf("RenameFolder: %v, want ErrInvalidID", err)
+	}
+	if err := r.DeleteFolder(ctx, "f-1", "u-1"); !errors.Is(err, ErrInvalidID) {
+		t.Errorf("DeleteFolder: %v, want ErrInvalidID", err)
+	}
+	if _, err := r.MoveDocument(ctx, "d-1", "u-1", ""); !errors.Is(err, ErrInvalidID) {
+		t.Errorf("MoveDocument: %v, want ErrInvalidID", err)
+	}
+}
+
+func TestDeleteFolderIsRefusedWhileNotEmpty(t *testing.T) {
+	r := testRepository(t)
+	folders := testFolders(r)
+	ctx := tenant.WithID(context.Background(), "acme")
+	owner := primitive.NewObjectID().Hex()
+
+	parent, err := folders.CreateFolder(ctx, owner, "Work", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := folders.CreateFolder(ctx, owner, "Q3", parent.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	document, err := r.CreateNewDocument(ctx, "Plan", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := folders.MoveDocument(ctx, document.ID.Hex(), owner, child.ID.Hex()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A subfolder, then a document, keep their folder
+	if err := folders.DeleteFolder(ctx, parent.ID.Hex(), owner); !errors.Is(err, ErrFolderNotEmpty) {
+		t.Errorf("deleting a folder with a subfolder: %v, want ErrFolderNotEmpty", err)
+	}
+	if err := folders.DeleteFolder(ctx, child.ID.Hex(), owner); !errors.Is(err, ErrFolderNotEmpty) {
+		t.Errorf("deleting a folder with a document: %v, want ErrFolderNotEmpty", err)
+	}
+	if got, _ := folders.FindFolders(ctx, owner); len(got) != 2 {
+		t.Fatalf("folders after refused deletions = %v, want both", got)
+	}
+
+	// Trashed documents do not count, and are restored to the root
+	if err := r.TrashDocument(ctx, document.ID.Hex(), owner); err != nil {
+		t.Fatal(err)
+	}
+	if err := folders.DeleteFolder(ctx, child.ID.Hex(), owner); err != nil {
+		t.Fatalf("deleting a folder holding only trash: %v", err)
+	}
+	restored, err := r.RestoreDocument(ctx, document.ID.Hex(), owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.FolderID != "" {
+		t.Errorf("restored into deleted folder %q, want the root", restored.FolderID)
+	}
+
+	if err := folders.DeleteFolder(ctx, parent.ID.Hex(), owner); err != nil {
+		t.Fatalf("deleting the emptied parent: %v", err)
+	}
+	if err := folders.DeleteFolder(ctx, parent.ID.Hex(), owner); !errors.Is(err, ErrFolderNotFound) {
+		t.Errorf("deleting it again: %v, want ErrFolderNotFound", err)
+	}
+}
+
+func TestFoldersArePerOwner(t *testing.T) {
+	r := testRepository(t)
+	folders := testFolders(r)
+	ctx := tenant.WithID(context.Background(), "acme")
+	owner, collaborator := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+
+	folder, err := folders.CreateFolder(ctx, owner, "Work", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	theirs, err := folders.CreateFolder(ctx, collaborator, "Shared with me", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	document, err := r.CreateNewDocument(ctx, "Plan", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.CreateCollaborationRecord(ctx, collaborator, document.ID.Hex(), "write"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := folders.CreateFolder(ctx, collaborator, "Inside", folder.ID.Hex()); !errors.Is(err, ErrFolderNotFound) {
+		t.Errorf("creating a folder in someone else's: %v, want ErrFolderNotFound", err)
+	}
+	if _, err := folders.RenameFolder(ctx, folder.ID.Hex(), collaborator, "Mine"); !errors.Is(err, ErrFolderNotFound) {
+		t.Errorf("renaming someone else's folder: %v, want ErrFolderNotFound", err)
+	}
+	if err := folders.DeleteFolder(ctx, folder.ID.Hex(), collaborator); !errors.Is(err, ErrFolderNotFound) {
+		t.Errorf("deleting someone else's folder: %v, want ErrFolderNotFound", err)
+	}
+	// Collaborators cannot file a shared document, in either's folder
+	if _, err := folders.MoveDocument(ctx, document.ID.Hex(), collaborator, theirs.ID.Hex()); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("collaborator filing the document: %v, want ErrDocumentNotFound", err)
+	}
+	if _, err := folders.MoveDocument(ctx, document.ID.Hex(), owner, theirs.ID.Hex()); !errors.Is(err, ErrFolderNotFound) {
+		t.Errorf("owner filing into the collaborator's folder: %v, want ErrFolderNotFound", err)
+	}
+
+	moved, err := folders.MoveDocument(ctx, document.ID.Hex(), owner, folder.ID.Hex())
+	if err != nil || moved.FolderID != folder.ID.Hex() {
+		t.Fatalf("owner filing the document: %+v, %v", moved, err)
+	}
+	// The owner's folder is invisible to the collaborator's listing
+	shared, _, err := r.FindSharedDocuments(ctx, collaborator, ListOptions{FolderID: theirs.ID.Hex()})
+	if err != nil || len(shared) != 1 {
+		t.Errorf("shared documents of the collaborator with a folder filter = %v, %v, want the document regardless", shared, err)
+	}
+	owned, _, err := r.FindOwnedDocuments(ctx, owner, ListOptions{FolderID: RootFolder})
+	if err != nil || len(owned) != 0 {
+		t.Errorf("owner's root = %v, %v, want the document filed away", owned, err)
+	}
+
+	// Other owners' documents do not keep the folder from being deleted
+	if _, err := folders.MoveDocument(ctx, document.ID.Hex(), owner, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := folders.DeleteFolder(ctx, theirs.ID.Hex(), collaborator); err != nil {
+		t.Errorf("deleting the collaborator's empty folder: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"context"
+	"document-service/model"
+	"fmt"
+	"shared/tenant"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// HistoryRepository reads the operations and snapshots DocumentUpdatesConsumer
+// records for each document. Snapshots make up a document's version history;
+// restoring a version records one here as well.
+type HistoryRepository struct {
+	operationCollection *mongo.Collection
+	snapshotCollection  *mongo.Collection
+}
+
+func NewHistoryRepository(client *mongo.Client, database string, operationCollection string, snapshotCollection string) *HistoryRepository {
+	return &HistoryRepository{
+		operationCollection: client.Database(database).Collection(operationCollection),
+		snapshotCollection:  client.Database(database).Collection(snapshotCollection),
+	}
+}
+
+// FindOperations returns the most recent operations of a document, newest first.
+func (r *HistoryRepository) FindOperations(ctx context.Context, documentId string, limit int64) ([]model.Operation, error) {
+	filter := tenantScoped(ctx, bson.M{"documentId": documentId})
+	opts := options.Find().SetSort(bson.D{{Key: "appliedAt", Value: -1}}).SetLimit(limit)
+
+	cursor, err := r.operationCollection.Find(ctx, filter, opts)
+	if err != nil {
+		fmt.Printf("[HistoryRepository][FindOperations] Error retrieving operations: %v\n", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	operations := []model.Operation{}
+	if err := cursor.All(ctx, &operations); err != nil {
+		fmt.Printf("[HistoryRepository][FindOperations] Error decoding operations: %v\n", err)
+		return nil, err
+	}
+
+	return operations, nil
+}
+
+// FindLatestSnapshot returns the highest-version snapshot, or nil if there is none.
+func (r *HistoryRepository) FindLatestSnapshot(ctx context.Context, documentId string) (*model.Snapshot, error) {
+	filter := tenantScoped(ctx, bson.M{"documentId": documentId})
+	opts := options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}})
+
+	var snapshot model.Snapshot
+	err := r.snapshotCollection.FindOne(ctx, filter, opts).Decode(&snapshot)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		fmt.Printf("[HistoryRepository][FindLatestSnapshot] Error retrieving snapshot: %v\n", err)
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+// FindVersions returns a page of a document's snapshots without their
+// content, newest first, together with how many there are.
+func (r *HistoryRepository) FindVersions(ctx context.Context, documentId string, limit int64, offset int64) ([]model.Snapshot, int64, error) {
+	filter := tenantScoped(ctx, bson.M{"documentId": documentId})
+
+	total, err := r.snapshotCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		fmt.Printf("[HistoryRepository][FindVersions] Error counting snapshots: %v\n", err)
+		return []model.Snapshot{}, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "version", Value: -1}}).
+		SetSkip(offset).
+		SetLimit(limit).
+		SetProjection(bson.M{"slides": 0})
+	cursor, err := r.snapshotCollection.Find(ctx, filter, opts)
+	if err != nil {
+		fmt.Printf("[HistoryRepository][FindVersions] Error retrieving snapshots: %v\n", err)
+		return []model.Snapshot{}, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	snapshots := []model.Snapshot{}
+	if err := cursor.All(ctx, &snapshots); err != nil {
+		fmt.Printf("[HistoryRepository][FindVersions] Error decoding snapshots: %v\n", err)
+		return []model.Snapshot{}, 0, err
+	}
+	return snapshots, total, nil
+}
+
+// FindSnapshot returns the snapshot of a document at version, or nil if there
+// is none.
+func (r *HistoryRepository) FindSnapshot(ctx context.Context, documentId string, version int64) (*model.Snapshot, error) {
+	filter := tenantScoped(ctx, bson.M{"documentId": documentId, "version": version})
+
+	var snapshot model.Snapshot
+	err := r.snapshotCollection.FindOne(ctx, filter).Decode(&snapshot)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		fmt.Printf("[HistoryRepository][FindSnapshot] Error retrieving snapshot: %v\n", err)
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// RecordSnapshot adds the document's content at a version to its history,
+// then drops the oldest snapshots beyond the keep newest.
+func (r *HistoryRepository) RecordSnapshot(ctx context.Context, document model.Document, userId string, keep int64) error {
+	documentId := document.ID.Hex()
+	_, err := r.snapshotCollection.InsertOne(ctx, model.Snapshot{
+		DocumentID: documentId,
+		TenantID:   tenant.FromContext(ctx),
+		Version:    document.Version,
+		UserID:     userId,
+		Title:      document.Title,
+		Slides:     document.Slides,
+		CreatedAt:  time.Now().UTC(),
+	})
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		fmt.Printf("[HistoryRepository][RecordSnapshot] Error recording snapshot: %v\n", err)
+		return err
+	}
+
+	// Drop the snapshots beyond the newest keep
+	filter := tenantScoped(ctx, bson.M{"documentId": documentId})
+	opts := options.FindOne().
+		SetSort(bson.D{{Key: "version", Value: -1}}).
+		SetSkip(keep).
+		SetProjection(bson.M{"version": 1})
+	var newestDropped model.Snapshot
+	err = r.snapshotCollection.FindOne(ctx, filter, opts).Decode(&newestDropped)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		fmt.Printf("[HistoryRepository][RecordSnapshot] Error finding old snapshots: %v\n", err)
+		return err
+	}
+	filter["version"] = bson.M{"$lte": newestDropped.Version}
+	if _, err := r.snapshotCollection.DeleteMany(ctx, filter); err != nil {
+		fmt.Printf("[HistoryRepository][RecordSnapshot] Error deleting old snapshots: %v\n", err)
+		return err
+	}
+	return nil
+}
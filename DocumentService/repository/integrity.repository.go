@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"context"
+	"document-service/model"
+	"fmt"
+	"shared/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Reasons a shared record is reported as orphaned.
+const (
+	OrphanInvalidDocumentID = "invalid document id"
+	OrphanMissingDocument   = "document does not exist"
+	OrphanTenantMismatch    = "document belongs to another tenant"
+)
+
+// OrphanedShare is a shared record that no longer points at a usable document.
+type OrphanedShare struct {
+	Record model.CollaborationRecord `json:"record"`
+	Reason string                    `json:"reason"`
+}
+
+// integrityBatchSize bounds the $in list of a single document lookup.
+const integrityBatchSize = 500
+
+// FindOrphanedShares scans every shared record across all tenants. It is meant
+// for operators and ignores the tenant carried by ctx.
+func (r *DocumentRepository) FindOrphanedShares(ctx context.Context) ([]OrphanedShare, error) {
+	cursor, err := r.sharedDocRecordCollection.Find(ctx, bson.M{})
+	if err != nil {
+		fmt.Printf("[DocumentRepository][FindOrphanedShares] Error retrieving shared records: %v\n", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	orphans := []OrphanedShare{}
+	batch := []model.CollaborationRecord{}
+
+	for cursor.Next(ctx) {
+		var record model.CollaborationRecord
+		if err := cursor.Decode(&record); err != nil {
+			return nil, err
+		}
+		batch = append(batch, record)
+
+		if len(batch) == integrityBatchSize {
+			found, err := r.orphansIn(ctx, batch)
+			if err != nil {
+				return nil, err
+			}
+			orphans = append(orphans, found...)
+			batch = batch[:0]
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	found, err := r.orphansIn(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+	return append(orphans, found...), nil
+}
+
+func (r *DocumentRepository) orphansIn(ctx context.Context, records []model.CollaborationRecord) ([]OrphanedShare, error) {
+	var orphans []OrphanedShare
+	var ids []primitive.ObjectID
+
+	for _, record := range records {
+		id, err := primitive.ObjectIDFromHex(record.DocumentID)
+		if err != nil {
+			orphans = append(orphans, OrphanedShare{Record: record, Reason: OrphanInvalidDocumentID})
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return orphans, nil
+	}
+
+	opts := options.Find().SetProjection(bson.M{"_id": 1, "tenantId": 1})
+	cursor, err := r.collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var documents []model.Document
+	if err := cursor.All(ctx, &documents); err != nil {
+		return nil, err
+	}
+
+	tenants := make(map[string]string, len(documents))
+	for _, document := range documents {
+		tenants[document.ID.Hex()] = tenant.Normalize(document.TenantID)
+	}
+
+	for _, record := range records {
+		documentTenant, ok := tenants[record.DocumentID]
+		switch {
+		case !primitive.IsValidObjectID(record.DocumentID):
+			// already reported
+		case !ok:
+			orphans = append(orphans, OrphanedShare{Record: record, Reason: OrphanMissingDocument})
+		case documentTenant != tenant.Normalize(record.TenantID):
+			orphans = append(orphans, OrphanedShare{Record: record, Reason: OrphanTenantMismatch})
+		}
+	}
+	return orphans, nil
+}
+
+// DeleteCollaborationRecords removes shared records by ID and returns how many were deleted.
+func (r *DocumentRepository) DeleteCollaborationRecords(ctx context.Context, ids []primitive.ObjectID) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	result, err := r.sharedDocRecordCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		fmt.Printf("[DocumentRepository][DeleteCollaborationRecords] Error deleting records: %v\n", err)
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"context"
+	"document-service/model"
+	"fmt"
+	"shared/events"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OutboxRepository stores events that still have to be relayed to Kafka.
+type OutboxRepository struct {
+	client           *mongo.Client
+	collection       *mongo.Collection
+	parkedCollection *mongo.Collection
+	topic            string
+}
+
+func NewOutboxRepository(client *mongo.Client, database string, collection string, parkedCollection string, topic string) *OutboxRepository {
+	return &OutboxRepository{
+		client:           client,
+		collection:       client.Database(database).Collection(collection),
+		parkedCollection: client.Database(database).Collection(parkedCollection),
+		topic:            topic,
+	}
+}
+
+// Append inserts a pending event. Pass the session context of the surrounding
+// transaction so the event commits or rolls back together with the state change.
+func (r *OutboxRepository) Append(ctx context.Context, e events.Event) error {
+	envelope, err := events.Encode(e)
+	if err != nil {
+		return fmt.Errorf("error encoding outbox event: %w", err)
+	}
+
+	event := model.OutboxEvent{
+		AggregateID: envelope.AggregateID,
+		Topic:       r.topic,
+		EventType:   envelope.Type,
+		Payload:     string(envelope.Payload),
+		Status:      model.OutboxStatusPending,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	if _, err := r.collection.InsertOne(ctx, event); err != nil {
+		fmt.Printf("[OutboxRepository] Error appending %s event: %v\n", envelope.Type, err)
+		return err
+	}
+
+	return nil
+}
+
+// FindPending returns up to limit pending events in insertion order.
+func (r *OutboxRepository) FindPending(ctx context.Context, limit int64) ([]model.OutboxEvent, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(limit)
+
+	cursor, err := r.collection.Find(ctx, bson.M{"status": model.OutboxStatusPending}, opts)
+	if err != nil {
+		fmt.Printf("[OutboxRepository][FindPending] Error retrieving events: %v\n", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []model.OutboxEvent{}
+	if err = cursor.All(ctx, &events); err != nil {
+		fmt.Printf("[OutboxRepository][FindPending] Error decoding events: %v\n", err)
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// MarkSent flags an event as relayed.
+func (r *OutboxRepository) MarkSent(ctx context.Context, id primitive.ObjectID) error {
+	update := bson.M{"$set": bson.M{"status": model.OutboxStatusSent, "sentAt": time.Now().UTC()}}
+	_, err := r.collection.UpdateByID(ctx, id, update)
+	return err
+}
+
+// RecordFailure increments the attempt counter and returns the new count.
+func (r *OutboxRepository) RecordFailure(ctx context.Context, id primitive.ObjectID, cause error) (int, error) {
+	update := bson.M{
+		"$inc": bson.M{"attempts": 1},
+		"$set": bson.M{"lastError": cause.Error()},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var event model.OutboxEvent
+	if err := r.collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&event); err != nil {
+		return 0, err
+	}
+	return event.Attempts, nil
+}
+
+// Park moves a poison event out of the relay path into the parking lot collection.
+func (r *OutboxRepository) Park(ctx context.Context, event model.OutboxEvent) error {
+	now := time.Now().UTC()
+	event.ParkedAt = &now
+
+	if _, err := r.parkedCollection.InsertOne(ctx, event); err != nil {
+		return fmt.Errorf("error parking outbox event: %w", err)
+	}
+
+	if _, err := r.collection.DeleteOne(ctx, bson.M{"_id": event.ID}); err != nil {
+		return fmt.Errorf("error removing parked outbox event: %w", err)
+	}
+
+	return nil
+}
+
+// PendingStats returns the number of pending events and the creation time of the oldest one.
+func (r *OutboxRepository) PendingStats(ctx context.Context) (int64, *time.Time, error) {
+	filter := bson.M{"status": model.OutboxStatusPending}
+
+	count, err := r.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, nil, err
+	}
+	if count == 0 {
+		return 0, nil, nil
+	}
+
+	var oldest model.OutboxEvent
+	opts := options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	if err := r.collection.FindOne(ctx, filter, opts).Decode(&oldest); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return 0, nil, nil
+		}
+		return 0, nil, err
+	}
+
+	return count, &oldest.CreatedAt, nil
+}
+
+// FindParked returns up to limit parked events, oldest first.
+func (r *OutboxRepository) FindParked(ctx context.Context, limit int64) ([]model.OutboxEvent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "parkedAt", Value: 1}}).SetLimit(limit)
+
+	cursor, err := r.parkedCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []model.OutboxEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Redrive moves a parked event back into the outbox as pending with a fresh
+// attempt budget. Running it twice for the same event is harmless.
+func (r *OutboxRepository) Redrive(ctx context.Context, event model.OutboxEvent) error {
+	event.Status = model.OutboxStatusPending
+	event.Attempts = 0
+	event.LastError = ""
+	event.ParkedAt = nil
+
+	if _, err := r.collection.InsertOne(ctx, event); err != nil && !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("error re-inserting parked event: %w", err)
+	}
+
+	if _, err := r.parkedCollection.DeleteOne(ctx, bson.M{"_id": event.ID}); err != nil {
+		return fmt.Errorf("error removing redriven event from parking lot: %w", err)
+	}
+
+	return nil
+}
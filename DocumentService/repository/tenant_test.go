@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"context"
+	"shared/tenant"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestTenantScopedFilter(t *testing.T) {
+	filter := tenantScoped(tenant.WithID(context.Background(), "acme"), bson.M{"_id": "doc-1"})
+	if filter["tenantId"] != "acme" {
+		t.Errorf("tenantId filter = %v, want acme", filter["tenantId"])
+	}
+	if filter["_id"] != "doc-1" {
+		t.Errorf("filter lost its other conditions: %v", filter)
+	}
+
+	// Records written before tenants existed belong to the default tenant;
+	// a named tenant must not see them
+	filter = tenantScoped(context.Background(), bson.M{})
+	in, ok := filter["tenantId"].(bson.M)["$in"].(bson.A)
+	if !ok || len(in) != 2 || in[0] != tenant.DefaultID || in[1] != nil {
+		t.Errorf("default tenantId filter = %v, want default or missing", filter["tenantId"])
+	}
+}
@@ -2,24 +2,648 @@ package types
 
 import (
 	"document-service/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"shared/apierror"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Page sizes of document listings. Without ?limit= a listing returns up to
+// MaxPageSize documents.
+const (
+	DefaultPageSize = 200
+	MaxPageSize     = 200
+)
+
+// Bounds of the ?q= of a document search, in characters.
+const (
+	MinSearchLength = 2
+	MaxSearchLength = 100
 )
 
+// PageInfo describes one page of a listing. NextOffset is absent on the last page.
+type PageInfo struct {
+	Total      int64  `json:"total"`
+	Limit      int64  `json:"limit"`
+	Offset     int64  `json:"offset"`
+	NextOffset *int64 `json:"nextOffset,omitempty"`
+}
+
+func NewPageInfo(total int64, limit int64, offset int64, count int) PageInfo {
+	page := PageInfo{Total: total, Limit: limit, Offset: offset}
+	if next := offset + int64(count); count > 0 && next < total {
+		page.NextOffset = &next
+	}
+	return page
+}
+
 // Dtos
+
+// DocumentDto is a document together with the caller's access level on it:
+// "owner", "write", or "read", whether the caller starred it, and when they
+// last opened it, if ever. Clients decide whether to offer editing from
+// AccessLevel, never from the owner ID. Documents shared with the caller name
+// their owner's username, UnknownUsername when it cannot be looked up.
+type DocumentDto struct {
+	model.Document
+	AccessLevel   string     `json:"accessLevel"`
+	IsFavorite    bool       `json:"isFavorite"`
+	LastOpenedAt  *time.Time `json:"lastOpenedAt,omitempty"`
+	OwnerUsername string     `json:"ownerUsername,omitempty"`
+}
+
+// Sizes of the recently opened documents listing.
+const (
+	DefaultRecentLimit = 10
+	MaxRecentLimit     = 50
+)
+
+// RecentDocumentsDto lists the documents the caller opened most recently,
+// newest first.
+type RecentDocumentsDto struct {
+	Documents []DocumentDto `json:"documents"`
+}
+
 type AllDocumentsDto struct {
-	OwnedDocuments  []model.Document `json:"ownedDocuments"`
-	SharedDocuments []model.Document `json:"sharedDocuments"`
+	OwnedDocuments  []DocumentDto `json:"ownedDocuments"`
+	SharedDocuments []DocumentDto `json:"sharedDocuments"`
+	OwnedPage       PageInfo      `json:"ownedPage"`
+	SharedPage      PageInfo      `json:"sharedPage"`
+	// Folders are all of the user's folders, whichever folder is listed
+	Folders []model.Folder `json:"folders"`
+}
+
+// ValidateObjectID reports why id, the value of field, is not an ID. Document,
+// user, and folder IDs are MongoDB ObjectIDs: 24 hexadecimal characters.
+func ValidateObjectID(field string, id string) error {
+	switch {
+	case id == "":
+		return fmt.Errorf("%s is required", field)
+	case len(id) != 24:
+		return fmt.Errorf("%s must be 24 characters long", field)
+	case !primitive.IsValidObjectID(id):
+		return fmt.Errorf("%s must be hexadecimal", field)
+	}
+	return nil
+}
+
+// AccessLevelDto is the caller's access to a document, "owner", "write", or
+// "read".
+type AccessLevelDto struct {
+	DocumentID  string `json:"documentId"`
+	AccessLevel string `json:"accessLevel"`
+}
+
+// SnapshotDto is a document's content at a version, with the live updates
+// it has: see model.Document's Seq and Applied.
+type SnapshotDto struct {
+	DocumentID string        `json:"documentId"`
+	Version    int64         `json:"version"`
+	Slides     []model.Slide `json:"slides"`
+	Seq        string        `json:"seq,omitempty"`
+	Applied    []string      `json:"applied,omitempty"`
+}
+
+// TransferOwnershipData is the payload of POST /document/:id/transfer.
+type TransferOwnershipData struct {
+	NewOwnerUserID string `json:"newOwnerUserId" binding:"required"`
+}
+
+// TrashDto is a page of the documents in the user's trash.
+type TrashDto struct {
+	Documents []model.Document `json:"documents"`
+	Page      PageInfo         `json:"page"`
 }
 
 type CreatedResponse struct {
 	ID string `json:"id"`
 }
 
+// AccessType is the access a share grants its collaborator.
+type AccessType string
+
+const (
+	AccessRead  AccessType = "read"
+	AccessWrite AccessType = "write"
+)
+
+// ErrInvalidAccessType is returned for access types other than "read" and "write".
+var ErrInvalidAccessType = errors.New(`accessType must be "read" or "write"`)
+
+// ParseAccessType accepts "read" and "write", ignoring case and surrounding space.
+func ParseAccessType(value string) (AccessType, error) {
+	switch accessType := AccessType(strings.ToLower(strings.TrimSpace(value))); accessType {
+	case AccessRead, AccessWrite:
+		return accessType, nil
+	}
+	return "", ErrInvalidAccessType
+}
+
+// UnmarshalJSON makes binding fail with ErrInvalidAccessType on unknown values.
+func (a *AccessType) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return ErrInvalidAccessType
+	}
+	accessType, err := ParseAccessType(value)
+	if err != nil {
+		return err
+	}
+	*a = accessType
+	return nil
+}
+
+// ShareDocumentPostData names the collaborator by user ID, by email, or by
+// both, which must then belong to the same user.
 type ShareDocumentPostData struct {
+	CollaboratorUserID string     `json:"collaboratorUserId"`
+	CollaboratorEmail  string     `json:"collaboratorEmail"`
+	DocumentID         string     `json:"documentId"`
+	AccessType         AccessType `json:"accessType"`
+}
+
+// BulkShareData is the payload of POST /document/:id/share/bulk. Each entry
+// names its collaborator like ShareDocumentPostData; the access type is
+// checked per entry, so one bad entry does not fail the others.
+type BulkShareData struct {
+	Shares []BulkShareEntry `json:"shares" binding:"required"`
+}
+
+type BulkShareEntry struct {
 	CollaboratorUserID string `json:"collaboratorUserId"`
-	DocumentID         string `json:"documentId"`
+	CollaboratorEmail  string `json:"collaboratorEmail"`
 	AccessType         string `json:"accessType"`
 }
 
+// Outcomes of one entry of a bulk share.
+const (
+	ShareCreated           = "created"
+	ShareUpdated           = "updated"
+	ShareUnchanged         = "unchanged"
+	ShareInvalidUser       = "invalid_user"
+	ShareSelf              = "self_share"
+	ShareInvalidAccessType = "invalid_access_type"
+	ShareDuplicate         = "duplicate"
+	ShareLookupFailed      = "lookup_failed"
+)
+
+// BulkShareResult is the outcome of the bulk share entry at the same index.
+// CollaboratorUserID is set once the collaborator was resolved.
+type BulkShareResult struct {
+	CollaboratorUserID string `json:"collaboratorUserId,omitempty"`
+	CollaboratorEmail  string `json:"collaboratorEmail,omitempty"`
+	Result             string `json:"result"`
+}
+
+type BulkShareResponse struct {
+	Results []BulkShareResult `json:"results"`
+}
+
 type DeleteDocumentPostData struct {
 	DocumentID string `json:"documentId"`
 }
+
+// BulkDeleteData is the payload of POST /document/bulk-delete.
+type BulkDeleteData struct {
+	DocumentIDs []string `json:"documentIds" binding:"required"`
+}
+
+// BulkDeleteResponse maps every requested ID to "deleted", "forbidden", or
+// "not_found".
+type BulkDeleteResponse struct {
+	Results map[string]string `json:"results"`
+}
+
+// MaxTitleLength is the longest document title accepted, in characters.
+const MaxTitleLength = 200
+
+// RenameDocumentData is the payload of PATCH /document/:id/title.
+type RenameDocumentData struct {
+	Title string `json:"title"`
+}
+
+// Normalize trims the title.
+func (d *RenameDocumentData) Normalize() {
+	d.Title = strings.TrimSpace(d.Title)
+}
+
+// Validate reports why the title is unacceptable. Call Normalize first.
+func (d RenameDocumentData) Validate() error {
+	switch {
+	case d.Title == "":
+		return errors.New("title is required")
+	case utf8.RuneCountInString(d.Title) > MaxTitleLength:
+		return errors.New("title must be at most 200 characters")
+	case strings.IndexFunc(d.Title, unicode.IsControl) >= 0:
+		return errors.New("title must not contain control characters")
+	}
+	return nil
+}
+
+// MaxFolderNameLength is the longest folder name accepted, in characters.
+const MaxFolderNameLength = 100
+
+// FolderData is the payload of POST /document/folders and, without ParentID,
+// of PATCH /document/folders/:folderId. A folder without ParentID is created
+// at the root.
+type FolderData struct {
+	Name     string `json:"name"`
+	ParentID string `json:"parentId"`
+}
+
+// Normalize trims the name.
+func (d *FolderData) Normalize() {
+	d.Name = strings.TrimSpace(d.Name)
+}
+
+// Validate reports why the name is unacceptable. Call Normalize first.
+func (d FolderData) Validate() error {
+	switch {
+	case d.Name == "":
+		return errors.New("name is required")
+	case utf8.RuneCountInString(d.Name) > MaxFolderNameLength:
+		return errors.New("name must be at most 100 characters")
+	case strings.IndexFunc(d.Name, unicode.IsControl) >= 0:
+		return errors.New("name must not contain control characters")
+	}
+	return nil
+}
+
+// MoveDocumentData is the payload of PATCH /document/:id/folder. An empty or
+// null FolderID moves the document to the root.
+type MoveDocumentData struct {
+	FolderID string `json:"folderId"`
+}
+
+// Limits on document tags, in characters and tags per document.
+const (
+	MaxTagLength       = 32
+	MaxTagsPerDocument = 20
+)
+
+// NormalizeTag trims and lowercases a tag and reports why it is
+// unacceptable, if it is.
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	switch {
+	case tag == "":
+		return "", errors.New("tags must not be empty")
+	case utf8.RuneCountInString(tag) > MaxTagLength:
+		return "", fmt.Errorf("tags must be at most %d characters", MaxTagLength)
+	case strings.ContainsRune(tag, '/') || strings.IndexFunc(tag, unicode.IsControl) >= 0:
+		return "", errors.New("tags must not contain slashes or control characters")
+	}
+	return tag, nil
+}
+
+// AddTagsData is the payload of POST /document/:id/tags.
+type AddTagsData struct {
+	Tags []string `json:"tags"`
+}
+
+// Normalize normalizes every tag, dropping duplicates, and reports the first
+// that is unacceptable.
+func (d *AddTagsData) Normalize() error {
+	if len(d.Tags) == 0 {
+		return errors.New("tags must list at least one tag")
+	}
+
+	seen := make(map[string]bool, len(d.Tags))
+	tags := make([]string, 0, len(d.Tags))
+	for _, tag := range d.Tags {
+		tag, err := NormalizeTag(tag)
+		if err != nil {
+			return err
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > MaxTagsPerDocument {
+		return fmt.Errorf("a document can have at most %d tags", MaxTagsPerDocument)
+	}
+	d.Tags = tags
+	return nil
+}
+
+// TagsResponse lists a document's tags after a change.
+type TagsResponse struct {
+	Tags []string `json:"tags"`
+}
+
+// TagCountDto is one of the user's tags and how many of their documents
+// carry it.
+type TagCountDto struct {
+	Tag   string `json:"tag" bson:"_id"`
+	Count int64  `json:"count" bson:"count"`
+}
+
+// MaxDescriptionLength is the longest document description accepted, in
+// characters.
+const MaxDescriptionLength = 2000
+
+// ErrEmptyPatch is returned for a document patch that names no field.
+var ErrEmptyPatch = errors.New("the patch must change at least one field")
+
+// UnknownFieldsError lists the fields of a document patch that cannot be
+// changed through it.
+type UnknownFieldsError struct {
+	Fields []string
+}
+
+func (e *UnknownFieldsError) Error() string {
+	return "these fields cannot be changed: " + strings.Join(e.Fields, ", ")
+}
+
+// DocumentPatch is the payload of PATCH /document/:id. Only the fields it
+// names change: nil fields stay as they are. A null or empty folderId moves
+// the document to the root, a null or empty description or tags clears them,
+// and a null archived unarchives it.
+type DocumentPatch struct {
+	Title       *string   `json:"title"`
+	Description *string   `json:"description"`
+	Tags        *[]string `json:"tags"`
+	FolderID    *string   `json:"folderId"`
+	Archived    *bool     `json:"archived"`
+}
+
+// patchFields are the JSON fields a DocumentPatch may name.
+var patchFields = map[string]bool{"title": true, "description": true, "tags": true, "folderId": true, "archived": true}
+
+// ParseDocumentPatch decodes a PATCH /document/:id body. It returns
+// ErrEmptyPatch for an empty object and an *UnknownFieldsError when the body
+// names fields outside the whitelist.
+func ParseDocumentPatch(body []byte) (DocumentPatch, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return DocumentPatch{}, err
+	}
+	if len(fields) == 0 {
+		return DocumentPatch{}, ErrEmptyPatch
+	}
+
+	var unknown []string
+	for field := range fields {
+		if !patchFields[field] {
+			unknown = append(unknown, field)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return DocumentPatch{}, &UnknownFieldsError{Fields: unknown}
+	}
+
+	var patch DocumentPatch
+	if err := json.Unmarshal(body, &patch); err != nil {
+		return DocumentPatch{}, err
+	}
+
+	// A null clears its field rather than leaving it, which for the title
+	// fails validation
+	if _, ok := fields["title"]; ok && patch.Title == nil {
+		patch.Title = new(string)
+	}
+	if _, ok := fields["description"]; ok && patch.Description == nil {
+		patch.Description = new(string)
+	}
+	if _, ok := fields["tags"]; ok && patch.Tags == nil {
+		patch.Tags = &[]string{}
+	}
+	if _, ok := fields["folderId"]; ok && patch.FolderID == nil {
+		patch.FolderID = new(string)
+	}
+	if _, ok := fields["archived"]; ok && patch.Archived == nil {
+		patch.Archived = new(bool)
+	}
+	return patch, nil
+}
+
+// OwnerOnly reports whether the patch changes fields only the owner may:
+// where the owner filed the document, and whether it is archived.
+func (p DocumentPatch) OwnerOnly() bool {
+	return p.FolderID != nil || p.Archived != nil
+}
+
+// Normalize normalizes the fields the patch names like their single-field
+// endpoints do, and reports the first that is unacceptable.
+func (p *DocumentPatch) Normalize() error {
+	if p.Title != nil {
+		data := RenameDocumentData{Title: *p.Title}
+		data.Normalize()
+		if err := data.Validate(); err != nil {
+			return err
+		}
+		p.Title = &data.Title
+	}
+
+	if p.Description != nil {
+		description := strings.TrimSpace(*p.Description)
+		switch {
+		case utf8.RuneCountInString(description) > MaxDescriptionLength:
+			return fmt.Errorf("description must be at most %d characters", MaxDescriptionLength)
+		case strings.IndexFunc(description, func(r rune) bool { return unicode.IsControl(r) && r != '\n' && r != '\t' }) >= 0:
+			return errors.New("description must not contain control characters other than newlines and tabs")
+		}
+		p.Description = &description
+	}
+
+	if p.Tags != nil && len(*p.Tags) > 0 {
+		data := AddTagsData{Tags: *p.Tags}
+		if err := data.Normalize(); err != nil {
+			return err
+		}
+		p.Tags = &data.Tags
+	}
+
+	if p.FolderID != nil {
+		folderId := strings.TrimSpace(*p.FolderID)
+		if folderId != "" {
+			if err := ValidateObjectID("folderId", folderId); err != nil {
+				return err
+			}
+		}
+		p.FolderID = &folderId
+	}
+	return nil
+}
+
+// UpdateContentData is the payload of PUT /document/:id/content; it replaces
+// every slide of the document. BaseVersion is the version the new content is
+// based on, unless it is given in an If-Match header instead.
+type UpdateContentData struct {
+	Slides      []model.Slide `json:"slides"`
+	BaseVersion *int64        `json:"baseVersion"`
+}
+
+// Validate requires at least one slide and unique, non-empty slide and
+// object IDs, which live updates address slides and objects by.
+func (d UpdateContentData) Validate() error {
+	if len(d.Slides) == 0 {
+		return errors.New("slides must contain at least one slide")
+	}
+
+	slideIDs := make(map[string]bool, len(d.Slides))
+	for i, slide := range d.Slides {
+		if slide.ID == "" || slideIDs[slide.ID] {
+			return fmt.Errorf("slides[%d] needs a unique id", i)
+		}
+		slideIDs[slide.ID] = true
+
+		objectIDs := make(map[string]bool, len(slide.Objects))
+		for j, object := range slide.Objects {
+			if object.ID == "" || objectIDs[object.ID] {
+				return fmt.Errorf("slides[%d].objects[%d] needs a unique id", i, j)
+			}
+			objectIDs[object.ID] = true
+		}
+	}
+	return nil
+}
+
+// ContentUpdatedResponse is returned after the content was replaced.
+type ContentUpdatedResponse struct {
+	ID        string    `json:"id"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Version   int64     `json:"version"`
+}
+
+// VersionConflictResponse is returned with a 409 when the content changed
+// since the base version of a write. It is the error envelope plus the
+// current version.
+type VersionConflictResponse struct {
+	Error   apierror.Detail `json:"error"`
+	Version int64           `json:"version"`
+}
+
+// ETag is the entity tag of a document version.
+func ETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// ParseETag returns the version an If-Match entity tag names.
+func ParseETag(tag string) (int64, error) {
+	unquoted, err := strconv.Unquote(strings.TrimPrefix(strings.TrimSpace(tag), "W/"))
+	if err != nil {
+		return 0, errors.New("If-Match must be a quoted document version, as in the ETag header")
+	}
+	version, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil || version < 0 {
+		return 0, errors.New("If-Match must be a quoted document version, as in the ETag header")
+	}
+	return version, nil
+}
+
+// UnknownUsername stands in for collaborators and owners whose account no
+// longer exists or could not be looked up.
+const UnknownUsername = "Unknown user"
+
+// CollaboratorDto is one entry of GET /document/:id/collaborators. Unknown is
+// set when the collaborator's account was deleted.
+type CollaboratorDto struct {
+	UserID     string    `json:"userId"`
+	Username   string    `json:"username"`
+	AccessType string    `json:"accessType"`
+	SharedAt   time.Time `json:"sharedAt"`
+	Unknown    bool      `json:"unknown,omitempty"`
+}
+
+// MaxAccessRequestMessageLength is the longest message accepted with an
+// access request, in characters.
+const MaxAccessRequestMessageLength = 500
+
+// AccessRequestData is the payload of POST /document/:id/request-access.
+// AccessType defaults to read.
+type AccessRequestData struct {
+	AccessType AccessType `json:"accessType"`
+	Message    string     `json:"message"`
+}
+
+// Normalize trims the message and defaults the access type.
+func (d *AccessRequestData) Normalize() {
+	d.Message = strings.TrimSpace(d.Message)
+	if d.AccessType == "" {
+		d.AccessType = AccessRead
+	}
+}
+
+// Validate reports why the request is unacceptable. Call Normalize first.
+func (d AccessRequestData) Validate() error {
+	if utf8.RuneCountInString(d.Message) > MaxAccessRequestMessageLength {
+		return fmt.Errorf("message must be at most %d characters", MaxAccessRequestMessageLength)
+	}
+	return nil
+}
+
+// ApproveAccessRequestData is the optional payload of approving an access
+// request. Without AccessType the requested access is granted.
+type ApproveAccessRequestData struct {
+	AccessType AccessType `json:"accessType"`
+}
+
+// AccessRequestDto is one entry of GET /document/:id/access-requests. Unknown
+// is set when the requester's account was deleted.
+type AccessRequestDto struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"userId"`
+	Username   string    `json:"username"`
+	AccessType string    `json:"accessType"`
+	Message    string    `json:"message,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Unknown    bool      `json:"unknown,omitempty"`
+}
+
+// VersionDto describes an entry of a document's version history.
+type VersionDto struct {
+	Version   int64     `json:"version"`
+	UserID    string    `json:"userId,omitempty"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// VersionsDto is a page of a document's version history, newest first.
+type VersionsDto struct {
+	Versions []VersionDto `json:"versions"`
+	Page     PageInfo     `json:"page"`
+}
+
+// DocumentMetadataDto describes a document without its content.
+type DocumentMetadataDto struct {
+	ID          string     `json:"id"`
+	Title       string     `json:"title"`
+	OwnerID     string     `json:"ownerId"`
+	FolderID    string     `json:"folderId,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	Archived    bool       `json:"archived,omitempty"`
+	Locked      bool       `json:"locked,omitempty"`
+	LockedBy    string     `json:"lockedBy,omitempty"`
+	LockedAt    *time.Time `json:"lockedAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+func NewDocumentMetadataDto(document model.Document) DocumentMetadataDto {
+	return DocumentMetadataDto{
+		ID:          document.ID.Hex(),
+		Title:       document.Title,
+		OwnerID:     document.OwnerID,
+		FolderID:    document.FolderID,
+		Description: document.Description,
+		Tags:        document.Tags,
+		Archived:    document.Archived,
+		Locked:      document.Locked,
+		LockedBy:    document.LockedBy,
+		LockedAt:    document.LockedAt,
+		CreatedAt:   document.CreatedAt,
+		UpdatedAt:   document.UpdatedAt,
+	}
+}
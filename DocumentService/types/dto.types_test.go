@@ -0,0 +1,55 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseAccessType(t *testing.T) {
+	tests := []struct {
+		value string
+		want  AccessType
+		err   error
+	}{
+		{"read", AccessRead, nil},
+		{"write", AccessWrite, nil},
+		{" Write ", AccessWrite, nil},
+		{"READ", AccessRead, nil},
+		{"", "", ErrInvalidAccessType},
+		{"rw", "", ErrInvalidAccessType},
+		{"admin", "", ErrInvalidAccessType},
+		{"owner", "", ErrInvalidAccessType},
+		{"read write", "", ErrInvalidAccessType},
+	}
+	for _, tt := range tests {
+		got, err := ParseAccessType(tt.value)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("ParseAccessType(%q) = %q, %v, want %q, %v", tt.value, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestShareDataAccessTypeBinding(t *testing.T) {
+	tests := []struct {
+		body string
+		want AccessType
+		err  error
+	}{
+		{`{"accessType":"write"}`, AccessWrite, nil},
+		{`{"accessType":" Read"}`, AccessRead, nil},
+		// Left for the handler to refuse
+		{`{}`, "", nil},
+		{`{"accessType":"banana"}`, "", ErrInvalidAccessType},
+		{`{"accessType":""}`, "", ErrInvalidAccessType},
+		{`{"accessType":1}`, "", ErrInvalidAccessType},
+		{`{"accessType":["read"]}`, "", ErrInvalidAccessType},
+	}
+	for _, tt := range tests {
+		var data ShareDocumentPostData
+		err := json.Unmarshal([]byte(tt.body), &data)
+		if data.AccessType != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("%s: access type %q, error %v, want %q, %v", tt.body, data.AccessType, err, tt.want, tt.err)
+		}
+	}
+}
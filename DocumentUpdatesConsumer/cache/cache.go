@@ -0,0 +1,43 @@
+// Package cache invalidates the documents DocumentService caches in Redis.
+// Live updates change documents in MongoDB behind DocumentService's back, so
+// each applied update evicts its document and the next read sees it.
+package cache
+
+import (
+	"context"
+	"log"
+	sharedmodel "shared/model"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// timeout bounds an invalidation, so a struggling Redis does not hold up
+// applying updates.
+const timeout = 200 * time.Millisecond
+
+// Invalidator evicts documents from DocumentService's cache.
+type Invalidator struct {
+	client *redis.Client
+}
+
+// NewInvalidator connects to the Redis at addr lazily: the cache is optional,
+// so a Redis that is down is only logged when an invalidation fails.
+func NewInvalidator(addr string, password string) *Invalidator {
+	return &Invalidator{client: redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       0,
+	})}
+}
+
+// Invalidate evicts the document with id. When that fails DocumentService may
+// serve the document without the update until its cache entry expires.
+func (i *Invalidator) Invalidate(ctx context.Context, id string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+
+	if err := i.client.Del(ctx, sharedmodel.DocumentCacheKey(id)).Err(); err != nil {
+		log.Printf("[Cache][Invalidate] Warning: invalidating document %s failed: %v", id, err)
+	}
+}
@@ -1,5 +1,14 @@
 package config
 
+import (
+	"os"
+	"shared/content"
+	"shared/events"
+	sharedmodel "shared/model"
+	"shared/secrets"
+	"strconv"
+)
+
 type Config struct {
 }
 
@@ -9,12 +18,108 @@ type MongoConfigStruct struct {
 	UserCollectionName            string
 	DocumentCollectionName        string
 	SharedDocRecordCollectionName string
+	OperationCollectionName       string
+	SnapshotCollectionName        string
 }
 
 var MongoConfig = MongoConfigStruct{
 	MongoUri:                      "mongodb://canvas-live-mongodb:27017",
 	DatabaseName:                  "default",
-	UserCollectionName:            "user",
-	DocumentCollectionName:        "document",
-	SharedDocRecordCollectionName: "sharedDocRecordCollection",
+	UserCollectionName:            sharedmodel.UserCollection,
+	DocumentCollectionName:        sharedmodel.DocumentCollection,
+	SharedDocRecordCollectionName: sharedmodel.SharedDocRecordCollection,
+	OperationCollectionName:       sharedmodel.OperationCollection,
+	SnapshotCollectionName:        sharedmodel.SnapshotCollection,
+}
+
+// KafkaConfigStruct locates the broker and topics the consumer reads. They come
+// from the environment so test harnesses can point it at a throwaway broker.
+type KafkaConfigStruct struct {
+	Broker  string
+	Topic   string
+	GroupID string
+	// DocumentEventsTopic carries the lifecycle events of documents, read
+	// with a group of its own
+	DocumentEventsTopic string
+	DocumentEventsGroup string
+}
+
+var KafkaConfig = KafkaConfigStruct{
+	Broker:              getEnv("KAFKA_BROKER", "canvas-live-kafka:9092"),
+	Topic:               getEnv("KAFKA_UPDATES_TOPIC", "document-updates"),
+	GroupID:             getEnv("KAFKA_GROUP_ID", "document-updates-consumer-group"),
+	DocumentEventsTopic: getEnv("KAFKA_DOCUMENT_EVENTS_TOPIC", events.TopicDocumentEvents),
+	DocumentEventsGroup: getEnv("KAFKA_DOCUMENT_EVENTS_GROUP", "document-updates-consumer-events"),
+}
+
+// HistoryConfigStruct controls the version history: the content is
+// snapshotted every Every live updates, and a document keeps its MaxVersions
+// newest snapshots.
+type HistoryConfigStruct struct {
+	Every       int
+	MaxVersions int
+}
+
+var HistoryConfig = HistoryConfigStruct{
+	Every:       getEnvInt("VERSION_HISTORY_EVERY", 50),
+	MaxVersions: getEnvInt("VERSION_HISTORY_MAX", 100),
+}
+
+// ContentConfigStruct limits the updates applied to a document. Larger ones
+// are dropped; UpdatesService refuses them already, see shared/content.
+type ContentConfigStruct struct {
+	MaxBytes int64
+}
+
+var ContentConfig = ContentConfigStruct{
+	MaxBytes: int64(getEnvInt(content.MaxBytesEnv, content.DefaultMaxBytes)),
+}
+
+// RedisConfigStruct locates the Redis DocumentService caches documents in.
+// Applied updates invalidate their document there; an empty Addr disables
+// that, which is only safe while DocumentService's cache is disabled too.
+type RedisConfigStruct struct {
+	Addr     string
+	Password string
+}
+
+var RedisConfig = RedisConfigStruct{
+	Addr: getEnv("REDIS_ADDR", "canvas-live-redis:6379"),
+}
+
+// MetricsAddr is where the consumer exposes its counters; empty disables it.
+var MetricsAddr = getEnv("METRICS_ADDR", ":9102")
+
+// Secrets accept a NAME_FILE variant pointing at a mounted secret file.
+var Secrets = secrets.NewSet()
+
+var (
+	// MongoURI may embed the database password, so it is treated as a secret.
+	MongoURI      = Secrets.Add(secrets.Spec{Name: "MONGO_URI", Fallback: MongoConfig.MongoUri})
+	RedisPassword = Secrets.Add(secrets.Spec{Name: "REDIS_PASSWORD"})
+)
+
+// Load reads every secret and applies them to the static config.
+func Load() error {
+	if err := Secrets.Load(); err != nil {
+		return err
+	}
+
+	MongoConfig.MongoUri = MongoURI.Get()
+	RedisConfig.Password = RedisPassword.Get()
+	return nil
+}
+
+func getEnvInt(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+	return fallback
+}
+
+func getEnv(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
 }
@@ -0,0 +1,94 @@
+// Package documentevents consumes the document events that concern the
+// history the consumer keeps, currently document deletions.
+package documentevents
+
+import (
+	"DocumentUpdatesConsumer/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"shared/events"
+	"shared/tenant"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// HistoryStore holds the operations and snapshots of documents.
+// *repository.DocumentRepository implements it.
+type HistoryStore interface {
+	DeleteHistory(ctx context.Context, documentId string) error
+}
+
+// Consumer reads the document events topic. Its instances share a group, so
+// each event is handled once.
+type Consumer struct {
+	consumer   *kafka.Consumer
+	dispatcher *events.Dispatcher
+}
+
+func NewConsumer(consumer *kafka.Consumer, store HistoryStore) *Consumer {
+	return &Consumer{consumer: consumer, dispatcher: newDispatcher(store)}
+}
+
+// newDispatcher registers every type of the document events topic: deleted
+// documents lose their history, every other event is ignored.
+func newDispatcher(store HistoryStore) *events.Dispatcher {
+	return events.NewDispatcher().
+		Handle(events.DocumentDeleted, func(ctx context.Context, env events.Envelope, e events.Event) error {
+			deleted := e.(events.DocumentDeletedEvent)
+			return store.DeleteHistory(tenant.WithID(ctx, deleted.TenantID), deleted.DocumentID)
+		}).
+		Ignore(
+			events.DocumentCreated,
+			events.DocumentShared,
+			events.DocumentTrashed,
+			events.DocumentRestored,
+			events.DocumentRenamed,
+			events.OwnershipTransferred,
+			events.ContentReplaced,
+			events.ShareRevoked,
+			events.DocumentLocked,
+			events.DocumentUnlocked,
+		)
+}
+
+// Run subscribes to topic and handles events until ctx is cancelled.
+func (c *Consumer) Run(ctx context.Context, topic string) error {
+	if missing := c.dispatcher.Unhandled(topic); len(missing) > 0 {
+		return fmt.Errorf("no handler registered for %v", missing)
+	}
+
+	if err := c.consumer.SubscribeTopics([]string{topic}, nil); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", topic, err)
+	}
+	log.Printf("[DocumentEvents] Subscribed to %s", topic)
+
+	for ctx.Err() == nil {
+		ev := c.consumer.Poll(100)
+
+		switch e := ev.(type) {
+		case *kafka.Message:
+			// A failed purge leaves orphaned history behind; it is logged
+			// and counted so it can be removed by hand
+			err := c.dispatcher.Dispatch(ctx, e.Value)
+			if errors.Is(err, events.ErrMalformedEvent) || errors.Is(err, events.ErrUnknownEvent) || errors.Is(err, events.ErrInvalidEvent) {
+				metrics.DecodeFailures.Add(1)
+				log.Printf("[DocumentEvents] Skipping undecodable event at %v: %v", e.TopicPartition, err)
+			} else if err != nil {
+				metrics.EventFailures.Add(1)
+				log.Printf("[DocumentEvents] Handling event at %v failed: %v", e.TopicPartition, err)
+			}
+			if _, err := c.consumer.StoreMessage(e); err != nil {
+				log.Printf("[DocumentEvents] Failed to store offset %v: %v", e.TopicPartition, err)
+			}
+		case kafka.Error:
+			log.Printf("[DocumentEvents] Kafka error: %v (Code: %d)", e, e.Code())
+			if e.IsFatal() {
+				return e
+			}
+		}
+	}
+
+	return nil
+}
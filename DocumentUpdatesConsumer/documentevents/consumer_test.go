@@ -0,0 +1,71 @@
+package documentevents
+
+import (
+	"DocumentUpdatesConsumer/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"shared/events"
+	"shared/tenant"
+	"testing"
+	"time"
+)
+
+var _ HistoryStore = (*repository.DocumentRepository)(nil)
+
+// recordingStore records the histories deleted, as tenant/document.
+type recordingStore struct {
+	deleted []string
+	err     error
+}
+
+func (s *recordingStore) DeleteHistory(ctx context.Context, documentId string) error {
+	s.deleted = append(s.deleted, tenant.FromContext(ctx)+"/"+documentId)
+	return s.err
+}
+
+func encode(t *testing.T, e events.Event) []byte {
+	t.Helper()
+	env, err := events.Encode(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestEveryDocumentEventIsRegistered(t *testing.T) {
+	if missing := newDispatcher(&recordingStore{}).Unhandled(events.TopicDocumentEvents); len(missing) != 0 {
+		t.Fatalf("no handler registered for %v", missing)
+	}
+}
+
+func TestDeletedDocumentLosesItsHistory(t *testing.T) {
+	store := &recordingStore{}
+	dispatcher := newDispatcher(store)
+	ctx := context.Background()
+	now := time.Now()
+
+	err := dispatcher.Dispatch(ctx, encode(t, events.DocumentDeletedEvent{DocumentID: "doc-1", TenantID: "acme", OccurredAt: now}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dispatcher.Dispatch(ctx, encode(t, events.DocumentRenamedEvent{DocumentID: "doc-2", TenantID: "acme", Title: "x", RenamedBy: "u-1", OccurredAt: now})); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.deleted) != 1 || store.deleted[0] != "acme/doc-1" {
+		t.Fatalf("deleted histories %v, want only acme/doc-1", store.deleted)
+	}
+}
+
+func TestFailedPurgeIsReported(t *testing.T) {
+	store := &recordingStore{err: errors.New("mongo unavailable")}
+	err := newDispatcher(store).Dispatch(context.Background(), encode(t, events.DocumentDeletedEvent{DocumentID: "doc-1", TenantID: "acme", OccurredAt: time.Now()}))
+	if err == nil || errors.Is(err, events.ErrMalformedEvent) {
+		t.Fatalf("dispatch = %v, want the store's error", err)
+	}
+}
@@ -1,21 +1,74 @@
 package handler
 
 import (
+	"DocumentUpdatesConsumer/config"
+	"DocumentUpdatesConsumer/metrics"
 	"DocumentUpdatesConsumer/model"
 	"DocumentUpdatesConsumer/repository"
 	"DocumentUpdatesConsumer/types"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"shared/content"
+	"shared/tenant"
+	"time"
 )
 
-func DocumentUpdatesHandler(ctx context.Context, r *repository.DocumentRepository, msg types.Message) {
+// DocumentStore is the persistence the handler applies updates to.
+// *repository.DocumentRepository implements it.
+type DocumentStore interface {
+	AddNewSlide(ctx context.Context, documentId string, slideId string) error
+	RemoveSlide(ctx context.Context, docId string, slideId string) error
+	UpdateElement(ctx context.Context, docId string, slideId string, elementId string, updatedFields map[string]interface{}) error
+	CreateElement(ctx context.Context, docId string, slideId string, newElementData model.Object) error
+	DeleteElement(ctx context.Context, docId string, slideId string, elementId string) error
+	RecordOperation(ctx context.Context, op model.Operation) error
+	SnapshotIfDue(ctx context.Context, documentId string, userId string) error
+	EditState(ctx context.Context, documentId string) (archived bool, lockedAt *time.Time, err error)
+}
+
+// DocumentUpdatesHandler applies an update to its document. Updates that are
+// invalid or may not be applied are dropped; the error is that of the store,
+// when it failed to look up the document or apply the update.
+func DocumentUpdatesHandler(ctx context.Context, r DocumentStore, msg types.Message) error {
+	// Every repository write is scoped to the tenant the update was produced
+	// for, and records how far the document's update stream was applied
+	ctx = tenant.WithID(ctx, msg.TenantID)
+	ctx = repository.WithSeq(ctx, msg.Seq)
+
+	// Oversized updates would push the document towards MongoDB's document
+	// size limit; UpdatesService refuses them, so any reaching here are dropped
+	if err := content.Check(int64(len(msg.Body)), config.ContentConfig.MaxBytes); err != nil {
+		metrics.OversizedUpdates.Add(1)
+		fmt.Printf("[DocumentUpdatesHandler] Dropping oversized update document=%s tenant=%s user=%s bytes=%d limit=%d\n",
+			msg.DocumentID, msg.TenantID, msg.UserID, len(msg.Body), config.ContentConfig.MaxBytes)
+		return nil
+	}
 
 	var actionMsg map[string]interface{}
 	err := json.Unmarshal([]byte(msg.Body), &actionMsg)
 	if err != nil {
 		fmt.Printf("[DocumentUpdatesHandler] error unmarshalling message body")
-		return
+		return nil
+	}
+
+	// Archived documents are read-only; their updates are dropped, not failed
+	archived, lockedAt, err := r.EditState(ctx, msg.DocumentID)
+	if err != nil {
+		fmt.Printf("[DocumentUpdatesHandler] Error looking up document %s: %s\n", msg.DocumentID, err)
+		return err
+	}
+	if archived {
+		fmt.Printf("[DocumentUpdatesHandler] Dropping update to archived document %s\n", msg.DocumentID)
+		return nil
+	}
+
+	// Locked documents take the updates sent before the lock, which were
+	// still in flight; updates without a send time are treated as later
+	if lockedAt != nil && (msg.SentAt.IsZero() || !msg.SentAt.Before(*lockedAt)) {
+		fmt.Printf("[DocumentUpdatesHandler] Dropping update to document %s locked at %s\n", msg.DocumentID, lockedAt.Format(time.RFC3339))
+		return nil
 	}
 
 	// fmt.Printf("\n ============ Action Msg ============= \n %v\n", actionMsg)
@@ -26,13 +79,17 @@ func DocumentUpdatesHandler(ctx context.Context, r *repository.DocumentRepositor
 		slideId, ok := actionMsg["slideId"].(string)
 		if !ok {
 			fmt.Printf("[DocumentUpdatesHandler] slideId missing")
-			return
+			return nil
 		}
 
 		err := r.AddNewSlide(ctx, msg.DocumentID, slideId)
+		if errors.Is(err, repository.ErrAlreadyApplied) {
+			fmt.Printf("[DocumentUpdatesHandler] Skipping redelivered slide %s\n", slideId)
+			return nil
+		}
 		if err != nil {
 			fmt.Printf("[DocumentUpdatesHandler] Error adding new slide")
-			return
+			return err
 		}
 
 	} else if actVal == "remove_slide" {
@@ -40,13 +97,13 @@ func DocumentUpdatesHandler(ctx context.Context, r *repository.DocumentRepositor
 		slideId, ok := actionMsg["slideId"].(string)
 		if !ok {
 			fmt.Printf("[DocumentUpdatesHandler] slideId missing")
-			return
+			return nil
 		}
 
 		err := r.RemoveSlide(ctx, msg.DocumentID, slideId)
 		if err != nil {
 			fmt.Printf("[DocumentUpdatesHandler] Error adding new slide")
-			return
+			return err
 		}
 
 	} else if actVal == "delete" {
@@ -58,7 +115,7 @@ func DocumentUpdatesHandler(ctx context.Context, r *repository.DocumentRepositor
 		err := r.DeleteElement(ctx, docId, slideId, objectId)
 		if err != nil {
 			fmt.Printf("[DocumentUpdatesHandler] Error deleting object")
-			return
+			return err
 		}
 
 	} else if actVal == "update" {
@@ -72,13 +129,13 @@ func DocumentUpdatesHandler(ctx context.Context, r *repository.DocumentRepositor
 		updatedFields, ok := actionMsg["updatedAttributes"].(map[string]interface{})
 		if !ok {
 			fmt.Printf("[DocumentUpdatesHandler] Error converting updatedAttributes to map[string]interface{}: %s\n", err)
-			return
+			return nil
 		}
 
 		err := r.UpdateElement(ctx, docId, slideId, objectId, updatedFields)
 		if err != nil {
 			fmt.Printf("[DocumentUpdatesHandler] Error updating object: %s\n", err)
-			return
+			return err
 		}
 
 	} else if actVal == "create" {
@@ -93,7 +150,7 @@ func DocumentUpdatesHandler(ctx context.Context, r *repository.DocumentRepositor
 		attr, ok := actionMsg["attributes"].(map[string]interface{})
 		if !ok {
 			fmt.Printf("[DocumentUpdatesHandler] Error converting updatedAttributes to map[string]interface{}:- %s\n", err)
-			return
+			return nil
 		}
 
 		// create model.Object
@@ -104,11 +161,34 @@ func DocumentUpdatesHandler(ctx context.Context, r *repository.DocumentRepositor
 		}
 
 		err := r.CreateElement(ctx, docId, slideId, obj)
+		if errors.Is(err, repository.ErrAlreadyApplied) {
+			fmt.Printf("[DocumentUpdatesHandler] Skipping redelivered object %s\n", objectId)
+			return nil
+		}
 		if err != nil {
 			fmt.Printf("[DocumentUpdatesHandler] Error creating object:- %s\n", err)
-			return
+			return err
 		}
 	} else {
 		fmt.Printf("[DocumentUpdatesHandler] Unknown message received by consumer")
+		return nil
+	}
+
+	// Keep the applied update in the operation history
+	err = r.RecordOperation(ctx, model.Operation{
+		DocumentID: msg.DocumentID,
+		UserID:     msg.UserID,
+		Username:   msg.Username,
+		Action:     actVal,
+		Body:       msg.Body,
+	})
+	if err != nil {
+		fmt.Printf("[DocumentUpdatesHandler] Error recording operation: %s\n", err)
+	}
+
+	// Every so often keep the whole content in the version history
+	if err := r.SnapshotIfDue(ctx, msg.DocumentID, msg.UserID); err != nil {
+		fmt.Printf("[DocumentUpdatesHandler] Error recording snapshot: %s\n", err)
 	}
+	return nil
 }
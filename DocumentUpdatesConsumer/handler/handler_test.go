@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"DocumentUpdatesConsumer/model"
+	"DocumentUpdatesConsumer/repository"
+	"DocumentUpdatesConsumer/types"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var _ DocumentStore = (*repository.DocumentRepository)(nil)
+
+// fakeStore fails its writes with err and records the operations kept, and
+// the seqs of the slides added.
+type fakeStore struct {
+	err        error
+	stateErr   error
+	archived   bool
+	lockedAt   *time.Time
+	operations []string
+	seqs       []string
+}
+
+func (s *fakeStore) AddNewSlide(ctx context.Context, _ string, _ string) error {
+	s.seqs = append(s.seqs, repository.Seq(ctx))
+	return s.err
+}
+func (s *fakeStore) RemoveSlide(context.Context, string, string) error { return s.err }
+func (s *fakeStore) UpdateElement(context.Context, string, string, string, map[string]interface{}) error {
+	return s.err
+}
+func (s *fakeStore) CreateElement(context.Context, string, string, model.Object) error {
+	return s.err
+}
+func (s *fakeStore) DeleteElement(context.Context, string, string, string) error { return s.err }
+func (s *fakeStore) RecordOperation(ctx context.Context, op model.Operation) error {
+	s.operations = append(s.operations, op.Action)
+	return nil
+}
+func (s *fakeStore) SnapshotIfDue(context.Context, string, string) error { return nil }
+func (s *fakeStore) EditState(context.Context, string) (bool, *time.Time, error) {
+	return s.archived, s.lockedAt, s.stateErr
+}
+
+func update(body string) types.Message {
+	return types.Message{DocumentID: "665f1c2ab7e4a1d2c3b4a5f6", UserID: "u-1", TenantID: "t-1", Body: body}
+}
+
+const createBody = `{"action":"create","slideId":"s-1","objectId":"o-1","objectType":"rect","attributes":{"x":1}}`
+
+func TestAppliedUpdateIsRecorded(t *testing.T) {
+	store := &fakeStore{}
+	if err := DocumentUpdatesHandler(context.Background(), store, update(createBody)); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.operations) != 1 || store.operations[0] != "create" {
+		t.Fatalf("operations = %v, want [create]", store.operations)
+	}
+}
+
+func TestAppliedUpdateCarriesItsSeq(t *testing.T) {
+	store := &fakeStore{}
+	kept := update(`{"action":"add_slide","slideId":"s-1"}`)
+	kept.Seq = "1700000000000-0"
+	// Updates UpdatesService could not keep in the stream have none
+	unkept := update(`{"action":"add_slide","slideId":"s-2"}`)
+
+	for _, msg := range []types.Message{kept, unkept} {
+		if err := DocumentUpdatesHandler(context.Background(), store, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(store.seqs) != 2 || store.seqs[0] != kept.Seq || store.seqs[1] != "" {
+		t.Fatalf("slides added with seqs %q, want %q and none", store.seqs, kept.Seq)
+	}
+}
+
+func TestStoreFailuresAreReturned(t *testing.T) {
+	down := errors.New("connection refused")
+	bodies := []string{
+		`{"action":"add_slide","slideId":"s-1"}`,
+		`{"action":"remove_slide","slideId":"s-1"}`,
+		`{"action":"delete","slideId":"s-1","objectId":"o-1"}`,
+		`{"action":"update","slideId":"s-1","objectId":"o-1","updatedAttributes":{"x":2}}`,
+		createBody,
+	}
+	for _, body := range bodies {
+		store := &fakeStore{err: down}
+		if err := DocumentUpdatesHandler(context.Background(), store, update(body)); !errors.Is(err, down) {
+			t.Errorf("%s: err = %v, want the store's", body, err)
+		}
+		if len(store.operations) != 0 {
+			t.Errorf("%s: failed update recorded as %v", body, store.operations)
+		}
+	}
+
+	store := &fakeStore{stateErr: down}
+	if err := DocumentUpdatesHandler(context.Background(), store, update(createBody)); !errors.Is(err, down) {
+		t.Errorf("edit state lookup: err = %v, want the store's", err)
+	}
+}
+
+func TestRedeliveredUpdateIsSkipped(t *testing.T) {
+	for _, body := range []string{`{"action":"add_slide","slideId":"s-1"}`, createBody} {
+		store := &fakeStore{err: repository.ErrAlreadyApplied}
+		if err := DocumentUpdatesHandler(context.Background(), store, update(body)); err != nil {
+			t.Errorf("%s: err = %v, want nil", body, err)
+		}
+		if len(store.operations) != 0 {
+			t.Errorf("%s: redelivered update recorded again as %v", body, store.operations)
+		}
+	}
+}
+
+func TestInvalidUpdateIsDropped(t *testing.T) {
+	store := &fakeStore{err: errors.New("not reached")}
+	for _, body := range []string{`{`, `{"action":"add_slide"}`, `{"action":"explode"}`} {
+		if err := DocumentUpdatesHandler(context.Background(), store, update(body)); err != nil {
+			t.Errorf("%s: err = %v, want the update dropped", body, err)
+		}
+	}
+}
+
+func TestArchivedDocumentUpdatesAreDropped(t *testing.T) {
+	// Writes would fail, so any attempted shows as an error
+	store := &fakeStore{archived: true, err: errors.New("not reached")}
+	for _, body := range []string{`{"action":"add_slide","slideId":"s-1"}`, createBody} {
+		if err := DocumentUpdatesHandler(context.Background(), store, update(body)); err != nil {
+			t.Errorf("%s: err = %v, want the update dropped", body, err)
+		}
+	}
+	if len(store.operations) != 0 {
+		t.Errorf("dropped updates recorded as %v", store.operations)
+	}
+}
+
+func TestUpdatesAfterTheLockAreDropped(t *testing.T) {
+	lockedAt := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		sentAt  time.Time
+		applied bool
+	}{
+		{"sent before the lock", lockedAt.Add(-time.Second), true},
+		{"sent at the lock", lockedAt, false},
+		{"sent after the lock", lockedAt.Add(time.Second), false},
+		{"without a send time", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		store := &fakeStore{lockedAt: &lockedAt}
+		msg := update(createBody)
+		msg.SentAt = tt.sentAt
+		if err := DocumentUpdatesHandler(context.Background(), store, msg); err != nil {
+			t.Errorf("%s: err = %v, want nil", tt.name, err)
+		}
+		if applied := len(store.operations) == 1; applied != tt.applied {
+			t.Errorf("%s: applied %v, want %v", tt.name, applied, tt.applied)
+		}
+	}
+}
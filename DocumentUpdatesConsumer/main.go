@@ -1,28 +1,27 @@
 package main
 
 import (
+	"DocumentUpdatesConsumer/cache"
 	"DocumentUpdatesConsumer/config"
 	"DocumentUpdatesConsumer/database"
+	"DocumentUpdatesConsumer/documentevents"
 	"DocumentUpdatesConsumer/handler"
+	"DocumentUpdatesConsumer/metrics"
 	"DocumentUpdatesConsumer/repository"
 	"DocumentUpdatesConsumer/types"
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"shared/migrate"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 )
 
-const (
-	kafkaBroker = "canvas-live-kafka:9092"
-	topic       = "document-updates"
-	groupID     = "document-updates-consumer-group"
-)
-
 // connectConsumerWithRetry loops until a broker connection is viable
 func connectConsumerWithRetry(brokers, group string) *kafka.Consumer {
 	var consumer *kafka.Consumer
@@ -31,6 +30,7 @@ func connectConsumerWithRetry(brokers, group string) *kafka.Consumer {
 
 	for {
 		fmt.Printf("Attempting to connect consumer to %s...\n", brokers)
+		metrics.KafkaConnectAttempts.Add(1)
 		consumer, err = kafka.NewConsumer(&kafka.ConfigMap{
 			"bootstrap.servers":        brokers,
 			"group.id":                 group,
@@ -39,6 +39,9 @@ func connectConsumerWithRetry(brokers, group string) *kafka.Consumer {
 			"session.timeout.ms":       30000,
 			"heartbeat.interval.ms":    3000,
 			"allow.auto.create.topics": true,
+			// Offsets are stored once a message is handled, so one that was
+			// polled but not yet applied is consumed again after a restart
+			"enable.auto.offset.store": false,
 		})
 
 		if err == nil {
@@ -79,6 +82,40 @@ func subscribeWithRetry(consumer *kafka.Consumer, topic string) {
 	log.Fatalf("Failed to subscribe to topic after %d attempts", maxRetries)
 }
 
+// applyWithRetry applies an update, retrying with backoff while the database
+// is unavailable. A partition's updates are applied in order, so the rest of
+// the partition waits for the database to come back. It returns false when
+// ctx is done first; the update is then left to be consumed again.
+func applyWithRetry(ctx context.Context, r handler.DocumentStore, msg types.Message) bool {
+	backoff := 500 * time.Millisecond
+	for {
+		attemptCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err := handler.DocumentUpdatesHandler(attemptCtx, r, msg)
+		cancel()
+		if err == nil || !repository.Unavailable(err) || ctx.Err() != nil {
+			return ctx.Err() == nil
+		}
+
+		metrics.StoreRetries.Add(1)
+		fmt.Printf("Database unavailable applying update to document %s, retrying in %v: %v\n", msg.DocumentID, backoff, err)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+// storeOffset marks msg as handled; the offset is committed with the next
+// auto commit. It fails when msg's partition was revoked meanwhile, and the
+// new owner then handles msg again.
+func storeOffset(c *kafka.Consumer, msg *kafka.Message) {
+	if _, err := c.StoreMessage(msg); err != nil {
+		fmt.Printf("Failed to store offset %v: %v\n", msg.TopicPartition, err)
+	}
+}
+
 // ensureTopicExists creates an admin client and ensures the topic exists
 func ensureTopicExists(brokers, topicName string) error {
 	adminClient, err := kafka.NewAdminClient(&kafka.ConfigMap{
@@ -129,43 +166,79 @@ func ensureTopicExists(brokers, topicName string) error {
 }
 
 func main() {
+	// Load secrets (NAME or NAME_FILE)
+	if err := config.Load(); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	go config.Secrets.ReloadOnSIGHUP(context.Background())
+
 	// Connect to DB
 	client := database.ConnectDB(config.MongoConfig.MongoUri)
 
+	// Create missing indexes, and refuse to start against data the migrate tool
+	// has not brought up to date
+	indexCtx, cancelIndexes := context.WithTimeout(context.Background(), time.Minute)
+	if _, err := migrate.New(client.Database(config.MongoConfig.DatabaseName)).EnsureIndexes(indexCtx); err != nil {
+		log.Fatalf("Ensuring indexes failed: %v", err)
+	}
+	cancelIndexes()
+
+	// Invalidate what DocumentService caches of the documents updates change
+	var documentCache repository.DocumentCache
+	if config.RedisConfig.Addr != "" {
+		documentCache = cache.NewInvalidator(config.RedisConfig.Addr, config.RedisConfig.Password)
+	}
+
 	// Repository
 	r := repository.NewDocumentRepository(
 		client,
 		config.MongoConfig.DatabaseName,
 		config.MongoConfig.DocumentCollectionName,
+		config.MongoConfig.OperationCollectionName,
+		config.MongoConfig.SnapshotCollectionName,
+		repository.SnapshotPolicy{Every: int64(config.HistoryConfig.Every), Keep: int64(config.HistoryConfig.MaxVersions)},
+		documentCache,
 	)
 
+	// Expose counters
+	go metrics.Serve(config.MetricsAddr)
+
 	// Ensure topic exists before creating consumer
 	fmt.Println("Ensuring Kafka topic exists...")
-	if err := ensureTopicExists(kafkaBroker, topic); err != nil {
+	if err := ensureTopicExists(config.KafkaConfig.Broker, config.KafkaConfig.Topic); err != nil {
 		log.Printf("Warning: Could not ensure topic exists: %v", err)
 		log.Println("Continuing anyway - topic may be auto-created on first message")
 	}
 
 	// Create Kafka consumer
 	fmt.Println("Trying to connect to Kafka!")
-	c := connectConsumerWithRetry(kafkaBroker, groupID)
+	c := connectConsumerWithRetry(config.KafkaConfig.Broker, config.KafkaConfig.GroupID)
 	defer c.Close()
 	fmt.Println("Connected to Kafka!")
 
 	// Subscribe to topic with retry
-	subscribeWithRetry(c, topic)
-	fmt.Printf("Subscribed to topic %s. Waiting for messages...\n", topic)
+	subscribeWithRetry(c, config.KafkaConfig.Topic)
+	fmt.Printf("Subscribed to topic %s. Waiting for messages...\n", config.KafkaConfig.Topic)
 
 	// Setup graceful shutdown
-	sigchan := make(chan os.Signal, 1)
-	signal.Notify(sigchan, os.Interrupt)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// Deleted documents lose their history through the document events topic
+	go func() {
+		consumer := connectConsumerWithRetry(config.KafkaConfig.Broker, config.KafkaConfig.DocumentEventsGroup)
+		defer consumer.Close()
+		if err := documentevents.NewConsumer(consumer, r).Run(ctx, config.KafkaConfig.DocumentEventsTopic); err != nil {
+			log.Printf("Document events consumer stopped: %v", err)
+		}
+	}()
 
 	// Start consuming messages
 	run := true
 	for run {
 		select {
-		case sig := <-sigchan:
-			fmt.Printf("Received signal %v: terminating\n", sig)
+		case <-ctx.Done():
+			fmt.Println("Received interrupt: terminating")
 			run = false
 
 		default:
@@ -181,26 +254,41 @@ func main() {
 				fmt.Printf("Received message from topic %s: %s\n",
 					*e.TopicPartition.Topic, string(e.Value))
 
+				metrics.MessagesConsumed.Add(1)
+
 				// Parse message into struct
 				var msg types.Message
 				if err := json.Unmarshal(e.Value, &msg); err != nil {
+					metrics.DecodeFailures.Add(1)
 					fmt.Printf("[Error] Can't unmarshal message: %v\n", err)
+					storeOffset(c, e)
 					continue
 				}
 
-				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-				go func() {
-					defer cancel()
-					handler.DocumentUpdatesHandler(ctx, r, msg)
-				}()
+				// Updates are keyed by document, so a document's are all on
+				// one partition, in the order they were made; handling them
+				// one at a time applies them in that order
+				if !bytes.Equal(e.Key, msg.Key()) {
+					metrics.MiskeyedUpdates.Add(1)
+					fmt.Printf("[Warning] Update of document %s keyed %q, it may be applied out of order\n", msg.DocumentID, e.Key)
+				}
+
+				if applyWithRetry(ctx, r, msg) {
+					storeOffset(c, e)
+				}
 
 			case kafka.Error:
 				// Handle Kafka errors
 				fmt.Printf("Kafka Error: %v (Code: %d)\n", e, e.Code())
 
-				// Check if it's a fatal error
+				// The client reconnects on its own and resumes from the committed
+				// offsets, so an outage is not a reason to exit
 				if e.Code() == kafka.ErrAllBrokersDown {
-					fmt.Println("All brokers are down, attempting reconnect...")
+					metrics.BrokerDownEvents.Add(1)
+					fmt.Println("All brokers are down, waiting for the client to reconnect...")
+				}
+				if e.IsFatal() {
+					fmt.Println("Fatal Kafka error, terminating")
 					run = false
 				}
 
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"DocumentUpdatesConsumer/metrics"
+	"DocumentUpdatesConsumer/model"
+	"DocumentUpdatesConsumer/types"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyStore times out its first failures lookups, then applies updates.
+type flakyStore struct {
+	mu       sync.Mutex
+	failures int
+	lookups  int
+	applied  int
+}
+
+func (s *flakyStore) EditState(ctx context.Context, documentId string) (bool, *time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lookups++
+	if s.lookups <= s.failures {
+		return false, nil, context.DeadlineExceeded
+	}
+	return false, nil, nil
+}
+
+func (s *flakyStore) AddNewSlide(context.Context, string, string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applied++
+	return nil
+}
+func (s *flakyStore) RemoveSlide(context.Context, string, string) error {
+	return errors.New("not found")
+}
+func (s *flakyStore) UpdateElement(context.Context, string, string, string, map[string]interface{}) error {
+	return nil
+}
+func (s *flakyStore) CreateElement(context.Context, string, string, model.Object) error { return nil }
+func (s *flakyStore) DeleteElement(context.Context, string, string, string) error       { return nil }
+func (s *flakyStore) RecordOperation(context.Context, model.Operation) error            { return nil }
+func (s *flakyStore) SnapshotIfDue(context.Context, string, string) error               { return nil }
+
+func update(body string) types.Message {
+	return types.Message{DocumentID: "665f1c2ab7e4a1d2c3b4a5f6", TenantID: "t-1", Body: body}
+}
+
+func TestApplyRetriesWhileDatabaseUnavailable(t *testing.T) {
+	store := &flakyStore{failures: 2}
+	retriesBefore := metrics.StoreRetries.Value()
+
+	msg := update(`{"action":"add_slide","slideId":"s-1"}`)
+	if !applyWithRetry(context.Background(), store, msg) {
+		t.Fatal("update not applied")
+	}
+	if store.applied != 1 || store.lookups != 3 {
+		t.Fatalf("applied %d after %d lookups, want 1 after 3", store.applied, store.lookups)
+	}
+	if got := metrics.StoreRetries.Value() - retriesBefore; got != 2 {
+		t.Fatalf("store_retries_total grew by %d, want 2", got)
+	}
+}
+
+func TestApplyDoesNotRetryRejectedUpdates(t *testing.T) {
+	store := &flakyStore{}
+	if !applyWithRetry(context.Background(), store, update(`{"action":"remove_slide","slideId":"s-1"}`)) {
+		t.Fatal("rejected update left to be consumed again")
+	}
+	if store.lookups != 1 {
+		t.Fatalf("rejected update tried %d times, want once", store.lookups)
+	}
+}
+
+func TestApplyStopsRetryingOnShutdown(t *testing.T) {
+	store := &flakyStore{failures: 1000}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	done := make(chan bool)
+	go func() { done <- applyWithRetry(ctx, store, update(`{"action":"add_slide","slideId":"s-1"}`)) }()
+	select {
+	case applied := <-done:
+		if applied {
+			t.Fatal("update reported applied while the database was down")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("retry did not stop on shutdown")
+	}
+}
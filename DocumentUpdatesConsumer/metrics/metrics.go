@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+)
+
+// Consumer metrics, published through expvar. Resilience tests read them to
+// assert that an outage was observed and recovered from.
+var (
+	KafkaConnectAttempts = expvar.NewInt("kafka_consumer_connect_attempts_total")
+	BrokerDownEvents     = expvar.NewInt("kafka_all_brokers_down_total")
+	MessagesConsumed     = expvar.NewInt("messages_consumed_total")
+	DecodeFailures       = expvar.NewInt("decode_failures_total")
+	OversizedUpdates     = expvar.NewInt("oversized_updates_total")
+	// MiskeyedUpdates counts updates not keyed by their document, which may
+	// be applied out of order
+	MiskeyedUpdates = expvar.NewInt("miskeyed_updates_total")
+	// StoreRetries counts the updates retried because the database was
+	// unavailable
+	StoreRetries = expvar.NewInt("store_retries_total")
+	// EventFailures counts document events whose handling failed
+	EventFailures = expvar.NewInt("document_event_failures_total")
+)
+
+// Serve exposes every registered expvar as JSON on addr. It does nothing when
+// addr is empty.
+func Serve(addr string) {
+	if addr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", expvar.Handler())
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("[Metrics] Metrics server stopped: %v", err)
+	}
+}
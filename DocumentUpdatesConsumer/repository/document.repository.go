@@ -3,7 +3,10 @@ package repository
 import (
 	"DocumentUpdatesConsumer/model"
 	"context"
+	"errors"
 	"fmt"
+	"shared/tenant"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -11,15 +14,161 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrAlreadyApplied is returned for an update whose slide or object is already
+// in the document. Updates are redelivered after a crash or a rebalance, and
+// the copy is skipped instead of applied twice.
+var ErrAlreadyApplied = errors.New("update already applied")
+
+// Unavailable reports whether err is the database being unreachable or too
+// slow, as opposed to the update being wrong; the update may succeed later.
+func Unavailable(err error) bool {
+	return mongo.IsNetworkError(err) || mongo.IsTimeout(err) || errors.Is(err, context.DeadlineExceeded)
+}
+
 type DocumentRepository struct {
-	collection *mongo.Collection
+	collection          *mongo.Collection
+	operationCollection *mongo.Collection
+	snapshotCollection  *mongo.Collection
+	snapshots           SnapshotPolicy
+	cache               DocumentCache
+}
+
+// DocumentCache is DocumentService's cache of documents, which every applied
+// update invalidates. *cache.Invalidator implements it.
+type DocumentCache interface {
+	Invalidate(ctx context.Context, id string)
 }
 
-func NewDocumentRepository(client *mongo.Client, database string, collection string) *DocumentRepository {
+// SnapshotPolicy bounds the version history: a snapshot is taken every Every
+// versions, and only the Keep newest snapshots of a document are kept.
+type SnapshotPolicy struct {
+	Every int64
+	Keep  int64
+}
+
+// NewDocumentRepository creates the repository; cache may be nil when
+// DocumentService caches nothing.
+func NewDocumentRepository(client *mongo.Client, database string, collection string, operationCollection string, snapshotCollection string, snapshots SnapshotPolicy, cache DocumentCache) *DocumentRepository {
 	coll := client.Database(database).Collection(collection)
+	operations := client.Database(database).Collection(operationCollection)
 	return &DocumentRepository{
-		collection: coll,
+		collection:          coll,
+		operationCollection: operations,
+		snapshotCollection:  client.Database(database).Collection(snapshotCollection),
+		snapshots:           snapshots,
+		cache:               cache,
+	}
+}
+
+// invalidate evicts a changed document from the cache, if there is one.
+func (r *DocumentRepository) invalidate(ctx context.Context, documentId string) {
+	if r.cache != nil {
+		r.cache.Invalidate(ctx, documentId)
+	}
+}
+
+// tenantScoped restricts filter to the tenant carried by ctx, so an update can
+// only land on a document that belongs to the sender's tenant.
+func tenantScoped(ctx context.Context, filter bson.M) bson.M {
+	tenantID := tenant.FromContext(ctx)
+	if tenant.IsDefault(tenantID) {
+		filter["tenantId"] = bson.M{"$in": bson.A{tenantID, nil}}
+	} else {
+		filter["tenantId"] = tenantID
+	}
+	return filter
+}
+
+// documentFilter matches the document with id in the tenant of ctx unless it
+// is in the trash or archived, so updates to those documents are dropped.
+func documentFilter(ctx context.Context, id primitive.ObjectID) bson.M {
+	return tenantScoped(ctx, bson.M{"_id": id, "deletedAt": nil, "archived": bson.M{"$ne": true}})
+}
+
+// EditState reports whether the document is archived and, when its owner
+// locked it, since when. Archived documents are read-only, and locked ones
+// take no updates sent after they were locked. Missing documents are neither.
+func (r *DocumentRepository) EditState(ctx context.Context, documentId string) (bool, *time.Time, error) {
+	objectId, err := primitive.ObjectIDFromHex(documentId)
+	if err != nil {
+		return false, nil, err
 	}
+
+	var document model.Document
+	opts := options.FindOne().SetProjection(bson.M{"archived": 1, "locked": 1, "lockedAt": 1})
+	err = r.collection.FindOne(ctx, tenantScoped(ctx, bson.M{"_id": objectId}), opts).Decode(&document)
+	if err == mongo.ErrNoDocuments {
+		return false, nil, nil
+	}
+	if err != nil {
+		fmt.Printf("[DocumentRepository][EditState] Error looking up document: %v\n", err)
+		return false, nil, err
+	}
+	if !document.Locked {
+		return document.Archived, nil, nil
+	}
+	return document.Archived, document.LockedAt, nil
+}
+
+type seqKey struct{}
+
+// WithSeq returns a copy of ctx carrying the seq of the live update being
+// applied, see Seq.
+func WithSeq(ctx context.Context, seq string) context.Context {
+	return context.WithValue(ctx, seqKey{}, seq)
+}
+
+// Seq returns the seq WithSeq stored in ctx, or "" when there is none.
+func Seq(ctx context.Context) string {
+	seq, _ := ctx.Value(seqKey{}).(string)
+	return seq
+}
+
+// touched returns the fields set along with every update: the document's
+// updatedAt and, for updates kept in the update stream, their seq there.
+// Every update filter must match only when the update changes something,
+// since updatedAt alone always counts as a modification.
+func touched(ctx context.Context) bson.M {
+	fields := bson.M{"updatedAt": time.Now().UTC()}
+	if seq := Seq(ctx); seq != "" {
+		fields["seq"] = seq
+	}
+	return fields
+}
+
+// touch sets the fields of touched along with an update.
+func touch(ctx context.Context) bson.E {
+	return bson.E{Key: "$set", Value: touched(ctx)}
+}
+
+// appliedKept is how many seqs of applied live updates a document keeps in
+// Applied. UpdatesService takes the updates of the stream before the oldest
+// of them as applied, so it bounds how far out of the stream's order an
+// update may be applied and still be told apart.
+const appliedKept = 256
+
+// withApplied adds to update pushing the seq of the live update being
+// applied, if it was kept in the update stream, to the document's Applied.
+func withApplied(ctx context.Context, update bson.D) bson.D {
+	seq := Seq(ctx)
+	if seq == "" {
+		return update
+	}
+	applied := bson.E{Key: "applied", Value: bson.M{"$each": bson.A{seq}, "$slice": -appliedKept}}
+	for i, op := range update {
+		if op.Key == "$push" {
+			update[i].Value = append(op.Value.(bson.D), applied)
+			return update
+		}
+	}
+	return append(update, bson.E{Key: "$push", Value: bson.D{applied}})
+}
+
+// bumpVersion increments the document's version along with an update. Live
+// updates do not check it, but REST writes based on an older version then
+// fail instead of overwriting them.
+func bumpVersion() bson.E {
+	return bson.E{Key: "$inc", Value: bson.M{"version": 1}}
 }
 
 func (r *DocumentRepository) AddNewSlide(ctx context.Context, documentId string, slideId string) error {
@@ -30,13 +179,18 @@ func (r *DocumentRepository) AddNewSlide(ctx context.Context, documentId string,
 	}
 
 	// check if document exists or not
-	filter := bson.M{"_id": objectId}
+	filter := documentFilter(ctx, objectId)
 	var doc model.Document
 	err = r.collection.FindOne(ctx, filter).Decode(&doc)
 	if err != nil {
 		fmt.Printf("[DocumentRepository][FindOwnedDocuments] Error decoding documents: %v\n", err)
 		return err
 	}
+	for _, slide := range doc.Slides {
+		if slide.ID == slideId {
+			return ErrAlreadyApplied
+		}
+	}
 
 	// document exists
 	// create new slide
@@ -46,11 +200,13 @@ func (r *DocumentRepository) AddNewSlide(ctx context.Context, documentId string,
 		Objects:    make([]model.Object, 0, 1),
 	}
 
-	update := bson.D{
+	update := withApplied(ctx, bson.D{
 		{Key: "$push", Value: bson.D{
 			{Key: "slides", Value: newSlide},
 		}},
-	}
+		touch(ctx),
+		bumpVersion(),
+	})
 
 	// Execute the UpdateOne
 	result, err := r.collection.UpdateOne(ctx, filter, update)
@@ -65,6 +221,7 @@ func (r *DocumentRepository) AddNewSlide(ctx context.Context, documentId string,
 		return fmt.Errorf("document not found with ID: %s", documentId)
 	}
 
+	r.invalidate(ctx, documentId)
 	return nil
 }
 
@@ -75,16 +232,19 @@ func (r *DocumentRepository) RemoveSlide(ctx context.Context, docId string, slid
 	if err != nil {
 		return fmt.Errorf("invalid Document ID format: %w", err)
 	}
-	docFilter := bson.M{"_id": docObjectID}
+	docFilter := documentFilter(ctx, docObjectID)
+	docFilter["slides._id"] = slideId
 
 	// --- 2. Construct the $pull Update
-	update := bson.D{
+	update := withApplied(ctx, bson.D{
 		{Key: "$pull", Value: bson.D{
 			// Key: The name of the array field to pull from ("slides")
 			// Value: The query that identifies the element(s) to remove.
 			{Key: "slides", Value: bson.M{"_id": slideId}},
 		}},
-	}
+		touch(ctx),
+		bumpVersion(),
+	})
 
 	// --- 3. Execute UpdateOne (No Array Filters Required) ---
 	// We pass nil for the options since arrayFilters is not needed.
@@ -104,6 +264,7 @@ func (r *DocumentRepository) RemoveSlide(ctx context.Context, docId string, slid
 	}
 
 	fmt.Printf("[Repository][RemoveSlide] Successfully deleted slide %s. Modified: %d\n", slideId, result.ModifiedCount)
+	r.invalidate(ctx, docId)
 	return nil
 }
 
@@ -114,7 +275,8 @@ func (r *DocumentRepository) UpdateElement(ctx context.Context, docId string, sl
 	if err != nil {
 		return fmt.Errorf("invalid Document ID format: %w", err)
 	}
-	docFilter := bson.M{"_id": docObjectID}
+	docFilter := documentFilter(ctx, docObjectID)
+	docFilter["slides"] = bson.M{"$elemMatch": bson.M{"_id": slideId, "objects._id": elementId}}
 
 	// --- 2. ARRAY FILTERS: Target the Slide and the Element ---
 	// Array Filters are defined using a slice of BSON documents.
@@ -144,10 +306,14 @@ func (r *DocumentRepository) UpdateElement(ctx context.Context, docId string, sl
 		fullPath := fmt.Sprintf("slides.$[elem].objects.$[obj].attributes.%s", key)
 		setStage = append(setStage, bson.E{Key: fullPath, Value: value})
 	}
+	for key, value := range touched(ctx) {
+		setStage = append(setStage, bson.E{Key: key, Value: value})
+	}
 
-	update := bson.D{
+	update := withApplied(ctx, bson.D{
 		{Key: "$set", Value: setStage},
-	}
+		bumpVersion(),
+	})
 
 	// --- 4. Execute UpdateOne with Array Filters ---
 	result, err := r.collection.UpdateOne(
@@ -167,6 +333,7 @@ func (r *DocumentRepository) UpdateElement(ctx context.Context, docId string, sl
 
 	fmt.Printf("[Repository][UpdateElement] Successfully updated 1 element. Matched: %d, Modified: %d\n",
 		result.MatchedCount, result.ModifiedCount)
+	r.invalidate(ctx, docId)
 	return nil
 }
 
@@ -179,7 +346,8 @@ func (r *DocumentRepository) CreateElement(ctx context.Context, docId string, sl
 
 	// --- 1. Top-Level Filter: Find the Document ---
 	// Match the main document by its ID.
-	docFilter := bson.M{"_id": docObjectId}
+	docFilter := documentFilter(ctx, docObjectId)
+	docFilter["slides"] = bson.M{"$elemMatch": bson.M{"_id": slideId, "objects._id": bson.M{"$ne": newElementData.ID}}}
 
 	// --- 2. ARRAY FILTERS: Target the Slide ---
 	// Define a filter to find the correct slide within the "slides" array.
@@ -197,12 +365,14 @@ func (r *DocumentRepository) CreateElement(ctx context.Context, docId string, sl
 	// This path targets the 'elements' array inside the slide where elem._id matches slideID.
 	updatePath := "slides.$[elem].objects"
 
-	update := bson.D{
+	update := withApplied(ctx, bson.D{
 		{Key: "$push", Value: bson.D{
 			// $push to the specific path defined by the positional filtered identifier '$[elem]'
 			{Key: updatePath, Value: newElementData},
 		}},
-	}
+		touch(ctx),
+		bumpVersion(),
+	})
 
 	result, err := r.collection.UpdateOne(
 		ctx,
@@ -216,12 +386,19 @@ func (r *DocumentRepository) CreateElement(ctx context.Context, docId string, sl
 	}
 
 	if result.ModifiedCount == 0 {
+		// A redelivered create matches no slide, its object being there already
+		existing := documentFilter(ctx, docObjectId)
+		existing["slides.objects._id"] = newElementData.ID
+		if n, err := r.collection.CountDocuments(ctx, existing); err == nil && n > 0 {
+			return ErrAlreadyApplied
+		}
 		return fmt.Errorf("[Repository][CreateElement] no element was created (IDs may be incorrect)")
 	}
 
 	fmt.Printf("[Repository][CreateElement] Successfully created 1 element. Matched: %d, Modified: %d\n",
 		result.MatchedCount, result.ModifiedCount)
 
+	r.invalidate(ctx, docId)
 	return nil
 }
 
@@ -233,7 +410,8 @@ func (r *DocumentRepository) DeleteElement(ctx context.Context, docId string, sl
 	}
 
 	// --- 1. Top-Level Filter: Find the Document ---
-	docFilter := bson.M{"_id": docObjectId}
+	docFilter := documentFilter(ctx, docObjectId)
+	docFilter["slides"] = bson.M{"$elemMatch": bson.M{"_id": slideId, "objects._id": elementId}}
 
 	// --- 2. ARRAY FILTERS: Target the Slide ---
 	// We use the identifier 'elem' to find the specific slide based on its ID.
@@ -252,12 +430,14 @@ func (r *DocumentRepository) DeleteElement(ctx context.Context, docId string, sl
 	// This path targets the 'objects' array inside the slide where elem._id matches slideID.
 	updatePath := "slides.$[elem].objects"
 
-	update := bson.D{
+	update := withApplied(ctx, bson.D{
 		{Key: "$pull", Value: bson.D{
 			// $pull from the target array field (updatePath)
 			{Key: updatePath, Value: bson.M{"_id": elementId}},
 		}},
-	}
+		touch(ctx),
+		bumpVersion(),
+	})
 
 	// --- 4. Execute UpdateOne with Array Filters ---
 	result, err := r.collection.UpdateOne(
@@ -277,5 +457,92 @@ func (r *DocumentRepository) DeleteElement(ctx context.Context, docId string, sl
 	}
 
 	fmt.Printf("Successfully deleted element %s from slide %s.\n", elementId, slideId)
+	r.invalidate(ctx, docId)
+	return nil
+}
+
+// RecordOperation appends an applied update to the document's operation history.
+func (r *DocumentRepository) RecordOperation(ctx context.Context, op model.Operation) error {
+	op.TenantID = tenant.FromContext(ctx)
+	op.AppliedAt = time.Now().UTC()
+
+	if _, err := r.operationCollection.InsertOne(ctx, op); err != nil {
+		return fmt.Errorf("[Repository][RecordOperation] insert failed: %w", err)
+	}
+	return nil
+}
+
+// SnapshotIfDue records the document's content in its version history when
+// its version is a multiple of the policy's Every. Every live update bumps the
+// version by one, so this keeps every Nth state. The oldest snapshots beyond
+// the policy's Keep are dropped.
+func (r *DocumentRepository) SnapshotIfDue(ctx context.Context, documentId string, userId string) error {
+	objectId, err := primitive.ObjectIDFromHex(documentId)
+	if err != nil {
+		return fmt.Errorf("invalid Document ID format: %w", err)
+	}
+
+	var current model.Document
+	err = r.collection.FindOne(ctx, tenantScoped(ctx, bson.M{"_id": objectId}), options.FindOne().SetProjection(bson.M{"version": 1})).Decode(&current)
+	if err != nil {
+		return fmt.Errorf("[Repository][SnapshotIfDue] reading version failed: %w", err)
+	}
+	if current.Version == 0 || current.Version%r.snapshots.Every != 0 {
+		return nil
+	}
+
+	var document model.Document
+	if err := r.collection.FindOne(ctx, tenantScoped(ctx, bson.M{"_id": objectId})).Decode(&document); err != nil {
+		return fmt.Errorf("[Repository][SnapshotIfDue] reading content failed: %w", err)
+	}
+
+	_, err = r.snapshotCollection.InsertOne(ctx, model.Snapshot{
+		DocumentID: documentId,
+		TenantID:   tenant.FromContext(ctx),
+		Version:    document.Version,
+		UserID:     userId,
+		Title:      document.Title,
+		Slides:     document.Slides,
+		CreatedAt:  time.Now().UTC(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		// This version was snapshotted already, e.g. by a redelivered update
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("[Repository][SnapshotIfDue] insert failed: %w", err)
+	}
+
+	// Drop the snapshots beyond the newest Keep
+	filter := tenantScoped(ctx, bson.M{"documentId": documentId})
+	opts := options.FindOne().
+		SetSort(bson.D{{Key: "version", Value: -1}}).
+		SetSkip(r.snapshots.Keep).
+		SetProjection(bson.M{"version": 1})
+	var newestDropped model.Snapshot
+	err = r.snapshotCollection.FindOne(ctx, filter, opts).Decode(&newestDropped)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("[Repository][SnapshotIfDue] finding old snapshots failed: %w", err)
+	}
+	filter["version"] = bson.M{"$lte": newestDropped.Version}
+	if _, err := r.snapshotCollection.DeleteMany(ctx, filter); err != nil {
+		return fmt.Errorf("[Repository][SnapshotIfDue] deleting old snapshots failed: %w", err)
+	}
+	return nil
+}
+
+// DeleteHistory removes the operations and snapshots of a deleted document,
+// which nothing reads once the document is gone.
+func (r *DocumentRepository) DeleteHistory(ctx context.Context, documentId string) error {
+	filter := tenantScoped(ctx, bson.M{"documentId": documentId})
+	if _, err := r.operationCollection.DeleteMany(ctx, filter); err != nil {
+		return fmt.Errorf("[Repository][DeleteHistory] deleting operations failed: %w", err)
+	}
+	if _, err := r.snapshotCollection.DeleteMany(ctx, filter); err != nil {
+		return fmt.Errorf("[Repository][DeleteHistory] deleting snapshots failed: %w", err)
+	}
 	return nil
 }
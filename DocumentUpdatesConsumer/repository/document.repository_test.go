@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestAppliedSeqIsPushed(t *testing.T) {
+	ctx := WithSeq(context.Background(), "1700000000000-0")
+	want := bson.E{Key: "applied", Value: bson.M{"$each": bson.A{"1700000000000-0"}, "$slice": -appliedKept}}
+
+	// Along with the update's own push
+	update := withApplied(ctx, bson.D{
+		{Key: "$push", Value: bson.D{{Key: "slides", Value: "s-1"}}},
+		bumpVersion(),
+	})
+	push, ok := update[0].Value.(bson.D)
+	if len(update) != 2 || !ok || len(push) != 2 || push[0].Key != "slides" || push[1].Key != want.Key {
+		t.Fatalf("add slide update = %v, want the seq pushed with the slide", update)
+	}
+	if got := push[1].Value.(bson.M); got["$slice"] != want.Value.(bson.M)["$slice"] {
+		t.Errorf("applied pushed as %v, want %v", got, want.Value)
+	}
+
+	// Or on its own
+	update = withApplied(ctx, bson.D{{Key: "$pull", Value: bson.D{{Key: "slides", Value: bson.M{"_id": "s-1"}}}}})
+	if len(update) != 2 || update[1].Key != "$push" || update[1].Value.(bson.D)[0].Key != want.Key {
+		t.Fatalf("remove slide update = %v, want the seq pushed", update)
+	}
+}
+
+func TestUpdateWithoutSeqIsLeftAlone(t *testing.T) {
+	// Updates UpdatesService could not keep in the stream have no seq
+	update := withApplied(context.Background(), bson.D{{Key: "$pull", Value: bson.D{}}, bumpVersion()})
+	if len(update) != 2 {
+		t.Errorf("update without a seq = %v, want it unchanged", update)
+	}
+}
@@ -1,9 +1,7 @@
 package types
 
-type Message struct {
-	DocumentID string `json:"documentId"`
-	UserID     string `json:"userId"`
-	Username   string `json:"username"`
-	Type       int    `json:"type"`
-	Body       string `json:"body"`
-}
+import "shared/messages"
+
+// Message is an update produced by UpdatesService, defined with the frames
+// clients send in shared/messages so the two cannot drift apart.
+type Message = messages.Message
@@ -0,0 +1,76 @@
+// Package apierror writes the error body of the DocumentService and
+// UpdatesService endpoints:
+//
+//	{"error": {"code": "DOC_NOT_FOUND", "message": "...", "requestId": "..."}}
+//
+// Codes are stable so clients can switch on them; messages are for humans and
+// may change. The request ID lets an error reported by a user be found in the
+// logs, which is where the underlying error goes: it is never sent.
+package apierror
+
+import (
+	"log"
+	"net/http"
+	"shared/httpclient"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	CodeInvalidRequest        = "INVALID_REQUEST"
+	CodeInvalidID             = "INVALID_ID"
+	CodeValidationFailed      = "VALIDATION_FAILED"
+	CodeAuthRequired          = "AUTH_REQUIRED"
+	CodeAuthUnavailable       = "AUTH_UNAVAILABLE"
+	CodeForbidden             = "FORBIDDEN"
+	CodeDocumentNotFound      = "DOC_NOT_FOUND"
+	CodeFolderNotFound        = "FOLDER_NOT_FOUND"
+	CodeFolderNotEmpty        = "FOLDER_NOT_EMPTY"
+	CodeVersionNotFound       = "VERSION_NOT_FOUND"
+	CodeAccessRequestNotFound = "ACCESS_REQUEST_NOT_FOUND"
+	CodeUserNotFound          = "USER_NOT_FOUND"
+	CodeEmailNotRegistered    = "EMAIL_NOT_REGISTERED"
+	CodeUserLookupUnavailable = "USER_LOOKUP_UNAVAILABLE"
+	CodeAccessUnavailable     = "ACCESS_CHECK_UNAVAILABLE"
+	CodeVersionRequired       = "VERSION_REQUIRED"
+	CodeVersionConflict       = "VERSION_CONFLICT"
+	CodeOwnershipChanged      = "OWNERSHIP_CHANGED"
+	CodeDocumentArchived      = "DOC_ARCHIVED"
+	CodeDocumentLocked        = "DOC_LOCKED"
+	CodeContentTooLarge       = "CONTENT_TOO_LARGE"
+	CodeRateLimited           = "RATE_LIMITED"
+	CodeShuttingDown          = "SHUTTING_DOWN"
+	CodeInternal              = "INTERNAL_ERROR"
+)
+
+type Detail struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId,omitempty"`
+	// Pointer is the JSON pointer of the offending value in an uploaded
+	// document, for errors about its content
+	Pointer string `json:"pointer,omitempty"`
+}
+
+type Response struct {
+	Error Detail `json:"error"`
+}
+
+// New returns the error detail for c, carrying its request ID. Use it to
+// build responses that hold more than the error, and Abort otherwise.
+func New(c *gin.Context, code string, message string) Detail {
+	return Detail{Code: code, Message: message, RequestID: httpclient.RequestIDFromContext(c.Request.Context())}
+}
+
+// Abort ends the request with status and the error envelope.
+func Abort(c *gin.Context, status int, code string, message string) {
+	c.AbortWithStatusJSON(status, Response{Error: New(c, code, message)})
+}
+
+// Internal ends the request with a 500 INTERNAL_ERROR saying message. err is
+// logged with the request ID, not sent.
+func Internal(c *gin.Context, message string, err error) {
+	detail := New(c, CodeInternal, message)
+	log.Printf("[APIError] %s %s %s: %s: %v", detail.RequestID, c.Request.Method, c.Request.URL.Path, message, err)
+	c.AbortWithStatusJSON(http.StatusInternalServerError, Response{Error: detail})
+}
@@ -0,0 +1,66 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"shared/httpclient"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// respond runs handle on a request carrying request ID req-1.
+func respond(handle gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	req := httptest.NewRequest(http.MethodGet, "/document/x", nil)
+	c.Request = req.WithContext(httpclient.WithRequestID(req.Context(), "req-1"))
+	handle(c)
+	return rec
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder) Detail {
+	t.Helper()
+	var body Response
+	decoder := json.NewDecoder(rec.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		t.Fatalf("body is not the error envelope: %v", err)
+	}
+	return body.Error
+}
+
+func TestAbortWritesEnvelope(t *testing.T) {
+	rec := respond(func(c *gin.Context) {
+		Abort(c, http.StatusNotFound, CodeDocumentNotFound, "Document not found")
+	})
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", rec.Code)
+	}
+	want := Detail{Code: CodeDocumentNotFound, Message: "Document not found", RequestID: "req-1"}
+	if got := decode(t, rec); got != want {
+		t.Errorf("error = %+v, want %+v", got, want)
+	}
+}
+
+func TestInternalDoesNotSendError(t *testing.T) {
+	rec := respond(func(c *gin.Context) {
+		Internal(c, "Error deleting document", errors.New("connection to mongo:27017 refused"))
+	})
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "mongo") {
+		t.Errorf("body %s leaks the underlying error", rec.Body)
+	}
+	want := Detail{Code: CodeInternal, Message: "Error deleting document", RequestID: "req-1"}
+	if got := decode(t, rec); got != want {
+		t.Errorf("error = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,179 @@
+// Package authmw authenticates requests to DocumentService and UpdatesService.
+// An Authenticator turns the credentials of a request into an Identity, and
+// Middleware stores that identity on the gin.Context (and the tenant on the
+// request's context.Context) or aborts with 401.
+package authmw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"shared/apierror"
+	"strings"
+
+	"shared/authz"
+	"shared/httpclient"
+	"shared/jwks"
+	"shared/tenant"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Keys under which the authenticated identity is stored on the gin.Context.
+const (
+	UserIDKey   = "userId"
+	UsernameKey = "username"
+	TenantIDKey = "tenantId"
+	RoleKey     = authz.RoleKey
+)
+
+// ErrUnauthenticated is returned when the request carries no valid credentials.
+// Any other error means the credentials could not be checked.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Identity is the authenticated caller.
+type Identity struct {
+	UserID   string
+	Username string
+	TenantID string
+	Role     string
+}
+
+// Authenticator checks the credentials of r. token is the bearer token the
+// request presents, which may be empty.
+type Authenticator interface {
+	Authenticate(ctx context.Context, r *http.Request, token string) (*Identity, error)
+}
+
+// Middleware authenticates every request with the bearer token of its
+// Authorization header.
+func Middleware(a Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if Authenticate(c, a, BearerToken(c.Request)) {
+			c.Next()
+		}
+	}
+}
+
+// Authenticate authenticates c with token and stores the identity on it. It
+// writes the error response itself and returns false on failure; use it
+// directly where the token does not come from the Authorization header.
+func Authenticate(c *gin.Context, a Authenticator, token string) bool {
+	identity, err := a.Authenticate(c.Request.Context(), c.Request, token)
+	if errors.Is(err, ErrUnauthenticated) {
+		apierror.Abort(c, http.StatusUnauthorized, apierror.CodeAuthRequired, "Authorization required")
+		return false
+	}
+	if err != nil {
+		fmt.Printf("[AuthMiddleware][Error] %v\n", err)
+		apierror.Abort(c, http.StatusServiceUnavailable, apierror.CodeAuthUnavailable, "Authentication is unavailable, try again later")
+		return false
+	}
+
+	tenantID := tenant.Normalize(identity.TenantID)
+	c.Set(UserIDKey, identity.UserID)
+	c.Set(UsernameKey, identity.Username)
+	c.Set(TenantIDKey, tenantID)
+	c.Set(RoleKey, authz.NormalizeRole(identity.Role))
+	c.Request = c.Request.WithContext(tenant.WithID(c.Request.Context(), tenantID))
+	return true
+}
+
+// IdentityFrom returns the identity Authenticate stored on c.
+func IdentityFrom(c *gin.Context) Identity {
+	return Identity{
+		UserID:   c.GetString(UserIDKey),
+		Username: c.GetString(UsernameKey),
+		TenantID: c.GetString(TenantIDKey),
+		Role:     c.GetString(RoleKey),
+	}
+}
+
+// BearerToken returns the token of r's "Authorization: Bearer" header, or "".
+func BearerToken(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// GatewayHeaders trusts the X-User-ID, X-Username, X-Tenant-ID, and
+// X-User-Role headers the gateway sets after its auth_request. Only use it
+// when the service cannot be reached except through the gateway: anyone else
+// can set those headers.
+type GatewayHeaders struct{}
+
+func (GatewayHeaders) Authenticate(_ context.Context, r *http.Request, _ string) (*Identity, error) {
+	return identityFromHeaders(r.Header)
+}
+
+// Remote asks AuthService's /auth/authenticate, which also applies the
+// revocation list. Without a token the request's cookies are forwarded, so
+// browsers holding the session cookie authenticate with it.
+type Remote struct {
+	Client *httpclient.Client
+}
+
+func (a Remote) Authenticate(ctx context.Context, r *http.Request, token string) (*Identity, error) {
+	header := http.Header{}
+	switch {
+	case token != "":
+		header.Set("Authorization", "Bearer "+token)
+	case r.Header.Get("Cookie") != "":
+		header.Set("Cookie", r.Header.Get("Cookie"))
+	default:
+		return nil, ErrUnauthenticated
+	}
+
+	resp, err := a.Client.Get(httpclient.ContextFromRequest(ctx, r), "/auth/authenticate", header)
+	if err != nil {
+		if status := httpclient.StatusCode(err); httpclient.IsClientError(err) && status != http.StatusTooManyRequests {
+			return nil, ErrUnauthenticated
+		}
+		return nil, fmt.Errorf("calling auth service: %w", err)
+	}
+	return identityFromHeaders(resp.Header)
+}
+
+// Local verifies RS256 tokens against AuthService's published keys without a
+// call per request. It does not see the revocation list, so a logged-out token
+// is accepted until it expires. Requests without a token go to Fallback when
+// it is set, e.g. a Remote that handles the session cookie.
+type Local struct {
+	Verifier *jwks.Verifier
+	Fallback Authenticator
+}
+
+func (a Local) Authenticate(ctx context.Context, r *http.Request, token string) (*Identity, error) {
+	if token == "" {
+		if a.Fallback != nil {
+			return a.Fallback.Authenticate(ctx, r, token)
+		}
+		return nil, ErrUnauthenticated
+	}
+
+	claims, err := a.Verifier.Verify(ctx, token)
+	if err != nil {
+		// A failed key fetch is an outage, anything else a bad token
+		if errors.Is(err, jwks.ErrKeysUnavailable) {
+			return nil, err
+		}
+		return nil, ErrUnauthenticated
+	}
+	return &Identity{UserID: claims.UserID, Username: claims.Username, TenantID: claims.TenantID, Role: claims.Role}, nil
+}
+
+func identityFromHeaders(h http.Header) (*Identity, error) {
+	identity := &Identity{
+		UserID:   h.Get("X-User-ID"),
+		Username: h.Get("X-Username"),
+		TenantID: h.Get("X-Tenant-ID"),
+		Role:     h.Get(authz.RoleHeader),
+	}
+	if identity.UserID == "" {
+		return nil, ErrUnauthenticated
+	}
+	return identity, nil
+}
@@ -0,0 +1,204 @@
+package authmw
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"shared/authz"
+	"shared/httpclient"
+	"shared/jwks"
+	"shared/tenant"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fixedIdentity authenticates every request as the identity it holds.
+type fixedIdentity Identity
+
+func (a fixedIdentity) Authenticate(ctx context.Context, r *http.Request, token string) (*Identity, error) {
+	identity := Identity(a)
+	return &identity, nil
+}
+
+func TestIdentityIsStoredOnContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name     string
+		identity Identity
+		want     Identity
+	}{
+		{
+			name:     "every claim",
+			identity: Identity{UserID: "u-1", Username: "alice", TenantID: "acme", Role: authz.RoleAdmin},
+			want:     Identity{UserID: "u-1", Username: "alice", TenantID: "acme", Role: authz.RoleAdmin},
+		},
+		{
+			// Tokens from before tenants and roles existed
+			name:     "no tenant or role",
+			identity: Identity{UserID: "u-1", Username: "alice"},
+			want:     Identity{UserID: "u-1", Username: "alice", TenantID: tenant.DefaultID, Role: authz.RoleUser},
+		},
+	}
+	for _, tt := range tests {
+		var got Identity
+		var requestTenant string
+		router := gin.New()
+		router.GET("/", Middleware(fixedIdentity(tt.identity)), func(c *gin.Context) {
+			got = IdentityFrom(c)
+			requestTenant = tenant.FromContext(c.Request.Context())
+		})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", tt.name, rec.Code)
+		}
+		if got != tt.want {
+			t.Errorf("%s: identity on the context = %+v, want %+v", tt.name, got, tt.want)
+		}
+		if requestTenant != tt.want.TenantID {
+			t.Errorf("%s: tenant on the request context = %q, want %q", tt.name, requestTenant, tt.want.TenantID)
+		}
+	}
+}
+
+func TestRoleClaimReachesRequireRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		role       string
+		wantStatus int
+	}{
+		{authz.RoleAdmin, http.StatusOK},
+		{authz.RoleUser, http.StatusForbidden},
+		{"", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		router := gin.New()
+		authenticator := fixedIdentity{UserID: "u-1", Role: tt.role}
+		router.GET("/admin", Middleware(authenticator), authz.RequireRole(authz.RoleAdmin), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		// The header only counts when nothing authenticated the request
+		req.Header.Set(authz.RoleHeader, authz.RoleAdmin)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantStatus {
+			t.Errorf("role %q: status %d, want %d", tt.role, rec.Code, tt.wantStatus)
+		}
+	}
+}
+
+// stubAuthService answers /auth/authenticate like AuthService: u-1 of acme for
+// the token "good", 401 for anything else. Its key set is empty. It fails the test if the caller's
+// identity headers are passed on.
+func stubAuthService(t *testing.T) *httpclient.Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-User-ID") != "" || r.Header.Get("X-Tenant-ID") != "" {
+			t.Errorf("identity headers reached AuthService: %v", r.Header)
+		}
+		if r.URL.Path == jwks.JWKSPath {
+			w.Write([]byte(`{"keys":[]}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("X-User-ID", "u-1")
+		w.Header().Set("X-Username", "alice")
+		w.Header().Set("X-Tenant-ID", "acme")
+		w.Header().Set(authz.RoleHeader, authz.RoleUser)
+	}))
+	t.Cleanup(server.Close)
+	return httpclient.New(httpclient.Config{Service: "auth-service", BaseURL: server.URL})
+}
+
+// TestSpoofedIdentityHeadersAreIgnored is a regression test for callers
+// reaching the service directly and naming any user in X-User-ID: outside
+// gateway mode only the token counts.
+func TestSpoofedIdentityHeadersAreIgnored(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	client := stubAuthService(t)
+
+	authenticators := map[string]Authenticator{
+		"remote": Remote{Client: client},
+		"local":  Local{Verifier: jwks.NewVerifier(client, time.Minute), Fallback: Remote{Client: client}},
+	}
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+		wantUser   string
+	}{
+		{"no token", "", http.StatusUnauthorized, ""},
+		{"rejected token", "bad", http.StatusUnauthorized, ""},
+	}
+	for name, authenticator := range authenticators {
+		for _, tt := range tests {
+			var reached bool
+			router := gin.New()
+			router.GET("/document/doc-1", Middleware(authenticator), func(c *gin.Context) {
+				reached = true
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/document/doc-1", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			req.Header.Set("X-User-ID", "victim")
+			req.Header.Set("X-Tenant-ID", "victims-tenant")
+			req.Header.Set(authz.RoleHeader, authz.RoleAdmin)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus || reached {
+				t.Errorf("%s, %s: status %d, handler reached %v, want %d", name, tt.name, rec.Code, reached, tt.wantStatus)
+			}
+		}
+	}
+
+	// With a valid token the identity is AuthService's, whatever the headers say
+	var got Identity
+	router := gin.New()
+	router.GET("/document/doc-1", Middleware(Remote{Client: client}), func(c *gin.Context) {
+		got = IdentityFrom(c)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/document/doc-1", nil)
+	req.Header.Set("Authorization", "Bearer good")
+	req.Header.Set("X-User-ID", "victim")
+	req.Header.Set("X-Tenant-ID", "victims-tenant")
+	req.Header.Set(authz.RoleHeader, authz.RoleAdmin)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	want := Identity{UserID: "u-1", Username: "alice", TenantID: "acme", Role: authz.RoleUser}
+	if rec.Code != http.StatusOK || got != want {
+		t.Errorf("valid token with spoofed headers: status %d, identity %+v, want %+v", rec.Code, got, want)
+	}
+}
+
+func TestGatewayHeadersRequireUserID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/document/doc-1", Middleware(GatewayHeaders{}), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	// A bearer token is no identity to a service behind the gateway; only
+	// the headers its auth_request sets are
+	req := httptest.NewRequest(http.MethodGet, "/document/doc-1", nil)
+	req.Header.Set("Authorization", "Bearer good")
+	req.Header.Set("X-Tenant-ID", "acme")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("request without X-User-ID: status %d, want 401", rec.Code)
+	}
+}
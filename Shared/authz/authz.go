@@ -0,0 +1,60 @@
+// Package authz holds the user roles carried in access tokens and the Gin
+// middleware services mount to restrict routes to a role.
+package authz
+
+import (
+	"net/http"
+	"shared/apierror"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles a user can hold. Users created before roles existed have none and are
+// treated as RoleUser.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// RoleHeader is set by AuthService's /auth/authenticate and forwarded by the
+// gateway alongside X-User-ID.
+const RoleHeader = "X-User-Role"
+
+// RoleKey is the gin.Context key under which the caller's role is stored.
+const RoleKey = "role"
+
+// NormalizeRole substitutes RoleUser for an empty role.
+func NormalizeRole(role string) string {
+	role = strings.TrimSpace(role)
+	if role == "" {
+		return RoleUser
+	}
+	return role
+}
+
+// HasRole reports whether a caller holding role satisfies required. Admins
+// satisfy every role.
+func HasRole(role string, required string) bool {
+	role = NormalizeRole(role)
+	return role == RoleAdmin || role == NormalizeRole(required)
+}
+
+// RequireRole aborts with 403 unless the caller holds role. The caller's role
+// is read from RoleKey when an earlier middleware set it, and from RoleHeader
+// otherwise.
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		callerRole, ok := c.Get(RoleKey)
+		if !ok {
+			callerRole = c.GetHeader(RoleHeader)
+		}
+
+		current, _ := callerRole.(string)
+		if !HasRole(current, role) {
+			apierror.Abort(c, http.StatusForbidden, apierror.CodeForbidden, "Forbidden")
+			return
+		}
+		c.Next()
+	}
+}
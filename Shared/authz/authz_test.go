@@ -0,0 +1,80 @@
+package authz
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"shared/apierror"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHasRole(t *testing.T) {
+	tests := []struct {
+		role     string
+		required string
+		want     bool
+	}{
+		{RoleUser, RoleUser, true},
+		{RoleAdmin, RoleAdmin, true},
+		{RoleAdmin, RoleUser, true},
+		{RoleUser, RoleAdmin, false},
+		// Users from before roles existed are users
+		{"", RoleUser, true},
+		{"", RoleAdmin, false},
+		{" admin ", RoleAdmin, true},
+	}
+	for _, tt := range tests {
+		if got := HasRole(tt.role, tt.required); got != tt.want {
+			t.Errorf("HasRole(%q, %q) = %v, want %v", tt.role, tt.required, got, tt.want)
+		}
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		role       any // stored under RoleKey when set
+		header     string
+		wantStatus int
+	}{
+		{"admin on the context", RoleAdmin, "", http.StatusOK},
+		{"user on the context", RoleUser, "", http.StatusForbidden},
+		{"admin in the header", nil, RoleAdmin, http.StatusOK},
+		{"no role", nil, "", http.StatusForbidden},
+		// The role an authenticating middleware stored wins over the header
+		{"user on the context claiming admin", RoleUser, RoleAdmin, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		router := gin.New()
+		router.GET("/admin", func(c *gin.Context) {
+			if tt.role != nil {
+				c.Set(RoleKey, tt.role)
+			}
+		}, RequireRole(RoleAdmin), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		if tt.header != "" {
+			req.Header.Set(RoleHeader, tt.header)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.wantStatus)
+			continue
+		}
+		if tt.wantStatus != http.StatusForbidden {
+			continue
+		}
+		var body apierror.Response
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error.Code != apierror.CodeForbidden {
+			t.Errorf("%s: body %s, want a FORBIDDEN error", tt.name, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Command migrate applies the index definitions and data migrations owned by
+// package shared/migrate.
+//
+//	migrate            apply everything pending
+//	migrate --dry-run  print what would be applied
+//	migrate --check    exit non-zero if anything is pending
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"shared/migrate"
+	"shared/secrets"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "print pending migrations and indexes without applying them")
+	check := flag.Bool("check", false, "exit with status 1 if the schema is behind")
+	database := flag.String("db", "default", "database name")
+	timeout := flag.Duration("timeout", 10*time.Minute, "overall timeout")
+	flag.Parse()
+
+	uri, found, err := secrets.Lookup("MONGO_URI")
+	if err != nil {
+		log.Fatalf("[migrate] %v", err)
+	}
+	if !found {
+		uri = "mongodb://canvas-live-mongodb:27017"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		log.Fatalf("[migrate] Failed to connect to MongoDB: %v", err)
+	}
+	defer client.Disconnect(context.Background())
+
+	if err := client.Ping(ctx, nil); err != nil {
+		log.Fatalf("[migrate] Failed to ping MongoDB: %v", err)
+	}
+
+	m := migrate.New(client.Database(*database))
+
+	if *check {
+		if err := m.Check(ctx); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println("schema is up to date")
+		return
+	}
+
+	status, err := m.Apply(ctx, *dryRun)
+	if err != nil {
+		log.Fatalf("[migrate] Migration failed: %v", err)
+	}
+
+	if *dryRun {
+		fmt.Println(status)
+		return
+	}
+	fmt.Printf("applied %d migrations and %d indexes\n", len(status.Pending), len(status.MissingIndexes))
+}
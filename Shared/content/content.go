@@ -0,0 +1,37 @@
+// Package content holds the size limit on document content. DocumentService
+// checks content written over REST, UpdatesService the frames clients send
+// live, and the updates consumer what reaches Kafka, so all three agree on the
+// limit and on how it is reported.
+package content
+
+import "fmt"
+
+// MaxBytesEnv names the variable every service reads the limit from.
+const MaxBytesEnv = "MAX_CONTENT_BYTES"
+
+// DefaultMaxBytes is the limit when MaxBytesEnv is unset. It stays well under
+// MongoDB's 16MB document limit, leaving room for the document's metadata.
+const DefaultMaxBytes = 5 << 20
+
+// TooLargeError is returned for content over the limit.
+type TooLargeError struct {
+	Size  int64
+	Limit int64
+}
+
+func (e *TooLargeError) Error() string {
+	return TooLargeMessage(e.Limit)
+}
+
+// TooLargeMessage is the message shown to clients whose content is over limit.
+func TooLargeMessage(limit int64) string {
+	return fmt.Sprintf("Content must be at most %d bytes", limit)
+}
+
+// Check returns a *TooLargeError when size is over limit.
+func Check(size int64, limit int64) error {
+	if size > limit {
+		return &TooLargeError{Size: size, Limit: limit}
+	}
+	return nil
+}
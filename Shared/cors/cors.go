@@ -0,0 +1,136 @@
+// Package cors answers cross-origin requests for the HTTP services, so
+// browser clients served from another origin can call them directly.
+package cors
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Config lists what cross-origin callers may do. An AllowedOrigins entry of
+// "*" admits every origin; credentials (cookies) are then never allowed,
+// since browsers reject them alongside a wildcard. Listed origins are
+// compared case-insensitively, scheme and port included.
+type Config struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// FromEnv reads the configuration from CORS_ALLOWED_ORIGINS,
+// CORS_ALLOWED_HEADERS, CORS_ALLOW_CREDENTIALS, and CORS_MAX_AGE. Lists are
+// comma-separated.
+func FromEnv() Config {
+	config := Config{
+		AllowedOrigins:   splitList(getEnv("CORS_ALLOWED_ORIGINS", "*")),
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   splitList(getEnv("CORS_ALLOWED_HEADERS", "Authorization,Content-Type,X-User-ID")),
+		AllowCredentials: getEnv("CORS_ALLOW_CREDENTIALS", "false") == "true",
+		MaxAge:           10 * time.Minute,
+	}
+	if maxAge, err := time.ParseDuration(os.Getenv("CORS_MAX_AGE")); err == nil {
+		config.MaxAge = maxAge
+	}
+	return config
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or ""
+// when origin is not allowed.
+func (c Config) allowOrigin(origin string) string {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			if c.AllowCredentials {
+				// Credentialed requests need the origin itself; only listed
+				// origins get that
+				continue
+			}
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// CheckOrigin reports whether a request may be served to its origin. Requests
+// without an Origin header do not come from a browser page and are allowed.
+// It fits websocket.Upgrader.CheckOrigin.
+func (c Config) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware sets the CORS headers on responses to allowed origins and
+// answers preflight requests itself. Mount it with router.Use, ahead of any
+// authentication, so preflights (which carry no credentials) never reach it.
+func Middleware(config Config) gin.HandlerFunc {
+	methods := strings.Join(config.AllowedMethods, ", ")
+	headers := strings.Join(config.AllowedHeaders, ", ")
+	maxAge := strconv.Itoa(int(config.MaxAge.Seconds()))
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		// The response depends on the origin, so caches must key on it
+		c.Writer.Header().Add("Vary", "Origin")
+
+		allowOrigin := config.allowOrigin(origin)
+		if allowOrigin != "" {
+			c.Header("Access-Control-Allow-Origin", allowOrigin)
+			if config.AllowCredentials && allowOrigin != "*" {
+				c.Header("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if !preflight {
+			c.Next()
+			return
+		}
+
+		// Without the Allow headers the browser refuses the actual request
+		if allowOrigin != "" {
+			c.Header("Access-Control-Allow-Methods", methods)
+			c.Header("Access-Control-Allow-Headers", headers)
+			c.Header("Access-Control-Max-Age", maxAge)
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+func getEnv(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
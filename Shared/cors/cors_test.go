@@ -0,0 +1,169 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsRouter serves GET /document behind the middleware and a guard standing
+// in for authentication, which refuses every request reaching it without an
+// Authorization header.
+func corsRouter(config Config) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware(config), func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			c.AbortWithStatus(http.StatusUnauthorized)
+		}
+	})
+	router.GET("/document", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return router
+}
+
+func testConfig(origins ...string) Config {
+	return Config{
+		AllowedOrigins: origins,
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Authorization", "X-User-ID"},
+		MaxAge:         time.Minute,
+	}
+}
+
+func TestAllowOrigin(t *testing.T) {
+	listed := testConfig("https://app.example.com", "http://localhost:3000")
+	wildcard := testConfig("*")
+	credentialed := testConfig("*", "https://app.example.com")
+	credentialed.AllowCredentials = true
+
+	tests := []struct {
+		name   string
+		config Config
+		origin string
+		want   string
+	}{
+		{"listed", listed, "https://app.example.com", "https://app.example.com"},
+		{"listed, other case", listed, "HTTPS://APP.example.com", "HTTPS://APP.example.com"},
+		{"listed port", listed, "http://localhost:3000", "http://localhost:3000"},
+		{"other port", listed, "http://localhost:8080", ""},
+		{"other scheme", listed, "http://app.example.com", ""},
+		{"not listed", listed, "https://evil.example.com", ""},
+		{"wildcard", wildcard, "https://anything.example.com", "*"},
+		// Credentials need the origin echoed, which only listed ones get
+		{"wildcard with credentials, listed", credentialed, "https://app.example.com", "https://app.example.com"},
+		{"wildcard with credentials, not listed", credentialed, "https://evil.example.com", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/document", nil)
+		req.Header.Set("Origin", tt.origin)
+		req.Header.Set("Authorization", "Bearer token")
+		rec := httptest.NewRecorder()
+		corsRouter(tt.config).ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.want {
+			t.Errorf("%s: Access-Control-Allow-Origin %q, want %q", tt.name, got, tt.want)
+		}
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d, want 200; CORS is for the browser to enforce", tt.name, rec.Code)
+		}
+		if rec.Header().Get("Vary") != "Origin" {
+			t.Errorf("%s: Vary %q, want Origin", tt.name, rec.Header().Get("Vary"))
+		}
+		wantCredentials := ""
+		if tt.config.AllowCredentials && tt.want != "" {
+			wantCredentials = "true"
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != wantCredentials {
+			t.Errorf("%s: Access-Control-Allow-Credentials %q, want %q", tt.name, got, wantCredentials)
+		}
+	}
+}
+
+func TestPreflightSkipsAuthentication(t *testing.T) {
+	router := corsRouter(testConfig("https://app.example.com"))
+
+	tests := []struct {
+		origin      string
+		wantMethods string
+	}{
+		{"https://app.example.com", "GET, POST"},
+		{"https://evil.example.com", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodOptions, "/document", nil)
+		req.Header.Set("Origin", tt.origin)
+		req.Header.Set("Access-Control-Request-Method", "GET")
+		req.Header.Set("Access-Control-Request-Headers", "Authorization")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusNoContent {
+			t.Errorf("%s: preflight status %d, want 204 without authenticating", tt.origin, rec.Code)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Methods"); got != tt.wantMethods {
+			t.Errorf("%s: Access-Control-Allow-Methods %q, want %q", tt.origin, got, tt.wantMethods)
+		}
+		if tt.wantMethods != "" {
+			if got := rec.Header().Get("Access-Control-Allow-Headers"); got != "Authorization, X-User-ID" {
+				t.Errorf("%s: Access-Control-Allow-Headers %q", tt.origin, got)
+			}
+			if got := rec.Header().Get("Access-Control-Max-Age"); got != "60" {
+				t.Errorf("%s: Access-Control-Max-Age %q, want 60", tt.origin, got)
+			}
+		}
+	}
+}
+
+func TestRequestsWithoutOriginAreUntouched(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/document", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+	corsRouter(testConfig("https://app.example.com")).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "" || rec.Header().Get("Vary") != "" {
+		t.Errorf("status %d, headers %v, want 200 without CORS headers", rec.Code, rec.Header())
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		origin string
+		want   bool
+	}{
+		{"no origin", testConfig("https://app.example.com"), "", true},
+		{"listed", testConfig("https://app.example.com"), "https://app.example.com", true},
+		{"not listed", testConfig("https://app.example.com"), "https://evil.example.com", false},
+		{"wildcard", testConfig("*"), "https://evil.example.com", true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/updates/ws/docId/doc-1", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if got := tt.config.CheckOrigin(req); got != tt.want {
+			t.Errorf("%s: CheckOrigin = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", " https://app.example.com, ,http://localhost:3000 ")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+	t.Setenv("CORS_MAX_AGE", "1h")
+
+	config := FromEnv()
+	if len(config.AllowedOrigins) != 2 || config.AllowedOrigins[0] != "https://app.example.com" || config.AllowedOrigins[1] != "http://localhost:3000" {
+		t.Errorf("AllowedOrigins = %q", config.AllowedOrigins)
+	}
+	if !config.AllowCredentials || config.MaxAge != time.Hour {
+		t.Errorf("AllowCredentials %v, MaxAge %s, want true 1h", config.AllowCredentials, config.MaxAge)
+	}
+	if len(config.AllowedHeaders) != 3 || config.AllowedHeaders[0] != "Authorization" || config.AllowedHeaders[2] != "X-User-ID" {
+		t.Errorf("AllowedHeaders = %q, want the defaults", config.AllowedHeaders)
+	}
+}
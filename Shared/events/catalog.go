@@ -0,0 +1,273 @@
+// Package events is the catalog of lifecycle events exchanged between
+// services over Kafka. Producers build a typed payload and Encode it;
+// consumers Decode envelopes back into typed payloads. Unknown or malformed
+// events are rejected explicitly instead of being silently half-parsed.
+package events
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Topics carrying lifecycle events.
+const (
+	TopicDocumentEvents = "document-events"
+	TopicUserEvents     = "user-events"
+)
+
+// Event types. The string values are part of the wire format.
+const (
+	DocumentCreated      = "document.created"
+	DocumentShared       = "document.shared"
+	DocumentDeleted      = "document.deleted"
+	DocumentTrashed      = "document.trashed"
+	DocumentRestored     = "document.restored"
+	DocumentRenamed      = "document.renamed"
+	OwnershipTransferred = "document.ownership_transferred"
+	ContentReplaced      = "document.content_replaced"
+	ShareRevoked         = "share.revoked"
+	DocumentLocked       = "document.locked"
+	DocumentUnlocked     = "document.unlocked"
+	UserCreated          = "user.created"
+	UserDeleted          = "user.deleted"
+)
+
+// Event is implemented by every payload in the catalog.
+type Event interface {
+	// EventType returns one of the event type constants.
+	EventType() string
+	// AggregateID is the Kafka key; events of one aggregate stay ordered.
+	AggregateID() string
+	// Occurred returns when the change happened.
+	Occurred() time.Time
+	// Validate reports missing or inconsistent fields.
+	Validate() error
+}
+
+// DocumentCreatedEvent is emitted when a document is created.
+type DocumentCreatedEvent struct {
+	DocumentID string    `json:"documentId"`
+	TenantID   string    `json:"tenantId"`
+	OwnerID    string    `json:"ownerId"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func (DocumentCreatedEvent) EventType() string     { return DocumentCreated }
+func (e DocumentCreatedEvent) AggregateID() string { return e.DocumentID }
+func (e DocumentCreatedEvent) Occurred() time.Time { return e.OccurredAt }
+func (e DocumentCreatedEvent) Validate() error {
+	return require(e.OccurredAt, "documentId", e.DocumentID, "tenantId", e.TenantID, "ownerId", e.OwnerID)
+}
+
+// DocumentSharedEvent is emitted when a document is shared with a user.
+type DocumentSharedEvent struct {
+	DocumentID string    `json:"documentId"`
+	TenantID   string    `json:"tenantId"`
+	UserID     string    `json:"userId"`
+	AccessType string    `json:"accessType"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func (DocumentSharedEvent) EventType() string     { return DocumentShared }
+func (e DocumentSharedEvent) AggregateID() string { return e.DocumentID }
+func (e DocumentSharedEvent) Occurred() time.Time { return e.OccurredAt }
+func (e DocumentSharedEvent) Validate() error {
+	return require(e.OccurredAt, "documentId", e.DocumentID, "tenantId", e.TenantID, "userId", e.UserID, "accessType", e.AccessType)
+}
+
+// DocumentDeletedEvent is emitted when a document is deleted, together with
+// its shares. Consumers close the document's live sessions and drop its
+// pending updates.
+type DocumentDeletedEvent struct {
+	DocumentID string    `json:"documentId"`
+	TenantID   string    `json:"tenantId"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func (DocumentDeletedEvent) EventType() string     { return DocumentDeleted }
+func (e DocumentDeletedEvent) AggregateID() string { return e.DocumentID }
+func (e DocumentDeletedEvent) Occurred() time.Time { return e.OccurredAt }
+func (e DocumentDeletedEvent) Validate() error {
+	return require(e.OccurredAt, "documentId", e.DocumentID, "tenantId", e.TenantID)
+}
+
+// DocumentTrashedEvent is emitted when a document is moved to the trash. It
+// keeps its shares but can no longer be opened or edited until restored.
+type DocumentTrashedEvent struct {
+	DocumentID string    `json:"documentId"`
+	TenantID   string    `json:"tenantId"`
+	TrashedBy  string    `json:"trashedBy"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func (DocumentTrashedEvent) EventType() string     { return DocumentTrashed }
+func (e DocumentTrashedEvent) AggregateID() string { return e.DocumentID }
+func (e DocumentTrashedEvent) Occurred() time.Time { return e.OccurredAt }
+func (e DocumentTrashedEvent) Validate() error {
+	return require(e.OccurredAt, "documentId", e.DocumentID, "tenantId", e.TenantID, "trashedBy", e.TrashedBy)
+}
+
+// DocumentRestoredEvent is emitted when a document is taken out of the trash.
+type DocumentRestoredEvent struct {
+	DocumentID string    `json:"documentId"`
+	TenantID   string    `json:"tenantId"`
+	RestoredBy string    `json:"restoredBy"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func (DocumentRestoredEvent) EventType() string     { return DocumentRestored }
+func (e DocumentRestoredEvent) AggregateID() string { return e.DocumentID }
+func (e DocumentRestoredEvent) Occurred() time.Time { return e.OccurredAt }
+func (e DocumentRestoredEvent) Validate() error {
+	return require(e.OccurredAt, "documentId", e.DocumentID, "tenantId", e.TenantID, "restoredBy", e.RestoredBy)
+}
+
+// DocumentRenamedEvent is emitted when a document's title changes, so open
+// editors can show the new title.
+type DocumentRenamedEvent struct {
+	DocumentID string    `json:"documentId"`
+	TenantID   string    `json:"tenantId"`
+	Title      string    `json:"title"`
+	RenamedBy  string    `json:"renamedBy"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func (DocumentRenamedEvent) EventType() string     { return DocumentRenamed }
+func (e DocumentRenamedEvent) AggregateID() string { return e.DocumentID }
+func (e DocumentRenamedEvent) Occurred() time.Time { return e.OccurredAt }
+func (e DocumentRenamedEvent) Validate() error {
+	return require(e.OccurredAt, "documentId", e.DocumentID, "tenantId", e.TenantID, "title", e.Title, "renamedBy", e.RenamedBy)
+}
+
+// OwnershipTransferredEvent is emitted when a document gets a new owner.
+// PreviousOwnerAccess is the access type the previous owner kept as a
+// collaborator, or empty when they lost access.
+type OwnershipTransferredEvent struct {
+	DocumentID          string    `json:"documentId"`
+	TenantID            string    `json:"tenantId"`
+	PreviousOwnerID     string    `json:"previousOwnerId"`
+	NewOwnerID          string    `json:"newOwnerId"`
+	PreviousOwnerAccess string    `json:"previousOwnerAccess,omitempty"`
+	OccurredAt          time.Time `json:"occurredAt"`
+}
+
+func (OwnershipTransferredEvent) EventType() string     { return OwnershipTransferred }
+func (e OwnershipTransferredEvent) AggregateID() string { return e.DocumentID }
+func (e OwnershipTransferredEvent) Occurred() time.Time { return e.OccurredAt }
+func (e OwnershipTransferredEvent) Validate() error {
+	return require(e.OccurredAt, "documentId", e.DocumentID, "tenantId", e.TenantID, "previousOwnerId", e.PreviousOwnerID, "newOwnerId", e.NewOwnerID)
+}
+
+// ContentReplacedEvent is emitted when a document's content is replaced
+// outside the live editing path, e.g. by PUT /document/:id/content. Open
+// editors hold content that no longer matches and must reload it.
+type ContentReplacedEvent struct {
+	DocumentID string    `json:"documentId"`
+	TenantID   string    `json:"tenantId"`
+	ReplacedBy string    `json:"replacedBy"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	Version    int64     `json:"version"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func (ContentReplacedEvent) EventType() string     { return ContentReplaced }
+func (e ContentReplacedEvent) AggregateID() string { return e.DocumentID }
+func (e ContentReplacedEvent) Occurred() time.Time { return e.OccurredAt }
+func (e ContentReplacedEvent) Validate() error {
+	return require(e.OccurredAt, "documentId", e.DocumentID, "tenantId", e.TenantID, "replacedBy", e.ReplacedBy)
+}
+
+// ShareRevokedEvent is emitted when a user loses access to a shared document.
+type ShareRevokedEvent struct {
+	DocumentID string    `json:"documentId"`
+	TenantID   string    `json:"tenantId"`
+	UserID     string    `json:"userId"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func (ShareRevokedEvent) EventType() string     { return ShareRevoked }
+func (e ShareRevokedEvent) AggregateID() string { return e.DocumentID }
+func (e ShareRevokedEvent) Occurred() time.Time { return e.OccurredAt }
+func (e ShareRevokedEvent) Validate() error {
+	return require(e.OccurredAt, "documentId", e.DocumentID, "tenantId", e.TenantID, "userId", e.UserID)
+}
+
+// DocumentLockedEvent is emitted when the owner locks a document. Its content
+// is frozen from LockedAt on: live sessions stop accepting edits and updates
+// sent later are dropped.
+type DocumentLockedEvent struct {
+	DocumentID string    `json:"documentId"`
+	TenantID   string    `json:"tenantId"`
+	LockedBy   string    `json:"lockedBy"`
+	LockedAt   time.Time `json:"lockedAt"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func (DocumentLockedEvent) EventType() string     { return DocumentLocked }
+func (e DocumentLockedEvent) AggregateID() string { return e.DocumentID }
+func (e DocumentLockedEvent) Occurred() time.Time { return e.OccurredAt }
+func (e DocumentLockedEvent) Validate() error {
+	return require(e.OccurredAt, "documentId", e.DocumentID, "tenantId", e.TenantID, "lockedBy", e.LockedBy)
+}
+
+// DocumentUnlockedEvent is emitted when the owner unlocks a document, making
+// it editable again.
+type DocumentUnlockedEvent struct {
+	DocumentID string    `json:"documentId"`
+	TenantID   string    `json:"tenantId"`
+	UnlockedBy string    `json:"unlockedBy"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func (DocumentUnlockedEvent) EventType() string     { return DocumentUnlocked }
+func (e DocumentUnlockedEvent) AggregateID() string { return e.DocumentID }
+func (e DocumentUnlockedEvent) Occurred() time.Time { return e.OccurredAt }
+func (e DocumentUnlockedEvent) Validate() error {
+	return require(e.OccurredAt, "documentId", e.DocumentID, "tenantId", e.TenantID, "unlockedBy", e.UnlockedBy)
+}
+
+// UserCreatedEvent is emitted when an account is registered.
+type UserCreatedEvent struct {
+	UserID     string    `json:"userId"`
+	TenantID   string    `json:"tenantId"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func (UserCreatedEvent) EventType() string     { return UserCreated }
+func (e UserCreatedEvent) AggregateID() string { return e.UserID }
+func (e UserCreatedEvent) Occurred() time.Time { return e.OccurredAt }
+func (e UserCreatedEvent) Validate() error {
+	return require(e.OccurredAt, "userId", e.UserID, "tenantId", e.TenantID)
+}
+
+// UserDeletedEvent is emitted when an account is deleted. Consumers remove
+// the user's shares and close their sessions.
+type UserDeletedEvent struct {
+	UserID     string    `json:"userId"`
+	TenantID   string    `json:"tenantId"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func (UserDeletedEvent) EventType() string     { return UserDeleted }
+func (e UserDeletedEvent) AggregateID() string { return e.UserID }
+func (e UserDeletedEvent) Occurred() time.Time { return e.OccurredAt }
+func (e UserDeletedEvent) Validate() error {
+	return require(e.OccurredAt, "userId", e.UserID, "tenantId", e.TenantID)
+}
+
+// ErrInvalidEvent wraps every validation failure.
+var ErrInvalidEvent = errors.New("invalid event")
+
+// require checks occurredAt and the given name/value pairs for emptiness.
+func require(occurredAt time.Time, fields ...string) error {
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i+1] == "" {
+			return fmt.Errorf("%w: %s is required", ErrInvalidEvent, fields[i])
+		}
+	}
+	if occurredAt.IsZero() {
+		return fmt.Errorf("%w: occurredAt is required", ErrInvalidEvent)
+	}
+	return nil
+}
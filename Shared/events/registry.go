@@ -0,0 +1,183 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrUnknownEvent is returned when an envelope carries an unregistered type.
+	ErrUnknownEvent = errors.New("unknown event type")
+	// ErrMalformedEvent is returned when a payload cannot be decoded or is invalid.
+	ErrMalformedEvent = errors.New("malformed event")
+)
+
+// Envelope is the value written to Kafka for every event.
+type Envelope struct {
+	// ID is assigned by the producer; the DocumentService outbox uses its row
+	// ID so redeliveries of one event share it.
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	AggregateID string          `json:"aggregateId"`
+	OccurredAt  time.Time       `json:"occurredAt"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
+type registration struct {
+	topic string
+	new   func() Event
+}
+
+// registry maps each event type to its topic and payload constructor.
+var registry = map[string]registration{
+	DocumentCreated:      {TopicDocumentEvents, func() Event { return &DocumentCreatedEvent{} }},
+	DocumentShared:       {TopicDocumentEvents, func() Event { return &DocumentSharedEvent{} }},
+	DocumentDeleted:      {TopicDocumentEvents, func() Event { return &DocumentDeletedEvent{} }},
+	DocumentTrashed:      {TopicDocumentEvents, func() Event { return &DocumentTrashedEvent{} }},
+	DocumentRestored:     {TopicDocumentEvents, func() Event { return &DocumentRestoredEvent{} }},
+	DocumentRenamed:      {TopicDocumentEvents, func() Event { return &DocumentRenamedEvent{} }},
+	OwnershipTransferred: {TopicDocumentEvents, func() Event { return &OwnershipTransferredEvent{} }},
+	ContentReplaced:      {TopicDocumentEvents, func() Event { return &ContentReplacedEvent{} }},
+	ShareRevoked:         {TopicDocumentEvents, func() Event { return &ShareRevokedEvent{} }},
+	DocumentLocked:       {TopicDocumentEvents, func() Event { return &DocumentLockedEvent{} }},
+	DocumentUnlocked:     {TopicDocumentEvents, func() Event { return &DocumentUnlockedEvent{} }},
+	UserCreated:          {TopicUserEvents, func() Event { return &UserCreatedEvent{} }},
+	UserDeleted:          {TopicUserEvents, func() Event { return &UserDeletedEvent{} }},
+}
+
+// Types returns every registered event type in sorted order.
+func Types() []string {
+	types := make([]string, 0, len(registry))
+	for t := range registry {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// TopicOf returns the topic an event type is published on.
+func TopicOf(eventType string) (string, error) {
+	reg, ok := registry[eventType]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownEvent, eventType)
+	}
+	return reg.topic, nil
+}
+
+// Encode validates e and wraps it in an envelope without an ID.
+func Encode(e Event) (Envelope, error) {
+	if _, ok := registry[e.EventType()]; !ok {
+		return Envelope{}, fmt.Errorf("%w: %q", ErrUnknownEvent, e.EventType())
+	}
+	if err := e.Validate(); err != nil {
+		return Envelope{}, err
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("encoding %s payload: %w", e.EventType(), err)
+	}
+	return Envelope{
+		Type:        e.EventType(),
+		AggregateID: e.AggregateID(),
+		OccurredAt:  e.Occurred(),
+		Payload:     payload,
+	}, nil
+}
+
+// Decode turns an envelope back into its typed payload. The result is a value
+// (e.g. DocumentCreatedEvent), not a pointer.
+func Decode(env Envelope) (Event, error) {
+	reg, ok := registry[env.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownEvent, env.Type)
+	}
+
+	ptr := reg.new()
+	if err := json.Unmarshal(env.Payload, ptr); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrMalformedEvent, env.Type, err)
+	}
+	if err := ptr.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrMalformedEvent, env.Type, err)
+	}
+	if ptr.AggregateID() != env.AggregateID {
+		return nil, fmt.Errorf("%w: %s: aggregate ID mismatch", ErrMalformedEvent, env.Type)
+	}
+	return deref(ptr), nil
+}
+
+// DecodeValue decodes a raw Kafka value into an envelope and its payload.
+func DecodeValue(value []byte) (Envelope, Event, error) {
+	var env Envelope
+	if err := json.Unmarshal(value, &env); err != nil {
+		return Envelope{}, nil, fmt.Errorf("%w: envelope: %v", ErrMalformedEvent, err)
+	}
+	e, err := Decode(env)
+	return env, e, err
+}
+
+// Handler processes one decoded event.
+type Handler func(ctx context.Context, env Envelope, e Event) error
+
+// Dispatcher routes decoded events to per-type handlers. Consumers register
+// every type of the topics they read, either with Handle or explicitly with
+// Ignore, and call Unhandled at startup to catch newly added types.
+type Dispatcher struct {
+	handlers map[string]Handler
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{handlers: map[string]Handler{}}
+}
+
+// Handle registers h for eventType.
+func (d *Dispatcher) Handle(eventType string, h Handler) *Dispatcher {
+	d.handlers[eventType] = h
+	return d
+}
+
+// Ignore registers no-op handlers, documenting that the consumer does not
+// care about these types.
+func (d *Dispatcher) Ignore(eventTypes ...string) *Dispatcher {
+	for _, t := range eventTypes {
+		d.handlers[t] = func(context.Context, Envelope, Event) error { return nil }
+	}
+	return d
+}
+
+// Unhandled returns the event types of topic with no registration.
+func (d *Dispatcher) Unhandled(topic string) []string {
+	var missing []string
+	for _, t := range Types() {
+		if registry[t].topic != topic {
+			continue
+		}
+		if _, ok := d.handlers[t]; !ok {
+			missing = append(missing, t)
+		}
+	}
+	return missing
+}
+
+// Dispatch decodes value and runs the handler of its type.
+func (d *Dispatcher) Dispatch(ctx context.Context, value []byte) error {
+	env, e, err := DecodeValue(value)
+	if err != nil {
+		return err
+	}
+	h, ok := d.handlers[env.Type]
+	if !ok {
+		return fmt.Errorf("%w: no handler for %q", ErrUnknownEvent, env.Type)
+	}
+	return h(ctx, env, e)
+}
+
+// deref turns the pointer used for unmarshalling back into the payload value.
+func deref(e Event) Event {
+	return reflect.ValueOf(e).Elem().Interface().(Event)
+}
@@ -0,0 +1,188 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var occurredAt = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// samples holds a valid payload of every event type. A type added to the
+// catalog without one fails TestEveryTypeRoundTrips.
+var samples = []Event{
+	DocumentCreatedEvent{DocumentID: "doc-1", TenantID: "t-1", OwnerID: "u-1", OccurredAt: occurredAt},
+	DocumentSharedEvent{DocumentID: "doc-1", TenantID: "t-1", UserID: "u-2", AccessType: "write", OccurredAt: occurredAt},
+	DocumentDeletedEvent{DocumentID: "doc-1", TenantID: "t-1", OccurredAt: occurredAt},
+	DocumentTrashedEvent{DocumentID: "doc-1", TenantID: "t-1", TrashedBy: "u-1", OccurredAt: occurredAt},
+	DocumentRestoredEvent{DocumentID: "doc-1", TenantID: "t-1", RestoredBy: "u-1", OccurredAt: occurredAt},
+	DocumentRenamedEvent{DocumentID: "doc-1", TenantID: "t-1", Title: "Plan", RenamedBy: "u-1", OccurredAt: occurredAt},
+	OwnershipTransferredEvent{DocumentID: "doc-1", TenantID: "t-1", PreviousOwnerID: "u-1", NewOwnerID: "u-2", PreviousOwnerAccess: "read", OccurredAt: occurredAt},
+	ContentReplacedEvent{DocumentID: "doc-1", TenantID: "t-1", ReplacedBy: "u-1", UpdatedAt: occurredAt, Version: 7, OccurredAt: occurredAt},
+	ShareRevokedEvent{DocumentID: "doc-1", TenantID: "t-1", UserID: "u-2", OccurredAt: occurredAt},
+	DocumentLockedEvent{DocumentID: "doc-1", TenantID: "t-1", LockedBy: "u-1", LockedAt: occurredAt, OccurredAt: occurredAt},
+	DocumentUnlockedEvent{DocumentID: "doc-1", TenantID: "t-1", UnlockedBy: "u-1", OccurredAt: occurredAt},
+	UserCreatedEvent{UserID: "u-1", TenantID: "t-1", OccurredAt: occurredAt},
+	UserDeletedEvent{UserID: "u-1", TenantID: "t-1", OccurredAt: occurredAt},
+}
+
+func TestEveryTypeRoundTrips(t *testing.T) {
+	covered := map[string]bool{}
+	for _, sample := range samples {
+		covered[sample.EventType()] = true
+
+		env, err := Encode(sample)
+		if err != nil {
+			t.Fatalf("%s: encode: %v", sample.EventType(), err)
+		}
+		value, err := json.Marshal(env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decodedEnv, decoded, err := DecodeValue(value)
+		if err != nil {
+			t.Fatalf("%s: decode: %v", sample.EventType(), err)
+		}
+		if decodedEnv.Type != sample.EventType() || decodedEnv.AggregateID != sample.AggregateID() || !decodedEnv.OccurredAt.Equal(sample.Occurred()) {
+			t.Fatalf("%s: envelope %+v", sample.EventType(), decodedEnv)
+		}
+		if !reflect.DeepEqual(decoded, sample) {
+			t.Fatalf("%s: decoded %#v, want %#v", sample.EventType(), decoded, sample)
+		}
+	}
+
+	for _, eventType := range Types() {
+		if !covered[eventType] {
+			t.Errorf("no round trip sample for %s", eventType)
+		}
+	}
+}
+
+// declaredTypes returns the event type constants of catalog.go, by name.
+func declaredTypes(t *testing.T) map[string]string {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "catalog.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	declared := map[string]string{}
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			value := spec.(*ast.ValueSpec)
+			for i, name := range value.Names {
+				lit, ok := value.Values[i].(*ast.BasicLit)
+				if !ok || lit.Kind != token.STRING || strings.HasPrefix(name.Name, "Topic") {
+					continue
+				}
+				declared[name.Name], _ = strconv.Unquote(lit.Value)
+			}
+		}
+	}
+	return declared
+}
+
+func TestEveryDeclaredTypeIsRegisteredOnce(t *testing.T) {
+	declared := declaredTypes(t)
+	if len(declared) == 0 {
+		t.Fatal("no event type constants found in catalog.go")
+	}
+
+	names := map[string]string{}
+	for name, value := range declared {
+		if other, ok := names[value]; ok {
+			t.Errorf("%s and %s share the event type %q", other, name, value)
+		}
+		names[value] = name
+		if _, err := TopicOf(value); err != nil {
+			t.Errorf("%s (%q) is not registered", name, value)
+		}
+	}
+	for _, registered := range Types() {
+		if _, ok := names[registered]; !ok {
+			t.Errorf("registered type %q has no constant in catalog.go", registered)
+		}
+	}
+
+	// Each registration must build the payload of its own type
+	for eventType, reg := range registry {
+		if got := reg.new().EventType(); got != eventType {
+			t.Errorf("type %q registered with a %q payload", eventType, got)
+		}
+	}
+}
+
+func TestDecodeRejectsUnknownAndMalformedEvents(t *testing.T) {
+	valid, err := Encode(DocumentDeletedEvent{DocumentID: "doc-1", TenantID: "t-1", OccurredAt: occurredAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unknown := valid
+	unknown.Type = "document.exploded"
+	if _, err := Decode(unknown); !errors.Is(err, ErrUnknownEvent) {
+		t.Errorf("unknown type: %v, want ErrUnknownEvent", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Envelope)
+	}{
+		{"payload not JSON", func(e *Envelope) { e.Payload = json.RawMessage(`{`) }},
+		{"missing field", func(e *Envelope) {
+			e.Payload = json.RawMessage(`{"documentId":"doc-1","occurredAt":"2024-05-01T12:00:00Z"}`)
+		}},
+		{"aggregate mismatch", func(e *Envelope) { e.AggregateID = "doc-2" }},
+	}
+	for _, tt := range tests {
+		env := valid
+		tt.modify(&env)
+		if _, err := Decode(env); !errors.Is(err, ErrMalformedEvent) {
+			t.Errorf("%s: %v, want ErrMalformedEvent", tt.name, err)
+		}
+	}
+
+	if _, err := Encode(DocumentDeletedEvent{DocumentID: "doc-1"}); !errors.Is(err, ErrInvalidEvent) {
+		t.Errorf("encoding an invalid event: %v, want ErrInvalidEvent", err)
+	}
+}
+
+func TestDispatcherReportsUnhandledTypes(t *testing.T) {
+	var handled []string
+	d := NewDispatcher().
+		Handle(UserCreated, func(ctx context.Context, env Envelope, e Event) error {
+			handled = append(handled, e.(UserCreatedEvent).UserID)
+			return nil
+		})
+
+	if missing := d.Unhandled(TopicUserEvents); !reflect.DeepEqual(missing, []string{UserDeleted}) {
+		t.Fatalf("unhandled = %v, want [%s]", missing, UserDeleted)
+	}
+
+	deleted, _ := Encode(UserDeletedEvent{UserID: "u-1", TenantID: "t-1", OccurredAt: occurredAt})
+	value, _ := json.Marshal(deleted)
+	if err := d.Dispatch(context.Background(), value); !errors.Is(err, ErrUnknownEvent) {
+		t.Fatalf("dispatching an unregistered type: %v, want ErrUnknownEvent", err)
+	}
+
+	d.Ignore(UserDeleted)
+	if missing := d.Unhandled(TopicUserEvents); len(missing) != 0 {
+		t.Fatalf("unhandled after Ignore = %v", missing)
+	}
+	created, _ := Encode(UserCreatedEvent{UserID: "u-1", TenantID: "t-1", OccurredAt: occurredAt})
+	value, _ = json.Marshal(created)
+	if err := d.Dispatch(context.Background(), value); err != nil || len(handled) != 1 {
+		t.Fatalf("dispatch = %v, handled %v", err, handled)
+	}
+}
@@ -0,0 +1,54 @@
+package httpclient
+
+import (
+	"sync"
+	"time"
+)
+
+// ConsecutiveBreaker opens after Threshold failed attempts in a row and then
+// rejects calls for Cooldown, so callers fail fast instead of waiting on a
+// target that is down. After the cooldown one trial call is let through: its
+// success closes the breaker, its failure opens it for another cooldown.
+type ConsecutiveBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+// NewConsecutiveBreaker returns a closed breaker.
+func NewConsecutiveBreaker(threshold int, cooldown time.Duration) *ConsecutiveBreaker {
+	return &ConsecutiveBreaker{Threshold: max(threshold, 1), Cooldown: cooldown}
+}
+
+func (b *ConsecutiveBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.Threshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.trial {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+func (b *ConsecutiveBreaker) Record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+	if success {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.Threshold {
+		b.openUntil = time.Now().Add(b.Cooldown)
+	}
+}
@@ -0,0 +1,256 @@
+// Package httpclient is the client every service uses to call another one. It
+// applies a per-target timeout, retries idempotent requests on transient
+// failures, reports outcomes to an optional circuit breaker, propagates the
+// request ID and trace context, signs requests with the internal HMAC key,
+// and returns *Error values that tell timeouts, 4xx, and 5xx apart.
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxErrorBody bounds how much of a failed response is kept in Error.Body.
+const maxErrorBody = 4 << 10
+
+// Breaker is the circuit breaker hook. Allow is asked before every attempt;
+// Record is told whether the attempt succeeded. 4xx responses count as
+// successes since the target itself is healthy.
+type Breaker interface {
+	Allow() bool
+	Record(success bool)
+}
+
+// RetryPolicy controls retries of idempotent requests.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy retries twice with jittered exponential backoff.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: 2 * time.Second}
+
+// Config configures a client for one target service.
+type Config struct {
+	// Service names the target in errors and logs.
+	Service string
+	BaseURL string
+	// Timeout applies to each attempt; the caller's context bounds the total.
+	Timeout time.Duration
+	Retry   RetryPolicy
+	Breaker Breaker
+	// SigningKey returns the current internal HMAC key. Requests are unsigned
+	// when it is nil or returns "". It is called per request so rotated keys
+	// are picked up without rebuilding the client.
+	SigningKey func() string
+	// Transport defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+}
+
+// Client calls a single target service.
+type Client struct {
+	cfg  Config
+	http *http.Client
+}
+
+// New creates a client. A zero Timeout defaults to 5 seconds.
+func New(cfg Config) *Client {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.Retry.MaxAttempts <= 0 {
+		cfg.Retry.MaxAttempts = 1
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+
+	transport := cfg.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &Client{cfg: cfg, http: &http.Client{Transport: transport}}
+}
+
+// Response is a successful (2xx or 3xx) response with its body read.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Get issues a GET request.
+func (c *Client) Get(ctx context.Context, path string, header http.Header) (*Response, error) {
+	return c.Do(ctx, http.MethodGet, path, nil, header)
+}
+
+// Post issues a POST request. POST is never retried.
+func (c *Client) Post(ctx context.Context, path string, body []byte, header http.Header) (*Response, error) {
+	return c.Do(ctx, http.MethodPost, path, body, header)
+}
+
+// Do sends the request, retrying idempotent methods on transport errors,
+// timeouts, 502, 503, and 504.
+func (c *Client) Do(ctx context.Context, method string, path string, body []byte, header http.Header) (*Response, error) {
+	attempts := 1
+	if idempotent(method) {
+		attempts = c.cfg.Retry.MaxAttempts
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if c.cfg.Breaker != nil && !c.cfg.Breaker.Allow() {
+			return nil, c.error(KindCircuitOpen, method, path, 0, "", lastErr)
+		}
+
+		resp, retryAfter, err := c.attempt(ctx, method, path, body, header)
+		if c.cfg.Breaker != nil {
+			c.cfg.Breaker.Record(err == nil || IsClientError(err))
+		}
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+
+		if attempt == attempts || !retryable(err) || ctx.Err() != nil {
+			break
+		}
+
+		delay := c.backoff(attempt)
+		if retryAfter > delay {
+			delay = retryAfter
+			if c.cfg.Retry.MaxDelay > 0 && delay > c.cfg.Retry.MaxDelay {
+				delay = c.cfg.Retry.MaxDelay
+			}
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, c.error(KindTimeout, method, path, 0, "", ctx.Err())
+		}
+	}
+	return nil, lastErr
+}
+
+func (c *Client) attempt(ctx context.Context, method string, path string, body []byte, header http.Header) (*Response, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, c.cfg.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, c.error(KindTransport, method, path, 0, "", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	propagate(ctx, req.Header)
+	c.sign(req, body)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if isTimeout(err) {
+			return nil, 0, c.error(KindTimeout, method, path, 0, "", err)
+		}
+		return nil, 0, c.error(KindTransport, method, path, 0, "", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if isTimeout(err) {
+			return nil, 0, c.error(KindTimeout, method, path, resp.StatusCode, "", err)
+		}
+		return nil, 0, c.error(KindTransport, method, path, resp.StatusCode, "", err)
+	}
+
+	switch {
+	case resp.StatusCode >= 500:
+		return nil, retryAfter(resp.Header), c.error(KindServer, method, path, resp.StatusCode, truncate(data), nil)
+	case resp.StatusCode >= 400:
+		return nil, 0, c.error(KindClient, method, path, resp.StatusCode, truncate(data), nil)
+	}
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: data}, 0, nil
+}
+
+func (c *Client) sign(req *http.Request, body []byte) {
+	if c.cfg.SigningKey == nil {
+		return
+	}
+	key := c.cfg.SigningKey()
+	if key == "" {
+		return
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set(SignatureTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign([]byte(key), req.Method, req.URL.Path, timestamp, body))
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.cfg.Retry.BaseDelay << (attempt - 1)
+	if c.cfg.Retry.MaxDelay > 0 && delay > c.cfg.Retry.MaxDelay {
+		delay = c.cfg.Retry.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	// Full jitter spreads retries from many callers
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+func (c *Client) error(kind Kind, method string, path string, status int, body string, err error) *Error {
+	return &Error{Kind: kind, Service: c.cfg.Service, Method: method, Path: path, StatusCode: status, Body: body, Err: err}
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func retryable(err error) bool {
+	var e *Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	switch e.Kind {
+	case KindTransport, KindTimeout:
+		return true
+	case KindServer:
+		return e.StatusCode == http.StatusBadGateway ||
+			e.StatusCode == http.StatusServiceUnavailable ||
+			e.StatusCode == http.StatusGatewayTimeout
+	}
+	return false
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func retryAfter(h http.Header) time.Duration {
+	seconds, err := strconv.Atoi(h.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func truncate(body []byte) string {
+	if len(body) > maxErrorBody {
+		body = body[:maxErrorBody]
+	}
+	return string(body)
+}
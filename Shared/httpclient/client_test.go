@@ -0,0 +1,364 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// target serves handler, counting the requests it got.
+type target struct {
+	*httptest.Server
+	hits atomic.Int32
+}
+
+func newTarget(t *testing.T, handler http.HandlerFunc) *target {
+	t.Helper()
+	tg := &target{}
+	tg.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tg.hits.Add(1)
+		handler(w, r)
+	}))
+	t.Cleanup(tg.Close)
+	return tg
+}
+
+// quickRetries retries three times without waiting long.
+var quickRetries = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func status(code int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+		w.Write([]byte(http.StatusText(code)))
+	}
+}
+
+func kind(err error) Kind {
+	var e *Error
+	if !errors.As(err, &e) {
+		return -1
+	}
+	return e.Kind
+}
+
+func TestSlowTargetTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	tg := newTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	t.Cleanup(func() { close(release) })
+	client := New(Config{Service: "slow", BaseURL: tg.URL, Timeout: 50 * time.Millisecond, Retry: quickRetries})
+
+	start := time.Now()
+	_, err := client.Get(context.Background(), "/slow", nil)
+	if !IsTimeout(err) {
+		t.Fatalf("Get = %v, want a timeout", err)
+	}
+	// Each attempt is bounded, and timeouts are retried
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("gave up after %s, want about three timeouts", elapsed)
+	}
+	if n := tg.hits.Load(); n != 3 {
+		t.Errorf("%d attempts, want 3", n)
+	}
+}
+
+func TestCallerDeadlineBoundsRetries(t *testing.T) {
+	tg := newTarget(t, status(http.StatusServiceUnavailable))
+	client := New(Config{BaseURL: tg.URL, Retry: RetryPolicy{MaxAttempts: 10, BaseDelay: time.Second}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.Get(ctx, "/unavailable", nil)
+	if !IsTimeout(err) {
+		t.Errorf("Get = %v, want a timeout once the caller's deadline passed", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("gave up after %s, want about the caller's deadline", elapsed)
+	}
+}
+
+func TestFlakyTargetIsRetried(t *testing.T) {
+	for _, code := range []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout} {
+		var calls atomic.Int32
+		tg := newTarget(t, func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) < 3 {
+				w.WriteHeader(code)
+				return
+			}
+			w.Write([]byte(`{"ok":true}`))
+		})
+		client := New(Config{BaseURL: tg.URL, Retry: quickRetries})
+
+		resp, err := client.Get(context.Background(), "/flaky", nil)
+		if err != nil || resp.StatusCode != http.StatusOK || string(resp.Body) != `{"ok":true}` {
+			t.Errorf("%d twice, then 200: Get = %+v, %v, want the 200", code, resp, err)
+		}
+	}
+}
+
+func TestOnlyIdempotentMethodsAreRetried(t *testing.T) {
+	tests := []struct {
+		method string
+		want   int32
+	}{
+		{http.MethodGet, 3},
+		{http.MethodPut, 3},
+		{http.MethodDelete, 3},
+		{http.MethodPost, 1},
+		{http.MethodPatch, 1},
+	}
+	for _, tt := range tests {
+		tg := newTarget(t, status(http.StatusServiceUnavailable))
+		client := New(Config{BaseURL: tg.URL, Retry: quickRetries})
+
+		_, err := client.Do(context.Background(), tt.method, "/unavailable", []byte("{}"), nil)
+		if !IsServerError(err) || StatusCode(err) != http.StatusServiceUnavailable {
+			t.Errorf("%s: error %v, want a 503 server error", tt.method, err)
+		}
+		if n := tg.hits.Load(); n != tt.want {
+			t.Errorf("%s: %d attempts, want %d", tt.method, n, tt.want)
+		}
+	}
+}
+
+func TestFailuresAreTyped(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		kind     Kind
+		status   int
+		attempts int32
+	}{
+		{"not found", status(http.StatusNotFound), KindClient, http.StatusNotFound, 1},
+		{"forbidden", status(http.StatusForbidden), KindClient, http.StatusForbidden, 1},
+		// Only 502, 503, and 504 say the target may recover
+		{"internal error", status(http.StatusInternalServerError), KindServer, http.StatusInternalServerError, 1},
+		{"unavailable", status(http.StatusServiceUnavailable), KindServer, http.StatusServiceUnavailable, 3},
+	}
+	for _, tt := range tests {
+		tg := newTarget(t, tt.handler)
+		client := New(Config{Service: "users", BaseURL: tg.URL, Retry: quickRetries})
+
+		_, err := client.Get(context.Background(), "/users/1", nil)
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Fatalf("%s: error %v, want an *Error", tt.name, err)
+		}
+		if e.Kind != tt.kind || e.StatusCode != tt.status || e.Service != "users" || e.Path != "/users/1" {
+			t.Errorf("%s: error %+v, want %s with status %d", tt.name, e, tt.kind, tt.status)
+		}
+		if e.Body != http.StatusText(tt.status) {
+			t.Errorf("%s: body %q, want the response's", tt.name, e.Body)
+		}
+		if n := tg.hits.Load(); n != tt.attempts {
+			t.Errorf("%s: %d attempts, want %d", tt.name, n, tt.attempts)
+		}
+	}
+}
+
+func TestUnreachableTargetIsTransport(t *testing.T) {
+	tg := newTarget(t, status(http.StatusOK))
+	url := tg.URL
+	tg.Close()
+	client := New(Config{BaseURL: url, Retry: quickRetries})
+
+	_, err := client.Get(context.Background(), "/gone", nil)
+	if kind(err) != KindTransport || IsTimeout(err) || StatusCode(err) != 0 {
+		t.Errorf("Get = %v, want a transport error", err)
+	}
+}
+
+func TestErrorBodyIsTruncated(t *testing.T) {
+	tg := newTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(strings.Repeat("x", 2*maxErrorBody)))
+	})
+	_, err := New(Config{BaseURL: tg.URL}).Get(context.Background(), "/", nil)
+
+	var e *Error
+	if !errors.As(err, &e) || len(e.Body) != maxErrorBody {
+		t.Errorf("kept %d bytes of the body, want %d", len(e.Body), maxErrorBody)
+	}
+}
+
+func TestRetryAfterIsBoundedByMaxDelay(t *testing.T) {
+	var calls atomic.Int32
+	tg := newTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	client := New(Config{BaseURL: tg.URL, Retry: RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: 20 * time.Millisecond}})
+
+	start := time.Now()
+	if _, err := client.Get(context.Background(), "/", nil); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond || elapsed > time.Second {
+		t.Errorf("retried after %s, want Retry-After capped at 20ms", elapsed)
+	}
+}
+
+func TestBreakerFailsFast(t *testing.T) {
+	var healthy atomic.Bool
+	tg := newTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	breaker := NewConsecutiveBreaker(2, 100*time.Millisecond)
+	client := New(Config{BaseURL: tg.URL, Breaker: breaker})
+
+	for range 2 {
+		if _, err := client.Get(context.Background(), "/", nil); !IsServerError(err) {
+			t.Fatalf("Get = %v, want a server error", err)
+		}
+	}
+	_, err := client.Get(context.Background(), "/", nil)
+	if kind(err) != KindCircuitOpen {
+		t.Errorf("Get once open = %v, want circuit open", err)
+	}
+	if n := tg.hits.Load(); n != 2 {
+		t.Errorf("%d requests reached the target, want 2; the open breaker sends none", n)
+	}
+
+	// After the cooldown one trial goes through, and closes the breaker
+	healthy.Store(true)
+	time.Sleep(150 * time.Millisecond)
+	for i := range 3 {
+		if _, err := client.Get(context.Background(), "/", nil); err != nil {
+			t.Errorf("call %d after the cooldown: %v", i+1, err)
+		}
+	}
+}
+
+func TestFailedTrialReopensTheBreaker(t *testing.T) {
+	breaker := NewConsecutiveBreaker(1, 50*time.Millisecond)
+	breaker.Record(false)
+	if breaker.Allow() {
+		t.Fatal("allowed while open")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !breaker.Allow() {
+		t.Fatal("no trial after the cooldown")
+	}
+	if breaker.Allow() {
+		t.Error("a second call allowed while the trial runs")
+	}
+	breaker.Record(false)
+	if breaker.Allow() {
+		t.Error("allowed right after a failed trial")
+	}
+}
+
+func TestClientErrorsKeepTheBreakerClosed(t *testing.T) {
+	tg := newTarget(t, status(http.StatusNotFound))
+	client := New(Config{BaseURL: tg.URL, Breaker: NewConsecutiveBreaker(1, time.Minute)})
+
+	for range 3 {
+		if _, err := client.Get(context.Background(), "/missing", nil); !IsClientError(err) {
+			t.Errorf("Get = %v, want the 404; the target is healthy", err)
+		}
+	}
+}
+
+func TestRequestContextIsPropagated(t *testing.T) {
+	got := make(chan http.Header, 2)
+	tg := newTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Clone()
+	})
+	client := New(Config{BaseURL: tg.URL})
+
+	incoming := httptest.NewRequest(http.MethodGet, "/document/1", nil)
+	incoming.Header.Set(RequestIDHeader, "req-1")
+	incoming.Header.Set(TraceParentHeader, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	incoming.Header.Set(TraceStateHeader, "vendor=1")
+	ctx := ContextFromRequest(context.Background(), incoming)
+
+	if _, err := client.Get(ctx, "/", nil); err != nil {
+		t.Fatal(err)
+	}
+	header := <-got
+	if header.Get(RequestIDHeader) != "req-1" || header.Get(TraceParentHeader) != incoming.Header.Get(TraceParentHeader) || header.Get(TraceStateHeader) != "vendor=1" {
+		t.Errorf("sent %v, want the incoming request ID and trace context", header)
+	}
+	if RequestIDFromContext(ctx) != "req-1" {
+		t.Errorf("RequestIDFromContext = %q, want req-1", RequestIDFromContext(ctx))
+	}
+
+	// Headers the caller set win
+	if _, err := client.Get(ctx, "/", http.Header{RequestIDHeader: {"req-2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if header := <-got; header.Get(RequestIDHeader) != "req-2" {
+		t.Errorf("sent request ID %q, want the caller's req-2", header.Get(RequestIDHeader))
+	}
+}
+
+func TestRequestsAreSigned(t *testing.T) {
+	key := "internal-key"
+	verified := make(chan error, 1)
+	tg := newTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		verified <- Verify(r, []byte(key), body, time.Minute)
+	})
+
+	client := New(Config{BaseURL: tg.URL, SigningKey: func() string { return key }})
+	if _, err := client.Post(context.Background(), "/internal/users", []byte(`{"id":"1"}`), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-verified; err != nil {
+		t.Errorf("Verify = %v, want the signature accepted", err)
+	}
+
+	unsigned := New(Config{BaseURL: tg.URL, SigningKey: func() string { return "" }})
+	if _, err := unsigned.Post(context.Background(), "/internal/users", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-verified; !errors.Is(err, ErrMissingSignature) {
+		t.Errorf("Verify = %v without a key, want ErrMissingSignature", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	key := []byte("internal-key")
+	body := []byte(`{"id":"1"}`)
+	signed := func(timestamp int64, signedBody []byte) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/internal/users", nil)
+		r.Header.Set(SignatureTimestampHeader, strconv.FormatInt(timestamp, 10))
+		r.Header.Set(SignatureHeader, Sign(key, http.MethodPost, "/internal/users", timestamp, signedBody))
+		return r
+	}
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		want    error
+	}{
+		{"valid", signed(now, body), nil},
+		{"other body", signed(now, []byte(`{"id":"2"}`)), ErrInvalidSignature},
+		{"stale", signed(now-120, body), ErrStaleSignature},
+		{"from the future", signed(now+120, body), ErrStaleSignature},
+		{"unsigned", httptest.NewRequest(http.MethodPost, "/internal/users", nil), ErrMissingSignature},
+	}
+	for _, tt := range tests {
+		if err := Verify(tt.request, key, body, time.Minute); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Verify = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
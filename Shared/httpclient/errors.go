@@ -0,0 +1,100 @@
+package httpclient
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kind classifies a failed call.
+type Kind int
+
+const (
+	// KindTransport covers connection refused, DNS failures, resets.
+	KindTransport Kind = iota
+	// KindTimeout is a per-attempt timeout or an expired caller deadline.
+	KindTimeout
+	// KindClient is a 4xx response.
+	KindClient
+	// KindServer is a 5xx response.
+	KindServer
+	// KindCircuitOpen means the breaker rejected the call without sending it.
+	KindCircuitOpen
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindTransport:
+		return "transport"
+	case KindTimeout:
+		return "timeout"
+	case KindClient:
+		return "client error"
+	case KindServer:
+		return "server error"
+	case KindCircuitOpen:
+		return "circuit open"
+	}
+	return "unknown"
+}
+
+// Error is returned for every failed call. Responses with a 4xx or 5xx status
+// carry the status code and the (truncated) body.
+type Error struct {
+	Kind       Kind
+	Service    string
+	Method     string
+	Path       string
+	StatusCode int
+	Body       string
+	Err        error
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s %s %s: %s", e.Service, e.Method, e.Path, e.Kind)
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" (status %d)", e.StatusCode)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func kindOf(err error) (Kind, bool) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind, true
+	}
+	return 0, false
+}
+
+// IsTimeout reports whether err is a timeout.
+func IsTimeout(err error) bool {
+	k, ok := kindOf(err)
+	return ok && k == KindTimeout
+}
+
+// IsClientError reports whether the target answered with a 4xx.
+func IsClientError(err error) bool {
+	k, ok := kindOf(err)
+	return ok && k == KindClient
+}
+
+// IsServerError reports whether the target answered with a 5xx.
+func IsServerError(err error) bool {
+	k, ok := kindOf(err)
+	return ok && k == KindServer
+}
+
+// StatusCode returns the response status carried by err, or 0.
+func StatusCode(err error) int {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
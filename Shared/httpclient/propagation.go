@@ -0,0 +1,59 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+)
+
+// Headers propagated from an incoming request to every outgoing call.
+const (
+	RequestIDHeader   = "X-Request-ID"
+	TraceParentHeader = "traceparent"
+	TraceStateHeader  = "tracestate"
+)
+
+type contextKey struct{ name string }
+
+var (
+	requestIDKey   = contextKey{"request-id"}
+	traceParentKey = contextKey{"traceparent"}
+	traceStateKey  = contextKey{"tracestate"}
+)
+
+// WithRequestID returns a context carrying the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestIDFromContext returns the request ID, or "".
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// ContextFromRequest copies the request ID and W3C trace context headers of an
+// incoming request into ctx so calls made while serving it carry them along.
+func ContextFromRequest(ctx context.Context, r *http.Request) context.Context {
+	if id := r.Header.Get(RequestIDHeader); id != "" {
+		ctx = context.WithValue(ctx, requestIDKey, id)
+	}
+	if tp := r.Header.Get(TraceParentHeader); tp != "" {
+		ctx = context.WithValue(ctx, traceParentKey, tp)
+	}
+	if ts := r.Header.Get(TraceStateHeader); ts != "" {
+		ctx = context.WithValue(ctx, traceStateKey, ts)
+	}
+	return ctx
+}
+
+func propagate(ctx context.Context, h http.Header) {
+	for key, header := range map[contextKey]string{
+		requestIDKey:   RequestIDHeader,
+		traceParentKey: TraceParentHeader,
+		traceStateKey:  TraceStateHeader,
+	} {
+		if v, ok := ctx.Value(key).(string); ok && v != "" && h.Get(header) == "" {
+			h.Set(header, v)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package httpclient
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers of the internal request signature.
+const (
+	SignatureHeader          = "X-Internal-Signature"
+	SignatureTimestampHeader = "X-Internal-Timestamp"
+)
+
+var (
+	ErrMissingSignature = errors.New("missing internal signature")
+	ErrInvalidSignature = errors.New("invalid internal signature")
+	ErrStaleSignature   = errors.New("internal signature timestamp out of range")
+)
+
+// Sign computes the internal signature over method, path, timestamp, and body.
+func Sign(key []byte, method string, path string, timestamp int64, body []byte) string {
+	bodySum := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(method + "\n" + path + "\n" + strconv.FormatInt(timestamp, 10) + "\n"))
+	mac.Write([]byte(hex.EncodeToString(bodySum[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the internal signature of an incoming request whose body has
+// already been read. maxSkew bounds how old (or early) the timestamp may be.
+func Verify(r *http.Request, key []byte, body []byte, maxSkew time.Duration) error {
+	signature := r.Header.Get(SignatureHeader)
+	ts := r.Header.Get(SignatureTimestampHeader)
+	if signature == "" || ts == "" {
+		return ErrMissingSignature
+	}
+
+	timestamp, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	skew := time.Since(time.Unix(timestamp, 0))
+	if skew > maxSkew || skew < -maxSkew {
+		return ErrStaleSignature
+	}
+
+	expected := Sign(key, r.Method, r.URL.Path, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// Package jwks publishes and consumes the RSA public keys AuthService signs
+// access tokens with. AuthService serves the set at JWKSPath; other services
+// use a Verifier to check tokens locally instead of calling AuthService.
+package jwks
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// JWKSPath is where AuthService serves the key set.
+const JWKSPath = "/auth/.well-known/jwks.json"
+
+// JWK is an RSA public key in JSON Web Key form (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// Set is a JSON Web Key Set.
+type Set struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK describes pub as an RS256 signing key named kid.
+func NewJWK(kid string, pub *rsa.PublicKey) JWK {
+	n, e := components(pub)
+	return JWK{Kty: "RSA", Use: "sig", Alg: "RS256", Kid: kid, N: n, E: e}
+}
+
+// PublicKey decodes the key.
+func (k JWK) PublicKey() (*rsa.PublicKey, error) {
+	if k.Kty != "RSA" {
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+
+	exponent := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid RSA key")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+// Thumbprint returns the RFC 7638 thumbprint of pub, which AuthService uses as
+// the key's kid so it never has to be configured.
+func Thumbprint(pub *rsa.PublicKey) string {
+	n, e := components(pub)
+	// Members in lexicographic order, no whitespace, as RFC 7638 requires
+	canonical, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{E: e, Kty: "RSA", N: n})
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func components(pub *rsa.PublicKey) (string, string) {
+	n := base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+	e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	return n, e
+}
@@ -0,0 +1,143 @@
+package jwks
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"shared/authz"
+	"shared/httpclient"
+	"shared/tenant"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// minRefreshInterval keeps tokens with made-up kids from turning into a
+	// stream of requests to AuthService.
+	minRefreshInterval = 30 * time.Second
+	// maxKeyAge bounds how long a removed key keeps being accepted.
+	maxKeyAge = time.Hour
+)
+
+// ErrUnknownKey is returned for tokens whose kid is not in the key set, even
+// after refetching it.
+var ErrUnknownKey = errors.New("token signed with an unknown key")
+
+// ErrKeysUnavailable is returned when the key set is needed but cannot be fetched.
+var ErrKeysUnavailable = errors.New("key set unavailable")
+
+// Claims are the claims of an AuthService access token.
+type Claims struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	TenantID string `json:"tenant_id,omitempty"`
+	Role     string `json:"role,omitempty"`
+	Purpose  string `json:"purpose,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Verifier checks RS256 access tokens against the key set fetched from
+// AuthService. Keys are cached and refetched when a token names an unknown
+// kid or the cache is older than an hour.
+//
+// Unlike /auth/authenticate, a Verifier does not consult the revocation list,
+// so a logged-out token stays valid here until it expires.
+type Verifier struct {
+	client *httpclient.Client
+	leeway time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// NewVerifier creates a verifier that fetches the key set through client, which
+// must point at AuthService. leeway is the clock skew tolerated on exp, nbf, and iat.
+func NewVerifier(client *httpclient.Client, leeway time.Duration) *Verifier {
+	return &Verifier{client: client, leeway: leeway}
+}
+
+// Verify validates token and returns its claims.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(v.leeway),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Single-use tokens such as email verification links are not access tokens
+	if claims.Purpose != "" || claims.UserID == "" {
+		return nil, errors.New("invalid token")
+	}
+
+	claims.TenantID = tenant.Normalize(claims.TenantID)
+	claims.Role = authz.NormalizeRole(claims.Role)
+	return claims, nil
+}
+
+func (v *Verifier) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	key, ok := v.keys[kid]
+	stale := time.Since(v.fetchedAt) > maxKeyAge
+	if ok && !stale {
+		return key, nil
+	}
+
+	if stale || time.Since(v.fetchedAt) > minRefreshInterval {
+		if err := v.refresh(ctx); err != nil {
+			// Keep serving the cached keys while AuthService is unreachable
+			if ok {
+				return key, nil
+			}
+			return nil, err
+		}
+		key, ok = v.keys[kid]
+	}
+
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// refresh refetches the key set. Callers hold v.mu.
+func (v *Verifier) refresh(ctx context.Context) error {
+	resp, err := v.client.Get(ctx, JWKSPath, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrKeysUnavailable, err)
+	}
+
+	var set Set
+	if err := json.Unmarshal(resp.Body, &set); err != nil {
+		return fmt.Errorf("%w: decoding: %v", ErrKeysUnavailable, err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		pub, err := k.PublicKey()
+		if err != nil {
+			return fmt.Errorf("%w: key %q: %v", ErrKeysUnavailable, k.Kid, err)
+		}
+		keys[k.Kid] = pub
+	}
+
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	return nil
+}
@@ -0,0 +1,232 @@
+// Package messages defines the frames clients send to UpdatesService over
+// the websocket, and the update messages UpdatesService produces from them to
+// Kafka for the updates consumer. Both services use these definitions, so the
+// producer and the consumer cannot drift apart.
+//
+// A frame is an envelope naming its type and protocol version around a
+// payload:
+//
+//	{"type": "edit", "v": 1, "payload": {"action": "update", ...}}
+//
+// Frames that do not parse, or carry an unknown type, are rejected explicitly
+// rather than forwarded.
+//
+// A session may open with a hello frame offering the versions the client
+// speaks, which the server answers with the one the session uses:
+//
+//	{"type": "hello", "versions": [1]}
+//	{"type": "hello", "v": 1, "versions": [1]}
+//
+// Sessions that open without one use Version.
+package messages
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"shared/content"
+	"slices"
+	"time"
+)
+
+// Version is the version of the envelope format.
+const Version = 1
+
+// SupportedVersions are the versions of the envelope format the services
+// speak, oldest first.
+var SupportedVersions = []int{Version}
+
+// Frame types. The string values are part of the wire format.
+const (
+	// TypeEdit frames change the document: their payload is a create,
+	// update, delete, add_slide, or remove_slide action
+	TypeEdit = "edit"
+	// TypeCursor frames carry the sender's pointer position and selection
+	TypeCursor = "cursor"
+	// TypePresence frames carry a cursormove, select, or deselect action,
+	// which the room sees but which is not persisted
+	TypePresence = "presence"
+	// TypeHello frames negotiate the version of a session, see Hello
+	TypeHello = "hello"
+)
+
+// MaxPresenceBytes bounds the payload of cursor and presence frames, which
+// carry no content.
+const MaxPresenceBytes = 16 << 10
+
+var (
+	// ErrInvalid is returned for frames that are not a well-formed envelope,
+	// or whose payload does not suit its type.
+	ErrInvalid = errors.New("invalid frame")
+	// ErrUnknownType is returned for envelopes of a type, or payloads of an
+	// action, the service does not know.
+	ErrUnknownType = errors.New("unknown frame type")
+)
+
+// Envelope is a frame sent by a client.
+type Envelope struct {
+	Type    string          `json:"type"`
+	V       int             `json:"v"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Hello is the frame a session opens with. The client offers the versions it
+// speaks in Versions; the server answers with the one the session uses in V,
+// and the versions it speaks.
+type Hello struct {
+	Type     string `json:"type"` // always "hello"
+	V        int    `json:"v,omitempty"`
+	Versions []int  `json:"versions"`
+}
+
+// ParseHello decodes frame if it is a hello, and reports whether it was.
+func ParseHello(frame []byte) (Hello, bool) {
+	var hello Hello
+	if err := json.Unmarshal(frame, &hello); err != nil || hello.Type != TypeHello {
+		return Hello{}, false
+	}
+	return hello, true
+}
+
+// Negotiate returns the newest of the offered versions the services speak,
+// or false when they speak none of them.
+func Negotiate(offered []int) (int, bool) {
+	chosen := 0
+	for _, v := range offered {
+		if v > chosen && slices.Contains(SupportedVersions, v) {
+			chosen = v
+		}
+	}
+	return chosen, chosen != 0
+}
+
+// ActionType returns the type of frame the action is sent in, or "" for
+// actions the service does not know.
+func ActionType(action string) string {
+	switch action {
+	case "create", "update", "delete", "add_slide", "remove_slide":
+		return TypeEdit
+	case "cursormove", "select", "deselect":
+		return TypePresence
+	}
+	return ""
+}
+
+// Parse decodes and checks a frame a client sent. Edit payloads may be up to
+// maxEditBytes long, cursor and presence payloads up to MaxPresenceBytes;
+// longer ones are refused with a *content.TooLargeError.
+//
+// Frames of clients predating the envelope, a bare action or cursor frame,
+// are parsed as though wrapped in one.
+func Parse(frame []byte, maxEditBytes int64) (Envelope, error) {
+	var raw struct {
+		Type    string          `json:"type"`
+		V       *int            `json:"v"`
+		Payload json.RawMessage `json:"payload"`
+		Action  *string         `json:"action"`
+	}
+	if err := json.Unmarshal(frame, &raw); err != nil {
+		return Envelope{}, fmt.Errorf("%w: frame must be a JSON object with a string type", ErrInvalid)
+	}
+
+	env := Envelope{Type: raw.Type, V: Version, Payload: raw.Payload}
+	switch {
+	case raw.V != nil && *raw.V != Version:
+		return Envelope{}, fmt.Errorf("%w: unsupported version %d", ErrInvalid, *raw.V)
+	case raw.V == nil && raw.Type == TypeCursor:
+		env.Payload = frame
+	case raw.V == nil && raw.Action != nil:
+		env.Type = ActionType(*raw.Action)
+		if env.Type == "" {
+			return Envelope{}, fmt.Errorf("%w: action %q", ErrUnknownType, *raw.Action)
+		}
+		env.Payload = frame
+	case raw.V == nil:
+		return Envelope{}, fmt.Errorf("%w: missing version", ErrInvalid)
+	}
+
+	limit := int64(MaxPresenceBytes)
+	switch env.Type {
+	case TypeEdit:
+		limit = maxEditBytes
+	case TypeCursor, TypePresence:
+	case "":
+		return Envelope{}, fmt.Errorf("%w: missing type", ErrInvalid)
+	default:
+		return Envelope{}, fmt.Errorf("%w: %q", ErrUnknownType, env.Type)
+	}
+	if err := content.Check(int64(len(env.Payload)), limit); err != nil {
+		return Envelope{}, err
+	}
+	if err := env.validatePayload(); err != nil {
+		return Envelope{}, err
+	}
+	return env, nil
+}
+
+// validatePayload checks that the payload is an object, and that the action
+// of an edit or presence payload belongs to its type.
+func (e Envelope) validatePayload() error {
+	var payload struct {
+		Action *string `json:"action"`
+	}
+	if len(e.Payload) == 0 || e.Payload[0] != '{' {
+		return fmt.Errorf("%w: payload must be an object", ErrInvalid)
+	}
+	if err := json.Unmarshal(e.Payload, &payload); err != nil {
+		return fmt.Errorf("%w: payload must be an object with a string action", ErrInvalid)
+	}
+	if e.Type == TypeCursor {
+		return nil
+	}
+
+	switch {
+	case payload.Action == nil:
+		return fmt.Errorf("%w: %s payload must name an action", ErrInvalid, e.Type)
+	case ActionType(*payload.Action) == "":
+		return fmt.Errorf("%w: action %q", ErrUnknownType, *payload.Action)
+	case ActionType(*payload.Action) != e.Type:
+		return fmt.Errorf("%w: %s frames cannot carry action %q", ErrInvalid, e.Type, *payload.Action)
+	}
+	return nil
+}
+
+// Message is an update as relayed to the room and produced to Kafka. Its
+// Body is the payload of the frame the client sent; the rest is filled in
+// by UpdatesService.
+type Message struct {
+	DocumentID string `json:"documentId"`
+	UserID     string `json:"userId"`
+	Username   string `json:"username"`
+	TenantID   string `json:"tenantId,omitempty"`
+	Type       int    `json:"type"`
+	Body       string `json:"body"`
+	// SentAt is when the update was received from the client; the consumer
+	// compares it with the time a document was locked.
+	SentAt time.Time `json:"sentAt,omitzero"`
+	// Seq is the update's ID in its document's update stream, which clients
+	// resume from after reconnecting; empty for updates not kept there.
+	Seq string `json:"seq,omitempty"`
+}
+
+// Key is the Kafka key the update is produced with: its document's ID, so
+// that all updates of a document land on one partition, which the consumer
+// reads them from in the order they were produced.
+func (m Message) Key() []byte {
+	return []byte(m.DocumentID)
+}
+
+// Validate reports why an update message must not be produced to Kafka.
+func (m Message) Validate() error {
+	switch {
+	case m.DocumentID == "":
+		return errors.New("message must name a document")
+	case m.UserID == "":
+		return errors.New("message must name a user")
+	case m.SentAt.IsZero():
+		return errors.New("message must be stamped with when it was sent")
+	case !json.Valid([]byte(m.Body)):
+		return errors.New("message body must be JSON")
+	}
+	return nil
+}
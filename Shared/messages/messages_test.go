@@ -0,0 +1,44 @@
+package messages
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNegotiatePicksNewestSharedVersion(t *testing.T) {
+	tests := []struct {
+		offered []int
+		want    int
+		ok      bool
+	}{
+		{[]int{Version}, Version, true},
+		{[]int{Version, Version + 1}, Version, true},
+		{[]int{Version + 1, Version}, Version, true},
+		{[]int{Version + 1}, 0, false},
+		{nil, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := Negotiate(tt.offered)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Negotiate(%v) = %d, %v, want %d, %v", tt.offered, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseHello(t *testing.T) {
+	hello, ok := ParseHello([]byte(`{"type":"hello","versions":[1,2]}`))
+	if !ok || !slices.Equal(hello.Versions, []int{1, 2}) {
+		t.Fatalf("ParseHello = %+v, %v", hello, ok)
+	}
+
+	for _, frame := range []string{
+		`{"type":"edit","v":1,"payload":{"action":"add_slide","slideId":"s-1"}}`,
+		`{"action":"add_slide","slideId":"s-1"}`,
+		`{"type":"hello","versions":"1"}`,
+		`not json`,
+	} {
+		if _, ok := ParseHello([]byte(frame)); ok {
+			t.Errorf("%s parsed as a hello", frame)
+		}
+	}
+}
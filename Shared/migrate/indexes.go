@@ -0,0 +1,117 @@
+package migrate
+
+import (
+	"time"
+
+	"shared/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Index is an index owned by the migrate tool. Every index has an explicit
+// name so Check can tell whether it exists without comparing key documents.
+type Index struct {
+	Collection string
+	Model      mongo.IndexModel
+}
+
+// Name returns the index name.
+func (i Index) Name() string {
+	return *i.Model.Options.Name
+}
+
+func index(collection string, name string, keys bson.D, opts *options.IndexOptions) Index {
+	if opts == nil {
+		opts = options.Index()
+	}
+	return Index{
+		Collection: collection,
+		Model:      mongo.IndexModel{Keys: keys, Options: opts.SetName(name)},
+	}
+}
+
+// outboxSentRetention is how long relayed outbox rows are kept for debugging.
+const outboxSentRetention = 7 * 24 * time.Hour
+
+// Indexes is the full set of indexes across every collection, created by
+// EnsureIndexes. Services must not create indexes of their own; add them here.
+var Indexes = []Index{
+	// Login looks users up by email, which must be unique
+	index(model.UserCollection, "email_unique", bson.D{{Key: "email", Value: 1}},
+		options.Index().SetUnique(true)),
+	// Usernames are display identity, unique regardless of case
+	index(model.UserCollection, "nameKey_unique", bson.D{{Key: "nameKey", Value: 1}},
+		options.Index().SetUnique(true)),
+
+	// Owned-document listing
+	index(model.DocumentCollection, "tenant_owner", bson.D{{Key: "tenantId", Value: 1}, {Key: "ownerId", Value: 1}}, nil),
+	// Folder contents, and checking a folder is empty before deleting it
+	index(model.DocumentCollection, "tenant_owner_folder", bson.D{{Key: "tenantId", Value: 1}, {Key: "ownerId", Value: 1}, {Key: "folderId", Value: 1}}, nil),
+	// Tag filtering; multikey over the tags array
+	index(model.DocumentCollection, "tenant_tags", bson.D{{Key: "tenantId", Value: 1}, {Key: "tags", Value: 1}}, nil),
+	// Title search and ordering within a tenant
+	index(model.DocumentCollection, "tenant_title", bson.D{{Key: "tenantId", Value: 1}, {Key: "title", Value: 1}}, nil),
+	// Trash purge; only trashed documents carry deletedAt
+	index(model.DocumentCollection, "deletedAt", bson.D{{Key: "deletedAt", Value: 1}},
+		options.Index().SetSparse(true)),
+
+	// Shared-with-me listing and per-document share lookups
+	index(model.SharedDocRecordCollection, "tenant_user", bson.D{{Key: "tenantId", Value: 1}, {Key: "userId", Value: 1}}, nil),
+	index(model.SharedDocRecordCollection, "document", bson.D{{Key: "documentId", Value: 1}}, nil),
+	// One share per user and document; sharing again updates the access type
+	index(model.SharedDocRecordCollection, "document_user_unique", bson.D{{Key: "documentId", Value: 1}, {Key: "userId", Value: 1}},
+		options.Index().SetUnique(true)),
+
+	// A user's folders, and the subfolders of a folder
+	index(model.FolderCollection, "tenant_owner_parent", bson.D{{Key: "tenantId", Value: 1}, {Key: "ownerId", Value: 1}, {Key: "parentId", Value: 1}}, nil),
+
+	// One favorite per user and document, and a user's favorites
+	index(model.FavoriteCollection, "user_document_unique", bson.D{{Key: "userId", Value: 1}, {Key: "documentId", Value: 1}},
+		options.Index().SetUnique(true)),
+	// Removing the favorites of a deleted document
+	index(model.FavoriteCollection, "document", bson.D{{Key: "documentId", Value: 1}}, nil),
+
+	// One activity row per user and document; a user's recently opened documents
+	index(model.ActivityCollection, "user_document_unique", bson.D{{Key: "userId", Value: 1}, {Key: "documentId", Value: 1}},
+		options.Index().SetUnique(true)),
+	index(model.ActivityCollection, "tenant_user_lastOpenedAt", bson.D{{Key: "tenantId", Value: 1}, {Key: "userId", Value: 1}, {Key: "lastOpenedAt", Value: -1}}, nil),
+
+	// One pending access request per user and document; asking again updates it
+	index(model.AccessRequestCollection, "document_user_pending_unique", bson.D{{Key: "documentId", Value: 1}, {Key: "userId", Value: 1}},
+		options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"status": "pending"})),
+	// The pending requests of a document, oldest first
+	index(model.AccessRequestCollection, "document_status_createdAt", bson.D{{Key: "documentId", Value: 1}, {Key: "status", Value: 1}, {Key: "createdAt", Value: 1}}, nil),
+
+	// Operation history of a document in apply order
+	index(model.OperationCollection, "document_appliedAt", bson.D{{Key: "documentId", Value: 1}, {Key: "appliedAt", Value: 1}}, nil),
+
+	// Latest snapshot of a document; one snapshot per version
+	index(model.SnapshotCollection, "document_version", bson.D{{Key: "documentId", Value: 1}, {Key: "version", Value: -1}},
+		options.Index().SetUnique(true)),
+
+	// Relay polling and cleanup of relayed rows
+	index(model.OutboxCollection, "status_createdAt", bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}, nil),
+	index(model.OutboxCollection, "sentAt_ttl", bson.D{{Key: "sentAt", Value: 1}},
+		options.Index().SetExpireAfterSeconds(int32(outboxSentRetention.Seconds()))),
+	index(model.OutboxParkedCollection, "aggregateId", bson.D{{Key: "aggregateId", Value: 1}}, nil),
+
+	// Refresh tokens are looked up by hash, revoked per family, and expire on their own
+	index(model.RefreshTokenCollection, "tokenHash_unique", bson.D{{Key: "tokenHash", Value: 1}},
+		options.Index().SetUnique(true)),
+	index(model.RefreshTokenCollection, "familyId", bson.D{{Key: "familyId", Value: 1}}, nil),
+	index(model.RefreshTokenCollection, "userId", bson.D{{Key: "userId", Value: 1}}, nil),
+	index(model.RefreshTokenCollection, "expiresAt_ttl", bson.D{{Key: "expiresAt", Value: 1}},
+		options.Index().SetExpireAfterSeconds(0)),
+
+	// A user's sessions, most recently used first; idle sessions expire
+	index(model.SessionCollection, "userId_lastUsedAt", bson.D{{Key: "userId", Value: 1}, {Key: "lastUsedAt", Value: -1}}, nil),
+	index(model.SessionCollection, "expiresAt_ttl", bson.D{{Key: "expiresAt", Value: 1}},
+		options.Index().SetExpireAfterSeconds(0)),
+
+	// A user's recent login events; each event carries its own expiry
+	index(model.LoginEventCollection, "userId_createdAt", bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}}, nil),
+	index(model.LoginEventCollection, "expiresAt_ttl", bson.D{{Key: "expiresAt", Value: 1}},
+		options.Index().SetExpireAfterSeconds(0)),
+}
@@ -0,0 +1,289 @@
+// Package migrate owns the index definitions and data migrations of every
+// collection. The cmd/migrate tool applies them; services call EnsureIndexes
+// at startup, creating the indexes they lack and refusing to run against data
+// that is behind.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"shared/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrSchemaBehind is returned by Check when migrations or indexes are missing.
+	ErrSchemaBehind = errors.New("database schema is behind")
+
+	// ErrLocked is returned by Apply when another run holds the migration lock.
+	ErrLocked = errors.New("another migration run is in progress")
+)
+
+const (
+	lockID = "lock"
+	// lockTTL lets a run take over the lock left behind by a crashed one.
+	lockTTL = 10 * time.Minute
+)
+
+// record is a row of the migrations collection.
+type record struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"appliedAt"`
+}
+
+// Status describes what Apply would do.
+type Status struct {
+	Applied        []int
+	Pending        []Migration
+	MissingIndexes []Index
+}
+
+// UpToDate reports whether nothing is left to apply.
+func (s Status) UpToDate() bool {
+	return len(s.Pending) == 0 && len(s.MissingIndexes) == 0
+}
+
+func (s Status) String() string {
+	if s.UpToDate() {
+		return "up to date"
+	}
+	var parts []string
+	for _, m := range s.Pending {
+		parts = append(parts, fmt.Sprintf("migration %d (%s)", m.Version, m.Name))
+	}
+	for _, i := range s.MissingIndexes {
+		parts = append(parts, fmt.Sprintf("index %s.%s", i.Collection, i.Name()))
+	}
+	return "pending: " + strings.Join(parts, ", ")
+}
+
+// Migrator applies Migrations and Indexes to one database.
+type Migrator struct {
+	db         *mongo.Database
+	migrations []Migration
+	indexes    []Index
+}
+
+// New returns a migrator for the registered migrations and indexes.
+func New(db *mongo.Database) *Migrator {
+	migrations := append([]Migration(nil), Migrations...)
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return &Migrator{db: db, migrations: migrations, indexes: Indexes}
+}
+
+func (m *Migrator) records() *mongo.Collection {
+	return m.db.Collection(model.MigrationCollection)
+}
+
+// Status compares the database with the registered migrations and indexes.
+func (m *Migrator) Status(ctx context.Context) (Status, error) {
+	var status Status
+
+	cursor, err := m.records().Find(ctx, bson.M{"_id": bson.M{"$type": "int"}})
+	if err != nil {
+		return status, fmt.Errorf("reading applied migrations: %w", err)
+	}
+	var applied []record
+	if err := cursor.All(ctx, &applied); err != nil {
+		return status, fmt.Errorf("decoding applied migrations: %w", err)
+	}
+
+	done := make(map[int]bool, len(applied))
+	for _, r := range applied {
+		done[r.Version] = true
+		status.Applied = append(status.Applied, r.Version)
+	}
+	sort.Ints(status.Applied)
+
+	for _, migration := range m.migrations {
+		if !done[migration.Version] {
+			status.Pending = append(status.Pending, migration)
+		}
+	}
+
+	existing := map[string]map[string]bool{}
+	for _, idx := range m.indexes {
+		names, ok := existing[idx.Collection]
+		if !ok {
+			if names, err = m.indexNames(ctx, idx.Collection); err != nil {
+				return status, err
+			}
+			existing[idx.Collection] = names
+		}
+		if !names[idx.Name()] {
+			status.MissingIndexes = append(status.MissingIndexes, idx)
+		}
+	}
+
+	return status, nil
+}
+
+func (m *Migrator) indexNames(ctx context.Context, collection string) (map[string]bool, error) {
+	names := map[string]bool{}
+
+	cursor, err := m.db.Collection(collection).Indexes().List(ctx)
+	if err != nil {
+		// Listing indexes of a collection that does not exist yet fails with NamespaceNotFound
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.Code == 26 {
+			return names, nil
+		}
+		return nil, fmt.Errorf("listing indexes of %s: %w", collection, err)
+	}
+
+	var specs []bson.M
+	if err := cursor.All(ctx, &specs); err != nil {
+		return nil, fmt.Errorf("decoding indexes of %s: %w", collection, err)
+	}
+	for _, spec := range specs {
+		if name, ok := spec["name"].(string); ok {
+			names[name] = true
+		}
+	}
+	return names, nil
+}
+
+// Check returns ErrSchemaBehind when anything is left to apply. It logs every
+// index it found, so operators can confirm the state after a deploy.
+func (m *Migrator) Check(ctx context.Context) error {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	missing := make(map[string]bool, len(status.MissingIndexes))
+	for _, idx := range status.MissingIndexes {
+		missing[idx.Collection+"."+idx.Name()] = true
+	}
+	for _, idx := range m.indexes {
+		if missing[idx.Collection+"."+idx.Name()] {
+			log.Printf("[migrate] Missing index %s.%s", idx.Collection, idx.Name())
+		} else {
+			log.Printf("[migrate] Verified index %s.%s", idx.Collection, idx.Name())
+		}
+	}
+
+	if !status.UpToDate() {
+		return fmt.Errorf("%w: %s", ErrSchemaBehind, status)
+	}
+	return nil
+}
+
+// Apply runs pending migrations in order, then creates missing indexes.
+// Migrations run before indexes so data fixes (e.g. email normalization)
+// land before the unique index that depends on them. With dryRun set it only
+// reports what would be done.
+func (m *Migrator) Apply(ctx context.Context, dryRun bool) (Status, error) {
+	if dryRun {
+		return m.Status(ctx)
+	}
+
+	if err := m.lock(ctx); err != nil {
+		return Status{}, err
+	}
+	defer m.unlock()
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		return status, err
+	}
+
+	for _, migration := range status.Pending {
+		log.Printf("[migrate] Applying migration %d (%s)", migration.Version, migration.Name)
+		if err := migration.Up(ctx, m.db); err != nil {
+			return status, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		_, err := m.records().InsertOne(ctx, record{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			AppliedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return status, fmt.Errorf("recording migration %d: %w", migration.Version, err)
+		}
+	}
+
+	for _, idx := range status.MissingIndexes {
+		if err := m.createIndex(ctx, idx); err != nil {
+			return status, err
+		}
+	}
+
+	return status, nil
+}
+
+// EnsureIndexes creates the registered indexes that are missing, logging each
+// one it creates or verifies, and returns those it created; run again it
+// creates nothing. Data migrations are left to the migrate tool: while any is
+// pending it returns ErrSchemaBehind without creating anything, since unique
+// indexes fail on the duplicates they fix.
+func (m *Migrator) EnsureIndexes(ctx context.Context) ([]Index, error) {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(status.Pending) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrSchemaBehind, status)
+	}
+
+	missing := make(map[string]bool, len(status.MissingIndexes))
+	for _, idx := range status.MissingIndexes {
+		missing[idx.Collection+"."+idx.Name()] = true
+	}
+	var created []Index
+	for _, idx := range m.indexes {
+		if !missing[idx.Collection+"."+idx.Name()] {
+			log.Printf("[migrate] Verified index %s.%s", idx.Collection, idx.Name())
+			continue
+		}
+		if err := m.createIndex(ctx, idx); err != nil {
+			return created, err
+		}
+		created = append(created, idx)
+	}
+	return created, nil
+}
+
+func (m *Migrator) createIndex(ctx context.Context, idx Index) error {
+	log.Printf("[migrate] Creating index %s.%s", idx.Collection, idx.Name())
+	if _, err := m.db.Collection(idx.Collection).Indexes().CreateOne(ctx, idx.Model); err != nil {
+		return fmt.Errorf("creating index %s.%s: %w", idx.Collection, idx.Name(), err)
+	}
+	return nil
+}
+
+// lock claims the migration lock, taking over one that has expired.
+func (m *Migrator) lock(ctx context.Context) error {
+	now := time.Now().UTC()
+	filter := bson.M{"_id": lockID, "expiresAt": bson.M{"$lt": now}}
+	update := bson.M{"$set": bson.M{"expiresAt": now.Add(lockTTL), "acquiredAt": now}}
+
+	_, err := m.records().UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// The upsert collided with a live lock
+		return ErrLocked
+	}
+	if err != nil {
+		return fmt.Errorf("acquiring migration lock: %w", err)
+	}
+	return nil
+}
+
+func (m *Migrator) unlock() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := m.records().DeleteOne(ctx, bson.M{"_id": lockID}); err != nil {
+		log.Printf("[migrate] Error releasing migration lock: %v", err)
+	}
+}
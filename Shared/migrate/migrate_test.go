@@ -0,0 +1,340 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"shared/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMigrationVersionsAreUnique(t *testing.T) {
+	seen := map[int]string{}
+	for _, m := range Migrations {
+		if m.Version <= 0 || m.Name == "" || m.Up == nil {
+			t.Fatalf("migration %+v is incomplete", m)
+		}
+		if other, ok := seen[m.Version]; ok {
+			t.Fatalf("version %d used by %s and %s", m.Version, other, m.Name)
+		}
+		seen[m.Version] = m.Name
+	}
+}
+
+func TestIndexNamesAreUniquePerCollection(t *testing.T) {
+	seen := map[string]bool{}
+	for _, idx := range Indexes {
+		key := idx.Collection + "." + idx.Name()
+		if seen[key] {
+			t.Fatalf("index %s defined twice", key)
+		}
+		seen[key] = true
+	}
+}
+
+func TestNewOrdersMigrationsByVersion(t *testing.T) {
+	m := New(nil)
+	if !sort.SliceIsSorted(m.migrations, func(i, j int) bool { return m.migrations[i].Version < m.migrations[j].Version }) {
+		t.Fatal("migrations not in version order")
+	}
+	if len(m.migrations) != len(Migrations) || len(m.indexes) != len(Indexes) {
+		t.Fatal("migrator does not carry every registered migration and index")
+	}
+}
+
+func TestStatusString(t *testing.T) {
+	if s := (Status{Applied: []int{1}}); !s.UpToDate() || s.String() != "up to date" {
+		t.Fatalf("status with nothing pending = %q", s)
+	}
+	s := Status{
+		Pending:        []Migration{{Version: 2, Name: "backfill"}},
+		MissingIndexes: []Index{index("users", "email_unique", bson.D{{Key: "email", Value: 1}}, nil)},
+	}
+	if s.UpToDate() {
+		t.Fatal("status with pending work is up to date")
+	}
+	if got, want := s.String(), "pending: migration 2 (backfill), index users.email_unique"; got != want {
+		t.Fatalf("status = %q, want %q", got, want)
+	}
+}
+
+// testDatabase returns an empty database on the server at MONGO_TEST_URI,
+// dropped when the test ends. Tests needing it are skipped without one.
+func testDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	db := client.Database(fmt.Sprintf("migrate_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+	return db
+}
+
+// seedLegacyData writes records the way services stored them before the
+// migrations existed.
+func seedLegacyData(t *testing.T, ctx context.Context, db *mongo.Database) {
+	t.Helper()
+	users := []interface{}{
+		bson.M{"name": "Ann", "email": " Ann@Example.com ", "joinedAt": time.Unix(100, 0)},
+		bson.M{"name": "ann", "email": "other@example.com", "joinedAt": time.Unix(200, 0)},
+	}
+	if _, err := db.Collection(model.UserCollection).InsertMany(ctx, users); err != nil {
+		t.Fatal(err)
+	}
+	shares := []interface{}{
+		bson.M{"documentId": "doc-1", "userId": "u-1", "accessType": "viewer"},
+		bson.M{"documentId": "doc-1", "userId": "u-1", "accessType": "editor"},
+		bson.M{"documentId": "doc-2", "userId": "u-1", "accessType": "owner"},
+	}
+	if _, err := db.Collection(model.SharedDocRecordCollection).InsertMany(ctx, shares); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func dump(t *testing.T, ctx context.Context, collection *mongo.Collection) []bson.M {
+	t.Helper()
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var docs []bson.M
+	if err := cursor.All(ctx, &docs); err != nil {
+		t.Fatal(err)
+	}
+	return docs
+}
+
+func TestApplyToCleanDatabase(t *testing.T) {
+	db := testDatabase(t)
+	ctx := context.Background()
+	m := New(db)
+
+	if err := m.Check(ctx); !errors.Is(err, ErrSchemaBehind) {
+		t.Fatalf("Check on a clean database = %v, want ErrSchemaBehind", err)
+	}
+
+	status, err := m.Apply(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Pending) != len(Migrations) || len(status.MissingIndexes) != len(Indexes) {
+		t.Fatalf("applied %d migrations and %d indexes, want all", len(status.Pending), len(status.MissingIndexes))
+	}
+	if err := m.Check(ctx); err != nil {
+		t.Fatalf("Check after Apply = %v", err)
+	}
+
+	after, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after.Applied) != len(Migrations) {
+		t.Fatalf("recorded versions %v, want every migration", after.Applied)
+	}
+}
+
+func TestApplyToMigratedDatabaseChangesNothing(t *testing.T) {
+	db := testDatabase(t)
+	ctx := context.Background()
+	seedLegacyData(t, ctx, db)
+	m := New(db)
+
+	if _, err := m.Apply(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	users := dump(t, ctx, db.Collection(model.UserCollection))
+	shares := dump(t, ctx, db.Collection(model.SharedDocRecordCollection))
+
+	if users[0]["email"] != "ann@example.com" || users[1]["name"] != "ann-2" {
+		t.Fatalf("users after migration = %v", users)
+	}
+	if len(shares) != 2 || shares[0]["accessType"] != "write" || shares[1]["accessType"] != "read" || shares[1]["invalidAccessType"] != "owner" {
+		t.Fatalf("shares after migration = %v", shares)
+	}
+
+	status, err := m.Apply(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.UpToDate() {
+		t.Fatalf("second run found work: %s", status)
+	}
+	if got := dump(t, ctx, db.Collection(model.UserCollection)); !reflect.DeepEqual(got, users) {
+		t.Fatalf("second run changed users: %v, was %v", got, users)
+	}
+	if got := dump(t, ctx, db.Collection(model.SharedDocRecordCollection)); !reflect.DeepEqual(got, shares) {
+		t.Fatalf("second run changed shares: %v, was %v", got, shares)
+	}
+
+	// Migrations must also survive being re-run from the start, as after a
+	// failure half way through
+	for _, migration := range Migrations {
+		if err := migration.Up(ctx, db); err != nil {
+			t.Fatalf("re-running migration %d: %v", migration.Version, err)
+		}
+	}
+	if got := dump(t, ctx, db.Collection(model.UserCollection)); !reflect.DeepEqual(got, users) {
+		t.Fatalf("re-running migrations changed users: %v, was %v", got, users)
+	}
+}
+
+func TestDryRunAppliesNothing(t *testing.T) {
+	db := testDatabase(t)
+	ctx := context.Background()
+	seedLegacyData(t, ctx, db)
+	m := New(db)
+
+	status, err := m.Apply(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Pending) != len(Migrations) {
+		t.Fatalf("dry run reported %d pending migrations, want %d", len(status.Pending), len(Migrations))
+	}
+	if !strings.HasPrefix(status.String(), "pending: migration 1 ") {
+		t.Fatalf("dry run status = %q", status)
+	}
+
+	users := dump(t, ctx, db.Collection(model.UserCollection))
+	if users[0]["email"] != " Ann@Example.com " {
+		t.Fatalf("dry run changed data: %v", users)
+	}
+	if err := m.Check(ctx); !errors.Is(err, ErrSchemaBehind) {
+		t.Fatalf("Check after a dry run = %v, want ErrSchemaBehind", err)
+	}
+}
+
+func TestApplyRefusesWhileLocked(t *testing.T) {
+	db := testDatabase(t)
+	ctx := context.Background()
+	m := New(db)
+
+	if err := m.lock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Apply(ctx, false); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Apply while locked = %v, want ErrLocked", err)
+	}
+
+	m.unlock()
+	if _, err := m.Apply(ctx, false); err != nil {
+		t.Fatalf("Apply after unlock = %v", err)
+	}
+}
+
+func TestEnsureIndexesTwiceIsANoOp(t *testing.T) {
+	db := testDatabase(t)
+	ctx := context.Background()
+	seedLegacyData(t, ctx, db)
+	m := New(db)
+
+	// Unique indexes wait for the migrations fixing the duplicates they reject
+	if created, err := m.EnsureIndexes(ctx); !errors.Is(err, ErrSchemaBehind) || len(created) != 0 {
+		t.Fatalf("EnsureIndexes with pending migrations = %v, %v, want ErrSchemaBehind", created, err)
+	}
+	if _, err := m.Apply(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Indexes dropped after the migrate tool ran are created again
+	dropped := Indexes[0]
+	if _, err := db.Collection(dropped.Collection).Indexes().DropOne(ctx, dropped.Name()); err != nil {
+		t.Fatal(err)
+	}
+	created, err := m.EnsureIndexes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 1 || created[0].Collection != dropped.Collection || created[0].Name() != dropped.Name() {
+		t.Fatalf("EnsureIndexes created %v, want only %s.%s", created, dropped.Collection, dropped.Name())
+	}
+
+	before := map[string]map[string]bool{}
+	for _, idx := range Indexes {
+		if before[idx.Collection], err = m.indexNames(ctx, idx.Collection); err != nil {
+			t.Fatal(err)
+		}
+	}
+	created, err = m.EnsureIndexes(ctx)
+	if err != nil || len(created) != 0 {
+		t.Fatalf("second EnsureIndexes = %v, %v, want nothing created", created, err)
+	}
+	for collection, names := range before {
+		after, err := m.indexNames(ctx, collection)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(after, names) {
+			t.Fatalf("second EnsureIndexes changed the indexes of %s: %v, was %v", collection, after, names)
+		}
+	}
+	if err := m.Check(ctx); err != nil {
+		t.Fatalf("Check after EnsureIndexes = %v", err)
+	}
+}
+
+func TestNormalizeAccessTypes(t *testing.T) {
+	db := testDatabase(t)
+	ctx := context.Background()
+	shares := db.Collection(model.SharedDocRecordCollection)
+
+	stored := []string{"read", "write", "viewer", " Editor ", "WRITE", "admin", "banana", ""}
+	var docs []interface{}
+	for i, accessType := range stored {
+		docs = append(docs, bson.M{"documentId": fmt.Sprintf("doc-%d", i), "userId": "u-1", "accessType": accessType})
+	}
+	docs = append(docs, bson.M{"documentId": "doc-missing", "userId": "u-1"})
+	if _, err := shares.InsertMany(ctx, docs); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := normalizeAccessTypes(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	type share struct {
+		AccessType        string  `bson:"accessType"`
+		InvalidAccessType *string `bson:"invalidAccessType"`
+	}
+	flagged := func(value string) *string { return &value }
+	want := []share{
+		{"read", nil}, {"write", nil}, {"read", nil}, {"write", nil}, {"write", nil},
+		{"read", flagged("admin")}, {"read", flagged("banana")}, {"read", flagged("")}, {"read", flagged("")},
+	}
+	after := dump(t, ctx, shares)
+	if len(after) != len(want) {
+		t.Fatalf("%d shares after normalizing, want %d", len(after), len(want))
+	}
+	for i, doc := range after {
+		var got share
+		raw, _ := bson.Marshal(doc)
+		bson.Unmarshal(raw, &got)
+		if got.AccessType != want[i].AccessType || (got.InvalidAccessType == nil) != (want[i].InvalidAccessType == nil) ||
+			(got.InvalidAccessType != nil && *got.InvalidAccessType != *want[i].InvalidAccessType) {
+			t.Errorf("share %v after normalizing, want access type %q flagged %v", doc, want[i].AccessType, want[i].InvalidAccessType != nil)
+		}
+	}
+}
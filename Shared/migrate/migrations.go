@@ -0,0 +1,267 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"shared/model"
+	"shared/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration is a one-off data change. Up must be safe to re-run: a migration
+// that fails half way is retried from the start on the next run.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database) error
+}
+
+// Migrations are applied in Version order. Never renumber or remove an entry
+// once it has shipped; append a new one instead.
+var Migrations = []Migration{
+	{Version: 1, Name: "normalize_user_emails", Up: normalizeUserEmails},
+	{Version: 2, Name: "backfill_tenant_ids", Up: backfillTenantIDs},
+	{Version: 3, Name: "backfill_shared_at", Up: backfillSharedAt},
+	{Version: 4, Name: "mark_existing_users_verified", Up: markExistingUsersVerified},
+	{Version: 5, Name: "dedupe_usernames", Up: dedupeUsernames},
+	{Version: 6, Name: "normalize_access_types", Up: normalizeAccessTypes},
+	{Version: 7, Name: "dedupe_shares", Up: dedupeShares},
+	{Version: 8, Name: "backfill_document_timestamps", Up: backfillDocumentTimestamps},
+}
+
+// normalizeUserEmails lowercases and trims stored emails so the unique index
+// treats "A@x.com" and "a@x.com" as the same account.
+func normalizeUserEmails(ctx context.Context, db *mongo.Database) error {
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"email": bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$email"}}}}}},
+	}
+	filter := bson.M{"email": bson.M{"$type": "string"}}
+	if _, err := db.Collection(model.UserCollection).UpdateMany(ctx, filter, update); err != nil {
+		return fmt.Errorf("normalizing emails: %w", err)
+	}
+	return nil
+}
+
+// backfillTenantIDs assigns the default tenant to records written before
+// tenants existed, so tenant-scoped indexes cover them.
+func backfillTenantIDs(ctx context.Context, db *mongo.Database) error {
+	collections := []string{
+		model.UserCollection,
+		model.DocumentCollection,
+		model.SharedDocRecordCollection,
+		model.OperationCollection,
+		model.SnapshotCollection,
+	}
+	filter := bson.M{"$or": bson.A{bson.M{"tenantId": bson.M{"$exists": false}}, bson.M{"tenantId": ""}}}
+	update := bson.M{"$set": bson.M{"tenantId": tenant.DefaultID}}
+
+	for _, name := range collections {
+		if _, err := db.Collection(name).UpdateMany(ctx, filter, update); err != nil {
+			return fmt.Errorf("backfilling tenantId on %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// backfillSharedAt derives the share time of old records from their ObjectID.
+func backfillSharedAt(ctx context.Context, db *mongo.Database) error {
+	filter := bson.M{"sharedAt": bson.M{"$exists": false}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"sharedAt": bson.M{"$toDate": "$_id"}}}},
+	}
+	if _, err := db.Collection(model.SharedDocRecordCollection).UpdateMany(ctx, filter, update); err != nil {
+		return fmt.Errorf("backfilling sharedAt: %w", err)
+	}
+	return nil
+}
+
+// backfillDocumentTimestamps derives createdAt of old documents from their
+// ObjectID, and uses it as updatedAt where that is missing too.
+func backfillDocumentTimestamps(ctx context.Context, db *mongo.Database) error {
+	documents := db.Collection(model.DocumentCollection)
+
+	filter := bson.M{"createdAt": bson.M{"$exists": false}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"createdAt": bson.M{"$toDate": "$_id"}}}},
+	}
+	if _, err := documents.UpdateMany(ctx, filter, update); err != nil {
+		return fmt.Errorf("backfilling createdAt: %w", err)
+	}
+
+	filter = bson.M{"updatedAt": bson.M{"$exists": false}}
+	update = mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"updatedAt": "$createdAt"}}},
+	}
+	if _, err := documents.UpdateMany(ctx, filter, update); err != nil {
+		return fmt.Errorf("backfilling updatedAt: %w", err)
+	}
+	return nil
+}
+
+// markExistingUsersVerified grandfathers accounts created before email
+// verification existed, so enforcing it does not lock them out.
+func markExistingUsersVerified(ctx context.Context, db *mongo.Database) error {
+	filter := bson.M{"verified": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"verified": true}}
+	if _, err := db.Collection(model.UserCollection).UpdateMany(ctx, filter, update); err != nil {
+		return fmt.Errorf("marking existing users verified: %w", err)
+	}
+	return nil
+}
+
+// dedupeUsernames backfills nameKey, the lowercased username the unique
+// nameKey_unique index is built on, and renames users whose username collides
+// with an earlier account. The earliest account (by joinedAt, then _id) keeps
+// the name; later ones get the first free "-2", "-3", ... suffix, so re-running
+// produces the same result.
+func dedupeUsernames(ctx context.Context, db *mongo.Database) error {
+	users := db.Collection(model.UserCollection)
+
+	backfill := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"nameKey": bson.M{"$toLower": bson.M{"$trim": bson.M{"input": bson.M{"$ifNull": bson.A{"$name", ""}}}}}}}},
+	}
+	if _, err := users.UpdateMany(ctx, bson.M{}, backfill); err != nil {
+		return fmt.Errorf("backfilling nameKey: %w", err)
+	}
+
+	duplicates, err := users.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$nameKey", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("finding duplicate usernames: %w", err)
+	}
+	var groups []struct {
+		Key string `bson:"_id"`
+	}
+	if err := duplicates.All(ctx, &groups); err != nil {
+		return fmt.Errorf("decoding duplicate usernames: %w", err)
+	}
+
+	for _, group := range groups {
+		opts := options.Find().SetSort(bson.D{{Key: "joinedAt", Value: 1}, {Key: "_id", Value: 1}})
+		cursor, err := users.Find(ctx, bson.M{"nameKey": group.Key}, opts)
+		if err != nil {
+			return fmt.Errorf("loading users named %q: %w", group.Key, err)
+		}
+		var colliding []struct {
+			ID   interface{} `bson:"_id"`
+			Name string      `bson:"name"`
+		}
+		if err := cursor.All(ctx, &colliding); err != nil {
+			return fmt.Errorf("decoding users named %q: %w", group.Key, err)
+		}
+
+		suffix := 2
+		for _, user := range colliding[1:] {
+			base := strings.TrimSpace(user.Name)
+			if base == "" {
+				base = "user"
+			}
+
+			// Skip suffixes another account already uses
+			var name string
+			for {
+				name = fmt.Sprintf("%s-%d", base, suffix)
+				suffix++
+				taken, err := users.CountDocuments(ctx, bson.M{"nameKey": strings.ToLower(name)}, options.Count().SetLimit(1))
+				if err != nil {
+					return fmt.Errorf("checking username %q: %w", name, err)
+				}
+				if taken == 0 {
+					break
+				}
+			}
+
+			update := bson.M{"$set": bson.M{"name": name, "nameKey": strings.ToLower(name)}}
+			if _, err := users.UpdateByID(ctx, user.ID, update); err != nil {
+				return fmt.Errorf("renaming user to %q: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// legacyAccessTypes maps access types stored before they were validated to
+// "read" or "write", the only values shares may hold now.
+var legacyAccessTypes = map[string]string{
+	"read": "read", "viewer": "read", "view": "read", "reader": "read",
+	"write": "write", "editor": "write", "edit": "write", "writer": "write",
+}
+
+// normalizeAccessTypes rewrites the access type of every share to "read" or
+// "write". Values that mean neither are flagged by keeping them in
+// invalidAccessType and set to "read", the access they were granted anyway.
+func normalizeAccessTypes(ctx context.Context, db *mongo.Database) error {
+	shares := db.Collection(model.SharedDocRecordCollection)
+
+	values, err := shares.Distinct(ctx, "accessType", bson.M{"accessType": bson.M{"$nin": bson.A{"read", "write"}}})
+	if err != nil {
+		return fmt.Errorf("finding access types: %w", err)
+	}
+	// Shares without an access type never show up in Distinct
+	values = append(values, nil)
+
+	for _, value := range values {
+		stored, _ := value.(string)
+		filter := bson.M{"accessType": value}
+		update := bson.M{"$set": bson.M{"accessType": "read", "invalidAccessType": stored}}
+		if normalized, ok := legacyAccessTypes[strings.ToLower(strings.TrimSpace(stored))]; ok {
+			update = bson.M{"$set": bson.M{"accessType": normalized}}
+		}
+		if _, err := shares.UpdateMany(ctx, filter, update); err != nil {
+			return fmt.Errorf("normalizing access type %q: %w", stored, err)
+		}
+	}
+	return nil
+}
+
+// dedupeShares merges shares of one document with the same user, so the
+// document_user_unique index can be built. The earliest share (by sharedAt,
+// then _id) is kept with the strongest access type of the group.
+func dedupeShares(ctx context.Context, db *mongo.Database) error {
+	shares := db.Collection(model.SharedDocRecordCollection)
+
+	duplicates, err := shares.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "sharedAt", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":         bson.M{"documentId": "$documentId", "userId": "$userId"},
+			"ids":         bson.M{"$push": "$_id"},
+			"accessTypes": bson.M{"$addToSet": "$accessType"},
+		}}},
+		{{Key: "$match", Value: bson.M{"ids.1": bson.M{"$exists": true}}}},
+	})
+	if err != nil {
+		return fmt.Errorf("finding duplicate shares: %w", err)
+	}
+	var groups []struct {
+		IDs         []interface{} `bson:"ids"`
+		AccessTypes []string      `bson:"accessTypes"`
+	}
+	if err := duplicates.All(ctx, &groups); err != nil {
+		return fmt.Errorf("decoding duplicate shares: %w", err)
+	}
+
+	for _, group := range groups {
+		accessType := "read"
+		for _, t := range group.AccessTypes {
+			if t == "write" {
+				accessType = "write"
+			}
+		}
+
+		if _, err := shares.UpdateByID(ctx, group.IDs[0], bson.M{"$set": bson.M{"accessType": accessType}}); err != nil {
+			return fmt.Errorf("merging shares: %w", err)
+		}
+		if _, err := shares.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": group.IDs[1:]}}); err != nil {
+			return fmt.Errorf("removing duplicate shares: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+// Package model holds the MongoDB document shapes shared by every service that
+// reads or writes the same collections. Change a bson tag here and every
+// service picks it up together.
+package model
+
+// Collection names shared between services.
+const (
+	UserCollection            = "user"
+	DocumentCollection        = "document"
+	SharedDocRecordCollection = "shared"
+	OperationCollection       = "operations"
+	SnapshotCollection        = "snapshots"
+)
+
+// Collections owned by a single service but indexed by the migrate tool.
+const (
+	OutboxCollection        = "outbox"
+	OutboxParkedCollection  = "outbox_parked"
+	MigrationCollection     = "schema_migrations"
+	RefreshTokenCollection  = "refresh_tokens"
+	LoginEventCollection    = "login_events"
+	SessionCollection       = "sessions"
+	FolderCollection        = "folders"
+	FavoriteCollection      = "favorites"
+	ActivityCollection      = "document_activity"
+	AccessRequestCollection = "access_requests"
+)
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Object struct {
+	ID         string                 `bson:"_id" json:"id"`
+	Type       string                 `bson:"type" json:"type"`
+	Attributes map[string]interface{} `bson:"attributes" json:"attributes"`
+}
+
+type Slide struct {
+	ID         string   `bson:"_id" json:"id"`
+	Background string   `bson:"background" json:"background"`
+	Objects    []Object `bson:"objects" json:"objects"`
+}
+
+type Document struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Title    string             `bson:"title" json:"title"`
+	OwnerID  string             `bson:"ownerId" json:"ownerId"`
+	TenantID string             `bson:"tenantId,omitempty" json:"tenantId,omitempty"`
+	Slides   []Slide            `bson:"slides" json:"slides"`
+	// FolderID is the owner's folder holding the document; documents at the
+	// root have none. Folders are the owner's own, collaborators do not see them.
+	FolderID string `bson:"folderId,omitempty" json:"folderId,omitempty"`
+	// Tags are normalized labels, lowercase and unique within the document.
+	Tags []string `bson:"tags,omitempty" json:"tags,omitempty"`
+	// Description is free text about the document, shown in listings.
+	Description string `bson:"description,omitempty" json:"description,omitempty"`
+	// CreatedAt and UpdatedAt are set on creation; every change, live updates
+	// included, bumps UpdatedAt. The backfill_document_timestamps migration
+	// gave older documents their ObjectID's time.
+	CreatedAt time.Time `bson:"createdAt,omitempty" json:"createdAt,omitempty"`
+	UpdatedAt time.Time `bson:"updatedAt,omitempty" json:"updatedAt,omitempty"`
+	// Version counts content changes. Writers that replace the content check
+	// it to detect concurrent changes; older documents have none, i.e. 0.
+	Version int64 `bson:"version,omitempty" json:"version"`
+	// Seq is the place in the document's update stream of the last live
+	// update applied.
+	Seq string `bson:"seq,omitempty" json:"seq,omitempty"`
+	// Applied holds the seqs of the latest live updates applied, in the
+	// order they were, and is updated with the content. Updates are applied
+	// in Kafka's order, which is not quite the stream's, so UpdatesService
+	// sends clients joining with the content the updates of the stream after
+	// the oldest of these that are not among them.
+	Applied []string `bson:"applied,omitempty" json:"applied,omitempty"`
+	// Archived documents are hidden from the default listing and read-only:
+	// content updates, live ones included, are refused.
+	Archived bool `bson:"archived,omitempty" json:"archived,omitempty"`
+	// Locked documents are frozen by their owner: the content cannot be
+	// changed until they are unlocked, and live updates sent after LockedAt
+	// are dropped. Unlike archiving, a lock does not hide the document.
+	Locked   bool       `bson:"locked,omitempty" json:"locked,omitempty"`
+	LockedBy string     `bson:"lockedBy,omitempty" json:"lockedBy,omitempty"`
+	LockedAt *time.Time `bson:"lockedAt,omitempty" json:"lockedAt,omitempty"`
+	// DeletedAt is set while the document is in the trash.
+	DeletedAt *time.Time `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+}
+
+// DocumentCacheKey is the Redis key DocumentService caches the document with
+// id under. Services changing documents without going through DocumentService
+// delete it, so the next read sees their change.
+func DocumentCacheKey(id string) string {
+	return "doc:" + id
+}
@@ -0,0 +1,123 @@
+package model
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Stored times have millisecond precision
+var testTime = time.Date(2024, 5, 1, 12, 30, 0, 123_000_000, time.UTC)
+
+var testSlides = []Slide{{
+	ID:         "slide-1",
+	Background: "#fff",
+	Objects: []Object{{
+		ID:         "obj-1",
+		Type:       "rectangle",
+		Attributes: map[string]interface{}{"x": 10.5, "fill": "red"},
+	}},
+}}
+
+// TestModelsRoundTrip writes every shared model the way one service does and
+// reads it back the way another does, under the field names both query by.
+// A renamed bson tag fails it.
+func TestModelsRoundTrip(t *testing.T) {
+	lockedAt := testTime.Add(time.Hour)
+	tests := []struct {
+		name   string
+		value  interface{}
+		fields []string
+	}{
+		{
+			name: "Document",
+			value: &Document{
+				ID: primitive.NewObjectID(), Title: "Plan", OwnerID: "user-1", TenantID: "acme",
+				Slides: testSlides, FolderID: "folder-1", Tags: []string{"q3"}, Description: "notes",
+				CreatedAt: testTime, UpdatedAt: testTime, Version: 3, Seq: "1700000000000-0",
+				Applied: []string{"1700000000000-0"}, Archived: true,
+				Locked: true, LockedBy: "user-1", LockedAt: &lockedAt, DeletedAt: &lockedAt,
+			},
+			fields: []string{"_id", "title", "ownerId", "tenantId", "slides", "folderId", "tags", "description",
+				"createdAt", "updatedAt", "version", "seq", "applied", "archived", "locked", "lockedBy", "lockedAt", "deletedAt"},
+		},
+		{
+			name: "SharedDocRecord",
+			value: &SharedDocRecord{
+				ID: primitive.NewObjectID(), UserID: "user-2", DocumentID: "doc-1",
+				AccessType: "write", TenantID: "acme", SharedAt: testTime,
+			},
+			fields: []string{"_id", "userId", "documentId", "accessType", "tenantId", "sharedAt"},
+		},
+		{
+			name: "Operation",
+			value: &Operation{
+				ID: primitive.NewObjectID(), DocumentID: "doc-1", TenantID: "acme", UserID: "user-1",
+				Username: "alice", Action: "update", Body: `{"action":"update"}`, AppliedAt: testTime,
+			},
+			fields: []string{"_id", "documentId", "tenantId", "userId", "username", "action", "body", "appliedAt"},
+		},
+		{
+			name: "Snapshot",
+			value: &Snapshot{
+				ID: primitive.NewObjectID(), DocumentID: "doc-1", TenantID: "acme", Version: 3,
+				UserID: "user-1", Title: "Plan", Slides: testSlides, CreatedAt: testTime,
+			},
+			fields: []string{"_id", "documentId", "tenantId", "version", "userId", "title", "slides", "createdAt"},
+		},
+		{
+			name: "OutboxEvent",
+			value: &OutboxEvent{
+				ID: primitive.NewObjectID(), AggregateID: "doc-1", Topic: "document-events",
+				EventType: "document.created", Payload: "{}", Status: OutboxStatusSent, Attempts: 2,
+				LastError: "timeout", CreatedAt: testTime, SentAt: &lockedAt, ParkedAt: &lockedAt,
+			},
+			fields: []string{"_id", "aggregateId", "topic", "eventType", "payload", "status", "attempts",
+				"lastError", "createdAt", "sentAt", "parkedAt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The fixture must set every field, or a field could go unchecked
+			v := reflect.ValueOf(tt.value).Elem()
+			for i := 0; i < v.NumField(); i++ {
+				if v.Field(i).IsZero() {
+					t.Fatalf("fixture leaves %s.%s unset", tt.name, v.Type().Field(i).Name)
+				}
+			}
+
+			data, err := bson.Marshal(tt.value)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+
+			var raw bson.M
+			if err := bson.Unmarshal(data, &raw); err != nil {
+				t.Fatalf("unmarshal into bson.M: %v", err)
+			}
+			var got []string
+			for field := range raw {
+				got = append(got, field)
+			}
+			want := append([]string(nil), tt.fields...)
+			sort.Strings(got)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("stored fields %v, want %v", got, want)
+			}
+
+			decoded := reflect.New(v.Type()).Interface()
+			if err := bson.Unmarshal(data, decoded); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, tt.value) {
+				t.Errorf("read back %+v, want %+v", decoded, tt.value)
+			}
+		})
+	}
+}
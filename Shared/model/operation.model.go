@@ -0,0 +1,32 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Operation is one update applied to a document by the updates consumer.
+type Operation struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	DocumentID string             `bson:"documentId" json:"documentId"`
+	TenantID   string             `bson:"tenantId,omitempty" json:"tenantId,omitempty"`
+	UserID     string             `bson:"userId" json:"userId"`
+	Username   string             `bson:"username" json:"username"`
+	Action     string             `bson:"action" json:"action"`
+	Body       string             `bson:"body" json:"body"`
+	AppliedAt  time.Time          `bson:"appliedAt" json:"appliedAt"`
+}
+
+// Snapshot is the full content of a document at a point in time: an entry
+// of its version history. UserID is the editor whose change produced it.
+type Snapshot struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	DocumentID string             `bson:"documentId" json:"documentId"`
+	TenantID   string             `bson:"tenantId,omitempty" json:"tenantId,omitempty"`
+	Version    int64              `bson:"version" json:"version"`
+	UserID     string             `bson:"userId,omitempty" json:"userId,omitempty"`
+	Title      string             `bson:"title" json:"title"`
+	Slides     []Slide            `bson:"slides" json:"slides"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+}
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusSent    = "sent"
+)
+
+// OutboxEvent is an event waiting to be relayed to Kafka. It is written in the
+// same transaction as the state change it describes. Any service may append
+// to the outbox collection; DocumentService's relay publishes every row to
+// its Topic.
+type OutboxEvent struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	AggregateID string             `bson:"aggregateId" json:"aggregateId"` // document or user ID, used as the Kafka key
+	Topic       string             `bson:"topic" json:"topic"`
+	EventType   string             `bson:"eventType" json:"eventType"`
+	Payload     string             `bson:"payload" json:"payload"`
+	Status      string             `bson:"status" json:"status"`
+	Attempts    int                `bson:"attempts" json:"attempts"`
+	LastError   string             `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+	SentAt      *time.Time         `bson:"sentAt,omitempty" json:"sentAt,omitempty"`
+	ParkedAt    *time.Time         `bson:"parkedAt,omitempty" json:"parkedAt,omitempty"`
+}
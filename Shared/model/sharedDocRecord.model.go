@@ -0,0 +1,17 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SharedDocRecord grants a collaborator access to a document.
+type SharedDocRecord struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID     string             `bson:"userId" json:"userId"`
+	DocumentID string             `bson:"documentId" json:"documentId"`
+	AccessType string             `bson:"accessType" json:"accessType"` // {read, write}
+	TenantID   string             `bson:"tenantId,omitempty" json:"tenantId,omitempty"`
+	SharedAt   time.Time          `bson:"sharedAt" json:"sharedAt"`
+}
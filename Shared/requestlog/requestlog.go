@@ -0,0 +1,104 @@
+// Package requestlog gives every request to the HTTP services an ID and logs
+// it on completion. The ID comes from the caller's X-Request-ID when it sent a
+// usable one, is echoed on the response, and travels on with calls made while
+// serving the request, so one ID follows a request across services.
+package requestlog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"shared/authmw"
+	"shared/httpclient"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDKey is the key under which the request ID is stored on the gin.Context.
+const RequestIDKey = "requestId"
+
+// maxRequestIDLength bounds incoming IDs; longer ones are replaced.
+const maxRequestIDLength = 128
+
+// Middleware assigns the request ID and logs the method, path, status,
+// latency, user, and response size once the request completes. Mount it first,
+// ahead of Recovery, so requests that panic are logged too.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		id := c.GetHeader(httpclient.RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+			c.Request.Header.Set(httpclient.RequestIDHeader, id)
+		}
+		c.Set(RequestIDKey, id)
+		c.Header(httpclient.RequestIDHeader, id)
+		c.Request = c.Request.WithContext(httpclient.WithRequestID(c.Request.Context(), id))
+
+		c.Next()
+
+		user := c.GetString(authmw.UserIDKey)
+		if user == "" {
+			user = "-"
+		}
+		log.Printf("[Request] %s %s %s %d %v user=%s bytes=%d",
+			id,
+			c.Request.Method,
+			c.Request.URL.Path,
+			c.Writer.Status(),
+			time.Since(start),
+			user,
+			max(c.Writer.Size(), 0),
+		)
+	}
+}
+
+// Recovery turns a panic into a 500 and logs it with the request ID and the
+// stack.
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				log.Printf("[Recovery] %s panic serving %s %s: %v\n%s", RequestID(c), c.Request.Method, c.Request.URL.Path, err, debug.Stack())
+				c.AbortWithStatus(http.StatusInternalServerError)
+			}
+		}()
+		c.Next()
+	}
+}
+
+// RequestID returns the ID Middleware assigned to the request, or "".
+func RequestID(c *gin.Context) string {
+	return c.GetString(RequestIDKey)
+}
+
+// validRequestID accepts IDs of printable ASCII without spaces, so they are
+// safe to log and to send on.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand does not fail on supported platforms
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
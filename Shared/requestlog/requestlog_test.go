@@ -0,0 +1,159 @@
+package requestlog
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"shared/authmw"
+	"shared/httpclient"
+
+	"github.com/gin-gonic/gin"
+)
+
+// captureLog collects what is logged until the test ends.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	saved := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(saved) })
+	return &buf
+}
+
+// loggedRouter serves handler at GET /document behind Middleware and
+// Recovery, as u-1.
+func loggedRouter(handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware(), Recovery(), func(c *gin.Context) {
+		c.Set(authmw.UserIDKey, "u-1")
+	})
+	router.GET("/document", handler)
+	return router
+}
+
+func get(router *gin.Engine, requestId string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/document", nil)
+	if requestId != "" {
+		req.Header.Set(httpclient.RequestIDHeader, requestId)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+var generatedID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+func TestIncomingRequestIDIsKept(t *testing.T) {
+	logged := captureLog(t)
+	var seen, inContext string
+	router := loggedRouter(func(c *gin.Context) {
+		seen, inContext = RequestID(c), httpclient.RequestIDFromContext(c.Request.Context())
+		c.String(http.StatusTeapot, "hello")
+	})
+
+	rec := get(router, "req-42")
+	if got := rec.Header().Get(httpclient.RequestIDHeader); got != "req-42" {
+		t.Errorf("response %s %q, want req-42", httpclient.RequestIDHeader, got)
+	}
+	if seen != "req-42" || inContext != "req-42" {
+		t.Errorf("handler saw %q and %q in its context, want req-42", seen, inContext)
+	}
+
+	line := logged.String()
+	for _, want := range []string{"[Request] req-42 GET /document 418 ", " user=u-1 bytes=5\n"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("log %q does not contain %q", line, want)
+		}
+	}
+}
+
+func TestUnusableRequestIDsAreReplaced(t *testing.T) {
+	logged := captureLog(t)
+	var forwarded string
+	router := loggedRouter(func(c *gin.Context) {
+		forwarded = c.GetHeader(httpclient.RequestIDHeader)
+		c.Status(http.StatusOK)
+	})
+
+	for _, incoming := range []string{"", "two words", "tab\there", strings.Repeat("x", maxRequestIDLength+1), "naïve"} {
+		logged.Reset()
+		rec := get(router, incoming)
+		id := rec.Header().Get(httpclient.RequestIDHeader)
+		if !generatedID.MatchString(id) {
+			t.Errorf("%q: response ID %q, want a generated one", incoming, id)
+			continue
+		}
+		// Handlers and the calls they make see the same ID
+		if forwarded != id {
+			t.Errorf("%q: request header %q, want %q", incoming, forwarded, id)
+		}
+		if !strings.Contains(logged.String(), "[Request] "+id+" ") {
+			t.Errorf("%q: log %q, want the generated ID", incoming, logged)
+		}
+	}
+
+	if a, b := get(router, ""), get(router, ""); a.Header().Get(httpclient.RequestIDHeader) == b.Header().Get(httpclient.RequestIDHeader) {
+		t.Error("two requests were given the same ID")
+	}
+	if id := get(router, strings.Repeat("x", maxRequestIDLength)).Header().Get(httpclient.RequestIDHeader); id != strings.Repeat("x", maxRequestIDLength) {
+		t.Errorf("ID at the length limit replaced by %q", id)
+	}
+}
+
+func TestRequestIDTravelsToOtherServices(t *testing.T) {
+	captureLog(t)
+	var received string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(httpclient.RequestIDHeader)
+	}))
+	defer upstream.Close()
+	client := httpclient.New(httpclient.Config{Service: "auth-service", BaseURL: upstream.URL})
+
+	router := loggedRouter(func(c *gin.Context) {
+		if _, err := client.Get(c.Request.Context(), "/auth/users/lookup", nil); err != nil {
+			t.Error(err)
+		}
+		c.Status(http.StatusOK)
+	})
+	get(router, "req-7")
+	if received != "req-7" {
+		t.Errorf("upstream received %s %q, want req-7", httpclient.RequestIDHeader, received)
+	}
+}
+
+func TestPanicsAreLoggedWithTheRequestID(t *testing.T) {
+	logged := captureLog(t)
+	router := loggedRouter(func(c *gin.Context) {
+		panic("nil map")
+	})
+
+	rec := get(router, "req-9")
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get(httpclient.RequestIDHeader) != "req-9" {
+		t.Errorf("status %d, ID %q, want 500 req-9", rec.Code, rec.Header().Get(httpclient.RequestIDHeader))
+	}
+	out := logged.String()
+	for _, want := range []string{"[Recovery] req-9 panic serving GET /document: nil map", "requestlog_test.go", "[Request] req-9 GET /document 500 "} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestAbortedHandlersStillAbort(t *testing.T) {
+	captureLog(t)
+	router := loggedRouter(func(c *gin.Context) {
+		panic(http.ErrAbortHandler)
+	})
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler passed on", err)
+		}
+	}()
+	get(router, "")
+	t.Error("http.ErrAbortHandler was swallowed")
+}
@@ -0,0 +1,179 @@
+// Package secrets loads credentials from mounted secret files or the environment.
+//
+// For every secret NAME, the variable NAME_FILE (pointing at a Docker/Kubernetes
+// secret mount) takes precedence over NAME itself, so values don't have to be
+// visible in `docker inspect`. Reloadable secrets are swapped atomically on
+// Reload: code that already called Get keeps its value, later calls see the new one.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// ErrMissing is returned when a required secret has neither a file nor an env value.
+var ErrMissing = errors.New("secret not configured")
+
+// Spec describes one secret.
+type Spec struct {
+	Name       string // environment variable name; NAME_FILE is checked first
+	Fallback   string // used when neither NAME_FILE nor NAME is set
+	Required   bool   // fail Load when no value (and no fallback) is found
+	Reloadable bool   // swap the value on Reload instead of only warning
+	// Validate optionally rejects malformed values.
+	Validate func(value string) error
+}
+
+// Secret is a loaded value that can be read concurrently.
+type Secret struct {
+	spec  Spec
+	value atomic.Pointer[string]
+}
+
+// Get returns the current value.
+func (s *Secret) Get() string {
+	if v := s.value.Load(); v != nil {
+		return *v
+	}
+	return ""
+}
+
+// Name returns the environment variable name of the secret.
+func (s *Secret) Name() string {
+	return s.spec.Name
+}
+
+// Set groups the secrets of one service.
+type Set struct {
+	mu      sync.Mutex
+	secrets []*Secret
+}
+
+func NewSet() *Set {
+	return &Set{}
+}
+
+// Add registers a secret. Its value is available after Load.
+func (set *Set) Add(spec Spec) *Secret {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	s := &Secret{spec: spec}
+	set.secrets = append(set.secrets, s)
+	return s
+}
+
+// Lookup resolves NAME_FILE or NAME. found is false when neither is set.
+func Lookup(name string) (value string, found bool, err error) {
+	if path, ok := os.LookupEnv(name + "_FILE"); ok && path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", false, fmt.Errorf("reading %s_FILE: %w", name, err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), true, nil
+	}
+
+	if value, ok := os.LookupEnv(name); ok && value != "" {
+		return value, true, nil
+	}
+
+	return "", false, nil
+}
+
+func (s *Secret) resolve() (string, error) {
+	value, found, err := Lookup(s.spec.Name)
+	if err != nil {
+		return "", err
+	}
+
+	if !found {
+		if s.spec.Required && s.spec.Fallback == "" {
+			return "", fmt.Errorf("%s: %w", s.spec.Name, ErrMissing)
+		}
+		value = s.spec.Fallback
+	}
+
+	if s.spec.Validate != nil && value != "" {
+		if err := s.spec.Validate(value); err != nil {
+			return "", fmt.Errorf("%s is invalid: %w", s.spec.Name, err)
+		}
+	}
+
+	return value, nil
+}
+
+// Load reads and validates every secret. All problems are reported together.
+func (set *Set) Load() error {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	var errs []error
+	for _, s := range set.secrets {
+		value, err := s.resolve()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s.value.Store(&value)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Reload re-reads the secrets. Reloadable ones are swapped; a changed
+// non-reloadable secret only logs a warning because it needs a restart.
+// A secret that fails to load keeps its previous value.
+func (set *Set) Reload() error {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	var errs []error
+	for _, s := range set.secrets {
+		value, err := s.resolve()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if value == s.Get() {
+			continue
+		}
+
+		if !s.spec.Reloadable {
+			log.Printf("[Secrets] WARNING: %s changed but is not reloadable; restart the service to apply it", s.spec.Name)
+			continue
+		}
+
+		s.value.Store(&value)
+		log.Printf("[Secrets] Reloaded %s", s.spec.Name)
+	}
+
+	return errors.Join(errs...)
+}
+
+// ReloadOnSIGHUP reloads the set every time the process receives SIGHUP, until ctx ends.
+func (set *Set) ReloadOnSIGHUP(ctx context.Context) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sighup:
+			log.Println("[Secrets] SIGHUP received, reloading secrets")
+			if err := set.Reload(); err != nil {
+				log.Printf("[Secrets] Reload incomplete, previous values kept: %v", err)
+			}
+		}
+	}
+}
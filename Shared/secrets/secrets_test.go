@@ -0,0 +1,138 @@
+package secrets
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFileTakesPrecedenceOverEnv(t *testing.T) {
+	t.Setenv("TEST_SECRET", "from-env")
+	t.Setenv("TEST_SECRET_FILE", writeFile(t, "secret", "from-file\n"))
+
+	set := NewSet()
+	secret := set.Add(Spec{Name: "TEST_SECRET"})
+	if err := set.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	// The trailing newline of the mount is not part of the value
+	if got := secret.Get(); got != "from-file" {
+		t.Errorf("Get = %q, want from-file", got)
+	}
+}
+
+func TestEnvAndFallback(t *testing.T) {
+	t.Setenv("TEST_SECRET", "from-env")
+
+	set := NewSet()
+	fromEnv := set.Add(Spec{Name: "TEST_SECRET", Fallback: "fallback"})
+	unset := set.Add(Spec{Name: "TEST_UNSET_SECRET", Fallback: "fallback"})
+	if err := set.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := fromEnv.Get(); got != "from-env" {
+		t.Errorf("Get = %q, want from-env", got)
+	}
+	if got := unset.Get(); got != "fallback" {
+		t.Errorf("Get of unset secret = %q, want fallback", got)
+	}
+}
+
+func TestLoadFailures(t *testing.T) {
+	t.Run("missing file", func(t *testing.T) {
+		t.Setenv("TEST_SECRET", "from-env")
+		t.Setenv("TEST_SECRET_FILE", filepath.Join(t.TempDir(), "absent"))
+		set := NewSet()
+		set.Add(Spec{Name: "TEST_SECRET"})
+		// A configured file that cannot be read is an error, not a reason
+		// to fall back to the environment
+		if err := set.Load(); err == nil {
+			t.Fatal("Load succeeded with a missing secret file")
+		}
+	})
+
+	t.Run("required", func(t *testing.T) {
+		set := NewSet()
+		set.Add(Spec{Name: "TEST_UNSET_SECRET", Required: true})
+		if err := set.Load(); !errors.Is(err, ErrMissing) {
+			t.Fatalf("Load = %v, want ErrMissing", err)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Setenv("TEST_SECRET", "short")
+		set := NewSet()
+		set.Add(Spec{Name: "TEST_SECRET", Validate: func(v string) error {
+			if len(v) < 16 {
+				return errors.New("too short")
+			}
+			return nil
+		}})
+		if err := set.Load(); err == nil {
+			t.Fatal("Load accepted a value failing validation")
+		}
+	})
+}
+
+func TestReloadSwapsReloadableSecrets(t *testing.T) {
+	keyFile := writeFile(t, "key", "old-key")
+	passwordFile := writeFile(t, "password", "old-password")
+	t.Setenv("TEST_VERIFY_KEY_FILE", keyFile)
+	t.Setenv("TEST_DB_PASSWORD_FILE", passwordFile)
+
+	set := NewSet()
+	key := set.Add(Spec{Name: "TEST_VERIFY_KEY", Reloadable: true})
+	password := set.Add(Spec{Name: "TEST_DB_PASSWORD"})
+	if err := set.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	// A request in flight keeps the value it read
+	inFlight := key.Get()
+
+	os.WriteFile(keyFile, []byte("new-key"), 0o600)
+	os.WriteFile(passwordFile, []byte("new-password"), 0o600)
+	if err := set.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	if got := key.Get(); got != "new-key" {
+		t.Errorf("reloadable secret = %q after reload, want new-key", got)
+	}
+	if inFlight != "old-key" {
+		t.Errorf("value read before the reload changed to %q", inFlight)
+	}
+	// Non-reloadable secrets need a restart; the reload only warns
+	if got := password.Get(); got != "old-password" {
+		t.Errorf("non-reloadable secret = %q after reload, want old-password", got)
+	}
+}
+
+func TestReloadKeepsValueOnFailure(t *testing.T) {
+	keyFile := writeFile(t, "key", "old-key")
+	t.Setenv("TEST_VERIFY_KEY_FILE", keyFile)
+
+	set := NewSet()
+	key := set.Add(Spec{Name: "TEST_VERIFY_KEY", Reloadable: true})
+	if err := set.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	os.Remove(keyFile)
+	if err := set.Reload(); err == nil {
+		t.Fatal("Reload succeeded with the secret file gone")
+	}
+	if got := key.Get(); got != "old-key" {
+		t.Errorf("secret = %q after a failed reload, want old-key", got)
+	}
+}
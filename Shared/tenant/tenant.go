@@ -0,0 +1,43 @@
+// Package tenant carries the tenant (workspace) boundary through request contexts.
+//
+// Single-tenant deployments never set a tenant explicitly; every lookup then
+// falls back to DefaultID so existing data and tokens keep working.
+package tenant
+
+import (
+	"context"
+	"strings"
+)
+
+// DefaultID is the tenant used when none is configured on a user, token, or record.
+const DefaultID = "default"
+
+type contextKey struct{}
+
+// Normalize trims the ID and substitutes DefaultID for an empty value.
+func Normalize(id string) string {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return DefaultID
+	}
+	return id
+}
+
+// WithID returns a copy of ctx carrying the given tenant ID.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, Normalize(id))
+}
+
+// FromContext returns the tenant ID stored in ctx, or DefaultID when there is none.
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(contextKey{}).(string); ok && id != "" {
+		return id
+	}
+	return DefaultID
+}
+
+// IsDefault reports whether id refers to the default tenant. Records written
+// before tenants existed have no tenant field and belong to the default tenant.
+func IsDefault(id string) bool {
+	return Normalize(id) == DefaultID
+}
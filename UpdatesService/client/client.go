@@ -0,0 +1,800 @@
+// Package client is a Go client for the UpdatesService websocket protocol.
+//
+// A Session joins one document room. Every connection opens with a hello,
+// offering the server the protocol versions the session speaks; the server
+// answers with the one it chose. Outbound messages go through a bounded
+// queue; each is acknowledged by the server in order, and SendUpdate returns
+// once the acknowledgement arrives. When the connection drops the session
+// reconnects with exponential backoff and replays messages that were written
+// but not yet acknowledged, so delivery is at-least-once.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// ErrRejected is returned when the server acknowledged a message as failed,
+	// e.g. because another user holds the lock on the object. The error wraps
+	// the server's reason when it gave one.
+	ErrRejected = errors.New("update rejected by server")
+	// ErrAckTimeout is returned when no acknowledgement arrived in time. The
+	// update may or may not have been applied.
+	ErrAckTimeout = errors.New("timed out waiting for acknowledgement")
+	// ErrThrottled is returned when the server dropped an update for exceeding
+	// the session's rate limit. The error says when to send again.
+	ErrThrottled = errors.New("update throttled by server")
+	// ErrQueueFull is returned when the outbound queue is at capacity.
+	ErrQueueFull = errors.New("outbound queue is full")
+	// ErrClosed is returned for sends on, or pending at, a closed session.
+	ErrClosed = errors.New("session closed")
+	// ErrUnsupportedVersion is returned by Connect when the server speaks
+	// none of the protocol versions the session does.
+	ErrUnsupportedVersion = errors.New("no protocol version in common with the server")
+)
+
+// Options tune a session. Zero values select the defaults.
+type Options struct {
+	Dialer *websocket.Dialer
+
+	// QueueSize bounds queued plus unacknowledged messages. Default 256.
+	QueueSize int
+	// AckTimeout is how long a written message may stay unacknowledged
+	// before the connection is considered broken, and how long the server
+	// may take to answer the hello. Default 10s.
+	AckTimeout time.Duration
+
+	// PingInterval is how often the client pings; PongWait is how long it
+	// waits for any frame before declaring the connection dead. Defaults
+	// 30s and 60s.
+	PingInterval time.Duration
+	PongWait     time.Duration
+
+	// Reconnect backoff. Defaults 250ms and 10s. MaxReconnects of 0 retries
+	// forever; otherwise the session closes after that many failed dials in a row.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	MaxReconnects  int
+
+	// PresenceTTL drops peers not heard from for this long. Default 60s.
+	PresenceTTL time.Duration
+
+	// OnReconnect is called after every successful reconnect.
+	OnReconnect func()
+}
+
+func (o *Options) defaults() {
+	if o.Dialer == nil {
+		o.Dialer = websocket.DefaultDialer
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = 256
+	}
+	if o.AckTimeout <= 0 {
+		o.AckTimeout = 10 * time.Second
+	}
+	if o.PingInterval <= 0 {
+		o.PingInterval = 30 * time.Second
+	}
+	if o.PongWait <= 0 {
+		o.PongWait = 60 * time.Second
+	}
+	if o.InitialBackoff <= 0 {
+		o.InitialBackoff = 250 * time.Millisecond
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 10 * time.Second
+	}
+	if o.PresenceTTL <= 0 {
+		o.PresenceTTL = 60 * time.Second
+	}
+}
+
+// outbound is a message waiting to be written or acknowledged.
+type outbound struct {
+	data   []byte
+	done   chan error
+	sentAt time.Time
+}
+
+func (o *outbound) finish(err error) {
+	o.done <- err
+}
+
+// Session is a connection to one document room.
+type Session struct {
+	url    string
+	header http.Header
+	opts   Options
+
+	mu       sync.Mutex
+	queue    []*outbound // not yet written
+	inflight []*outbound // written, awaiting ack in order
+	closed   bool
+	conn     *websocket.Conn
+	// lastSeq is the seq of the last update received, which reconnects
+	// resume from
+	lastSeq string
+
+	wake       chan struct{}
+	stop       chan struct{}
+	done       chan struct{}
+	resync     chan struct{}
+	reconnects atomic.Int64
+	// version is the protocol version negotiated on the last connection
+	version atomic.Int64
+
+	presence *presenceTable
+
+	handlersMu      sync.RWMutex
+	onUpdate        []func(Incoming)
+	onPresence      []func(PresenceEvent)
+	onSnapshot      []func(Snapshot)
+	onPersistFailed []func(PersistFailure)
+}
+
+// Connect dials url (e.g. "ws://host/updates/ws/docId/<id>") authenticating
+// with token in the Authorization header, and returns a session once the
+// first connection is up.
+func Connect(ctx context.Context, url string, token string, opts Options) (*Session, error) {
+	opts.defaults()
+
+	s := &Session{
+		url:      url,
+		header:   http.Header{"Authorization": {"Bearer " + token}},
+		opts:     opts,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		resync:   make(chan struct{}, 1),
+		presence: newPresenceTable(opts.PresenceTTL),
+	}
+
+	conn, early, err := s.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	go s.run(conn, early)
+	return s, nil
+}
+
+// dial connects and negotiates the protocol version, returning the frames
+// the server sent ahead of its hello.
+func (s *Session) dial(ctx context.Context) (*websocket.Conn, [][]byte, error) {
+	conn, resp, err := s.opts.Dialer.DialContext(ctx, s.resumeURL(), s.header)
+	if err != nil {
+		if resp != nil {
+			return nil, nil, fmt.Errorf("dial failed with status %d: %w", resp.StatusCode, err)
+		}
+		return nil, nil, fmt.Errorf("dial failed: %w", err)
+	}
+
+	early, err := s.handshake(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, early, nil
+}
+
+// handshake offers the server the versions the session speaks and waits
+// for its hello naming the one it chose. The server may send the snapshot
+// and other frames first; they are returned, to be handled once the session
+// is served. Servers predating the handshake refuse the hello as an invalid
+// frame, and speak version 1.
+func (s *Session) handshake(conn *websocket.Conn) ([][]byte, error) {
+	deadline := time.Now().Add(s.opts.AckTimeout)
+	conn.SetWriteDeadline(deadline)
+	if err := conn.WriteJSON(hello{Type: frameHello, Versions: supportedVersions}); err != nil {
+		return nil, fmt.Errorf("sending hello: %w", err)
+	}
+
+	conn.SetReadDeadline(deadline)
+	var early [][]byte
+	for {
+		_, data, err := conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) && closeErr.Code == websocket.CloseProtocolError {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedVersion, closeErr.Text)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("waiting for hello: %w", err)
+		}
+
+		var msg wireMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		switch {
+		case msg.Type == frameHello:
+			if !slices.Contains(supportedVersions, msg.V) {
+				return nil, fmt.Errorf("%w: server chose version %d", ErrUnsupportedVersion, msg.V)
+			}
+			s.version.Store(int64(msg.V))
+			return early, nil
+		case msg.Type == "error" && (msg.Code == codeInvalidFrame || msg.Code == codeUnknownType):
+			s.version.Store(1)
+			return early, nil
+		}
+		early = append(early, data)
+	}
+}
+
+// resumeURL is the URL to connect with, asking the server to replay the
+// updates after the last one received.
+func (s *Session) resumeURL() string {
+	s.mu.Lock()
+	since := s.lastSeq
+	s.mu.Unlock()
+	if since == "" {
+		return s.url
+	}
+
+	u, err := url.Parse(s.url)
+	if err != nil {
+		return s.url
+	}
+	query := u.Query()
+	query.Set("since", since)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// OnUpdate registers a handler for content updates from other members.
+// Handlers run on the read loop and must not block.
+func (s *Session) OnUpdate(fn func(Incoming)) {
+	s.handlersMu.Lock()
+	defer s.handlersMu.Unlock()
+	s.onUpdate = append(s.onUpdate, fn)
+}
+
+// OnPresence registers a handler for presence changes of other members.
+// Handlers run on the read loop and must not block.
+func (s *Session) OnPresence(fn func(PresenceEvent)) {
+	s.handlersMu.Lock()
+	defer s.handlersMu.Unlock()
+	s.onPresence = append(s.onPresence, fn)
+}
+
+// OnSnapshot registers a handler for the document's content, which the
+// server sends on every (re)connect before any update. Handlers run on the
+// read loop and must not block.
+func (s *Session) OnSnapshot(fn func(Snapshot)) {
+	s.handlersMu.Lock()
+	defer s.handlersMu.Unlock()
+	s.onSnapshot = append(s.onSnapshot, fn)
+}
+
+// OnPersistFailed registers a handler for updates of the session the server
+// could not save. Such updates are acked as sent, and their room saw them.
+// Handlers run on the read loop and must not block.
+func (s *Session) OnPersistFailed(fn func(PersistFailure)) {
+	s.handlersMu.Lock()
+	defer s.handlersMu.Unlock()
+	s.onPersistFailed = append(s.onPersistFailed, fn)
+}
+
+// Presence returns the peers heard from within the presence TTL.
+func (s *Session) Presence() []Peer {
+	return s.presence.snapshot()
+}
+
+// Version returns the protocol version negotiated with the server.
+func (s *Session) Version() int {
+	return int(s.version.Load())
+}
+
+// Reconnects returns how many times the session has reconnected.
+func (s *Session) Reconnects() int64 {
+	return s.reconnects.Load()
+}
+
+// Resync drops the current connection and joins the room again, clearing
+// local presence. Unacknowledged messages are replayed on the new connection,
+// and the server replays the updates missed meanwhile, or sends a snapshot
+// when it no longer has them.
+func (s *Session) Resync() {
+	s.presence.reset()
+	select {
+	case s.resync <- struct{}{}:
+	default:
+	}
+}
+
+// SendUpdate sends a content update and waits for its acknowledgement.
+func (s *Session) SendUpdate(ctx context.Context, u Update) error {
+	return s.send(ctx, envelope{Type: frameOf(u.Action), V: s.Version(), Payload: u})
+}
+
+// MoveCursor broadcasts the local cursor position.
+func (s *Session) MoveCursor(ctx context.Context, slideID string, x float64, y float64) error {
+	return s.send(ctx, envelope{Type: framePresence, V: s.Version(), Payload: map[string]interface{}{
+		"action":            ActionCursorMove,
+		"slideId":           slideID,
+		"newCursorLocation": [2]float64{x, y},
+	}})
+}
+
+// ShowCursor shares the local pointer position and selection with the room.
+// The server relays at most 20 a second, keeping the latest.
+func (s *Session) ShowCursor(ctx context.Context, slideID string, x float64, y float64, selection []string) error {
+	return s.send(ctx, envelope{Type: frameCursor, V: s.Version(), Payload: map[string]interface{}{
+		"slideId":   slideID,
+		"x":         x,
+		"y":         y,
+		"selection": selection,
+	}})
+}
+
+// Select takes the lock on an object and announces the selection.
+func (s *Session) Select(ctx context.Context, slideID string, objectID string) error {
+	return s.SendUpdate(ctx, Update{Action: ActionSelect, SlideID: slideID, ObjectID: objectID})
+}
+
+// Deselect releases the lock on an object.
+func (s *Session) Deselect(ctx context.Context, slideID string, objectID string) error {
+	return s.SendUpdate(ctx, Update{Action: ActionDeselect, SlideID: slideID, ObjectID: objectID})
+}
+
+// SendAsync queues v and returns a channel that receives the acknowledgement
+// result exactly once. v is sent as it is, so it should be an envelope
+// ({"type", "v", "payload"}) as the other senders build.
+func (s *Session) SendAsync(v interface{}) (<-chan error, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("encoding message: %w", err)
+	}
+
+	msg := &outbound{data: data, done: make(chan error, 1)}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if len(s.queue)+len(s.inflight) >= s.opts.QueueSize {
+		s.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+	s.queue = append(s.queue, msg)
+	s.mu.Unlock()
+
+	s.notify()
+	return msg.done, nil
+}
+
+func (s *Session) send(ctx context.Context, v interface{}) error {
+	done, err := s.SendAsync(v)
+	if err != nil {
+		return err
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// The message stays queued; its result is discarded
+		return ctx.Err()
+	}
+}
+
+func (s *Session) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the session. Queued and unacknowledged sends fail with ErrClosed.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		<-s.done
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.stop)
+	<-s.done
+	return nil
+}
+
+// run owns the connection: it serves one connection at a time and reconnects
+// until the session is closed.
+func (s *Session) run(conn *websocket.Conn, early [][]byte) {
+	defer close(s.done)
+
+	for {
+		s.serve(conn, early)
+		s.requeueInflight()
+
+		if s.isClosed() {
+			s.failAll(ErrClosed)
+			return
+		}
+
+		conn, early = s.reconnect()
+		if conn == nil {
+			s.mu.Lock()
+			s.closed = true
+			s.mu.Unlock()
+			s.failAll(ErrClosed)
+			return
+		}
+		s.reconnects.Add(1)
+		if s.opts.OnReconnect != nil {
+			s.opts.OnReconnect()
+		}
+	}
+}
+
+func (s *Session) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// reconnect dials with exponential backoff and full jitter. It returns nil if
+// the session was closed, MaxReconnects dials failed, or the server no
+// longer speaks the session's protocol versions.
+func (s *Session) reconnect() (*websocket.Conn, [][]byte) {
+	backoff := s.opts.InitialBackoff
+	for attempt := 1; s.opts.MaxReconnects == 0 || attempt <= s.opts.MaxReconnects; attempt++ {
+		select {
+		case <-time.After(time.Duration(rand.Int63n(int64(backoff)) + 1)):
+		case <-s.stop:
+			return nil, nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.opts.PongWait)
+		conn, early, err := s.dial(ctx)
+		cancel()
+		if err == nil {
+			return conn, early
+		}
+		if errors.Is(err, ErrUnsupportedVersion) {
+			return nil, nil
+		}
+
+		backoff *= 2
+		if backoff > s.opts.MaxBackoff {
+			backoff = s.opts.MaxBackoff
+		}
+	}
+	return nil, nil
+}
+
+// serve runs the read loop in a goroutine and the write loop inline until
+// either fails, the session is closed, or a resync is requested. The read
+// loop handles early, the frames read during the handshake, first.
+func (s *Session) serve(conn *websocket.Conn, early [][]byte) {
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+
+	conn.SetReadDeadline(time.Now().Add(s.opts.PongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(s.opts.PongWait))
+	})
+
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		s.readLoop(conn, early)
+	}()
+
+	s.writeLoop(conn, readerDone)
+
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+	conn.Close()
+	<-readerDone
+}
+
+func (s *Session) writeLoop(conn *websocket.Conn, readerDone <-chan struct{}) {
+	ping := time.NewTicker(s.opts.PingInterval)
+	defer ping.Stop()
+	ackCheck := time.NewTicker(s.opts.AckTimeout / 4)
+	defer ackCheck.Stop()
+
+	for {
+		// Drain the queue before waiting
+		for {
+			msg := s.nextOutbound()
+			if msg == nil {
+				break
+			}
+			conn.SetWriteDeadline(time.Now().Add(s.opts.AckTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, msg.data); err != nil {
+				return
+			}
+		}
+
+		select {
+		case <-s.wake:
+		case <-s.stop:
+			return
+		case <-s.resync:
+			return
+		case <-readerDone:
+			return
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(s.opts.AckTimeout)); err != nil {
+				return
+			}
+		case <-ackCheck.C:
+			if s.expireAck() {
+				// Acks are matched by order, so after a lost ack the stream
+				// can only be realigned on a fresh connection
+				return
+			}
+		}
+	}
+}
+
+// nextOutbound moves the head of the queue to inflight.
+func (s *Session) nextOutbound() *outbound {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queue) == 0 {
+		return nil
+	}
+	msg := s.queue[0]
+	s.queue = s.queue[1:]
+	msg.sentAt = time.Now()
+	s.inflight = append(s.inflight, msg)
+	return msg
+}
+
+// expireAck fails the oldest unacknowledged message if it is overdue.
+func (s *Session) expireAck() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.inflight) == 0 || time.Since(s.inflight[0].sentAt) < s.opts.AckTimeout {
+		return false
+	}
+	s.inflight[0].finish(ErrAckTimeout)
+	s.inflight = s.inflight[1:]
+	return true
+}
+
+func (s *Session) ack(success bool, reason string) {
+	if success {
+		s.finishOldest(nil)
+	} else {
+		s.finishOldest(rejected(reason))
+	}
+}
+
+// finishOldest finishes the oldest unacknowledged message with err, or
+// successfully when err is nil.
+func (s *Session) finishOldest(err error) {
+	s.mu.Lock()
+	if len(s.inflight) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	msg := s.inflight[0]
+	s.inflight = s.inflight[1:]
+	s.mu.Unlock()
+
+	msg.finish(err)
+}
+
+// rejected is the error for a failed acknowledgement, carrying the server's
+// reason when it gave one.
+func rejected(reason string) error {
+	if reason == "" {
+		return ErrRejected
+	}
+	return fmt.Errorf("%w: %s", ErrRejected, reason)
+}
+
+// requeueInflight puts unacknowledged messages back in front of the queue so
+// they are replayed on the next connection.
+func (s *Session) requeueInflight() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queue = append(s.inflight, s.queue...)
+	s.inflight = nil
+	s.conn = nil
+}
+
+func (s *Session) failAll(err error) {
+	s.mu.Lock()
+	pending := append(s.inflight, s.queue...)
+	s.inflight, s.queue = nil, nil
+	s.mu.Unlock()
+
+	for _, msg := range pending {
+		msg.finish(err)
+	}
+}
+
+func (s *Session) readLoop(conn *websocket.Conn, early [][]byte) {
+	for _, data := range early {
+		s.handleFrame(data)
+	}
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(s.opts.PongWait))
+		s.handleFrame(data)
+	}
+}
+
+// handleFrame acts on a frame the server sent.
+func (s *Session) handleFrame(data []byte) {
+	var msg wireMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return
+	}
+	if msg.Success != nil {
+		s.ack(*msg.Success, msg.Error)
+		return
+	}
+	switch msg.Type {
+	// An error frame stands in for the failed ack of the refused message
+	case "error":
+		s.ack(false, msg.Code)
+	case "throttle":
+		retryAfter := time.Duration(msg.RetryAfterMs) * time.Millisecond
+		s.finishOldest(fmt.Errorf("%w: retry after %v", ErrThrottled, retryAfter))
+	case "presence":
+		s.presenceFrame(msg)
+	case "snapshot":
+		s.snapshotFrame(msg)
+	// Unlike an error frame, this does not stand in for an ack
+	case "persist-failed":
+		s.persistFailedFrame(msg)
+	// The server dropped messages for this client: join again, to have
+	// them replayed. When it could not replay them on joining, a snapshot
+	// follows instead
+	case "resync":
+		if msg.Reason != resyncHistoryTrimmed && msg.Reason != resyncHistoryUnavailable {
+			s.Resync()
+		}
+	// A repeated hello changes nothing
+	case frameHello:
+	default:
+		s.dispatch(msg)
+	}
+}
+
+// presenceFrame applies a join or leave, or the members listed on joining,
+// and reports each to the presence handlers.
+func (s *Session) presenceFrame(msg wireMessage) {
+	var events []PresenceEvent
+	switch msg.Event {
+	case PresenceJoin, PresenceLeave:
+		events = append(events, PresenceEvent{UserID: msg.UserID, Username: msg.Username, Action: msg.Event})
+	case "list":
+		for _, p := range msg.Participants {
+			events = append(events, PresenceEvent{UserID: p.UserID, Username: p.Username, Action: PresenceJoin})
+		}
+	}
+
+	s.handlersMu.RLock()
+	defer s.handlersMu.RUnlock()
+	for _, event := range events {
+		s.presence.apply(event)
+		for _, fn := range s.onPresence {
+			fn(event)
+		}
+	}
+}
+
+func (s *Session) snapshotFrame(msg wireMessage) {
+	// Updates the snapshot has need not be replayed on reconnecting
+	if msg.Seq != "" {
+		s.mu.Lock()
+		s.lastSeq = msg.Seq
+		s.mu.Unlock()
+	}
+	snapshot := Snapshot{Version: msg.Version, Slides: msg.Content.Slides}
+
+	s.handlersMu.RLock()
+	defer s.handlersMu.RUnlock()
+	for _, fn := range s.onSnapshot {
+		fn(snapshot)
+	}
+}
+
+func (s *Session) persistFailedFrame(msg wireMessage) {
+	failure := PersistFailure{Seq: msg.Seq, Body: json.RawMessage(msg.Body)}
+
+	s.handlersMu.RLock()
+	defer s.handlersMu.RUnlock()
+	for _, fn := range s.onPersistFailed {
+		fn(failure)
+	}
+}
+
+func (s *Session) dispatch(msg wireMessage) {
+	if msg.Seq != "" {
+		s.mu.Lock()
+		s.lastSeq = msg.Seq
+		s.mu.Unlock()
+	}
+
+	var body actionBody
+	if err := json.Unmarshal([]byte(msg.Body), &body); err != nil {
+		return
+	}
+	if body.Type == ActionCursor {
+		body.Action = ActionCursor
+		body.NewCursorLocation = [2]float64{body.X, body.Y}
+	}
+
+	switch body.Action {
+	case ActionCursor:
+		event := PresenceEvent{
+			UserID:    msg.UserID,
+			Username:  msg.Username,
+			Action:    ActionCursor,
+			SlideID:   body.SlideID,
+			Cursor:    body.NewCursorLocation,
+			Selection: body.Selection,
+			Removed:   body.Removed,
+		}
+		s.presence.apply(event)
+
+		s.handlersMu.RLock()
+		defer s.handlersMu.RUnlock()
+		for _, fn := range s.onPresence {
+			fn(event)
+		}
+
+	case ActionCursorMove, ActionSelect, ActionDeselect, ActionNotify:
+		event := PresenceEvent{
+			UserID:   msg.UserID,
+			Username: msg.Username,
+			Action:   body.Action,
+			SlideID:  body.SlideID,
+			ObjectID: body.ObjectID,
+			Cursor:   body.NewCursorLocation,
+		}
+		s.presence.apply(event)
+
+		s.handlersMu.RLock()
+		defer s.handlersMu.RUnlock()
+		for _, fn := range s.onPresence {
+			fn(event)
+		}
+
+	default:
+		incoming := Incoming{
+			DocumentID: msg.DocumentID,
+			UserID:     msg.UserID,
+			Username:   msg.Username,
+			Action:     body.Action,
+			Seq:        msg.Seq,
+			Body:       json.RawMessage(msg.Body),
+		}
+
+		s.handlersMu.RLock()
+		defer s.handlersMu.RUnlock()
+		for _, fn := range s.onUpdate {
+			fn(incoming)
+		}
+	}
+}
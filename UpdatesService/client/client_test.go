@@ -0,0 +1,315 @@
+package client
+
+import (
+	"UpdatesService/kafkaUtils"
+	"UpdatesService/redis"
+	"UpdatesService/websocket"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	gorilla "github.com/gorilla/websocket"
+)
+
+// fakeBackend takes the edits the pool produces without a broker.
+type fakeBackend struct {
+	events chan kafka.Event
+}
+
+func (b *fakeBackend) Produce(*kafka.Message, chan kafka.Event) error { return nil }
+func (b *fakeBackend) Events() chan kafka.Event                       { return b.events }
+
+// testServer serves the websocket protocol from an in-process pool, with
+// the update streams in miniredis. Users authenticate as the token they
+// connect with.
+type testServer struct {
+	*httptest.Server
+	pool *websocket.Pool
+
+	refuse atomic.Bool
+	mu     sync.Mutex
+	conns  map[string][]*gorilla.Conn
+	since  []string
+}
+
+func startServer(t *testing.T) *testServer {
+	t.Helper()
+	redisClient := redis.NewRedisClient(miniredis.RunT(t).Addr(), "")
+	pool := websocket.NewPool(kafkaUtils.NewProducer(&fakeBackend{events: make(chan kafka.Event)}))
+	go pool.Start()
+
+	s := &testServer{pool: pool, conns: make(map[string][]*gorilla.Conn)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.refuse.Load() {
+			http.Error(w, "refused", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := websocket.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		user := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		since := r.URL.Query().Get("since")
+		s.mu.Lock()
+		s.conns[user] = append(s.conns[user], conn)
+		s.since = append(s.since, since)
+		s.mu.Unlock()
+
+		client := &websocket.Client{
+			UserID:      user,
+			Username:    user,
+			TenantID:    "acme",
+			DocumentID:  "doc-1",
+			AccessLevel: "owner",
+			Conn:        conn,
+			Pool:        pool,
+			Send:        make(chan []byte, 64),
+			RedisClient: redisClient,
+		}
+		pool.Register <- client
+		if since != "" {
+			if err := client.Replay(r.Context(), since); err != nil {
+				t.Errorf("replaying since %s: %v", since, err)
+			}
+		}
+		go client.Writer()
+		client.Read()
+	}))
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		pool.Shutdown(ctx)
+		s.Close()
+	})
+	return s
+}
+
+// drop cuts the connections of user without a close frame.
+func (s *testServer) drop(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns[user] {
+		conn.NetConn().Close()
+	}
+	s.conns[user] = nil
+}
+
+func (s *testServer) resumedFrom() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.since...)
+}
+
+func (s *testServer) url() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + "/updates/ws/docId/doc-1"
+}
+
+// rawServer upgrades every connection and hands it to serve, with how many
+// connections came before it.
+func rawServer(t *testing.T, serve func(conn *gorilla.Conn, n int)) *httptest.Server {
+	t.Helper()
+	var connections atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&gorilla.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serve(conn, int(connections.Add(1)-1))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// answerHello reads the session's hello and answers it with version 1.
+func answerHello(t *testing.T, conn *gorilla.Conn) {
+	var h hello
+	if err := conn.ReadJSON(&h); err != nil || h.Type != frameHello {
+		t.Errorf("first frame = %+v, %v, want a hello", h, err)
+		return
+	}
+	conn.WriteJSON(map[string]interface{}{"type": frameHello, "v": 1, "versions": []int{1}})
+}
+
+func wsURL(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+var fastOptions = Options{
+	AckTimeout:     time.Second,
+	InitialBackoff: 10 * time.Millisecond,
+	MaxBackoff:     50 * time.Millisecond,
+}
+
+func connect(t *testing.T, url string, user string, opts Options) *Session {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s, err := Connect(ctx, url, user, opts)
+	if err != nil {
+		t.Fatalf("connecting as %s: %v", user, err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// updates collects the slides of the updates the session receives.
+func updates(s *Session) <-chan Incoming {
+	ch := make(chan Incoming, 64)
+	s.OnUpdate(func(m Incoming) { ch <- m })
+	return ch
+}
+
+func awaitSlide(t *testing.T, ch <-chan Incoming, slideID string) Incoming {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case m := <-ch:
+			var u Update
+			if err := m.Decode(&u); err == nil && u.SlideID == slideID {
+				return m
+			}
+		case <-timeout:
+			t.Fatalf("update of slide %s never arrived", slideID)
+		}
+	}
+}
+
+func TestConnectNegotiatesVersion(t *testing.T) {
+	server := startServer(t)
+	s := connect(t, server.url(), "alice", fastOptions)
+	if s.Version() != protocolVersion {
+		t.Fatalf("negotiated version %d, want %d", s.Version(), protocolVersion)
+	}
+	if err := s.SendUpdate(context.Background(), Update{Action: ActionAddSlide, SlideID: "s-1"}); err != nil {
+		t.Fatalf("sending after the handshake: %v", err)
+	}
+}
+
+func TestConnectFailsWithoutCommonVersion(t *testing.T) {
+	server := startServer(t)
+	offered := supportedVersions
+	supportedVersions = []int{protocolVersion + 1}
+	defer func() { supportedVersions = offered }()
+
+	_, err := Connect(context.Background(), server.url(), "alice", fastOptions)
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("Connect = %v, want ErrUnsupportedVersion", err)
+	}
+}
+
+func TestConnectToServerWithoutHello(t *testing.T) {
+	server := rawServer(t, func(conn *gorilla.Conn, n int) {
+		conn.ReadMessage()
+		conn.WriteJSON(map[string]string{"type": "error", "code": codeInvalidFrame})
+		conn.ReadMessage()
+	})
+	s := connect(t, wsURL(server), "alice", fastOptions)
+	if s.Version() != 1 {
+		t.Fatalf("version with a server predating the hello = %d, want 1", s.Version())
+	}
+}
+
+func TestReconnectReplaysMissedUpdates(t *testing.T) {
+	server := startServer(t)
+	alice := connect(t, server.url(), "alice", fastOptions)
+	bob := connect(t, server.url(), "bob", fastOptions)
+	received := updates(bob)
+	ctx := context.Background()
+
+	if err := alice.SendUpdate(ctx, Update{Action: ActionAddSlide, SlideID: "s-1"}); err != nil {
+		t.Fatal(err)
+	}
+	first := awaitSlide(t, received, "s-1")
+
+	// Bob is away while alice adds a slide
+	server.refuse.Store(true)
+	server.drop("bob")
+	if err := alice.SendUpdate(ctx, Update{Action: ActionAddSlide, SlideID: "s-2"}); err != nil {
+		t.Fatal(err)
+	}
+	server.refuse.Store(false)
+
+	awaitSlide(t, received, "s-2")
+	if bob.Reconnects() == 0 {
+		t.Error("bob did not reconnect")
+	}
+	since := server.resumedFrom()
+	if last := since[len(since)-1]; last != first.Seq {
+		t.Errorf("bob resumed from %q, want the seq of the last update he got, %q", last, first.Seq)
+	}
+}
+
+func TestUnacknowledgedUpdatesAreResent(t *testing.T) {
+	var mu sync.Mutex
+	var edits []string
+	server := rawServer(t, func(conn *gorilla.Conn, n int) {
+		answerHello(t, conn)
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		edits = append(edits, string(data))
+		mu.Unlock()
+		// The first connection drops before the ack
+		if n == 0 {
+			return
+		}
+		conn.WriteJSON(map[string]bool{"success": true})
+		conn.ReadMessage()
+	})
+	s := connect(t, wsURL(server), "alice", fastOptions)
+
+	if err := s.SendUpdate(context.Background(), Update{Action: ActionAddSlide, SlideID: "s-1"}); err != nil {
+		t.Fatalf("SendUpdate = %v, want the update resent and acked", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(edits) != 2 || edits[0] != edits[1] {
+		t.Fatalf("server got %q, want the same edit twice", edits)
+	}
+	var env envelope
+	if err := json.Unmarshal([]byte(edits[0]), &env); err != nil || env.V != 1 {
+		t.Errorf("edit sent as %s, want the negotiated version", edits[0])
+	}
+}
+
+func TestAckTimeoutReconnects(t *testing.T) {
+	var connections atomic.Int64
+	server := rawServer(t, func(conn *gorilla.Conn, n int) {
+		connections.Add(1)
+		answerHello(t, conn)
+		// Never acks
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	opts := fastOptions
+	opts.AckTimeout = 200 * time.Millisecond
+	s := connect(t, wsURL(server), "alice", opts)
+
+	err := s.SendUpdate(context.Background(), Update{Action: ActionAddSlide, SlideID: "s-1"})
+	if !errors.Is(err, ErrAckTimeout) {
+		t.Fatalf("SendUpdate = %v, want ErrAckTimeout", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Reconnects() == 0 || connections.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("session did not reconnect after the lost ack")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
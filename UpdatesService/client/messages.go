@@ -0,0 +1,221 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Actions understood by the UpdatesService.
+const (
+	ActionCreate      = "create"
+	ActionUpdate      = "update"
+	ActionDelete      = "delete"
+	ActionAddSlide    = "add_slide"
+	ActionRemoveSlide = "remove_slide"
+	ActionCursorMove  = "cursormove"
+	ActionSelect      = "select"
+	ActionDeselect    = "deselect"
+	ActionNotify      = "notification"
+	// ActionCursor is the type of cursor frames, which carry the pointer
+	// position and selection of a member
+	ActionCursor = "cursor"
+)
+
+// Presence events announced by the server, reported as the Action of a
+// PresenceEvent: a member joined the room, or left it with their last
+// connection.
+const (
+	PresenceJoin  = "join"
+	PresenceLeave = "leave"
+)
+
+// protocolVersion is the newest version of the envelope the session speaks;
+// supportedVersions are all it speaks, offered to the server on connecting.
+const protocolVersion = 1
+
+var supportedVersions = []int{protocolVersion}
+
+// Types of the envelopes messages are sent in.
+const (
+	frameEdit     = "edit"
+	framePresence = "presence"
+	frameCursor   = "cursor"
+	// frameHello opens every connection, see Session.handshake
+	frameHello = "hello"
+)
+
+// Codes of the error frames the session acts on.
+const (
+	codeInvalidFrame = "INVALID_FRAME"
+	codeUnknownType  = "UNKNOWN_TYPE"
+)
+
+// hello offers the server the versions the session speaks.
+type hello struct {
+	Type     string `json:"type"`
+	Versions []int  `json:"versions"`
+}
+
+// envelope wraps every message the session sends.
+type envelope struct {
+	Type    string      `json:"type"`
+	V       int         `json:"v"`
+	Payload interface{} `json:"payload"`
+}
+
+// frameOf returns the envelope type of an action: cursor moves and
+// selections are presence, everything else an edit.
+func frameOf(action string) string {
+	switch action {
+	case ActionCursorMove, ActionSelect, ActionDeselect:
+		return framePresence
+	}
+	return frameEdit
+}
+
+// Reasons of resync frames the server sends on joining, when it could not
+// replay the updates missed; a snapshot follows them.
+const (
+	resyncHistoryTrimmed     = "history_trimmed"
+	resyncHistoryUnavailable = "history_unavailable"
+)
+
+// Update is a content change sent by the client. Only the fields relevant to
+// Action are sent.
+type Update struct {
+	Action            string                 `json:"action"`
+	SlideID           string                 `json:"slideId"`
+	ObjectID          string                 `json:"objectId,omitempty"`
+	ObjectType        string                 `json:"objectType,omitempty"`
+	Attributes        map[string]interface{} `json:"attributes,omitempty"`
+	UpdatedAttributes map[string]interface{} `json:"updatedAttributes,omitempty"`
+}
+
+// Incoming is a message broadcast by another member of the room.
+type Incoming struct {
+	DocumentID string
+	UserID     string
+	Username   string
+	Action     string
+	// Seq is the update's place in the document's update stream; sessions
+	// resume after the last one on reconnecting.
+	Seq string
+	// Body is the raw action message as sent by the originating client.
+	Body json.RawMessage
+}
+
+// Decode unmarshals the body into v, e.g. an Update.
+func (m Incoming) Decode(v interface{}) error {
+	return json.Unmarshal(m.Body, v)
+}
+
+// Snapshot is the document's content at Version, sent on joining; the
+// updates received after it apply to it.
+type Snapshot struct {
+	Version int64
+	// Slides are the document's slides, as GET /document/:id returns them
+	Slides json.RawMessage
+}
+
+// PersistFailure is an update of this session the room received but which
+// the server could not hand on for saving, so it may be lost when the
+// document is next opened.
+type PersistFailure struct {
+	// Seq is the update's place in the document's update stream, if it
+	// has one
+	Seq string
+	// Body is the update as sent, e.g. an Update
+	Body json.RawMessage
+}
+
+// PresenceEvent is a presence change of another member: a join or leave, a
+// cursor move, or a selection change.
+type PresenceEvent struct {
+	UserID   string
+	Username string
+	Action   string
+	SlideID  string
+	ObjectID string
+	Cursor   [2]float64
+	// Selection and Removed come with cursor frames; Removed means the
+	// member's cursor is gone
+	Selection []string
+	Removed   bool
+}
+
+// Peer is the last known presence of a room member.
+type Peer struct {
+	UserID   string
+	Username string
+	SlideID  string
+	Cursor   [2]float64
+	Selected string
+	// Selection is the objects the peer's cursor frames say it selected
+	Selection []string
+	LastSeen  time.Time
+	// Present peers were announced by the server as in the room, and stay
+	// until it announces they left.
+	Present bool
+}
+
+// wireMessage is the server-to-client frame. Acks carry only Success, and
+// Error when a message failed for a reason the client can act on. Messages
+// refused outright come back as frames of Type "error" with a Code instead
+// of an ack.
+type wireMessage struct {
+	DocumentID string    `json:"documentId"`
+	UserID     string    `json:"userId"`
+	Username   string    `json:"username"`
+	Body       string    `json:"body"`
+	Success    *bool     `json:"success"`
+	Error      string    `json:"error"`
+	Type       frameType `json:"type"`
+	Code       string    `json:"code"`
+	Seq        string    `json:"seq"`
+	Reason     string    `json:"reason"`
+	// Hello frames name the version the server chose for the session
+	V int `json:"v"`
+	// Throttle frames say when the session may send again
+	RetryAfterMs int64 `json:"retryAfterMs"`
+	// Snapshot frames carry the document's content at Version
+	Version int64 `json:"version"`
+	Content struct {
+		Slides json.RawMessage `json:"slides"`
+	} `json:"content"`
+	// Presence frames of Type "presence" name the Event, and list the
+	// members on joining
+	Event        string            `json:"event"`
+	Participants []wireParticipant `json:"participants"`
+}
+
+// frameType is the type of a server frame. Broadcast updates carry their
+// numeric websocket message type under the same key, which reads as "".
+type frameType string
+
+func (t *frameType) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		*t = frameType(s)
+	}
+	return nil
+}
+
+type wireParticipant struct {
+	UserID   string `json:"userId"`
+	Username string `json:"username"`
+}
+
+// actionBody decodes the fields of a broadcast body the session looks at.
+type actionBody struct {
+	Action            string     `json:"action"`
+	SlideID           string     `json:"slideId"`
+	ObjectID          string     `json:"objectId"`
+	NewCursorLocation [2]float64 `json:"newCursorLocation"`
+
+	// Cursor frames are typed, with the position in X and Y
+	Type      string   `json:"type"`
+	X         float64  `json:"x"`
+	Y         float64  `json:"y"`
+	Selection []string `json:"selection"`
+	Removed   bool     `json:"removed"`
+}
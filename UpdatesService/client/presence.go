@@ -0,0 +1,83 @@
+package client
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// presenceTable keeps the last known state of every peer. Peers leave when
+// the server announces it; peers only known from their messages, not heard
+// from for ttl, are dropped.
+type presenceTable struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	peers map[string]*Peer
+}
+
+func newPresenceTable(ttl time.Duration) *presenceTable {
+	return &presenceTable{ttl: ttl, peers: map[string]*Peer{}}
+}
+
+func (t *presenceTable) apply(e PresenceEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e.Action == PresenceLeave {
+		delete(t.peers, e.UserID)
+		return
+	}
+
+	peer, ok := t.peers[e.UserID]
+	if !ok {
+		peer = &Peer{UserID: e.UserID}
+		t.peers[e.UserID] = peer
+	}
+	peer.Username = e.Username
+	peer.LastSeen = time.Now()
+
+	switch e.Action {
+	case PresenceJoin:
+		peer.Present = true
+	case ActionCursorMove:
+		peer.SlideID = e.SlideID
+		peer.Cursor = e.Cursor
+	case ActionCursor:
+		peer.SlideID = e.SlideID
+		peer.Cursor = e.Cursor
+		peer.Selection = e.Selection
+		if e.Removed {
+			peer.SlideID, peer.Cursor, peer.Selection = "", [2]float64{}, nil
+		}
+	case ActionSelect:
+		peer.SlideID = e.SlideID
+		peer.Selected = e.ObjectID
+	case ActionDeselect:
+		if peer.Selected == e.ObjectID {
+			peer.Selected = ""
+		}
+	}
+}
+
+func (t *presenceTable) snapshot() []Peer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := time.Now().Add(-t.ttl)
+	peers := make([]Peer, 0, len(t.peers))
+	for id, peer := range t.peers {
+		if !peer.Present && peer.LastSeen.Before(cutoff) {
+			delete(t.peers, id)
+			continue
+		}
+		peers = append(peers, *peer)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].UserID < peers[j].UserID })
+	return peers
+}
+
+func (t *presenceTable) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.peers = map[string]*Peer{}
+}
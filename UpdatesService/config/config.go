@@ -0,0 +1,257 @@
+package config
+
+import (
+	"os"
+	"shared/content"
+	"shared/cors"
+	"shared/events"
+	"shared/secrets"
+	"strconv"
+	"time"
+)
+
+// RedisConfigStruct locates Redis. Each document's latest edits are kept in
+// a stream there, for reconnecting clients to catch up from: about
+// UpdatesStreamMaxLen of them, until UpdatesStreamTTL after the last.
+type RedisConfigStruct struct {
+	Addr                string
+	Password            string
+	UpdatesStreamMaxLen int64
+	UpdatesStreamTTL    time.Duration
+}
+
+var RedisConfig = RedisConfigStruct{
+	Addr:                getEnv("REDIS_ADDR", "canvas-live-redis:6379"),
+	UpdatesStreamMaxLen: int64(getEnvInt("REDIS_UPDATES_STREAM_MAXLEN", 1000)),
+	UpdatesStreamTTL:    getEnvDuration("REDIS_UPDATES_STREAM_TTL", 24*time.Hour),
+}
+
+// KafkaConfigStruct locates the broker and topic updates are pushed to. Both
+// come from the environment so test harnesses can point the service at a
+// throwaway broker.
+type KafkaConfigStruct struct {
+	Broker       string
+	UpdatesTopic string
+	// A failed produce is retried by librdkafka up to ProduceRetries times,
+	// RetryBackoff apart, and reported as failed once they are used up or
+	// DeliveryTimeout passed since it was produced, whichever comes first,
+	// so an outage surfaces as errors instead of stalls.
+	DeliveryTimeout time.Duration
+	ProduceRetries  int
+	RetryBackoff    time.Duration
+	// DocumentEventsTopic carries document locks. Every instance reads it in a
+	// group of its own, named after its host unless DocumentEventsGroup is set.
+	DocumentEventsTopic string
+	DocumentEventsGroup string
+	// While the producer's queue is full, the read loop of the client
+	// sending an update pauses for QueueFullPause before trying again, up to
+	// ProduceAttempts times.
+	ProduceAttempts int
+	QueueFullPause  time.Duration
+}
+
+var KafkaConfig = KafkaConfigStruct{
+	Broker:              getEnv("KAFKA_BROKER", "canvas-live-kafka:9092"),
+	UpdatesTopic:        getEnv("KAFKA_UPDATES_TOPIC", "document-updates"),
+	DeliveryTimeout:     getEnvDuration("KAFKA_DELIVERY_TIMEOUT", 10*time.Second),
+	ProduceRetries:      getEnvInt("KAFKA_PRODUCE_RETRIES", 10),
+	RetryBackoff:        getEnvDuration("KAFKA_RETRY_BACKOFF", 500*time.Millisecond),
+	DocumentEventsTopic: getEnv("KAFKA_DOCUMENT_EVENTS_TOPIC", events.TopicDocumentEvents),
+	DocumentEventsGroup: getEnv("KAFKA_DOCUMENT_EVENTS_GROUP", "updates-service-"+hostname()),
+	ProduceAttempts:     getEnvInt("KAFKA_PRODUCE_ATTEMPTS", 5),
+	QueueFullPause:      getEnvDuration("KAFKA_QUEUE_FULL_PAUSE", 50*time.Millisecond),
+}
+
+// AuthServiceConfigStruct locates the AuthService used to validate websocket
+// tokens. With VerifyLocally, RS256 tokens are checked against AuthService's
+// published keys instead of calling /auth/authenticate per connection; that
+// skips the revocation list, so logged-out tokens work until they expire.
+type AuthServiceConfigStruct struct {
+	URL           string
+	Timeout       time.Duration
+	VerifyLocally bool
+	TokenLeeway   time.Duration
+}
+
+var AuthServiceConfig = AuthServiceConfigStruct{
+	URL:           getEnv("AUTH_SERVICE_URL", "http://auth-service:8081"),
+	Timeout:       getEnvDuration("AUTH_SERVICE_TIMEOUT", 5*time.Second),
+	VerifyLocally: getEnv("AUTH_VERIFY_LOCALLY", "false") == "true",
+	TokenLeeway:   getEnvDuration("TOKEN_LEEWAY", 30*time.Second),
+}
+
+// DocumentServiceConfigStruct locates the DocumentService, asked for the
+// user's access before they join a document's live session, told when they
+// did, and asked for the document's content to start them off with.
+// AccessTimeout bounds the access check, retries included, so a slow
+// DocumentService fails connections instead of holding them; SnapshotTimeout
+// bounds fetching the content, after which the client starts without it.
+type DocumentServiceConfigStruct struct {
+	URL             string
+	Timeout         time.Duration
+	AccessTimeout   time.Duration
+	SnapshotTimeout time.Duration
+}
+
+var DocumentServiceConfig = DocumentServiceConfigStruct{
+	URL:             getEnv("DOCUMENT_SERVICE_URL", "http://document-service:8082"),
+	Timeout:         getEnvDuration("DOCUMENT_SERVICE_TIMEOUT", 3*time.Second),
+	AccessTimeout:   getEnvDuration("DOCUMENT_ACCESS_TIMEOUT", 5*time.Second),
+	SnapshotTimeout: getEnvDuration("DOCUMENT_SNAPSHOT_TIMEOUT", 5*time.Second),
+}
+
+// ContentConfigStruct limits the frames clients send. Larger frames are
+// rejected with a failed acknowledgement, see shared/content.
+type ContentConfigStruct struct {
+	MaxBytes int64
+}
+
+var ContentConfig = ContentConfigStruct{
+	MaxBytes: int64(getEnvInt(content.MaxBytesEnv, content.DefaultMaxBytes)),
+}
+
+// WebSocketConfigStruct sets the heartbeat of live connections. The server
+// pings every PingPeriod; a connection that sends nothing, pongs included,
+// for PongWait is considered dead, closed, and its client unregistered.
+// Writes that take longer than WriteWait fail the same way.
+//
+// SendBuffer is how many messages may wait for a client's connection. When
+// a client falls that far behind, SlowConsumerPolicy applies:
+// SlowConsumerDropOldest drops the oldest waiting message and tells the
+// client to resync, SlowConsumerClose disconnects it.
+type WebSocketConfigStruct struct {
+	PongWait           time.Duration
+	PingPeriod         time.Duration
+	WriteWait          time.Duration
+	SendBuffer         int
+	SlowConsumerPolicy string
+}
+
+// Policies for clients that do not keep up with their room.
+const (
+	SlowConsumerDropOldest = "drop_oldest"
+	SlowConsumerClose      = "close"
+)
+
+var WebSocketConfig = newWebSocketConfig()
+
+func newWebSocketConfig() WebSocketConfigStruct {
+	pongWait := getEnvDuration("WS_PONG_WAIT", 60*time.Second)
+	pingPeriod := getEnvDuration("WS_PING_PERIOD", pongWait*9/10)
+	// A ping must be able to come back before the deadline
+	if pingPeriod <= 0 || pingPeriod >= pongWait {
+		pingPeriod = pongWait * 9 / 10
+	}
+	policy := getEnv("WS_SLOW_CONSUMER_POLICY", SlowConsumerDropOldest)
+	if policy != SlowConsumerClose {
+		policy = SlowConsumerDropOldest
+	}
+	return WebSocketConfigStruct{
+		PongWait:           pongWait,
+		PingPeriod:         pingPeriod,
+		WriteWait:          getEnvDuration("WS_WRITE_WAIT", 10*time.Second),
+		SendBuffer:         getEnvInt("WS_SEND_BUFFER", 256),
+		SlowConsumerPolicy: policy,
+	}
+}
+
+// RateLimitConfigStruct limits the frames each client may send, with token
+// buckets of Burst frames refilled at Rate a second: one for edits, one for
+// cursor and presence frames. Edits over the limit are refused with a
+// throttle frame, cursor frames dropped. A client whose bucket stays
+// exhausted for longer than AbuseAfter is disconnected.
+type RateLimitConfigStruct struct {
+	EditRate    float64
+	EditBurst   int
+	CursorRate  float64
+	CursorBurst int
+	AbuseAfter  time.Duration
+}
+
+var RateLimitConfig = RateLimitConfigStruct{
+	EditRate:    getEnvFloat("WS_EDIT_RATE", 20),
+	EditBurst:   getEnvInt("WS_EDIT_BURST", 40),
+	CursorRate:  getEnvFloat("WS_CURSOR_RATE", 60),
+	CursorBurst: getEnvInt("WS_CURSOR_BURST", 120),
+	AbuseAfter:  getEnvDuration("WS_RATE_ABUSE_AFTER", 10*time.Second),
+}
+
+// ServerConfigStruct controls the HTTP server. On SIGTERM /ready starts
+// failing and new connections are refused at once; after DrainDelay, time for
+// the load balancer to notice, live connections are closed as going away and
+// get up to CloseGrace to go. The whole shutdown, Kafka producer flush
+// included, is bounded by ShutdownTimeout.
+type ServerConfigStruct struct {
+	Addr            string
+	DrainDelay      time.Duration
+	CloseGrace      time.Duration
+	ShutdownTimeout time.Duration
+}
+
+var ServerConfig = ServerConfigStruct{
+	Addr:            getEnv("SERVER_ADDR", ":8083"),
+	DrainDelay:      getEnvDuration("SHUTDOWN_DRAIN_DELAY", 0),
+	CloseGrace:      getEnvDuration("SHUTDOWN_CLOSE_GRACE", 5*time.Second),
+	ShutdownTimeout: getEnvDuration("SHUTDOWN_TIMEOUT", 15*time.Second),
+}
+
+// CORSConfig admits browser clients served from other origins, configured
+// with CORS_ALLOWED_ORIGINS and related variables (see cors.FromEnv).
+var CORSConfig = cors.FromEnv()
+
+// Secrets accept a NAME_FILE variant pointing at a mounted secret file.
+var Secrets = secrets.NewSet()
+
+var (
+	RedisPassword = Secrets.Add(secrets.Spec{Name: "REDIS_PASSWORD"})
+
+	// InternalHMACKey signs service-to-service requests. Reloaded on SIGHUP.
+	InternalHMACKey = Secrets.Add(secrets.Spec{Name: "INTERNAL_HMAC_KEY", Reloadable: true})
+)
+
+// Load reads every secret and applies the non-reloadable ones to the static config.
+func Load() error {
+	if err := Secrets.Load(); err != nil {
+		return err
+	}
+
+	RedisConfig.Password = RedisPassword.Get()
+	return nil
+}
+
+func getEnv(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return strconv.Itoa(os.Getpid())
+	}
+	return name
+}
+
+func getEnvInt(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+	return fallback
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil && value > 0 {
+		return value
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
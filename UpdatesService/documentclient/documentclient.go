@@ -0,0 +1,124 @@
+// Package documentclient calls DocumentService on behalf of UpdatesService,
+// with the credentials the user connected with.
+package documentclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"shared/httpclient"
+)
+
+// Access levels a user can have to a document.
+const (
+	AccessOwner = "owner"
+	AccessWrite = "write"
+	AccessRead  = "read"
+)
+
+var (
+	// ErrDocumentNotFound is returned for documents that do not exist, or
+	// that DocumentService hides from the user.
+	ErrDocumentNotFound = errors.New("document not found")
+	// ErrAccessDenied is returned when the user may not see the document.
+	ErrAccessDenied = errors.New("access to the document denied")
+	// ErrInvalidDocumentID is returned for malformed document IDs.
+	ErrInvalidDocumentID = errors.New("invalid document ID")
+)
+
+// Client calls DocumentService as the connecting user.
+type Client struct {
+	http *httpclient.Client
+}
+
+func New(client *httpclient.Client) *Client {
+	return &Client{http: client}
+}
+
+// Credentials returns the headers that authenticate the user with
+// DocumentService: the token they connected with, or their cookies when
+// there was none.
+func Credentials(r *http.Request, token string) http.Header {
+	header := http.Header{}
+	switch {
+	case token != "":
+		header.Set("Authorization", "Bearer "+token)
+	case r.Header.Get("Cookie") != "":
+		header.Set("Cookie", r.Header.Get("Cookie"))
+	}
+	return header
+}
+
+// RecordOpened tells DocumentService the user opened documentId, for their
+// recently opened documents.
+func (c *Client) RecordOpened(ctx context.Context, documentId string, credentials http.Header) error {
+	_, err := c.http.Post(ctx, "/document/"+url.PathEscape(documentId)+"/opened", nil, credentials)
+	return err
+}
+
+// AccessLevel returns the access the user has to documentId: AccessOwner,
+// AccessWrite, or AccessRead. It returns ErrDocumentNotFound, ErrAccessDenied,
+// or ErrInvalidDocumentID when DocumentService refuses the user.
+func (c *Client) AccessLevel(ctx context.Context, documentId string, credentials http.Header) (string, error) {
+	response, err := c.http.Get(ctx, "/document/"+url.PathEscape(documentId)+"/access", credentials)
+	if err != nil {
+		return "", refusal(err)
+	}
+
+	var body struct {
+		AccessLevel string `json:"accessLevel"`
+	}
+	if err := json.Unmarshal(response.Body, &body); err != nil {
+		return "", fmt.Errorf("decoding access level: %w", err)
+	}
+	switch body.AccessLevel {
+	case AccessOwner, AccessWrite, AccessRead:
+		return body.AccessLevel, nil
+	}
+	return "", fmt.Errorf("unknown access level %q", body.AccessLevel)
+}
+
+// Snapshot is a document's content at a version. Seq is the place in the
+// document's update stream of the last live update the content has, "" when
+// it has none, and Applied those of the latest live updates it has, in the
+// order they were applied.
+type Snapshot struct {
+	Version int64           `json:"version"`
+	Slides  json.RawMessage `json:"slides"`
+	Seq     string          `json:"seq"`
+	Applied []string        `json:"applied"`
+}
+
+// Snapshot returns the current content of documentId. DocumentService reads
+// it from its Redis cache when the document is there, from MongoDB
+// otherwise, and does not count it as the user opening the document. It
+// fails like AccessLevel when DocumentService refuses the user.
+func (c *Client) Snapshot(ctx context.Context, documentId string, credentials http.Header) (*Snapshot, error) {
+	response, err := c.http.Get(ctx, "/document/"+url.PathEscape(documentId)+"/snapshot", credentials)
+	if err != nil {
+		return nil, refusal(err)
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(response.Body, &snapshot); err != nil {
+		return nil, fmt.Errorf("decoding document: %w", err)
+	}
+	return &snapshot, nil
+}
+
+// refusal returns the error for DocumentService refusing the user, or err
+// when it failed otherwise.
+func refusal(err error) error {
+	switch httpclient.StatusCode(err) {
+	case http.StatusNotFound:
+		return ErrDocumentNotFound
+	case http.StatusForbidden:
+		return ErrAccessDenied
+	case http.StatusBadRequest:
+		return ErrInvalidDocumentID
+	}
+	return err
+}
@@ -0,0 +1,121 @@
+package documentclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"shared/httpclient"
+	"testing"
+	"time"
+)
+
+// testClient returns a client of a DocumentService served by handler.
+func testClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return New(httpclient.New(httpclient.Config{Service: "document-service", BaseURL: server.URL, Timeout: time.Second}))
+}
+
+func TestAccessLevel(t *testing.T) {
+	var gotPath, gotAuthorization string
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuthorization = r.URL.Path, r.Header.Get("Authorization")
+		w.Write([]byte(`{"accessLevel":"read"}`))
+	})
+
+	level, err := client.AccessLevel(context.Background(), "doc-1", http.Header{"Authorization": {"Bearer secret-token"}})
+	if err != nil || level != AccessRead {
+		t.Fatalf("AccessLevel = %q, %v, want read", level, err)
+	}
+	if gotPath != "/document/doc-1/access" || gotAuthorization != "Bearer secret-token" {
+		t.Errorf("asked %s as %q, want /document/doc-1/access as the user", gotPath, gotAuthorization)
+	}
+}
+
+func TestAccessLevelRefusals(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{"not found", http.StatusNotFound, `{}`, ErrDocumentNotFound},
+		{"forbidden", http.StatusForbidden, `{}`, ErrAccessDenied},
+		{"bad ID", http.StatusBadRequest, `{}`, ErrInvalidDocumentID},
+	}
+	for _, tt := range tests {
+		client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.body))
+		})
+		if _, err := client.AccessLevel(context.Background(), "doc-1", http.Header{}); !errors.Is(err, tt.want) {
+			t.Errorf("%s: AccessLevel error %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestAccessLevelFailuresAreNotRefusals(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"server error", http.StatusInternalServerError, `{}`},
+		{"unauthorized", http.StatusUnauthorized, `{}`},
+		{"not JSON", http.StatusOK, `<html>`},
+		{"unknown level", http.StatusOK, `{"accessLevel":"admin"}`},
+		{"no level", http.StatusOK, `{}`},
+	}
+	for _, tt := range tests {
+		client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.body))
+		})
+		level, err := client.AccessLevel(context.Background(), "doc-1", http.Header{})
+		if err == nil {
+			t.Errorf("%s: AccessLevel = %q, want an error", tt.name, level)
+			continue
+		}
+		for _, refusal := range []error{ErrDocumentNotFound, ErrAccessDenied, ErrInvalidDocumentID} {
+			if errors.Is(err, refusal) {
+				t.Errorf("%s: AccessLevel error %v is a refusal", tt.name, err)
+			}
+		}
+	}
+}
+
+func TestAccessLevelIsBoundedByTheContext(t *testing.T) {
+	release := make(chan struct{})
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	t.Cleanup(func() { close(release) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.AccessLevel(ctx, "doc-1", http.Header{}); err == nil {
+		t.Fatal("AccessLevel succeeded against a stalled DocumentService")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("gave up after %s, want about the context's deadline", elapsed)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	withCookie := httptest.NewRequest(http.MethodGet, "/updates/ws/docId/doc-1", nil)
+	withCookie.Header.Set("Cookie", "session=abc")
+	bare := httptest.NewRequest(http.MethodGet, "/updates/ws/docId/doc-1", nil)
+
+	if got := Credentials(withCookie, "token"); got.Get("Authorization") != "Bearer token" || got.Get("Cookie") != "" {
+		t.Errorf("with a token: %v, want the bearer token only", got)
+	}
+	if got := Credentials(withCookie, ""); got.Get("Cookie") != "session=abc" || got.Get("Authorization") != "" {
+		t.Errorf("without a token: %v, want the cookies", got)
+	}
+	if got := Credentials(bare, ""); len(got) != 0 {
+		t.Errorf("without either: %v, want none", got)
+	}
+}
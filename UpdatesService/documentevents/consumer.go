@@ -0,0 +1,85 @@
+// Package documentevents consumes the document events that change what live
+// sessions may do, currently document locks.
+package documentevents
+
+import (
+	"UpdatesService/websocket"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"shared/events"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// Consumer reads the document events topic and applies locks to the pool's
+// rooms. Every instance must see every lock, so each reads with a group of its
+// own; it starts at the newest events and learns only of locks made while it
+// runs. The DocumentUpdatesConsumer drops updates to locked documents either
+// way, so a missed lock costs live feedback, not content.
+type Consumer struct {
+	consumer   *kafka.Consumer
+	dispatcher *events.Dispatcher
+}
+
+func NewConsumer(consumer *kafka.Consumer, pool *websocket.Pool) *Consumer {
+	dispatcher := events.NewDispatcher().
+		Handle(events.DocumentLocked, func(ctx context.Context, env events.Envelope, e events.Event) error {
+			locked := e.(events.DocumentLockedEvent)
+			pool.SetLocked(locked.TenantID, locked.DocumentID, true)
+			return nil
+		}).
+		Handle(events.DocumentUnlocked, func(ctx context.Context, env events.Envelope, e events.Event) error {
+			unlocked := e.(events.DocumentUnlockedEvent)
+			pool.SetLocked(unlocked.TenantID, unlocked.DocumentID, false)
+			return nil
+		}).
+		Ignore(
+			events.DocumentCreated,
+			events.DocumentShared,
+			events.DocumentDeleted,
+			events.DocumentTrashed,
+			events.DocumentRestored,
+			events.DocumentRenamed,
+			events.OwnershipTransferred,
+			events.ContentReplaced,
+			events.ShareRevoked,
+		)
+
+	return &Consumer{consumer: consumer, dispatcher: dispatcher}
+}
+
+// Run subscribes to topic and handles events until ctx is cancelled.
+func (c *Consumer) Run(ctx context.Context, topic string) error {
+	if missing := c.dispatcher.Unhandled(topic); len(missing) > 0 {
+		return fmt.Errorf("no handler registered for %v", missing)
+	}
+
+	if err := c.consumer.SubscribeTopics([]string{topic}, nil); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", topic, err)
+	}
+	log.Printf("[DocumentEvents] Subscribed to %s", topic)
+
+	for ctx.Err() == nil {
+		ev := c.consumer.Poll(100)
+
+		switch e := ev.(type) {
+		case *kafka.Message:
+			// Handlers only update in-memory state, so there is nothing to retry
+			err := c.dispatcher.Dispatch(ctx, e.Value)
+			if errors.Is(err, events.ErrMalformedEvent) || errors.Is(err, events.ErrUnknownEvent) || errors.Is(err, events.ErrInvalidEvent) {
+				log.Printf("[DocumentEvents] Skipping undecodable event at %v: %v", e.TopicPartition, err)
+			} else if err != nil {
+				log.Printf("[DocumentEvents] Handling event at %v failed: %v", e.TopicPartition, err)
+			}
+		case kafka.Error:
+			log.Printf("[DocumentEvents] Kafka error: %v (Code: %d)", e, e.Code())
+			if e.IsFatal() {
+				return e
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package documentevents
+
+import (
+	"UpdatesService/websocket"
+	"context"
+	"encoding/json"
+	"shared/events"
+	"testing"
+	"time"
+)
+
+func dispatch(t *testing.T, c *Consumer, e events.Event) {
+	t.Helper()
+	env, err := events.Encode(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, _ := json.Marshal(env)
+	if err := c.dispatcher.Dispatch(context.Background(), value); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEveryDocumentEventIsRegistered(t *testing.T) {
+	c := NewConsumer(nil, websocket.NewPool(nil))
+	if missing := c.dispatcher.Unhandled(events.TopicDocumentEvents); len(missing) != 0 {
+		t.Fatalf("no handler registered for %v", missing)
+	}
+}
+
+func TestLockEventsReachThePool(t *testing.T) {
+	pool := websocket.NewPool(nil)
+	go pool.Start()
+	c := NewConsumer(nil, pool)
+	now := time.Now()
+
+	dispatch(t, c, events.DocumentLockedEvent{DocumentID: "doc-1", TenantID: "acme", LockedBy: "u-1", LockedAt: now, OccurredAt: now})
+	if !pool.IsLocked("acme", "doc-1") {
+		t.Fatal("document not locked")
+	}
+	if pool.IsLocked("other", "doc-1") {
+		t.Fatal("lock leaked into another tenant")
+	}
+
+	dispatch(t, c, events.DocumentUnlockedEvent{DocumentID: "doc-1", TenantID: "acme", UnlockedBy: "u-1", OccurredAt: now})
+	if pool.IsLocked("acme", "doc-1") {
+		t.Fatal("document still locked")
+	}
+}
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"UpdatesService/websocket"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HealthHandler answers the orchestrator's probes.
+type HealthHandler struct {
+	Pool *websocket.Pool
+}
+
+// ReadinessResponse says why the instance is not ready, when it is not.
+type ReadinessResponse struct {
+	Status string `json:"status"`
+}
+
+// Health reports that the process is up.
+func (h HealthHandler) Health(c *gin.Context) {
+	c.JSON(http.StatusOK, ReadinessResponse{Status: "ok"})
+}
+
+// Ready returns 200 while the instance accepts connections, and 503 once it
+// is shutting down, so the load balancer stops routing to it.
+func (h HealthHandler) Ready(c *gin.Context) {
+	if !h.Pool.Accepting() {
+		c.JSON(http.StatusServiceUnavailable, ReadinessResponse{Status: "shutting down"})
+		return
+	}
+	c.JSON(http.StatusOK, ReadinessResponse{Status: "ok"})
+}
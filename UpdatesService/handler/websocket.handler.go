@@ -1,112 +1,99 @@
 package handler
 
 import (
+	"UpdatesService/config"
+	"UpdatesService/documentclient"
+	"UpdatesService/metrics"
 	"UpdatesService/redis"
+	"UpdatesService/types"
 	"UpdatesService/websocket"
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"shared/apierror"
+	"shared/authmw"
+	"shared/httpclient"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// =============================== Helper Functions ========================================
+// Documents is the part of DocumentService the handler relies on;
+// documentclient.Client implements it.
+type Documents interface {
+	AccessLevel(ctx context.Context, documentId string, credentials http.Header) (string, error)
+	RecordOpened(ctx context.Context, documentId string, credentials http.Header) error
+	Snapshot(ctx context.Context, documentId string, credentials http.Header) (*documentclient.Snapshot, error)
+}
 
+// Where WsHandler found a connection's token, in the order it looks.
 const (
-	authServiceURL = "http://auth-service:8081/auth/authenticate" // Adjust to your auth service
+	tokenFromHeader      = "header"
+	tokenFromSubprotocol = "subprotocol"
+	tokenFromPath        = "path"
 )
 
-// UserInfo holds authenticated user data
-type UserInfo struct {
-	UserID   string
-	Username string
-}
-
-// authenticateToken validates JWT token by calling auth service
-func authenticateToken(token string) (*UserInfo, error) {
-	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: 5 * time.Second,
-	}
-
-	// Create request to auth service
-	req, err := http.NewRequest("GET", authServiceURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create auth request: %w", err)
-	}
-
-	// Add Authorization header
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-
-	log.Printf("Authenticating token with auth service...")
-
-	// Send request
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to reach auth service: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Check status code
-	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("authentication failed: %s", string(body))
-	}
-
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("auth endpoint not found (404)")
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
-	}
-
-	// Extract user info from response headers
-	userID := resp.Header.Get("X-User-ID")
-	username := resp.Header.Get("X-Username")
-
-	if userID == "" {
-		return nil, fmt.Errorf("auth service did not return X-User-ID header")
-	}
-
-	log.Printf("Authentication successful for user: %s (%s)", username, userID)
-
-	return &UserInfo{
-		UserID:   userID,
-		Username: username,
-	}, nil
-}
-
-func WsHandler(pool *websocket.Pool, redis_client *redis.RedisClient) gin.HandlerFunc {
+// WsHandler authenticates the connection with its token, see
+// connectionToken, or the session cookie when there is none, and asks
+// DocumentService for the user's access to the document before upgrading it: users who may not see the
+// document get a 403, or a 404 when it does not exist. Joining counts as
+// opening the document, which DocumentService is told about in the background.
+// Once in the room, the client is sent the document's content before any of
+// the updates to it, see websocket.Client.WriteSnapshot. Clients reconnecting
+// pass the seq of the last update they got as ?since= instead, and are sent
+// the updates they missed, or told to resync and sent the content when those
+// are gone.
+func WsHandler(pool *websocket.Pool, redis_client *redis.RedisClient, auth authmw.Authenticator, documents Documents) gin.HandlerFunc {
 	// Return a Gin handler function
 	return func(c *gin.Context) {
+		// Clients are sent elsewhere while the instance shuts down
+		if !pool.Accepting() {
+			apierror.Abort(c, http.StatusServiceUnavailable, apierror.CodeShuttingDown, "The server is shutting down - Reconnect to continue.")
+			return
+		}
+
 		docId := c.Param("docId")
-		jwtToken := c.Param("token")
 		if docId == "" {
-			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "documentId missing"})
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "documentId missing")
+			return
+		}
+		jwtToken, source := connectionToken(c)
+		since := c.Query("since")
+		if since != "" && !redis.ValidStreamID(since) {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "since must be the seq of an update")
 			return
 		}
+
+		// Tokens in the path end up in the logs of every proxy on the way; the
+		// route stays for one release, and the token out of our own logs
+		var responseHeader http.Header
+		if source == tokenFromPath {
+			c.Request.URL.Path = strings.Replace(c.Request.URL.Path, jwtToken, "REDACTED", 1)
+			c.Request.URL.RawPath = ""
+			log.Printf("[WsHandler] Deprecated: token passed in the URL path for document %s; pass it in the Authorization header or the %q subprotocol instead", docId, websocket.TokenProtocol)
+			responseHeader = http.Header{"Deprecation": {"true"}}
+		}
 		// 1. Authentication Check (Using c.Request)
-		// Access header directly from the raw http.Request object
-		userInfo, err := authenticateToken(jwtToken)
-		if err != nil {
-			fmt.Printf("[WsHandler][Error] %v", err)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization failed"})
+		if !authmw.Authenticate(c, auth, jwtToken) {
 			return
 		}
+		userInfo := authmw.IdentityFrom(c)
 		userId := userInfo.UserID
 		username := userInfo.Username
-		if userId == "" {
-			// Use Gin's method to send HTTP error response before upgrade
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization required"})
+		log.Printf("Authentication successful for user: %s (%s)", username, userId)
+
+		// 2. Authorization Check, before anything of the document is sent
+		credentials := documentclient.Credentials(c.Request, jwtToken)
+		accessLevel, ok := authorize(c, documents, docId, credentials)
+		if !ok {
 			return
 		}
 
-		// 2. Perform WebSocket Upgrade (Using c.Writer and c.Request)
-		conn, err := websocket.Upgrade(c.Writer, c.Request)
+		// 3. Perform WebSocket Upgrade (Using c.Writer and c.Request)
+		conn, err := websocket.Upgrade(c.Writer, c.Request, responseHeader)
 		if err != nil {
 			// Log error after upgrade attempt, as headers may already be sent
 			log.Printf("WebSocket Upgrade Failed: %v", err)
@@ -114,22 +101,141 @@ func WsHandler(pool *websocket.Pool, redis_client *redis.RedisClient) gin.Handle
 			return
 		}
 
-		// 3. Initialize and Register Client
+		// Record the open without holding up the session
+		ctx := context.WithoutCancel(httpclient.ContextFromRequest(c.Request.Context(), c.Request))
+		go func() {
+			if err := documents.RecordOpened(ctx, docId, credentials); err != nil {
+				log.Printf("[WsHandler] Error recording open of %s by %s: %v", docId, userId, err)
+			}
+		}()
+
+		// 4. Initialize and Register Client
 		client := &websocket.Client{
 			UserID:      userId,
 			Username:    username,
+			TenantID:    userInfo.TenantID,
 			DocumentID:  docId, // Ensure this is correctly retrieved or set
+			AccessLevel: accessLevel,
 			Conn:        conn,
 			Pool:        pool,
-			Send:        make(chan []byte),
+			Send:        make(chan []byte, config.WebSocketConfig.SendBuffer),
 			RedisClient: redis_client,
 		}
 
+		// Updates broadcast from here on wait in Send while the snapshot, or
+		// the updates missed since the client's last, are fetched, and the
+		// writer sends them after those
+		pool.Register <- client
+		if since == "" || !replay(c, client, since) {
+			sendSnapshot(c, client, documents, credentials)
+		}
+
 		fmt.Println("[WsHandler] client reader running!")
 		go client.Writer() // Start a goroutine responsible for send message(it receives via Send channel) to the client
 		fmt.Println("[WsHandler] client Writer running!")
 
-		pool.Register <- client
 		client.Read() // Start the client's read loop
 	}
 }
+
+// sendSnapshot sends a client that just joined the document's current
+// content, followed by the updates kept in the document's stream that the
+// content does not have yet: DocumentService has those once the consumer
+// applied them, which may be after the client registered. When the content
+// cannot be fetched the client starts without, and must fetch the document
+// itself as before.
+func sendSnapshot(c *gin.Context, client *websocket.Client, documents Documents, credentials http.Header) {
+	ctx, cancel := context.WithTimeout(httpclient.ContextFromRequest(c.Request.Context(), c.Request), config.DocumentServiceConfig.SnapshotTimeout)
+	defer cancel()
+
+	snapshot, err := documents.Snapshot(ctx, client.DocumentID, credentials)
+	if err != nil {
+		metrics.SnapshotFailures.Add(1)
+		log.Printf("[WsHandler] Error fetching snapshot of %s for %s: %v", client.DocumentID, client.UserID, err)
+		return
+	}
+	frame := types.SnapshotFrame{Version: snapshot.Version, Seq: snapshot.Seq, Content: types.SnapshotContent{Slides: snapshot.Slides}}
+	if err := client.WriteSnapshot(frame); err != nil {
+		metrics.SnapshotFailures.Add(1)
+		log.Printf("[WsHandler] Error sending snapshot of %s to %s: %v", client.DocumentID, client.UserID, err)
+		return
+	}
+
+	catchUpCtx, cancelCatchUp := context.WithTimeout(c.Request.Context(), replayTimeout)
+	defer cancelCatchUp()
+	if err := client.CatchUp(catchUpCtx, snapshot.Applied); err != nil {
+		metrics.StreamFailures.Add(1)
+		log.Printf("[WsHandler] Error sending updates of %s after its snapshot to %s: %v", client.DocumentID, client.UserID, err)
+	}
+}
+
+// replayTimeout bounds reading the missed updates from Redis, after which
+// a reconnecting client gets a snapshot instead, and one that got a snapshot
+// only the updates broadcast since it registered.
+const replayTimeout = 2 * time.Second
+
+// replay sends a reconnecting client the updates it missed since the one
+// with ID since, and reports whether it did. Otherwise the client is told to
+// resync, for the snapshot that follows.
+func replay(c *gin.Context, client *websocket.Client, since string) bool {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), replayTimeout)
+	defer cancel()
+
+	err := client.Replay(ctx, since)
+	if err == nil {
+		return true
+	}
+	reason := types.ResyncHistoryTrimmed
+	if !errors.Is(err, redis.ErrTrimmed) {
+		metrics.StreamFailures.Add(1)
+		log.Printf("[WsHandler] Error replaying updates of %s for %s: %v", client.DocumentID, client.UserID, err)
+		reason = types.ResyncHistoryUnavailable
+	}
+	if err := client.WriteResync(reason); err != nil {
+		log.Printf("[WsHandler] Error sending resync to %s: %v", client.UserID, err)
+	}
+	return false
+}
+
+// connectionToken returns the token the connection authenticates with, and
+// where it was found: the Authorization header, set by non-browser clients;
+// the subprotocol offered after websocket.TokenProtocol, by browsers; or,
+// deprecated, the path. It returns "" when there is none, for the session
+// cookie to be used.
+func connectionToken(c *gin.Context) (string, string) {
+	if token := authmw.BearerToken(c.Request); token != "" {
+		return token, tokenFromHeader
+	}
+	if token := websocket.SubprotocolToken(c.Request); token != "" {
+		return token, tokenFromSubprotocol
+	}
+	if token := c.Param("token"); token != "" {
+		return token, tokenFromPath
+	}
+	return "", ""
+}
+
+// authorize returns the user's access to the document, or answers the
+// request itself when they have none or it cannot be checked.
+func authorize(c *gin.Context, documents Documents, docId string, credentials http.Header) (string, bool) {
+	ctx, cancel := context.WithTimeout(httpclient.ContextFromRequest(c.Request.Context(), c.Request), config.DocumentServiceConfig.AccessTimeout)
+	defer cancel()
+
+	accessLevel, err := documents.AccessLevel(ctx, docId, credentials)
+	switch {
+	case errors.Is(err, documentclient.ErrInvalidDocumentID):
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidID, "Invalid document ID")
+		return "", false
+	case errors.Is(err, documentclient.ErrDocumentNotFound):
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeDocumentNotFound, "Document not found")
+		return "", false
+	case errors.Is(err, documentclient.ErrAccessDenied):
+		apierror.Abort(c, http.StatusForbidden, apierror.CodeForbidden, "You do not have access to this document")
+		return "", false
+	case err != nil:
+		log.Printf("[WsHandler] Error checking access to %s: %v", docId, err)
+		apierror.Abort(c, http.StatusServiceUnavailable, apierror.CodeAccessUnavailable, "Document access could not be verified")
+		return "", false
+	}
+	return accessLevel, true
+}
@@ -0,0 +1,747 @@
+package handler
+
+import (
+	"UpdatesService/config"
+	"UpdatesService/documentclient"
+	"UpdatesService/kafkaUtils"
+	"UpdatesService/redis"
+	"UpdatesService/types"
+	"UpdatesService/websocket"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"shared/apierror"
+	"shared/authmw"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/gin-gonic/gin"
+	gorilla "github.com/gorilla/websocket"
+)
+
+// tokenUsers are the users fakeAuth knows, by token.
+var tokenUsers = map[string]string{
+	"from-header":   "hana",
+	"from-protocol": "pablo",
+	"from-path":     "petra",
+	"from-cookie":   "cora",
+	"secret-token":  "sam",
+}
+
+// fakeAuth takes the tokens of tokenUsers, presented or in the session
+// cookie. It keeps the path of the last request it checked.
+type fakeAuth struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (a *fakeAuth) Authenticate(_ context.Context, r *http.Request, token string) (*authmw.Identity, error) {
+	a.mu.Lock()
+	a.path = r.URL.Path
+	a.mu.Unlock()
+	if token == "" {
+		cookie, err := r.Cookie("session")
+		if err != nil {
+			return nil, authmw.ErrUnauthenticated
+		}
+		token = cookie.Value
+	}
+	user, ok := tokenUsers[token]
+	if !ok {
+		return nil, authmw.ErrUnauthenticated
+	}
+	return &authmw.Identity{UserID: user, Username: user, TenantID: "acme"}, nil
+}
+
+func (a *fakeAuth) lastPath() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.path
+}
+
+// fakeDocuments gives users the access access returns, write access to
+// every document while it is nil, to documents whose content is snapshot, or
+// unavailable while it is nil.
+type fakeDocuments struct {
+	access   func(ctx context.Context, documentId string, credentials http.Header) (string, error)
+	snapshot func() (*documentclient.Snapshot, error)
+}
+
+func (d *fakeDocuments) AccessLevel(ctx context.Context, documentId string, credentials http.Header) (string, error) {
+	if d.access == nil {
+		return documentclient.AccessWrite, nil
+	}
+	return d.access(ctx, documentId, credentials)
+}
+
+func (d *fakeDocuments) RecordOpened(context.Context, string, http.Header) error { return nil }
+
+func (d *fakeDocuments) Snapshot(context.Context, string, http.Header) (*documentclient.Snapshot, error) {
+	if d.snapshot == nil {
+		return nil, errors.New("snapshot unavailable")
+	}
+	return d.snapshot()
+}
+
+// discardBackend takes the edits the pool produces without a broker.
+type discardBackend struct {
+	events chan kafka.Event
+}
+
+func (b *discardBackend) Produce(*kafka.Message, chan kafka.Event) error { return nil }
+func (b *discardBackend) Events() chan kafka.Event                       { return b.events }
+
+// wsServer serves both websocket routes from a pool of its own.
+func wsServer(t *testing.T, redisClient *redis.RedisClient, auth authmw.Authenticator, documents Documents) (*httptest.Server, *websocket.Pool) {
+	t.Helper()
+	pool := websocket.NewPool(kafkaUtils.NewProducer(&discardBackend{events: make(chan kafka.Event)}))
+	go pool.Start()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/updates/ws/docId/:docId/token/:token", WsHandler(pool, redisClient, auth, documents))
+	router.GET("/updates/ws/docId/:docId", WsHandler(pool, redisClient, auth, documents))
+	server := httptest.NewServer(router)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		pool.Shutdown(ctx)
+		server.Close()
+	})
+	return server, pool
+}
+
+// awaitParticipants waits until n users joined the document, and returns
+// them.
+func awaitParticipants(t *testing.T, pool *websocket.Pool, documentID string, n int) []types.Participant {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		participants := pool.Participants("acme", documentID)
+		if len(participants) >= n {
+			return participants
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d users joined %s", len(participants), n, documentID)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func wsURL(server *httptest.Server, path string) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http") + path
+}
+
+func TestConnectionTokenPrecedence(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		protocols  string
+		pathToken  string
+		wantToken  string
+		wantSource string
+	}{
+		{"header first", "Bearer from-header", "bearer, from-protocol", "from-path", "from-header", tokenFromHeader},
+		{"subprotocol before path", "", "bearer, from-protocol", "from-path", "from-protocol", tokenFromSubprotocol},
+		{"path last", "", "", "from-path", "from-path", tokenFromPath},
+		{"none leaves the cookie", "", "", "", "", ""},
+		{"other schemes are no token", "Basic YWxpY2U6c2VjcmV0", "", "from-path", "from-path", tokenFromPath},
+		{"bearer without a token", "", "bearer", "", "", ""},
+		{"token only after bearer", "", "from-protocol, bearer", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/updates/ws/docId/doc-1", nil)
+			c.Request.Header.Set("Cookie", "session=from-cookie")
+			if tt.header != "" {
+				c.Request.Header.Set("Authorization", tt.header)
+			}
+			if tt.protocols != "" {
+				c.Request.Header.Set("Sec-WebSocket-Protocol", tt.protocols)
+			}
+			if tt.pathToken != "" {
+				c.Params = gin.Params{{Key: "token", Value: tt.pathToken}}
+			}
+
+			token, source := connectionToken(c)
+			if token != tt.wantToken || source != tt.wantSource {
+				t.Errorf("connectionToken = %q, %q, want %q, %q", token, source, tt.wantToken, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestConnectionAuthenticatesAs(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		header    http.Header
+		protocols []string
+		want      string
+	}{
+		{"header", "/updates/ws/docId/doc-1/token/from-path",
+			http.Header{"Authorization": {"Bearer from-header"}, "Cookie": {"session=from-cookie"}},
+			[]string{websocket.TokenProtocol, "from-protocol"}, "hana"},
+		{"subprotocol", "/updates/ws/docId/doc-1/token/from-path",
+			http.Header{"Cookie": {"session=from-cookie"}},
+			[]string{websocket.TokenProtocol, "from-protocol"}, "pablo"},
+		{"path", "/updates/ws/docId/doc-1/token/from-path",
+			http.Header{"Cookie": {"session=from-cookie"}}, nil, "petra"},
+		{"cookie", "/updates/ws/docId/doc-1",
+			http.Header{"Cookie": {"session=from-cookie"}}, nil, "cora"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, pool := wsServer(t, nil, &fakeAuth{}, &fakeDocuments{})
+			dialer := gorilla.Dialer{Subprotocols: tt.protocols}
+			conn, _, err := dialer.Dial(wsURL(server, tt.path), tt.header)
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer conn.Close()
+
+			participants := awaitParticipants(t, pool, "doc-1", 1)
+			if participants[0].UserID != tt.want {
+				t.Errorf("connected as %s, want %s", participants[0].UserID, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathTokenIsRedacted(t *testing.T) {
+	var logs bytes.Buffer
+	saved := log.Writer()
+	log.SetOutput(&logs)
+	defer log.SetOutput(saved)
+
+	auth := &fakeAuth{}
+	server, _ := wsServer(t, nil, auth, &fakeDocuments{})
+	conn, resp, err := gorilla.DefaultDialer.Dial(wsURL(server, "/updates/ws/docId/doc-1/token/secret-token"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	if got := resp.Header.Get("Deprecation"); got != "true" {
+		t.Errorf("Deprecation header = %q, want true", got)
+	}
+	if path := auth.lastPath(); path != "/updates/ws/docId/doc-1/token/REDACTED" {
+		t.Errorf("request path seen past the handler = %q, want the token redacted", path)
+	}
+	if strings.Contains(logs.String(), "secret-token") {
+		t.Errorf("token logged:\n%s", logs.String())
+	}
+}
+
+func TestSubprotocolIsEchoedWithoutToken(t *testing.T) {
+	server, _ := wsServer(t, nil, &fakeAuth{}, &fakeDocuments{})
+	dialer := gorilla.Dialer{Subprotocols: []string{websocket.TokenProtocol, "secret-token"}}
+	conn, resp, err := dialer.Dial(wsURL(server, "/updates/ws/docId/doc-1"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != websocket.TokenProtocol {
+		t.Errorf("selected subprotocol = %q, want %q", got, websocket.TokenProtocol)
+	}
+	if conn.Subprotocol() != websocket.TokenProtocol {
+		t.Errorf("connection subprotocol = %q, want %q", conn.Subprotocol(), websocket.TokenProtocol)
+	}
+}
+
+// laggingDocuments serves doc-1 as the consumer left it, having applied all
+// but the last lag updates of its stream, the add_slide edits of the test.
+func laggingDocuments(redisClient *redis.RedisClient, lag int) *fakeDocuments {
+	return &fakeDocuments{snapshot: func() (*documentclient.Snapshot, error) {
+		entries, err := redisClient.UpdatesAfter(context.Background(), "doc-1", "")
+		if err != nil {
+			return nil, err
+		}
+		applied := entries[:max(0, len(entries)-lag)]
+
+		snapshot := &documentclient.Snapshot{Version: int64(len(applied))}
+		var slides []map[string]string
+		for _, entry := range applied {
+			var message types.Message
+			json.Unmarshal(entry.Payload, &message)
+			var body map[string]string
+			json.Unmarshal([]byte(message.Body), &body)
+			slides = append(slides, map[string]string{"id": body["slideId"]})
+			snapshot.Seq = entry.ID
+			snapshot.Applied = append(snapshot.Applied, entry.ID)
+		}
+		snapshot.Slides, _ = json.Marshal(slides)
+		return snapshot, nil
+	}}
+}
+
+func addSlide(conn *gorilla.Conn, i int) error {
+	return conn.WriteMessage(gorilla.TextMessage,
+		[]byte(fmt.Sprintf(`{"type":"edit","v":1,"payload":{"action":"add_slide","slideId":"s-%d"}}`, i)))
+}
+
+func TestJoinDuringEditsMissesNothing(t *testing.T) {
+	redisClient := redis.NewRedisClient(miniredis.RunT(t).Addr(), "")
+	server, _ := wsServer(t, redisClient, &fakeAuth{}, laggingDocuments(redisClient, 3))
+	url := wsURL(server, "/updates/ws/docId/doc-1")
+
+	editor, _, err := gorilla.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer from-header"}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer editor.Close()
+	acks := make(chan struct{}, 64)
+	go func() {
+		for {
+			var frame struct {
+				Success bool `json:"success"`
+			}
+			if err := editor.ReadJSON(&frame); err != nil {
+				return
+			}
+			if frame.Success {
+				acks <- struct{}{}
+			}
+		}
+	}()
+
+	// The first edits are in the stream, the last of them not yet applied,
+	// before the joiner registers; the rest are made while it joins
+	const before, total = 10, 30
+	for i := 0; i < before; i++ {
+		if err := addSlide(editor, i); err != nil {
+			t.Fatal(err)
+		}
+		<-acks
+	}
+	go func() {
+		for i := before; i < total; i++ {
+			if addSlide(editor, i) != nil {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	joiner, _, err := gorilla.DefaultDialer.Dial(url, http.Header{"Cookie": {"session=from-cookie"}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer joiner.Close()
+
+	seen := make(map[string]bool)
+	snapshot := false
+	joiner.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(seen) < total {
+		var frame struct {
+			Type    json.RawMessage `json:"type"`
+			Seq     string          `json:"seq"`
+			Body    string          `json:"body"`
+			Content struct {
+				Slides []struct {
+					ID string `json:"id"`
+				} `json:"slides"`
+			} `json:"content"`
+		}
+		if err := joiner.ReadJSON(&frame); err != nil {
+			t.Fatalf("joiner has %d of %d slides: %v", len(seen), total, err)
+		}
+		switch string(frame.Type) {
+		case `"presence"`:
+		case `"snapshot"`:
+			if snapshot || len(seen) > 0 {
+				t.Fatal("snapshot after updates")
+			}
+			snapshot = true
+			if len(frame.Content.Slides) > 0 && frame.Seq == "" {
+				t.Error("snapshot without the seq of its last update")
+			}
+			for _, slide := range frame.Content.Slides {
+				seen[slide.ID] = true
+			}
+		default:
+			if !snapshot {
+				t.Fatalf("update %s before the snapshot", frame.Body)
+			}
+			var body map[string]string
+			if err := json.Unmarshal([]byte(frame.Body), &body); err != nil {
+				t.Fatalf("update with body %q: %v", frame.Body, err)
+			}
+			seen[body["slideId"]] = true
+		}
+	}
+}
+
+// keepUpdates keeps add_slide updates of the given tenant in doc-1's stream
+// of about maxLen updates, as the pool does with the edits it broadcasts, and
+// returns their seqs.
+func keepUpdates(t *testing.T, redisClient *redis.RedisClient, maxLen int64, tenantID string, slideIDs ...string) []string {
+	t.Helper()
+	var seqs []string
+	for _, slideID := range slideIDs {
+		payload, _ := json.Marshal(types.Message{
+			DocumentID: "doc-1", TenantID: tenantID, UserID: "hana", Type: 1,
+			Body: fmt.Sprintf(`{"action":"add_slide","slideId":"%s"}`, slideID),
+		})
+		seq, err := redisClient.AppendUpdate(context.Background(), "doc-1", payload, maxLen, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, seq)
+	}
+	return seqs
+}
+
+// joinFrame is what a client is sent on joining, besides presence frames.
+type joinFrame struct {
+	Type   string
+	Reason string
+	Seq    string
+	Slide  string
+}
+
+// readJoin reads the frames a client is sent on joining, until n of them
+// besides presence frames arrived.
+func readJoin(t *testing.T, conn *gorilla.Conn, n int) []joinFrame {
+	t.Helper()
+	var frames []joinFrame
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for len(frames) < n {
+		var frame struct {
+			Type   json.RawMessage `json:"type"`
+			Reason string          `json:"reason"`
+			Seq    string          `json:"seq"`
+			Body   string          `json:"body"`
+		}
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatalf("got %+v, then: %v", frames, err)
+		}
+		var frameType string
+		if json.Unmarshal(frame.Type, &frameType) != nil {
+			// Updates have a numeric type
+			frameType = "update"
+		}
+		if frameType == "presence" {
+			continue
+		}
+		var body struct {
+			SlideID string `json:"slideId"`
+		}
+		json.Unmarshal([]byte(frame.Body), &body)
+		frames = append(frames, joinFrame{Type: frameType, Reason: frame.Reason, Seq: frame.Seq, Slide: body.SlideID})
+	}
+	return frames
+}
+
+func TestReconnectReplaysSince(t *testing.T) {
+	redisClient := redis.NewRedisClient(miniredis.RunT(t).Addr(), "")
+	var snapshots atomic.Int64
+	documents := &fakeDocuments{snapshot: func() (*documentclient.Snapshot, error) {
+		snapshots.Add(1)
+		return &documentclient.Snapshot{}, nil
+	}}
+	server, _ := wsServer(t, redisClient, &fakeAuth{}, documents)
+
+	seqs := keepUpdates(t, redisClient, 100, "acme", "s-1")
+	// Another tenant's document of the same ID shares the stream
+	keepUpdates(t, redisClient, 100, "globex", "s-x")
+	seqs = append(seqs, keepUpdates(t, redisClient, 100, "acme", "s-2")...)
+
+	conn, _, err := gorilla.DefaultDialer.Dial(wsURL(server, "/updates/ws/docId/doc-1?since="+seqs[0]), http.Header{"Authorization": {"Bearer from-header"}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	frames := readJoin(t, conn, 1)
+	if want := (joinFrame{Type: "update", Seq: seqs[1], Slide: "s-2"}); frames[0] != want {
+		t.Errorf("first frame = %+v, want %+v", frames[0], want)
+	}
+	if n := snapshots.Load(); n != 0 {
+		t.Errorf("%d snapshots fetched for a client that could be replayed to", n)
+	}
+}
+
+func TestReconnectPastTrimmedHistoryResyncs(t *testing.T) {
+	redisClient := redis.NewRedisClient(miniredis.RunT(t).Addr(), "")
+	seqs := keepUpdates(t, redisClient, 2, "acme", "s-1", "s-2", "s-3", "s-4")
+	documents := &fakeDocuments{snapshot: func() (*documentclient.Snapshot, error) {
+		return &documentclient.Snapshot{Version: 3, Slides: json.RawMessage(`[]`), Seq: seqs[2], Applied: seqs[:3]}, nil
+	}}
+	server, _ := wsServer(t, redisClient, &fakeAuth{}, documents)
+
+	// The stream keeps about two updates, not the first
+	conn, _, err := gorilla.DefaultDialer.Dial(wsURL(server, "/updates/ws/docId/doc-1?since="+seqs[0]), http.Header{"Authorization": {"Bearer from-header"}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	frames := readJoin(t, conn, 3)
+	want := []joinFrame{
+		{Type: "resync", Reason: types.ResyncHistoryTrimmed},
+		{Type: "snapshot", Seq: seqs[2]},
+		// The snapshot has not caught up with the last update
+		{Type: "update", Seq: seqs[3], Slide: "s-4"},
+	}
+	for i := range want {
+		if frames[i] != want[i] {
+			t.Errorf("frame %d = %+v, want %+v", i, frames[i], want[i])
+		}
+	}
+}
+
+// TestCatchUpFollowsApplyOrder checks that a joining client is sent the
+// updates its snapshot does not have when the consumer applied them out of
+// the stream's order: an update before the last one applied may still be
+// missing, and one after it may not.
+func TestCatchUpFollowsApplyOrder(t *testing.T) {
+	redisClient := redis.NewRedisClient(miniredis.RunT(t).Addr(), "")
+	seqs := keepUpdates(t, redisClient, 100, "acme", "s-1", "s-2", "s-3", "s-4", "s-5")
+	documents := &fakeDocuments{snapshot: func() (*documentclient.Snapshot, error) {
+		// s-3 was still on its way when s-4 was applied
+		applied := []string{seqs[1], seqs[0], seqs[3]}
+		return &documentclient.Snapshot{Version: 3, Slides: json.RawMessage(`[]`), Seq: seqs[3], Applied: applied}, nil
+	}}
+	server, _ := wsServer(t, redisClient, &fakeAuth{}, documents)
+
+	conn, _, err := gorilla.DefaultDialer.Dial(wsURL(server, "/updates/ws/docId/doc-1"), http.Header{"Authorization": {"Bearer from-header"}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	frames := readJoin(t, conn, 3)
+	want := []joinFrame{
+		{Type: "snapshot", Seq: seqs[3]},
+		{Type: "update", Seq: seqs[2], Slide: "s-3"},
+		{Type: "update", Seq: seqs[4], Slide: "s-5"},
+	}
+	for i := range want {
+		if frames[i] != want[i] {
+			t.Errorf("frame %d = %+v, want %+v", i, frames[i], want[i])
+		}
+	}
+	expectQuiet(t, conn)
+}
+
+// TestCatchUpWithoutAppliedSendsNothing checks that a snapshot that does not
+// say which updates it has, as of a document the consumer has not applied a
+// kept update to, is not followed by the whole stream: it may have all of it.
+func TestCatchUpWithoutAppliedSendsNothing(t *testing.T) {
+	redisClient := redis.NewRedisClient(miniredis.RunT(t).Addr(), "")
+	keepUpdates(t, redisClient, 100, "acme", "s-1", "s-2")
+	documents := &fakeDocuments{snapshot: func() (*documentclient.Snapshot, error) {
+		return &documentclient.Snapshot{Version: 2, Slides: json.RawMessage(`[{"id":"s-1"},{"id":"s-2"}]`)}, nil
+	}}
+	server, _ := wsServer(t, redisClient, &fakeAuth{}, documents)
+
+	conn, _, err := gorilla.DefaultDialer.Dial(wsURL(server, "/updates/ws/docId/doc-1"), http.Header{"Authorization": {"Bearer from-header"}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	if frames := readJoin(t, conn, 1); frames[0].Type != "snapshot" {
+		t.Fatalf("first frame = %+v, want the snapshot", frames[0])
+	}
+	expectQuiet(t, conn)
+}
+
+// expectQuiet fails the test if the connection is sent anything but
+// presence frames for a while.
+func expectQuiet(t *testing.T, conn *gorilla.Conn) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	for {
+		var frame struct {
+			Type json.RawMessage `json:"type"`
+			Seq  string          `json:"seq"`
+		}
+		if err := conn.ReadJSON(&frame); err != nil {
+			return
+		}
+		if string(frame.Type) != `"presence"` {
+			t.Errorf("unexpected frame of type %s, seq %q", frame.Type, frame.Seq)
+		}
+	}
+}
+
+func TestInvalidSinceIsRefused(t *testing.T) {
+	server, pool := wsServer(t, nil, &fakeAuth{}, &fakeDocuments{})
+
+	for _, since := range []string{"abc", "-", "1700000000000", "1-0-0"} {
+		_, resp, err := gorilla.DefaultDialer.Dial(wsURL(server, "/updates/ws/docId/doc-1?since="+since), http.Header{"Authorization": {"Bearer from-header"}})
+		if err == nil {
+			t.Fatalf("since=%s upgraded", since)
+		}
+		if resp == nil || resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("since=%s answered %v, want 400", since, resp)
+		}
+	}
+	if got := pool.Participants("acme", "doc-1"); len(got) != 0 {
+		t.Errorf("participants after refused connections = %v", got)
+	}
+}
+
+// refusedWith returns the status and error code a refused upgrade answered.
+func refusedWith(t *testing.T, resp *http.Response) (int, string) {
+	t.Helper()
+	if resp == nil {
+		t.Fatal("no response to the upgrade")
+	}
+	var body apierror.Response
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body.Error.Code
+}
+
+func TestAccessIsCheckedBeforeUpgrade(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{"not found", documentclient.ErrDocumentNotFound, http.StatusNotFound, apierror.CodeDocumentNotFound},
+		{"denied", documentclient.ErrAccessDenied, http.StatusForbidden, apierror.CodeForbidden},
+		{"invalid ID", documentclient.ErrInvalidDocumentID, http.StatusBadRequest, apierror.CodeInvalidID},
+		{"DocumentService down", errors.New("connection refused"), http.StatusServiceUnavailable, apierror.CodeAccessUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var checked atomic.Int32
+			var gotDocument, gotAuthorization string
+			documents := &fakeDocuments{access: func(_ context.Context, documentId string, credentials http.Header) (string, error) {
+				checked.Add(1)
+				gotDocument, gotAuthorization = documentId, credentials.Get("Authorization")
+				return "", tt.err
+			}}
+			server, pool := wsServer(t, nil, &fakeAuth{}, documents)
+
+			_, resp, err := gorilla.DefaultDialer.Dial(wsURL(server, "/updates/ws/docId/doc-1"), http.Header{"Authorization": {"Bearer from-header"}})
+			if err == nil {
+				t.Fatal("upgraded without access")
+			}
+			if status, code := refusedWith(t, resp); status != tt.wantStatus || code != tt.wantCode {
+				t.Errorf("answered %d %s, want %d %s", status, code, tt.wantStatus, tt.wantCode)
+			}
+			if checked.Load() != 1 || gotDocument != "doc-1" || gotAuthorization != "Bearer from-header" {
+				t.Errorf("checked %d times, for %q as %q, want once for doc-1 as the connecting user", checked.Load(), gotDocument, gotAuthorization)
+			}
+			if got := pool.Participants("acme", "doc-1"); len(got) != 0 {
+				t.Errorf("participants after a refused connection = %v", got)
+			}
+		})
+	}
+}
+
+func TestCookieCredentialsAreChecked(t *testing.T) {
+	credentials := make(chan http.Header, 1)
+	documents := &fakeDocuments{access: func(_ context.Context, _ string, header http.Header) (string, error) {
+		credentials <- header
+		return documentclient.AccessWrite, nil
+	}}
+	server, _ := wsServer(t, nil, &fakeAuth{}, documents)
+
+	conn, _, err := gorilla.DefaultDialer.Dial(wsURL(server, "/updates/ws/docId/doc-1"), http.Header{"Cookie": {"session=from-cookie"}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	header := <-credentials
+	if header.Get("Cookie") != "session=from-cookie" || header.Get("Authorization") != "" {
+		t.Errorf("checked with %v, want the session cookie", header)
+	}
+}
+
+func TestUnauthenticatedConnectionsAreNotChecked(t *testing.T) {
+	var checked atomic.Int32
+	documents := &fakeDocuments{access: func(context.Context, string, http.Header) (string, error) {
+		checked.Add(1)
+		return documentclient.AccessWrite, nil
+	}}
+	server, _ := wsServer(t, nil, &fakeAuth{}, documents)
+
+	_, resp, err := gorilla.DefaultDialer.Dial(wsURL(server, "/updates/ws/docId/doc-1"), http.Header{"Authorization": {"Bearer unknown"}})
+	if err == nil {
+		t.Fatal("upgraded without a valid token")
+	}
+	if status, _ := refusedWith(t, resp); status != http.StatusUnauthorized {
+		t.Errorf("answered %d, want 401", status)
+	}
+	if checked.Load() != 0 {
+		t.Errorf("access checked %d times for an unauthenticated user", checked.Load())
+	}
+}
+
+func TestSlowAccessCheckIsUnavailable(t *testing.T) {
+	saved := config.DocumentServiceConfig.AccessTimeout
+	config.DocumentServiceConfig.AccessTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { config.DocumentServiceConfig.AccessTimeout = saved })
+
+	// DocumentService answers only once the check gave up on it
+	documents := &fakeDocuments{access: func(ctx context.Context, _ string, _ http.Header) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}}
+	server, pool := wsServer(t, nil, &fakeAuth{}, documents)
+
+	start := time.Now()
+	_, resp, err := gorilla.DefaultDialer.Dial(wsURL(server, "/updates/ws/docId/doc-1"), http.Header{"Authorization": {"Bearer from-header"}})
+	if err == nil {
+		t.Fatal("upgraded without access")
+	}
+	if status, code := refusedWith(t, resp); status != http.StatusServiceUnavailable || code != apierror.CodeAccessUnavailable {
+		t.Errorf("answered %d %s, want 503 %s", status, code, apierror.CodeAccessUnavailable)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("refused after %s, want about the access timeout", elapsed)
+	}
+	if got := pool.Participants("acme", "doc-1"); len(got) != 0 {
+		t.Errorf("participants after a refused connection = %v", got)
+	}
+}
+
+func TestReadAccessConnectsReadOnly(t *testing.T) {
+	documents := &fakeDocuments{access: func(context.Context, string, http.Header) (string, error) {
+		return documentclient.AccessRead, nil
+	}}
+	server, pool := wsServer(t, nil, &fakeAuth{}, documents)
+
+	conn, _, err := gorilla.DefaultDialer.Dial(wsURL(server, "/updates/ws/docId/doc-1"), http.Header{"Authorization": {"Bearer from-header"}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	awaitParticipants(t, pool, "doc-1", 1)
+
+	if err := addSlide(conn, 1); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var frame types.ErrorFrame
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatalf("no %s error for an edit with read access: %v", types.ErrorReadOnly, err)
+		}
+		if frame.Type == "error" {
+			if frame.Code != types.ErrorReadOnly {
+				t.Errorf("error %s, want %s", frame.Code, types.ErrorReadOnly)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package kafkaUtils
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// ConnectConsumer creates a consumer that starts at the newest messages when
+// its group has no committed offset, and verifies the broker is reachable,
+// retrying until it is.
+func ConnectConsumer(brokers string, groupID string) (*kafka.Consumer, error) {
+	var consumer *kafka.Consumer
+	var err error
+
+	maxRetries := 30
+	retryInterval := 5 * time.Second
+
+	for i := 0; i < maxRetries; i++ {
+		fmt.Printf("Attempting to connect Consumer to Kafka (Attempt %d/%d)...\n", i+1, maxRetries)
+
+		consumer, err = kafka.NewConsumer(&kafka.ConfigMap{
+			"bootstrap.servers":        brokers,
+			"group.id":                 groupID,
+			"auto.offset.reset":        "latest",
+			"allow.auto.create.topics": true,
+		})
+
+		if err == nil {
+			_, err = consumer.GetMetadata(nil, false, 5000)
+			if err == nil {
+				fmt.Println("Successfully connected Consumer to Kafka!")
+				return consumer, nil
+			}
+			consumer.Close()
+		}
+
+		fmt.Printf("Failed to connect Consumer: %v. Retrying in %v...\n", err, retryInterval)
+		time.Sleep(retryInterval)
+	}
+
+	return nil, fmt.Errorf("failed to connect consumer after %d attempts: %w", maxRetries, err)
+}
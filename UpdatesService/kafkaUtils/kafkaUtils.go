@@ -1,40 +1,105 @@
 package kafkaUtils
 
 import (
+	"UpdatesService/metrics"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 )
 
-const (
-	KafkaBroker = "canvas-live-kafka:9092"
-	Topic       = "document-updates"
-)
+// ErrQueueFull is returned by Produce while the producer's queue is full.
+// Nothing was produced; the caller may try again once it drained.
+var ErrQueueFull = errors.New("producer queue is full")
 
-func ProduceMessage(p *kafka.Producer, topic string, message []byte) error {
+// Config is how the *kafka.Producer behind a Producer delivers. librdkafka
+// retries a failed produce up to Retries times, RetryBackoff apart, and gives
+// up on the message once they are used up or DeliveryTimeout passed since it
+// was produced, whichever comes first. Idempotence keeps the messages of a
+// partition in order, and without duplicates, across the retries.
+type Config struct {
+	Broker          string
+	DeliveryTimeout time.Duration
+	Retries         int
+	RetryBackoff    time.Duration
+}
 
-	kafkaMessage := &kafka.Message{
-		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
-		Value:          message,
+// ConfigMap returns the librdkafka configuration of c.
+func (c Config) ConfigMap() *kafka.ConfigMap {
+	return &kafka.ConfigMap{
+		"bootstrap.servers":  c.Broker,
+		"enable.idempotence": true,
+		"message.timeout.ms": int(c.DeliveryTimeout.Milliseconds()),
+		"retries":            c.Retries,
+		"retry.backoff.ms":   int(c.RetryBackoff.Milliseconds()),
 	}
+}
+
+// Backend is the part of *kafka.Producer a Producer uses.
+type Backend interface {
+	Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error
+	Events() chan kafka.Event
+}
+
+// Producer produces messages without waiting for their delivery, whose
+// reports Run handles. librdkafka retries a failed delivery itself, as its
+// Config says, so a failed report is final: the message's onFailure is
+// called, and it is not produced again, which would land it after the
+// messages of its key produced since.
+type Producer struct {
+	backend Backend
+}
+
+func NewProducer(backend Backend) *Producer {
+	return &Producer{backend: backend}
+}
 
-	// Produce the kafka message
-	deliveryChan := make(chan kafka.Event)
-	err := p.Produce(kafkaMessage, deliveryChan)
-	if err != nil {
+// Produce queues value for topic, under key: messages of a key go to one
+// partition, in order. onFailure, which may be nil, is called from Run once
+// librdkafka gave up delivering it, and only then.
+func (p *Producer) Produce(topic string, key []byte, value []byte, onFailure func(error)) error {
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            key,
+		Value:          value,
+		Opaque:         onFailure,
+	}
+	if err := p.backend.Produce(msg, nil); err != nil {
+		if isQueueFull(err) {
+			return ErrQueueFull
+		}
 		return fmt.Errorf("failed to produce message: %w", err)
 	}
+	return nil
+}
 
-	// wait for delivery report or error
-	e := <-deliveryChan
-	m := e.(*kafka.Message)
-
-	if m.TopicPartition.Error != nil {
-		return fmt.Errorf("delivery failed: %s", m.TopicPartition.Error)
+// Run handles the delivery reports until the backend is closed.
+func (p *Producer) Run() {
+	for event := range p.backend.Events() {
+		switch e := event.(type) {
+		case *kafka.Message:
+			if e.TopicPartition.Error == nil {
+				metrics.KafkaProduced.Add(1)
+				continue
+			}
+			p.failed(e, e.TopicPartition.Error)
+		case kafka.Error:
+			fmt.Printf("[Kafka][Producer] %v\n", e)
+		}
 	}
+}
 
-	// close the delivery chanel
-	close(deliveryChan)
+// failed reports a message whose delivery failed for good with err.
+func (p *Producer) failed(msg *kafka.Message, err error) {
+	metrics.KafkaProduceFailures.Add(1)
+	fmt.Printf("[Kafka][Producer] Delivery failed: %v\n", err)
+	if onFailure, ok := msg.Opaque.(func(error)); ok && onFailure != nil {
+		onFailure(err)
+	}
+}
 
-	return nil
+func isQueueFull(err error) bool {
+	var kafkaErr kafka.Error
+	return errors.As(err, &kafkaErr) && kafkaErr.Code() == kafka.ErrQueueFull
 }
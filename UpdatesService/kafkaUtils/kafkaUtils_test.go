@@ -0,0 +1,320 @@
+package kafkaUtils
+
+import (
+	"UpdatesService/metrics"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// fakeBackend keeps the messages produced, failing them with err, and
+// reports their delivery as the test sends it.
+type fakeBackend struct {
+	mu       sync.Mutex
+	err      error
+	produced []*kafka.Message
+	events   chan kafka.Event
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{events: make(chan kafka.Event, 16)}
+}
+
+func (b *fakeBackend) Produce(msg *kafka.Message, _ chan kafka.Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	b.produced = append(b.produced, msg)
+	return nil
+}
+
+func (b *fakeBackend) Events() chan kafka.Event { return b.events }
+
+// report delivers the reports, closes the backend, and waits for Run to
+// handle them.
+func report(p *Producer, b *fakeBackend, reports ...kafka.Event) {
+	for _, r := range reports {
+		b.events <- r
+	}
+	close(b.events)
+	p.Run()
+}
+
+// failedReport is the report of msg failing with code.
+func failedReport(msg *kafka.Message, code kafka.ErrorCode) *kafka.Message {
+	failed := *msg
+	failed.TopicPartition.Error = kafka.NewError(code, code.String(), false)
+	return &failed
+}
+
+func TestProduceKeysMessage(t *testing.T) {
+	backend := newFakeBackend()
+	p := NewProducer(backend)
+
+	if err := p.Produce("updates", []byte("doc-1"), []byte("edit"), nil); err != nil {
+		t.Fatalf("Produce: %v", err)
+	}
+	if len(backend.produced) != 1 {
+		t.Fatalf("%d messages produced, want 1", len(backend.produced))
+	}
+	msg := backend.produced[0]
+	if *msg.TopicPartition.Topic != "updates" || string(msg.Key) != "doc-1" || string(msg.Value) != "edit" {
+		t.Errorf("produced %s/%s = %s, want updates/doc-1 = edit", *msg.TopicPartition.Topic, msg.Key, msg.Value)
+	}
+	// The partitioner picks the partition from the key
+	if msg.TopicPartition.Partition != kafka.PartitionAny {
+		t.Errorf("produced to partition %d, want the key's", msg.TopicPartition.Partition)
+	}
+}
+
+func TestProduceQueueFull(t *testing.T) {
+	backend := newFakeBackend()
+	backend.err = kafka.NewError(kafka.ErrQueueFull, "queue full", false)
+	p := NewProducer(backend)
+
+	if err := p.Produce("updates", []byte("doc-1"), []byte("edit"), nil); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Produce with a full queue = %v, want ErrQueueFull", err)
+	}
+	backend.err = kafka.NewError(kafka.ErrUnknownTopic, "unknown topic", false)
+	if err := p.Produce("updates", []byte("doc-1"), []byte("edit"), nil); err == nil || errors.Is(err, ErrQueueFull) {
+		t.Errorf("Produce to an unknown topic = %v, want its error", err)
+	}
+}
+
+func TestDeliveredMessageIsCounted(t *testing.T) {
+	backend := newFakeBackend()
+	p := NewProducer(backend)
+	var failures []error
+	p.Produce("updates", []byte("doc-1"), []byte("edit"), func(err error) { failures = append(failures, err) })
+
+	before := metrics.KafkaProduced.Value()
+	report(p, backend, backend.produced[0])
+
+	if got := metrics.KafkaProduced.Value() - before; got != 1 {
+		t.Errorf("%d deliveries counted, want 1", got)
+	}
+	if len(failures) != 0 {
+		t.Errorf("delivered message reported as failed: %v", failures)
+	}
+}
+
+// TestFailedDeliveryIsNotProducedAgain checks that a failed report, which
+// comes once librdkafka stopped retrying, is final: producing the message
+// again would land it after the ones of its key produced meanwhile.
+func TestFailedDeliveryIsNotProducedAgain(t *testing.T) {
+	for _, code := range []kafka.ErrorCode{kafka.ErrMsgTimedOut, kafka.ErrAllBrokersDown, kafka.ErrMsgSizeTooLarge} {
+		backend := newFakeBackend()
+		p := NewProducer(backend)
+		var failures []error
+		p.Produce("updates", []byte("doc-1"), []byte("edit"), func(err error) { failures = append(failures, err) })
+
+		report(p, backend, failedReport(backend.produced[0], code))
+
+		if len(backend.produced) != 1 {
+			t.Errorf("%v: message produced %d times, want once", code, len(backend.produced))
+		}
+		var kafkaErr kafka.Error
+		if len(failures) != 1 || !errors.As(failures[0], &kafkaErr) || kafkaErr.Code() != code {
+			t.Errorf("%v: failures reported = %v, want the delivery's", code, failures)
+		}
+	}
+}
+
+func TestLostEditIsReportedToItsSender(t *testing.T) {
+	backend := newFakeBackend()
+	p := NewProducer(backend)
+	failed := make(map[string]int)
+	for _, edit := range []string{"first", "second", "third"} {
+		p.Produce("updates", []byte("doc-1"), []byte(edit), func(error) { failed[edit]++ })
+	}
+	// Messages nobody waits on are only counted
+	p.Produce("updates", []byte("doc-1"), []byte("unwatched"), nil)
+
+	before := metrics.KafkaProduceFailures.Value()
+	report(p, backend,
+		backend.produced[0],
+		failedReport(backend.produced[1], kafka.ErrMsgTimedOut),
+		backend.produced[2],
+		failedReport(backend.produced[3], kafka.ErrMsgTimedOut),
+		kafka.NewError(kafka.ErrAllBrokersDown, "all brokers down", false),
+	)
+
+	if len(failed) != 1 || failed["second"] != 1 {
+		t.Errorf("failures reported = %v, want only the second edit's, once", failed)
+	}
+	if got := metrics.KafkaProduceFailures.Value() - before; got != 2 {
+		t.Errorf("%d failed deliveries counted, want 2", got)
+	}
+}
+
+func TestConfigBoundsRetries(t *testing.T) {
+	cfg := Config{Broker: "kafka:9092", DeliveryTimeout: 10 * time.Second, Retries: 10, RetryBackoff: 500 * time.Millisecond}
+	want := map[string]kafka.ConfigValue{
+		"bootstrap.servers":  "kafka:9092",
+		"enable.idempotence": true,
+		"message.timeout.ms": 10000,
+		"retries":            10,
+		"retry.backoff.ms":   500,
+	}
+	got := *cfg.ConfigMap()
+	if len(got) != len(want) {
+		t.Errorf("config = %v, want %v", got, want)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %v, want %v", key, got[key], value)
+		}
+	}
+}
+
+// outageProxy forwards connections to target, but drops them while down.
+type outageProxy struct {
+	listener net.Listener
+	target   string
+	down     atomic.Bool
+}
+
+func startOutageProxy(t *testing.T, target string) *outageProxy {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &outageProxy{listener: listener, target: target}
+	p.down.Store(true)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if p.down.Load() {
+				conn.Close()
+				continue
+			}
+			upstream, err := net.Dial("tcp", target)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			go func() {
+				io.Copy(upstream, conn)
+				upstream.Close()
+			}()
+			go func() {
+				io.Copy(conn, upstream)
+				conn.Close()
+			}()
+		}
+	}()
+	return p
+}
+
+// produceAll produces n edits of doc-1 through a Producer over backend,
+// and returns the failures reported for them by edit, once Run handled the
+// reports of all of them.
+func produceAll(t *testing.T, backend *kafka.Producer, n int) map[int][]error {
+	t.Helper()
+	p := NewProducer(backend)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Run()
+	}()
+
+	var mu sync.Mutex
+	failures := make(map[int][]error)
+	for i := 0; i < n; i++ {
+		onFailure := func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			failures[i] = append(failures[i], err)
+		}
+		if err := p.Produce("updates", []byte("doc-1"), []byte(fmt.Sprint(i)), onFailure); err != nil {
+			t.Fatalf("Produce: %v", err)
+		}
+	}
+	if left := backend.Flush(10000); left != 0 {
+		t.Fatalf("%d messages left undelivered and unreported", left)
+	}
+	backend.Close()
+	<-done
+	return failures
+}
+
+// TestOutageIsRetriedThrough checks that librdkafka retries through a broker
+// outage shorter than the delivery timeout: every edit is delivered and none
+// is reported as failed.
+func TestOutageIsRetriedThrough(t *testing.T) {
+	cluster, err := kafka.NewMockCluster(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+	proxy := startOutageProxy(t, cluster.BootstrapServers())
+
+	backend, err := kafka.NewProducer(Config{
+		Broker:          proxy.listener.Addr().String(),
+		DeliveryTimeout: 5 * time.Second,
+		Retries:         10,
+		RetryBackoff:    50 * time.Millisecond,
+	}.ConfigMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(500*time.Millisecond, func() { proxy.down.Store(false) })
+
+	delivered := metrics.KafkaProduced.Value()
+	failures := produceAll(t, backend, 10)
+	if len(failures) != 0 {
+		t.Errorf("failures reported through a short outage: %v", failures)
+	}
+	if got := metrics.KafkaProduced.Value() - delivered; got != 10 {
+		t.Errorf("%d of 10 edits delivered", got)
+	}
+}
+
+// TestRetriesAreBoundedByDeliveryTimeout checks that, with no broker to
+// deliver to, librdkafka gives up on every edit once the delivery timeout
+// passed, and each is reported as failed once.
+func TestRetriesAreBoundedByDeliveryTimeout(t *testing.T) {
+	// A port nothing listens on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := listener.Addr().String()
+	listener.Close()
+
+	backend, err := kafka.NewProducer(Config{
+		Broker:          broker,
+		DeliveryTimeout: 500 * time.Millisecond,
+		Retries:         10,
+		RetryBackoff:    50 * time.Millisecond,
+	}.ConfigMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	failures := produceAll(t, backend, 5)
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Errorf("edits given up on after %v, before the delivery timeout", elapsed)
+	}
+	for i := 0; i < 5; i++ {
+		var kafkaErr kafka.Error
+		if len(failures[i]) != 1 || !errors.As(failures[i][0], &kafkaErr) || kafkaErr.Code() != kafka.ErrMsgTimedOut {
+			t.Errorf("edit %d: failures reported = %v, want one timeout", i, failures[i])
+		}
+	}
+}
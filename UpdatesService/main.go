@@ -1,19 +1,34 @@
 package main
 
 import (
+	"UpdatesService/config"
+	"UpdatesService/documentclient"
+	"UpdatesService/documentevents"
 	"UpdatesService/handler"
 	"UpdatesService/kafkaUtils"
+	"UpdatesService/metrics"
 	"UpdatesService/redis"
 	"UpdatesService/websocket"
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"shared/authmw"
+	"shared/cors"
+	"shared/httpclient"
+	"shared/jwks"
+	"shared/requestlog"
+	"syscall"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/gin-gonic/gin"
 )
 
-func connectProducer(brokers string) (*kafka.Producer, error) {
+func connectProducer(cfg kafkaUtils.Config) (*kafka.Producer, error) {
 	var producer *kafka.Producer
 	var err error
 
@@ -22,10 +37,9 @@ func connectProducer(brokers string) (*kafka.Producer, error) {
 
 	for i := 0; i < maxRetries; i++ {
 		fmt.Printf("Attempting to connect Producer to Kafka (Attempt %d/%d)...\n", i+1, maxRetries)
+		metrics.KafkaConnectAttempts.Add(1)
 
-		producer, err = kafka.NewProducer(&kafka.ConfigMap{
-			"bootstrap.servers": brokers,
-		})
+		producer, err = kafka.NewProducer(cfg.ConfigMap())
 
 		if err == nil {
 			// Verify connection by requesting metadata.
@@ -47,9 +61,24 @@ func connectProducer(brokers string) (*kafka.Producer, error) {
 }
 
 func main() {
+	// Shut down on SIGINT or SIGTERM, see the end of main
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Load secrets (NAME or NAME_FILE) and reload the reloadable ones on SIGHUP
+	if err := config.Load(); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	go config.Secrets.ReloadOnSIGHUP(context.Background())
+
 	// kafka Setup
 	fmt.Println("Trying to connect to Kafka!")
-	p, err := connectProducer(kafkaUtils.KafkaBroker)
+	p, err := connectProducer(kafkaUtils.Config{
+		Broker:          config.KafkaConfig.Broker,
+		DeliveryTimeout: config.KafkaConfig.DeliveryTimeout,
+		Retries:         config.KafkaConfig.ProduceRetries,
+		RetryBackoff:    config.KafkaConfig.RetryBackoff,
+	})
 	if err != nil {
 		fmt.Printf("Failed to create producer: %s\n", err)
 		return
@@ -58,19 +87,134 @@ func main() {
 	fmt.Println("Connected to Kafka!")
 
 	// Redis Setup
-	redis_client := redis.NewRedisClient("canvas-live-redis:6379")
+	redis_client := redis.NewRedisClient(config.RedisConfig.Addr, config.RedisConfig.Password)
 
-	// Websocket pool
-	pool := websocket.NewPool(p)
+	// Updates are produced without waiting for their delivery; the producer
+	// tells the sender of those librdkafka gave up on
+	producer := kafkaUtils.NewProducer(p)
+	go producer.Run()
+
+	// Websocket pool. Clients of a document connected to other instances are
+	// reached through Redis pub/sub.
+	pool := websocket.NewPool(producer)
+	relay := redis_client.RoomRelay()
+	defer relay.Close()
+	pool.UseRelay(relay)
+
+	// Connections are authenticated by AuthService, or against its published
+	// keys when AUTH_VERIFY_LOCALLY is set
+	authClient := httpclient.New(httpclient.Config{
+		Service:    "auth-service",
+		BaseURL:    config.AuthServiceConfig.URL,
+		Timeout:    config.AuthServiceConfig.Timeout,
+		Retry:      httpclient.DefaultRetryPolicy,
+		SigningKey: config.InternalHMACKey.Get,
+	})
+	var authenticator authmw.Authenticator = authmw.Remote{Client: authClient}
+	if config.AuthServiceConfig.VerifyLocally {
+		authenticator = authmw.Local{
+			Verifier: jwks.NewVerifier(authClient, config.AuthServiceConfig.TokenLeeway),
+			Fallback: authenticator,
+		}
+	}
 	go pool.Start()
 
+	// Document locks reach the rooms through the document events topic. The
+	// service runs without them when Kafka cannot be reached for consuming.
+	go func() {
+		consumer, err := kafkaUtils.ConnectConsumer(config.KafkaConfig.Broker, config.KafkaConfig.DocumentEventsGroup)
+		if err != nil {
+			log.Printf("Document events consumer not started: %v", err)
+			return
+		}
+		defer consumer.Close()
+		if err := documentevents.NewConsumer(consumer, pool).Run(ctx, config.KafkaConfig.DocumentEventsTopic); err != nil {
+			log.Printf("Document events consumer stopped: %v", err)
+		}
+	}()
+
+	// Joining a live session counts as opening the document
+	documents := documentclient.New(httpclient.New(httpclient.Config{
+		Service:    "document-service",
+		BaseURL:    config.DocumentServiceConfig.URL,
+		Timeout:    config.DocumentServiceConfig.Timeout,
+		SigningKey: config.InternalHMACKey.Get,
+	}))
+
 	// Server setup
-	router := gin.Default()
+	// gin.New rather than gin.Default: requests are logged and recovered by
+	// the shared middleware, with their request ID
+	router := gin.New()
+	router.Use(requestlog.Middleware(), requestlog.Recovery(), cors.Middleware(config.CORSConfig))
 	router.GET("/", func(c *gin.Context) {
 		c.String(http.StatusOK, "Server running.")
 	})
 
-	router.GET("/updates/ws/docId/:docId/token/:token", handler.WsHandler(pool, redis_client))
+	// Probes for the orchestrator; /ready fails once shutting down
+	healthHandler := handler.HealthHandler{Pool: pool}
+	router.GET("/health", healthHandler.Health)
+	router.GET("/ready", healthHandler.Ready)
+
+	// Producer metrics
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	// Deprecated: the token goes in the Authorization header or the "bearer"
+	// subprotocol; see handler.WsHandler
+	router.GET("/updates/ws/docId/:docId/token/:token", handler.WsHandler(pool, redis_client, authenticator, documents))
+	// Clients pass the token in the Authorization header or the "bearer"
+	// subprotocol, or browsers holding the session cookie none
+	router.GET("/updates/ws/docId/:docId", handler.WsHandler(pool, redis_client, authenticator, documents))
 
-	router.Run(":8083")
+	server := &http.Server{Addr: config.ServerConfig.Addr, Handler: router}
+	go func() {
+		fmt.Printf("Starting server on %s with Gin...\n", config.ServerConfig.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Could not start server: %s\n", err.Error())
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	shutdown(pool, server, p)
+}
+
+// flusher is the Kafka producer as shutdown sees it.
+type flusher interface {
+	Flush(timeoutMs int) int
+}
+
+// shutdown stops the service in order, within config.ServerConfig's
+// ShutdownTimeout: new connections are refused, live ones told to go, the
+// server stopped, and the updates still queued in producer flushed.
+func shutdown(pool *websocket.Pool, server *http.Server, producer flusher) {
+	// Fail readiness and refuse new connections first, so the load balancer
+	// sends clients elsewhere
+	pool.StopAccepting()
+	if config.ServerConfig.DrainDelay > 0 {
+		log.Printf("[Main] Shutting down, waiting %s for the load balancer to notice", config.ServerConfig.DrainDelay)
+		time.Sleep(config.ServerConfig.DrainDelay)
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), config.ServerConfig.ShutdownTimeout)
+	defer cancelShutdown()
+
+	// Live connections do not end on their own, so they are told to go
+	log.Printf("[Main] Shutting down, closing live connections for up to %s", config.ServerConfig.CloseGrace)
+	graceCtx, cancelGrace := context.WithTimeout(shutdownCtx, config.ServerConfig.CloseGrace)
+	pool.Shutdown(graceCtx)
+	cancelGrace()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("[Main] Requests still running at the shutdown timeout: %v", err)
+	}
+
+	// Updates still queued in the producer would be lost on Close
+	flushMs := 0
+	if deadline, ok := shutdownCtx.Deadline(); ok {
+		flushMs = max(int(time.Until(deadline).Milliseconds()), 0)
+	}
+	if remaining := producer.Flush(flushMs); remaining > 0 {
+		log.Printf("[Main] %d Kafka messages not delivered at the shutdown timeout", remaining)
+	}
+	log.Println("[Main] Shutdown complete")
 }
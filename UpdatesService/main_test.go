@@ -0,0 +1,132 @@
+package main
+
+import (
+	"UpdatesService/config"
+	"UpdatesService/websocket"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+)
+
+// fakeProducer records its flushes, and how many clients had been told to go
+// by the first.
+type fakeProducer struct {
+	flushes []int
+	told    *atomic.Int32
+	toldBy  int32
+}
+
+func (p *fakeProducer) Flush(timeoutMs int) int {
+	if len(p.flushes) == 0 {
+		p.toldBy = p.told.Load()
+	}
+	p.flushes = append(p.flushes, timeoutMs)
+	return 0
+}
+
+// serveClients upgrades every connection to a client of doc-1 of acme, as
+// the websocket handler would, and returns the server's websocket URL.
+func serveClients(t *testing.T, pool *websocket.Pool) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		user := r.URL.Query().Get("user")
+		client := &websocket.Client{
+			UserID:      user,
+			Username:    user,
+			TenantID:    "acme",
+			DocumentID:  "doc-1",
+			AccessLevel: "write",
+			Conn:        conn,
+			Pool:        pool,
+			Send:        make(chan []byte, config.WebSocketConfig.SendBuffer),
+		}
+		pool.Register <- client
+		go client.Writer()
+		client.Read()
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestShutdownClosesClientsThenFlushes(t *testing.T) {
+	saved := config.ServerConfig
+	config.ServerConfig.DrainDelay = 0
+	config.ServerConfig.CloseGrace = 2 * time.Second
+	config.ServerConfig.ShutdownTimeout = 5 * time.Second
+	t.Cleanup(func() { config.ServerConfig = saved })
+
+	pool := websocket.NewPool(nil)
+	go pool.Start()
+	url := serveClients(t, pool)
+
+	// Each client records the close frame it gets, and answers it as
+	// browsers do
+	var told atomic.Int32
+	codes := make([]int, 3)
+	for i, user := range []string{"alice", "bob", "carol"} {
+		conn, _, err := gorilla.DefaultDialer.Dial(url+"?user="+user, nil)
+		if err != nil {
+			t.Fatalf("dialing as %s: %v", user, err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					var closeErr *gorilla.CloseError
+					if errors.As(err, &closeErr) {
+						codes[i] = closeErr.Code
+						told.Add(1)
+					}
+					return
+				}
+			}
+		}()
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(pool.Participants("acme", "doc-1")) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("clients never joined")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	producer := &fakeProducer{told: &told}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		shutdown(pool, &http.Server{}, producer)
+	}()
+	select {
+	case <-done:
+	case <-time.After(config.ServerConfig.ShutdownTimeout):
+		t.Fatal("shutdown overran its timeout")
+	}
+
+	for i, code := range codes {
+		if code != gorilla.CloseGoingAway {
+			t.Errorf("client %d closed with %d, want going away (%d)", i, code, gorilla.CloseGoingAway)
+		}
+	}
+	if pool.Accepting() {
+		t.Error("pool still accepting clients after shutdown")
+	}
+	if len(producer.flushes) != 1 {
+		t.Fatalf("producer flushed %d times, want once", len(producer.flushes))
+	}
+	if ms := producer.flushes[0]; ms <= 0 || ms > int(config.ServerConfig.ShutdownTimeout.Milliseconds()) {
+		t.Errorf("flushed for %dms, want what is left of the %s shutdown timeout", ms, config.ServerConfig.ShutdownTimeout)
+	}
+	if producer.toldBy != 3 {
+		t.Errorf("producer flushed when %d of 3 clients were told to go", producer.toldBy)
+	}
+}
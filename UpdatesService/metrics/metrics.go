@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+)
+
+// Kafka producer metrics, published through expvar. Resilience tests read
+// them to assert that an outage was observed and recovered from.
+// KafkaProduceFailures counts the deliveries that failed once librdkafka
+// gave up retrying them; KafkaProduceRejected the edits the producer would
+// not take, its queue full through every pause or Produce failing outright;
+// KafkaQueueFullPauses the pauses of client reads while the queue was full.
+var (
+	KafkaConnectAttempts = expvar.NewInt("kafka_connect_attempts_total")
+	KafkaProduced        = expvar.NewInt("kafka_produced_total")
+	KafkaProduceFailures = expvar.NewInt("kafka_produce_failures_total")
+	KafkaProduceRejected = expvar.NewInt("kafka_produce_rejected_total")
+	KafkaQueueFullPauses = expvar.NewInt("kafka_queue_full_pauses_total")
+	KafkaPersistFailed   = expvar.NewInt("kafka_persist_failed_total")
+)
+
+// OversizedMessages counts client frames rejected for exceeding the content
+// size limit.
+var OversizedMessages = expvar.NewInt("oversized_messages_total")
+
+// InvalidMessages counts client frames rejected as malformed, or of an
+// unknown type.
+var InvalidMessages = expvar.NewInt("invalid_messages_total")
+
+// ReadOnlyRejections counts edits refused from collaborators with read
+// access.
+var ReadOnlyRejections = expvar.NewInt("read_only_rejections_total")
+
+// ThrottledMessages counts client frames dropped for exceeding the client's
+// rate limit; RateLimitDisconnects the clients disconnected for exceeding it
+// for too long.
+var (
+	ThrottledMessages    = expvar.NewInt("throttled_messages_total")
+	RateLimitDisconnects = expvar.NewInt("rate_limit_disconnects_total")
+)
+
+// SlowConsumers counts messages that did not fit a client's send buffer,
+// which cost the client its oldest waiting message or its connection.
+var SlowConsumers = expvar.NewInt("slow_consumers_total")
+
+// SnapshotFailures counts clients that joined without the document's
+// content, because it could not be fetched or sent.
+var SnapshotFailures = expvar.NewInt("snapshot_failures_total")
+
+// StreamFailures counts edits that could not be kept in their document's
+// update stream, and replays from it that failed.
+var StreamFailures = expvar.NewInt("stream_failures_total")
+
+// RelayFailures counts room messages and subscriptions that could not be
+// exchanged with the other instances through Redis.
+var RelayFailures = expvar.NewInt("relay_failures_total")
+
+// Handler exposes every registered expvar as JSON.
+func Handler() http.Handler {
+	return expvar.Handler()
+}
@@ -0,0 +1,75 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RoomChannel is the pub/sub channel carrying the live updates of a document
+// between instances.
+func RoomChannel(documentID string) string {
+	return "doc:" + documentID
+}
+
+// RoomRelay publishes room messages to, and receives them from, the other
+// instances of the service over Redis pub/sub, one channel per document.
+// It only receives the documents it joined.
+type RoomRelay struct {
+	client   *redis.Client
+	pubsub   *redis.PubSub
+	messages chan []byte
+}
+
+// RoomRelay opens the pub/sub connection of a relay. The connection is
+// re-established and its channels resubscribed after failures.
+func (r *RedisClient) RoomRelay() *RoomRelay {
+	relay := &RoomRelay{
+		client:   r.Client,
+		pubsub:   r.Client.Subscribe(context.Background()),
+		messages: make(chan []byte),
+	}
+	go func() {
+		defer close(relay.messages)
+		for message := range relay.pubsub.Channel() {
+			relay.messages <- []byte(message.Payload)
+		}
+	}()
+	return relay
+}
+
+// Publish sends payload to the instances that joined the document.
+func (r *RoomRelay) Publish(ctx context.Context, documentID string, payload []byte) error {
+	if err := r.client.Publish(ctx, RoomChannel(documentID), payload).Err(); err != nil {
+		return fmt.Errorf("redis PUBLISH failed: %w", err)
+	}
+	return nil
+}
+
+// Join starts receiving the messages published for the document.
+func (r *RoomRelay) Join(ctx context.Context, documentID string) error {
+	if err := r.pubsub.Subscribe(ctx, RoomChannel(documentID)); err != nil {
+		return fmt.Errorf("redis SUBSCRIBE failed: %w", err)
+	}
+	return nil
+}
+
+// Leave stops receiving the messages published for the document.
+func (r *RoomRelay) Leave(ctx context.Context, documentID string) error {
+	if err := r.pubsub.Unsubscribe(ctx, RoomChannel(documentID)); err != nil {
+		return fmt.Errorf("redis UNSUBSCRIBE failed: %w", err)
+	}
+	return nil
+}
+
+// Messages delivers the payloads published for the joined documents, this
+// instance's own included. It is closed by Close.
+func (r *RoomRelay) Messages() <-chan []byte {
+	return r.messages
+}
+
+// Close ends every subscription.
+func (r *RoomRelay) Close() error {
+	return r.pubsub.Close()
+}
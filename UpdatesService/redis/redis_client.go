@@ -15,12 +15,12 @@ type RedisClient struct {
 }
 
 // NewRedisClient creates and tests the connection to Redis
-func NewRedisClient(addr string) *RedisClient {
+func NewRedisClient(addr string, password string) *RedisClient {
 	// Initialize the client connection
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     addr, // e.g., "redis:6379" from Docker Compose
-		Password: "",   // No password by default
-		DB:       0,    // Default DB
+		Password: password,
+		DB:       0, // Default DB
 	})
 
 	// Use a context to test the connection (Ping)
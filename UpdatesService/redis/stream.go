@@ -0,0 +1,124 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrTrimmed is returned by UpdatesSince when the update to resume from is
+// no longer in the stream, so the updates after it cannot all be replayed.
+var ErrTrimmed = errors.New("update no longer in the stream")
+
+var streamIDPattern = regexp.MustCompile(`^[0-9]+-[0-9]+$`)
+
+// UpdatesStream is the stream keeping the latest updates of a document, for
+// clients that reconnect to catch up on the ones they missed.
+func UpdatesStream(documentID string) string {
+	return "doc:" + documentID + ":updates"
+}
+
+// ValidStreamID reports whether id is a stream entry ID, as clients resume
+// from.
+func ValidStreamID(id string) bool {
+	return streamIDPattern.MatchString(id)
+}
+
+// StreamIDBefore reports whether the stream entry ID a comes before b.
+func StreamIDBefore(a string, b string) bool {
+	aMillis, aSeq := splitStreamID(a)
+	bMillis, bSeq := splitStreamID(b)
+	if aMillis != bMillis {
+		return aMillis < bMillis
+	}
+	return aSeq < bSeq
+}
+
+func splitStreamID(id string) (uint64, uint64) {
+	millis, seq, _ := strings.Cut(id, "-")
+	m, _ := strconv.ParseUint(millis, 10, 64)
+	s, _ := strconv.ParseUint(seq, 10, 64)
+	return m, s
+}
+
+// StreamEntry is an update kept in a document's stream.
+type StreamEntry struct {
+	ID      string
+	Payload []byte
+}
+
+// AppendUpdate adds payload to the document's stream and returns its ID
+// there. The stream keeps about maxLen updates, and expires ttl after the
+// last one.
+func (r *RedisClient) AppendUpdate(ctx context.Context, documentID string, payload []byte, maxLen int64, ttl time.Duration) (string, error) {
+	key := UpdatesStream(documentID)
+
+	var add *redis.StringCmd
+	_, err := r.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		add = pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: key,
+			MaxLen: maxLen,
+			Approx: true,
+			Values: map[string]interface{}{"payload": payload},
+		})
+		pipe.Expire(ctx, key, ttl)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("redis XADD failed: %w", err)
+	}
+	return add.Val(), nil
+}
+
+// UpdatesAfter returns the updates of the document's stream after the one
+// with ID after, or all of them when after is "", oldest first. Unlike
+// UpdatesSince, it does not mind after being trimmed away: the stream then
+// only holds updates after it.
+func (r *RedisClient) UpdatesAfter(ctx context.Context, documentID string, after string) ([]StreamEntry, error) {
+	start := "-"
+	if after != "" {
+		start = after
+	}
+	messages, err := r.Client.XRange(ctx, UpdatesStream(documentID), start, "+").Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis XRANGE failed: %w", err)
+	}
+	if len(messages) > 0 && messages[0].ID == after {
+		messages = messages[1:]
+	}
+	return streamEntries(messages), nil
+}
+
+// UpdatesSince returns the updates of the document's stream after the one
+// with ID since, oldest first. It returns ErrTrimmed when since is no longer
+// in the stream.
+func (r *RedisClient) UpdatesSince(ctx context.Context, documentID string, since string) ([]StreamEntry, error) {
+	messages, err := r.Client.XRange(ctx, UpdatesStream(documentID), since, "+").Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis XRANGE failed: %w", err)
+	}
+	// The range starts at since itself, unless it was trimmed away
+	if len(messages) == 0 || messages[0].ID != since {
+		return nil, ErrTrimmed
+	}
+
+	return streamEntries(messages[1:]), nil
+}
+
+func streamEntries(messages []redis.XMessage) []StreamEntry {
+	entries := make([]StreamEntry, 0, len(messages))
+	for _, message := range messages {
+		payload, ok := message.Values["payload"].(string)
+		if !ok {
+			continue
+		}
+		entries = append(entries, StreamEntry{ID: message.ID, Payload: []byte(payload)})
+	}
+	return entries
+}
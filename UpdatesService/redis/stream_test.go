@@ -0,0 +1,153 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// appendUpdates keeps the payloads in doc-1's stream, and returns their IDs.
+func appendUpdates(t *testing.T, r *RedisClient, maxLen int64, payloads ...string) []string {
+	t.Helper()
+	ids := make([]string, 0, len(payloads))
+	for _, payload := range payloads {
+		id, err := r.AppendUpdate(context.Background(), "doc-1", []byte(payload), maxLen, time.Hour)
+		if err != nil {
+			t.Fatalf("AppendUpdate: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func payloads(entries []StreamEntry) []string {
+	var got []string
+	for _, entry := range entries {
+		got = append(got, string(entry.Payload))
+	}
+	return got
+}
+
+func TestUpdatesAfter(t *testing.T) {
+	r := NewRedisClient(miniredis.RunT(t).Addr(), "")
+	ids := appendUpdates(t, r, 100, "a", "b", "c")
+
+	tests := []struct {
+		name  string
+		after string
+		want  []string
+	}{
+		{"all without a seq", "", []string{"a", "b", "c"}},
+		{"after the first", ids[0], []string{"b", "c"}},
+		{"after the last", ids[2], nil},
+		// A seq trimmed away is older than every update kept
+		{"after a trimmed seq", "1-0", []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		entries, err := r.UpdatesAfter(context.Background(), "doc-1", tt.after)
+		if err != nil {
+			t.Fatalf("%s: UpdatesAfter: %v", tt.name, err)
+		}
+		if got := payloads(entries); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: UpdatesAfter = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	entries, err := r.UpdatesAfter(context.Background(), "doc-2", "")
+	if err != nil || len(entries) != 0 {
+		t.Errorf("UpdatesAfter of a document without updates = %v, %v", entries, err)
+	}
+}
+
+func TestUpdatesSince(t *testing.T) {
+	r := NewRedisClient(miniredis.RunT(t).Addr(), "")
+	ids := appendUpdates(t, r, 100, "a", "b", "c")
+
+	tests := []struct {
+		name  string
+		since string
+		want  []string
+	}{
+		{"since the first", ids[0], []string{"b", "c"}},
+		{"since the last", ids[2], nil},
+	}
+	for _, tt := range tests {
+		entries, err := r.UpdatesSince(context.Background(), "doc-1", tt.since)
+		if err != nil {
+			t.Fatalf("%s: UpdatesSince: %v", tt.name, err)
+		}
+		if got := payloads(entries); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: UpdatesSince = %q, want %q", tt.name, got, tt.want)
+		}
+		for i, entry := range entries {
+			if want := ids[len(ids)-len(entries)+i]; entry.ID != want {
+				t.Errorf("%s: entry %d has ID %s, want %s", tt.name, i, entry.ID, want)
+			}
+		}
+	}
+}
+
+func TestUpdatesSinceTrimmed(t *testing.T) {
+	r := NewRedisClient(miniredis.RunT(t).Addr(), "")
+	ids := appendUpdates(t, r, 2, "a", "b", "c")
+
+	for _, since := range []string{ids[0], "1-0"} {
+		if _, err := r.UpdatesSince(context.Background(), "doc-1", since); !errors.Is(err, ErrTrimmed) {
+			t.Errorf("UpdatesSince(%s) = %v, want ErrTrimmed", since, err)
+		}
+	}
+	// Documents without updates kept have nothing to resume from either
+	if _, err := r.UpdatesSince(context.Background(), "doc-2", ids[1]); !errors.Is(err, ErrTrimmed) {
+		t.Errorf("UpdatesSince of an empty stream = %v, want ErrTrimmed", err)
+	}
+	entries, err := r.UpdatesSince(context.Background(), "doc-1", ids[1])
+	if err != nil || !slices.Equal(payloads(entries), []string{"c"}) {
+		t.Errorf("UpdatesSince of a kept update = %q, %v, want c", payloads(entries), err)
+	}
+}
+
+func TestValidStreamID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"1700000000000-0", true},
+		{"0-0", true},
+		{"", false},
+		{"1700000000000", false},
+		{"1700000000000-", false},
+		{"-", false},
+		{"+", false},
+		{"abc-1", false},
+		{"1-0 ", false},
+	}
+	for _, tt := range tests {
+		if got := ValidStreamID(tt.id); got != tt.want {
+			t.Errorf("ValidStreamID(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestStreamIDBefore(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"1700000000000-0", "1700000000001-0", true},
+		{"1700000000001-0", "1700000000000-0", false},
+		{"1700000000000-1", "1700000000000-2", true},
+		// Compared as numbers, not strings
+		{"1700000000000-9", "1700000000000-10", true},
+		{"999999999999-0", "1700000000000-0", true},
+		{"1700000000000-0", "1700000000000-0", false},
+	}
+	for _, tt := range tests {
+		if got := StreamIDBefore(tt.a, tt.b); got != tt.want {
+			t.Errorf("StreamIDBefore(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -1,12 +1,17 @@
 package types
 
-type Message struct {
-	DocumentID string `json:"documentId"`
-	UserID     string `json:"userId"`
-	Username   string `json:"username"`
-	Type       int    `json:"type"`
-	Body       string `json:"body"`
-}
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"shared/messages"
+	"time"
+)
+
+// Message is an update relayed to the room and produced to Kafka; it is
+// defined with the frames clients send, in shared/messages.
+type Message = messages.Message
 
 // Update Message
 type UpdateMessage struct {
@@ -60,13 +65,137 @@ type RemoveSlide struct {
 	SlideID string `json:"slideId"`
 }
 
+// Limits on the selection a cursor frame carries.
+const (
+	MaxCursorSelection = 100
+	MaxObjectIDLength  = 64
+)
+
+// CursorFrame is a collaborator's pointer position and selection, relayed
+// to the room but neither persisted nor sent to Kafka. The server fills in
+// UserID and Username; Removed tells the room the user's cursor is gone.
+type CursorFrame struct {
+	Type      string   `json:"type"` // always "cursor"
+	UserID    string   `json:"userId"`
+	Username  string   `json:"username"`
+	SlideID   string   `json:"slideId,omitempty"`
+	X         float64  `json:"x"`
+	Y         float64  `json:"y"`
+	Selection []string `json:"selection,omitempty"`
+	Removed   bool     `json:"removed,omitempty"`
+}
+
+// Validate reports why a cursor frame a client sent is unacceptable.
+func (f CursorFrame) Validate() error {
+	switch {
+	case math.IsNaN(f.X) || math.IsInf(f.X, 0) || math.IsNaN(f.Y) || math.IsInf(f.Y, 0):
+		return errors.New("cursor x and y must be finite numbers")
+	case len(f.SlideID) > MaxObjectIDLength:
+		return fmt.Errorf("cursor slideId must be at most %d characters", MaxObjectIDLength)
+	case len(f.Selection) > MaxCursorSelection:
+		return fmt.Errorf("cursor selection must hold at most %d objects", MaxCursorSelection)
+	}
+	for _, id := range f.Selection {
+		if id == "" || len(id) > MaxObjectIDLength {
+			return fmt.Errorf("cursor selection must hold object IDs of 1 to %d characters", MaxObjectIDLength)
+		}
+	}
+	return nil
+}
+
 // ========================================================
 
-type KafkaInterMessage struct {
-	Topic   string
-	Message Message
+// PersistFailedFrame tells a client an edit it sent, with Seq when it has
+// one, could not be handed on for saving: the room saw it, but it may be
+// lost when the document is next opened.
+type PersistFailedFrame struct {
+	Type string `json:"type"` // always "persist-failed"
+	Seq  string `json:"seq,omitempty"`
+	Body string `json:"body"`
 }
 
 type ServerResponseMessage struct {
-	Success bool `json:"success"` // true for success false for failure
+	Success bool   `json:"success"`         // true for success false for failure
+	Error   string `json:"error,omitempty"` // why a message failed, when the client can act on it
+}
+
+// Codes of the error frames sent to clients.
+const (
+	ErrorReadOnly     = "READ_ONLY"
+	ErrorInvalidFrame = "INVALID_FRAME"
+	ErrorUnknownType  = "UNKNOWN_TYPE"
+	ErrorTooLarge     = "TOO_LARGE"
+)
+
+// ErrorFrame tells a client a message it sent was refused, for reasons it
+// should act on rather than retry.
+type ErrorFrame struct {
+	Type    string `json:"type"` // always "error"
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// SnapshotFrame is the first frame a client gets on joining: the document's
+// content at Version, which the updates it gets after apply to. Seq is the
+// last update the content has, for the client to resume from when it has
+// none after it.
+type SnapshotFrame struct {
+	Type    string          `json:"type"` // always "snapshot"
+	Version int64           `json:"version"`
+	Seq     string          `json:"seq,omitempty"`
+	Content SnapshotContent `json:"content"`
 }
+
+// SnapshotContent is a document's content, as PUT /document/:id/content
+// takes it.
+type SnapshotContent struct {
+	Slides json.RawMessage `json:"slides"`
+}
+
+// ThrottleFrame tells a client an edit it sent was dropped for exceeding its
+// rate limit, and how long until it may send again.
+type ThrottleFrame struct {
+	Type         string `json:"type"` // always "throttle"
+	RetryAfterMs int64  `json:"retryAfterMs"`
+}
+
+// Presence events.
+const (
+	PresenceJoin  = "join"
+	PresenceLeave = "leave"
+	PresenceList  = "list"
+)
+
+// Participant is a user connected to a document's live session, since their
+// first connection when they have several.
+type Participant struct {
+	UserID      string    `json:"userId"`
+	Username    string    `json:"username"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+// PresenceFrame tells the clients of a room who joined or left it, and a
+// newcomer who is there. Presence is not persisted or sent to Kafka.
+type PresenceFrame struct {
+	Type         string        `json:"type"` // always "presence"
+	Event        string        `json:"event"`
+	UserID       string        `json:"userId,omitempty"`
+	Username     string        `json:"username,omitempty"`
+	Participants []Participant `json:"participants,omitempty"`
+}
+
+// ResyncFrame tells a client that messages for it were lost, so it must
+// reload the document to be in step again.
+type ResyncFrame struct {
+	Type   string `json:"type"` // always "resync"
+	Reason string `json:"reason"`
+}
+
+// Reasons of resync frames. The updates a client asked to resume from could
+// not be replayed when the stream no longer has them or is unavailable; a
+// snapshot follows those.
+const (
+	ResyncSlowConsumer       = "slow_consumer"
+	ResyncHistoryTrimmed     = "history_trimmed"
+	ResyncHistoryUnavailable = "history_unavailable"
+)
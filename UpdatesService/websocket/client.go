@@ -1,64 +1,167 @@
 package websocket
 
 import (
+	"UpdatesService/config"
+	"UpdatesService/documentclient"
+	"UpdatesService/kafkaUtils"
+	"UpdatesService/metrics"
 	"UpdatesService/redis"
 	"UpdatesService/types"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"shared/content"
+	"shared/messages"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// errDocumentLocked is returned by HandleMessage for edits to a document its
+// owner locked.
+var errDocumentLocked = errors.New("document is locked")
+
+// errReadOnly is returned by HandleMessage for edits from a client with read
+// access only.
+var errReadOnly = errors.New("read-only access")
+
+// A client whose edits are refused for read-only access this many times
+// within the window is disconnected.
+const (
+	maxReadOnlyRejections   = 10
+	readOnlyRejectionWindow = time.Minute
+)
+
 type Client struct {
 	UserID      string
 	Username    string
+	TenantID    string
 	DocumentID  string
 	Conn        *websocket.Conn
 	Pool        *Pool
 	Send        chan []byte
 	RedisClient *redis.RedisClient
+
+	// AccessLevel is the user's access to the document when they connected:
+	// "owner", "write", or "read". Readers may not edit.
+	AccessLevel string
+
+	// readOnlyRejections holds when the edits refused within the last
+	// readOnlyRejectionWindow were; only the read loop touches it.
+	readOnlyRejections []time.Time
+
+	// cursor throttles the cursor frames relayed to the room.
+	cursor cursorThrottle
+
+	// editBudget and cursorBudget rate limit the frames the client sends,
+	// see limit; only the read loop touches them.
+	editBudget   tokenBucket
+	cursorBudget tokenBucket
+
+	// resync is set when messages for the client were dropped, see enqueue;
+	// slow once it was disconnected for falling behind.
+	resync atomic.Bool
+	slow   atomic.Bool
 }
 
 func (c *Client) Read() {
 	defer func() {
+		c.removeCursor()
 		c.Pool.Unregister <- c
 		c.Conn.Close()
 	}()
 
+	// Anything the client sends, pongs to the writer's pings included, shows
+	// it is alive; silence past the deadline fails the read
+	pongWait := config.WebSocketConfig.PongWait
+	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.Conn.SetPongHandler(func(string) error {
+		return c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
 	for {
 		messageType, p, err := c.Conn.ReadMessage()
 		if err != nil {
 			fmt.Println("[Client Reader] Error reading message")
 			return
 		}
+		c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		// Oversized frames are refused, not forwarded to the room and Kafka
+		if err := content.Check(int64(len(p)), config.ContentConfig.MaxBytes); err != nil {
+			fmt.Printf("[Client Reader] Rejected %d byte message from user %s on document %s\n", len(p), c.UserID, c.DocumentID)
+			metrics.OversizedMessages.Add(1)
+			c.ErrorFrame(types.ErrorTooLarge, err.Error())
+			continue
+		}
 
 		switch messageType {
 		case 1: // Text message
 			fmt.Printf("[Client Reader] Received TEXT data: %s\n", string(p))
 
+			// A hello negotiates the session's version, and is not acked
+			if hello, ok := messages.ParseHello(p); ok {
+				if !c.Hello(hello) {
+					return
+				}
+				continue
+			}
+
 			// Data validation
 			err := c.HandleMessage(p)
-			if err != nil {
+			var tooLarge *content.TooLargeError
+			if errors.As(err, &tooLarge) {
+				metrics.OversizedMessages.Add(1)
+				c.ErrorFrame(types.ErrorTooLarge, err.Error())
+			} else if errors.Is(err, messages.ErrUnknownType) {
+				metrics.InvalidMessages.Add(1)
+				c.ErrorFrame(types.ErrorUnknownType, err.Error())
+			} else if errors.Is(err, messages.ErrInvalid) {
+				metrics.InvalidMessages.Add(1)
+				c.ErrorFrame(types.ErrorInvalidFrame, err.Error())
+			} else if errors.Is(err, errThrottled) {
+				c.ThrottleFrame()
+			} else if errors.Is(err, errRateAbuse) {
+				metrics.RateLimitDisconnects.Add(1)
+				fmt.Printf("[Client Reader] Disconnecting user %s on document %s for exceeding the rate limit\n", c.UserID, c.DocumentID)
+				c.Conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Too many messages"),
+					time.Now().Add(config.WebSocketConfig.WriteWait))
+				return
+			} else if errors.Is(err, errReadOnly) {
+				metrics.ReadOnlyRejections.Add(1)
+				if c.rejectReadOnly() {
+					fmt.Printf("[Client Reader] Disconnecting read-only user %s on document %s for repeated edits\n", c.UserID, c.DocumentID)
+					c.Conn.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Too many edits with read-only access"),
+						time.Now().Add(config.WebSocketConfig.WriteWait))
+					return
+				}
+			} else if errors.Is(err, errDocumentLocked) {
+				c.FailureResponseMessage("Document is locked by its owner")
+			} else if err != nil {
 				fmt.Printf("[Error] %s", err)
-				c.FailureResponseMessage()
+				c.FailureResponseMessage("")
 			} else {
 				c.SuccessResponseMessage()
 			}
 
 		case 2: // Binary message
 			fmt.Printf("[Client Reader] Received BINARY data (%d bytes)\n", len(p))
+			metrics.InvalidMessages.Add(1)
+			c.ErrorFrame(types.ErrorInvalidFrame, "Frames must be JSON text")
 		}
 
 	}
 }
 
 func (c *Client) Writer() {
-	// PING / PONG Connection Keep-Alive mechanism
-	pongWait := 60 * time.Second      // The maximum time server will wait for a pong message before assuming that the connection is dead
-	pingPeriod := (pongWait * 9) / 10 // The interval at which the server sends a PING message
-	const writeWait = 10 * time.Second
+	// PING / PONG Connection Keep-Alive mechanism: the reader waits up to
+	// WebSocketConfig.PongWait for the pongs
+	pingPeriod := config.WebSocketConfig.PingPeriod // The interval at which the server sends a PING message
+	writeWait := config.WebSocketConfig.WriteWait
 
 	ticker := time.NewTicker(pingPeriod)
 	defer func() {
@@ -74,6 +177,15 @@ func (c *Client) Writer() {
 			if !ok {
 				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
 				fmt.Println("[Client Writer] Error receiving message from Send channel!")
+				return
+			}
+
+			// Ahead of the message, say that older ones were dropped
+			if c.resync.Swap(false) {
+				if err := c.Conn.WriteJSON(types.ResyncFrame{Type: "resync", Reason: types.ResyncSlowConsumer}); err != nil {
+					fmt.Println("[Client Writer] Failed to send message")
+					return
+				}
 			}
 
 			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
@@ -92,154 +204,203 @@ func (c *Client) Writer() {
 
 }
 
+// HandleMessage parses a frame the client sent and acts on it: cursor frames
+// are relayed to the room, presence actions broadcast, and edits broadcast
+// and produced to Kafka. Frames that are malformed, of an unknown type, or
+// missing the fields of their action are refused with an error wrapping
+// messages.ErrInvalid or messages.ErrUnknownType; nothing of them reaches
+// the room or Kafka. Frames over the client's rate limit are dropped, see
+// limit.
 func (c *Client) HandleMessage(p []byte) error {
+	env, err := messages.Parse(p, config.ContentConfig.MaxBytes)
+	if err != nil {
+		fmt.Printf("[Client Reader] Rejected frame from user %s on document %s - %s\n", c.UserID, c.DocumentID, err)
+		return err
+	}
 
-	var msg map[string]interface{}
-	if err := json.Unmarshal(p, &msg); err != nil {
-		fmt.Printf("[Client Reader] Error Unmarshaling Action Message - %s\n", err)
+	if allowed, err := c.limit(env.Type, time.Now()); !allowed {
 		return err
 	}
 
-	actVal, ok := msg["action"]
-	if !ok {
-		fmt.Println("[Client Reader] action key not available in message")
-		return fmt.Errorf("[Error] action key missing")
+	// Cursor frames carry no action, and are open to readers too
+	if env.Type == messages.TypeCursor {
+		return c.HandleCursor(env.Payload)
+	}
+
+	var msg map[string]interface{}
+	if err := json.Unmarshal(env.Payload, &msg); err != nil {
+		return fmt.Errorf("%w: %v", messages.ErrInvalid, err)
+	}
+	// Parse checked that the payload names an action of its type
+	actionStr, _ := msg["action"].(string)
+
+	// Readers only move their cursor; selecting an object claims it for
+	// editing
+	if !c.canEdit() && actionStr != "cursormove" {
+		return errReadOnly
 	}
-	actionStr, ok := actVal.(string)
-	if !ok {
-		fmt.Println("[Client Reader] action key is not a string")
-		return fmt.Errorf("[Error] action key is not a string")
+
+	// Locked documents still take cursor moves and selections, not edits
+	if env.Type == messages.TypeEdit && c.Pool.IsLocked(c.TenantID, c.DocumentID) {
+		return errDocumentLocked
 	}
 
+	// The identity and time are the server's, whatever the client sent
 	outMsg := types.Message{
 		DocumentID: c.DocumentID,
 		Username:   c.Username,
 		UserID:     c.UserID,
+		TenantID:   c.TenantID,
 		Type:       1,
-		Body:       string(p),
+		Body:       string(env.Payload),
+		SentAt:     time.Now().UTC(),
 	}
 
 	switch actionStr {
 	case "cursormove":
-		if types.ValidateCursorMoveMessage(msg) {
-			c.Broadcast(outMsg)
+		if !types.ValidateCursorMoveMessage(msg) {
+			return missingFields(actionStr)
 		}
+		c.Broadcast(outMsg)
 
 	case "create":
+		if !types.ValidateCreateMessage(msg) {
+			return missingFields(actionStr)
+		}
+		attr, ok := msg["attributes"].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%w: attributes must be an object", messages.ErrInvalid)
+		}
+		objectType := msg["objectType"]
+		objectId, ok := msg["objectId"].(string)
+		if !ok {
+			return fmt.Errorf("%w: objectId must be a string", messages.ErrInvalid)
+		}
 
-		if types.ValidateCreateMessage(msg) {
-			attr, ok := msg["attributes"].(map[string]interface{})
-			if !ok {
-				return fmt.Errorf("[Client][HandleMessage][Error] attributes missing")
-
-			}
-			objectType, ok := msg["objectType"]
-			if !ok {
-				return fmt.Errorf("[Client][HandleMessage][Error] objectType missing")
-			}
-			objectId, ok := msg["objectId"].(string)
-			if !ok {
-				return fmt.Errorf("[Client][HandleMessage][Error] objectId missing")
-			}
-
-			isValid := false
-
-			switch objectType {
-			case "rectangle":
-				isValid = types.ValidateRectangleAttributes(attr)
-			case "circle":
-				isValid = types.ValidateCircleAttributes(attr)
-			case "text":
-				isValid = types.ValidateTextAttributes(attr)
-			case "pen":
-				isValid = types.ValidatePenAttributes(attr)
-			case "line", "arrow":
-				isValid = types.ValidateLineAttributes(attr)
-			case "image":
-				isValid = true 
-			default:
-				fmt.Printf("[HandleMessage] Unknown object type: %s\n", objectType)
-			}
-
-			if isValid {
-				if err := c.CheckLockAndBroadcastAndPushToKafka(outMsg, objectId); err != nil {
-					return err
-				}
-			} else {
-				fmt.Printf("[HandleMessage] Validation failed for type: %s\n", objectType)
-			}
-
+		isValid := false
+
+		switch objectType {
+		case "rectangle":
+			isValid = types.ValidateRectangleAttributes(attr)
+		case "circle":
+			isValid = types.ValidateCircleAttributes(attr)
+		case "text":
+			isValid = types.ValidateTextAttributes(attr)
+		case "pen":
+			isValid = types.ValidatePenAttributes(attr)
+		case "line", "arrow":
+			isValid = types.ValidateLineAttributes(attr)
+		case "image":
+			isValid = true
+		default:
+			return fmt.Errorf("%w: object type %v", messages.ErrUnknownType, objectType)
 		}
 
-	case "update":
-		if types.ValidateUpdateMessage(msg) {
-			objectId, ok := msg["objectId"].(string)
-			if !ok {
-				return fmt.Errorf("[Client][HandleMessage][Error] objectId missing")
-			}
+		if !isValid {
+			return fmt.Errorf("%w: %v attributes are missing fields", messages.ErrInvalid, objectType)
+		}
+		if err := c.CheckLockAndBroadcastAndPushToKafka(outMsg, objectId); err != nil {
+			return err
+		}
 
-			if err := c.CheckLockAndBroadcastAndPushToKafka(outMsg, objectId); err != nil {
-				return err
-			}
+	case "update", "delete":
+		valid := types.ValidateUpdateMessage(msg)
+		if actionStr == "delete" {
+			valid = types.ValidateDeleteMessage(msg)
+		}
+		if !valid {
+			return missingFields(actionStr)
+		}
+		objectId, ok := msg["objectId"].(string)
+		if !ok {
+			return fmt.Errorf("%w: objectId must be a string", messages.ErrInvalid)
 		}
-	case "delete":
-		if types.ValidateDeleteMessage(msg) {
-			objectId, ok := msg["objectId"].(string)
-			if !ok {
-				return fmt.Errorf("[Client][HandleMessage][Error] objectId missing")
-			}
 
-			if err := c.CheckLockAndBroadcastAndPushToKafka(outMsg, objectId); err != nil {
-				return err
-			}
+		if err := c.CheckLockAndBroadcastAndPushToKafka(outMsg, objectId); err != nil {
+			return err
 		}
+
 	case "select":
-		if types.ValidateSelectMessage(msg) {
-			objectId, ok := msg["objectId"].(string)
-			if !ok {
-				return fmt.Errorf("[Client][HandleMessage][Error] objectId missing")
-			}
+		if !types.ValidateSelectMessage(msg) {
+			return missingFields(actionStr)
+		}
+		objectId, ok := msg["objectId"].(string)
+		if !ok {
+			return fmt.Errorf("%w: objectId must be a string", messages.ErrInvalid)
+		}
 
-			if err := c.CheckLockAndBroadcast(outMsg, objectId); err != nil {
-				return err
-			}
+		if err := c.CheckLockAndBroadcast(outMsg, objectId); err != nil {
+			return err
 		}
-	case "deselect":
-		if types.ValidateSelectMessage(msg) {
-			objectId, ok := msg["objectId"].(string)
-			if !ok {
-				return fmt.Errorf("[Client][HandleMessage][Error] objectId missing")
-			}
 
-			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
-			defer cancel()
-			anyKeyDeleted, err := c.RedisClient.ReleaseLock(ctx, objectId)
-			if err != nil {
-				return err
-			}
+	case "deselect":
+		if !types.ValidateSelectMessage(msg) {
+			return missingFields(actionStr)
+		}
+		objectId, ok := msg["objectId"].(string)
+		if !ok {
+			return fmt.Errorf("%w: objectId must be a string", messages.ErrInvalid)
+		}
 
-			// if the object had been selected then it has been deleted
-			if anyKeyDeleted {
-				c.Broadcast(outMsg)
-			}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		anyKeyDeleted, err := c.RedisClient.ReleaseLock(ctx, objectId)
+		if err != nil {
+			return err
+		}
 
+		// if the object had been selected then it has been deleted
+		if anyKeyDeleted {
+			c.Broadcast(outMsg)
 		}
+
 	case "add_slide":
-		if types.ValidateAddSlideMessage(msg) {
-			c.BroadcastAndPushToKafka(outMsg)
+		if !types.ValidateAddSlideMessage(msg) {
+			return missingFields(actionStr)
 		}
+		c.BroadcastAndPushToKafka(outMsg)
+
 	case "remove_slide":
-		if types.ValidateRemoveSlideMessage(msg) {
-			c.BroadcastAndPushToKafka(outMsg)
+		if !types.ValidateRemoveSlideMessage(msg) {
+			return missingFields(actionStr)
 		}
-	default:
-		// c.Send <- []byte("[Error] Invalid m essage format")
-		return fmt.Errorf("[Client][HandleMessage][Error] Invalid message format received")
+		c.BroadcastAndPushToKafka(outMsg)
 	}
 
 	return nil
 }
 
+// missingFields is the error for a payload lacking the fields its action
+// requires.
+func missingFields(action string) error {
+	return fmt.Errorf("%w: %s message is missing fields", messages.ErrInvalid, action)
+}
+
+// canEdit reports whether the client may change the document.
+func (c *Client) canEdit() bool {
+	return c.AccessLevel == documentclient.AccessOwner || c.AccessLevel == documentclient.AccessWrite
+}
+
+// rejectReadOnly tells the client its edit was refused for read-only access,
+// and reports whether it was refused too often within the window, and should
+// be disconnected.
+func (c *Client) rejectReadOnly() bool {
+	now := time.Now()
+	recent := c.readOnlyRejections[:0]
+	for _, at := range c.readOnlyRejections {
+		if now.Sub(at) < readOnlyRejectionWindow {
+			recent = append(recent, at)
+		}
+	}
+	c.readOnlyRejections = append(recent, now)
+	if len(c.readOnlyRejections) >= maxReadOnlyRejections {
+		return true
+	}
+
+	c.ErrorFrame(types.ErrorReadOnly, "You have read-only access to this document")
+	return false
+}
+
 func (c *Client) CheckLockAndBroadcast(outMsg types.Message, objectId string) error {
 
 	// Check Exclusive Lock[]
@@ -252,7 +413,7 @@ func (c *Client) CheckLockAndBroadcast(outMsg types.Message, objectId string) er
 	}
 
 	// broadcast message to everyone in the room
-	c.Pool.RoomBroadcast <- outMsg
+	c.Pool.RoomBroadcast <- RoomMessage{Message: outMsg, Sender: c}
 	fmt.Printf("Message Received: %+v\n", outMsg)
 	return nil
 }
@@ -268,42 +429,103 @@ func (c *Client) CheckLockAndBroadcastAndPushToKafka(outMsg types.Message, objec
 		return fmt.Errorf("[Error] Lock is not free")
 	}
 
-	// broadcast message to everyone in the room
-	c.Pool.RoomBroadcast <- outMsg
+	// broadcast message to everyone in the room, with its place in the
+	// document's stream
+	outMsg.Seq = c.record(outMsg)
+	c.Pool.RoomBroadcast <- RoomMessage{Message: outMsg, Sender: c}
 	fmt.Printf("Message Received: %+v\n", outMsg)
 
-	// push to kafka
-	kafkaMessage := types.KafkaInterMessage{Topic: "document-updates", Message: outMsg}
-	c.Pool.PushToKafka <- kafkaMessage
+	c.pushToKafka(outMsg)
 
 	return nil
 }
 
 func (c *Client) BroadcastAndPushToKafka(outMsg types.Message) {
-	// broadcast message to everyone in the room
-	c.Pool.RoomBroadcast <- outMsg
+	// broadcast message to everyone in the room, with its place in the
+	// document's stream
+	outMsg.Seq = c.record(outMsg)
+	c.Pool.RoomBroadcast <- RoomMessage{Message: outMsg, Sender: c}
 	fmt.Printf("Message Received: %+v\n", outMsg)
 
-	// push to kafka
-	kafkaMessage := types.KafkaInterMessage{Topic: "document-updates", Message: outMsg}
-	c.Pool.PushToKafka <- kafkaMessage
+	c.pushToKafka(outMsg)
+}
+
+// pushToKafka produces an edit for the updates consumer, unless it is not
+// well formed and would only fail there. While the producer's queue is full
+// it holds up the client's read loop, so a client sending faster than Kafka
+// takes its edits is slowed down rather than having them dropped. Edits that
+// cannot be produced, now or once librdkafka gave up retrying them, are
+// reported to the client in a persist-failed frame.
+func (c *Client) pushToKafka(outMsg types.Message) {
+	if err := outMsg.Validate(); err != nil {
+		fmt.Printf("[Client][Kafka] Not producing malformed message of user %s on document %s: %v\n", c.UserID, c.DocumentID, err)
+		return
+	}
+	serialized, err := SerializeMessage(outMsg)
+	if err != nil {
+		fmt.Println("[Client][Kafka]", err)
+		return
+	}
+
+	onFailure := func(err error) { c.PersistFailed(outMsg, err) }
+	cfg := config.KafkaConfig
+	for attempt := 1; ; attempt++ {
+		// Keyed by document, for the consumer to apply its edits in order
+		err = c.Pool.Producer.Produce(cfg.UpdatesTopic, outMsg.Key(), serialized, onFailure)
+		if !errors.Is(err, kafkaUtils.ErrQueueFull) || attempt >= cfg.ProduceAttempts {
+			break
+		}
+		metrics.KafkaQueueFullPauses.Add(1)
+		time.Sleep(cfg.QueueFullPause)
+	}
+	if err != nil {
+		metrics.KafkaProduceRejected.Add(1)
+		c.PersistFailed(outMsg, err)
+	}
+}
+
+// PersistFailed tells the client its edit could not be produced to Kafka, so
+// it may not be saved. It is safe to call from any goroutine, and after the
+// client disconnected.
+func (c *Client) PersistFailed(outMsg types.Message, err error) {
+	metrics.KafkaPersistFailed.Add(1)
+	fmt.Printf("[Client][Kafka] Edit of user %s on document %s not produced: %v\n", c.UserID, c.DocumentID, err)
+
+	jsonBytes, err := json.Marshal(types.PersistFailedFrame{Type: "persist-failed", Seq: outMsg.Seq, Body: outMsg.Body})
+	if err != nil {
+		fmt.Println("[Client][Kafka] json marshalling error")
+		return
+	}
+	c.enqueue(jsonBytes)
 }
 
 func (c *Client) Broadcast(outMsg types.Message) {
 	// broadcast message to everyone in the room
-	c.Pool.RoomBroadcast <- outMsg
+	c.Pool.RoomBroadcast <- RoomMessage{Message: outMsg, Sender: c}
 	fmt.Printf("Message Received: %+v\n", outMsg)
 
 	// return nil
 }
 
-func (c *Client) FailureResponseMessage() error {
-	msg := types.ServerResponseMessage{Success: false}
+// ErrorFrame tells the client a message was refused, with code saying why.
+func (c *Client) ErrorFrame(code string, message string) error {
+	jsonBytes, err := json.Marshal(types.ErrorFrame{Type: "error", Code: code, Message: message})
+	if err != nil {
+		return fmt.Errorf("[Error] failure to marshal error frame")
+	}
+	c.enqueue(jsonBytes)
+	return nil
+}
+
+// FailureResponseMessage acknowledges a message as failed, telling the client
+// why when reason is not empty.
+func (c *Client) FailureResponseMessage(reason string) error {
+	msg := types.ServerResponseMessage{Success: false, Error: reason}
 	jsonBytes, err := json.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("[Error] failure to marshal server response message")
 	}
-	c.Send <- jsonBytes
+	c.enqueue(jsonBytes)
 	return nil
 }
 
@@ -313,6 +535,6 @@ func (c *Client) SuccessResponseMessage() error {
 	if err != nil {
 		return fmt.Errorf("[Error] failure to marshal server response message")
 	}
-	c.Send <- jsonBytes
+	c.enqueue(jsonBytes)
 	return nil
 }
@@ -0,0 +1,400 @@
+package websocket
+
+import (
+	"UpdatesService/config"
+	"UpdatesService/kafkaUtils"
+	"UpdatesService/redis"
+	"UpdatesService/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/gorilla/websocket"
+)
+
+// recordingBackend keeps what the pool's producer produces, without a
+// broker.
+type recordingBackend struct {
+	mu       sync.Mutex
+	produced []*kafka.Message
+	events   chan kafka.Event
+}
+
+func (b *recordingBackend) Produce(msg *kafka.Message, _ chan kafka.Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.produced = append(b.produced, msg)
+	return nil
+}
+
+func (b *recordingBackend) Events() chan kafka.Event { return b.events }
+
+func (b *recordingBackend) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.produced)
+}
+
+// startProducingPool runs a pool producing to backend, with the clients'
+// update streams and object locks in miniredis.
+func startProducingPool(t *testing.T, backend *recordingBackend) (*Pool, *redis.RedisClient) {
+	t.Helper()
+	redisClient := redis.NewRedisClient(miniredis.RunT(t).Addr(), "")
+	pool := NewPool(kafkaUtils.NewProducer(backend))
+	go pool.Start()
+	t.Cleanup(func() { close(pool.quit) })
+	return pool, redisClient
+}
+
+// joinWith registers a client with the given access to doc-1 of acme.
+func joinWith(pool *Pool, redisClient *redis.RedisClient, userID string, accessLevel string) *Client {
+	client := &Client{
+		UserID:      userID,
+		Username:    userID,
+		TenantID:    "acme",
+		DocumentID:  "doc-1",
+		AccessLevel: accessLevel,
+		Pool:        pool,
+		Send:        make(chan []byte, 64),
+		RedisClient: redisClient,
+	}
+	pool.Register <- client
+	return client
+}
+
+func TestReadOnlyEditsReachNoOne(t *testing.T) {
+	backend := &recordingBackend{events: make(chan kafka.Event)}
+	pool, redisClient := startProducingPool(t, backend)
+	reader := joinWith(pool, redisClient, "rita", "read")
+	peer := joinWith(pool, redisClient, "walt", "write")
+
+	frames := []string{
+		`{"type":"edit","v":1,"payload":{"action":"create","slideId":"s-1","objectId":"o-1","objectType":"rectangle","attributes":{"x":1,"y":1,"width":1,"height":1}}}`,
+		`{"type":"edit","v":1,"payload":{"action":"update","slideId":"s-1","objectId":"o-1","attributes":{"x":2}}}`,
+		`{"type":"edit","v":1,"payload":{"action":"delete","slideId":"s-1","objectId":"o-1"}}`,
+		`{"type":"edit","v":1,"payload":{"action":"add_slide","slideId":"s-2"}}`,
+		`{"type":"edit","v":1,"payload":{"action":"remove_slide","slideId":"s-1"}}`,
+		`{"type":"presence","v":1,"payload":{"action":"select","slideId":"s-1","objectId":"o-1"}}`,
+		// Clients predating the envelope send bare actions
+		`{"action":"add_slide","slideId":"s-3"}`,
+	}
+	for _, frame := range frames {
+		if err := reader.HandleMessage([]byte(frame)); !errors.Is(err, errReadOnly) {
+			t.Errorf("HandleMessage(%s) = %v, want errReadOnly", frame, err)
+		}
+	}
+	if got := received(peer, 200*time.Millisecond); len(got) != 0 {
+		t.Errorf("peer got %v from a reader", got)
+	}
+	if n := backend.count(); n != 0 {
+		t.Errorf("%d edits of a reader produced", n)
+	}
+
+	// The same edit from a writer goes through
+	if err := peer.HandleMessage([]byte(`{"type":"edit","v":1,"payload":{"action":"add_slide","slideId":"s-2"}}`)); err != nil {
+		t.Fatalf("writer's edit: %v", err)
+	}
+	if got := received(reader, 200*time.Millisecond); len(got) != 1 {
+		t.Errorf("reader got %v, want the writer's edit", got)
+	}
+	if n := backend.count(); n != 1 {
+		t.Errorf("%d edits produced, want the writer's", n)
+	}
+}
+
+// serveClients upgrades every connection to a client of doc-1 of acme named
+// after the user query parameter, and returns the server's websocket URL.
+func serveClients(t *testing.T, pool *Pool) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		user := r.URL.Query().Get("user")
+		client := &Client{
+			UserID:      user,
+			Username:    user,
+			TenantID:    "acme",
+			DocumentID:  "doc-1",
+			AccessLevel: "write",
+			Conn:        conn,
+			Pool:        pool,
+			Send:        make(chan []byte, config.WebSocketConfig.SendBuffer),
+		}
+		pool.Register <- client
+		go client.Writer()
+		client.Read()
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// withHeartbeat sets the websocket heartbeat for the test.
+func withHeartbeat(t *testing.T, pongWait time.Duration) {
+	t.Helper()
+	saved := config.WebSocketConfig
+	config.WebSocketConfig.PongWait = pongWait
+	config.WebSocketConfig.PingPeriod = pongWait * 9 / 10
+	t.Cleanup(func() { config.WebSocketConfig = saved })
+}
+
+func dial(t *testing.T, url string, user string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?user="+user, nil)
+	if err != nil {
+		t.Fatalf("dialing as %s: %v", user, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// connected reports whether user is in the room of doc-1 of acme.
+func connected(pool *Pool, user string) bool {
+	for _, p := range pool.Participants("acme", "doc-1") {
+		if p.UserID == user {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSilentClientIsUnregisteredAfterPongWait(t *testing.T) {
+	const pongWait = 300 * time.Millisecond
+	withHeartbeat(t, pongWait)
+	pool := startPool(t)
+	url := serveClients(t, pool)
+
+	// A client that never reads never answers the pings
+	dial(t, url, "alice")
+	start := time.Now()
+	for !connected(pool, "alice") {
+		if time.Since(start) > time.Second {
+			t.Fatal("alice never joined")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for connected(pool, "alice") {
+		if time.Since(start) > pongWait+500*time.Millisecond {
+			t.Fatalf("silent client still registered %v after joining", time.Since(start))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if elapsed := time.Since(start); elapsed < pongWait*9/10 {
+		t.Errorf("silent client unregistered after %v, before the pong wait of %v", elapsed, pongWait)
+	}
+}
+
+func TestClientAnsweringPingsStays(t *testing.T) {
+	const pongWait = 200 * time.Millisecond
+	withHeartbeat(t, pongWait)
+	pool := startPool(t)
+	url := serveClients(t, pool)
+
+	// Reading answers the server's pings
+	conn := dial(t, url, "bob")
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	time.Sleep(4 * pongWait)
+	if !connected(pool, "bob") {
+		t.Error("client answering pings was unregistered")
+	}
+}
+
+// roomTap counts what a client sends to its room, in place of the pool.
+type roomTap struct {
+	pool       *Pool
+	broadcasts atomic.Int64
+}
+
+func tapRoom(pool *Pool) *roomTap {
+	tap := &roomTap{pool: pool}
+	go func() {
+		for message := range pool.RoomBroadcast {
+			if message.Sender != nil {
+				tap.broadcasts.Add(1)
+			}
+		}
+	}()
+	return tap
+}
+
+// count returns how many messages reached the room so far: once the tap took
+// a message without a sender, it counted every one sent before.
+func (tap *roomTap) count() int64 {
+	tap.pool.RoomBroadcast <- RoomMessage{}
+	return tap.broadcasts.Load()
+}
+
+func FuzzHandleMessage(f *testing.F) {
+	for _, seed := range []string{
+		``,
+		`null`,
+		`[]`,
+		`"edit"`,
+		`{`,
+		`{"type":"edit"`,
+		`{"type":"edit","v":1}`,
+		`{"type":"edit","v":1,"payload":null}`,
+		`{"type":"edit","v":1,"payload":"add_slide"}`,
+		`{"type":"edit","v":1,"payload":{"action":7}}`,
+		`{"type":"edit","v":1,"payload":{"action":"select","slideId":"s-1","objectId":"o-1"}}`,
+		`{"type":"edit","v":1,"payload":{"action":"create","slideId":"s-1","objectId":"o-1","objectType":"hexagon","attributes":{}}}`,
+		`{"type":"edit","v":1,"payload":{"action":"create","slideId":"s-1","objectId":["o-1"],"objectType":"image","attributes":{}}}`,
+		`{"type":"edit","v":1,"payload":{"action":"update","slideId":"s-1"}}`,
+		`{"type":"teleport","v":1,"payload":{"action":"add_slide","slideId":"s-1"}}`,
+		`{"type":"cursor","v":1,"payload":{"x":"left","y":1}}`,
+		`{"action":"remove_slide"}`,
+		`{"action":"launch"}`,
+		"\x00\xff\xfe",
+		`{"type":"edit","v":1,"payload":{"action":"add_slide","slideId":"s-1"}}` + `}`,
+	} {
+		f.Add([]byte(seed))
+	}
+
+	backend := &recordingBackend{events: make(chan kafka.Event)}
+	redisClient := redis.NewRedisClient(miniredis.RunT(f).Addr(), "")
+	pool := NewPool(kafkaUtils.NewProducer(backend))
+	tap := tapRoom(pool)
+	client := &Client{
+		UserID:      "alice",
+		Username:    "alice",
+		TenantID:    "acme",
+		DocumentID:  "doc-1",
+		AccessLevel: "owner",
+		Pool:        pool,
+		Send:        make(chan []byte, 64),
+		RedisClient: redisClient,
+	}
+
+	f.Fuzz(func(t *testing.T, frame []byte) {
+		produced, broadcast := backend.count(), tap.count()
+		err := client.HandleMessage(frame)
+		if !json.Valid(frame) && err == nil {
+			t.Errorf("HandleMessage(%q) accepted a frame that is not JSON", frame)
+		}
+		if err == nil {
+			return
+		}
+		if n := backend.count() - produced; n != 0 {
+			t.Errorf("HandleMessage(%q) = %v, yet produced %d edits", frame, err, n)
+		}
+		if n := tap.count() - broadcast; n != 0 {
+			t.Errorf("HandleMessage(%q) = %v, yet broadcast %d messages", frame, err, n)
+		}
+	})
+}
+
+// partitionedBackend puts what the pool's producer produces on one of its
+// partitions by the message's key, as the default partitioner does.
+type partitionedBackend struct {
+	mu         sync.Mutex
+	partitions [3][]*kafka.Message
+	events     chan kafka.Event
+}
+
+func (b *partitionedBackend) Produce(msg *kafka.Message, _ chan kafka.Event) error {
+	h := fnv.New32a()
+	h.Write(msg.Key)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p := h.Sum32() % uint32(len(b.partitions))
+	b.partitions[p] = append(b.partitions[p], msg)
+	return nil
+}
+
+func (b *partitionedBackend) Events() chan kafka.Event { return b.events }
+
+// TestDocumentUpdatesStayInOrder checks that the updates of a document, sent
+// while another document is edited, are all produced under its key, so that
+// they share a partition, and that a consumer reading the partitions applies
+// them in the order they were sent.
+func TestDocumentUpdatesStayInOrder(t *testing.T) {
+	const edits = 50
+	withRateLimit(t, config.RateLimitConfigStruct{EditRate: 1, EditBurst: edits, AbuseAfter: time.Minute})
+	backend := &partitionedBackend{events: make(chan kafka.Event)}
+	redisClient := redis.NewRedisClient(miniredis.RunT(t).Addr(), "")
+	pool := NewPool(kafkaUtils.NewProducer(backend))
+	go pool.Start()
+	t.Cleanup(func() { close(pool.quit) })
+
+	docs := []string{"doc-1", "doc-2"}
+	var wg sync.WaitGroup
+	for _, doc := range docs {
+		client := &Client{
+			UserID:      "alice",
+			Username:    "alice",
+			TenantID:    "acme",
+			DocumentID:  doc,
+			AccessLevel: "write",
+			Pool:        pool,
+			Send:        make(chan []byte, edits),
+			RedisClient: redisClient,
+		}
+		pool.Register <- client
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < edits; i++ {
+				frame := fmt.Sprintf(`{"type":"edit","v":1,"payload":{"action":"add_slide","slideId":"s-%d"}}`, i)
+				if err := client.HandleMessage([]byte(frame)); err != nil {
+					t.Errorf("edit %d of %s: %v", i, client.DocumentID, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// What a consumer applies, partition by partition
+	applied := make(map[string][]string)
+	partitionOf := make(map[string]int)
+	for p, partition := range backend.partitions {
+		for _, msg := range partition {
+			var m types.Message
+			if err := json.Unmarshal(msg.Value, &m); err != nil {
+				t.Fatalf("produced %s: %v", msg.Value, err)
+			}
+			if string(msg.Key) != m.DocumentID {
+				t.Errorf("update of %s produced under key %q", m.DocumentID, msg.Key)
+			}
+			if prev, ok := partitionOf[m.DocumentID]; ok && prev != p {
+				t.Errorf("updates of %s on partitions %d and %d", m.DocumentID, prev, p)
+			}
+			partitionOf[m.DocumentID] = p
+			var edit struct {
+				SlideID string `json:"slideId"`
+			}
+			json.Unmarshal([]byte(m.Body), &edit)
+			applied[m.DocumentID] = append(applied[m.DocumentID], edit.SlideID)
+		}
+	}
+	for _, doc := range docs {
+		if len(applied[doc]) != edits {
+			t.Errorf("%d updates of %s applied, want %d", len(applied[doc]), doc, edits)
+			continue
+		}
+		for i, slide := range applied[doc] {
+			if want := fmt.Sprintf("s-%d", i); slide != want {
+				t.Errorf("update %d of %s applied = %s, want %s", i, doc, slide, want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"UpdatesService/types"
+	"encoding/json"
+	"fmt"
+	"shared/messages"
+	"sync"
+	"time"
+)
+
+// cursorInterval is the least time between two cursor frames of a client
+// relayed to its room, i.e. at most 20 a second. Frames arriving faster are
+// coalesced: only the latest is relayed when the interval is up.
+const cursorInterval = 50 * time.Millisecond
+
+// cursorThrottle relays a client's cursor frames at most once per
+// cursorInterval. It is used by the read loop and by its own timer.
+type cursorThrottle struct {
+	mu      sync.Mutex
+	lastAt  time.Time
+	pending *types.CursorFrame
+	timer   *time.Timer
+	stopped bool
+}
+
+// HandleCursor relays a cursor frame the client sent, stamped with its
+// identity, or keeps it for later when the client sent one too recently.
+func (c *Client) HandleCursor(p []byte) error {
+	var frame types.CursorFrame
+	if err := json.Unmarshal(p, &frame); err != nil {
+		return fmt.Errorf("%w: %v", messages.ErrInvalid, err)
+	}
+	if err := frame.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", messages.ErrInvalid, err)
+	}
+
+	// Clients cannot speak for others, or remove cursors
+	frame.Type = "cursor"
+	frame.UserID = c.UserID
+	frame.Username = c.Username
+	frame.Removed = false
+
+	t := &c.cursor
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return nil
+	}
+
+	// A timer due but not yet run relays this frame in place of the one it
+	// held, so the room never gets an older cursor after a newer one
+	wait := cursorInterval - time.Since(t.lastAt)
+	if wait <= 0 && t.timer == nil {
+		t.lastAt = time.Now()
+		c.broadcastCursor(frame)
+		return nil
+	}
+
+	t.pending = &frame
+	if t.timer == nil {
+		t.timer = time.AfterFunc(wait, c.flushCursor)
+	}
+	return nil
+}
+
+// flushCursor relays the latest cursor frame held back by the throttle.
+func (c *Client) flushCursor() {
+	t := &c.cursor
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.timer = nil
+	if t.stopped || t.pending == nil {
+		return
+	}
+	t.lastAt = time.Now()
+	c.broadcastCursor(*t.pending)
+	t.pending = nil
+}
+
+// removeCursor drops any cursor frame held back and tells the room the
+// client's cursor is gone, so it does not linger after a disconnect.
+func (c *Client) removeCursor() {
+	t := &c.cursor
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.stopped = true
+	t.pending = nil
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	// Only clients that showed a cursor have one to remove
+	if t.lastAt.IsZero() {
+		return
+	}
+	c.broadcastCursor(types.CursorFrame{Type: "cursor", UserID: c.UserID, Username: c.Username, Removed: true})
+}
+
+func (c *Client) broadcastCursor(frame types.CursorFrame) {
+	body, err := json.Marshal(frame)
+	if err != nil {
+		fmt.Println("[Client][Cursor] json marshalling error")
+		return
+	}
+	c.Broadcast(types.Message{
+		DocumentID: c.DocumentID,
+		Username:   c.Username,
+		UserID:     c.UserID,
+		TenantID:   c.TenantID,
+		Type:       1,
+		Body:       string(body),
+	})
+}
@@ -0,0 +1,205 @@
+package websocket
+
+import (
+	"UpdatesService/documentclient"
+	"UpdatesService/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"shared/messages"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cursors returns the cursor frames the client got within wait, with the
+// message each came in.
+func cursors(client *Client, wait time.Duration) ([]types.CursorFrame, []types.Message) {
+	var frames []types.CursorFrame
+	var carriers []types.Message
+	for _, message := range received(client, wait) {
+		var frame types.CursorFrame
+		if json.Unmarshal([]byte(message.Body), &frame) == nil && frame.Type == "cursor" {
+			frames = append(frames, frame)
+			carriers = append(carriers, message)
+		}
+	}
+	return frames, carriers
+}
+
+func cursorAt(x float64) []byte {
+	return []byte(fmt.Sprintf(`{"type":"cursor","slideId":"s-1","x":%g,"y":2,"selection":["o-1"]}`, x))
+}
+
+func TestCursorIsStampedWithTheSender(t *testing.T) {
+	backend := &recordingBackend{}
+	pool, redisClient := startProducingPool(t, backend)
+	alice := joinWith(pool, redisClient, "alice", documentclient.AccessRead)
+	bob := joinWith(pool, redisClient, "bob", documentclient.AccessWrite)
+
+	// Alice claims to be Bob, and that his cursor is gone
+	frame := `{"type":"cursor","userId":"bob","username":"bob","removed":true,"slideId":"s-1","x":10.5,"y":-3,"selection":["o-1","o-2"]}`
+	if err := alice.HandleMessage([]byte(frame)); err != nil {
+		t.Fatalf("HandleMessage: %v", err)
+	}
+
+	frames, carriers := cursors(bob, 200*time.Millisecond)
+	if len(frames) != 1 {
+		t.Fatalf("bob got %d cursor frames, want 1", len(frames))
+	}
+	got := frames[0]
+	if got.UserID != "alice" || got.Username != "alice" || got.Removed {
+		t.Errorf("relayed as %s (%s), removed %v, want alice's shown cursor", got.UserID, got.Username, got.Removed)
+	}
+	if got.SlideID != "s-1" || got.X != 10.5 || got.Y != -3 || strings.Join(got.Selection, ",") != "o-1,o-2" {
+		t.Errorf("relayed %+v, want the position and selection alice sent", got)
+	}
+	if carriers[0].UserID != "alice" || carriers[0].TenantID != "acme" || carriers[0].DocumentID != "doc-1" {
+		t.Errorf("carried by %+v, want alice's message on acme/doc-1", carriers[0])
+	}
+	if frames, _ := cursors(alice, 50*time.Millisecond); len(frames) != 0 {
+		t.Errorf("alice got her own cursor back: %+v", frames)
+	}
+	// Cursors are neither persisted nor produced
+	if n := backend.count(); n != 0 {
+		t.Errorf("%d cursor frames produced to Kafka, want none", n)
+	}
+}
+
+func TestEnvelopedCursorIsStamped(t *testing.T) {
+	pool := startPool(t)
+	alice := join(pool, "acme", "doc-1", "alice")
+	bob := join(pool, "acme", "doc-1", "bob")
+
+	if err := alice.HandleMessage([]byte(`{"type":"cursor","v":1,"payload":{"userId":"bob","x":1,"y":2}}`)); err != nil {
+		t.Fatalf("HandleMessage: %v", err)
+	}
+	frames, _ := cursors(bob, 200*time.Millisecond)
+	if len(frames) != 1 || frames[0].UserID != "alice" || frames[0].X != 1 || frames[0].Y != 2 {
+		t.Errorf("bob got %+v, want alice's cursor at 1,2", frames)
+	}
+}
+
+func TestCursorFramesAreCoalescedToTheLatest(t *testing.T) {
+	pool := startPool(t)
+	alice := join(pool, "acme", "doc-1", "alice")
+	bob := join(pool, "acme", "doc-1", "bob")
+
+	for x := 1; x <= 10; x++ {
+		if err := alice.HandleCursor(cursorAt(float64(x))); err != nil {
+			t.Fatalf("HandleCursor: %v", err)
+		}
+	}
+
+	// The first at once, the last once the interval is up, none between
+	frames, _ := cursors(bob, 4*cursorInterval)
+	var xs []float64
+	for _, frame := range frames {
+		xs = append(xs, frame.X)
+	}
+	if len(xs) != 2 || xs[0] != 1 || xs[1] != 10 {
+		t.Errorf("bob got cursors at %v, want [1 10]", xs)
+	}
+
+	// A frame after a pause is relayed at once
+	if err := alice.HandleCursor(cursorAt(11)); err != nil {
+		t.Fatal(err)
+	}
+	frames, _ = cursors(bob, cursorInterval/2)
+	if len(frames) != 1 || frames[0].X != 11 {
+		t.Errorf("after a pause, bob got %+v, want the cursor at 11 without waiting", frames)
+	}
+}
+
+func TestCursorRateIsBoundedPerClient(t *testing.T) {
+	pool := startPool(t)
+	alice := join(pool, "acme", "doc-1", "alice")
+	carol := join(pool, "acme", "doc-1", "carol")
+	bob := join(pool, "acme", "doc-1", "bob")
+
+	// Two clients moving their pointers every millisecond for 10 intervals
+	stop := time.Now().Add(10 * cursorInterval)
+	for x := 0; time.Now().Before(stop); x++ {
+		alice.HandleCursor(cursorAt(float64(x)))
+		carol.HandleCursor(cursorAt(float64(x)))
+		time.Sleep(time.Millisecond)
+	}
+
+	frames, _ := cursors(bob, 2*cursorInterval)
+	counts := map[string]int{}
+	last := map[string]float64{}
+	for _, frame := range frames {
+		if counts[frame.UserID] > 0 && frame.X <= last[frame.UserID] {
+			t.Errorf("%s's cursor at %g relayed after the one at %g", frame.UserID, frame.X, last[frame.UserID])
+		}
+		counts[frame.UserID]++
+		last[frame.UserID] = frame.X
+	}
+	for _, user := range []string{"alice", "carol"} {
+		// One per interval, the first and the last flush besides
+		if counts[user] == 0 || counts[user] > 12 {
+			t.Errorf("bob got %d cursor frames of %s over 10 intervals, want at most 12", counts[user], user)
+		}
+	}
+}
+
+func TestInvalidCursorFramesAreRefused(t *testing.T) {
+	pool := startPool(t)
+	alice := join(pool, "acme", "doc-1", "alice")
+	bob := join(pool, "acme", "doc-1", "bob")
+
+	tooMany := make([]string, types.MaxCursorSelection+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("o-%d", i)
+	}
+	selection, _ := json.Marshal(tooMany)
+
+	for _, frame := range []string{
+		`{"type":"cursor","x":"left","y":2}`,
+		`{"type":"cursor","x":1,"y":2,"selection":"o-1"}`,
+		`{"type":"cursor","x":1,"y":2,"selection":[""]}`,
+		`{"type":"cursor","x":1,"y":2,"selection":["` + strings.Repeat("o", types.MaxObjectIDLength+1) + `"]}`,
+		`{"type":"cursor","x":1,"y":2,"slideId":"` + strings.Repeat("s", types.MaxObjectIDLength+1) + `"}`,
+		`{"type":"cursor","x":1,"y":2,"selection":` + string(selection) + `}`,
+	} {
+		if err := alice.HandleMessage([]byte(frame)); !errors.Is(err, messages.ErrInvalid) {
+			t.Errorf("HandleMessage(%.60s) = %v, want ErrInvalid", frame, err)
+		}
+	}
+	if frames, _ := cursors(bob, 100*time.Millisecond); len(frames) != 0 {
+		t.Errorf("invalid cursor frames relayed: %+v", frames)
+	}
+}
+
+func TestCursorIsRemovedOnLeave(t *testing.T) {
+	pool := startPool(t)
+	alice := join(pool, "acme", "doc-1", "alice")
+	bob := join(pool, "acme", "doc-1", "bob")
+
+	alice.HandleCursor(cursorAt(1))
+	// Held back by the throttle, and dropped when alice leaves
+	alice.HandleCursor(cursorAt(2))
+	alice.removeCursor()
+
+	frames, _ := cursors(bob, 3*cursorInterval)
+	if len(frames) != 2 || frames[0].X != 1 || !frames[1].Removed || frames[1].UserID != "alice" {
+		t.Fatalf("bob got %+v, want alice's cursor at 1, then its removal", frames)
+	}
+
+	// Nothing of alice is relayed once she left
+	alice.HandleCursor(cursorAt(3))
+	if frames, _ := cursors(bob, 2*cursorInterval); len(frames) != 0 {
+		t.Errorf("relayed after leaving: %+v", frames)
+	}
+}
+
+func TestClientWithoutCursorLeavesNoRemoval(t *testing.T) {
+	pool := startPool(t)
+	alice := join(pool, "acme", "doc-1", "alice")
+	bob := join(pool, "acme", "doc-1", "bob")
+
+	alice.removeCursor()
+	if frames, _ := cursors(bob, 100*time.Millisecond); len(frames) != 0 {
+		t.Errorf("bob got %+v for a client that never showed a cursor", frames)
+	}
+}
@@ -0,0 +1,36 @@
+package websocket
+
+import (
+	"UpdatesService/config"
+	"encoding/json"
+	"fmt"
+	"shared/messages"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Hello answers the hello a client opened its session with, naming the
+// version of the protocol the session uses: the newest one the client
+// offered that the service speaks. It reports false when there is none,
+// having closed the connection with a protocol error that says which
+// versions the service speaks.
+func (c *Client) Hello(hello messages.Hello) bool {
+	version, ok := messages.Negotiate(hello.Versions)
+	if !ok {
+		fmt.Printf("[Client Reader] User %s on document %s offered no supported protocol version: %v\n", c.UserID, c.DocumentID, hello.Versions)
+		reason := fmt.Sprintf("unsupported protocol version, this server speaks %v", messages.SupportedVersions)
+		c.Conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseProtocolError, reason),
+			time.Now().Add(config.WebSocketConfig.WriteWait))
+		return false
+	}
+
+	jsonBytes, err := json.Marshal(messages.Hello{Type: messages.TypeHello, V: version, Versions: messages.SupportedVersions})
+	if err != nil {
+		fmt.Println("[Client Reader] json marshalling error")
+		return true
+	}
+	c.enqueue(jsonBytes)
+	return true
+}
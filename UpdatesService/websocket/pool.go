@@ -5,28 +5,110 @@ import (
 	"UpdatesService/types"
 	"encoding/json"
 	"fmt"
-
-	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"shared/tenant"
+	"sync"
+	"sync/atomic"
 )
 
+// RoomMessage is a message for the clients in a document's room. Sender is
+// the client it came from, which does not get it back; messages without one,
+// from the service itself, go to everyone in the room.
+type RoomMessage struct {
+	Message types.Message
+	Sender  *Client
+}
+
 type Pool struct {
 	Register      chan *Client
 	Unregister    chan *Client
-	RoomBroadcast chan types.Message
-	PushToKafka   chan types.KafkaInterMessage
-	Rooms         map[string]map[*Client]bool
-	KafkaProducer *kafka.Producer
+	RoomBroadcast chan RoomMessage
+	// Producer produces the clients' edits to Kafka
+	Producer *kafkaUtils.Producer
+
+	// rooms holds the clients connected to each document, by roomKey. Only
+	// the Start goroutine touches it; rooms are dropped with their last client.
+	rooms map[string]map[*Client]bool
+
+	// clients counts the registered clients. Once closing, the service
+	// accepts no more; goingAway and quit drive Shutdown.
+	clients   atomic.Int64
+	closing   atomic.Bool
+	goingAway chan struct{}
+	quit      chan struct{}
+
+	// presence holds the users in each room, by roomKey and user ID. The
+	// pool goroutine maintains it; Participants reads it from others.
+	presenceMu sync.RWMutex
+	presence   map[string]map[string]*participant
+
+	// relay exchanges client messages with the other instances, see UseRelay.
+	// channels counts the rooms of each document on this instance, which
+	// the subscriber, told of changes through resubscribe, joins on it.
+	relay       Relay
+	instanceID  string
+	relayOps    chan func()
+	relayed     chan types.Message
+	channelsMu  sync.Mutex
+	channels    map[string]int
+	resubscribe chan struct{}
+
+	// lockedRooms holds the rooms of documents their owner locked. Clients
+	// read it from their own goroutines, hence the mutex.
+	lockMu      sync.RWMutex
+	lockedRooms map[string]bool
 }
 
-func NewPool(p *kafka.Producer) *Pool {
+func NewPool(p *kafkaUtils.Producer) *Pool {
 	return &Pool{
 		Register:      make(chan *Client),
 		Unregister:    make(chan *Client),
-		RoomBroadcast: make(chan types.Message),
-		Rooms:         make(map[string]map[*Client]bool),
-		KafkaProducer: p,
-		PushToKafka:   make(chan types.KafkaInterMessage),
+		RoomBroadcast: make(chan RoomMessage),
+		rooms:         make(map[string]map[*Client]bool),
+		channels:      make(map[string]int),
+		presence:      make(map[string]map[string]*participant),
+		goingAway:     make(chan struct{}),
+		quit:          make(chan struct{}),
+		Producer:      p,
+		lockedRooms:   make(map[string]bool),
+	}
+}
+
+// roomKey scopes a document room to its tenant so clients of different tenants
+// can never share a room, even if document IDs collide.
+func roomKey(tenantID string, documentID string) string {
+	return tenant.Normalize(tenantID) + "/" + documentID
+}
+
+// SetLocked records whether the document is locked and tells its room.
+func (pool *Pool) SetLocked(tenantID string, documentID string, locked bool) {
+	room := roomKey(tenantID, documentID)
+	pool.lockMu.Lock()
+	if locked {
+		pool.lockedRooms[room] = true
+	} else {
+		delete(pool.lockedRooms, room)
 	}
+	pool.lockMu.Unlock()
+
+	body := `{"action": "notification", "value": "Document unlocked", "locked": false}`
+	if locked {
+		body = `{"action": "notification", "value": "Document locked", "locked": true}`
+	}
+	// No sender, so everyone in the room is told
+	pool.RoomBroadcast <- RoomMessage{Message: types.Message{
+		DocumentID: documentID,
+		TenantID:   tenantID,
+		Type:       1,
+		Body:       body,
+	}}
+}
+
+// IsLocked reports whether the document is locked, as far as this instance
+// has seen.
+func (pool *Pool) IsLocked(tenantID string, documentID string) bool {
+	pool.lockMu.RLock()
+	defer pool.lockMu.RUnlock()
+	return pool.lockedRooms[roomKey(tenantID, documentID)]
 }
 
 func SerializeMessage(message types.Message) ([]byte, error) {
@@ -37,86 +119,90 @@ func SerializeMessage(message types.Message) ([]byte, error) {
 	return serialized, nil
 }
 
+// deliver sends a message to every client in its room but sender.
+func (pool *Pool) deliver(message types.Message, sender *Client) {
+	fmt.Printf("Broadcasting to room -> ")
+	room, ok := pool.rooms[roomKey(message.TenantID, message.DocumentID)]
+	if !ok {
+		fmt.Println("Room is empty")
+		return
+	}
+
+	// Convert message (struct) to []byte
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		fmt.Println("[Pool][RoomBroadcast] json Marshalling error")
+		return
+	}
+
+	for client := range room {
+		// The sender's other connections, in other tabs, do get it
+		if client == sender {
+			continue
+		}
+		client.enqueue(jsonData)
+	}
+
+	fmt.Println("Broadcasted!")
+}
+
 func (pool *Pool) Start() types.Message {
 	for {
 		select {
 		case client := <-pool.Register:
 			fmt.Println("Trying to register a client")
 
-			if _, ok := pool.Rooms[client.DocumentID]; !ok {
-				pool.Rooms[client.DocumentID] = make(map[*Client]bool)
+			room := roomKey(client.TenantID, client.DocumentID)
+			if _, ok := pool.rooms[room]; !ok {
+				pool.rooms[room] = make(map[*Client]bool)
+				pool.joinRelay(client.DocumentID)
 			}
 
-			pool.Rooms[client.DocumentID][client] = true
-
-			for c := range pool.Rooms[client.DocumentID] {
-				message, err := json.Marshal(types.Message{
-					DocumentID: c.DocumentID,
-					UserID:     c.UserID,
-					Username:   c.Username,
-					Type:       1,
-					Body:       `{"action": "notification", "value": "New user joined"}`,
-				})
-
-				if err != nil {
-					fmt.Println("[Pool][Register] json marshalling error")
-					break
-				}
+			pool.rooms[room][client] = true
+			pool.clients.Add(1)
 
+			// Further connections of a user already in the room are not news
+			if pool.addParticipant(client) {
 				fmt.Println("[Pool][Register] Sending new user joined message")
-				client.Send <- message
+				pool.announce(room, types.PresenceFrame{Event: types.PresenceJoin, UserID: client.UserID, Username: client.Username}, client)
 			}
+			pool.sendParticipants(client)
 			fmt.Println("Client registered")
 
 		case client := <-pool.Unregister:
-			delete(pool.Rooms[client.DocumentID], client)
-			for c := range pool.Rooms[client.DocumentID] {
-				message, err := json.Marshal(types.Message{
-					DocumentID: c.DocumentID,
-					UserID:     c.UserID,
-					Username:   c.Username,
-					Type:       1,
-					Body:       `{"action": "notification", "value": "User disconnected"}`,
-				})
-
-				if err != nil {
-					fmt.Println("[Pool][Unregister] json marshalling error")
-					continue
-				}
-
-				client.Send <- message
+			room := roomKey(client.TenantID, client.DocumentID)
+			if _, ok := pool.rooms[room][client]; !ok {
+				break
+			}
+			delete(pool.rooms[room], client)
+			pool.clients.Add(-1)
+			if len(pool.rooms[room]) == 0 {
+				delete(pool.rooms, room)
+				pool.leaveRelay(client.DocumentID)
 			}
 
-		case message := <-pool.RoomBroadcast:
-			fmt.Printf("Broadcasting to room -> ")
-			for client := range pool.Rooms[message.DocumentID] {
-				if client.UserID == message.UserID {
-					continue
-				}
-
-				// Convert message (struct) to []byte
-				jsonData, err := json.Marshal(message)
-				if err != nil {
-					fmt.Println("[Pool][RoomBroadcast] json Marshalling error")
-					break
-				}
-
-				client.Send <- jsonData
+			// The user leaves with their last connection
+			if pool.removeParticipant(client) {
+				pool.announce(room, types.PresenceFrame{Event: types.PresenceLeave, UserID: client.UserID, Username: client.Username}, nil)
 			}
 
-			fmt.Println("Broadcasted!")
+		case broadcast := <-pool.RoomBroadcast:
+			pool.deliver(broadcast.Message, broadcast.Sender)
 
-		case message := <-pool.PushToKafka:
-			fmt.Println("[Pool][PushToKafka] Pushing message to kafka!")
-			serialized, err := SerializeMessage(message.Message)
-			if err != nil {
-				fmt.Println("[Pool][PushToKafka]", err)
-				break
-			}
-			err = kafkaUtils.ProduceMessage(pool.KafkaProducer, message.Topic, serialized)
-			if err != nil {
-				fmt.Println("[Pool][PushToKafka] Error pushing message to kafka: ", err)
+			// Messages of the service itself are made by every instance
+			if broadcast.Sender != nil {
+				pool.publish(broadcast.Message)
 			}
+
+		case message := <-pool.relayed:
+			pool.deliver(message, nil)
+
+		case <-pool.goingAway:
+			pool.closeAll()
+
+		case <-pool.quit:
+			fmt.Println("[Pool] Stopped")
+			return types.Message{}
 		}
 
 	}
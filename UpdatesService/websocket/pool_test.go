@@ -0,0 +1,145 @@
+package websocket
+
+import (
+	"UpdatesService/types"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// startPool runs a pool without Kafka or a relay for the test.
+func startPool(t *testing.T) *Pool {
+	t.Helper()
+	pool := NewPool(nil)
+	go pool.Start()
+	t.Cleanup(func() { close(pool.quit) })
+	return pool
+}
+
+// join registers a client without a connection; what the pool sends it
+// waits in its Send channel.
+func join(pool *Pool, tenantID string, documentID string, userID string) *Client {
+	client := &Client{
+		UserID:     userID,
+		Username:   userID,
+		TenantID:   tenantID,
+		DocumentID: documentID,
+		Pool:       pool,
+		Send:       make(chan []byte, 64),
+	}
+	pool.Register <- client
+	return client
+}
+
+// received drains the room messages the client got within wait, leaving
+// out presence frames.
+func received(client *Client, wait time.Duration) []types.Message {
+	var messages []types.Message
+	timeout := time.After(wait)
+	for {
+		select {
+		case data := <-client.Send:
+			var frame struct {
+				Type string `json:"type"`
+			}
+			json.Unmarshal(data, &frame)
+			if frame.Type == "presence" {
+				continue
+			}
+			var message types.Message
+			if err := json.Unmarshal(data, &message); err == nil {
+				messages = append(messages, message)
+			}
+		case <-timeout:
+			return messages
+		}
+	}
+}
+
+func TestRoomsAreScopedToTenant(t *testing.T) {
+	pool := startPool(t)
+
+	// Two tenants happen to have a document with the same ID
+	sender := join(pool, "acme", "doc-1", "alice")
+	colleague := join(pool, "acme", "doc-1", "bob")
+	stranger := join(pool, "globex", "doc-1", "mallory")
+
+	pool.RoomBroadcast <- RoomMessage{Sender: sender, Message: types.Message{
+		DocumentID: "doc-1", TenantID: "acme", UserID: "alice", Body: `{"action":"update"}`,
+	}}
+
+	if got := received(colleague, 200*time.Millisecond); len(got) != 1 {
+		t.Errorf("client of the same tenant got %d messages, want 1", len(got))
+	}
+	if got := received(stranger, 50*time.Millisecond); len(got) != 0 {
+		t.Errorf("client of another tenant got %v", got)
+	}
+	if got := pool.Participants("globex", "doc-1"); len(got) != 1 || got[0].UserID != "mallory" {
+		t.Errorf("participants of the other tenant = %v, want only mallory", got)
+	}
+}
+
+func TestBroadcastsStayInTheirDocument(t *testing.T) {
+	pool := startPool(t)
+	alice := join(pool, "acme", "doc-1", "alice")
+	bob := join(pool, "acme", "doc-1", "bob")
+	carol := join(pool, "acme", "doc-2", "carol")
+	dave := join(pool, "acme", "doc-2", "dave")
+
+	pool.RoomBroadcast <- RoomMessage{Sender: alice, Message: types.Message{
+		DocumentID: "doc-1", TenantID: "acme", UserID: "alice", Body: `{"action":"add_slide","slideId":"s-1"}`,
+	}}
+	pool.RoomBroadcast <- RoomMessage{Sender: dave, Message: types.Message{
+		DocumentID: "doc-2", TenantID: "acme", UserID: "dave", Body: `{"action":"add_slide","slideId":"s-2"}`,
+	}}
+
+	tests := []struct {
+		client *Client
+		from   string
+	}{
+		{bob, "alice"},
+		{carol, "dave"},
+	}
+	for _, tt := range tests {
+		got := received(tt.client, 200*time.Millisecond)
+		if len(got) != 1 || got[0].UserID != tt.from {
+			t.Errorf("%s got %v, want only the message of %s", tt.client.UserID, got, tt.from)
+		}
+	}
+	// Senders do not get their own messages back
+	for _, sender := range []*Client{alice, dave} {
+		if got := received(sender, 50*time.Millisecond); len(got) != 0 {
+			t.Errorf("%s got %v back", sender.UserID, got)
+		}
+	}
+}
+
+func TestEmptyRoomsAreDropped(t *testing.T) {
+	pool := NewPool(nil)
+	stopped := make(chan struct{})
+	go func() {
+		pool.Start()
+		close(stopped)
+	}()
+
+	alice := join(pool, "acme", "doc-1", "alice")
+	bob := join(pool, "acme", "doc-1", "bob")
+	carol := join(pool, "acme", "doc-2", "carol")
+	pool.Unregister <- alice
+	pool.Unregister <- bob
+	// Unregistering twice changes nothing
+	pool.Unregister <- bob
+
+	// The rooms are the pool goroutine's until it stops
+	close(pool.quit)
+	<-stopped
+	if _, ok := pool.rooms[roomKey("acme", "doc-1")]; ok {
+		t.Error("room of doc-1 kept after its last client left")
+	}
+	if room := pool.rooms[roomKey("acme", "doc-2")]; len(room) != 1 || !room[carol] {
+		t.Errorf("room of doc-2 = %v, want carol", room)
+	}
+	if got := pool.Participants("acme", "doc-1"); len(got) != 0 {
+		t.Errorf("participants of the empty room = %v", got)
+	}
+}
@@ -0,0 +1,111 @@
+package websocket
+
+import (
+	"UpdatesService/types"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// participant is a user in a room with the number of connections they have
+// to it, from several tabs or devices. They join with the first and leave
+// with the last.
+type participant struct {
+	types.Participant
+	connections int
+}
+
+// addParticipant records a client's connection to its room and reports
+// whether its user just joined.
+func (pool *Pool) addParticipant(client *Client) bool {
+	room := roomKey(client.TenantID, client.DocumentID)
+	pool.presenceMu.Lock()
+	defer pool.presenceMu.Unlock()
+
+	if _, ok := pool.presence[room]; !ok {
+		pool.presence[room] = make(map[string]*participant)
+	}
+	p, ok := pool.presence[room][client.UserID]
+	if !ok {
+		p = &participant{Participant: types.Participant{
+			UserID:      client.UserID,
+			Username:    client.Username,
+			ConnectedAt: time.Now().UTC(),
+		}}
+		pool.presence[room][client.UserID] = p
+	}
+	p.connections++
+	return !ok
+}
+
+// removeParticipant forgets a client's connection and reports whether its
+// user just left the room.
+func (pool *Pool) removeParticipant(client *Client) bool {
+	room := roomKey(client.TenantID, client.DocumentID)
+	pool.presenceMu.Lock()
+	defer pool.presenceMu.Unlock()
+
+	p, ok := pool.presence[room][client.UserID]
+	if !ok {
+		return false
+	}
+	p.connections--
+	if p.connections > 0 {
+		return false
+	}
+	delete(pool.presence[room], client.UserID)
+	if len(pool.presence[room]) == 0 {
+		delete(pool.presence, room)
+	}
+	return true
+}
+
+// Participants returns the users connected to the document through this
+// instance, earliest first.
+func (pool *Pool) Participants(tenantID string, documentID string) []types.Participant {
+	pool.presenceMu.RLock()
+	defer pool.presenceMu.RUnlock()
+
+	room := pool.presence[roomKey(tenantID, documentID)]
+	participants := make([]types.Participant, 0, len(room))
+	for _, p := range room {
+		participants = append(participants, p.Participant)
+	}
+	sort.Slice(participants, func(i, j int) bool {
+		if !participants[i].ConnectedAt.Equal(participants[j].ConnectedAt) {
+			return participants[i].ConnectedAt.Before(participants[j].ConnectedAt)
+		}
+		return participants[i].UserID < participants[j].UserID
+	})
+	return participants
+}
+
+// announce sends a presence frame to every client in the room but except.
+func (pool *Pool) announce(room string, frame types.PresenceFrame, except *Client) {
+	frame.Type = "presence"
+	message, err := json.Marshal(frame)
+	if err != nil {
+		fmt.Println("[Pool][Presence] json marshalling error")
+		return
+	}
+	for c := range pool.rooms[room] {
+		if c != except {
+			c.enqueue(message)
+		}
+	}
+}
+
+// sendParticipants tells a newcomer who is in its room, itself included.
+func (pool *Pool) sendParticipants(client *Client) {
+	message, err := json.Marshal(types.PresenceFrame{
+		Type:         "presence",
+		Event:        types.PresenceList,
+		Participants: pool.Participants(client.TenantID, client.DocumentID),
+	})
+	if err != nil {
+		fmt.Println("[Pool][Presence] json marshalling error")
+		return
+	}
+	client.enqueue(message)
+}
@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"UpdatesService/config"
+	"UpdatesService/metrics"
+	"UpdatesService/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"shared/messages"
+	"time"
+)
+
+// errThrottled is returned by HandleMessage for edits over the client's rate
+// limit; errRateAbuse once the client kept exceeding it for longer than
+// RateLimitConfig.AbuseAfter.
+var (
+	errThrottled = errors.New("rate limit exceeded")
+	errRateAbuse = errors.New("rate limit exceeded for too long")
+)
+
+// tokenBucket allows frames at a rate a second on average, in bursts of up
+// to burst. The zero value is a full bucket. Only the read loop uses it.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	// exhaustedSince is when the bucket first refused a frame since it was
+	// last full, zero while it has not
+	exhaustedSince time.Time
+}
+
+// take takes a token as of now, refilled at rate up to burst, and reports
+// whether there was one.
+func (b *tokenBucket) take(rate float64, burst int, now time.Time) bool {
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens = min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+	if b.tokens >= float64(burst) {
+		b.exhaustedSince = time.Time{}
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+	if b.exhaustedSince.IsZero() {
+		b.exhaustedSince = now
+	}
+	return false
+}
+
+// retryAfter is how long until the bucket holds a token again.
+func (b *tokenBucket) retryAfter(rate float64) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// limit takes a token for a frame of the given type from the client's edit or
+// cursor budget as of now. Over the limit, cursor and presence frames are
+// dropped, which the client is not told of, as with the cursor throttle;
+// edits fail with errThrottled. Both fail with errRateAbuse once the budget
+// stayed exhausted for too long.
+func (c *Client) limit(frameType string, now time.Time) (bool, error) {
+	cfg := config.RateLimitConfig
+	bucket, rate, burst := &c.cursorBudget, cfg.CursorRate, cfg.CursorBurst
+	if frameType == messages.TypeEdit {
+		bucket, rate, burst = &c.editBudget, cfg.EditRate, cfg.EditBurst
+	}
+	if bucket.take(rate, burst, now) {
+		return true, nil
+	}
+
+	metrics.ThrottledMessages.Add(1)
+	if now.Sub(bucket.exhaustedSince) > cfg.AbuseAfter {
+		return false, errRateAbuse
+	}
+	if frameType == messages.TypeEdit {
+		return false, errThrottled
+	}
+	return false, nil
+}
+
+// ThrottleFrame tells the client its edit was dropped for exceeding its rate
+// limit, and when it may send the next.
+func (c *Client) ThrottleFrame() error {
+	retryAfter := c.editBudget.retryAfter(config.RateLimitConfig.EditRate)
+	jsonBytes, err := json.Marshal(types.ThrottleFrame{Type: "throttle", RetryAfterMs: retryAfter.Milliseconds()})
+	if err != nil {
+		return fmt.Errorf("[Error] failure to marshal throttle frame")
+	}
+	c.enqueue(jsonBytes)
+	return nil
+}
@@ -0,0 +1,133 @@
+package websocket
+
+import (
+	"UpdatesService/config"
+	"errors"
+	"shared/messages"
+	"testing"
+	"time"
+)
+
+func withRateLimit(t *testing.T, limits config.RateLimitConfigStruct) {
+	t.Helper()
+	saved := config.RateLimitConfig
+	config.RateLimitConfig = limits
+	t.Cleanup(func() { config.RateLimitConfig = saved })
+}
+
+func TestTokenBucketRefills(t *testing.T) {
+	var bucket tokenBucket
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		if !bucket.take(2, 3, now) {
+			t.Fatalf("frame %d of a burst of 3 refused", i+1)
+		}
+	}
+	if bucket.take(2, 3, now) {
+		t.Fatal("frame past the burst allowed")
+	}
+	if got := bucket.retryAfter(2); got != 500*time.Millisecond {
+		t.Errorf("retryAfter = %v, want 500ms at 2 a second", got)
+	}
+
+	// Half a token is not enough
+	if bucket.take(2, 3, now.Add(250*time.Millisecond)) {
+		t.Error("frame allowed a quarter second in")
+	}
+	if !bucket.take(2, 3, now.Add(500*time.Millisecond)) {
+		t.Error("frame refused once a token refilled")
+	}
+
+	// A long pause refills up to the burst, not beyond
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !bucket.take(2, 3, later) {
+			t.Fatalf("frame %d after a pause refused", i+1)
+		}
+	}
+	if bucket.take(2, 3, later) {
+		t.Error("pause refilled the bucket beyond its burst")
+	}
+}
+
+func TestLimit(t *testing.T) {
+	withRateLimit(t, config.RateLimitConfigStruct{
+		EditRate: 1, EditBurst: 2,
+		CursorRate: 1, CursorBurst: 1,
+		AbuseAfter: 5 * time.Second,
+	})
+	client := &Client{UserID: "alice", DocumentID: "doc-1"}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		frameType string
+		at        time.Duration
+		allowed   bool
+		err       error
+	}{
+		{"first edit", messages.TypeEdit, 0, true, nil},
+		{"second edit", messages.TypeEdit, 0, true, nil},
+		{"edit past the burst", messages.TypeEdit, 0, false, errThrottled},
+		// Cursor frames have a budget of their own, and are dropped quietly
+		{"cursor", messages.TypeCursor, 0, true, nil},
+		{"cursor past the burst", messages.TypeCursor, 0, false, nil},
+		{"presence past the burst", messages.TypePresence, 0, false, nil},
+		{"edit once refilled", messages.TypeEdit, time.Second, true, nil},
+		{"edit refused again", messages.TypeEdit, time.Second, false, errThrottled},
+	}
+	for _, tt := range tests {
+		allowed, err := client.limit(tt.frameType, now.Add(tt.at))
+		if allowed != tt.allowed || !errors.Is(err, tt.err) {
+			t.Errorf("%s: limit = %v, %v, want %v, %v", tt.name, allowed, err, tt.allowed, tt.err)
+		}
+	}
+}
+
+func TestLimitDisconnectsAbuse(t *testing.T) {
+	withRateLimit(t, config.RateLimitConfigStruct{
+		EditRate: 1, EditBurst: 2,
+		CursorRate: 1, CursorBurst: 2,
+		AbuseAfter: 5 * time.Second,
+	})
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, frameType := range []string{messages.TypeEdit, messages.TypeCursor} {
+		client := &Client{UserID: "alice", DocumentID: "doc-1"}
+		// Ten frames a second against a rate of one: the bucket is never
+		// full again after the first refusal, at 200ms
+		var abusedAt time.Duration
+		for at := time.Duration(0); at <= 10*time.Second; at += 100 * time.Millisecond {
+			if _, err := client.limit(frameType, now.Add(at)); errors.Is(err, errRateAbuse) {
+				abusedAt = at
+				break
+			}
+		}
+		if want := 5*time.Second + 300*time.Millisecond; abusedAt != want {
+			t.Errorf("%s frames: errRateAbuse at %v, want %v, past AbuseAfter from the first refusal", frameType, abusedAt, want)
+		}
+	}
+}
+
+func TestLimitForgivesOnceRefilled(t *testing.T) {
+	withRateLimit(t, config.RateLimitConfigStruct{
+		EditRate: 1, EditBurst: 1,
+		CursorRate: 1, CursorBurst: 1,
+		AbuseAfter: 5 * time.Second,
+	})
+	client := &Client{UserID: "alice", DocumentID: "doc-1"}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	client.limit(messages.TypeEdit, now)
+	if _, err := client.limit(messages.TypeEdit, now); !errors.Is(err, errThrottled) {
+		t.Fatalf("edit past the burst = %v, want errThrottled", err)
+	}
+	// Back at a full bucket, the earlier refusal no longer counts
+	if allowed, _ := client.limit(messages.TypeEdit, now.Add(4*time.Second)); !allowed {
+		t.Fatal("edit refused once the bucket refilled")
+	}
+	if _, err := client.limit(messages.TypeEdit, now.Add(4*time.Second)); !errors.Is(err, errThrottled) {
+		t.Errorf("edit over the limit after a pause = %v, want errThrottled, not errRateAbuse", err)
+	}
+}
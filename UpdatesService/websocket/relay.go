@@ -0,0 +1,207 @@
+package websocket
+
+import (
+	"UpdatesService/metrics"
+	"UpdatesService/types"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Relay carries room messages between the instances of the service, so that
+// clients of one document connected to different instances see each other's
+// updates. redis.RoomRelay implements it over pub/sub.
+type Relay interface {
+	Publish(ctx context.Context, documentID string, payload []byte) error
+	Join(ctx context.Context, documentID string) error
+	Leave(ctx context.Context, documentID string) error
+	Messages() <-chan []byte
+}
+
+// relayTimeout bounds every relay call, so a Redis outage cannot hold up the
+// relay worker for long.
+const relayTimeout = 2 * time.Second
+
+// relayQueueSize is how many relay calls may wait for the worker. Messages
+// published beyond it are dropped rather than stalling the pool.
+const relayQueueSize = 1024
+
+// resubscribeInterval is how often the subscriber retries the joins and
+// leaves that failed.
+const resubscribeInterval = 5 * time.Second
+
+// relayedMessage is a room message as published to the other instances.
+// Instance lets an instance skip its own messages when they come back.
+type relayedMessage struct {
+	Instance string        `json:"instance"`
+	Message  types.Message `json:"message"`
+}
+
+// UseRelay makes the pool exchange the messages clients send with the other
+// instances through relay, for the documents it has clients of. Call it
+// before Start.
+func (pool *Pool) UseRelay(relay Relay) {
+	pool.relay = relay
+	pool.instanceID = newInstanceID()
+	pool.relayOps = make(chan func(), relayQueueSize)
+	pool.relayed = make(chan types.Message)
+	pool.resubscribe = make(chan struct{}, 1)
+
+	go func() {
+		for op := range pool.relayOps {
+			op()
+		}
+	}()
+	go pool.subscribe()
+
+	go func() {
+		for payload := range relay.Messages() {
+			var relayed relayedMessage
+			if err := json.Unmarshal(payload, &relayed); err != nil {
+				fmt.Printf("[Pool][Relay] Dropping malformed relayed message: %v\n", err)
+				continue
+			}
+			if relayed.Instance == pool.instanceID {
+				continue
+			}
+			pool.relayed <- relayed.Message
+		}
+	}()
+}
+
+// publish sends a message a client of this instance sent to the other
+// instances. It never blocks the pool: when the relay falls behind, the
+// message only reaches this instance's clients.
+func (pool *Pool) publish(message types.Message) {
+	if pool.relay == nil {
+		return
+	}
+
+	payload, err := json.Marshal(relayedMessage{Instance: pool.instanceID, Message: message})
+	if err != nil {
+		fmt.Println("[Pool][Relay] json Marshalling error")
+		return
+	}
+	op := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), relayTimeout)
+		defer cancel()
+		if err := pool.relay.Publish(ctx, message.DocumentID, payload); err != nil {
+			metrics.RelayFailures.Add(1)
+			fmt.Printf("[Pool][Relay] Error publishing to document %s: %v\n", message.DocumentID, err)
+		}
+	}
+	select {
+	case pool.relayOps <- op:
+	default:
+		metrics.RelayFailures.Add(1)
+		fmt.Printf("[Pool][Relay] Relay queue full, not publishing to document %s\n", message.DocumentID)
+	}
+}
+
+// joinRelay subscribes to a document's messages when its first room opens on
+// this instance. Rooms of different tenants may share a document ID, and
+// hence a channel.
+func (pool *Pool) joinRelay(documentID string) {
+	if pool.relay == nil {
+		return
+	}
+
+	pool.channelsMu.Lock()
+	pool.channels[documentID]++
+	first := pool.channels[documentID] == 1
+	pool.channelsMu.Unlock()
+	if first {
+		pool.wakeSubscriber()
+	}
+}
+
+// leaveRelay unsubscribes from a document's messages once its last room on
+// this instance closed.
+func (pool *Pool) leaveRelay(documentID string) {
+	if pool.relay == nil {
+		return
+	}
+
+	pool.channelsMu.Lock()
+	pool.channels[documentID]--
+	last := pool.channels[documentID] <= 0
+	if last {
+		delete(pool.channels, documentID)
+	}
+	pool.channelsMu.Unlock()
+	if last {
+		pool.wakeSubscriber()
+	}
+}
+
+// wakeSubscriber tells the subscriber the documents with rooms changed. It
+// never blocks the pool: a wake-up already pending covers the change.
+func (pool *Pool) wakeSubscriber() {
+	select {
+	case pool.resubscribe <- struct{}{}:
+	default:
+	}
+}
+
+// subscribe keeps the relay joined to the documents with rooms on this
+// instance, and to no others, until the pool quits. It compares them with
+// those it joined whenever they change, so that a slow relay only delays
+// the joins and leaves, and never in the wrong order; failed ones are
+// retried every resubscribeInterval.
+func (pool *Pool) subscribe() {
+	joined := make(map[string]bool)
+	retry := time.NewTicker(resubscribeInterval)
+	defer retry.Stop()
+
+	for {
+		select {
+		case <-pool.quit:
+			return
+		case <-pool.resubscribe:
+		case <-retry.C:
+		}
+
+		pool.channelsMu.Lock()
+		wanted := make(map[string]bool, len(pool.channels))
+		for documentID := range pool.channels {
+			wanted[documentID] = true
+		}
+		pool.channelsMu.Unlock()
+
+		for documentID := range wanted {
+			if !joined[documentID] && pool.relayCall("joining", documentID, pool.relay.Join) {
+				joined[documentID] = true
+			}
+		}
+		for documentID := range joined {
+			if !wanted[documentID] && pool.relayCall("leaving", documentID, pool.relay.Leave) {
+				delete(joined, documentID)
+			}
+		}
+	}
+}
+
+// relayCall makes a Join or Leave of the relay, reporting whether it
+// succeeded.
+func (pool *Pool) relayCall(doing string, documentID string, call func(ctx context.Context, documentID string) error) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), relayTimeout)
+	defer cancel()
+	if err := call(ctx, documentID); err != nil {
+		metrics.RelayFailures.Add(1)
+		fmt.Printf("[Pool][Relay] Error %s document %s: %v\n", doing, documentID, err)
+		return false
+	}
+	return true
+}
+
+func newInstanceID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand does not fail on supported platforms
+		return fmt.Sprint(time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
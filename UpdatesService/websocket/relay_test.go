@@ -0,0 +1,198 @@
+package websocket
+
+import (
+	"UpdatesService/redis"
+	"UpdatesService/types"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// startRelayedPool runs a pool relaying its rooms through the Redis at addr,
+// as another instance of the service would.
+func startRelayedPool(t *testing.T, addr string) *Pool {
+	t.Helper()
+	relay := redis.NewRedisClient(addr, "").RoomRelay()
+	pool := NewPool(nil)
+	pool.UseRelay(relay)
+	go pool.Start()
+	t.Cleanup(func() {
+		close(pool.quit)
+		relay.Close()
+	})
+	return pool
+}
+
+// awaitSubscribers waits until n instances joined the document's channel.
+func awaitSubscribers(t *testing.T, server *miniredis.Miniredis, documentID string, n int) {
+	t.Helper()
+	channel := redis.RoomChannel(documentID)
+	deadline := time.Now().Add(2 * time.Second)
+	for server.PubSubNumSub(channel)[channel] < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d instances never joined %s", n, channel)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRelaySyncsRoomsAcrossInstances(t *testing.T) {
+	server := miniredis.RunT(t)
+	first := startRelayedPool(t, server.Addr())
+	second := startRelayedPool(t, server.Addr())
+
+	alice := join(first, "acme", "doc-1", "alice")
+	bob := join(first, "acme", "doc-1", "bob")
+	carol := join(second, "acme", "doc-1", "carol")
+	dave := join(second, "acme", "doc-2", "dave")
+	awaitSubscribers(t, server, "doc-1", 2)
+
+	first.RoomBroadcast <- RoomMessage{Sender: alice, Message: types.Message{
+		DocumentID: "doc-1", TenantID: "acme", UserID: "alice", Body: `{"action":"add_slide","slideId":"s-1"}`,
+	}}
+	second.RoomBroadcast <- RoomMessage{Sender: carol, Message: types.Message{
+		DocumentID: "doc-1", TenantID: "acme", UserID: "carol", Body: `{"action":"add_slide","slideId":"s-2"}`,
+	}}
+
+	// Each gets the other's message once; an instance skips its own when the
+	// relay hands them back
+	tests := []struct {
+		client *Client
+		from   []string
+	}{
+		{alice, []string{"carol"}},
+		{bob, []string{"alice", "carol"}},
+		{carol, []string{"alice"}},
+		{dave, nil},
+	}
+	for _, tt := range tests {
+		got := received(tt.client, 300*time.Millisecond)
+		senders := make(map[string]int)
+		for _, m := range got {
+			senders[m.UserID]++
+		}
+		if len(got) != len(tt.from) {
+			t.Errorf("%s got %v, want one message each of %v", tt.client.UserID, got, tt.from)
+			continue
+		}
+		for _, from := range tt.from {
+			if senders[from] != 1 {
+				t.Errorf("%s got %d messages of %s, want 1", tt.client.UserID, senders[from], from)
+			}
+		}
+	}
+}
+
+func TestRelayLeavesEmptyRooms(t *testing.T) {
+	server := miniredis.RunT(t)
+	pool := startRelayedPool(t, server.Addr())
+
+	alice := join(pool, "acme", "doc-1", "alice")
+	// Another tenant's document of the same ID shares the channel
+	mallory := join(pool, "globex", "doc-1", "mallory")
+	awaitSubscribers(t, server, "doc-1", 1)
+
+	pool.Unregister <- alice
+	pool.Unregister <- mallory
+	channel := redis.RoomChannel("doc-1")
+	deadline := time.Now().Add(2 * time.Second)
+	for server.PubSubNumSub(channel)[channel] > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("instance still subscribed to %s after its rooms closed", channel)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// stalledRelay is a relay whose joins and leaves wait for release, as over a
+// Redis that stopped answering, and that keeps which documents it joined.
+type stalledRelay struct {
+	release chan struct{}
+	mu      sync.Mutex
+	joined  map[string]bool
+	strays  int
+}
+
+func (r *stalledRelay) Publish(ctx context.Context, documentID string, payload []byte) error {
+	return nil
+}
+
+func (r *stalledRelay) Join(ctx context.Context, documentID string) error {
+	<-r.release
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.joined[documentID] = true
+	return nil
+}
+
+func (r *stalledRelay) Leave(ctx context.Context, documentID string) error {
+	<-r.release
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Leaving a document not joined means a leave overtook its join
+	if !r.joined[documentID] {
+		r.strays++
+	}
+	delete(r.joined, documentID)
+	return nil
+}
+
+func (r *stalledRelay) Messages() <-chan []byte { return nil }
+
+func TestStalledRelayDoesNotStallRooms(t *testing.T) {
+	relay := &stalledRelay{release: make(chan struct{}), joined: make(map[string]bool)}
+	pool := NewPool(nil)
+	pool.UseRelay(relay)
+	go pool.Start()
+	t.Cleanup(func() { close(pool.quit) })
+
+	// Far more rooms open and close than relay calls can wait
+	const n = 2 * relayQueueSize
+	clients := make([]*Client, n)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range clients {
+			clients[i] = join(pool, "acme", fmt.Sprintf("doc-%d", i), "alice")
+		}
+		for i := 0; i < n; i += 2 {
+			pool.Unregister <- clients[i]
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("pool stalled behind the relay")
+	}
+
+	// Once the relay answers, it is joined to the documents still open, and
+	// to those only
+	close(relay.release)
+	open := func() bool {
+		relay.mu.Lock()
+		defer relay.mu.Unlock()
+		if relay.strays != 0 {
+			t.Fatalf("%d documents left before they were joined", relay.strays)
+		}
+		if len(relay.joined) != n/2 {
+			return false
+		}
+		for i := 1; i < n; i += 2 {
+			if !relay.joined[fmt.Sprintf("doc-%d", i)] {
+				return false
+			}
+		}
+		return true
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !open() {
+		if time.Now().After(deadline) {
+			t.Fatalf("relay not joined to exactly the %d documents still open", n/2)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
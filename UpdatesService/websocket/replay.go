@@ -0,0 +1,117 @@
+package websocket
+
+import (
+	"UpdatesService/config"
+	"UpdatesService/metrics"
+	"UpdatesService/redis"
+	"UpdatesService/types"
+	"context"
+	"encoding/json"
+	"fmt"
+	"shared/tenant"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// streamTimeout bounds keeping an edit in its document's stream, which holds
+// up the read loop.
+const streamTimeout = 100 * time.Millisecond
+
+// record keeps an edit in its document's update stream and returns its ID
+// there, its Seq. When that fails it returns "", and clients resuming from
+// before the edit will not get it replayed.
+func (c *Client) record(outMsg types.Message) string {
+	payload, err := json.Marshal(outMsg)
+	if err != nil {
+		fmt.Println("[Client][Stream] json Marshalling error")
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), streamTimeout)
+	defer cancel()
+	id, err := c.RedisClient.AppendUpdate(ctx, c.DocumentID, payload,
+		config.RedisConfig.UpdatesStreamMaxLen, config.RedisConfig.UpdatesStreamTTL)
+	if err != nil {
+		metrics.StreamFailures.Add(1)
+		fmt.Printf("[Client][Stream] Error keeping update of document %s: %v\n", c.DocumentID, err)
+		return ""
+	}
+	return id
+}
+
+// Replay writes the updates kept in the document's stream after the one
+// with ID since straight to the connection, for a client that reconnected
+// to catch up on what it missed. Like WriteSnapshot, call it after
+// registering the client and before starting its Writer: updates broadcast
+// meanwhile follow the replayed ones, some of them twice. It returns
+// redis.ErrTrimmed when since is no longer in the stream.
+func (c *Client) Replay(ctx context.Context, since string) error {
+	entries, err := c.RedisClient.UpdatesSince(ctx, c.DocumentID, since)
+	if err != nil {
+		return err
+	}
+	return c.writeUpdates(entries)
+}
+
+// CatchUp writes the updates kept in the document's stream that a snapshot
+// does not have straight to the connection. Call it right after
+// WriteSnapshot, with the seqs of the latest updates the snapshot has, in
+// the order they were applied: updates made before the client registered
+// that the snapshot has not caught up with are then sent before the ones
+// broadcast since. Updates are applied in Kafka's order, not the stream's,
+// so those are the updates after the oldest of applied that are not among
+// them, not simply the ones after the last. Without applied, the snapshot
+// may have any of the stream's updates and none are sent.
+func (c *Client) CatchUp(ctx context.Context, applied []string) error {
+	if len(applied) == 0 {
+		return nil
+	}
+	oldest := applied[0]
+	has := make(map[string]bool, len(applied))
+	for _, seq := range applied {
+		has[seq] = true
+		if redis.StreamIDBefore(seq, oldest) {
+			oldest = seq
+		}
+	}
+
+	entries, err := c.RedisClient.UpdatesAfter(ctx, c.DocumentID, oldest)
+	if err != nil {
+		return err
+	}
+	missing := entries[:0]
+	for _, entry := range entries {
+		if !has[entry.ID] {
+			missing = append(missing, entry)
+		}
+	}
+	return c.writeUpdates(missing)
+}
+
+// writeUpdates writes the client's tenant's updates among entries to the
+// connection, with their seq.
+func (c *Client) writeUpdates(entries []redis.StreamEntry) error {
+	c.Conn.SetWriteDeadline(time.Now().Add(config.WebSocketConfig.WriteWait))
+	for _, entry := range entries {
+		var message types.Message
+		if err := json.Unmarshal(entry.Payload, &message); err != nil {
+			fmt.Printf("[Client][Replay] Skipping malformed update %s of document %s\n", entry.ID, c.DocumentID)
+			continue
+		}
+		// Documents of different tenants may share an ID, and hence a stream
+		if tenant.Normalize(message.TenantID) != tenant.Normalize(c.TenantID) {
+			continue
+		}
+		message.Seq = entry.ID
+
+		data, err := json.Marshal(message)
+		if err != nil {
+			return fmt.Errorf("failed to serialize message: %w", err)
+		}
+		if err := c.Conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
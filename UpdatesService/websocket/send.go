@@ -0,0 +1,65 @@
+package websocket
+
+import (
+	"UpdatesService/config"
+	"UpdatesService/metrics"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// enqueue hands a message to the client's writer without ever blocking, so
+// one client that does not keep up cannot stall the pool and the rest of its
+// room. When its send buffer is full, the slow consumer policy applies; it
+// reports whether the message was queued.
+func (c *Client) enqueue(message []byte) bool {
+	if c.slow.Load() {
+		return false
+	}
+
+	select {
+	case c.Send <- message:
+		return true
+	default:
+	}
+
+	metrics.SlowConsumers.Add(1)
+	if config.WebSocketConfig.SlowConsumerPolicy == config.SlowConsumerClose {
+		fmt.Printf("[Client] Disconnecting slow user %s on document %s\n", c.UserID, c.DocumentID)
+		c.disconnectSlow()
+		return false
+	}
+
+	// Make room by dropping the oldest message, and have the writer tell the
+	// client it missed something
+	select {
+	case <-c.Send:
+	default:
+	}
+	c.resync.Store(true)
+	select {
+	case c.Send <- message:
+		return true
+	default:
+		// The writer may not have drained while the read loop queued
+		return false
+	}
+}
+
+// disconnectSlow closes the connection of a client that fell behind. Its read
+// loop then fails and unregisters it. The close frame is written from a
+// goroutine of its own: it waits for the writer, which may be stuck on a dead
+// peer for up to WriteWait, and the pool and the producer must not.
+func (c *Client) disconnectSlow() {
+	if !c.slow.CompareAndSwap(false, true) || c.Conn == nil {
+		return
+	}
+	deadline := time.Now().Add(config.WebSocketConfig.WriteWait)
+	go func() {
+		c.Conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Too slow to keep up with the document"),
+			deadline)
+		c.Conn.Close()
+	}()
+}
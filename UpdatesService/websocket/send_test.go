@@ -0,0 +1,180 @@
+package websocket
+
+import (
+	"UpdatesService/config"
+	"UpdatesService/types"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// joinBuffered registers a client of doc-1 of acme whose writer never
+// drains more than buffer messages.
+func joinBuffered(pool *Pool, userID string, buffer int) *Client {
+	client := &Client{
+		UserID:     userID,
+		Username:   userID,
+		TenantID:   "acme",
+		DocumentID: "doc-1",
+		Pool:       pool,
+		Send:       make(chan []byte, buffer),
+	}
+	pool.Register <- client
+	return client
+}
+
+// broadcastAll sends n messages from sender to its room, failing the test if
+// the pool does not take them all within wait.
+func broadcastAll(t *testing.T, pool *Pool, sender *Client, n int, wait time.Duration) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			pool.RoomBroadcast <- RoomMessage{Sender: sender, Message: types.Message{
+				DocumentID: "doc-1", TenantID: "acme", UserID: sender.UserID,
+				Body: fmt.Sprintf(`{"action":"add_slide","slideId":"s-%d"}`, i),
+			}}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(wait):
+		t.Fatalf("room stalled: %d messages not taken within %v", n, wait)
+	}
+}
+
+func withSlowConsumerPolicy(t *testing.T, policy string) {
+	t.Helper()
+	saved := config.WebSocketConfig
+	config.WebSocketConfig.SlowConsumerPolicy = policy
+	t.Cleanup(func() { config.WebSocketConfig = saved })
+}
+
+func TestSlowConsumerDoesNotStallRoom(t *testing.T) {
+	withSlowConsumerPolicy(t, config.SlowConsumerDropOldest)
+	pool := startPool(t)
+	sender := join(pool, "acme", "doc-1", "alice")
+	slow := joinBuffered(pool, "snail", 4)
+	fast := joinBuffered(pool, "bob", 256)
+
+	const n = 100
+	broadcastAll(t, pool, sender, n, 2*time.Second)
+
+	if got := received(fast, 200*time.Millisecond); len(got) != n {
+		t.Errorf("peer got %d of %d messages", len(got), n)
+	}
+	if !slow.resync.Load() {
+		t.Error("slow consumer not told to resync")
+	}
+	// It keeps the newest messages
+	got := received(slow, 50*time.Millisecond)
+	if len(got) == 0 || got[len(got)-1].Body != fmt.Sprintf(`{"action":"add_slide","slideId":"s-%d"}`, n-1) {
+		t.Errorf("slow consumer kept %v, want the newest messages", got)
+	}
+}
+
+func TestSlowConsumerIsDisconnectedUnderClosePolicy(t *testing.T) {
+	withSlowConsumerPolicy(t, config.SlowConsumerClose)
+	pool := startPool(t)
+	sender := join(pool, "acme", "doc-1", "alice")
+	slow := joinBuffered(pool, "snail", 4)
+	fast := joinBuffered(pool, "bob", 256)
+
+	const n = 100
+	broadcastAll(t, pool, sender, n, 2*time.Second)
+
+	if got := received(fast, 200*time.Millisecond); len(got) != n {
+		t.Errorf("peer got %d of %d messages", len(got), n)
+	}
+	if !slow.slow.Load() {
+		t.Error("slow consumer not disconnected")
+	}
+	if slow.enqueue([]byte(`{}`)) {
+		t.Error("message queued for a disconnected slow consumer")
+	}
+}
+
+// TestBlockedConnectionDoesNotStallRoom checks that disconnecting a client
+// whose writer is stuck on a peer that stopped reading, under the close
+// policy, does not hold up the room while the close frame waits for it.
+func TestBlockedConnectionDoesNotStallRoom(t *testing.T) {
+	withSlowConsumerPolicy(t, config.SlowConsumerClose)
+	config.WebSocketConfig.WriteWait = 3 * time.Second
+	pool := startPool(t)
+	sender := join(pool, "acme", "doc-1", "alice")
+
+	clients := make(chan *Client, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := &Client{
+			UserID:     "snail",
+			Username:   "snail",
+			TenantID:   "acme",
+			DocumentID: "doc-1",
+			Conn:       conn,
+			Pool:       pool,
+			Send:       make(chan []byte, 4),
+		}
+		pool.Register <- client
+		clients <- client
+		go client.Writer()
+		client.Read()
+	}))
+	defer server.Close()
+	// A peer that never reads
+	dial(t, "ws"+strings.TrimPrefix(server.URL, "http"), "snail")
+	snail := <-clients
+
+	body := fmt.Sprintf(`{"action":"add_slide","slideId":"%s"}`, strings.Repeat("s", 256<<10))
+	broadcast := func() {
+		pool.RoomBroadcast <- RoomMessage{Sender: sender, Message: types.Message{
+			DocumentID: "doc-1", TenantID: "acme", UserID: sender.UserID, Body: body,
+		}}
+	}
+	// Fill the socket buffers one message at a time, until the writer is
+	// stuck writing
+	for blocked := false; !blocked; {
+		broadcast()
+		drained := time.After(200 * time.Millisecond)
+		for waiting := true; waiting; {
+			select {
+			case <-drained:
+				blocked, waiting = true, false
+			case <-time.After(time.Millisecond):
+				waiting = len(snail.Send) > 0
+			}
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			broadcast()
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("room stalled behind a blocked connection")
+	}
+	if !snail.slow.Load() {
+		t.Error("blocked client not disconnected")
+	}
+
+	// Its connection is closed once the close frame gave up
+	start := time.Now()
+	for connected(pool, "snail") {
+		if time.Since(start) > config.WebSocketConfig.WriteWait+2*time.Second {
+			t.Fatal("blocked client still registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,65 @@
+package websocket
+
+import (
+	"UpdatesService/config"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Accepting reports whether the pool takes new clients, i.e. the service is
+// not shutting down.
+func (pool *Pool) Accepting() bool {
+	return !pool.closing.Load()
+}
+
+// StopAccepting makes the service refuse new connections, the first step of
+// a shutdown.
+func (pool *Pool) StopAccepting() {
+	pool.closing.Store(true)
+}
+
+// Shutdown stops accepting clients, sends every connected one a going-away
+// close frame, waits for them to go until ctx is done, and stops the pool
+// loop. Clients reconnect to another instance.
+func (pool *Pool) Shutdown(ctx context.Context) {
+	pool.StopAccepting()
+
+	select {
+	case pool.goingAway <- struct{}{}:
+	case <-ctx.Done():
+	}
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for pool.clients.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			fmt.Printf("[Pool][Shutdown] %d clients still connected at the deadline\n", pool.clients.Load())
+			close(pool.quit)
+			return
+		}
+	}
+	close(pool.quit)
+}
+
+// closeAll sends every client a going-away close frame. Their read loops end
+// on the client's reply, or when the connection drops, and unregister them.
+func (pool *Pool) closeAll() {
+	deadline := time.Now().Add(config.WebSocketConfig.WriteWait)
+	message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "Server is shutting down")
+	for _, room := range pool.rooms {
+		for client := range room {
+			if client.Conn == nil {
+				continue
+			}
+			if err := client.Conn.WriteControl(websocket.CloseMessage, message, deadline); err != nil {
+				// Nothing to wait for on a broken connection
+				client.Conn.Close()
+			}
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestShutdownIsBoundedByItsContext(t *testing.T) {
+	pool := NewPool(nil)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		pool.Start()
+	}()
+
+	// A client without a connection is never told to go, so never leaves
+	join(pool, "acme", "doc-1", "alice")
+	for len(pool.Participants("acme", "doc-1")) == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	pool.Shutdown(ctx)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown took %s past its 100ms deadline", elapsed)
+	}
+	if pool.Accepting() {
+		t.Error("pool still accepting clients")
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("pool loop still running after Shutdown")
+	}
+}
@@ -0,0 +1,33 @@
+package websocket
+
+import (
+	"UpdatesService/config"
+	"UpdatesService/types"
+	"time"
+)
+
+// WriteSnapshot writes the snapshot frame straight to the connection, ahead
+// of anything waiting in Send. Call it after registering the client and
+// before starting its Writer, which it is not safe alongside: the updates
+// broadcast to the room meanwhile wait in Send, and reach the client after
+// the snapshot they apply to.
+//
+// The snapshot may lag behind the updates made before the client registered,
+// which it gets with CatchUp next. Updates already in the snapshot may so
+// reach the client again; applying one twice leaves the content as it was,
+// since each sets the object or slide it names.
+func (c *Client) WriteSnapshot(frame types.SnapshotFrame) error {
+	frame.Type = "snapshot"
+	return c.writeDirect(frame)
+}
+
+// WriteResync tells the client, like WriteSnapshot ahead of the Writer, that
+// it missed updates for the reason given.
+func (c *Client) WriteResync(reason string) error {
+	return c.writeDirect(types.ResyncFrame{Type: "resync", Reason: reason})
+}
+
+func (c *Client) writeDirect(frame interface{}) error {
+	c.Conn.SetWriteDeadline(time.Now().Add(config.WebSocketConfig.WriteWait))
+	return c.Conn.WriteJSON(frame)
+}
@@ -1,20 +1,45 @@
 package websocket
 
 import (
+	"UpdatesService/config"
 	"log"
 	"net/http"
 
 	"github.com/gorilla/websocket"
 )
 
+// TokenProtocol is the subprotocol browsers, which cannot set the
+// Authorization header of the handshake, pass their token with: they offer
+// it followed by the token, as in new WebSocket(url, ["bearer", token]).
+// Only TokenProtocol is echoed back as the selected subprotocol, never the
+// token.
+const TokenProtocol = "bearer"
+
+// Browsers do not apply CORS to websockets, so the handshake's Origin is
+// checked against the same allowed origins here
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin:     func(r *http.Request) bool { return true },
+	CheckOrigin:     config.CORSConfig.CheckOrigin,
+	Subprotocols:    []string{TokenProtocol},
+}
+
+// SubprotocolToken returns the token offered after TokenProtocol in the
+// handshake's subprotocols, or "".
+func SubprotocolToken(r *http.Request) string {
+	protocols := websocket.Subprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == TokenProtocol && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+	return ""
 }
 
-func Upgrade(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+// Upgrade upgrades the connection, adding responseHeader, which may be nil,
+// to the handshake's response.
+func Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*websocket.Conn, error) {
+	conn, err := upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		log.Println(err)
 		return conn, err